@@ -14,6 +14,11 @@ class SyncApiClient {
   String? _refreshToken;
   DateTime? _tokenExpiresAt;
 
+  /// Called after every token refresh. The server rotates refresh tokens
+  /// and treats reuse of an old one as theft, so the new tokens must be
+  /// persisted.
+  void Function(String accessToken, String refreshToken)? onTokensRefreshed;
+
   /// Set authentication tokens.
   void setTokens({
     required String accessToken,
@@ -145,7 +150,7 @@ class SyncApiClient {
   }
 
   /// Complete login with recovery code.
-  Future<Map<String, dynamic>> validateRecovery({
+  Future<LoginResponse> validateRecovery({
     required String tempToken,
     required String code,
   }) async {
@@ -157,7 +162,14 @@ class SyncApiClient {
         'code': code,
       }),
     );
-    return _handleResponse(response);
+    final body = await _handleResponse(response);
+    final loginResponse = LoginResponse.fromJson(body);
+    setTokens(
+      accessToken: loginResponse.accessToken,
+      refreshToken: loginResponse.refreshToken,
+      expiresIn: loginResponse.expiresIn,
+    );
+    return loginResponse;
   }
 
   /// Refresh the access token.
@@ -176,8 +188,10 @@ class SyncApiClient {
     final body = await _handleResponse(response);
 
     _accessToken = body['access_token'] as String;
+    _refreshToken = body['refresh_token'] as String? ?? _refreshToken;
     final expiresIn = body['expires_in'] as int;
     _tokenExpiresAt = DateTime.now().add(Duration(seconds: expiresIn - 60));
+    onTokensRefreshed?.call(_accessToken!, _refreshToken!);
   }
 
   /// Logout (revoke refresh token).
@@ -68,7 +68,10 @@ class AuthService {
     required SyncApiClient apiClient,
     FlutterSecureStorage? secureStorage,
   })  : _api = apiClient,
-        _storage = secureStorage ?? const FlutterSecureStorage();
+        _storage = secureStorage ?? const FlutterSecureStorage() {
+    _api.onTokensRefreshed = (accessToken, refreshToken) =>
+        unawaited(_storeRefreshedTokens(accessToken, refreshToken));
+  }
 
   final SyncApiClient _api;
   final FlutterSecureStorage _storage;
@@ -126,12 +129,6 @@ class AuthService {
           state: AuthState.authenticated,
           deviceId: device.id.isNotEmpty ? device.id : storedDeviceId,
         ));
-
-        // Save refreshed token
-        await _storage.write(
-          key: _accessTokenKey,
-          value: _api._accessToken,
-        );
       } on SyncException catch (e) {
         // Session expired or invalid
         await _clearStoredTokens();
@@ -235,13 +232,14 @@ class AuthService {
       throw SyncException('No recovery session active');
     }
 
+    _updateStatus(_status.copyWith(state: AuthState.authenticating));
+
     try {
-      await _api.validateRecovery(
+      final result = await _api.validateRecovery(
         tempToken: _status.tempToken!,
         code: code,
       );
-      // Recovery code accepted - user needs to login again
-      _updateStatus(AuthStatus.unauthenticated);
+      await _handleLoginSuccess(result);
     } on SyncException catch (e) {
       _updateStatus(AuthStatus(
         state: AuthState.totpRequired,
@@ -266,6 +264,12 @@ class AuthService {
     ));
   }
 
+  Future<void> _storeRefreshedTokens(
+      String accessToken, String refreshToken) async {
+    await _storage.write(key: _refreshTokenKey, value: refreshToken);
+    await _storage.write(key: _accessTokenKey, value: accessToken);
+  }
+
   /// Logout from the current device.
   Future<void> logout() async {
     try {
@@ -362,12 +366,6 @@ class AuthService {
         state: AuthState.authenticated,
         deviceId: device.id.isNotEmpty ? device.id : storedDeviceId,
       ));
-
-      // Save refreshed token
-      await _storage.write(
-        key: _accessTokenKey,
-        value: _api._accessToken,
-      );
     } on SyncException catch (e) {
       if (e.isPendingApproval) {
         _updateStatus(const AuthStatus(state: AuthState.pendingApproval));
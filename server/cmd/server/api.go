@@ -0,0 +1,216 @@
+package main
+
+import (
+	"expvar"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/handlers"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// apiRoutes mounts the health checks and the JSON API
+type apiRoutes struct {
+	healthHandler            *handlers.HealthHandler
+	jwksHandler              *handlers.JWKSHandler
+	bootstrapHandler         *handlers.BootstrapHandler
+	capabilitiesHandler      *handlers.CapabilitiesHandler
+	authHandler              *handlers.AuthHandler
+	emailVerificationHandler *handlers.EmailVerificationHandler
+	totpHandler              *handlers.TOTPHandler
+	sessionHandler           *handlers.SessionHandler
+	trustedDeviceHandler     *handlers.TrustedDeviceHandler
+	authEventHandler         *handlers.AuthEventHandler
+	apiKeyHandler            *handlers.APIKeyHandler
+	webAuthnHandler          *handlers.WebAuthnHandler
+	vaultHandler             *handlers.VaultHandler
+	settingsBlobHandler      *handlers.SettingsBlobHandler
+	deviceHandler            *handlers.DeviceHandler
+	adminHandler             *handlers.AdminHandler
+	streamsHandler           *handlers.StreamsHandler
+	vaultTransferHandler     *handlers.VaultTransferHandler
+	inviteHandler            *handlers.InviteHandler
+	eventsHandler            *handlers.EventsHandler
+	outboxHandler            *handlers.OutboxHandler
+	maintenanceHandler       *handlers.MaintenanceHandler
+
+	apiKeys      *service.APIKeys
+	appVersions  models.AppVersionPolicy
+	jwtSecret    string
+	routesPublic bool
+
+	generalLimiter        *middleware.RateLimiter
+	pushLimiter           *middleware.RateLimiter
+	pullLimiter           *middleware.RateLimiter
+	forceOverwriteLimiter *middleware.RateLimiter
+}
+
+// register mounts the routes on r and returns the table annotating them.
+// loginLimit guards the login endpoints.
+func (a apiRoutes) register(r *gin.Engine, loginLimit gin.HandlerFunc) *middleware.RouteTable {
+	// Health checks. /health is kept as an alias of liveness.
+	r.GET("/health", a.healthHandler.Live)
+	r.GET("/health/live", a.healthHandler.Live)
+	r.GET("/health/ready", a.healthHandler.Ready)
+
+	// Public key of asymmetrically signed access tokens
+	r.GET("/.well-known/jwks.json", a.jwksHandler.Get)
+
+	// API v1
+	routeTable := middleware.APIRouteTable(a.routesPublic)
+	v1 := r.Group("/api/v1")
+	v1.Use(a.generalLimiter.Middleware())
+	v1.Use(middleware.RequireJSON("/api/v1/vault/blob"))
+	v1.Use(middleware.AppVersion(a.appVersions))
+	{
+		// Public routes
+		v1.POST("/bootstrap", a.bootstrapHandler.Bootstrap)
+		v1.GET("/capabilities", a.capabilitiesHandler.Get)
+
+		auth := v1.Group("/auth")
+		{
+			auth.GET("/requirements", a.capabilitiesHandler.Requirements)
+			auth.POST("/register", a.authHandler.Register)
+			auth.GET("/verify-email", a.emailVerificationHandler.VerifyEmail)
+			auth.POST("/verify-email/resend", loginLimit, a.emailVerificationHandler.ResendVerification)
+			auth.POST("/login", loginLimit, a.authHandler.Login)
+			auth.POST("/login/totp", loginLimit, a.authHandler.ValidateTOTP)
+			auth.POST("/login/totp/extend", loginLimit, a.authHandler.ExtendTempToken)
+			auth.POST("/login/recovery", loginLimit, a.totpHandler.ValidateRecovery)
+			auth.POST("/refresh", a.authHandler.Refresh)
+			auth.POST("/logout", a.authHandler.Logout)
+		}
+
+		// WebAuthn as second factor of a login
+		v1.POST("/webauthn/login/begin", loginLimit, a.authHandler.BeginWebAuthnLogin)
+		v1.POST("/webauthn/login/finish", loginLimit, a.authHandler.FinishWebAuthnLogin)
+
+		// Protected routes
+		protected := v1.Group("")
+		protected.Use(middleware.APIKeyMiddleware(a.apiKeys, service.APIKeyPrefix))
+		protected.Use(middleware.JWTMiddleware(a.jwtSecret))
+		protected.Use(middleware.ScopeMiddleware(routeTable))
+		{
+			// User profile
+			protected.POST("/auth/logout-all", a.authHandler.LogoutAll)
+			protected.GET("/auth/sessions", a.sessionHandler.List)
+			protected.DELETE("/auth/sessions/:id", a.sessionHandler.Revoke)
+			protected.GET("/auth/trusted-devices", a.trustedDeviceHandler.List)
+			protected.DELETE("/auth/trusted-devices/:id", a.trustedDeviceHandler.Revoke)
+			protected.GET("/auth/events", a.authEventHandler.List)
+
+			// API keys for headless clients
+			protected.GET("/apikeys", a.apiKeyHandler.List)
+			protected.POST("/apikeys", a.apiKeyHandler.Create)
+			protected.DELETE("/apikeys/:id", a.apiKeyHandler.Revoke)
+
+			// TOTP management
+			totp := protected.Group("/totp")
+			{
+				totp.POST("/setup", a.totpHandler.Setup)
+				totp.POST("/verify", a.totpHandler.Verify)
+				totp.POST("/disable", a.totpHandler.Disable)
+				totp.POST("/recovery-codes", a.totpHandler.RegenerateRecoveryCodes)
+			}
+
+			// Passkey and security key management
+			webAuthnRoutes := protected.Group("/webauthn")
+			{
+				webAuthnRoutes.POST("/register/begin", a.webAuthnHandler.BeginRegistration)
+				webAuthnRoutes.POST("/register/finish", a.webAuthnHandler.FinishRegistration)
+				webAuthnRoutes.GET("/credentials", a.webAuthnHandler.List)
+				webAuthnRoutes.DELETE("/credentials/:id", a.webAuthnHandler.Delete)
+			}
+
+			// Vault sync
+			pushLimit := a.pushLimiter.PerUser(a.vaultHandler.RecordThrottle)
+			pullLimit := a.pullLimiter.PerUser(a.vaultHandler.RecordThrottle)
+			forceOverwriteLimit := a.forceOverwriteLimiter.PerUser(a.vaultHandler.RecordThrottle)
+			vault := protected.Group("/vault")
+			vault.Use(a.deviceHandler.RequireApproved, a.vaultHandler.LimitBody)
+			{
+				vault.GET("/list", a.vaultHandler.List)
+				vault.GET("/status", a.vaultHandler.Status)
+				vault.GET("/watch", a.vaultHandler.Watch)
+				vault.GET("/pull", pullLimit, a.vaultHandler.Pull)
+				vault.POST("/push", pushLimit, a.vaultHandler.Push)
+				vault.POST("/push/validate", a.vaultHandler.ValidatePush)
+				vault.GET("/blob", pullLimit, a.vaultHandler.PullBlob)
+				vault.PUT("/blob", pushLimit, a.vaultHandler.PushBlob)
+				vault.POST("/force-overwrite", forceOverwriteLimit, a.vaultHandler.ForceOverwrite)
+				vault.POST("/delta/manifest", a.vaultHandler.DeltaManifest)
+				vault.POST("/delta/chunks", pushLimit, a.vaultHandler.DeltaChunks)
+				vault.POST("/delta/commit", pushLimit, a.vaultHandler.DeltaCommit)
+				vault.GET("/history", a.vaultHandler.History)
+				vault.GET("/history/verify", a.vaultHandler.VerifyHistory)
+				vault.GET("/revisions", a.vaultHandler.Revisions)
+				vault.POST("/restore/:revision", a.vaultHandler.Restore)
+				vault.DELETE("", a.vaultHandler.Delete)
+				vault.POST("/undelete", a.vaultHandler.Undelete)
+				vault.GET("/export", a.vaultHandler.Export)
+			}
+			// Imports carry the history of the export besides the blob
+			protected.POST("/vault/import", a.deviceHandler.RequireApproved, forceOverwriteLimit, a.vaultHandler.LimitImportBody, a.vaultHandler.Import)
+
+			// Client settings sidecar, revisioned independently of the vault
+			protected.GET("/settings-blob", a.settingsBlobHandler.Get)
+			protected.PUT("/settings-blob", a.settingsBlobHandler.Put)
+
+			// Device management
+			devices := protected.Group("/devices")
+			{
+				devices.GET("", a.deviceHandler.List)
+				devices.POST("", a.deviceHandler.Register)
+				devices.GET("/current", a.deviceHandler.GetCurrent)
+				devices.PUT("/:id", a.deviceHandler.Update)
+				devices.POST("/:id/approve", a.deviceHandler.Approve)
+				devices.DELETE("/:id", a.deviceHandler.Delete)
+			}
+
+			// Admin routes
+			admin := protected.Group("/admin")
+			admin.Use(middleware.AdminMiddleware())
+			{
+				admin.GET("/dashboard", a.adminHandler.Dashboard)
+				admin.GET("/users", a.adminHandler.ListUsers)
+				admin.POST("/users/bulk", a.adminHandler.BulkUsers)
+				admin.GET("/users/:id", a.adminHandler.GetUser)
+				admin.POST("/users/:id/approve", a.adminHandler.ApproveUser)
+				admin.POST("/users/:id/unapprove", a.adminHandler.UnapproveUser)
+				admin.POST("/users/:id/reject", a.adminHandler.RejectUser)
+				admin.POST("/users/:id/block", a.adminHandler.BlockUser)
+				admin.POST("/users/:id/unblock", a.adminHandler.UnblockUser)
+				admin.POST("/users/:id/role", a.adminHandler.SetUserRole)
+				admin.DELETE("/users/:id", a.adminHandler.DeleteUser)
+				admin.GET("/users/:id/devices", a.adminHandler.GetUserDevices)
+				admin.DELETE("/users/:id/devices/:deviceId", a.adminHandler.DeleteUserDevice)
+				admin.GET("/users/:id/sessions", a.adminHandler.GetUserSessions)
+				admin.DELETE("/users/:id/sessions/:sessionId", a.adminHandler.RevokeUserSession)
+				admin.GET("/users/:id/vault", a.adminHandler.GetUserVault)
+				admin.DELETE("/users/:id/streams", a.streamsHandler.TerminateUser)
+				admin.POST("/users/:id/vault/transfer", a.vaultTransferHandler.Transfer)
+				admin.GET("/audit", a.adminHandler.ListAudit)
+				admin.GET("/audit/auth-events", a.authEventHandler.ListAll)
+				admin.GET("/invites", a.inviteHandler.List)
+				admin.POST("/invites", a.inviteHandler.Create)
+				admin.GET("/events/export", a.eventsHandler.Export)
+				admin.GET("/export/users.csv", a.adminHandler.ExportUsers)
+				admin.GET("/export/sync-logs.csv", a.adminHandler.ExportSyncLogs)
+				admin.GET("/metrics", gin.WrapH(expvar.Handler()))
+				admin.GET("/streams", a.streamsHandler.List)
+				admin.DELETE("/streams/:id", a.streamsHandler.Terminate)
+				admin.GET("/outbox", a.outboxHandler.List)
+				admin.POST("/outbox/:id/retry", a.outboxHandler.Retry)
+				admin.DELETE("/outbox/:id", a.outboxHandler.Drop)
+				admin.POST("/maintenance/prune-devices", a.maintenanceHandler.PruneDevices)
+			}
+		}
+
+		// Route introspection for client debugging
+		handlers.NewRoutesHandler(r, routeTable, a.routesPublic).Register(v1, protected)
+	}
+
+	return routeTable
+}
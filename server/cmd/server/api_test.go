@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+)
+
+const apiTestSecret = "api-test-secret"
+
+// Every route of the API has its own rule in the route table, and the
+// rule matches the middleware the route is registered behind
+func TestAPIRoutes_Annotated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userToken, _ := middleware.GenerateToken(uuid.New(), "u@test.com", uuid.New(), false, apiTestSecret, time.Hour)
+
+	for _, public := range []bool{false, true} {
+		r := gin.New()
+		// The handlers are nil, so reaching one panics
+		const reached = http.StatusTeapot
+		r.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, _ any) {
+			c.AbortWithStatus(reached)
+		}))
+		unlimited := middleware.NewRateLimiter(0)
+		table := apiRoutes{
+			jwtSecret:             apiTestSecret,
+			routesPublic:          public,
+			generalLimiter:        unlimited,
+			pushLimiter:           unlimited,
+			pullLimiter:           unlimited,
+			forceOverwriteLimiter: unlimited,
+		}.register(r, func(*gin.Context) {})
+
+		status := func(method, path, token string) int {
+			var segments []string
+			for _, segment := range strings.Split(path, "/") {
+				if strings.HasPrefix(segment, ":") {
+					segment = "1"
+				}
+				segments = append(segments, segment)
+			}
+			req := httptest.NewRequest(method, strings.Join(segments, "/"), nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		for _, route := range r.Routes() {
+			name := route.Method + " " + route.Path
+			rule, ok := table.Lookup(route.Method, route.Path)
+			if !ok {
+				t.Errorf("%s has no route rule", name)
+				continue
+			}
+			anonymous, user := status(route.Method, route.Path, ""), status(route.Method, route.Path, userToken)
+			switch rule.Auth {
+			case middleware.AuthPublic:
+				if anonymous == http.StatusUnauthorized {
+					t.Errorf("%s is annotated public but requires a login", name)
+				}
+			case middleware.AuthUser:
+				if anonymous != http.StatusUnauthorized || user == http.StatusUnauthorized || user == http.StatusForbidden {
+					t.Errorf("%s is annotated for users: anonymous %d, user %d", name, anonymous, user)
+				}
+			case middleware.AuthAdmin:
+				if anonymous != http.StatusUnauthorized || user != http.StatusForbidden {
+					t.Errorf("%s is annotated for admins: anonymous %d, user %d", name, anonymous, user)
+				}
+			default:
+				t.Errorf("%s has auth %q", name, rule.Auth)
+			}
+		}
+	}
+}
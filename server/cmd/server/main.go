@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,153 +15,348 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/crypto/bcrypt"
 
 	"github.com/sprobst76/vibedterm-server/internal/config"
 	"github.com/sprobst76/vibedterm-server/internal/database"
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/geoip"
 	"github.com/sprobst76/vibedterm-server/internal/handlers"
+	"github.com/sprobst76/vibedterm-server/internal/input"
 	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/recoverycode"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
 	"github.com/sprobst76/vibedterm-server/internal/web"
 )
 
+// outboxSize bounds the notifications awaiting delivery
+const outboxSize = 256
+
+// Build identification, set with
+// -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = ""
+)
+
 func main() {
 	// Setup logging
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
+	opts, err := parseStartArgs(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
 	// Load configuration
 	cfg := config.Load()
-	log.Info().Str("addr", cfg.ServerAddr).Msg("Starting VibedTerm server")
-
-	// Connect to database
-	if err := database.Connect(cfg.DatabaseURL); err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to database")
+	log.Info().Str("addr", cfg.ServerAddr).Str("version", version).Str("commit", commit).Msg("Starting VibedTerm server")
+	if err := password.Configure(cfg.PasswordHashConcurrency, cfg.PasswordHashQueueTimeout, passwordParams(cfg)); err != nil {
+		log.Fatal().Err(err).Msg("Invalid password hashing configuration")
+	}
+	if err := totpsecret.Configure(cfg.TOTPPeriod, cfg.TOTPSkew); err != nil {
+		log.Fatal().Err(err).Msg("Invalid TOTP configuration")
+	}
+	recoveryKey := cfg.RecoveryCodeKey
+	if recoveryKey == "" {
+		log.Warn().Msg("RECOVERY_CODE_KEY not set, keying recovery codes with JWT_SECRET; rotating it invalidates them")
+		recoveryKey = cfg.JWTSecret
 	}
-	defer database.Close()
+	if err := recoverycode.Configure([]byte(recoveryKey)); err != nil {
+		log.Fatal().Err(err).Msg("Invalid recovery code configuration")
+	}
+	inputLimits, err := input.ParseLimits(cfg.InputMaxLengths)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid INPUT_MAX_LENGTHS")
+	}
+	input.Configure(inputLimits)
+	input.InstallBinding()
+	signingKeys, err := middleware.LoadSigningKeys(cfg.JWTPrivateKeyFile, cfg.JWTPublicKeyFile, cfg.JWTAcceptHS256)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid JWT signing keys")
+	}
+	middleware.ConfigureSigningKeys(signingKeys)
+	middleware.ConfigureSecrets(cfg.JWTSecrets)
+	if err := middleware.ConfigureTokenValidation(cfg.JWTAlgorithms, cfg.JWTMaxLifetime); err != nil {
+		log.Fatal().Err(err).Msg("Invalid JWT validation configuration")
+	}
+	if cfg.AccessTokenDuration > cfg.JWTMaxLifetime || cfg.TOTPTempTokenDuration > cfg.JWTMaxLifetime {
+		log.Fatal().Msg("JWT_ACCESS_DURATION and TOTP_TEMP_TOKEN_DURATION must not exceed JWT_MAX_LIFETIME")
+	}
+
+	code := start(context.Background(), opts, startSteps{
+		connect: connectDatabase(cfg.DatabaseURL),
+		migrate: database.RunMigrations,
+		serve:   func() { serve(cfg) },
+	})
+	database.Close()
+	os.Exit(code)
+}
 
-	// Run migrations
+// serve wires up the application and runs the HTTP server until SIGINT or SIGTERM
+func serve(cfg *config.Config) {
 	ctx := context.Background()
-	if err := database.RunMigrations(ctx); err != nil {
-		log.Fatal().Err(err).Msg("Failed to run migrations")
-	}
 
 	// Create repositories
 	userRepo := repository.NewUserRepository(database.DB)
-	deviceRepo := repository.NewDeviceRepository(database.DB)
+	deviceRepo := repository.NewDeviceRepository(database.DB, cfg.DeviceRecordIP)
 	refreshRepo := repository.NewRefreshTokenRepository(database.DB)
 	recoveryRepo := repository.NewRecoveryCodeRepository(database.DB)
-	vaultRepo := repository.NewVaultRepository(database.DB)
+	vaultRepo := repository.NewVaultRepository(database.DB, cfg.VaultHistoryLimit, cfg.VaultMaxPerUser)
+	clientSettingsRepo := repository.NewClientSettingsRepository(database.DB)
 	syncLogRepo := repository.NewSyncLogRepository(database.DB)
+	pushReceiptRepo := repository.NewPushReceiptRepository(database.DB)
+	vaultChunkRepo := repository.NewVaultChunkRepository(database.DB, int64(cfg.VaultDeltaMaxPendingBytes))
+	tempTokenRepo := repository.NewTempTokenRepository(database.DB)
+	bootstrapTokenRepo := repository.NewBootstrapTokenRepository(database.DB)
+	settingRepo := repository.NewSettingRepository(database.DB)
+	apiKeyRepo := repository.NewAPIKeyRepository(database.DB)
+	auditRepo := repository.NewAuditRepository(database.DB)
+	domainEventRepo := repository.NewDomainEventRepository(database.DB)
+	authEventRepo := repository.NewAuthEventRepository(database.DB)
+	emailVerificationRepo := repository.NewEmailVerificationRepository(database.DB)
+	inviteRepo := repository.NewInviteRepository(database.DB)
+	webAuthnRepo := repository.NewWebAuthnRepository(database.DB)
+	trustedDeviceRepo := repository.NewTrustedDeviceRepository(database.DB)
+
+	// Background jobs
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	// Domain event log, mirrored into the audit log writers and the
+	// account activity log
+	eventLog := events.NewLog(domainEventRepo, openSyslogForwarder(jobCtx, cfg), authEventRepo)
+	auditLog := events.NewAuditRecorder(auditRepo, eventLog)
+
+	// Create services
+	streamHub := stream.NewHub(cfg.StreamMaxPerUser, cfg.StreamMaxGlobal)
+	streamHub.Publish("streams")
+	vaultChanges := stream.NewBroker()
+	notifier := notify.NewLogNotifier(openGeoIP(cfg))
+	// Queued so requests don't wait for delivery
+	outbox := notify.NewOutbox(notifier, outboxSize)
+	emailVerification := service.NewEmailVerification(emailVerificationRepo, userRepo, notifier, cfg.PublicURL, cfg.EmailVerificationTTL)
+	invites := service.NewInvites(inviteRepo, auditLog, cfg.RegistrationInviteTTL)
+	registrationPolicy := service.RegistrationPolicy{
+		Mode:               cfg.RegistrationMode,
+		AllowedDomains:     cfg.RegistrationAllowedDomains,
+		RequireDisplayName: cfg.RequireDisplayName,
+		ConcealExisting:    cfg.ConcealExistingAccounts,
+	}
+	if err := registrationPolicy.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid registration configuration")
+	}
+	approvalQueue := service.NewApprovalQueue(userRepo, outbox, cfg.PendingDigestInterval, cfg.PendingAutoRejectDays, cfg.PendingNotifyAdmins)
+	registration := service.NewRegistration(userRepo, emailVerification, invites, settingRepo, notifier, eventLog, approvalQueue, registrationPolicy)
+	userAdmin := service.NewUserAdmin(userRepo, deviceRepo, vaultRepo, refreshRepo, syncLogRepo, auditLog)
+	tokenRefresh := service.NewTokenRefresh(refreshRepo, userRepo, deviceRepo, eventLog)
+	inactivityPolicy := service.InactivityPolicy{
+		WarnAfterMonths: cfg.InactivityWarnAfterMonths,
+		ActAfterMonths:  cfg.InactivityActAfterMonths,
+		Action:          cfg.InactivityAction,
+		IncludeVaults:   cfg.InactivityIncludeVaults,
+		MaxPerRun:       cfg.InactivityMaxPerRun,
+		DryRun:          cfg.InactivityDryRun,
+	}
+	if err := inactivityPolicy.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid inactivity cleanup configuration")
+	}
+	inactivityCleanup := service.NewInactivityCleanup(userRepo, settingRepo, refreshRepo, auditLog, notifier, inactivityPolicy)
+	vaultMigration := service.NewVaultMigration(settingRepo, vaultRepo)
+	vaultSync := service.NewVaultSync(vaultRepo, syncLogRepo, deviceRepo, vaultMigration, cfg.VaultMaxVersion)
+	vaultTrash := service.NewVaultTrash(vaultRepo, syncLogRepo, cfg.VaultDeleteGrace)
+	pushReceipts := service.NewPushReceipts(pushReceiptRepo)
+	vaultDelta := service.NewVaultDelta(vaultChunkRepo, vaultRepo)
+	deviceCleanup := service.NewDeviceCleanup(deviceRepo, refreshRepo, userRepo, cfg.DeviceInactiveAfter, cfg.DevicePruneAllAccounts)
+	maintenance := service.NewMaintenance(syncLogRepo, refreshRepo, cfg.SyncLogRetention, cfg.TokenCleanupInterval)
+	clientSettingsSync := service.NewClientSettingsSync(clientSettingsRepo)
+	vaultTransfer := service.NewVaultTransfer(userRepo, vaultRepo, auditLog, notifier)
+	apiKeys := service.NewAPIKeys(apiKeyRepo, userRepo)
+	deviceTrust := service.NewDeviceTrust(trustedDeviceRepo, cfg.TrustedDeviceDuration)
+	totpGuard := service.NewTOTPGuard(auditLog, cfg.TOTPMaxAttempts, cfg.TOTPAttemptWindow)
+	bootstrap := service.NewBootstrap(bootstrapTokenRepo, userRepo, settingRepo, apiKeys)
+	webAuthn, err := service.NewWebAuthn(service.WebAuthnConfig{
+		RPID:        cfg.WebAuthnRPID,
+		DisplayName: cfg.WebAuthnRPName,
+		Origins:     cfg.WebAuthnRPOrigins,
+	}, webAuthnRepo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid WebAuthn configuration")
+	}
 
 	// Create handlers
-	authHandler := handlers.NewAuthHandler(userRepo, deviceRepo, refreshRepo, cfg)
-	totpHandler := handlers.NewTOTPHandler(userRepo, recoveryRepo, cfg)
-	vaultHandler := handlers.NewVaultHandler(vaultRepo, deviceRepo, syncLogRepo)
-	deviceHandler := handlers.NewDeviceHandler(deviceRepo, refreshRepo)
-	adminHandler := handlers.NewAdminHandler(userRepo, deviceRepo, vaultRepo, refreshRepo)
+	var newDeviceNotifier notify.Notifier
+	if cfg.NotifyNewDevice {
+		newDeviceNotifier = outbox
+	}
+	authHandler := handlers.NewAuthHandler(userRepo, deviceRepo, refreshRepo, tempTokenRepo, registration, tokenRefresh, webAuthn, deviceTrust, totpGuard, newDeviceNotifier, outbox, eventLog, cfg)
+	totpHandler := handlers.NewTOTPHandler(userRepo, recoveryRepo, tempTokenRepo, deviceTrust, totpGuard, authHandler, notifier, eventLog, cfg)
+	trustedDeviceHandler := handlers.NewTrustedDeviceHandler(deviceTrust)
+	webAuthnHandler := handlers.NewWebAuthnHandler(userRepo, webAuthn)
+	vaultHandler := handlers.NewVaultHandler(vaultRepo, deviceRepo, syncLogRepo, vaultSync, vaultTrash, pushReceipts, vaultDelta, clientSettingsSync, vaultMigration, int64(cfg.VaultMaxSizeBytes), streamHub, vaultChanges, cfg.VaultWatchTimeout)
+	settingsBlobHandler := handlers.NewSettingsBlobHandler(clientSettingsSync)
+	deviceHandler := handlers.NewDeviceHandler(deviceRepo, refreshRepo, vaultRepo)
+	sessionHandler := handlers.NewSessionHandler(refreshRepo)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeys)
+	appVersions := models.AppVersionPolicy{MinSupported: cfg.MinSupportedAppVersion, MinRequired: cfg.MinRequiredAppVersion}
+	accountExport := service.NewAccountExport(userRepo, syncLogRepo)
+	adminHandler := handlers.NewAdminHandler(userRepo, deviceRepo, vaultRepo, auditRepo, userAdmin, vaultMigration, streamHub, accountExport, appVersions)
+	streamsHandler := handlers.NewStreamsHandler(streamHub)
+	outboxHandler := handlers.NewOutboxHandler(outbox)
+	maintenanceHandler := handlers.NewMaintenanceHandler(deviceCleanup)
+	bootstrapHandler := handlers.NewBootstrapHandler(bootstrap)
+	vaultTransferHandler := handlers.NewVaultTransferHandler(vaultTransfer)
+	eventsHandler := handlers.NewEventsHandler(domainEventRepo)
+	authEventHandler := handlers.NewAuthEventHandler(authEventRepo)
+	emailVerificationHandler := handlers.NewEmailVerificationHandler(emailVerification)
+	inviteHandler := handlers.NewInviteHandler(invites)
+
+	jwksHandler := handlers.NewJWKSHandler(middleware.CurrentSigningKeys())
+	capabilitiesHandler := handlers.NewCapabilitiesHandler(models.Capabilities{
+		WebUI: models.WebUICapabilities{Admin: cfg.AdminWeb(), User: cfg.UserWeb()},
+	}, registration)
 
 	// Create shared templates and web interfaces
-	templates, err := web.NewTemplates()
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to parse web templates")
+	ui := webUI{admin: cfg.AdminWeb(), user: cfg.UserWeb()}
+	if ui.admin || ui.user {
+		templates, err := web.NewTemplates()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse web templates")
+		}
+		cookies, err := web.NewCookiePolicy(cfg.CookieSecure, cfg.CookieSameSite)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid COOKIE_SECURE or COOKIE_SAMESITE")
+		}
+		web.SetCookiePolicy(cookies)
+		ui.newAdmin = func() *web.AdminWeb {
+			return web.NewAdminWeb(userRepo, deviceRepo, vaultRepo, userAdmin, inactivityCleanup, invites, registration, vaultMigration, outbox, totpGuard, maintenance, eventLog, accountExport, appVersions, templates)
+		}
+		ui.newUser = func() *web.UserWeb {
+			return web.NewUserWeb(userRepo, recoveryRepo, deviceRepo, refreshRepo, registration, emailVerification, webAuthn, deviceTrust, totpGuard, deviceCleanup, eventLog, cfg.PublicURL, cfg.TOTPIssuer, templates)
+		}
+		ui.assets = web.NewSiteAssets(cfg.RobotsDisallow)
+	} else {
+		log.Info().Msg("Web UI disabled, serving the API only")
 	}
-	adminWeb := web.NewAdminWeb(userRepo, deviceRepo, vaultRepo, refreshRepo, templates)
-	userWeb := web.NewUserWeb(userRepo, deviceRepo, templates)
 
 	// Setup Gin
 	gin.SetMode(cfg.ServerMode)
 	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal().Err(err).Msg("Invalid TRUSTED_PROXIES")
+	}
+	middleware.StrictRouting(r)
+	r.Use(middleware.RequestID())
 	r.Use(gin.Recovery())
 	r.Use(ginLogger())
+	r.Use(middleware.EventClient())
 
 	// CORS middleware
 	r.Use(corsMiddleware())
 
-	// Register web interface routes
-	adminWeb.RegisterRoutes(r)
-	userWeb.RegisterRoutes(r)
-
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+	// Request bodies are capped before any handler reads them
+	r.Use(middleware.BodyLimit(int64(cfg.MaxRequestBodyBytes), int64(cfg.MaxVaultRequestBodyBytes), "/api/v1/vault"))
 
-	// API v1
-	v1 := r.Group("/api/v1")
-	{
-		// Public routes
-		auth := v1.Group("/auth")
-		{
-			auth.POST("/register", authHandler.Register)
-			auth.POST("/login", authHandler.Login)
-			auth.POST("/login/totp", authHandler.ValidateTOTP)
-			auth.POST("/login/recovery", totpHandler.ValidateRecovery)
-			auth.POST("/refresh", authHandler.Refresh)
-			auth.POST("/logout", authHandler.Logout)
-		}
+	// Rate limiting per client IP of the API. Login endpoints of the API
+	// and the web interface share a stricter limit.
+	generalLimiter := middleware.NewRateLimiter(cfg.RateLimitGeneral)
+	loginLimiter := middleware.NewRateLimiter(cfg.RateLimitLogin)
+	loginLimit := loginLimiter.Middleware()
+	pushLimiter := middleware.NewRateLimiterPer(cfg.VaultPushPerHour, time.Hour)
+	pullLimiter := middleware.NewRateLimiterPer(cfg.VaultPullPerHour, time.Hour)
+	forceOverwriteLimiter := middleware.NewRateLimiterPer(1, cfg.VaultForceOverwriteInterval)
 
-		// Protected routes
-		protected := v1.Group("")
-		protected.Use(middleware.JWTMiddleware(cfg.JWTSecret))
-		{
-			// User profile
-			protected.POST("/auth/logout-all", authHandler.LogoutAll)
-
-			// TOTP management
-			totp := protected.Group("/totp")
-			{
-				totp.POST("/setup", totpHandler.Setup)
-				totp.POST("/verify", totpHandler.Verify)
-				totp.POST("/disable", totpHandler.Disable)
-				totp.POST("/recovery-codes", totpHandler.RegenerateRecoveryCodes)
-			}
-
-			// Vault sync
-			vault := protected.Group("/vault")
-			{
-				vault.GET("/status", vaultHandler.Status)
-				vault.GET("/pull", vaultHandler.Pull)
-				vault.POST("/push", vaultHandler.Push)
-				vault.POST("/force-overwrite", vaultHandler.ForceOverwrite)
-				vault.GET("/history", vaultHandler.History)
-			}
-
-			// Device management
-			devices := protected.Group("/devices")
-			{
-				devices.GET("", deviceHandler.List)
-				devices.POST("", deviceHandler.Register)
-				devices.GET("/current", deviceHandler.GetCurrent)
-				devices.PUT("/:id", deviceHandler.Rename)
-				devices.DELETE("/:id", deviceHandler.Delete)
-			}
-
-			// Admin routes
-			admin := protected.Group("/admin")
-			admin.Use(middleware.AdminMiddleware())
-			{
-				admin.GET("/dashboard", adminHandler.Dashboard)
-				admin.GET("/users", adminHandler.ListUsers)
-				admin.POST("/users/:id/approve", adminHandler.ApproveUser)
-				admin.POST("/users/:id/block", adminHandler.BlockUser)
-				admin.DELETE("/users/:id", adminHandler.DeleteUser)
-				admin.GET("/users/:id/devices", adminHandler.GetUserDevices)
-			}
-		}
+	// Register web interface routes
+	ui.register(r, loginLimit)
+
+	// Health checks, the JSON API and the routes listing
+	api := apiRoutes{
+		healthHandler:            handlers.NewHealthHandler(database.DB, version, commit),
+		jwksHandler:              jwksHandler,
+		bootstrapHandler:         bootstrapHandler,
+		capabilitiesHandler:      capabilitiesHandler,
+		authHandler:              authHandler,
+		emailVerificationHandler: emailVerificationHandler,
+		totpHandler:              totpHandler,
+		sessionHandler:           sessionHandler,
+		trustedDeviceHandler:     trustedDeviceHandler,
+		authEventHandler:         authEventHandler,
+		apiKeyHandler:            apiKeyHandler,
+		webAuthnHandler:          webAuthnHandler,
+		vaultHandler:             vaultHandler,
+		settingsBlobHandler:      settingsBlobHandler,
+		deviceHandler:            deviceHandler,
+		adminHandler:             adminHandler,
+		streamsHandler:           streamsHandler,
+		vaultTransferHandler:     vaultTransferHandler,
+		inviteHandler:            inviteHandler,
+		eventsHandler:            eventsHandler,
+		outboxHandler:            outboxHandler,
+		maintenanceHandler:       maintenanceHandler,
+		apiKeys:                  apiKeys,
+		appVersions:              appVersions,
+		jwtSecret:                cfg.JWTSecret,
+		routesPublic:             cfg.RoutesPublic,
+		generalLimiter:           generalLimiter,
+		pushLimiter:              pushLimiter,
+		pullLimiter:              pullLimiter,
+		forceOverwriteLimiter:    forceOverwriteLimiter,
 	}
+	api.register(r, loginLimit)
 
 	// Create admin user if configured
 	createAdminUser(ctx, userRepo, cfg)
 
+	// Log a one-time bootstrap token on first boot
+	issueBootstrapToken(ctx, bootstrap)
+
+	// Point out accounts that can no longer pass two-factor login
+	reportBrokenTOTPSecrets(ctx, userRepo)
+
+	// Background jobs
+	go approvalQueue.Run(jobCtx)
+	go outbox.Run(jobCtx)
+	go totpGuard.Run(jobCtx)
+	go inactivityCleanup.Run(jobCtx)
+	go service.NewAuthEventRetention(authEventRepo, cfg.AuthEventRetention).Run(jobCtx)
+	go vaultTrash.Run(jobCtx)
+	go pushReceipts.Run(jobCtx)
+	go vaultDelta.Run(jobCtx)
+	go maintenance.Run(jobCtx)
+	go deviceCleanup.Run(jobCtx)
+	go generalLimiter.Run(jobCtx, time.Minute)
+	go loginLimiter.Run(jobCtx, time.Minute)
+	go pushLimiter.Run(jobCtx, time.Minute)
+	go pullLimiter.Run(jobCtx, time.Minute)
+	go forceOverwriteLimiter.Run(jobCtx, time.Minute)
+
 	// Start server with graceful shutdown
-	srv := &http.Server{
-		Addr:    cfg.ServerAddr,
-		Handler: r,
+	if cfg.ServerWriteTimeout > 0 && cfg.ServerWriteTimeout <= cfg.VaultWatchTimeout {
+		log.Warn().Dur("write_timeout", cfg.ServerWriteTimeout).Dur("watch_timeout", cfg.VaultWatchTimeout).
+			Msg("SERVER_WRITE_TIMEOUT does not outlast VAULT_WATCH_TIMEOUT; long polls will be cut off")
+	}
+	servers, err := newHTTPServers(cfg, middleware.HeadAsGet(r))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid TLS configuration")
+	}
+	if servers.redirect != nil {
+		log.Info().Strs("domains", cfg.TLSAutocertDomains).Str("http_addr", cfg.TLSHTTPAddr).Msg("Serving HTTPS with Let's Encrypt certificates")
+	} else if servers.tls() {
+		log.Info().Str("cert", cfg.TLSCertFile).Msg("Serving HTTPS")
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := servers.listenAndServe(); err != nil {
 			log.Fatal().Err(err).Msg("Failed to start server")
 		}
 	}()
@@ -169,11 +368,14 @@ func main() {
 
 	log.Info().Msg("Shutting down server...")
 
-	// Graceful shutdown with 5 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Close streaming connections first so they don't hold up shutdown
+	streamHub.Shutdown()
+
+	// Graceful shutdown, bounded by SERVER_SHUTDOWN_TIMEOUT
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ServerShutdownTimeout)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := servers.shutdown(ctx); err != nil {
 		log.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
 
@@ -187,7 +389,7 @@ func ginLogger() gin.HandlerFunc {
 
 		c.Next()
 
-		log.Info().
+		middleware.Logger(c).Info().
 			Int("status", c.Writer.Status()).
 			Str("method", c.Request.Method).
 			Str("path", path).
@@ -201,7 +403,8 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 		c.Header("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
@@ -226,26 +429,117 @@ func createAdminUser(ctx context.Context, userRepo *repository.UserRepository, c
 	}
 
 	// Create admin user
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(cfg.AdminPassword), bcrypt.DefaultCost)
+	hashedPassword, err := password.Hash(ctx, cfg.AdminPassword)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to hash admin password")
 		return
 	}
 
-	user, err := userRepo.Create(ctx, cfg.AdminEmail, string(hashedPassword))
+	user, err := userRepo.Create(ctx, cfg.AdminEmail, hashedPassword)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create admin user")
 		return
 	}
 
-	// Approve and set as admin via direct SQL
-	_, err = database.DB.Exec(ctx, `
-		UPDATE users SET is_approved = true, is_admin = true WHERE id = $1
-	`, user.ID)
-	if err != nil {
+	// Approve, verify and set as admin
+	if err := userRepo.MarkEmailVerified(ctx, user.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to verify admin email")
+		return
+	}
+	if err := userRepo.SetApproved(ctx, user.ID, true, nil); err != nil {
+		log.Error().Err(err).Msg("Failed to approve admin user")
+		return
+	}
+	if err := userRepo.SetAdmin(ctx, user.ID, true); err != nil {
 		log.Error().Err(err).Msg("Failed to set admin privileges")
 		return
 	}
 
 	log.Info().Str("email", cfg.AdminEmail).Msg("Admin user created")
 }
+
+// issueBootstrapToken logs a single-use token for creating the first admin
+// account while no users exist. The token is logged deliberately, as a
+// warning, so the operator finds it in the server log.
+func issueBootstrapToken(ctx context.Context, bootstrap *service.Bootstrap) {
+	token, err := bootstrap.IssueToken(ctx)
+	if errors.Is(err, service.ErrBootstrapComplete) {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to issue bootstrap token")
+		return
+	}
+
+	log.Warn().
+		Str("bootstrap_token", token).
+		Dur("valid_for", service.BootstrapTokenLifetime).
+		Msg("No users exist yet. POST the single-use bootstrap token to /api/v1/bootstrap to create the admin account")
+}
+
+// reportBrokenTOTPSecrets logs users whose stored TOTP secret has an
+// implausible size, such as the empty secrets an earlier Setup stored
+// when decoding failed. They need to disable and re-enroll TOTP.
+func reportBrokenTOTPSecrets(ctx context.Context, userRepo *repository.UserRepository) {
+	users, err := userRepo.ListBrokenTOTPSecrets(ctx, models.MinTOTPSecretSize, models.MaxTOTPSecretSize)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check TOTP secrets")
+		return
+	}
+	for _, u := range users {
+		log.Warn().
+			Str("user_id", u.ID.String()).
+			Str("email", u.Email).
+			Int("secret_bytes", u.SecretSize).
+			Msg("TOTP secret has an implausible size, the user must re-enroll")
+	}
+}
+
+// passwordParams builds the hashing parameters from cfg. Out-of-range
+// values become 0 and are rejected by password.Configure.
+func passwordParams(cfg *config.Config) password.Params {
+	argon2Value := func(v, limit int) int {
+		if v < 0 || v > limit {
+			return 0
+		}
+		return v
+	}
+	return password.Params{
+		Algorithm:     cfg.PasswordHashAlgo,
+		BcryptCost:    cfg.PasswordBcryptCost,
+		Argon2Memory:  uint32(argon2Value(cfg.PasswordArgon2Memory, math.MaxUint32)),
+		Argon2Time:    uint32(argon2Value(cfg.PasswordArgon2Time, math.MaxUint32)),
+		Argon2Threads: uint8(argon2Value(cfg.PasswordArgon2Threads, math.MaxUint8)),
+	}
+}
+
+// openGeoIP loads the configured GeoIP database. A missing or unreadable
+// database disables location summaries instead of failing startup.
+func openGeoIP(cfg *config.Config) geoip.Locator {
+	if cfg.GeoIPDBPath == "" {
+		return nil
+	}
+	db, err := geoip.Open(cfg.GeoIPDBPath)
+	if err != nil {
+		log.Warn().Err(err).Str("path", cfg.GeoIPDBPath).Msg("GeoIP database unavailable, location summaries disabled")
+		return nil
+	}
+	log.Info().Str("path", cfg.GeoIPDBPath).Msg("GeoIP database loaded")
+	return db
+}
+
+// openSyslogForwarder starts the configured syslog forwarder. It returns
+// nil if forwarding is disabled or the address is unusable.
+func openSyslogForwarder(ctx context.Context, cfg *config.Config) events.Forwarder {
+	if cfg.EventsSyslogAddr == "" {
+		return nil
+	}
+	forwarder, err := events.NewSyslogForwarder(cfg.EventsSyslogAddr, cfg.EventsSyslogApp)
+	if err != nil {
+		log.Warn().Err(err).Str("addr", cfg.EventsSyslogAddr).Msg("Syslog forwarder unavailable, events are only stored")
+		return nil
+	}
+	go forwarder.Run(ctx)
+	log.Info().Str("addr", cfg.EventsSyslogAddr).Msg("Forwarding domain events to syslog")
+	return forwarder
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/database"
+)
+
+// Exit codes, following sysexits.h so orchestrators can tell failures apart
+const (
+	exitOK              = 0
+	exitUsage           = 64 // EX_USAGE
+	exitDBUnavailable   = 69 // EX_UNAVAILABLE
+	exitMigrationFailed = 70 // EX_SOFTWARE
+	exitConfig          = 78 // EX_CONFIG
+)
+
+// startOptions controls how the server starts
+type startOptions struct {
+	waitForDB   bool
+	waitTimeout time.Duration
+	migrate     bool
+	migrateOnly bool
+}
+
+// parseStartArgs parses the command line. Without arguments the server
+// connects once, migrates and serves, as it always has. The run
+// subcommand makes each step explicit for container setups:
+//
+//	server run [--wait-for-db] [--db-wait-timeout=60s] [--migrate] [--migrate-only]
+func parseStartArgs(args []string, output io.Writer) (startOptions, error) {
+	if len(args) == 0 {
+		return startOptions{migrate: true}, nil
+	}
+	if args[0] != "run" {
+		return startOptions{}, fmt.Errorf("unknown command %q, expected \"run\"", args[0])
+	}
+
+	var opts startOptions
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.BoolVar(&opts.waitForDB, "wait-for-db", false, "retry the database connection until it succeeds or the timeout passes")
+	fs.DurationVar(&opts.waitTimeout, "db-wait-timeout", 60*time.Second, "how long --wait-for-db keeps retrying")
+	fs.BoolVar(&opts.migrate, "migrate", false, "run database migrations before serving")
+	fs.BoolVar(&opts.migrateOnly, "migrate-only", false, "run database migrations and exit")
+	if err := fs.Parse(args[1:]); err != nil {
+		return startOptions{}, err
+	}
+	if fs.NArg() > 0 {
+		return startOptions{}, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return opts, nil
+}
+
+// startSteps are the startup stages, replaceable in tests
+type startSteps struct {
+	connect func(ctx context.Context, opts startOptions) error
+	migrate func(ctx context.Context) error
+	serve   func()
+}
+
+// start runs the startup stages in order and returns the process exit
+// code. Migrations always finish before the HTTP listener opens.
+func start(ctx context.Context, opts startOptions, steps startSteps) int {
+	if err := steps.connect(ctx, opts); err != nil {
+		if errors.Is(err, database.ErrInvalidDatabaseURL) {
+			log.Error().Err(err).Msg("Invalid database configuration")
+			return exitConfig
+		}
+		log.Error().Err(err).Msg("Failed to connect to database")
+		return exitDBUnavailable
+	}
+
+	if opts.migrate || opts.migrateOnly {
+		if err := steps.migrate(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to run migrations")
+			return exitMigrationFailed
+		}
+	}
+	if opts.migrateOnly {
+		log.Info().Msg("Migrations complete, exiting (--migrate-only)")
+		return exitOK
+	}
+
+	steps.serve()
+	return exitOK
+}
+
+// connectDatabase connects once, or keeps retrying with --wait-for-db
+func connectDatabase(databaseURL string) func(context.Context, startOptions) error {
+	return func(ctx context.Context, opts startOptions) error {
+		if opts.waitForDB {
+			return database.ConnectWait(ctx, databaseURL, opts.waitTimeout)
+		}
+		return database.Connect(databaseURL)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sprobst76/vibedterm-server/internal/database"
+)
+
+func TestParseStartArgs(t *testing.T) {
+	opts, err := parseStartArgs(nil, io.Discard)
+	if err != nil || !opts.migrate || opts.waitForDB {
+		t.Errorf("no args: %+v, %v; want legacy connect+migrate", opts, err)
+	}
+
+	opts, err = parseStartArgs([]string{"run", "--wait-for-db", "--db-wait-timeout=5s", "--migrate-only"}, io.Discard)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !opts.waitForDB || opts.waitTimeout != 5*time.Second || !opts.migrateOnly || opts.migrate {
+		t.Errorf("run: %+v", opts)
+	}
+
+	for _, args := range [][]string{{"serve"}, {"run", "--bogus"}, {"run", "extra"}} {
+		if _, err := parseStartArgs(args, io.Discard); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
+
+// recordingSteps returns start steps that record which stages ran
+func recordingSteps(connectErr, migrateErr error) (startSteps, *[]string) {
+	var ran []string
+	return startSteps{
+		connect: func(context.Context, startOptions) error {
+			ran = append(ran, "connect")
+			return connectErr
+		},
+		migrate: func(context.Context) error {
+			ran = append(ran, "migrate")
+			return migrateErr
+		},
+		serve: func() { ran = append(ran, "serve") },
+	}, &ran
+}
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       startOptions
+		connectErr error
+		migrateErr error
+		wantCode   int
+		wantRan    string
+	}{
+		{"migrate then serve", startOptions{migrate: true}, nil, nil, exitOK, "[connect migrate serve]"},
+		{"serve without migrating", startOptions{}, nil, nil, exitOK, "[connect serve]"},
+		{"migrate only exits", startOptions{migrateOnly: true}, nil, nil, exitOK, "[connect migrate]"},
+		{"invalid database URL", startOptions{migrate: true}, fmt.Errorf("%w: bad port", database.ErrInvalidDatabaseURL), nil, exitConfig, "[connect]"},
+		{"database unreachable", startOptions{migrate: true}, fmt.Errorf("%w: refused", database.ErrUnavailable), nil, exitDBUnavailable, "[connect]"},
+		{"migration failure", startOptions{migrateOnly: true}, nil, errors.New("syntax error"), exitMigrationFailed, "[connect migrate]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, ran := recordingSteps(tt.connectErr, tt.migrateErr)
+			if code := start(context.Background(), tt.opts, steps); code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if got := fmt.Sprint(*ran); got != tt.wantRan {
+				t.Errorf("ran %s, want %s", got, tt.wantRan)
+			}
+		})
+	}
+}
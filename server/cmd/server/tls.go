@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/sprobst76/vibedterm-server/internal/config"
+)
+
+// httpServers are the listeners of the process: the API and web server,
+// and with autocert a plain HTTP server that answers the HTTP-01
+// challenges and redirects everything else to HTTPS
+type httpServers struct {
+	main     *http.Server
+	redirect *http.Server // nil unless autocert is enabled
+}
+
+// newHTTPServers builds the servers for handler. TLS comes from the
+// certificate files or from Let's Encrypt for the autocert domains; with
+// neither configured the server speaks plain HTTP, as behind a proxy.
+func newHTTPServers(cfg *config.Config, handler http.Handler) (*httpServers, error) {
+	s := &httpServers{main: &http.Server{
+		Addr:              cfg.ServerAddr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+	}}
+
+	files := cfg.TLSCertFile != "" || cfg.TLSKeyFile != ""
+	switch {
+	case files && len(cfg.TLSAutocertDomains) > 0:
+		return nil, errors.New("TLS_CERT_FILE and TLS_AUTOCERT_DOMAINS are mutually exclusive")
+	case files:
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		s.main.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	case len(cfg.TLSAutocertDomains) > 0:
+		if cfg.TLSAutocertCacheDir == "" {
+			return nil, errors.New("TLS_AUTOCERT_CACHE_DIR must be set, or every restart requests new certificates")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLSAutocertCacheDir),
+		}
+		s.main.TLSConfig = m.TLSConfig()
+		s.main.TLSConfig.MinVersion = tls.VersionTLS12
+		// A nil fallback redirects to HTTPS
+		s.redirect = &http.Server{
+			Addr:              cfg.TLSHTTPAddr,
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+			IdleTimeout:       cfg.ServerIdleTimeout,
+		}
+	}
+	return s, nil
+}
+
+// tls reports whether the main server terminates TLS itself
+func (s *httpServers) tls() bool {
+	return s.main.TLSConfig != nil
+}
+
+// listenAndServe opens the listeners and serves until shutdown
+func (s *httpServers) listenAndServe() error {
+	mainLn, err := net.Listen("tcp", s.main.Addr)
+	if err != nil {
+		return err
+	}
+	var redirectLn net.Listener
+	if s.redirect != nil {
+		if redirectLn, err = net.Listen("tcp", s.redirect.Addr); err != nil {
+			mainLn.Close()
+			return err
+		}
+	}
+	return s.serve(mainLn, redirectLn)
+}
+
+// serve runs the servers on the listeners and returns once both stopped.
+// After shutdown it returns nil, otherwise the first error; a failing
+// server shuts the other one down.
+func (s *httpServers) serve(mainLn, redirectLn net.Listener) error {
+	errs := make(chan error, 2)
+	run := func(serve func() error) {
+		err := serve()
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		errs <- err
+	}
+
+	running := 1
+	if s.redirect != nil {
+		running++
+		go run(func() error { return s.redirect.Serve(redirectLn) })
+	}
+	go run(func() error {
+		if s.tls() {
+			return s.main.ServeTLS(mainLn, "", "")
+		}
+		return s.main.Serve(mainLn)
+	})
+
+	var first error
+	for ; running > 0; running-- {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			s.close()
+		}
+	}
+	return first
+}
+
+// shutdown stops accepting connections on every listener and waits for
+// in-flight requests until ctx is done
+func (s *httpServers) shutdown(ctx context.Context) error {
+	var errs []error
+	if s.redirect != nil {
+		errs = append(errs, s.redirect.Shutdown(ctx))
+	}
+	errs = append(errs, s.main.Shutdown(ctx))
+	return errors.Join(errs...)
+}
+
+// close stops every server at once, dropping open connections
+func (s *httpServers) close() {
+	if s.redirect != nil {
+		s.redirect.Close()
+	}
+	s.main.Close()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sprobst76/vibedterm-server/internal/config"
+)
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 and localhost
+// and its key as PEM files, and returns their paths and a pool trusting it
+func writeSelfSignedCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok")
+})
+
+// startServers serves s on loopback listeners and returns their
+// addresses. The servers are shut down when the test ends, and serve
+// must then return nil.
+func startServers(t *testing.T, s *httpServers) (mainAddr, redirectAddr string) {
+	t.Helper()
+	listen := func() net.Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ln
+	}
+	mainLn := listen()
+	var redirectLn net.Listener
+	if s.redirect != nil {
+		redirectLn = listen()
+		redirectAddr = redirectLn.Addr().String()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.serve(mainLn, redirectLn) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.shutdown(ctx); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("serve after shutdown: %v", err)
+			}
+		case <-ctx.Done():
+			t.Error("serve did not return after shutdown")
+		}
+	})
+	return mainLn.Addr().String(), redirectAddr
+}
+
+func testServerConfig() *config.Config {
+	return &config.Config{ServerReadHeaderTimeout: 5 * time.Second, TLSHTTPAddr: ":80"}
+}
+
+func TestHTTPServers_PlainHTTP(t *testing.T) {
+	s, err := newHTTPServers(testServerConfig(), okHandler)
+	if err != nil {
+		t.Fatalf("newHTTPServers: %v", err)
+	}
+	if s.tls() || s.redirect != nil {
+		t.Fatal("TLS enabled without certificates or autocert domains")
+	}
+	addr, _ := startServers(t, s)
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS != nil {
+		t.Errorf("status = %d, TLS = %v", resp.StatusCode, resp.TLS != nil)
+	}
+}
+
+func TestHTTPServers_CertificateFiles(t *testing.T) {
+	cfg := testServerConfig()
+	var pool *x509.CertPool
+	cfg.TLSCertFile, cfg.TLSKeyFile, pool = writeSelfSignedCert(t)
+	s, err := newHTTPServers(cfg, okHandler)
+	if err != nil {
+		t.Fatalf("newHTTPServers: %v", err)
+	}
+	if !s.tls() || s.redirect != nil {
+		t.Fatalf("tls = %v, redirect = %v; want TLS without a redirect listener", s.tls(), s.redirect != nil)
+	}
+	addr, _ := startServers(t, s)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		t.Fatalf("GET over TLS: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" || resp.TLS == nil || resp.TLS.Version < tls.VersionTLS12 {
+		t.Errorf("status = %d, body = %q, TLS = %+v", resp.StatusCode, body, resp.TLS)
+	}
+
+	// Plain HTTP to the TLS port is refused
+	resp, err = http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("GET over plain HTTP: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain HTTP status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestHTTPServers_Autocert(t *testing.T) {
+	cfg := testServerConfig()
+	cfg.TLSAutocertDomains = []string{"vibedterm.example.com"}
+	cfg.TLSAutocertCacheDir = t.TempDir()
+	s, err := newHTTPServers(cfg, okHandler)
+	if err != nil {
+		t.Fatalf("newHTTPServers: %v", err)
+	}
+	if !s.tls() || s.redirect == nil {
+		t.Fatalf("tls = %v, redirect = %v; want both", s.tls(), s.redirect != nil)
+	}
+	if s.redirect.Addr != ":80" {
+		t.Errorf("redirect listens on %q, want :80", s.redirect.Addr)
+	}
+	mainAddr, redirectAddr := startServers(t, s)
+
+	// Without keep-alives no spare connection is left open to hold up
+	// the shutdown
+	client := &http.Client{
+		Transport:     &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	get := func(path string) *http.Response {
+		req, err := http.NewRequest("GET", "http://"+redirectAddr+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "vibedterm.example.com"
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("/account/login?next=1")
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "https://vibedterm.example.com/account/login?next=1" {
+		t.Errorf("redirect: status = %d, location = %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	// Challenges are answered by the ACME handler, never redirected
+	resp = get("/.well-known/acme-challenge/unknown-token")
+	if resp.StatusCode == http.StatusFound || resp.StatusCode == http.StatusOK {
+		t.Errorf("unknown challenge: status = %d", resp.StatusCode)
+	}
+
+	// Hosts outside the domain list get no certificate, so the handshake
+	// fails before any ACME request is made
+	conn, err := tls.Dial("tcp", mainAddr, &tls.Config{ServerName: "other.example.com", InsecureSkipVerify: true})
+	if err == nil {
+		conn.Close()
+		t.Error("handshake for a host outside TLS_AUTOCERT_DOMAINS succeeded")
+	} else if !strings.Contains(err.Error(), "remote error") {
+		t.Errorf("handshake error = %v, want one sent by the server", err)
+	}
+}
+
+func TestNewHTTPServers_InvalidTLSConfig(t *testing.T) {
+	certFile, keyFile, _ := writeSelfSignedCert(t)
+	for name, set := range map[string]func(*config.Config){
+		"cert without key":  func(c *config.Config) { c.TLSCertFile = certFile },
+		"key without cert":  func(c *config.Config) { c.TLSKeyFile = keyFile },
+		"missing cert file": func(c *config.Config) { c.TLSCertFile, c.TLSKeyFile = certFile+".missing", keyFile },
+		"key as cert":       func(c *config.Config) { c.TLSCertFile, c.TLSKeyFile = keyFile, keyFile },
+		"files and autocert": func(c *config.Config) {
+			c.TLSCertFile, c.TLSKeyFile = certFile, keyFile
+			c.TLSAutocertDomains = []string{"vibedterm.example.com"}
+		},
+		"autocert without cache": func(c *config.Config) {
+			c.TLSAutocertDomains = []string{"vibedterm.example.com"}
+			c.TLSAutocertCacheDir = ""
+		},
+	} {
+		cfg := testServerConfig()
+		cfg.TLSAutocertCacheDir = t.TempDir()
+		set(cfg)
+		if _, err := newHTTPServers(cfg, okHandler); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
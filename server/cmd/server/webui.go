@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/web"
+)
+
+// webUI mounts the web interfaces. They are constructed on demand, so a
+// disabled interface never starts its session store.
+type webUI struct {
+	admin    bool
+	user     bool
+	newAdmin func() *web.AdminWeb
+	newUser  func() *web.UserWeb
+	assets   *web.SiteAssets
+}
+
+// register mounts the enabled interfaces and the site assets they share.
+// loginLimit guards their login and registration posts. With both
+// disabled, / answers with a banner pointing at the API.
+func (w webUI) register(r *gin.Engine, loginLimit gin.HandlerFunc) {
+	if w.admin {
+		w.newAdmin().RegisterRoutes(r, loginLimit)
+	}
+	if w.user {
+		w.newUser().RegisterRoutes(r, loginLimit)
+	}
+	if w.admin || w.user {
+		w.assets.RegisterRoutes(r)
+		return
+	}
+	r.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"service": "vibedterm", "api": "/api/v1"})
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/web"
+)
+
+// routePrefixes reports which route prefixes r serves
+func routePrefixes(r *gin.Engine) map[string]bool {
+	found := map[string]bool{}
+	for _, route := range r.Routes() {
+		for _, prefix := range []string{"/admin", "/account", "/register", "/favicon.ico", "/robots.txt"} {
+			if strings.HasPrefix(route.Path, prefix) {
+				found[prefix] = true
+			}
+		}
+	}
+	return found
+}
+
+func TestWebUIRegister(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	templates, err := web.NewTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		admin, user bool
+		want        []string
+	}{
+		{"all", true, true, []string{"/admin", "/account", "/register", "/favicon.ico", "/robots.txt"}},
+		{"admin only", true, false, []string{"/admin", "/favicon.ico", "/robots.txt"}},
+		{"user only", false, true, []string{"/account", "/register", "/favicon.ico", "/robots.txt"}},
+		{"api only", false, false, nil},
+	}
+	for _, tc := range tests {
+		var adminBuilt, userBuilt int
+		ui := webUI{
+			admin: tc.admin,
+			user:  tc.user,
+			newAdmin: func() *web.AdminWeb {
+				adminBuilt++
+				return web.NewAdminWeb(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, models.AppVersionPolicy{}, templates)
+			},
+			newUser: func() *web.UserWeb {
+				userBuilt++
+				return web.NewUserWeb(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, "", "", templates)
+			},
+			assets: web.NewSiteAssets(nil),
+		}
+		r := gin.New()
+		ui.register(r, func(*gin.Context) {})
+
+		// Disabled interfaces are never constructed, so they start no
+		// session store or cleanup goroutine
+		if adminBuilt != btoi(tc.admin) || userBuilt != btoi(tc.user) {
+			t.Errorf("%s: built admin %d, user %d times", tc.name, adminBuilt, userBuilt)
+		}
+
+		found := routePrefixes(r)
+		if len(found) != len(tc.want) {
+			t.Errorf("%s: routes %v, want %v", tc.name, found, tc.want)
+		}
+		for _, prefix := range tc.want {
+			if !found[prefix] {
+				t.Errorf("%s: %s not registered", tc.name, prefix)
+			}
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		apiOnly := !tc.admin && !tc.user
+		if gotBanner := w.Code == http.StatusOK && strings.Contains(w.Body.String(), `"api":"/api/v1"`); gotBanner != apiOnly {
+			t.Errorf("%s: GET / = %d %s", tc.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+// The web interface's password and registration posts share the login
+// limit with the API; pages are not limited
+func TestWebUIRegister_LoginLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	templates, err := web.NewTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ui := webUI{
+		admin: true,
+		user:  true,
+		newAdmin: func() *web.AdminWeb {
+			return web.NewAdminWeb(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, models.AppVersionPolicy{}, templates)
+		},
+		newUser: func() *web.UserWeb {
+			return web.NewUserWeb(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, "", "", templates)
+		},
+		assets: web.NewSiteAssets(nil),
+	}
+	r := gin.New()
+	limiter := middleware.NewRateLimiter(1)
+	ui.register(r, limiter.Middleware())
+
+	// The first post takes the only token
+	if ok, _ := limiter.Allow("192.0.2.1"); !ok {
+		t.Fatal("limiter has no token")
+	}
+	for _, path := range []string{
+		"/admin/login", "/admin/login/totp", "/register", "/account/login", "/account/login/totp",
+		"/account/verify-email/resend", "/account/login/passkey/begin", "/account/login/passkey/finish",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("POST %s = %d, want 429", path, w.Code)
+		}
+	}
+	for _, path := range []string{"/admin/login", "/account/login"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code == http.StatusTooManyRequests {
+			t.Errorf("GET %s was rate limited", path)
+		}
+	}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,117 @@
+// Package apierror writes the error responses of the JSON API. Every
+// error carries a machine-readable code: a specific one where a client
+// can act on it, otherwise the generic code of its status.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// Generic error codes. Handlers use more specific codes, such as
+// USER_BLOCKED or NO_VAULT, where a client can act on them.
+const (
+	CodeInvalidRequest       = "INVALID_REQUEST" // malformed body or parameter
+	CodeInvalidInput         = "INVALID_INPUT"   // rejected fields, listed in details
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodePendingApproval      = "PENDING_APPROVAL"
+	CodeNotFound             = "NOT_FOUND"
+	CodeConflict             = "CONFLICT"
+	CodeGone                 = "GONE"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeUnprocessable        = "UNPROCESSABLE"
+	CodeUpgradeRequired      = "UPGRADE_REQUIRED"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeInternal             = "INTERNAL_ERROR"
+	CodeUnavailable          = "SERVICE_UNAVAILABLE"
+)
+
+// statusCodes maps statuses to their generic code
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusConflict:              CodeConflict,
+	http.StatusGone:                  CodeGone,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusUpgradeRequired:       CodeUpgradeRequired,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// StatusCode returns the generic code of an error status
+func StatusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// response builds the body of an error. An empty code is replaced by the
+// generic code of status. The request ID is the one set on the response.
+func response(c *gin.Context, status int, code, message string, details any) models.ErrorResponse {
+	if code == "" {
+		code = StatusCode(status)
+	}
+	return models.ErrorResponse{
+		Error:     message,
+		Code:      code,
+		Details:   details,
+		RequestID: c.Writer.Header().Get(models.RequestIDHeader),
+	}
+}
+
+// RespondError writes an error response. details is optional context for
+// the client, such as the rejected fields, and may be nil.
+func RespondError(c *gin.Context, status int, code, message string, details any) {
+	c.JSON(status, response(c, status, code, message, details))
+}
+
+// AbortWithError is RespondError for middleware; it also stops the
+// handler chain
+func AbortWithError(c *gin.Context, status int, code, message string, details any) {
+	c.AbortWithStatusJSON(status, response(c, status, code, message, details))
+}
+
+// RespondInvalidInput answers 400 with the rejected fields as details
+func RespondInvalidInput(c *gin.Context, fields input.Errors) {
+	RespondError(c, http.StatusBadRequest, CodeInvalidInput, "invalid input", fields)
+}
+
+// RespondBindError answers a request whose body could not be bound.
+// Failed input checks and values of the wrong JSON type are listed per
+// field; the raw decoder and validator messages are not shown. A body cut
+// off by a size limit is answered with 413.
+func RespondBindError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		RespondError(c, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "request body too large", gin.H{"max_size_bytes": tooLarge.Limit})
+		return
+	}
+	var fields input.Errors
+	if errors.As(err, &fields) {
+		RespondInvalidInput(c, fields)
+		return
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		RespondInvalidInput(c, input.Errors{{Field: typeErr.Field, Code: input.CodeInvalid, Message: "has the wrong type"}})
+		return
+	}
+	RespondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body", nil)
+}
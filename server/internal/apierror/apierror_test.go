@@ -0,0 +1,39 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondError_DefaultsCodeToStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		status int
+		code   string
+		want   string
+	}{
+		{http.StatusNotFound, "", CodeNotFound},
+		{http.StatusConflict, "USER_BLOCKED", "USER_BLOCKED"},
+		{http.StatusTeapot, "", CodeInvalidRequest},
+		{http.StatusBadGateway, "", CodeInternal},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		RespondError(c, tc.status, tc.code, "failed", nil)
+
+		var resp map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if w.Code != tc.status || resp["code"] != tc.want || resp["error"] != "failed" {
+			t.Errorf("%d %q: status = %d, body = %v; want code %s", tc.status, tc.code, w.Code, resp, tc.want)
+		}
+		if _, ok := resp["details"]; ok {
+			t.Errorf("%d: nil details written: %v", tc.status, resp)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,6 +13,9 @@ import (
 // DB is the database connection pool
 var DB *pgxpool.Pool
 
+// ErrInvalidDatabaseURL is returned if the database URL cannot be parsed
+var ErrInvalidDatabaseURL = errors.New("invalid database URL")
+
 // Connect establishes a connection to the PostgreSQL database
 func Connect(databaseURL string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -19,7 +23,7 @@ func Connect(databaseURL string) error {
 
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
-		return fmt.Errorf("failed to parse database URL: %w", err)
+		return fmt.Errorf("%w: %v", ErrInvalidDatabaseURL, err)
 	}
 
 	// Connection pool settings
@@ -61,6 +65,44 @@ func RunMigrations(ctx context.Context) error {
 		migrationRecoveryCodes,
 		migrationSyncLogs,
 		migrationIndexes,
+		migrationTempTokenExtensions,
+		migrationTempTokenRecoveryAttempts,
+		migrationBootstrapTokens,
+		migrationSettings,
+		migrationAPIKeys,
+		migrationAuditEvents,
+		migrationVaultHistory,
+		migrationRefreshTokenRotation,
+		migrationUserStateTimestamps,
+		migrationClientSettings,
+		migrationDomainEvents,
+		migrationEmailVerification,
+		migrationTextConstraints,
+		migrationRefreshTokenSessions,
+		migrationRegistrationProfile,
+		migrationDeviceLastSeen,
+		migrationInactivityCleanup,
+		migrationInvites,
+		migrationWebAuthn,
+		migrationAPIKeyScopes,
+		migrationTrustedDevices,
+		migrationSyncLogChain,
+		migrationAuthEvents,
+		migrationDeviceSeenRevision,
+		migrationVaultHistoryRestore,
+		migrationVaultBlobStorage,
+		migrationVaultChecksum,
+		migrationVaultSoftDelete,
+		migrationNamedVaults,
+		migrationPushReceipts,
+		migrationSyncLogVersions,
+		migrationVaultChunks,
+		migrationDeviceActivity,
+		migrationDevicePruning,
+		migrationDeviceReadOnly,
+		migrationDeviceApproval,
+		migrationTempTokenUses,
+		migrationSyncLogRetention,
 	}
 
 	for i, migration := range migrations {
@@ -175,3 +217,427 @@ CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON refresh_tokens(expires_
 CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
 CREATE INDEX IF NOT EXISTS idx_sync_logs_user_id ON sync_logs(user_id);
 `
+
+const migrationTempTokenExtensions = `
+CREATE TABLE IF NOT EXISTS temp_token_extensions (
+    jti UUID PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+`
+
+const migrationTempTokenRecoveryAttempts = `
+CREATE TABLE IF NOT EXISTS temp_token_recovery_attempts (
+    jti UUID PRIMARY KEY,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL
+);
+`
+
+const migrationBootstrapTokens = `
+CREATE TABLE IF NOT EXISTS bootstrap_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash VARCHAR(255) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+`
+
+const migrationSettings = `
+CREATE TABLE IF NOT EXISTS settings (
+    key VARCHAR(100) PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+`
+
+const migrationAPIKeys = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+    name VARCHAR(255) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL,
+    key_hash VARCHAR(255) UNIQUE NOT NULL,
+    revoked BOOLEAN DEFAULT false,
+
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+`
+
+const migrationAuditEvents = `
+CREATE TABLE IF NOT EXISTS audit_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    action VARCHAR(100) NOT NULL,
+    actor_id UUID,
+    target_id UUID,
+    details JSONB,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events(target_id);
+`
+
+const migrationVaultHistory = `
+CREATE TABLE IF NOT EXISTS vault_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+    vault_blob BYTEA NOT NULL,
+    revision INTEGER NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_vault_history_user_id ON vault_history(user_id);
+`
+
+const migrationRefreshTokenRotation = `
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS replaced_by UUID;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+`
+
+const migrationUserStateTimestamps = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS approved_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS approved_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked_reason TEXT;
+UPDATE users SET approved_at = updated_at WHERE is_approved = true AND approved_at IS NULL;
+UPDATE users SET blocked_at = updated_at WHERE is_blocked = true AND blocked_at IS NULL;
+`
+
+const migrationClientSettings = `
+CREATE TABLE IF NOT EXISTS client_settings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID UNIQUE NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    settings_blob BYTEA NOT NULL,
+    revision INTEGER NOT NULL DEFAULT 1,
+    updated_by_device UUID REFERENCES devices(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+`
+
+const migrationDomainEvents = `
+CREATE TABLE IF NOT EXISTS domain_events (
+    seq BIGSERIAL PRIMARY KEY,
+    type VARCHAR(100) NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor_id UUID,
+    subject_id UUID,
+    ip VARCHAR(64),
+    payload JSONB NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_domain_events_occurred_at ON domain_events(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_domain_events_type ON domain_events(type);
+`
+
+// Accounts that existed before email verification are treated as verified
+const migrationEmailVerification = `
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'email_verified'
+    ) THEN
+        ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+        UPDATE users SET email_verified = true;
+    END IF;
+END $$;
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_id ON email_verification_tokens(user_id);
+`
+
+// Backstop for the input validation of the API. The constraints are NOT
+// VALID so existing rows do not block the migration; new writes are
+// checked.
+const migrationTextConstraints = `
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_email_text') THEN
+        ALTER TABLE users ADD CONSTRAINT users_email_text
+            CHECK (char_length(email) <= 254 AND email !~ '[[:cntrl:]]') NOT VALID;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_blocked_reason_text') THEN
+        ALTER TABLE users ADD CONSTRAINT users_blocked_reason_text
+            CHECK (char_length(blocked_reason) <= 500 AND blocked_reason !~ '[[:cntrl:]]') NOT VALID;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'devices_text') THEN
+        ALTER TABLE devices ADD CONSTRAINT devices_text
+            CHECK (device_name !~ '[[:cntrl:]]' AND device_type !~ '[[:cntrl:]]') NOT VALID;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'api_keys_name_text') THEN
+        ALTER TABLE api_keys ADD CONSTRAINT api_keys_name_text
+            CHECK (name !~ '[[:cntrl:]]') NOT VALID;
+    END IF;
+END $$;
+`
+
+// A session is a refresh token rotation chain. Its start is copied to
+// each successor so it survives the cleanup of old chain members.
+const migrationRefreshTokenSessions = `
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_created_at TIMESTAMP;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_active ON refresh_tokens(user_id) WHERE revoked = false;
+`
+
+const migrationRegistrationProfile = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(100);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS registration_message VARCHAR(1000);
+`
+
+// last_seen_at is set on each token refresh, last_sync_at only on vault sync
+const migrationDeviceLastSeen = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+`
+
+// Inactive accounts are warned before being blocked or soft-deleted. A
+// soft-deleted account is blocked and keeps its data until unblocked.
+const migrationInactivityCleanup = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS inactivity_warned_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+`
+
+const migrationInvites = `
+CREATE TABLE IF NOT EXISTS invites (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code_prefix VARCHAR(20) NOT NULL,
+    code_hash VARCHAR(255) UNIQUE NOT NULL,
+    note VARCHAR(500),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    used_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+`
+
+// WebAuthn credentials are an alternative second factor to TOTP. A
+// challenge lives from the begin to the finish call of one ceremony.
+const migrationWebAuthn = `
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    credential_id BYTEA UNIQUE NOT NULL,
+    public_key BYTEA NOT NULL,
+    attestation_type VARCHAR(50) NOT NULL DEFAULT '',
+    aaguid BYTEA,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    backup_eligible BOOLEAN NOT NULL DEFAULT false,
+    backup_state BOOLEAN NOT NULL DEFAULT false,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    ceremony VARCHAR(20) NOT NULL,
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+`
+
+// API keys are limited to the scopes chosen at creation. Keys minted
+// before scopes existed keep vault access.
+const migrationAPIKeyScopes = `
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{vault:read,vault:write}';
+`
+
+const migrationTrustedDevices = `
+CREATE TABLE IF NOT EXISTS trusted_devices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id UUID REFERENCES devices(id) ON DELETE CASCADE, -- NULL for web browsers
+    name VARCHAR(255) NOT NULL,
+
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trusted_devices_user ON trusted_devices(user_id);
+`
+
+// Sync logs of a user form a hash chain. Existing entries keep NULL until
+// the user's next entry chains them.
+const migrationSyncLogChain = `
+ALTER TABLE sync_logs ADD COLUMN IF NOT EXISTS seq BIGINT;
+ALTER TABLE sync_logs ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE sync_logs ADD COLUMN IF NOT EXISTS entry_hash VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sync_logs_user_seq ON sync_logs(user_id, seq);
+`
+
+const migrationAuthEvents = `
+CREATE TABLE IF NOT EXISTS auth_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE, -- NULL for unknown emails
+    actor_id UUID,
+
+    type VARCHAR(100) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    reason VARCHAR(100),
+    email VARCHAR(255),
+    ip VARCHAR(45),
+    user_agent VARCHAR(512),
+
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_auth_events_user_created ON auth_events(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_auth_events_created_at ON auth_events(created_at);
+`
+
+// The vault revision a device last pulled or pushed; NULL if it never
+// synced the current vault
+const migrationDeviceSeenRevision = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_revision INTEGER;
+`
+
+// Vault history snapshots can be restored, so they keep the vault version
+// and the device that wrote them
+const migrationVaultHistoryRestore = `
+ALTER TABLE vault_history ADD COLUMN IF NOT EXISTS vault_version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE vault_history ADD COLUMN IF NOT EXISTS device_id UUID REFERENCES devices(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_vault_history_user_revision ON vault_history(user_id, revision);
+`
+
+// Encrypted blobs do not compress. Stored uncompressed, a substring of the
+// blob reads only the TOAST chunks it covers, so vaults stream in slices.
+const migrationVaultBlobStorage = `
+ALTER TABLE encrypted_vaults ALTER COLUMN vault_blob SET STORAGE EXTERNAL;
+`
+
+// The checksum is computed by the database on every write, which also
+// fills it in for existing vaults. Must match models.BlobChecksum.
+const migrationVaultChecksum = `
+ALTER TABLE encrypted_vaults ADD COLUMN IF NOT EXISTS checksum TEXT
+    GENERATED ALWAYS AS (encode(sha256(vault_blob), 'hex')) STORED;
+`
+
+// A deleted vault keeps its row until the grace period ends, so it can be
+// undeleted. The partial index serves the purge job.
+const migrationVaultSoftDelete = `
+ALTER TABLE encrypted_vaults ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_encrypted_vaults_deleted_at ON encrypted_vaults(deleted_at) WHERE deleted_at IS NOT NULL;
+`
+
+// Users may keep several vaults, told apart by a name of their choice.
+// Existing vaults, their history and sync log entries belong to the
+// default vault.
+const migrationNamedVaults = `
+ALTER TABLE encrypted_vaults ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE encrypted_vaults DROP CONSTRAINT IF EXISTS encrypted_vaults_user_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_encrypted_vaults_user_name ON encrypted_vaults(user_id, name);
+ALTER TABLE vault_history ADD COLUMN IF NOT EXISTS vault_name TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS idx_vault_history_user_vault_revision ON vault_history(user_id, vault_name, revision);
+ALTER TABLE sync_logs ADD COLUMN IF NOT EXISTS vault_name TEXT NOT NULL DEFAULT 'default';
+`
+
+// Successful pushes sent with an idempotency key, so a retried push gets
+// the original response instead of a conflict
+const migrationPushReceipts = `
+CREATE TABLE IF NOT EXISTS vault_push_receipts (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    idempotency_key TEXT NOT NULL,
+    vault_name TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    revision INTEGER NOT NULL,
+    pushed_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS idx_vault_push_receipts_created_at ON vault_push_receipts(created_at);
+`
+
+// Sync log entries of vault version changes record both versions
+const migrationSyncLogVersions = `
+ALTER TABLE sync_logs ADD COLUMN IF NOT EXISTS version_before INTEGER;
+ALTER TABLE sync_logs ADD COLUMN IF NOT EXISTS version_after INTEGER;
+`
+
+// Chunks uploaded for a delta push, kept until the push is committed or
+// they expire. They are scoped per user so no one can probe for chunks of
+// another user.
+const migrationVaultChunks = `
+CREATE TABLE IF NOT EXISTS vault_chunks (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hash TEXT NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, hash)
+);
+CREATE INDEX IF NOT EXISTS idx_vault_chunks_created_at ON vault_chunks(created_at);
+`
+
+// The client address and user agent a device last logged in, refreshed or
+// synced from. last_ip stays NULL if DEVICE_RECORD_IP is off.
+const migrationDeviceActivity = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_ip VARCHAR(45);
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_user_agent VARCHAR(512);
+`
+
+// Accounts that opted in have devices removed once unused for
+// DEVICE_INACTIVE_AFTER
+const migrationDevicePruning = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS prune_inactive_devices BOOLEAN NOT NULL DEFAULT false;
+`
+
+// Read-only devices may pull vaults but not push them
+const migrationDeviceReadOnly = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS read_only BOOLEAN NOT NULL DEFAULT false;
+`
+
+// Accounts requiring device approval get new devices unapproved; they may
+// not use the vault until an approved device or the web UI approves them.
+// Existing devices stay approved.
+const migrationDeviceApproval = `
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS is_approved BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS require_device_approval BOOLEAN NOT NULL DEFAULT false;
+`
+
+// Temp tokens that completed a login may not complete another one
+const migrationTempTokenUses = `
+CREATE TABLE IF NOT EXISTS temp_token_uses (
+    jti UUID PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+`
+
+// Retention prunes the oldest entries of a chain and records where it cut,
+// so the remaining suffix verifies while a truncated one does not. Chains
+// already pruned get their cut recorded once, when the table is created.
+const migrationSyncLogRetention = `
+DO $$
+BEGIN
+    IF to_regclass('sync_log_retention') IS NULL THEN
+        CREATE TABLE sync_log_retention (
+            user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+            seq BIGINT NOT NULL,            -- first retained entry
+            prev_hash VARCHAR(64) NOT NULL, -- hash of the last pruned entry
+            pruned_at TIMESTAMP NOT NULL DEFAULT NOW()
+        );
+        INSERT INTO sync_log_retention (user_id, seq, prev_hash)
+        SELECT user_id, seq, prev_hash FROM (
+            SELECT DISTINCT ON (user_id) user_id, seq, prev_hash
+            FROM sync_logs WHERE seq IS NOT NULL
+            ORDER BY user_id, seq
+        ) oldest
+        WHERE seq > 1;
+    END IF;
+END $$;
+`
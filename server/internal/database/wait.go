@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnavailable is returned if the database did not become reachable in time
+var ErrUnavailable = errors.New("database unavailable")
+
+// waitInterval is the pause between connection attempts
+const waitInterval = 2 * time.Second
+
+// Wait calls probe every interval until it succeeds or timeout passes.
+// Each failed attempt is logged. On timeout the last probe error is
+// wrapped in ErrUnavailable.
+func Wait(ctx context.Context, timeout, interval time.Duration, probe func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		err := probe(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Info().Err(err).Int("attempt", attempt).Msg("Waiting for database")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %s: %v", ErrUnavailable, timeout, err)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ConnectWait connects like Connect but keeps retrying until the database
+// accepts connections or timeout passes. An unparsable URL fails at once
+// with ErrInvalidDatabaseURL.
+func ConnectWait(ctx context.Context, databaseURL string, timeout time.Duration) error {
+	if _, err := pgxpool.ParseConfig(databaseURL); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDatabaseURL, err)
+	}
+	return Wait(ctx, timeout, waitInterval, func(context.Context) error {
+		return Connect(databaseURL)
+	})
+}
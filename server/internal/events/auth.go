@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// maxUserAgentLength caps stored user agents
+const maxUserAgentLength = 512
+
+// AuthEventStore persists the account activity log
+type AuthEventStore interface {
+	Create(ctx context.Context, event *models.AuthEvent) error
+}
+
+type clientKey struct{}
+
+type client struct {
+	ip        string
+	userAgent string
+}
+
+// WithClient attaches the requesting client to ctx, so events recorded
+// while serving the request carry its address and user agent
+func WithClient(ctx context.Context, ip, userAgent string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client{ip: ip, userAgent: userAgent})
+}
+
+// Client returns the address and user agent attached to ctx with
+// WithClient, or empty strings
+func Client(ctx context.Context) (ip, userAgent string) {
+	c, _ := ctx.Value(clientKey{}).(client)
+	return c.ip, c.userAgent
+}
+
+// recordAuthEvent adds entry to the account activity log of its subject,
+// or of its actor if it has none
+func (l *Log) recordAuthEvent(ctx context.Context, entry Entry) {
+	if l.authEvents == nil {
+		return
+	}
+	event := &models.AuthEvent{
+		UserID:    entry.SubjectID,
+		ActorID:   entry.ActorID,
+		Type:      entry.Payload.eventType(),
+		Outcome:   models.AuthOutcomeSuccess,
+		IP:        entry.IP,
+		UserAgent: entry.UserAgent,
+	}
+	if event.UserID == nil {
+		event.UserID = entry.ActorID
+	}
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = event.UserAgent[:maxUserAgentLength]
+	}
+
+	switch p := entry.Payload.(type) {
+	case AccountRegistered:
+		event.Email = p.Email
+	case LoginSucceeded:
+		event.Email = p.Email
+	case LoginFailed:
+		event.Outcome, event.Reason, event.Email = models.AuthOutcomeFailure, p.Reason, p.Email
+	case RefreshTokenReused:
+		event.Outcome = models.AuthOutcomeFailure
+	case RefreshDeviceMismatch:
+		event.Outcome, event.Reason = models.AuthOutcomeFailure, p.Reason
+	case AdminAction:
+		event.Reason = p.Reason
+	}
+	if event.UserID == nil && event.Email == "" {
+		return // nothing to attribute it to
+	}
+
+	if err := l.authEvents.Create(ctx, event); err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Failed to record auth event")
+	}
+}
@@ -0,0 +1,246 @@
+// Package events records security-relevant domain events and exposes
+// them to external collectors.
+//
+// Every event carries one of the typed payloads defined here. Payloads
+// only have fields that are safe to ship to a SIEM; there is no way to
+// attach free-form data, so secrets and password material cannot end up
+// in the log.
+package events
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// Event types
+const (
+	TypeAccountRegistered     = "account.registered"
+	TypeLoginSucceeded        = "auth.login_succeeded"
+	TypeLoginFailed           = "auth.login_failed"
+	TypeRefreshTokenReused    = "auth.refresh_token_reused"
+	TypeRefreshDeviceMismatch = "auth.refresh_device_mismatch"
+	TypePasswordChanged       = "account.password_changed"
+	TypeTOTPEnabled           = "account.totp_enabled"
+	TypeTOTPDisabled          = "account.totp_disabled"
+	TypeRecoveryCodesReset    = "account.recovery_codes_regenerated"
+
+	// TypeAuditPrefix prefixes the audit action of admin actions, e.g.
+	// "audit.user.blocked"
+	TypeAuditPrefix = "audit."
+)
+
+// Login surfaces
+const (
+	SurfaceAPI      = "api"
+	SurfaceWeb      = "web"
+	SurfaceAdminWeb = "admin_web"
+)
+
+// Login failure reasons
+const (
+	ReasonInvalidCredentials  = "invalid_credentials"
+	ReasonAccountBlocked      = "account_blocked"
+	ReasonEmailNotVerified    = "email_not_verified"
+	ReasonPendingApproval     = "pending_approval"
+	ReasonNotAdmin            = "not_admin"
+	ReasonInvalidTOTP         = "invalid_totp"
+	ReasonInvalidWebAuthn     = "invalid_webauthn"
+	ReasonInvalidRecoveryCode = "invalid_recovery_code"
+)
+
+// Payload is the typed body of an event. It is implemented only by the
+// payload types of this package.
+type Payload interface {
+	eventType() string
+}
+
+// AccountRegistered is recorded when a user account is created
+type AccountRegistered struct {
+	Email string `json:"email"`
+}
+
+// LoginSucceeded is recorded when a login completes
+type LoginSucceeded struct {
+	Email      string `json:"email"`
+	Surface    string `json:"surface"`
+	DeviceName string `json:"device_name,omitempty"`
+}
+
+// LoginFailed is recorded when a login is rejected
+type LoginFailed struct {
+	Email   string `json:"email,omitempty"`
+	Surface string `json:"surface"`
+	Reason  string `json:"reason"`
+}
+
+// RefreshTokenReused is recorded when a rotated refresh token is presented again
+type RefreshTokenReused struct {
+	FamilyID uuid.UUID `json:"family_id"`
+	DeviceID uuid.UUID `json:"device_id"`
+}
+
+// RefreshDeviceMismatch is recorded when a refresh token's device binding is broken
+type RefreshDeviceMismatch struct {
+	DeviceID uuid.UUID `json:"device_id"`
+	Reason   string    `json:"reason"`
+}
+
+// PasswordChanged is recorded when a user changes their password
+type PasswordChanged struct {
+	Surface string `json:"surface"`
+}
+
+// TOTPEnabled is recorded when a user turns on TOTP
+type TOTPEnabled struct {
+	Surface string `json:"surface"`
+}
+
+// TOTPDisabled is recorded when a user turns off TOTP
+type TOTPDisabled struct {
+	Surface string `json:"surface"`
+}
+
+// RecoveryCodesReset is recorded when a user regenerates their recovery codes
+type RecoveryCodesReset struct {
+	Surface string `json:"surface"`
+}
+
+// AdminAction mirrors an audit event. Only known audit details are
+// copied over.
+type AdminAction struct {
+	Action       string     `json:"action"`
+	Email        string     `json:"email,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	TargetUserID *uuid.UUID `json:"target_user_id,omitempty"`
+	TargetEmail  string     `json:"target_email,omitempty"`
+	Revision     string     `json:"revision,omitempty"`
+	Overwritten  string     `json:"overwritten,omitempty"`
+}
+
+func (AccountRegistered) eventType() string     { return TypeAccountRegistered }
+func (LoginSucceeded) eventType() string        { return TypeLoginSucceeded }
+func (LoginFailed) eventType() string           { return TypeLoginFailed }
+func (RefreshTokenReused) eventType() string    { return TypeRefreshTokenReused }
+func (RefreshDeviceMismatch) eventType() string { return TypeRefreshDeviceMismatch }
+func (PasswordChanged) eventType() string       { return TypePasswordChanged }
+func (TOTPEnabled) eventType() string           { return TypeTOTPEnabled }
+func (TOTPDisabled) eventType() string          { return TypeTOTPDisabled }
+func (RecoveryCodesReset) eventType() string    { return TypeRecoveryCodesReset }
+func (a AdminAction) eventType() string         { return TypeAuditPrefix + a.Action }
+
+// FromAudit converts an audit event into an admin action payload
+func FromAudit(event *models.AuditEvent) AdminAction {
+	action := AdminAction{
+		Action:      event.Action,
+		Email:       event.Details["email"],
+		Reason:      event.Details["reason"],
+		TargetEmail: event.Details["target_email"],
+		Revision:    event.Details["revision"],
+		Overwritten: event.Details["overwritten"],
+	}
+	if id, err := uuid.Parse(event.Details["target_user_id"]); err == nil {
+		action.TargetUserID = &id
+	}
+	return action
+}
+
+// Entry is an event to record. IP and UserAgent default to the client
+// attached to the context with WithClient.
+type Entry struct {
+	ActorID   *uuid.UUID
+	SubjectID *uuid.UUID
+	IP        string
+	UserAgent string
+	Payload   Payload
+}
+
+// Store persists events and assigns their sequence IDs
+type Store interface {
+	Append(ctx context.Context, event *models.DomainEvent) error
+}
+
+// Forwarder pushes recorded events to an external system
+type Forwarder interface {
+	Forward(event models.DomainEvent)
+}
+
+// Log records domain events. A nil *Log records nothing, so callers need
+// not check whether event recording is wired up.
+type Log struct {
+	store      Store
+	forwarder  Forwarder
+	authEvents AuthEventStore
+}
+
+// NewLog creates an event log. forwarder may be nil. If authEvents is
+// set, events concerning an account are also added to the account
+// activity log.
+func NewLog(store Store, forwarder Forwarder, authEvents AuthEventStore) *Log {
+	return &Log{store: store, forwarder: forwarder, authEvents: authEvents}
+}
+
+// Record stores an event and hands it to the forwarder. Failures are
+// logged but never fail the action that caused the event.
+func (l *Log) Record(ctx context.Context, entry Entry) {
+	if l == nil || entry.Payload == nil {
+		return
+	}
+	if client, ok := ctx.Value(clientKey{}).(client); ok {
+		entry.IP = cmp.Or(entry.IP, client.ip)
+		entry.UserAgent = cmp.Or(entry.UserAgent, client.userAgent)
+	}
+	data, err := json.Marshal(entry.Payload)
+	if err != nil {
+		log.Error().Err(err).Str("type", entry.Payload.eventType()).Msg("Failed to encode domain event")
+		return
+	}
+	event := &models.DomainEvent{
+		Type:       entry.Payload.eventType(),
+		OccurredAt: time.Now(),
+		ActorID:    entry.ActorID,
+		SubjectID:  entry.SubjectID,
+		IP:         entry.IP,
+		Data:       data,
+	}
+	if err := l.store.Append(ctx, event); err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Failed to record domain event")
+		return
+	}
+	if l.forwarder != nil {
+		l.forwarder.Forward(*event)
+	}
+	l.recordAuthEvent(ctx, entry)
+}
+
+// auditStore is the audit log written by admin services
+type auditStore interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+}
+
+// AuditRecorder wraps an audit store so that every audit event is also
+// recorded as a domain event
+type AuditRecorder struct {
+	audit auditStore
+	log   *Log
+}
+
+// NewAuditRecorder creates an audit store that also records domain events
+func NewAuditRecorder(audit auditStore, log *Log) *AuditRecorder {
+	return &AuditRecorder{audit: audit, log: log}
+}
+
+// Create stores the audit event and records it as an admin action
+func (r *AuditRecorder) Create(ctx context.Context, event *models.AuditEvent) error {
+	if err := r.audit.Create(ctx, event); err != nil {
+		return err
+	}
+	r.log.Record(ctx, Entry{ActorID: event.ActorID, SubjectID: event.TargetID, Payload: FromAudit(event)})
+	return nil
+}
@@ -0,0 +1,225 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+type memStore struct{ events []models.DomainEvent }
+
+func (m *memStore) Append(_ context.Context, event *models.DomainEvent) error {
+	event.Seq = int64(len(m.events) + 1)
+	m.events = append(m.events, *event)
+	return nil
+}
+
+type memForwarder struct{ events []models.DomainEvent }
+
+func (m *memForwarder) Forward(event models.DomainEvent) { m.events = append(m.events, event) }
+
+type memAudit struct{ events []models.AuditEvent }
+
+func (m *memAudit) Create(_ context.Context, event *models.AuditEvent) error {
+	m.events = append(m.events, *event)
+	return nil
+}
+
+func TestLog_RecordsTypedPayload(t *testing.T) {
+	store, fwd := &memStore{}, &memForwarder{}
+	l := NewLog(store, fwd, nil)
+	id := uuid.New()
+
+	l.Record(context.Background(), Entry{
+		SubjectID: &id,
+		IP:        "203.0.113.7",
+		Payload:   LoginFailed{Email: "a@example.com", Surface: SurfaceAPI, Reason: ReasonInvalidTOTP},
+	})
+
+	if len(store.events) != 1 || len(fwd.events) != 1 {
+		t.Fatalf("stored %d, forwarded %d events; want 1 each", len(store.events), len(fwd.events))
+	}
+	e := fwd.events[0]
+	if e.Seq != 1 || e.Type != TypeLoginFailed || *e.SubjectID != id || e.IP != "203.0.113.7" {
+		t.Errorf("forwarded event = %+v", e)
+	}
+	want := `{"email":"a@example.com","surface":"api","reason":"invalid_totp"}`
+	if string(e.Data) != want {
+		t.Errorf("data = %s, want %s", e.Data, want)
+	}
+}
+
+func TestLog_NilIsNoop(t *testing.T) {
+	var l *Log
+	l.Record(context.Background(), Entry{Payload: AccountRegistered{Email: "a@example.com"}})
+}
+
+func TestAuditRecorder_CopiesOnlyKnownDetails(t *testing.T) {
+	store, audit := &memStore{}, &memAudit{}
+	r := NewAuditRecorder(audit, NewLog(store, nil, nil))
+	actor, target := uuid.New(), uuid.New()
+
+	err := r.Create(context.Background(), &models.AuditEvent{
+		Action:   models.AuditVaultMoved,
+		ActorID:  &actor,
+		TargetID: &target,
+		Details: map[string]string{
+			"email":          "src@example.com",
+			"target_user_id": actor.String(),
+			"revision":       "4",
+			"password":       "hunter2",
+			"token":          "secret",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(audit.events) != 1 || len(store.events) != 1 {
+		t.Fatalf("audit %d, events %d; want 1 each", len(audit.events), len(store.events))
+	}
+
+	e := store.events[0]
+	if e.Type != "audit.vault.moved" || *e.ActorID != actor || *e.SubjectID != target {
+		t.Errorf("event = %+v", e)
+	}
+	if strings.Contains(string(e.Data), "hunter2") || strings.Contains(string(e.Data), "secret") {
+		t.Errorf("unknown audit details leaked into event: %s", e.Data)
+	}
+	var data AdminAction
+	if err := json.Unmarshal(e.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Email != "src@example.com" || data.Revision != "4" || data.TargetUserID == nil || *data.TargetUserID != actor {
+		t.Errorf("payload = %+v", data)
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	id := uuid.MustParse("6f9619ff-8b86-d011-b42d-00c04fc964ff")
+	event := models.DomainEvent{
+		Seq:        42,
+		Type:       TypeLoginFailed,
+		OccurredAt: time.Date(2026, 3, 4, 5, 6, 7, 890e6, time.FixedZone("CET", 3600)),
+		SubjectID:  &id,
+		IP:         "203.0.113.7",
+		Data:       json.RawMessage(`{"surface":"api","reason":"invalid_credentials"}`),
+	}
+
+	got := string(formatSyslog(event, "host-1", "vibedterm"))
+	want := `<84>1 2026-03-04T04:06:07.890Z host-1 vibedterm - auth.login_failed - ` +
+		`{"seq":42,"type":"auth.login_failed","occurred_at":"2026-03-04T05:06:07.89+01:00",` +
+		`"subject_id":"6f9619ff-8b86-d011-b42d-00c04fc964ff","ip":"203.0.113.7",` +
+		`"data":{"surface":"api","reason":"invalid_credentials"}}`
+	if got != want {
+		t.Errorf("formatSyslog =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatSyslog_Severity(t *testing.T) {
+	for eventType, pri := range map[string]string{
+		TypeLoginFailed:        "<84>",
+		TypeRefreshTokenReused: "<84>",
+		"audit.user.blocked":   "<85>",
+		TypeLoginSucceeded:     "<86>",
+		TypeAccountRegistered:  "<86>",
+	} {
+		msg := formatSyslog(models.DomainEvent{Type: eventType, Data: json.RawMessage(`{}`)}, "h", "a")
+		if !strings.HasPrefix(string(msg), pri) {
+			t.Errorf("%s: message %q, want priority %s", eventType, msg, pri)
+		}
+	}
+}
+
+func TestSyslogHeaderField(t *testing.T) {
+	cases := map[string]string{
+		"":                      "-",
+		"my app":                "my_app",
+		"émoji":                 "_moji",
+		strings.Repeat("x", 60): strings.Repeat("x", 48),
+	}
+	for in, want := range cases {
+		if got := syslogHeaderField(in, 48); got != want {
+			t.Errorf("syslogHeaderField(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSyslogForwarder_DeliversOverUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp unavailable: %v", err)
+	}
+	defer pc.Close()
+
+	f, err := NewSyslogForwarder(pc.LocalAddr().String(), "vibed term")
+	if err != nil {
+		t.Fatalf("NewSyslogForwarder: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	f.Forward(models.DomainEvent{Seq: 7, Type: TypeAccountRegistered, OccurredAt: time.Now(), Data: json.RawMessage(`{"email":"a@example.com"}`)})
+
+	buf := make([]byte, 2048)
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no datagram received: %v", err)
+	}
+	pattern := `^<86>1 \S+Z \S+ vibed_term - account\.registered - \{"seq":7,.*"data":\{"email":"a@example\.com"\}\}$`
+	if !regexp.MustCompile(pattern).Match(buf[:n]) {
+		t.Errorf("datagram = %q, want match for %s", buf[:n], pattern)
+	}
+}
+
+func TestSyslogForwarder_DropsWhenFull(t *testing.T) {
+	f := &SyslogForwarder{queue: make(chan models.DomainEvent, 1)}
+	f.Forward(models.DomainEvent{Seq: 1})
+	f.Forward(models.DomainEvent{Seq: 2})
+	if got := f.dropped.Load(); got != 1 {
+		t.Errorf("dropped = %d, want 1", got)
+	}
+}
+
+type memAuthEvents struct{ events []models.AuthEvent }
+
+func (m *memAuthEvents) Create(_ context.Context, event *models.AuthEvent) error {
+	m.events = append(m.events, *event)
+	return nil
+}
+
+func TestLog_MirrorsAuthEvents(t *testing.T) {
+	authEvents := &memAuthEvents{}
+	l := NewLog(&memStore{}, nil, authEvents)
+	user, admin := uuid.New(), uuid.New()
+	ctx := WithClient(context.Background(), "198.51.100.4", "vibedterm-app/1.2")
+
+	l.Record(ctx, Entry{SubjectID: &user, Payload: LoginFailed{Email: "a@example.com", Surface: SurfaceAPI, Reason: ReasonInvalidTOTP}})
+	l.Record(ctx, Entry{SubjectID: &user, Payload: TOTPDisabled{Surface: SurfaceWeb}})
+	l.Record(ctx, Entry{ActorID: &admin, SubjectID: &user, Payload: AdminAction{Action: models.AuditUserBlocked, Reason: "abuse"}})
+	l.Record(ctx, Entry{Payload: LoginFailed{Surface: SurfaceAPI, Reason: ReasonInvalidRecoveryCode}}) // nobody to attribute it to
+
+	if len(authEvents.events) != 3 {
+		t.Fatalf("mirrored %d events, want 3", len(authEvents.events))
+	}
+	failed, disabled, blocked := authEvents.events[0], authEvents.events[1], authEvents.events[2]
+	if failed.Outcome != models.AuthOutcomeFailure || failed.Reason != ReasonInvalidTOTP || *failed.UserID != user ||
+		failed.IP != "198.51.100.4" || failed.UserAgent != "vibedterm-app/1.2" {
+		t.Errorf("failed login = %+v", failed)
+	}
+	if disabled.Type != TypeTOTPDisabled || disabled.Outcome != models.AuthOutcomeSuccess {
+		t.Errorf("TOTP disabled = %+v", disabled)
+	}
+	if blocked.Type != TypeAuditPrefix+models.AuditUserBlocked || *blocked.UserID != user || *blocked.ActorID != admin || blocked.Reason != "abuse" {
+		t.Errorf("admin action = %+v", blocked)
+	}
+}
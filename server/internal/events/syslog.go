@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// Syslog framing per RFC 5424
+const (
+	syslogFacilityAuthPriv = 10
+	syslogSeverityWarning  = 4
+	syslogSeverityNotice   = 5
+	syslogSeverityInfo     = 6
+
+	syslogQueueSize = 256
+)
+
+// SyslogForwarder sends events as RFC 5424 messages over UDP. Delivery
+// is best effort: events are dropped when the queue is full, and the
+// export endpoint remains the complete record.
+type SyslogForwarder struct {
+	conn     net.Conn
+	hostname string
+	app      string
+	queue    chan models.DomainEvent
+	dropped  atomic.Int64
+}
+
+// NewSyslogForwarder creates a forwarder sending to the UDP address addr
+// with the given app name. Run must be started to deliver events.
+func NewSyslogForwarder(addr, app string) (*SyslogForwarder, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &SyslogForwarder{
+		conn:     conn,
+		hostname: syslogHeaderField(hostname, 255),
+		app:      syslogHeaderField(app, 48),
+		queue:    make(chan models.DomainEvent, syslogQueueSize),
+	}, nil
+}
+
+// Forward queues an event without blocking
+func (f *SyslogForwarder) Forward(event models.DomainEvent) {
+	select {
+	case f.queue <- event:
+	default:
+		f.dropped.Add(1)
+	}
+}
+
+// Run delivers queued events until ctx is cancelled
+func (f *SyslogForwarder) Run(ctx context.Context) {
+	defer f.conn.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-f.queue:
+			if n := f.dropped.Swap(0); n > 0 {
+				log.Warn().Int64("dropped", n).Msg("Syslog forwarder queue full, events dropped")
+			}
+			if _, err := f.conn.Write(formatSyslog(event, f.hostname, f.app)); err != nil {
+				log.Warn().Err(err).Int64("seq", event.Seq).Msg("Failed to forward event to syslog")
+			}
+		}
+	}
+}
+
+// formatSyslog renders an event as an RFC 5424 message whose MSGID is
+// the event type and whose body is the event's JSON export line
+func formatSyslog(event models.DomainEvent, hostname, app string) []byte {
+	body, err := json.Marshal(event)
+	if err != nil {
+		body = []byte(`{}`)
+	}
+	pri := syslogFacilityAuthPriv*8 + syslogSeverity(event.Type)
+	return fmt.Appendf(nil, "<%d>1 %s %s %s - %s - %s",
+		pri,
+		event.OccurredAt.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		hostname,
+		app,
+		syslogHeaderField(event.Type, 32),
+		body)
+}
+
+func syslogSeverity(eventType string) int {
+	switch {
+	case eventType == TypeLoginFailed,
+		eventType == TypeRefreshTokenReused,
+		eventType == TypeRefreshDeviceMismatch:
+		return syslogSeverityWarning
+	case strings.HasPrefix(eventType, TypeAuditPrefix):
+		return syslogSeverityNotice
+	default:
+		return syslogSeverityInfo
+	}
+}
+
+// syslogHeaderField makes s a valid header field: printable ASCII
+// without spaces, at most maxLen bytes, "-" if empty
+func syslogHeaderField(s string, maxLen int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
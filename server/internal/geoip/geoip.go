@@ -0,0 +1,52 @@
+// Package geoip turns client IP addresses into coarse "city, country"
+// summaries using an offline MaxMind database. No external calls are made.
+package geoip
+
+import (
+	"net/netip"
+)
+
+// Fallback summaries
+const (
+	SummaryPrivate = "private network"
+	SummaryUnknown = "unknown location"
+)
+
+// Location is the coarse location of an IP address
+type Location struct {
+	City    string
+	Country string
+}
+
+// Locator resolves IP addresses to locations
+type Locator interface {
+	Lookup(ip netip.Addr) (Location, bool)
+}
+
+// Summary describes where ip is, e.g. "Berlin, Germany". It returns an
+// empty string if ip is not a valid address or locator is nil (GeoIP
+// disabled), SummaryPrivate for non-routable addresses and SummaryUnknown
+// if the address is not in the database.
+func Summary(locator Locator, ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || locator == nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return SummaryPrivate
+	}
+
+	loc, ok := locator.Lookup(addr)
+	if !ok {
+		return SummaryUnknown
+	}
+	switch {
+	case loc.City != "" && loc.Country != "":
+		return loc.City + ", " + loc.Country
+	case loc.Country != "":
+		return loc.Country
+	default:
+		return SummaryUnknown
+	}
+}
@@ -0,0 +1,130 @@
+package geoip
+
+import (
+	"net/netip"
+	"testing"
+)
+
+type fakeLocator map[string]Location
+
+func (f fakeLocator) Lookup(ip netip.Addr) (Location, bool) {
+	loc, ok := f[ip.String()]
+	return loc, ok
+}
+
+func TestSummary(t *testing.T) {
+	locator := fakeLocator{
+		"203.0.113.7":  {City: "Berlin", Country: "Germany"},
+		"198.51.100.1": {Country: "France"},
+	}
+
+	tests := []struct {
+		name    string
+		locator Locator
+		ip      string
+		want    string
+	}{
+		{"hit", locator, "203.0.113.7", "Berlin, Germany"},
+		{"country only", locator, "198.51.100.1", "France"},
+		{"ipv4-mapped", locator, "::ffff:203.0.113.7", "Berlin, Germany"},
+		{"miss", locator, "192.0.2.55", SummaryUnknown},
+		{"private", locator, "10.1.2.3", SummaryPrivate},
+		{"loopback", locator, "127.0.0.1", SummaryPrivate},
+		{"ipv6 private", locator, "fd00::1", SummaryPrivate},
+		{"disabled", nil, "203.0.113.7", ""},
+		{"invalid", locator, "not-an-ip", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Summary(tt.locator, tt.ip); got != tt.want {
+				t.Errorf("Summary(%q) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+// mmdb encoding helpers for building a tiny test database
+
+func encodeString(s string) []byte {
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encodeUint(typ byte, v uint32) []byte {
+	return []byte{typ<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func encodeMap(pairs ...[]byte) []byte {
+	out := []byte{byte(typeMap<<5 | len(pairs)/2)}
+	for _, p := range pairs {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func names(en string) []byte {
+	return encodeMap(encodeString("names"), encodeMap(encodeString("en"), encodeString(en)))
+}
+
+// buildIPv4DB maps exactly 203.0.113.0/24 to Berlin, Germany
+func buildIPv4DB() []byte {
+	const prefixBits = 24
+	prefix := netip.MustParseAddr("203.0.113.0").As4()
+	nodeCount := uint32(prefixBits)
+
+	var tree []byte
+	for i := 0; i < prefixBits; i++ {
+		next := uint32(i + 1)
+		if i == prefixBits-1 {
+			next = nodeCount + dataSectionSeparator // data offset 0
+		}
+		left, right := nodeCount, nodeCount
+		if prefix[i/8]>>(7-uint(i%8))&1 == 1 {
+			right = next
+		} else {
+			left = next
+		}
+		tree = append(tree, byte(left>>16), byte(left>>8), byte(left),
+			byte(right>>16), byte(right>>8), byte(right))
+	}
+
+	data := encodeMap(
+		encodeString("city"), names("Berlin"),
+		encodeString("country"), names("Germany"),
+	)
+	meta := encodeMap(
+		encodeString("node_count"), encodeUint(typeUint32, nodeCount),
+		encodeString("record_size"), encodeUint(typeUint16, 24),
+		encodeString("ip_version"), encodeUint(typeUint16, 4),
+	)
+
+	buf := append(tree, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	return append(buf, meta...)
+}
+
+func TestDBLookup(t *testing.T) {
+	db, err := newDB(buildIPv4DB())
+	if err != nil {
+		t.Fatalf("newDB: %v", err)
+	}
+
+	if got := Summary(db, "203.0.113.7"); got != "Berlin, Germany" {
+		t.Errorf("hit: got %q", got)
+	}
+	if got := Summary(db, "198.51.100.1"); got != SummaryUnknown {
+		t.Errorf("miss: got %q", got)
+	}
+	if got := Summary(db, "2001:db8::1"); got != SummaryUnknown {
+		t.Errorf("ipv6 in ipv4 database: got %q", got)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	if _, err := newDB([]byte("not a database")); err == nil {
+		t.Fatal("expected error for invalid database")
+	}
+	if _, err := Open("/nonexistent/GeoLite2-City.mmdb"); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
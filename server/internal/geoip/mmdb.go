@@ -0,0 +1,314 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+var (
+	ErrInvalidDatabase = errors.New("invalid MaxMind database")
+)
+
+// metadataMarker precedes the metadata section at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the gap between search tree and data section
+const dataSectionSeparator = 16
+
+// DB is a read-only MaxMind DB (GeoLite2/GeoIP2 City or Country)
+type DB struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// Open loads a MaxMind DB file into memory
+func Open(path string) (*DB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newDB(buf)
+}
+
+func newDB(buf []byte) (*DB, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, ErrInvalidDatabase
+	}
+	meta, _, err := decode(buf[i+len(metadataMarker):], 0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	db := &DB{
+		nodeCount:  uintField(m, "node_count"),
+		recordSize: uintField(m, "record_size"),
+		ipVersion:  uintField(m, "ip_version"),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%w: record size %d", ErrInvalidDatabase, db.recordSize)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(i) {
+		return nil, ErrInvalidDatabase
+	}
+	db.buf = buf[:treeSize]
+	db.data = buf[treeSize+dataSectionSeparator : i]
+
+	// IPv4 addresses live below ::/96 in IPv6 databases
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for range 96 {
+			if node >= db.nodeCount {
+				break
+			}
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Lookup implements Locator
+func (db *DB) Lookup(ip netip.Addr) (Location, bool) {
+	record, ok := db.find(ip)
+	if !ok {
+		return Location{}, false
+	}
+	loc := Location{
+		City:    englishName(record, "city"),
+		Country: englishName(record, "country"),
+	}
+	if loc.Country == "" {
+		loc.Country = englishName(record, "registered_country")
+	}
+	return loc, loc.Country != ""
+}
+
+// find walks the search tree and decodes the matching data record
+func (db *DB) find(ip netip.Addr) (map[string]any, bool) {
+	node := uint(0)
+	addr := ip.AsSlice()
+	if ip.Is4() {
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if db.ipVersion == 4 {
+		return nil, false
+	}
+
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return nil, false
+	}
+
+	offset := node - db.nodeCount - dataSectionSeparator
+	value, _, err := decode(db.data, offset)
+	if err != nil {
+		return nil, false
+	}
+	record, ok := value.(map[string]any)
+	return record, ok
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a node
+func (db *DB) record(node, bit uint) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		o := bit * 3
+		return uint(b[o])<<16 | uint(b[o+1])<<8 | uint(b[o+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Data section field types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// decode decodes the value at offset in data and returns it together with
+// the offset of the next value
+func decode(data []byte, offset uint) (any, uint, error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, ErrInvalidDatabase
+	}
+	ctrl := data[offset]
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		return decodePointer(data, ctrl, offset)
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(data)) {
+			return nil, 0, ErrInvalidDatabase
+		}
+		typ = 7 + uint(data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, ErrInvalidDatabase
+		}
+		v := uint(0)
+		for _, b := range data[offset : offset+n] {
+			v = v<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + v
+		case 30:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for range size {
+			key, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidDatabase
+			}
+			value, next, err := decode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for range size {
+			value, next, err := decode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, ErrInvalidDatabase
+	}
+	b := data[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes, typeUint128:
+		return b, offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown type %d", ErrInvalidDatabase, typ)
+}
+
+// decodePointer follows a pointer and returns the offset after the pointer itself
+func decodePointer(data []byte, ctrl byte, offset uint) (any, uint, error) {
+	size := uint(ctrl>>3)&0x3 + 1
+	if offset+size > uint(len(data)) {
+		return nil, 0, ErrInvalidDatabase
+	}
+	b := data[offset : offset+size]
+
+	var target uint
+	switch size {
+	case 1:
+		target = uint(ctrl&0x7)<<8 | uint(b[0])
+	case 2:
+		target = (uint(ctrl&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		target = (uint(ctrl&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		target = uint(binary.BigEndian.Uint32(b))
+	}
+
+	value, _, err := decode(data, target)
+	return value, offset + size, err
+}
+
+// uintField reads an unsigned integer from a decoded map
+func uintField(m map[string]any, key string) uint {
+	v, _ := m[key].(uint64)
+	return uint(v)
+}
+
+// englishName returns record[key].names.en
+func englishName(record map[string]any, key string) string {
+	entry, _ := record[key].(map[string]any)
+	names, _ := entry["names"].(map[string]any)
+	name, _ := names["en"].(string)
+	return name
+}
@@ -1,34 +1,76 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
 )
 
 // AdminHandler handles admin endpoints
 type AdminHandler struct {
-	userRepo    *repository.UserRepository
-	deviceRepo  *repository.DeviceRepository
-	vaultRepo   *repository.VaultRepository
-	refreshRepo *repository.RefreshTokenRepository
+	userRepo   *repository.UserRepository
+	userList   adminUserList
+	deviceRepo *repository.DeviceRepository
+	vaultRepo  *repository.VaultRepository
+	auditRepo  *repository.AuditRepository
+	userAdmin  *service.UserAdmin
+	migration  *service.VaultMigration
+	streams    *stream.Hub
+	exports    *service.AccountExport
+
+	appVersions models.AppVersionPolicy
 }
 
+// Audit log page sizes
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// User list page sizes
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
 // NewAdminHandler creates a new admin handler
 func NewAdminHandler(
 	userRepo *repository.UserRepository,
 	deviceRepo *repository.DeviceRepository,
 	vaultRepo *repository.VaultRepository,
-	refreshRepo *repository.RefreshTokenRepository,
+	auditRepo *repository.AuditRepository,
+	userAdmin *service.UserAdmin,
+	migration *service.VaultMigration,
+	streams *stream.Hub,
+	exports *service.AccountExport,
+	appVersions models.AppVersionPolicy,
 ) *AdminHandler {
 	return &AdminHandler{
-		userRepo:    userRepo,
-		deviceRepo:  deviceRepo,
-		vaultRepo:   vaultRepo,
-		refreshRepo: refreshRepo,
+		userRepo:   userRepo,
+		userList:   userRepo,
+		deviceRepo: deviceRepo,
+		vaultRepo:  vaultRepo,
+		auditRepo:  auditRepo,
+		userAdmin:  userAdmin,
+		migration:  migration,
+		streams:    streams,
+		exports:    exports,
+
+		appVersions: appVersions,
 	}
 }
 
@@ -38,158 +80,560 @@ func (h *AdminHandler) Dashboard(c *gin.Context) {
 
 	total, approved, pending, blocked, err := h.userRepo.Count(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user stats"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get user stats", nil)
 		return
 	}
 
 	deviceCount, _ := h.deviceRepo.Count(ctx)
 	vaultCount, _ := h.vaultRepo.Count(ctx)
+	deletedVaults, _ := h.vaultRepo.CountDeleted(ctx)
+	oldestPending, _ := h.userRepo.OldestPendingCreatedAt(ctx)
+	avgApproval, _ := h.userRepo.AverageApprovalSeconds(ctx)
+	appVersions, _ := h.deviceRepo.CountByAppVersion(ctx)
+	h.appVersions.MarkOutdated(appVersions)
+
+	migration := gin.H{}
+	if progress, err := h.migration.Progress(ctx); err == nil {
+		migration["vault_versions"] = progress.Versions
+		if progress.Campaign.Active() {
+			migration["target_version"] = progress.Campaign.TargetVersion
+			migration["deadline"] = progress.Campaign.Deadline.Unix()
+			migration["refuse_old_pushes"] = progress.Campaign.RefuseOldPushes
+			migration["migrated"] = progress.Migrated
+			migration["percent"] = progress.Percent()
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"users": gin.H{
-			"total":    total,
-			"approved": approved,
-			"pending":  pending,
-			"blocked":  blocked,
+			"total":                  total,
+			"approved":               approved,
+			"pending":                pending,
+			"blocked":                blocked,
+			"oldest_pending_seconds": pendingAgeSeconds(oldestPending),
+			"avg_approval_seconds":   avgApproval,
 		},
-		"devices": deviceCount,
-		"vaults":  vaultCount,
+		"devices":        deviceCount,
+		"app_versions":   appVersions,
+		"vaults":         vaultCount,
+		"deleted_vaults": deletedVaults,
+		"migration":      migration,
+		"streams":        h.streams.Total(),
 	})
 }
 
-// ListUsers returns all users
+// adminUserList is the subset of UserRepository needed to page through
+// users
+type adminUserList interface {
+	ListPage(ctx context.Context, q models.UserListQuery) ([]models.User, error)
+	CountMatching(ctx context.Context, q models.UserListQuery) (int, error)
+	OldestPendingCreatedAt(ctx context.Context) (*time.Time, error)
+}
+
+// adminUserStatuses maps the ?status= values of the user list to the
+// statuses of UserListQuery
+var adminUserStatuses = map[string]string{
+	"":                       "",
+	"approved":               models.UserStatusActive,
+	models.UserStatusActive:  models.UserStatusActive,
+	models.UserStatusPending: models.UserStatusPending,
+	models.UserStatusBlocked: models.UserStatusBlocked,
+	models.UserStatusAdmin:   models.UserStatusAdmin,
+}
+
+// ListUsers returns one page of users, newest first. ?search= matches a
+// part of the email, ?status= is pending, approved, blocked or admin, and
+// ?page= and ?per_page= select the page.
 func (h *AdminHandler) ListUsers(c *gin.Context) {
-	users, err := h.userRepo.List(c.Request.Context())
+	q, page, ok := bindUserListQuery(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	total, err := h.userList.CountMatching(ctx, q)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list users", nil)
 		return
 	}
+	users, err := h.userList.ListPage(ctx, q)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list users", nil)
+		return
+	}
+	oldestPending, _ := h.userList.OldestPendingCreatedAt(ctx)
 
 	// Strip sensitive data
-	type userResponse struct {
-		ID          uuid.UUID  `json:"id"`
-		Email       string     `json:"email"`
-		IsApproved  bool       `json:"is_approved"`
-		IsAdmin     bool       `json:"is_admin"`
-		IsBlocked   bool       `json:"is_blocked"`
-		TOTPEnabled bool       `json:"totp_enabled"`
-		CreatedAt   string     `json:"created_at"`
-		LastLoginAt *string    `json:"last_login_at,omitempty"`
-	}
-
-	response := make([]userResponse, len(users))
-	for i, u := range users {
-		var lastLogin *string
-		if u.LastLoginAt != nil {
-			s := u.LastLoginAt.Format("2006-01-02T15:04:05Z")
-			lastLogin = &s
+	response := make([]models.AdminUser, len(users))
+	for i := range users {
+		response[i] = models.NewAdminUser(&users[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":                  response,
+		"total":                  total,
+		"page":                   page,
+		"per_page":               q.Limit,
+		"total_pages":            (total + q.Limit - 1) / q.Limit,
+		"oldest_pending_seconds": pendingAgeSeconds(oldestPending),
+	})
+}
+
+// bindUserListQuery reads the filter and page of the user list. It
+// responds and returns false if they are invalid.
+func bindUserListQuery(c *gin.Context) (q models.UserListQuery, page int, ok bool) {
+	var errs input.Errors
+	status, known := adminUserStatuses[c.Query("status")]
+	if !known {
+		errs = append(errs, input.FieldError{Field: "status", Code: input.CodeInvalid, Message: "must be pending, approved, blocked or admin"})
+	}
+	search, err := input.Clean("search", input.KindEmail, strings.TrimSpace(c.Query("search")))
+	var field *input.FieldError
+	if errors.As(err, &field) {
+		errs = append(errs, *field)
+	}
+	page, perPage := 1, defaultUserPageSize
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			errs = append(errs, input.FieldError{Field: "page", Code: input.CodeInvalid, Message: "must be a positive number"})
 		}
-		response[i] = userResponse{
-			ID:          u.ID,
-			Email:       u.Email,
-			IsApproved:  u.IsApproved,
-			IsAdmin:     u.IsAdmin,
-			IsBlocked:   u.IsBlocked,
-			TOTPEnabled: u.TOTPEnabled,
-			CreatedAt:   u.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			LastLoginAt: lastLogin,
+	}
+	if v := c.Query("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 {
+			errs = append(errs, input.FieldError{Field: "per_page", Code: input.CodeInvalid, Message: "must be a positive number"})
+		}
+		perPage = min(perPage, maxUserPageSize)
+	}
+	if len(errs) > 0 {
+		apierror.RespondInvalidInput(c, errs)
+		return q, 0, false
+	}
+
+	return models.UserListQuery{Status: status, Search: search, Limit: perPage, Offset: (page - 1) * perPage}, page, true
+}
+
+// pendingAgeSeconds returns how long ago createdAt was, or nil
+func pendingAgeSeconds(createdAt *time.Time) *int64 {
+	if createdAt == nil {
+		return nil
+	}
+	age := int64(time.Since(*createdAt).Seconds())
+	return &age
+}
+
+// adminMutationRequest is the optional body of approve and block
+type adminMutationRequest struct {
+	Unblock bool   `json:"unblock"`               // approve: also unblock a blocked user
+	Blocked *bool  `json:"blocked,omitempty"`     // block: legacy toggle, false unblocks
+	Reason  string `json:"reason" input:"reason"` // block: why the user is blocked
+	Confirm bool   `json:"confirm"`               // delete: legacy confirmation
+}
+
+// adminRoleRequest is the body of a role change
+type adminRoleRequest struct {
+	IsAdmin *bool `json:"is_admin" binding:"required"`
+}
+
+// bindOptionalJSON binds the request body if there is one
+func bindOptionalJSON(c *gin.Context, req interface{}) bool {
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+	if err := c.ShouldBindJSON(req); err != nil {
+		apierror.RespondBindError(c, err)
+		return false
+	}
+	return true
+}
+
+// adminTarget parses the target user ID and the acting admin's ID
+func adminTarget(c *gin.Context) (actorID, userID uuid.UUID, ok bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid user ID", nil)
+		return uuid.Nil, uuid.Nil, false
+	}
+	actorID, _ = middleware.GetUserID(c)
+	return actorID, userID, true
+}
+
+// respondUserMutation writes the updated user or maps the error
+func respondUserMutation(c *gin.Context, message string, user *models.User, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+		case errors.Is(err, service.ErrUserAlreadyApproved):
+			apierror.RespondError(c, http.StatusConflict, "USER_ALREADY_APPROVED", "user already approved", nil)
+		case errors.Is(err, service.ErrUserNotApproved):
+			apierror.RespondError(c, http.StatusConflict, "USER_NOT_APPROVED", "user is not approved", nil)
+		case errors.Is(err, service.ErrUserBlocked):
+			apierror.RespondError(c, http.StatusConflict, "USER_BLOCKED", "user is blocked, unblock first or set unblock", nil)
+		case errors.Is(err, service.ErrUserAlreadyBlocked):
+			apierror.RespondError(c, http.StatusConflict, "USER_ALREADY_BLOCKED", "user already blocked", nil)
+		case errors.Is(err, service.ErrUserNotBlocked):
+			apierror.RespondError(c, http.StatusConflict, "USER_NOT_BLOCKED", "user is not blocked", nil)
+		case errors.Is(err, service.ErrCannotBlockAdmin):
+			apierror.RespondError(c, http.StatusConflict, "CANNOT_BLOCK_ADMIN", "cannot block admin users", nil)
+		case errors.Is(err, service.ErrBlockReasonTooLong):
+			apierror.RespondError(c, http.StatusBadRequest, "REASON_TOO_LONG", "block reason too long", nil)
+		case errors.Is(err, service.ErrCannotUnapproveAdmin):
+			apierror.RespondError(c, http.StatusConflict, "CANNOT_UNAPPROVE_ADMIN", "cannot unapprove admin users", nil)
+		case errors.Is(err, service.ErrUserAlreadyAdmin):
+			apierror.RespondError(c, http.StatusConflict, "USER_ALREADY_ADMIN", "user is already an admin", nil)
+		case errors.Is(err, service.ErrUserNotAdmin):
+			apierror.RespondError(c, http.StatusConflict, "USER_NOT_ADMIN", "user is not an admin", nil)
+		case errors.Is(err, repository.ErrLastAdmin):
+			apierror.RespondError(c, http.StatusConflict, "LAST_ADMIN", "cannot demote the last admin", nil)
+		default:
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to update user", nil)
 		}
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"users": response})
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"user":    models.NewAdminUser(user),
+	})
 }
 
-// ApproveUser approves a user
+// ApproveUser approves a user. Blocked users require {"unblock": true}.
 func (h *AdminHandler) ApproveUser(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	var req adminMutationRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+
+	user, err := h.userAdmin.Approve(c.Request.Context(), actorID, userID, req.Unblock)
+	respondUserMutation(c, "user approved", user, err)
+}
+
+// UnapproveUser moves an approved user back to pending
+func (h *AdminHandler) UnapproveUser(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userAdmin.Unapprove(c.Request.Context(), actorID, userID)
+	respondUserMutation(c, "user unapproved", user, err)
+}
+
+// RejectUser rejects (deletes) a user that is still pending approval
+func (h *AdminHandler) RejectUser(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userAdmin.Reject(c.Request.Context(), actorID, userID)
+	if errors.Is(err, service.ErrUserAlreadyApproved) {
+		apierror.RespondError(c, http.StatusConflict, "USER_ALREADY_APPROVED", "cannot reject approved user", nil)
+		return
+	}
+	respondUserMutation(c, "user rejected", user, err)
+}
+
+// GetUser returns a single user with devices, vault metadata and recent activity
+func (h *AdminHandler) GetUser(c *gin.Context) {
 	userIDStr := c.Param("id")
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid user ID", nil)
 		return
 	}
 
-	if err := h.userRepo.SetApproved(c.Request.Context(), userID, true); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve user"})
+	detail, err := h.userAdmin.Detail(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+			return
+		}
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get user", nil)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "user approved"})
+	c.JSON(http.StatusOK, detail)
 }
 
-// BlockUser blocks or unblocks a user
-func (h *AdminHandler) BlockUser(c *gin.Context) {
-	userIDStr := c.Param("id")
-	userID, err := uuid.Parse(userIDStr)
+// GetUserVault returns the vault metadata and recent sync activity of a
+// user. The blob itself is never returned.
+func (h *AdminHandler) GetUserVault(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid user ID", nil)
 		return
 	}
 
-	var req struct {
-		Blocked bool `json:"blocked"`
+	vault, err := h.userAdmin.Vault(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+			return
+		}
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault", nil)
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+
+	c.JSON(http.StatusOK, vault)
+}
+
+// BlockUser blocks a user. The legacy body {"blocked": false} unblocks.
+func (h *AdminHandler) BlockUser(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	var req adminMutationRequest
+	if !bindOptionalJSON(c, &req) {
 		return
 	}
 
-	if err := h.userRepo.SetBlocked(c.Request.Context(), userID, req.Blocked); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
+	if req.Blocked != nil && !*req.Blocked {
+		user, err := h.userAdmin.Unblock(c.Request.Context(), actorID, userID)
+		respondUserMutation(c, "user unblocked", user, err)
 		return
 	}
 
-	// Revoke all tokens if blocking
-	if req.Blocked {
-		_ = h.refreshRepo.RevokeAllForUser(c.Request.Context(), userID)
+	user, err := h.userAdmin.Block(c.Request.Context(), actorID, userID, req.Reason)
+	respondUserMutation(c, "user blocked", user, err)
+}
+
+// SetUserRole promotes a user to admin or demotes an admin with
+// {"is_admin": bool}. The user's sessions are revoked so they sign in
+// again with the new role.
+func (h *AdminHandler) SetUserRole(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	var req adminRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
 	}
 
-	action := "unblocked"
-	if req.Blocked {
-		action = "blocked"
+	message := "user demoted"
+	if *req.IsAdmin {
+		message = "user promoted to admin"
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "user " + action})
+	user, err := h.userAdmin.SetAdmin(c.Request.Context(), actorID, userID, *req.IsAdmin)
+	respondUserMutation(c, message, user, err)
 }
 
-// DeleteUser deletes a user and all their data
-func (h *AdminHandler) DeleteUser(c *gin.Context) {
-	userIDStr := c.Param("id")
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+// UnblockUser unblocks a user
+func (h *AdminHandler) UnblockUser(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
 		return
 	}
 
-	var req struct {
-		Confirm bool `json:"confirm"`
+	user, err := h.userAdmin.Unblock(c.Request.Context(), actorID, userID)
+	respondUserMutation(c, "user unblocked", user, err)
+}
+
+// DeleteUser deletes a user and all their data. Requires ?confirm=true
+// (or the legacy body {"confirm": true}).
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || !req.Confirm {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "confirmation required"})
+	var req adminMutationRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+	if c.Query("confirm") != "true" && !req.Confirm {
+		apierror.RespondError(c, http.StatusBadRequest, "CONFIRMATION_REQUIRED", "confirmation required", nil)
 		return
 	}
 
 	// Delete user (cascade deletes devices, vault, tokens, etc.)
-	if err := h.userRepo.Delete(c.Request.Context(), userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete user"})
+	user, err := h.userAdmin.Delete(c.Request.Context(), actorID, userID)
+	respondUserMutation(c, "user deleted", user, err)
+}
+
+// BulkUsers applies one action to several users and reports the outcome
+// per user. Blocking and deleting need {"confirm": true}; admins are
+// skipped by both.
+func (h *AdminHandler) BulkUsers(c *gin.Context) {
+	var req models.BulkUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
 		return
 	}
+	if (req.Action == models.BulkBlock || req.Action == models.BulkDelete) && !req.Confirm {
+		apierror.RespondError(c, http.StatusBadRequest, "CONFIRMATION_REQUIRED", "confirmation required", nil)
+		return
+	}
+	actorID, _ := middleware.GetUserID(c)
+
+	results, err := h.userAdmin.Bulk(c.Request.Context(), actorID, req.Action, req.UserIDs)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrUnknownBulkAction):
+		apierror.RespondInvalidInput(c, input.Errors{{Field: "action", Code: input.CodeInvalid, Message: "must be approve, block, unblock or delete"}})
+		return
+	case errors.Is(err, service.ErrTooManyUsers):
+		apierror.RespondInvalidInput(c, input.Errors{{Field: "user_ids", Code: input.CodeTooLong, Message: "has too many users", Max: service.MaxBulkUsers}})
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to update users", nil)
+		return
+	}
+
+	counts := map[string]int{models.BulkStatusDone: 0, models.BulkStatusSkipped: 0, models.BulkStatusNotFound: 0}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	c.JSON(http.StatusOK, gin.H{"action": req.Action, "results": results, "counts": counts})
+}
+
+// ListAudit returns audit events, newest first. Use ?before=<RFC3339>
+// with the created_at of the last event to fetch the next page.
+func (h *AdminHandler) ListAudit(c *gin.Context) {
+	limit, before, ok := bindAuditPage(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.auditRepo.List(c.Request.Context(), before, limit)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list audit events", nil)
+		return
+	}
+	if events == nil {
+		events = []models.AuditEvent{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// bindAuditPage reads the ?limit= and ?before= paging parameters of
+// event listings. It responds and returns false if they are invalid.
+func bindAuditPage(c *gin.Context) (limit int, before *time.Time, ok bool) {
+	limit = defaultAuditPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid limit", nil)
+			return 0, nil, false
+		}
+		limit = min(n, maxAuditPageSize)
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "user deleted"})
+	if v := c.Query("before"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid before timestamp", nil)
+			return 0, nil, false
+		}
+		before = &t
+	}
+	return limit, before, true
 }
 
-// GetUserDevices returns devices for a specific user
+// GetUserDevices returns the devices of a user with their active token
+// counts and last activity
 func (h *AdminHandler) GetUserDevices(c *gin.Context) {
-	userIDStr := c.Param("id")
-	userID, err := uuid.Parse(userIDStr)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid user ID", nil)
+		return
+	}
+
+	devices, err := h.userAdmin.Devices(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+			return
+		}
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get devices", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
+
+// GetUserSessions lists the active sessions of a user with their devices
+func (h *AdminHandler) GetUserSessions(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid user ID", nil)
 		return
 	}
 
-	devices, err := h.deviceRepo.GetByUserID(c.Request.Context(), userID)
+	sessions, err := h.userAdmin.Sessions(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get devices"})
+		if errors.Is(err, repository.ErrUserNotFound) {
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+			return
+		}
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get sessions", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeUserSession ends one session of a user. The device stays
+// registered and can sign in again.
+func (h *AdminHandler) RevokeUserSession(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	sessionID, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid session ID", nil)
+		return
+	}
+
+	session, err := h.userAdmin.RevokeSession(c.Request.Context(), actorID, userID, sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+		case errors.Is(err, repository.ErrRefreshTokenNotFound):
+			apierror.RespondError(c, http.StatusNotFound, "SESSION_NOT_FOUND", "session not found", nil)
+		default:
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to revoke session", nil)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked", "session": session})
+}
+
+// DeleteUserDevice revokes the sessions of one of a user's devices and
+// deletes it
+func (h *AdminHandler) DeleteUserDevice(c *gin.Context) {
+	actorID, userID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+	deviceID, err := uuid.Parse(c.Param("deviceId"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid device ID", nil)
+		return
+	}
+
+	device, err := h.userAdmin.DeleteDevice(c.Request.Context(), actorID, userID, deviceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+		case errors.Is(err, repository.ErrDeviceNotFound):
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "device not found", nil)
+		default:
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to delete device", nil)
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"devices": devices})
+	c.JSON(http.StatusOK, gin.H{"message": "device deleted", "device": device})
 }
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+)
+
+// ExportUsers streams the users matching the user list filters as CSV
+func (h *AdminHandler) ExportUsers(c *gin.Context) {
+	q, _, ok := bindUserListQuery(c)
+	if !ok {
+		return
+	}
+	streamCSV(c, "users", func(w io.Writer) error {
+		return h.exports.WriteUsers(c.Request.Context(), w, q)
+	})
+}
+
+// ExportSyncLogs streams the sync activity of the users matching the user
+// list filters as CSV
+func (h *AdminHandler) ExportSyncLogs(c *gin.Context) {
+	q, _, ok := bindUserListQuery(c)
+	if !ok {
+		return
+	}
+	streamCSV(c, "sync-logs", func(w io.Writer) error {
+		return h.exports.WriteSyncLogs(c.Request.Context(), w, q)
+	})
+}
+
+// streamCSV sends the CSV written by write as a dated download. Errors
+// before the first row went out get a 500; later ones cut the download
+// short and are only logged.
+func streamCSV(c *gin.Context, name string, write func(io.Writer) error) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="vibedterm-`+name+"-"+time.Now().UTC().Format("2006-01-02")+`.csv"`)
+	c.Header("Cache-Control", "no-store")
+
+	if err := write(c.Writer); err != nil {
+		log.Error().Err(err).Str("export", name).Msg("Failed to export CSV")
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to export "+name, nil)
+		}
+	}
+}
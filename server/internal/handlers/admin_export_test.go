@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// exportUsers serves fixed export rows and records the filter
+type exportUsers struct {
+	rows []models.UserExportRow
+	q    *models.UserListQuery
+}
+
+func (e exportUsers) EachForExport(_ context.Context, q models.UserListQuery, fn func(*models.UserExportRow) error) error {
+	*e.q = q
+	for i := range e.rows {
+		if err := fn(&e.rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExportUsers(t *testing.T) {
+	var q models.UserListQuery
+	users := exportUsers{rows: []models.UserExportRow{{Email: `"a,b"@example.com`, IsApproved: true}}, q: &q}
+	h := &AdminHandler{exports: service.NewAccountExport(users, nil)}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/export/users.csv?status=blocked&search=example", nil)
+	h.ExportUsers(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="vibedterm-users-`) {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if q.Status != models.UserStatusBlocked || q.Search != "example" {
+		t.Errorf("filter = %+v, want blocked users matching example", q)
+	}
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], `"""a,b""@example.com",`) {
+		t.Errorf("body = %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/export/users.csv?status=bogus", nil)
+	h.ExportUsers(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid filter: status = %d", w.Code)
+	}
+}
+
+// failingExportUsers fails before the first row
+type failingExportUsers struct{}
+
+func (failingExportUsers) EachForExport(context.Context, models.UserListQuery, func(*models.UserExportRow) error) error {
+	return errors.New("db down")
+}
+
+// csv.Writer buffers the header, so a query that fails before any row
+// still gets a JSON error instead of a CSV download
+func TestExportUsers_ErrorBeforeRows(t *testing.T) {
+	h := &AdminHandler{exports: service.NewAccountExport(failingExportUsers{}, nil)}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/export/users.csv", nil)
+	h.ExportUsers(c)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "" {
+		t.Errorf("Content-Disposition = %q, want none", cd)
+	}
+}
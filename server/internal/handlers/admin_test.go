@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// stubAdminUsers serves a fixed set of users to the admin service
+type stubAdminUsers map[uuid.UUID]*models.User
+
+func (s stubAdminUsers) GetByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	if u, ok := s[id]; ok {
+		return u, nil
+	}
+	return nil, repository.ErrUserNotFound
+}
+func (s stubAdminUsers) SetApproved(_ context.Context, id uuid.UUID, approved bool, by *uuid.UUID) error {
+	u := s[id]
+	u.IsApproved, u.ApprovedAt, u.ApprovedBy = approved, nil, nil
+	if approved {
+		now := time.Now()
+		u.ApprovedAt, u.ApprovedBy = &now, by
+	}
+	return nil
+}
+func (s stubAdminUsers) SetBlocked(_ context.Context, id uuid.UUID, blocked bool, reason string) error {
+	u := s[id]
+	u.IsBlocked, u.BlockedAt, u.BlockedReason = blocked, nil, ""
+	if blocked {
+		now := time.Now()
+		u.BlockedAt, u.BlockedReason = &now, reason
+	}
+	return nil
+}
+func (s stubAdminUsers) SetAdmin(_ context.Context, id uuid.UUID, admin bool) error {
+	if !admin {
+		last := true
+		for _, u := range s {
+			if u.IsAdmin && u.ID != id {
+				last = false
+			}
+		}
+		if last {
+			return repository.ErrLastAdmin
+		}
+	}
+	s[id].IsAdmin = admin
+	return nil
+}
+func (s stubAdminUsers) BulkUpdate(_ context.Context, action string, ids []uuid.UUID, _ uuid.UUID) ([]uuid.UUID, error) {
+	for _, id := range ids {
+		switch action {
+		case models.BulkApprove:
+			s[id].IsApproved = true
+		case models.BulkBlock, models.BulkUnblock:
+			s[id].IsBlocked = action == models.BulkBlock
+		case models.BulkDelete:
+			delete(s, id)
+		}
+	}
+	return ids, nil
+}
+func (s stubAdminUsers) Delete(_ context.Context, id uuid.UUID) error {
+	delete(s, id)
+	return nil
+}
+
+type nopAudit struct{}
+
+func (nopAudit) Create(context.Context, *models.AuditEvent) error { return nil }
+
+type nopTokens struct{}
+
+func (nopTokens) RevokeAllForUser(context.Context, uuid.UUID) error   { return nil }
+func (nopTokens) RevokeAllForDevice(context.Context, uuid.UUID) error { return nil }
+func (nopTokens) RevokeByID(context.Context, uuid.UUID, uuid.UUID) error {
+	return repository.ErrRefreshTokenNotFound
+}
+func (nopTokens) GetActiveByUserID(context.Context, uuid.UUID) ([]models.Session, error) {
+	return nil, nil
+}
+
+func newAdminTestHandler(users stubAdminUsers) *AdminHandler {
+	return &AdminHandler{userAdmin: service.NewUserAdmin(users, nil, nil, nopTokens{}, nil, nopAudit{})}
+}
+
+func callWithID(handler gin.HandlerFunc, id string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	handler(c)
+	return w
+}
+
+func TestRejectUser_ApprovedUserConflict(t *testing.T) {
+	id := uuid.New()
+	users := stubAdminUsers{id: {ID: id, IsApproved: true}}
+	h := newAdminTestHandler(users)
+
+	w := callWithID(h.RejectUser, id.String())
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if _, ok := users[id]; !ok {
+		t.Error("approved user was deleted")
+	}
+}
+
+func TestRejectUser_PendingUser(t *testing.T) {
+	id := uuid.New()
+	users := stubAdminUsers{id: {ID: id}}
+	h := newAdminTestHandler(users)
+
+	w := callWithID(h.RejectUser, id.String())
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if _, ok := users[id]; ok {
+		t.Error("pending user was not deleted")
+	}
+}
+
+func TestRejectUser_NotFoundAndInvalidID(t *testing.T) {
+	h := newAdminTestHandler(stubAdminUsers{})
+
+	if w := callWithID(h.RejectUser, uuid.New().String()); w.Code != http.StatusNotFound {
+		t.Errorf("unknown user status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := callWithID(h.RejectUser, "not-a-uuid"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetUserVault_NotFoundAndInvalidID(t *testing.T) {
+	h := newAdminTestHandler(stubAdminUsers{})
+
+	if w := callWithID(h.GetUserVault, uuid.New().String()); w.Code != http.StatusNotFound {
+		t.Errorf("unknown user status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := callWithID(h.GetUserVault, "not-a-uuid"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteUserDevice_NotFoundAndInvalidID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := newAdminTestHandler(stubAdminUsers{})
+
+	call := func(userID, deviceID string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("DELETE", "/", nil)
+		c.Params = gin.Params{{Key: "id", Value: userID}, {Key: "deviceId", Value: deviceID}}
+		h.DeleteUserDevice(c)
+		return w.Code
+	}
+
+	if code := call(uuid.New().String(), uuid.New().String()); code != http.StatusNotFound {
+		t.Errorf("unknown user status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := call(uuid.New().String(), "not-a-uuid"); code != http.StatusBadRequest {
+		t.Errorf("invalid device ID status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestRevokeUserSession_NotFoundAndInvalidID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	id := uuid.New()
+	h := newAdminTestHandler(stubAdminUsers{id: {ID: id, Email: "s@example.com"}})
+
+	call := func(userID, sessionID string) (int, string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("DELETE", "/", nil)
+		c.Params = gin.Params{{Key: "id", Value: userID}, {Key: "sessionId", Value: sessionID}}
+		h.RevokeUserSession(c)
+		return w.Code, w.Body.String()
+	}
+
+	if code, _ := call(uuid.New().String(), uuid.New().String()); code != http.StatusNotFound {
+		t.Errorf("unknown user status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code, body := call(id.String(), uuid.New().String()); code != http.StatusNotFound || !strings.Contains(body, "SESSION_NOT_FOUND") {
+		t.Errorf("unknown session: status = %d, body = %s", code, body)
+	}
+	if code, _ := call(id.String(), "not-a-uuid"); code != http.StatusBadRequest {
+		t.Errorf("invalid session ID status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func callWithIDBody(handler gin.HandlerFunc, id, target, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", target, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	handler(c)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestApproveUser_BlockedRequiresUnblock(t *testing.T) {
+	id := uuid.New()
+	users := stubAdminUsers{id: {ID: id, Email: "b@example.com", IsBlocked: true}}
+	h := newAdminTestHandler(users)
+
+	w, resp := callWithIDBody(h.ApproveUser, id.String(), "/", "")
+	if w.Code != http.StatusConflict || resp["code"] != "USER_BLOCKED" {
+		t.Fatalf("status = %d, body = %v; want 409 USER_BLOCKED", w.Code, resp)
+	}
+
+	w, resp = callWithIDBody(h.ApproveUser, id.String(), "/", `{"unblock": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	user, _ := resp["user"].(map[string]interface{})
+	if user["is_approved"] != true || user["is_blocked"] != false || user["email"] != "b@example.com" {
+		t.Errorf("user = %v, want approved and unblocked", user)
+	}
+	if user["approved_at"] == nil || user["blocked_at"] != nil {
+		t.Errorf("user = %v, want approved_at set and blocked_at cleared", user)
+	}
+}
+
+func TestBlockUser_ReturnsUpdatedUser(t *testing.T) {
+	id := uuid.New()
+	h := newAdminTestHandler(stubAdminUsers{id: {ID: id, IsApproved: true}})
+
+	w, resp := callWithIDBody(h.BlockUser, id.String(), "/", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	if user, _ := resp["user"].(map[string]interface{}); user["is_blocked"] != true {
+		t.Errorf("user = %v, want blocked", user)
+	}
+
+	// Unblocking a user that isn't blocked is an invalid transition
+	w, resp = callWithIDBody(h.UnblockUser, uuid.New().String(), "/", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown user status = %d", w.Code)
+	}
+	id2 := uuid.New()
+	h = newAdminTestHandler(stubAdminUsers{id2: {ID: id2}})
+	w, resp = callWithIDBody(h.UnblockUser, id2.String(), "/", "")
+	if w.Code != http.StatusConflict || resp["code"] != "USER_NOT_BLOCKED" {
+		t.Errorf("status = %d, body = %v; want 409 USER_NOT_BLOCKED", w.Code, resp)
+	}
+}
+
+func TestBlockUser_StateTimestamps(t *testing.T) {
+	id := uuid.New()
+	users := stubAdminUsers{id: {ID: id, IsApproved: true}}
+	h := newAdminTestHandler(users)
+
+	w, resp := callWithIDBody(h.BlockUser, id.String(), "/", `{"reason": "chargeback"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	user, _ := resp["user"].(map[string]interface{})
+	if user["blocked_at"] == nil || user["blocked_reason"] != "chargeback" {
+		t.Errorf("user = %v, want blocked_at and blocked_reason", user)
+	}
+
+	w, resp = callWithIDBody(h.UnblockUser, id.String(), "/", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	user, _ = resp["user"].(map[string]interface{})
+	if _, ok := user["blocked_at"]; ok {
+		t.Errorf("user = %v, want blocked_at cleared", user)
+	}
+	if _, ok := user["blocked_reason"]; ok {
+		t.Errorf("user = %v, want blocked_reason cleared", user)
+	}
+
+	w, resp = callWithIDBody(h.BlockUser, id.String(), "/", `{"reason": "`+strings.Repeat("x", 501)+`"}`)
+	if w.Code != http.StatusBadRequest || resp["code"] != "REASON_TOO_LONG" {
+		t.Errorf("status = %d, body = %v; want 400 REASON_TOO_LONG", w.Code, resp)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	admin, id := uuid.New(), uuid.New()
+	h := newAdminTestHandler(stubAdminUsers{
+		admin: {ID: admin, IsApproved: true, IsAdmin: true},
+		id:    {ID: id, IsApproved: true},
+	})
+
+	w, resp := callWithIDBody(h.SetUserRole, id.String(), "/", "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing body: status = %d, body = %v; want 400", w.Code, resp)
+	}
+
+	w, resp = callWithIDBody(h.SetUserRole, admin.String(), "/", `{"is_admin": false}`)
+	if w.Code != http.StatusConflict || resp["code"] != "LAST_ADMIN" {
+		t.Errorf("status = %d, body = %v; want 409 LAST_ADMIN", w.Code, resp)
+	}
+
+	w, resp = callWithIDBody(h.SetUserRole, id.String(), "/", `{"is_admin": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	if user, _ := resp["user"].(map[string]interface{}); user["is_admin"] != true {
+		t.Errorf("user = %v, want admin", user)
+	}
+	w, resp = callWithIDBody(h.SetUserRole, id.String(), "/", `{"is_admin": true}`)
+	if w.Code != http.StatusConflict || resp["code"] != "USER_ALREADY_ADMIN" {
+		t.Errorf("status = %d, body = %v; want 409 USER_ALREADY_ADMIN", w.Code, resp)
+	}
+
+	w, resp = callWithIDBody(h.SetUserRole, admin.String(), "/", `{"is_admin": false}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("demote with another admin left: status = %d, body = %v", w.Code, resp)
+	}
+}
+
+func TestBulkUsers(t *testing.T) {
+	admin, pending := uuid.New(), uuid.New()
+	users := stubAdminUsers{
+		admin:   {ID: admin, IsApproved: true, IsAdmin: true},
+		pending: {ID: pending},
+	}
+	h := newAdminTestHandler(users)
+	body := func(action string, confirm bool, ids ...uuid.UUID) string {
+		b, _ := json.Marshal(models.BulkUserRequest{Action: action, UserIDs: ids, Confirm: confirm})
+		return string(b)
+	}
+
+	w, resp := callWithIDBody(h.BulkUsers, "", "/", body(models.BulkDelete, false, pending))
+	if w.Code != http.StatusBadRequest || resp["code"] != "CONFIRMATION_REQUIRED" {
+		t.Errorf("status = %d, body = %v; want 400 CONFIRMATION_REQUIRED", w.Code, resp)
+	}
+	w, resp = callWithIDBody(h.BulkUsers, "", "/", body("promote", true, pending))
+	if w.Code != http.StatusBadRequest || resp["code"] != "INVALID_INPUT" {
+		t.Errorf("status = %d, body = %v; want 400 INVALID_INPUT", w.Code, resp)
+	}
+
+	w, resp = callWithIDBody(h.BulkUsers, "", "/", body(models.BulkApprove, false, pending, admin))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	counts, _ := resp["counts"].(map[string]interface{})
+	if counts["done"] != 1.0 || counts["skipped"] != 1.0 || !users[pending].IsApproved {
+		t.Errorf("counts = %v, approved = %v; want one done, one skipped", counts, users[pending].IsApproved)
+	}
+
+	w, resp = callWithIDBody(h.BulkUsers, "", "/", body(models.BulkDelete, true, pending, admin))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	if _, ok := users[admin]; !ok || len(users) != 1 {
+		t.Errorf("users left = %v, want only the admin", users)
+	}
+}
+
+func TestDeleteUser_Confirmation(t *testing.T) {
+	id := uuid.New()
+	users := stubAdminUsers{id: {ID: id, Email: "gone@example.com"}}
+	h := newAdminTestHandler(users)
+
+	w, resp := callWithIDBody(h.DeleteUser, id.String(), "/", "")
+	if w.Code != http.StatusBadRequest || resp["code"] != "CONFIRMATION_REQUIRED" {
+		t.Fatalf("status = %d, body = %v; want 400 CONFIRMATION_REQUIRED", w.Code, resp)
+	}
+	if _, ok := users[id]; !ok {
+		t.Fatal("user deleted without confirmation")
+	}
+
+	w, resp = callWithIDBody(h.DeleteUser, id.String(), "/?confirm=true", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	if user, _ := resp["user"].(map[string]interface{}); user["email"] != "gone@example.com" {
+		t.Errorf("user = %v, want deleted user", user)
+	}
+	if _, ok := users[id]; ok {
+		t.Error("user not deleted")
+	}
+}
+
+// pagedUsers pages through users like UserRepository.ListPage, ignoring
+// the status filter
+type pagedUsers struct {
+	users []models.User
+	last  models.UserListQuery
+}
+
+func (p *pagedUsers) matching(q models.UserListQuery) []models.User {
+	var out []models.User
+	for _, u := range p.users {
+		if strings.Contains(u.Email, q.Search) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func (p *pagedUsers) ListPage(_ context.Context, q models.UserListQuery) ([]models.User, error) {
+	p.last = q
+	users := p.matching(q)
+	if q.Offset >= len(users) {
+		return nil, nil
+	}
+	return users[q.Offset:min(q.Offset+q.Limit, len(users))], nil
+}
+
+func (p *pagedUsers) CountMatching(_ context.Context, q models.UserListQuery) (int, error) {
+	return len(p.matching(q)), nil
+}
+
+func (p *pagedUsers) OldestPendingCreatedAt(context.Context) (*time.Time, error) { return nil, nil }
+
+func TestListUsers_Paged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := &pagedUsers{}
+	for i := 0; i < 120; i++ {
+		users.users = append(users.users, models.User{ID: uuid.New(), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	h := &AdminHandler{userList: users}
+
+	list := func(query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/api/v1/admin/users?"+query, nil)
+		h.ListUsers(c)
+		var resp map[string]interface{}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := list("")
+	if w.Code != http.StatusOK || len(resp["users"].([]interface{})) != 50 || resp["total"] != 120.0 || resp["total_pages"] != 3.0 {
+		t.Fatalf("first page: %d, %d users, total %v, pages %v", w.Code, len(resp["users"].([]interface{})), resp["total"], resp["total_pages"])
+	}
+
+	_, resp = list("page=3&per_page=50")
+	if len(resp["users"].([]interface{})) != 20 || users.last.Offset != 100 {
+		t.Errorf("last page: %d users at offset %d", len(resp["users"].([]interface{})), users.last.Offset)
+	}
+
+	_, resp = list("per_page=1000&status=approved&search=user11")
+	if users.last.Limit != maxUserPageSize || users.last.Status != models.UserStatusActive || resp["total"] != 11.0 {
+		t.Errorf("query = %+v, total %v", users.last, resp["total"])
+	}
+
+	for _, query := range []string{"status=deleted", "page=0", "per_page=x"} {
+		if w, resp := list(query); w.Code != http.StatusBadRequest || resp["code"] != "INVALID_INPUT" {
+			t.Errorf("%s: status = %d, code = %v", query, w.Code, resp["code"])
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// apiKeyManager is the subset of service.APIKeys needed by the handler
+type apiKeyManager interface {
+	Create(ctx context.Context, userID uuid.UUID, name, access string) (string, *models.APIKey, error)
+	List(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error)
+	Revoke(ctx context.Context, userID, id uuid.UUID) error
+}
+
+// APIKeyHandler manages the API keys of the current user
+type APIKeyHandler struct {
+	keys apiKeyManager
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(keys *service.APIKeys) *APIKeyHandler {
+	return &APIKeyHandler{keys: keys}
+}
+
+// Create mints an API key. Its secret is only returned here.
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+	if req.Access == "" {
+		req.Access = service.APIKeyAccessRead
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	secret, key, err := h.keys.Create(c.Request.Context(), userID, req.Name, req.Access)
+	if errors.Is(err, service.ErrInvalidAPIKeyAccess) {
+		apierror.RespondError(c, http.StatusBadRequest, "INVALID_API_KEY_ACCESS", "access must be read or read_write", nil)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create API key")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to create api key", nil)
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{Key: secret, APIKey: key})
+}
+
+// List lists the active API keys of the current user
+func (h *APIKeyHandler) List(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	keys, err := h.keys.List(c.Request.Context(), userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list API keys")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list api keys", nil)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// Revoke revokes one API key of the current user
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid api key ID", nil)
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	err = h.keys.Revoke(c.Request.Context(), userID, id)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		apierror.RespondError(c, http.StatusNotFound, "API_KEY_NOT_FOUND", "api key not found", nil)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke API key")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to revoke api key", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+}
@@ -1,31 +1,96 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/rs/zerolog/log"
 
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
 	"github.com/sprobst76/vibedterm-server/internal/config"
+	"github.com/sprobst76/vibedterm-server/internal/events"
 	"github.com/sprobst76/vibedterm-server/internal/middleware"
 	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/password"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
 )
 
+// Defaults used when the corresponding config value is unset
+const (
+	defaultTempTokenDuration   = 5 * time.Minute
+	defaultRecoveryMaxAttempts = 3
+)
+
+// tempTokenStore tracks extensions, recovery attempts and uses of TOTP temp tokens
+type tempTokenStore interface {
+	MarkExtended(ctx context.Context, jti uuid.UUID, expiresAt time.Time) (bool, error)
+	MarkUsed(ctx context.Context, jti uuid.UUID, expiresAt time.Time) (bool, error)
+	Used(ctx context.Context, jti uuid.UUID) (bool, error)
+	RecordRecoveryAttempt(ctx context.Context, jti uuid.UUID, expiresAt time.Time) (int, error)
+	RecoveryAttempts(ctx context.Context, jti uuid.UUID) (int, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// authUserStore is the subset of UserRepository needed by AuthHandler
+type authUserStore interface {
+	passwordRehasher
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdateLastLogin(ctx context.Context, id uuid.UUID) error
+}
+
+// authDeviceStore is the subset of DeviceRepository needed by AuthHandler
+type authDeviceStore interface {
+	Create(ctx context.Context, userID uuid.UUID, name, deviceType, model, appVersion string) (*models.Device, bool, error)
+	UpdateActivity(ctx context.Context, id uuid.UUID, ip, userAgent string) error
+}
+
+// authRefreshStore is the subset of RefreshTokenRepository needed by AuthHandler
+type authRefreshStore interface {
+	Create(ctx context.Context, userID, deviceID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error)
+	Revoke(ctx context.Context, tokenHash string) error
+	RevokeAllForUser(ctx context.Context, userID uuid.UUID) error
+}
+
 // AuthHandler handles authentication endpoints
 type AuthHandler struct {
-	userRepo    *repository.UserRepository
-	deviceRepo  *repository.DeviceRepository
-	refreshRepo *repository.RefreshTokenRepository
-	config      *config.Config
+	userRepo     authUserStore
+	deviceRepo   authDeviceStore
+	refreshRepo  authRefreshStore
+	tempTokens   tempTokenStore
+	registration *service.Registration
+	tokenRefresh tokenRefresher
+	totpGuard    totpChecker
+	webAuthn     webAuthnLogin   // nil disables WebAuthn as second factor
+	trust        deviceTruster   // nil disables trusted devices
+	newDevices   notify.Notifier // told about logins from new devices; nil disables
+	approvals    notify.Notifier // told about devices awaiting approval; nil disables
+	events       *events.Log
+	config       *config.Config
+}
+
+// tokenRefresher is the subset of service.TokenRefresh needed by the handler
+type tokenRefresher interface {
+	Refresh(ctx context.Context, tokenHash, newHash string, expiresAt time.Time) (*models.User, *models.Device, *models.RefreshToken, error)
+}
+
+// deviceTruster is the subset of service.DeviceTrust needed by AuthHandler
+type deviceTruster interface {
+	Enabled() bool
+	TrustDevice(ctx context.Context, userID, deviceID uuid.UUID, name string) (string, *models.TrustedDevice, error)
+	VerifyDevice(ctx context.Context, userID uuid.UUID, token, deviceName string) (bool, error)
 }
 
 // NewAuthHandler creates a new auth handler
@@ -33,13 +98,31 @@ func NewAuthHandler(
 	userRepo *repository.UserRepository,
 	deviceRepo *repository.DeviceRepository,
 	refreshRepo *repository.RefreshTokenRepository,
+	tempTokenRepo *repository.TempTokenRepository,
+	registration *service.Registration,
+	tokenRefresh *service.TokenRefresh,
+	webAuthn *service.WebAuthn,
+	trust *service.DeviceTrust,
+	totpGuard *service.TOTPGuard,
+	newDevices notify.Notifier,
+	approvals notify.Notifier,
+	eventLog *events.Log,
 	cfg *config.Config,
 ) *AuthHandler {
 	return &AuthHandler{
-		userRepo:    userRepo,
-		deviceRepo:  deviceRepo,
-		refreshRepo: refreshRepo,
-		config:      cfg,
+		userRepo:     userRepo,
+		deviceRepo:   deviceRepo,
+		refreshRepo:  refreshRepo,
+		tempTokens:   tempTokenRepo,
+		registration: registration,
+		tokenRefresh: tokenRefresh,
+		webAuthn:     webAuthn,
+		trust:        trust,
+		totpGuard:    totpGuard,
+		newDevices:   newDevices,
+		approvals:    approvals,
+		events:       eventLog,
+		config:       cfg,
 	}
 }
 
@@ -47,180 +130,338 @@ func NewAuthHandler(
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req models.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
-		return
-	}
-
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process password"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
-	// Create user
-	user, err := h.userRepo.Create(c.Request.Context(), req.Email, string(hashedPassword))
+	// Create user (duplicate submissions resolve to the same user)
+	profile := models.RegistrationProfile{DisplayName: req.DisplayName, Message: req.RegistrationMessage}
+	user, err := h.registration.Register(c.Request.Context(), req.Email, req.Password, req.InviteCode, profile)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserAlreadyExists) {
-			c.JSON(http.StatusConflict, gin.H{"error": "email already registered"})
+			if h.registration.ConcealsExisting() {
+				registrationAccepted(c, nil)
+				return
+			}
+			apierror.RespondError(c, http.StatusConflict, apierror.CodeConflict, "email already registered", nil)
+			return
+		}
+		if errors.Is(err, service.ErrDisplayNameRequired) {
+			apierror.RespondError(c, http.StatusBadRequest, "DISPLAY_NAME_REQUIRED", "display name required", nil)
+			return
+		}
+		if errors.Is(err, service.ErrRegistrationClosed) {
+			apierror.RespondError(c, http.StatusForbidden, "REGISTRATION_DISABLED", "registration is disabled", nil)
+			return
+		}
+		if errors.Is(err, service.ErrEmailDomainNotAllowed) {
+			apierror.RespondError(c, http.StatusForbidden, "EMAIL_DOMAIN_NOT_ALLOWED", "email domain not allowed", nil)
+			return
+		}
+		if errors.Is(err, service.ErrInviteRequired) {
+			apierror.RespondError(c, http.StatusBadRequest, "INVITE_REQUIRED", "invite code required", nil)
+			return
+		}
+		if errors.Is(err, service.ErrInviteInvalid) {
+			apierror.RespondError(c, http.StatusForbidden, "INVITE_INVALID", "invite code invalid, used or expired", nil)
+			return
+		}
+		if errors.Is(err, password.ErrBusy) {
+			respondBusy(c)
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
+		middleware.Logger(c).Error().Err(err).Msg("Failed to create user")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to create user", nil)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "registration successful, awaiting admin approval",
-		"user_id": user.ID,
-	})
+	if h.registration.ConcealsExisting() {
+		user = nil
+	}
+	registrationAccepted(c, user)
+}
+
+// registrationAccepted answers a registration. The user ID is left out
+// when existing emails are concealed, so both answers look alike.
+func registrationAccepted(c *gin.Context, user *models.User) {
+	resp := gin.H{"message": "registration successful, confirm your email address and await admin approval"}
+	if user != nil {
+		resp["user_id"] = user.ID
+	}
+	c.JSON(http.StatusCreated, resp)
 }
 
 // Login handles user login
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		apierror.RespondBindError(c, err)
 		return
 	}
+	policy := appVersionPolicy(h.config)
+	if _, ok := policy.Check(req.AppVersion); !ok {
+		middleware.RespondAppVersionUnsupported(c, policy.MinRequired)
+		return
+	}
+	device := loginDevice{Name: req.DeviceName, Type: req.DeviceType, Model: req.DeviceModel, AppVersion: req.AppVersion}
 
 	// Get user
 	user, err := h.userRepo.GetByEmail(c.Request.Context(), req.Email)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			// Hash as for a wrong password so the response time does not
+			// reveal whether the email is registered
+			if errors.Is(password.CompareDummy(c.Request.Context(), req.Password), password.ErrBusy) {
+				respondBusy(c)
+				return
+			}
+			recordLoginFailed(c, h.events, nil, req.Email, events.ReasonInvalidCredentials)
+			apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid credentials", nil)
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate"})
+		middleware.Logger(c).Error().Err(err).Msg("Failed to authenticate")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to authenticate", nil)
 		return
 	}
 
 	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+	rehashed, err := password.Verify(c.Request.Context(), user.PasswordHash, req.Password)
+	if err != nil {
+		if errors.Is(err, password.ErrBusy) {
+			respondBusy(c)
+			return
+		}
+		recordLoginFailed(c, h.events, user, req.Email, events.ReasonInvalidCredentials)
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid credentials", nil)
 		return
 	}
 
-	// Check if blocked
-	if user.IsBlocked {
-		c.JSON(http.StatusForbidden, gin.H{"error": "account blocked", "code": "ACCOUNT_BLOCKED"})
+	upgradePasswordHash(c.Request.Context(), h.userRepo, user, rehashed)
+
+	if !h.checkAccountStatus(c, user, req.Email) {
 		return
 	}
 
-	// Check if approved
-	if !user.IsApproved {
-		c.JSON(http.StatusForbidden, gin.H{"error": "account pending approval", "code": "PENDING_APPROVAL"})
+	// Check if a second factor is required
+	methods, err := h.secondFactorMethods(c.Request.Context(), user)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to authenticate")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to authenticate", nil)
 		return
 	}
-
-	// Check if TOTP is required
-	if user.TOTPEnabled {
-		// Generate temporary token for TOTP validation
-		tempToken, err := h.generateTempToken(user.ID, req.DeviceName, req.DeviceType)
+	if len(methods) > 0 && req.TrustToken != "" && h.trust != nil {
+		trusted, err := h.trust.VerifyDevice(c.Request.Context(), user.ID, req.TrustToken, req.DeviceName)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate temp token"})
+			middleware.Logger(c).Error().Err(err).Msg("Failed to authenticate")
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to authenticate", nil)
 			return
 		}
-		c.JSON(http.StatusOK, models.LoginTOTPResponse{
-			RequiresTOTP: true,
-			TempToken:    tempToken,
-		})
+		if trusted {
+			methods = nil
+		}
+	}
+	if len(methods) > 0 {
+		// Generate temporary token for the second factor
+		tempToken, err := h.generateTempToken(user.ID, device)
+		if err != nil {
+			middleware.Logger(c).Error().Err(err).Msg("Failed to generate temp token")
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to generate temp token", nil)
+			return
+		}
+		c.JSON(http.StatusOK, h.secondFactorResponse(tempToken, methods))
 		return
 	}
 
 	// Complete login
-	h.completeLogin(c, user, req.DeviceName, req.DeviceType)
+	h.completeLogin(c, user, device, nil, false)
 }
 
 // ValidateTOTP handles TOTP validation during login
 func (h *AuthHandler) ValidateTOTP(c *gin.Context) {
 	var req models.TOTPValidateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
-	// Parse temp token
-	userID, deviceName, deviceType, err := h.parseTempToken(req.TempToken)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+	// Parse temp token and get user
+	user, device, claims, ok := h.tempTokenUser(c, req.TempToken)
+	if !ok {
 		return
 	}
 
-	// Get user
-	user, err := h.userRepo.GetByID(c.Request.Context(), userID)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+	// Validate TOTP; users with only WebAuthn have no usable secret
+	if !user.TOTPEnabled {
+		recordLoginFailed(c, h.events, user, user.Email, events.ReasonInvalidTOTP)
+		respondTOTPError(c, service.ErrTOTPInvalid)
 		return
 	}
-
-	// Validate TOTP
-	if !totp.Validate(req.Code, base32.StdEncoding.EncodeToString(user.TOTPSecret)) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid TOTP code"})
+	if err := h.totpGuard.Check(c.Request.Context(), user, req.Code, events.SurfaceAPI); err != nil {
+		if !errors.Is(err, service.ErrTOTPThrottled) {
+			recordLoginFailed(c, h.events, user, user.Email, events.ReasonInvalidTOTP)
+		}
+		respondTOTPError(c, err)
 		return
 	}
 
 	// Complete login
-	h.completeLogin(c, user, deviceName, deviceType)
+	h.completeLogin(c, user, device, claims, req.RememberDevice)
 }
 
-// Refresh handles token refresh
-func (h *AuthHandler) Refresh(c *gin.Context) {
-	var req models.RefreshRequest
+// ExtendTempToken re-issues a still-valid TOTP temp token. Each login may
+// be extended once: both the presented and the re-issued token are recorded
+// so neither can be extended again.
+func (h *AuthHandler) ExtendTempToken(c *gin.Context) {
+	var req models.TempTokenExtendRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
-	// Hash the refresh token
-	tokenHash := hashToken(req.RefreshToken)
-
-	// Find and validate refresh token
-	refreshToken, err := h.refreshRepo.GetByTokenHash(c.Request.Context(), tokenHash)
+	claims, err := h.parseTempTokenClaims(req.TempToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		respondTempTokenError(c, err)
+		return
+	}
+	jti, jtiErr := uuid.Parse(claims.ID)
+	if jtiErr != nil || claims.ExpiresAt == nil || !tempTokenUsable(c, h.tempTokens, claims, h.config) {
+		respondTempTokenError(c, middleware.ErrInvalidToken)
 		return
 	}
 
-	if refreshToken.Revoked {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token revoked"})
+	ctx := c.Request.Context()
+	_, _ = h.tempTokens.DeleteExpired(ctx)
+
+	extendable, err := h.tempTokens.MarkExtended(ctx, jti, claims.ExpiresAt.Time)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to extend token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to extend token", nil)
+		return
+	}
+	if !extendable {
+		apierror.RespondError(c, http.StatusConflict, "TEMP_TOKEN_ALREADY_EXTENDED", "temp token already extended", nil)
 		return
 	}
 
-	if time.Now().After(refreshToken.ExpiresAt) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token expired"})
+	user, err := h.userRepo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "user not found", nil)
+		return
+	}
+	methods, err := h.secondFactorMethods(ctx, user)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to extend token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to extend token", nil)
 		return
 	}
 
-	// Get user
-	user, err := h.userRepo.GetByID(c.Request.Context(), refreshToken.UserID)
+	tempToken, newJTI, expiresAt, err := h.issueTempToken(claims.UserID, tempTokenDevice(claims))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		middleware.Logger(c).Error().Err(err).Msg("Failed to generate temp token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to generate temp token", nil)
+		return
+	}
+	if _, err := h.tempTokens.MarkExtended(ctx, newJTI, expiresAt); err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to extend token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to extend token", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, h.secondFactorResponse(tempToken, methods))
+}
+
+// secondFactorMethods lists the second factors the user can log in with.
+// An empty list means the password suffices.
+func (h *AuthHandler) secondFactorMethods(ctx context.Context, user *models.User) ([]string, error) {
+	var methods []string
+	if user.TOTPEnabled {
+		methods = append(methods, models.SecondFactorTOTP)
+	}
+	if h.webAuthn != nil {
+		enabled, err := h.webAuthn.Enabled(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if enabled {
+			methods = append(methods, models.SecondFactorWebAuthn)
+		}
+	}
+	return methods, nil
+}
+
+// secondFactorResponse asks the client to complete the login with one of
+// the given methods
+func (h *AuthHandler) secondFactorResponse(tempToken string, methods []string) models.LoginTOTPResponse {
+	requiresTOTP := false
+	for _, m := range methods {
+		requiresTOTP = requiresTOTP || m == models.SecondFactorTOTP
+	}
+	return models.LoginTOTPResponse{
+		RequiresSecondFactor: true,
+		Methods:              methods,
+		RequiresTOTP:         requiresTOTP,
+		TempToken:            tempToken,
+		ExpiresIn:            int64(h.tempTokenDuration().Seconds()),
+	}
+}
+
+// Refresh handles token refresh
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
 		return
 	}
 
-	// Check if user is still valid
-	if user.IsBlocked || !user.IsApproved {
-		c.JSON(http.StatusForbidden, gin.H{"error": "account no longer active"})
+	// Validate the refresh token and its device binding, then rotate it
+	newRefreshToken := generateSecureToken()
+	user, device, rotated, err := h.tokenRefresh.Refresh(
+		c.Request.Context(),
+		hashToken(req.RefreshToken),
+		hashToken(newRefreshToken),
+		time.Now().Add(h.config.RefreshTokenDuration),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrRefreshTokenReused):
+			apierror.RespondError(c, http.StatusUnauthorized, "TOKEN_REUSE", "refresh token reuse detected, all sessions revoked", nil)
+		case errors.Is(err, service.ErrRefreshTokenInvalid):
+			apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid refresh token", nil)
+		case errors.Is(err, service.ErrRefreshTokenRevoked):
+			apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "refresh token revoked", nil)
+		case errors.Is(err, service.ErrRefreshTokenExpired):
+			apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "refresh token expired", nil)
+		case errors.Is(err, service.ErrDeviceMismatch):
+			apierror.RespondError(c, http.StatusUnauthorized, "DEVICE_MISMATCH", "refresh token no longer valid for this device", nil)
+		case errors.Is(err, service.ErrAccountInactive):
+			apierror.RespondError(c, http.StatusForbidden, apierror.CodeForbidden, "account no longer active", nil)
+		default:
+			middleware.Logger(c).Error().Err(err).Msg("Failed to refresh token")
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to refresh token", nil)
+		}
 		return
 	}
 
-	// Generate new access token
+	// Generate new access token bound to the refresh token's device
 	accessToken, err := middleware.GenerateToken(
 		user.ID,
 		user.Email,
-		refreshToken.DeviceID,
+		device.ID,
 		user.IsAdmin,
 		h.config.JWTSecret,
 		h.config.AccessTokenDuration,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		middleware.Logger(c).Error().Err(err).Msg("Failed to generate token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to generate token", nil)
 		return
 	}
 
 	c.JSON(http.StatusOK, models.RefreshResponse{
-		AccessToken: accessToken,
-		ExpiresIn:   int64(h.config.AccessTokenDuration.Seconds()),
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    int64(h.config.AccessTokenDuration.Seconds()),
+		TokenGrant:   models.NewTokenGrant(middleware.GrantedScopes(user.IsAdmin), rotated.FamilyID),
 	})
 }
 
@@ -228,7 +469,7 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 func (h *AuthHandler) Logout(c *gin.Context) {
 	var req models.RefreshRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
@@ -242,7 +483,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 func (h *AuthHandler) LogoutAll(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
@@ -250,15 +491,72 @@ func (h *AuthHandler) LogoutAll(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "all sessions logged out"})
 }
 
-// completeLogin generates tokens and responds
-func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User, deviceName, deviceType string) {
+// loginDevice is the device a login registers, as the client described it
+type loginDevice struct {
+	Name, Type, Model, AppVersion string
+}
+
+// tempTokenDevice returns the device a temp token carries until the login
+// completes
+func tempTokenDevice(claims *middleware.Claims) loginDevice {
+	return loginDevice{Name: claims.DeviceName, Type: claims.DeviceType, Model: claims.DeviceModel, AppVersion: claims.AppVersion}
+}
+
+// appVersionPolicy returns the configured app version minimums
+func appVersionPolicy(cfg *config.Config) models.AppVersionPolicy {
+	return models.AppVersionPolicy{MinSupported: cfg.MinSupportedAppVersion, MinRequired: cfg.MinRequiredAppVersion}
+}
+
+// completeLogin generates tokens and responds. With remember set the device
+// also gets a trust token to skip the second factor on later logins.
+func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User, device loginDevice, tempToken *middleware.Claims, remember bool) {
+	if resp, ok := h.issueLogin(c, user, device, tempToken, remember); ok {
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// checkAccountStatus rejects logins of blocked, unverified and unapproved
+// accounts, or responds with the error
+func (h *AuthHandler) checkAccountStatus(c *gin.Context, user *models.User, email string) bool {
+	switch {
+	case user.IsBlocked:
+		recordLoginFailed(c, h.events, user, email, events.ReasonAccountBlocked)
+		apierror.RespondError(c, http.StatusForbidden, "ACCOUNT_BLOCKED", "account blocked", nil)
+	case !user.EmailVerified:
+		recordLoginFailed(c, h.events, user, email, events.ReasonEmailNotVerified)
+		apierror.RespondError(c, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "email address not verified", nil)
+	case !user.IsApproved:
+		recordLoginFailed(c, h.events, user, email, events.ReasonPendingApproval)
+		apierror.RespondError(c, http.StatusForbidden, apierror.CodePendingApproval, "account pending approval", nil)
+	default:
+		return true
+	}
+	return false
+}
+
+// issueLogin registers the device and generates the tokens of a login
+// that passed every factor. The account is checked again since it may
+// have been blocked after the password step, and the temp token of the
+// second factor, if any, is used up. On failure it responds with the error.
+func (h *AuthHandler) issueLogin(c *gin.Context, user *models.User, login loginDevice, tempToken *middleware.Claims, remember bool) (*models.LoginResponse, bool) {
 	ctx := c.Request.Context()
 
+	if !h.checkAccountStatus(c, user, user.Email) {
+		return nil, false
+	}
+	if tempToken != nil && !h.useTempToken(c, tempToken) {
+		return nil, false
+	}
+
 	// Create or update device
-	device, err := h.deviceRepo.Create(ctx, user.ID, deviceName, deviceType, "", "")
+	device, created, err := h.deviceRepo.Create(ctx, user.ID, login.Name, login.Type, login.Model, login.AppVersion)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register device"})
-		return
+		middleware.Logger(c).Error().Err(err).Msg("Failed to register device")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to register device", nil)
+		return nil, false
+	}
+	if err := h.deviceRepo.UpdateActivity(ctx, device.ID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		middleware.Logger(c).Error().Err(err).Str("device_id", device.ID.String()).Msg("Failed to update device activity")
 	}
 
 	// Generate access token
@@ -271,15 +569,16 @@ func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User, deviceNam
 		h.config.AccessTokenDuration,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate access token"})
-		return
+		middleware.Logger(c).Error().Err(err).Msg("Failed to generate access token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to generate access token", nil)
+		return nil, false
 	}
 
 	// Generate refresh token
 	refreshTokenStr := generateSecureToken()
 	refreshTokenHash := hashToken(refreshTokenStr)
 
-	_, err = h.refreshRepo.Create(
+	refreshToken, err := h.refreshRepo.Create(
 		ctx,
 		user.ID,
 		device.ID,
@@ -287,58 +586,210 @@ func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User, deviceNam
 		time.Now().Add(h.config.RefreshTokenDuration),
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate refresh token"})
-		return
+		middleware.Logger(c).Error().Err(err).Msg("Failed to generate refresh token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to generate refresh token", nil)
+		return nil, false
 	}
 
 	// Update last login
 	_ = h.userRepo.UpdateLastLogin(ctx, user.ID)
+	h.events.Record(ctx, events.Entry{
+		SubjectID: &user.ID,
+		IP:        c.ClientIP(),
+		Payload:   events.LoginSucceeded{Email: user.Email, Surface: events.SurfaceAPI, DeviceName: login.Name},
+	})
+	switch {
+	case created && !device.IsApproved:
+		h.notifyPendingDevice(c, user, device)
+	case created:
+		h.notifyNewDevice(c, user, device)
+	}
 
-	c.JSON(http.StatusOK, models.LoginResponse{
+	resp := models.LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshTokenStr,
 		ExpiresIn:    int64(h.config.AccessTokenDuration.Seconds()),
+		TokenGrant:   models.NewTokenGrant(middleware.GrantedScopes(user.IsAdmin), refreshToken.FamilyID),
 		User:         *user,
 		DeviceID:     device.ID.String(),
+
+		DevicePendingApproval: !device.IsApproved,
+	}
+	resp.Warning, _ = appVersionPolicy(h.config).Check(login.AppVersion)
+	if remember && h.trust != nil && h.trust.Enabled() {
+		// The login itself succeeded; without trust the next login asks
+		// for the second factor again
+		token, trusted, err := h.trust.TrustDevice(ctx, user.ID, device.ID, login.Name)
+		if err != nil {
+			middleware.Logger(c).Error().Err(err).Str("device_id", device.ID.String()).Msg("Failed to trust device")
+		} else {
+			resp.TrustToken = token
+			resp.TrustTokenExpiresAt = trusted.ExpiresAt.Unix()
+		}
+	}
+	return &resp, true
+}
+
+// notifyNewDevice tells the account owner about a login from a device name
+// the account has not used before. Delivery must not fail the login.
+func (h *AuthHandler) notifyNewDevice(c *gin.Context, user *models.User, device *models.Device) {
+	if h.newDevices == nil {
+		return
+	}
+	err := h.newDevices.Notify(c.Request.Context(), notify.Notification{
+		Kind:    notify.KindNewDeviceLogin,
+		UserID:  user.ID,
+		Email:   user.Email,
+		Subject: "New device signed in to your account",
+		Body: fmt.Sprintf(
+			"Your account was signed in on a new device %q (%s) at %s. "+
+				"If this wasn't you, change your password and remove the device.",
+			device.DeviceName, device.DeviceType, device.CreatedAt.UTC().Format(time.RFC1123),
+		),
+		IP: c.ClientIP(),
 	})
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to send new device notification")
+	}
+}
+
+// notifyPendingDevice asks the account owner to approve or deny a new
+// device. Delivery must not fail the login.
+func (h *AuthHandler) notifyPendingDevice(c *gin.Context, user *models.User, device *models.Device) {
+	if h.approvals == nil {
+		return
+	}
+	err := h.approvals.Notify(c.Request.Context(), notify.Notification{
+		Kind:    notify.KindDevicePending,
+		UserID:  user.ID,
+		Email:   user.Email,
+		Subject: "A new device awaits your approval",
+		Body: fmt.Sprintf(
+			"Your account was signed in on a new device %q (%s) at %s. "+
+				"It cannot access your vault until you approve it on the devices page or from another device. "+
+				"If this wasn't you, deny the device and change your password.",
+			device.DeviceName, device.DeviceType, device.CreatedAt.UTC().Format(time.RFC1123),
+		),
+		IP: c.ClientIP(),
+	})
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to send device approval notification")
+	}
 }
 
 // generateTempToken creates a temporary token for TOTP flow
-func (h *AuthHandler) generateTempToken(userID uuid.UUID, deviceName, deviceType string) (string, error) {
-	// Simple approach: JWT with short expiry
-	return middleware.GenerateToken(
-		userID,
-		deviceName+"|"+deviceType, // Store device info in email field temporarily
-		uuid.Nil,
-		false,
-		h.config.JWTSecret,
-		5*time.Minute, // Short-lived
-	)
+func (h *AuthHandler) generateTempToken(userID uuid.UUID, device loginDevice) (string, error) {
+	token, _, _, err := h.issueTempToken(userID, device)
+	return token, err
+}
+
+// issueTempToken signs a short-lived temp token and returns it with its jti and expiry
+func (h *AuthHandler) issueTempToken(userID uuid.UUID, device loginDevice) (string, uuid.UUID, time.Time, error) {
+	jti := uuid.New()
+	now := time.Now()
+	expiresAt := now.Add(h.tempTokenDuration())
+
+	claims := &middleware.Claims{
+		TokenType:   middleware.TokenTypeTempTOTP,
+		UserID:      userID,
+		DeviceName:  device.Name,
+		DeviceType:  device.Type,
+		DeviceModel: device.Model,
+		AppVersion:  device.AppVersion,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti.String(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "vibedterm",
+		},
+	}
+
+	token, err := middleware.SignHS256(claims, h.config.JWTSecret)
+	if err != nil {
+		return "", uuid.Nil, time.Time{}, err
+	}
+	return token, jti, expiresAt, nil
+}
+
+// useTempToken marks the temp token as used so it completes no further
+// login, or responds with an error if it already completed one
+func (h *AuthHandler) useTempToken(c *gin.Context, claims *middleware.Claims) bool {
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil || claims.ExpiresAt == nil {
+		respondTempTokenError(c, middleware.ErrInvalidToken)
+		return false
+	}
+	unused, err := h.tempTokens.MarkUsed(c.Request.Context(), jti, claims.ExpiresAt.Time)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to use temp token")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to complete login", nil)
+		return false
+	}
+	if !unused {
+		respondTempTokenError(c, middleware.ErrInvalidToken)
+		return false
+	}
+	return true
+}
+
+// tempTokenDuration returns the configured temp token lifetime
+func (h *AuthHandler) tempTokenDuration() time.Duration {
+	if h.config.TOTPTempTokenDuration > 0 {
+		return h.config.TOTPTempTokenDuration
+	}
+	return defaultTempTokenDuration
 }
 
 // parseTempToken extracts data from temp token
 func (h *AuthHandler) parseTempToken(tokenStr string) (uuid.UUID, string, string, error) {
-	claims, err := middleware.ValidateToken(tokenStr, h.config.JWTSecret)
+	claims, err := h.parseTempTokenClaims(tokenStr)
 	if err != nil {
 		return uuid.Nil, "", "", err
 	}
+	return claims.UserID, claims.DeviceName, claims.DeviceType, nil
+}
+
+// parseTempTokenClaims validates a temp token and returns its claims.
+// Access tokens are rejected.
+func (h *AuthHandler) parseTempTokenClaims(tokenStr string) (*middleware.Claims, error) {
+	return middleware.ValidateTokenType(tokenStr, h.config.JWTSecret, middleware.TokenTypeTempTOTP)
+}
 
-	// Parse device info from email field
-	parts := splitDeviceInfo(claims.Email)
-	if len(parts) != 2 {
-		return uuid.Nil, "", "", errors.New("invalid temp token format")
+// tempTokenUsable reports whether the temp token has neither completed a
+// login nor been invalidated by exhausting its recovery code attempts
+func tempTokenUsable(c *gin.Context, store tempTokenStore, claims *middleware.Claims, cfg *config.Config) bool {
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil {
+		// Tokens without jti predate attempt tracking
+		return true
+	}
+	if used, err := store.Used(c.Request.Context(), jti); err != nil || used {
+		return false
+	}
+	attempts, err := store.RecoveryAttempts(c.Request.Context(), jti)
+	if err != nil {
+		return false
 	}
+	return attempts < recoveryMaxAttempts(cfg)
+}
 
-	return claims.UserID, parts[0], parts[1], nil
+// recoveryMaxAttempts returns the configured recovery attempt cap per temp token
+func recoveryMaxAttempts(cfg *config.Config) int {
+	if cfg.RecoveryMaxAttempts > 0 {
+		return cfg.RecoveryMaxAttempts
+	}
+	return defaultRecoveryMaxAttempts
 }
 
-func splitDeviceInfo(s string) []string {
-	for i := len(s) - 1; i >= 0; i-- {
-		if s[i] == '|' {
-			return []string{s[:i], s[i+1:]}
-		}
+// respondTempTokenError distinguishes expired temp tokens from invalid ones
+// so clients can send the user back to the password step only when needed
+func respondTempTokenError(c *gin.Context, err error) {
+	if errors.Is(err, middleware.ErrExpiredToken) {
+		apierror.RespondError(c, http.StatusUnauthorized, "TEMP_TOKEN_EXPIRED", "temp token expired", nil)
+		return
 	}
-	return []string{s}
+	apierror.RespondError(c, http.StatusUnauthorized, "TEMP_TOKEN_INVALID", "invalid or expired token", nil)
 }
 
 func generateSecureToken() string {
@@ -351,3 +802,41 @@ func hashToken(token string) string {
 	hash := sha256.Sum256([]byte(token))
 	return hex.EncodeToString(hash[:])
 }
+
+// respondBusy sheds a request whose password check could not get a
+// hashing slot in time
+func respondBusy(c *gin.Context) {
+	c.Header("Retry-After", "1")
+	apierror.RespondError(c, http.StatusServiceUnavailable, "BUSY", "server busy, retry shortly", nil)
+}
+
+// recordLoginFailed records a rejected API login. user is nil if the
+// email matched no account.
+func recordLoginFailed(c *gin.Context, eventLog *events.Log, user *models.User, email, reason string) {
+	entry := events.Entry{
+		IP:      c.ClientIP(),
+		Payload: events.LoginFailed{Email: email, Surface: events.SurfaceAPI, Reason: reason},
+	}
+	if user != nil {
+		entry.SubjectID = &user.ID
+	}
+	eventLog.Record(c.Request.Context(), entry)
+}
+
+// passwordRehasher stores upgraded password hashes
+type passwordRehasher interface {
+	RehashPassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) error
+}
+
+// upgradePasswordHash stores the hash password.Verify produced for an
+// outdated one. Failures are logged; the next login tries again.
+func upgradePasswordHash(ctx context.Context, users passwordRehasher, user *models.User, rehashed string) {
+	if rehashed == "" {
+		return
+	}
+	if err := users.RehashPassword(ctx, user.ID, user.PasswordHash, rehashed); err != nil {
+		log.Warn().Err(err).Str("user_id", user.ID.String()).Msg("Failed to upgrade password hash")
+		return
+	}
+	user.PasswordHash = rehashed
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// authEventLister is the subset of AuthEventRepository needed to read the
+// account activity log
+type authEventLister interface {
+	ListAll(ctx context.Context, q models.AuthEventQuery) ([]models.AuthEvent, error)
+}
+
+// AuthEventHandler serves the account activity log
+type AuthEventHandler struct {
+	events authEventLister
+}
+
+// NewAuthEventHandler creates a new auth event handler
+func NewAuthEventHandler(events *repository.AuthEventRepository) *AuthEventHandler {
+	return &AuthEventHandler{events: events}
+}
+
+// List returns the current user's recent events, newest first. Where an
+// admin acted on the account, the admin's address is not shown.
+func (h *AuthEventHandler) List(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	limit, before, ok := bindAuditPage(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.events.ListAll(c.Request.Context(), models.AuthEventQuery{UserID: &userID, Before: before, Limit: limit})
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list events", nil)
+		return
+	}
+	for i := range events {
+		if actor := events[i].ActorID; actor != nil && *actor != userID {
+			events[i].IP, events[i].UserAgent = "", ""
+		}
+	}
+
+	respondAuthEvents(c, events)
+}
+
+// ListAll returns events of all users, newest first. Filter with
+// ?user_id= and ?type= (an event type, or a prefix ending in ".*"); page
+// with ?before= like the audit log.
+func (h *AuthEventHandler) ListAll(c *gin.Context) {
+	limit, before, ok := bindAuditPage(c)
+	if !ok {
+		return
+	}
+	q := models.AuthEventQuery{Type: c.Query("type"), Before: before, Limit: limit}
+	if v := c.Query("user_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid user ID", nil)
+			return
+		}
+		q.UserID = &id
+	}
+
+	events, err := h.events.ListAll(c.Request.Context(), q)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list events", nil)
+		return
+	}
+
+	respondAuthEvents(c, events)
+}
+
+func respondAuthEvents(c *gin.Context, events []models.AuthEvent) {
+	if events == nil {
+		events = []models.AuthEvent{}
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
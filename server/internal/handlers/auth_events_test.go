@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+type memAuthEventList []models.AuthEvent
+
+func (m memAuthEventList) ListAll(_ context.Context, q models.AuthEventQuery) ([]models.AuthEvent, error) {
+	var events []models.AuthEvent
+	for _, e := range m {
+		if q.UserID == nil || (e.UserID != nil && *e.UserID == *q.UserID) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func TestAuthEvents_ListOwnEvents(t *testing.T) {
+	user, other, admin := uuid.New(), uuid.New(), uuid.New()
+	h := &AuthEventHandler{events: memAuthEventList{
+		{UserID: &user, Type: "auth.login_succeeded", IP: "198.51.100.4", UserAgent: "app"},
+		{UserID: &user, ActorID: &admin, Type: "audit.user.blocked", IP: "10.0.0.1", UserAgent: "admin browser"},
+		{UserID: &other, Type: "auth.login_succeeded"},
+	}}
+
+	w, resp := callSessions(h.List, user, uuid.Nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("list = %d %v", w.Code, resp)
+	}
+	events, _ := resp["events"].([]interface{})
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want the user's 2", len(events))
+	}
+	own, byAdmin := events[0].(map[string]interface{}), events[1].(map[string]interface{})
+	if own["ip"] != "198.51.100.4" || own["user_agent"] != "app" {
+		t.Errorf("own event lost its client: %v", own)
+	}
+	if byAdmin["ip"] != nil || byAdmin["user_agent"] != nil {
+		t.Errorf("admin's client leaked to the user: %v", byAdmin)
+	}
+}
+
+func TestAuthEvents_ListAllRejectsBadFilter(t *testing.T) {
+	h := &AuthEventHandler{events: memAuthEventList{}}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?user_id=nope", nil)
+	h.ListAll(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
@@ -1,12 +1,29 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
 
 	"github.com/sprobst76/vibedterm-server/internal/config"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
 )
 
 func TestHashToken_Deterministic(t *testing.T) {
@@ -36,53 +53,6 @@ func TestHashToken_NonEmpty(t *testing.T) {
 	}
 }
 
-func TestSplitDeviceInfo_Normal(t *testing.T) {
-	parts := splitDeviceInfo("Device|phone")
-	if len(parts) != 2 {
-		t.Fatalf("splitDeviceInfo returned %d parts, want 2", len(parts))
-	}
-	if parts[0] != "Device" {
-		t.Errorf("parts[0] = %q, want %q", parts[0], "Device")
-	}
-	if parts[1] != "phone" {
-		t.Errorf("parts[1] = %q, want %q", parts[1], "phone")
-	}
-}
-
-func TestSplitDeviceInfo_PipeInDeviceName(t *testing.T) {
-	// "My|Device|phone" should split on the LAST pipe
-	parts := splitDeviceInfo("My|Device|phone")
-	if len(parts) != 2 {
-		t.Fatalf("splitDeviceInfo returned %d parts, want 2", len(parts))
-	}
-	if parts[0] != "My|Device" {
-		t.Errorf("parts[0] = %q, want %q", parts[0], "My|Device")
-	}
-	if parts[1] != "phone" {
-		t.Errorf("parts[1] = %q, want %q", parts[1], "phone")
-	}
-}
-
-func TestSplitDeviceInfo_NoPipe(t *testing.T) {
-	parts := splitDeviceInfo("justname")
-	if len(parts) != 1 {
-		t.Fatalf("splitDeviceInfo returned %d parts, want 1", len(parts))
-	}
-	if parts[0] != "justname" {
-		t.Errorf("parts[0] = %q, want %q", parts[0], "justname")
-	}
-}
-
-func TestSplitDeviceInfo_EmptyString(t *testing.T) {
-	parts := splitDeviceInfo("")
-	if len(parts) != 1 {
-		t.Fatalf("splitDeviceInfo returned %d parts, want 1", len(parts))
-	}
-	if parts[0] != "" {
-		t.Errorf("parts[0] = %q, want empty string", parts[0])
-	}
-}
-
 func TestGenerateAndParseTempToken(t *testing.T) {
 	cfg := &config.Config{
 		JWTSecret: "test-jwt-secret-for-temp-tokens",
@@ -93,7 +63,7 @@ func TestGenerateAndParseTempToken(t *testing.T) {
 	deviceName := "My Phone"
 	deviceType := "android"
 
-	token, err := h.generateTempToken(userID, deviceName, deviceType)
+	token, err := h.generateTempToken(userID, loginDevice{Name: deviceName, Type: deviceType})
 	if err != nil {
 		t.Fatalf("generateTempToken failed: %v", err)
 	}
@@ -130,7 +100,7 @@ func TestParseTempToken_WrongSecret(t *testing.T) {
 	h1 := &AuthHandler{config: &config.Config{JWTSecret: "secret-1"}}
 	h2 := &AuthHandler{config: &config.Config{JWTSecret: "secret-2"}}
 
-	token, err := h1.generateTempToken(uuid.New(), "dev", "type")
+	token, err := h1.generateTempToken(uuid.New(), loginDevice{Name: "dev", Type: "type"})
 	if err != nil {
 		t.Fatalf("generateTempToken failed: %v", err)
 	}
@@ -146,7 +116,7 @@ func TestGenerateTempToken_PipeInDeviceName(t *testing.T) {
 	h := &AuthHandler{config: cfg}
 
 	// Device name contains a pipe character
-	token, err := h.generateTempToken(uuid.New(), "My|Device", "phone")
+	token, err := h.generateTempToken(uuid.New(), loginDevice{Name: "My|Device", Type: "phone"})
 	if err != nil {
 		t.Fatalf("generateTempToken failed: %v", err)
 	}
@@ -163,6 +133,69 @@ func TestGenerateTempToken_PipeInDeviceName(t *testing.T) {
 	}
 }
 
+func TestGenerateTempToken_PipeInDeviceType(t *testing.T) {
+	h := &AuthHandler{config: &config.Config{JWTSecret: "test-secret"}}
+
+	token, err := h.generateTempToken(uuid.New(), loginDevice{Name: "Laptop", Type: "desk|top"})
+	if err != nil {
+		t.Fatalf("generateTempToken failed: %v", err)
+	}
+
+	_, gotName, gotType, err := h.parseTempToken(token)
+	if err != nil {
+		t.Fatalf("parseTempToken failed: %v", err)
+	}
+	if gotName != "Laptop" || gotType != "desk|top" {
+		t.Errorf("device = %q/%q, want Laptop/desk|top", gotName, gotType)
+	}
+}
+
+func TestParseTempToken_RejectsAccessToken(t *testing.T) {
+	secret := "test-secret"
+	h := &AuthHandler{config: &config.Config{JWTSecret: secret}}
+
+	access, err := middleware.GenerateToken(uuid.New(), "user@example.com", uuid.New(), false, secret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	if _, _, _, err := h.parseTempToken(access); !errors.Is(err, middleware.ErrWrongTokenType) {
+		t.Errorf("parseTempToken(access token) error = %v, want ErrWrongTokenType", err)
+	}
+}
+
+func TestTempToken_CannotCallProtectedEndpoints(t *testing.T) {
+	secret := "test-secret"
+	h := &AuthHandler{config: &config.Config{JWTSecret: secret}}
+
+	r := gin.New()
+	r.Use(middleware.JWTMiddleware(secret))
+	r.GET("/api/v1/vault/pull", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	pull := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/vault/pull", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	temp, err := h.generateTempToken(uuid.New(), loginDevice{Name: "My Phone", Type: "android"})
+	if err != nil {
+		t.Fatalf("generateTempToken failed: %v", err)
+	}
+	if code := pull(temp); code != http.StatusUnauthorized {
+		t.Errorf("temp token: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	access, err := middleware.GenerateToken(uuid.New(), "user@example.com", uuid.New(), false, secret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	if code := pull(access); code != http.StatusOK {
+		t.Errorf("access token: status = %d, want %d", code, http.StatusOK)
+	}
+}
+
 func TestGenerateSecureToken_Unique(t *testing.T) {
 	t1 := generateSecureToken()
 	t2 := generateSecureToken()
@@ -199,7 +232,7 @@ func TestGenerateTempToken_ShortExpiry(t *testing.T) {
 	cfg := &config.Config{JWTSecret: "secret"}
 	h := &AuthHandler{config: cfg}
 
-	token, err := h.generateTempToken(uuid.New(), "dev", "type")
+	token, err := h.generateTempToken(uuid.New(), loginDevice{Name: "dev", Type: "type"})
 	if err != nil {
 		t.Fatalf("generateTempToken failed: %v", err)
 	}
@@ -214,3 +247,612 @@ func TestGenerateTempToken_ShortExpiry(t *testing.T) {
 	// that the token was generated with expected claims
 	_ = time.Now() // Just ensuring the function works without issues
 }
+
+// memTempTokenStore is an in-memory tempTokenStore
+type memTempTokenStore struct {
+	mu       sync.Mutex
+	extended map[uuid.UUID]time.Time
+	used     map[uuid.UUID]time.Time
+	attempts map[uuid.UUID]int
+}
+
+func (m *memTempTokenStore) MarkExtended(_ context.Context, jti uuid.UUID, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.extended[jti]; ok {
+		return false, nil
+	}
+	m.extended[jti] = expiresAt
+	return true, nil
+}
+
+func (m *memTempTokenStore) MarkUsed(_ context.Context, jti uuid.UUID, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.used[jti]; ok {
+		return false, nil
+	}
+	m.used[jti] = expiresAt
+	return true, nil
+}
+
+func (m *memTempTokenStore) Used(_ context.Context, jti uuid.UUID) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.used[jti]
+	return ok, nil
+}
+
+func (m *memTempTokenStore) RecordRecoveryAttempt(_ context.Context, jti uuid.UUID, _ time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts[jti]++
+	return m.attempts[jti], nil
+}
+
+func (m *memTempTokenStore) RecoveryAttempts(_ context.Context, jti uuid.UUID) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.attempts[jti], nil
+}
+
+func (m *memTempTokenStore) DeleteExpired(_ context.Context) (int64, error) {
+	return 0, nil
+}
+
+func newMemTempTokenStore() *memTempTokenStore {
+	return &memTempTokenStore{
+		extended: make(map[uuid.UUID]time.Time),
+		used:     make(map[uuid.UUID]time.Time),
+		attempts: make(map[uuid.UUID]int),
+	}
+}
+
+func newTempTokenTestHandler(users ...*models.User) *AuthHandler {
+	m := &memAuthStores{users: map[uuid.UUID]*models.User{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+	return &AuthHandler{
+		userRepo:   m,
+		config:     &config.Config{JWTSecret: "temp-token-secret", TOTPTempTokenDuration: 2 * time.Minute},
+		tempTokens: newMemTempTokenStore(),
+	}
+}
+
+func postJSON(t *testing.T, handler gin.HandlerFunc, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	payload, _ := json.Marshal(body)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/", bytes.NewReader(payload))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestExtendTempToken_OnlyOnce(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Email: "phone@example.com", TOTPEnabled: true}
+	h := newTempTokenTestHandler(user)
+
+	original, err := h.generateTempToken(user.ID, loginDevice{Name: "My Phone", Type: "android"})
+	if err != nil {
+		t.Fatalf("generateTempToken failed: %v", err)
+	}
+
+	w, resp := postJSON(t, h.ExtendTempToken, gin.H{"temp_token": original})
+	if w.Code != http.StatusOK {
+		t.Fatalf("first extension status = %d, want %d (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+	if resp["expires_in"] != float64(120) {
+		t.Errorf("expires_in = %v, want 120", resp["expires_in"])
+	}
+	extended, _ := resp["temp_token"].(string)
+	if extended == "" || extended == original {
+		t.Fatal("expected a fresh temp token")
+	}
+
+	// The re-issued token keeps the device info
+	_, name, deviceType, err := h.parseTempToken(extended)
+	if err != nil {
+		t.Fatalf("parseTempToken failed on extended token: %v", err)
+	}
+	if name != "My Phone" || deviceType != "android" {
+		t.Errorf("device info = %q/%q, want %q/%q", name, deviceType, "My Phone", "android")
+	}
+
+	// Neither the original nor the re-issued token can be extended again
+	for _, token := range []string{original, extended} {
+		w, resp = postJSON(t, h.ExtendTempToken, gin.H{"temp_token": token})
+		if w.Code != http.StatusConflict {
+			t.Errorf("repeat extension status = %d, want %d", w.Code, http.StatusConflict)
+		}
+		if resp["code"] != "TEMP_TOKEN_ALREADY_EXTENDED" {
+			t.Errorf("code = %v, want TEMP_TOKEN_ALREADY_EXTENDED", resp["code"])
+		}
+	}
+}
+
+func TestTempTokenErrors_Differentiated(t *testing.T) {
+	h := newTempTokenTestHandler()
+
+	expired, err := middleware.GenerateToken(uuid.New(), "dev|type", uuid.Nil, false, h.config.JWTSecret, -time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		body    gin.H
+		code    string
+	}{
+		{"extend expired", h.ExtendTempToken, gin.H{"temp_token": expired}, "TEMP_TOKEN_EXPIRED"},
+		{"extend garbage", h.ExtendTempToken, gin.H{"temp_token": "garbage"}, "TEMP_TOKEN_INVALID"},
+		{"validate expired", h.ValidateTOTP, gin.H{"temp_token": expired, "code": "123456"}, "TEMP_TOKEN_EXPIRED"},
+		{"validate garbage", h.ValidateTOTP, gin.H{"temp_token": "garbage", "code": "123456"}, "TEMP_TOKEN_INVALID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, resp := postJSON(t, tt.handler, tt.body)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if resp["code"] != tt.code {
+				t.Errorf("code = %v, want %s", resp["code"], tt.code)
+			}
+		})
+	}
+}
+
+// memAuthStores backs the user, device and refresh token stores of AuthHandler
+type memAuthStores struct {
+	users   map[uuid.UUID]*models.User
+	devices map[uuid.UUID]*models.Device
+	tokens  []*models.RefreshToken
+
+	requireApproval map[uuid.UUID]bool // users whose new devices need approval
+}
+
+func (m *memAuthStores) GetByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	if u, ok := m.users[id]; ok {
+		return u, nil
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *memAuthStores) GetByEmail(_ context.Context, email string) (*models.User, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *memAuthStores) UpdateLastLogin(context.Context, uuid.UUID) error { return nil }
+
+func (m *memAuthStores) RehashPassword(context.Context, uuid.UUID, string, string) error { return nil }
+
+// Create upserts by user and device name like DeviceRepository
+func (m *memAuthStores) Create(_ context.Context, userID uuid.UUID, name, deviceType, model, appVersion string) (*models.Device, bool, error) {
+	for _, d := range m.devices {
+		if d.UserID == userID && d.DeviceName == name {
+			d.DeviceType = deviceType
+			if model != "" {
+				d.DeviceModel = model
+			}
+			if appVersion != "" {
+				d.AppVersion = appVersion
+			}
+			return d, false, nil
+		}
+	}
+	approved := true
+	for _, d := range m.devices {
+		if d.UserID == userID && d.IsApproved && m.requireApproval[userID] {
+			approved = false
+		}
+	}
+	d := &models.Device{ID: uuid.New(), UserID: userID, DeviceName: name, DeviceType: deviceType, DeviceModel: model, AppVersion: appVersion, CreatedAt: time.Now(), IsApproved: approved}
+	m.devices[d.ID] = d
+	return d, true, nil
+}
+
+func (m *memAuthStores) UpdateActivity(_ context.Context, id uuid.UUID, ip, userAgent string) error {
+	if d, ok := m.devices[id]; ok {
+		d.LastIP, d.LastUserAgent = ip, userAgent
+	}
+	return nil
+}
+
+// authDevices adapts memAuthStores to the device store of service.TokenRefresh
+type authDevices struct{ m *memAuthStores }
+
+func (d authDevices) GetByID(_ context.Context, id uuid.UUID) (*models.Device, error) {
+	if device, ok := d.m.devices[id]; ok {
+		return device, nil
+	}
+	return nil, repository.ErrDeviceNotFound
+}
+
+func (d authDevices) TouchLastSeen(_ context.Context, id uuid.UUID) error {
+	now := time.Now()
+	d.m.devices[id].LastSeenAt = &now
+	return nil
+}
+
+func (d authDevices) UpdateActivity(ctx context.Context, id uuid.UUID, ip, userAgent string) error {
+	return d.m.UpdateActivity(ctx, id, ip, userAgent)
+}
+
+// authRefreshTokens adapts memAuthStores to authRefreshStore
+type authRefreshTokens struct{ m *memAuthStores }
+
+func (r authRefreshTokens) Create(_ context.Context, userID, deviceID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	id := uuid.New()
+	token := &models.RefreshToken{ID: id, UserID: userID, DeviceID: deviceID, FamilyID: id, TokenHash: tokenHash, ExpiresAt: expiresAt}
+	r.m.tokens = append(r.m.tokens, token)
+	return token, nil
+}
+
+func (r authRefreshTokens) GetByTokenHash(_ context.Context, tokenHash string) (*models.RefreshToken, error) {
+	for _, t := range r.m.tokens {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+	return nil, repository.ErrRefreshTokenNotFound
+}
+
+func (r authRefreshTokens) Rotate(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	old, err := r.GetByTokenHash(ctx, oldHash)
+	if err != nil {
+		return nil, err
+	}
+	if old.Revoked {
+		return nil, repository.ErrRefreshTokenRotated
+	}
+	next := &models.RefreshToken{ID: uuid.New(), UserID: old.UserID, DeviceID: old.DeviceID, FamilyID: old.FamilyID, TokenHash: newHash, ExpiresAt: expiresAt}
+	old.Revoked, old.ReplacedBy = true, &next.ID
+	r.m.tokens = append(r.m.tokens, next)
+	return next, nil
+}
+
+func (r authRefreshTokens) Revoke(_ context.Context, tokenHash string) error {
+	for _, t := range r.m.tokens {
+		if t.TokenHash == tokenHash {
+			t.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (r authRefreshTokens) RevokeAllForUser(context.Context, uuid.UUID) error { return nil }
+
+// fixedRefresher rotates every refresh token into the same family
+type fixedRefresher struct {
+	user   *models.User
+	family uuid.UUID
+}
+
+func (f fixedRefresher) Refresh(context.Context, string, string, time.Time) (*models.User, *models.Device, *models.RefreshToken, error) {
+	return f.user, &models.Device{ID: uuid.New()}, &models.RefreshToken{ID: uuid.New(), FamilyID: f.family}, nil
+}
+
+func newGrantTestHandler(t testing.TB, users ...*models.User) (*AuthHandler, *memAuthStores) {
+	t.Helper()
+	m := &memAuthStores{users: map[uuid.UUID]*models.User{}, devices: map[uuid.UUID]*models.Device{}}
+	for _, u := range users {
+		m.users[u.ID] = u
+	}
+	return &AuthHandler{
+		userRepo:    m,
+		deviceRepo:  m,
+		refreshRepo: authRefreshTokens{m},
+		tempTokens:  newMemTempTokenStore(),
+		totpGuard:   service.NewTOTPGuard(nopAudit{}, 5, 5*time.Minute),
+		config:      &config.Config{JWTSecret: "grant-secret", AccessTokenDuration: time.Minute},
+	}, m
+}
+
+// assertGrant checks the token_type, scope and auth_session_id of a response
+func assertGrant(t *testing.T, name string, resp map[string]interface{}, scope string, session uuid.UUID) {
+	t.Helper()
+	if resp["token_type"] != "Bearer" || resp["scope"] != scope || resp["auth_session_id"] != session.String() {
+		t.Errorf("%s: grant = %v %v %v, want Bearer %q %s", name, resp["token_type"], resp["scope"], resp["auth_session_id"], scope, session)
+	}
+}
+
+func TestTokenGrant_Login(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	admin := &models.User{ID: uuid.New(), Email: "admin@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true, IsAdmin: true}
+	h, m := newGrantTestHandler(t, user, admin)
+
+	for _, tc := range []struct {
+		user  *models.User
+		scope string
+	}{
+		{user, "account vault:read vault:write devices"},
+		{admin, "account vault:read vault:write devices admin"},
+	} {
+		w, resp := postJSON(t, h.Login, gin.H{"email": tc.user.Email, "password": "correct horse", "device_name": "Laptop", "device_type": "linux"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: login = %d %s", tc.user.Email, w.Code, w.Body.String())
+		}
+		assertGrant(t, tc.user.Email, resp, tc.scope, m.tokens[len(m.tokens)-1].FamilyID)
+	}
+}
+
+func TestLogin_AppVersion(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	h, m := newGrantTestHandler(t, user)
+	h.config.MinSupportedAppVersion = "2.0"
+	h.config.MinRequiredAppVersion = "1.5"
+
+	login := func(appVersion string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return postJSON(t, h.Login, gin.H{"email": user.Email, "password": "correct horse", "device_name": "Laptop", "device_type": "linux",
+			"device_model": "ThinkPad X1", "app_version": appVersion})
+	}
+
+	if w, resp := login("1.4.9"); w.Code != http.StatusUpgradeRequired || resp["code"] != "APP_VERSION_UNSUPPORTED" {
+		t.Errorf("below the floor: %d %v", w.Code, resp)
+	}
+	if len(m.devices) != 0 {
+		t.Error("rejected login registered a device")
+	}
+
+	w, resp := login("1.9.0")
+	if w.Code != http.StatusOK || resp["warning"] == nil {
+		t.Fatalf("outdated: %d %v", w.Code, resp)
+	}
+	for _, d := range m.devices {
+		if d.AppVersion != "1.9.0" || d.DeviceModel != "ThinkPad X1" {
+			t.Errorf("device = %q %q, want the reported model and version", d.DeviceModel, d.AppVersion)
+		}
+	}
+
+	if w, resp := login("2.1"); w.Code != http.StatusOK || resp["warning"] != nil {
+		t.Errorf("current: %d %v", w.Code, resp)
+	}
+}
+
+func TestLogin_NotifiesNewDevice(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	h, _ := newGrantTestHandler(t, user)
+	notifier := &recordingNotifier{}
+	h.newDevices = notifier
+
+	login := func(deviceName string) {
+		t.Helper()
+		w, _ := postJSON(t, h.Login, gin.H{"email": user.Email, "password": "correct horse", "device_name": deviceName, "device_type": "linux"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("login = %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	login("Laptop")
+	if len(notifier.sent) != 1 {
+		t.Fatalf("%d notifications for a new device, want 1", len(notifier.sent))
+	}
+	if n := notifier.sent[0]; n.Kind != notify.KindNewDeviceLogin || n.UserID != user.ID || !strings.Contains(n.Body, `"Laptop" (linux)`) {
+		t.Errorf("notification = %+v", n)
+	}
+
+	login("Laptop")
+	if len(notifier.sent) != 1 {
+		t.Errorf("existing device notified again: %d notifications", len(notifier.sent))
+	}
+
+	// A failing notifier does not fail the login
+	h.newDevices = failingNotifier{}
+	login("Phone")
+}
+
+func TestLogin_PendingDeviceApproval(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	h, m := newGrantTestHandler(t, user)
+	m.requireApproval = map[uuid.UUID]bool{user.ID: true}
+	newDevices, approvals := &recordingNotifier{}, &recordingNotifier{}
+	h.newDevices, h.approvals = newDevices, approvals
+
+	login := func(deviceName string) map[string]interface{} {
+		t.Helper()
+		w, resp := postJSON(t, h.Login, gin.H{"email": user.Email, "password": "correct horse", "device_name": deviceName, "device_type": "linux"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("login = %d %s", w.Code, w.Body.String())
+		}
+		return resp
+	}
+
+	// The first device is approved so the account is never locked out
+	if resp := login("Laptop"); resp["device_pending_approval"] != nil || len(approvals.sent) != 0 {
+		t.Errorf("first device pending: %v, %d approval requests", resp["device_pending_approval"], len(approvals.sent))
+	}
+	resp := login("Phone")
+	if resp["device_pending_approval"] != true || resp["access_token"] == "" {
+		t.Errorf("second device: pending %v, tokens issued %v", resp["device_pending_approval"], resp["access_token"] != "")
+	}
+	if len(approvals.sent) != 1 || approvals.sent[0].Kind != notify.KindDevicePending || len(newDevices.sent) != 1 {
+		t.Errorf("approval requests = %+v, new device notifications = %d", approvals.sent, len(newDevices.sent))
+	}
+}
+
+func TestLogin_SameDeviceNameReusesDevice(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	h, m := newGrantTestHandler(t, user)
+
+	var deviceIDs []uuid.UUID
+	for i := 0; i < 2; i++ {
+		w, _ := postJSON(t, h.Login, gin.H{"email": user.Email, "password": "correct horse", "device_name": "Laptop", "device_type": "linux"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("login %d = %d %s", i+1, w.Code, w.Body.String())
+		}
+		deviceIDs = append(deviceIDs, m.tokens[len(m.tokens)-1].DeviceID)
+	}
+
+	if deviceIDs[0] != deviceIDs[1] {
+		t.Errorf("second login got device %s, want %s", deviceIDs[1], deviceIDs[0])
+	}
+	if _, ok := m.devices[deviceIDs[1]]; !ok || len(m.devices) != 1 {
+		t.Errorf("refresh token names device %s, devices = %v", deviceIDs[1], m.devices)
+	}
+}
+
+func TestLogin_RecordsDeviceActivity(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	h, m := newGrantTestHandler(t, user)
+
+	w, _ := postJSON(t, h.Login, gin.H{"email": user.Email, "password": "correct horse", "device_name": "Laptop", "device_type": "linux"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", w.Code, w.Body.String())
+	}
+	for _, d := range m.devices {
+		if d.LastIP != "192.0.2.1" {
+			t.Errorf("device last_ip = %q, want the client address", d.LastIP)
+		}
+	}
+}
+
+func TestTokenGrant_TOTPLogin(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	user := &models.User{ID: uuid.New(), Email: "totp@example.com", EmailVerified: true, IsApproved: true, TOTPEnabled: true, TOTPSecret: secret}
+	h, m := newGrantTestHandler(t, user)
+
+	tempToken, err := h.generateTempToken(user.ID, loginDevice{Name: "Phone", Type: "android"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.GenerateCode(base32.StdEncoding.EncodeToString(secret), time.Now())
+	w, resp := postJSON(t, h.ValidateTOTP, gin.H{"temp_token": tempToken, "code": code})
+	if w.Code != http.StatusOK {
+		t.Fatalf("TOTP login = %d %s", w.Code, w.Body.String())
+	}
+	assertGrant(t, "TOTP login", resp, "account vault:read vault:write devices", m.tokens[0].FamilyID)
+}
+
+func TestTokenGrant_Refresh(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Email: "user@example.com"}
+	family := uuid.New()
+	h, _ := newGrantTestHandler(t, user)
+	h.tokenRefresh = fixedRefresher{user: user, family: family}
+
+	w, resp := postJSON(t, h.Refresh, gin.H{"refresh_token": "old"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh = %d %s", w.Code, w.Body.String())
+	}
+	// The session ID stays that of the login across refreshes
+	assertGrant(t, "refresh", resp, "account vault:read vault:write devices", family)
+}
+
+func TestLogin_UnknownEmailHashesLikeWrongPassword(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	h, _ := newGrantTestHandler(t, user)
+
+	var bodies []string
+	for _, email := range []string{"user@example.com", "nobody@example.com"} {
+		before := password.CurrentStats().Compares
+		w, _ := postJSON(t, h.Login, gin.H{"email": email, "password": "wrong guess", "device_name": "Laptop", "device_type": "linux"})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d", email, w.Code)
+		}
+		if compares := password.CurrentStats().Compares - before; compares != 1 {
+			t.Errorf("%s: %d hash comparisons, want 1", email, compares)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("responses differ: %s vs %s", bodies[0], bodies[1])
+	}
+}
+
+func TestRefresh_DeletedDeviceInvalidatesTokens(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	h, m := newGrantTestHandler(t, user)
+	h.config.RefreshTokenDuration = time.Hour
+	h.tokenRefresh = service.NewTokenRefresh(authRefreshTokens{m}, m, authDevices{m}, nil)
+
+	w, resp := postJSON(t, h.Login, gin.H{"email": user.Email, "password": "correct horse", "device_name": "Laptop", "device_type": "linux"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", w.Code, w.Body.String())
+	}
+	w, resp = postJSON(t, h.Refresh, gin.H{"refresh_token": resp["refresh_token"]})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh = %d %s", w.Code, w.Body.String())
+	}
+	device := m.devices[m.tokens[0].DeviceID]
+	if device.LastSeenAt == nil {
+		t.Error("refresh did not update the device's last seen time")
+	}
+
+	// Removed without revoking its tokens, e.g. by a cascade
+	delete(m.devices, device.ID)
+	refreshToken := resp["refresh_token"]
+	w, resp = postJSON(t, h.Refresh, gin.H{"refresh_token": refreshToken})
+	if w.Code != http.StatusUnauthorized || resp["code"] != "DEVICE_MISMATCH" {
+		t.Fatalf("refresh for deleted device = %d %v", w.Code, resp)
+	}
+	// The token stays dead even if a device with that ID were to come back
+	m.devices[device.ID] = device
+	if w, _ = postJSON(t, h.Refresh, gin.H{"refresh_token": refreshToken}); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token refresh = %d", w.Code)
+	}
+}
+
+func TestRegister_ClosedAndInviteErrors(t *testing.T) {
+	tests := []struct {
+		mode   string
+		body   map[string]string
+		status int
+		code   string
+	}{
+		{service.RegistrationClosed, map[string]string{"email": "a@example.com", "password": "password123"}, http.StatusForbidden, "REGISTRATION_DISABLED"},
+		{service.RegistrationInvite, map[string]string{"email": "a@example.com", "password": "password123"}, http.StatusBadRequest, "INVITE_REQUIRED"},
+	}
+	for _, tc := range tests {
+		registration := service.NewRegistration(nil, nil, nil, nil, nil, nil, nil, service.RegistrationPolicy{Mode: tc.mode})
+		h := &AuthHandler{registration: registration, config: &config.Config{}}
+		w, resp := postJSON(t, h.Register, tc.body)
+		if w.Code != tc.status || resp["code"] != tc.code {
+			t.Errorf("%s: %d %v, want %d %s", tc.mode, w.Code, resp, tc.status, tc.code)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// bootstrapRunner is the subset of service.Bootstrap needed by the handler
+type bootstrapRunner interface {
+	Run(ctx context.Context, req *models.BootstrapRequest) (*models.BootstrapResponse, error)
+}
+
+// BootstrapHandler handles non-interactive instance setup
+type BootstrapHandler struct {
+	bootstrap bootstrapRunner
+}
+
+// NewBootstrapHandler creates a new bootstrap handler
+func NewBootstrapHandler(bootstrap *service.Bootstrap) *BootstrapHandler {
+	return &BootstrapHandler{bootstrap: bootstrap}
+}
+
+// Bootstrap creates the first admin, initial settings and an optional
+// vault API key using the one-time token printed at first boot
+func (h *BootstrapHandler) Bootstrap(c *gin.Context) {
+	var req models.BootstrapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+
+	resp, err := h.bootstrap.Run(c.Request.Context(), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrBootstrapComplete):
+			apierror.RespondError(c, http.StatusGone, "BOOTSTRAP_COMPLETE", "instance already set up", nil)
+		case errors.Is(err, service.ErrInvalidBootstrapToken):
+			apierror.RespondError(c, http.StatusUnauthorized, "INVALID_BOOTSTRAP_TOKEN", "invalid or expired bootstrap token", nil)
+		case errors.Is(err, service.ErrUnknownSetting), errors.Is(err, service.ErrInvalidSettingValue):
+			apierror.RespondError(c, http.StatusBadRequest, "INVALID_SETTING", err.Error(), nil)
+		case errors.Is(err, password.ErrBusy):
+			respondBusy(c)
+		default:
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "bootstrap failed", nil)
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
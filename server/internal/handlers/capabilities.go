@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// requirementsSource returns the effective registration requirements
+type requirementsSource interface {
+	Requirements(ctx context.Context) (*models.AuthRequirements, error)
+}
+
+// requirementsMaxAge lets clients and proxies cache the requirements
+// briefly; admins may change them at runtime
+const requirementsMaxAge = "public, max-age=60"
+
+// CapabilitiesHandler tells clients which optional features are enabled
+// and what registration requires
+type CapabilitiesHandler struct {
+	capabilities models.Capabilities
+	requirements requirementsSource
+}
+
+// NewCapabilitiesHandler creates a new capabilities handler
+func NewCapabilitiesHandler(capabilities models.Capabilities, requirements requirementsSource) *CapabilitiesHandler {
+	return &CapabilitiesHandler{capabilities: capabilities, requirements: requirements}
+}
+
+// Get returns the capabilities of this deployment
+func (h *CapabilitiesHandler) Get(c *gin.Context) {
+	capabilities := h.capabilities
+	if req, err := h.requirements.Requirements(c.Request.Context()); err == nil {
+		capabilities.Registration = models.RegistrationCapabilities{
+			Mode:                req.Registration.Mode,
+			DisplayNameRequired: req.Registration.DisplayNameRequired,
+		}
+	}
+	c.JSON(http.StatusOK, capabilities)
+}
+
+// Requirements returns the password policy and registration requirements,
+// so clients can validate input before submitting it
+func (h *CapabilitiesHandler) Requirements(c *gin.Context) {
+	req, err := h.requirements.Requirements(c.Request.Context())
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to load requirements", nil)
+		return
+	}
+	c.Header("Cache-Control", requirementsMaxAge)
+	c.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+type memSettings map[string]string
+
+func (m memSettings) All(context.Context) (map[string]string, error) { return m, nil }
+
+func (m memSettings) Set(_ context.Context, key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func TestRequirements_TracksRuntimeSettings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	settings := memSettings{}
+	registration := service.NewRegistration(nil, nil, nil, settings, nil, nil, nil, service.RegistrationPolicy{
+		Mode:               service.RegistrationOpen,
+		RequireDisplayName: true,
+	})
+	h := NewCapabilitiesHandler(models.Capabilities{}, registration)
+
+	get := func() (*httptest.ResponseRecorder, models.AuthRequirements) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/api/v1/auth/requirements", nil)
+		h.Requirements(c)
+		var req models.AuthRequirements
+		if err := json.Unmarshal(w.Body.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		return w, req
+	}
+
+	w, req := get()
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Cache-Control"), "max-age=") {
+		t.Fatalf("status %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	if req.Password.MinLength != 8 || req.Registration.Mode != "open" || !req.Registration.DisplayNameRequired ||
+		!req.Registration.EmailVerificationRequired || req.Registration.InviteRequired || req.Registration.EmailDomainPattern != "" || req.Captcha != nil {
+		t.Errorf("requirements = %+v", req)
+	}
+
+	if err := registration.SaveAccess(context.Background(), service.RegistrationInvite, []string{"Example.com", "corp.org"}); err != nil {
+		t.Fatal(err)
+	}
+	_, req = get()
+	if req.Registration.Mode != "invite" || !req.Registration.InviteRequired || len(req.Registration.AllowedEmailDomains) != 2 {
+		t.Errorf("requirements after change = %+v", req)
+	}
+	pattern := regexp.MustCompile("(?i)" + req.Registration.EmailDomainPattern)
+	if !pattern.MatchString("a@EXAMPLE.com") || pattern.MatchString("a@example.com.evil.io") || pattern.MatchString("a@examplexcom") {
+		t.Errorf("pattern %q", req.Registration.EmailDomainPattern)
+	}
+}
@@ -1,44 +1,94 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
 	"github.com/sprobst76/vibedterm-server/internal/middleware"
 	"github.com/sprobst76/vibedterm-server/internal/models"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
 )
 
 // DeviceHandler handles device management endpoints
 type DeviceHandler struct {
 	deviceRepo  *repository.DeviceRepository
 	refreshRepo *repository.RefreshTokenRepository
+	vaultRepo   *repository.VaultRepository
+	updates     deviceUpdateStore
+	approvals   deviceApprovalStore
+}
+
+// deviceApprovalStore is the subset of DeviceRepository needed to approve
+// devices and gate the vault on approval
+type deviceApprovalStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error)
+	Approve(ctx context.Context, id uuid.UUID) error
+}
+
+// deviceUpdateStore is the subset of DeviceRepository needed to update
+// devices
+type deviceUpdateStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error)
+	Update(ctx context.Context, id uuid.UUID, name *string, readOnly *bool) error
 }
 
 // NewDeviceHandler creates a new device handler
 func NewDeviceHandler(
 	deviceRepo *repository.DeviceRepository,
 	refreshRepo *repository.RefreshTokenRepository,
+	vaultRepo *repository.VaultRepository,
 ) *DeviceHandler {
 	return &DeviceHandler{
 		deviceRepo:  deviceRepo,
 		refreshRepo: refreshRepo,
+		vaultRepo:   vaultRepo,
+		updates:     deviceRepo,
+		approvals:   deviceRepo,
 	}
 }
 
+// vaultRevisionSource is the subset of VaultRepository needed for device lag
+type vaultRevisionSource interface {
+	GetRevision(ctx context.Context, userID uuid.UUID, name string) (int, error)
+}
+
+// setDeviceLag sets how many revisions of the default vault each of the
+// user's devices is behind. Without a vault it leaves them unset.
+func setDeviceLag(ctx context.Context, vaults vaultRevisionSource, userID uuid.UUID, devices []models.Device) error {
+	head, err := vaults.GetRevision(ctx, userID, models.DefaultVaultName)
+	if errors.Is(err, repository.ErrVaultNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	service.SetRevisionLag(devices, head)
+	return nil
+}
+
 // List lists all devices for the current user
 func (h *DeviceHandler) List(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	devices, err := h.deviceRepo.GetByUserID(c.Request.Context(), userID)
+	if err == nil {
+		err = setDeviceLag(c.Request.Context(), h.vaultRepo, userID, devices)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list devices"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list devices", nil)
 		return
 	}
 
@@ -51,17 +101,17 @@ func (h *DeviceHandler) List(c *gin.Context) {
 func (h *DeviceHandler) Register(c *gin.Context) {
 	var req models.RegisterDeviceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
-	device, err := h.deviceRepo.Create(
+	device, _, err := h.deviceRepo.Create(
 		c.Request.Context(),
 		userID,
 		req.DeviceName,
@@ -70,54 +120,154 @@ func (h *DeviceHandler) Register(c *gin.Context) {
 		req.AppVersion,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register device"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to register device", nil)
 		return
 	}
+	_ = h.deviceRepo.UpdateActivity(c.Request.Context(), device.ID, c.ClientIP(), c.Request.UserAgent())
 
 	c.JSON(http.StatusCreated, device)
 }
 
-// Rename renames a device
-func (h *DeviceHandler) Rename(c *gin.Context) {
+// Update renames a device or changes its read-only flag. Names are
+// trimmed and unique per user.
+func (h *DeviceHandler) Update(c *gin.Context) {
 	deviceIDStr := c.Param("id")
 	deviceID, err := uuid.Parse(deviceIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device ID"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid device ID", nil)
 		return
 	}
 
-	var req struct {
-		Name string `json:"name" binding:"required"`
-	}
+	var req models.UpdateDeviceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
+		return
+	}
+	if req.Name == nil && req.ReadOnly == nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "name or read_only required", nil)
 		return
 	}
+	if req.Name != nil {
+		name, err := input.Clean("name", input.KindDeviceName, strings.TrimSpace(*req.Name))
+		if err != nil {
+			apierror.RespondInvalidInput(c, input.Errors{*err.(*input.FieldError)})
+			return
+		}
+		if name == "" {
+			apierror.RespondInvalidInput(c, input.Errors{{Field: "name", Code: input.CodeRequired, Message: "is required"}})
+			return
+		}
+		req.Name = &name
+	}
 
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	// Verify device belongs to user
-	device, err := h.deviceRepo.GetByID(c.Request.Context(), deviceID)
+	device, err := h.updates.GetByID(c.Request.Context(), deviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "device not found", nil)
 		return
 	}
 
 	if device.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		apierror.RespondError(c, http.StatusForbidden, apierror.CodeForbidden, "access denied", nil)
+		return
+	}
+
+	err = h.updates.Update(c.Request.Context(), deviceID, req.Name, req.ReadOnly)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrDeviceNameTaken):
+		apierror.RespondError(c, http.StatusConflict, "DEVICE_NAME_TAKEN", "another of your devices is already named "+strconv.Quote(*req.Name), nil)
+		return
+	case errors.Is(err, repository.ErrDeviceNotFound):
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "device not found", nil)
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to update device", nil)
 		return
 	}
 
-	if err := h.deviceRepo.UpdateName(c.Request.Context(), deviceID, req.Name); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rename device"})
+	device, err = h.updates.GetByID(c.Request.Context(), deviceID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to load device", nil)
+		return
+	}
+	c.JSON(http.StatusOK, device)
+}
+
+// Approve lets a device awaiting approval use the vault. Only an approved
+// device of the same user may approve it.
+func (h *DeviceHandler) Approve(c *gin.Context) {
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid device ID", nil)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "device renamed"})
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	callerID, err := middleware.GetDeviceID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusForbidden, "DEVICE_PENDING_APPROVAL", "only an approved device can approve devices", nil)
+		return
+	}
+	caller, err := h.approvals.GetByID(c.Request.Context(), callerID)
+	if err != nil || caller.UserID != userID || !caller.IsApproved {
+		apierror.RespondError(c, http.StatusForbidden, "DEVICE_PENDING_APPROVAL", "only an approved device can approve devices", nil)
+		return
+	}
+
+	device, err := h.approvals.GetByID(c.Request.Context(), deviceID)
+	if err != nil || device.UserID != userID {
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "device not found", nil)
+		return
+	}
+	if !device.IsApproved {
+		if err := h.approvals.Approve(c.Request.Context(), deviceID); err != nil {
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to approve device", nil)
+			return
+		}
+		device.IsApproved = true
+	}
+
+	c.JSON(http.StatusOK, device)
+}
+
+// RequireApproved rejects requests from devices awaiting approval and
+// from devices that were removed, whose access tokens may not have
+// expired yet. Requests without a device, such as from API keys, pass.
+func (h *DeviceHandler) RequireApproved(c *gin.Context) {
+	deviceID, err := middleware.GetDeviceID(c)
+	if err != nil || deviceID == uuid.Nil {
+		c.Next()
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.AbortWithError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	device, err := h.approvals.GetByID(c.Request.Context(), deviceID)
+	switch {
+	case errors.Is(err, repository.ErrDeviceNotFound) || err == nil && device.UserID != userID:
+		apierror.AbortWithError(c, http.StatusUnauthorized, "DEVICE_REVOKED", "this device was removed, sign in again", nil)
+		return
+	case err != nil:
+		apierror.AbortWithError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to load device", nil)
+		return
+	case !device.IsApproved:
+		apierror.AbortWithError(c, http.StatusForbidden, "DEVICE_PENDING_APPROVAL", "this device awaits approval from another of your devices or the web interface", nil)
+		return
+	}
+	c.Next()
 }
 
 // Delete removes a device
@@ -125,25 +275,25 @@ func (h *DeviceHandler) Delete(c *gin.Context) {
 	deviceIDStr := c.Param("id")
 	deviceID, err := uuid.Parse(deviceIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device ID"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid device ID", nil)
 		return
 	}
 
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	// Verify device belongs to user
 	device, err := h.deviceRepo.GetByID(c.Request.Context(), deviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "device not found", nil)
 		return
 	}
 
 	if device.UserID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		apierror.RespondError(c, http.StatusForbidden, apierror.CodeForbidden, "access denied", nil)
 		return
 	}
 
@@ -152,7 +302,7 @@ func (h *DeviceHandler) Delete(c *gin.Context) {
 
 	// Delete device
 	if err := h.deviceRepo.Delete(c.Request.Context(), deviceID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete device"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to delete device", nil)
 		return
 	}
 
@@ -163,13 +313,13 @@ func (h *DeviceHandler) Delete(c *gin.Context) {
 func (h *DeviceHandler) GetCurrent(c *gin.Context) {
 	deviceID, err := middleware.GetDeviceID(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no device context"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "no device context", nil)
 		return
 	}
 
 	device, err := h.deviceRepo.GetByID(c.Request.Context(), deviceID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "device not found", nil)
 		return
 	}
 
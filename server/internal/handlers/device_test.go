@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memRevisions holds the vault revision of each user
+type memRevisions map[uuid.UUID]int
+
+func (m memRevisions) GetRevision(_ context.Context, userID uuid.UUID, _ string) (int, error) {
+	revision, ok := m[userID]
+	if !ok {
+		return 0, repository.ErrVaultNotFound
+	}
+	return revision, nil
+}
+
+func TestSetDeviceLag(t *testing.T) {
+	ctx := context.Background()
+	withVault, withoutVault := uuid.New(), uuid.New()
+	vaults := memRevisions{withVault: 4}
+	seen := 1
+
+	devices := []models.Device{{LastSeenRevision: &seen}, {}}
+	if err := setDeviceLag(ctx, vaults, withVault, devices); err != nil {
+		t.Fatal(err)
+	}
+	if *devices[0].BehindBy != 3 || *devices[1].BehindBy != 4 {
+		t.Errorf("behind by %d and %d, want 3 and 4", *devices[0].BehindBy, *devices[1].BehindBy)
+	}
+
+	devices = []models.Device{{LastSeenRevision: &seen}, {}}
+	if err := setDeviceLag(ctx, vaults, withoutVault, devices); err != nil {
+		t.Fatal(err)
+	}
+	if devices[0].BehindBy != nil || devices[1].BehindBy != nil {
+		t.Error("devices are behind although there is no vault")
+	}
+}
+
+// memDeviceUpdates enforces unique device names per user like the
+// devices table
+type memDeviceUpdates map[uuid.UUID]*models.Device
+
+func (m memDeviceUpdates) GetByID(_ context.Context, id uuid.UUID) (*models.Device, error) {
+	device, ok := m[id]
+	if !ok {
+		return nil, repository.ErrDeviceNotFound
+	}
+	cp := *device
+	return &cp, nil
+}
+
+func (m memDeviceUpdates) Update(_ context.Context, id uuid.UUID, name *string, readOnly *bool) error {
+	device, ok := m[id]
+	if !ok {
+		return repository.ErrDeviceNotFound
+	}
+	if name != nil {
+		for otherID, other := range m {
+			if otherID != id && other.UserID == device.UserID && other.DeviceName == *name {
+				return repository.ErrDeviceNameTaken
+			}
+		}
+		device.DeviceName = *name
+	}
+	if readOnly != nil {
+		device.ReadOnly = *readOnly
+	}
+	return nil
+}
+
+func (m memDeviceUpdates) Approve(_ context.Context, id uuid.UUID) error {
+	device, ok := m[id]
+	if !ok {
+		return repository.ErrDeviceNotFound
+	}
+	device.IsApproved = true
+	return nil
+}
+
+func TestDeviceApproval(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID, laptop, phone := uuid.New(), uuid.New(), uuid.New()
+	devices := memDeviceUpdates{
+		laptop: {ID: laptop, UserID: userID, DeviceName: "Laptop", IsApproved: true},
+		phone:  {ID: phone, UserID: userID, DeviceName: "Phone"},
+	}
+	h := &DeviceHandler{approvals: devices}
+
+	// request runs handler as caller; the next handler records whether
+	// the request got through
+	request := func(caller uuid.UUID, handler gin.HandlerFunc, target uuid.UUID) (*httptest.ResponseRecorder, bool) {
+		w := httptest.NewRecorder()
+		r := gin.New()
+		passed := false
+		r.POST("/:id", func(c *gin.Context) {
+			c.Set("user_id", userID)
+			c.Set("device_id", caller)
+		}, handler, func(*gin.Context) { passed = true })
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/"+target.String(), nil))
+		return w, passed
+	}
+
+	if w, passed := request(phone, h.RequireApproved, phone); passed || w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "DEVICE_PENDING_APPROVAL") {
+		t.Errorf("pending device reached the vault: %d %s", w.Code, w.Body.String())
+	}
+	if _, passed := request(laptop, h.RequireApproved, laptop); !passed {
+		t.Error("approved device was rejected")
+	}
+	if _, passed := request(uuid.Nil, h.RequireApproved, laptop); !passed {
+		t.Error("request without a device was rejected")
+	}
+
+	if w, _ := request(phone, h.Approve, phone); w.Code != http.StatusForbidden || devices[phone].IsApproved {
+		t.Errorf("pending device approved itself: %d", w.Code)
+	}
+	if w, _ := request(laptop, h.Approve, phone); w.Code != http.StatusOK || !devices[phone].IsApproved {
+		t.Errorf("approve = %d %s", w.Code, w.Body.String())
+	}
+	if _, passed := request(phone, h.RequireApproved, phone); !passed {
+		t.Error("approved device is still rejected")
+	}
+	if w, passed := request(uuid.New(), h.RequireApproved, laptop); passed || w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown device = %d, want 401", w.Code)
+	}
+}
+
+// A denied device is deleted, but its access token stays valid until it
+// expires; the vault must reject it anyway
+func TestRequireApproved_DeniedDeviceToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "device-test-secret"
+	userID, phone := uuid.New(), uuid.New()
+	devices := memDeviceUpdates{phone: {ID: phone, UserID: userID, DeviceName: "Phone", IsApproved: true}}
+	h := &DeviceHandler{approvals: devices}
+
+	r := gin.New()
+	r.GET("/api/v1/vault", middleware.JWTMiddleware(secret), h.RequireApproved, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	access, err := middleware.GenerateToken(userID, "user@example.com", phone, false, secret, 15*time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	pull := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v1/vault", nil)
+		req.Header.Set("Authorization", "Bearer "+access)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := pull(); code != http.StatusOK {
+		t.Fatalf("approved device = %d, want 200", code)
+	}
+	delete(devices, phone)
+	if code := pull(); code != http.StatusUnauthorized {
+		t.Errorf("denied device = %d, want 401", code)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	input.InstallBinding()
+	userID, laptop, phone := uuid.New(), uuid.New(), uuid.New()
+	devices := memDeviceUpdates{
+		laptop: {ID: laptop, UserID: userID, DeviceName: "Laptop"},
+		phone:  {ID: phone, UserID: userID, DeviceName: "Phone"},
+	}
+	h := &DeviceHandler{updates: devices}
+
+	update := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("PUT", "/api/v1/devices/"+phone.String(), strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{{Key: "id", Value: phone.String()}}
+		c.Set("user_id", userID)
+		h.Update(c)
+		return w
+	}
+
+	if w := update(`{"name":"Laptop"}`); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "DEVICE_NAME_TAKEN") {
+		t.Errorf("duplicate name = %d %s, want 409 DEVICE_NAME_TAKEN", w.Code, w.Body.String())
+	}
+	if w := update(`{"name":"   "}`); w.Code != http.StatusBadRequest {
+		t.Errorf("blank name = %d, want 400", w.Code)
+	}
+	if w := update(`{"name":"` + strings.Repeat("x", 101) + `"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("long name = %d, want 400", w.Code)
+	}
+	if w := update(`{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("empty update = %d, want 400", w.Code)
+	}
+	if w := update(`{"name":"  Tablet "}`); w.Code != http.StatusOK || devices[phone].DeviceName != "Tablet" {
+		t.Errorf("rename = %d, name %q, want 200 and trimmed name", w.Code, devices[phone].DeviceName)
+	}
+	if w := update(`{"read_only":true}`); w.Code != http.StatusOK || !devices[phone].ReadOnly || devices[phone].DeviceName != "Tablet" {
+		t.Errorf("read-only = %d %s, device %+v", w.Code, w.Body.String(), devices[phone])
+	} else if !strings.Contains(w.Body.String(), `"read_only":true`) {
+		t.Errorf("updated device lacks the flag: %s", w.Body.String())
+	}
+}
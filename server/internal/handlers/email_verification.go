@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// emailVerifier is the subset of service.EmailVerification needed by the handler
+type emailVerifier interface {
+	Verify(ctx context.Context, token string) (uuid.UUID, error)
+	Resend(ctx context.Context, email string) error
+}
+
+// EmailVerificationHandler handles email verification endpoints
+type EmailVerificationHandler struct {
+	verification emailVerifier
+}
+
+// NewEmailVerificationHandler creates a new email verification handler
+func NewEmailVerificationHandler(verification emailVerifier) *EmailVerificationHandler {
+	return &EmailVerificationHandler{verification: verification}
+}
+
+// VerifyEmail confirms the email address a verification link was sent
+// to. Browsers following the link are redirected to the account login.
+func (h *EmailVerificationHandler) VerifyEmail(c *gin.Context) {
+	browser := strings.Contains(c.GetHeader("Accept"), "text/html")
+
+	token := c.Query("token")
+	if token == "" {
+		apierror.RespondError(c, http.StatusBadRequest, "TOKEN_REQUIRED", "token required", nil)
+		return
+	}
+
+	userID, err := h.verification.Verify(c.Request.Context(), token)
+	if err != nil {
+		status, code, message := http.StatusInternalServerError, apierror.CodeInternal, "failed to verify email"
+		switch {
+		case errors.Is(err, service.ErrVerificationTokenInvalid):
+			status, code, message = http.StatusBadRequest, "INVALID_TOKEN", "invalid verification link"
+		case errors.Is(err, service.ErrVerificationTokenExpired):
+			status, code, message = http.StatusBadRequest, "TOKEN_EXPIRED", "verification link expired"
+		default:
+			log.Error().Err(err).Msg("Failed to verify email")
+		}
+		if browser && status != http.StatusInternalServerError {
+			c.Redirect(http.StatusFound, "/account/login?error=Verification+link+invalid+or+expired")
+			return
+		}
+		apierror.RespondError(c, status, code, message, nil)
+		return
+	}
+
+	if browser {
+		c.Redirect(http.StatusFound, "/account/login?success=Email+confirmed.+Please+wait+for+admin+approval.")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "email verified, awaiting admin approval", "user_id": userID})
+}
+
+// ResendVerification sends a new verification link. The response is the
+// same whether or not the account exists.
+func (h *EmailVerificationHandler) ResendVerification(c *gin.Context) {
+	var req models.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+
+	if err := h.verification.Resend(c.Request.Context(), req.Email); err != nil {
+		log.Error().Err(err).Msg("Failed to resend verification email")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to send verification email", nil)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the account exists and is not yet verified, a new link has been sent"})
+}
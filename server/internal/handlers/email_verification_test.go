@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+type stubVerifier struct {
+	tokens  map[string]error
+	resends []string
+}
+
+func (s *stubVerifier) Verify(_ context.Context, token string) (uuid.UUID, error) {
+	err, ok := s.tokens[token]
+	if !ok {
+		return uuid.Nil, service.ErrVerificationTokenInvalid
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.New(), nil
+}
+
+func (s *stubVerifier) Resend(_ context.Context, email string) error {
+	s.resends = append(s.resends, email)
+	return nil
+}
+
+func callVerifyEmail(h *EmailVerificationHandler, query, accept string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/verify-email?"+query, nil)
+	if accept != "" {
+		c.Request.Header.Set("Accept", accept)
+	}
+	h.VerifyEmail(c)
+	c.Writer.WriteHeaderNow()
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestVerifyEmail(t *testing.T) {
+	h := NewEmailVerificationHandler(&stubVerifier{tokens: map[string]error{
+		"good":    nil,
+		"expired": service.ErrVerificationTokenExpired,
+		"broken":  errors.New("db down"),
+	}})
+
+	for _, tc := range []struct {
+		query  string
+		status int
+		code   interface{}
+	}{
+		{"token=good", http.StatusOK, nil},
+		{"", http.StatusBadRequest, "TOKEN_REQUIRED"},
+		{"token=unknown", http.StatusBadRequest, "INVALID_TOKEN"},
+		{"token=expired", http.StatusBadRequest, "TOKEN_EXPIRED"},
+		{"token=broken", http.StatusInternalServerError, "INTERNAL_ERROR"},
+	} {
+		w, resp := callVerifyEmail(h, tc.query, "application/json")
+		if w.Code != tc.status || resp["code"] != tc.code {
+			t.Errorf("%q: status = %d, code = %v; want %d, %v", tc.query, w.Code, resp["code"], tc.status, tc.code)
+		}
+	}
+}
+
+func TestVerifyEmail_BrowserRedirects(t *testing.T) {
+	h := NewEmailVerificationHandler(&stubVerifier{tokens: map[string]error{"good": nil}})
+	const accept = "text/html,application/xhtml+xml"
+
+	w, _ := callVerifyEmail(h, "token=good", accept)
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "success=") {
+		t.Errorf("valid link: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+	w, _ = callVerifyEmail(h, "token=unknown", accept)
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "error=") {
+		t.Errorf("invalid link: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	verifier := &stubVerifier{}
+	h := NewEmailVerificationHandler(verifier)
+
+	w, _ := callWithIDBody(h.ResendVerification, "", "/", `{"email": "someone@example.com"}`)
+	if w.Code != http.StatusAccepted || len(verifier.resends) != 1 {
+		t.Errorf("status = %d, resends = %v; want 202 and one resend", w.Code, verifier.resends)
+	}
+
+	w, _ = callWithIDBody(h.ResendVerification, "", "/", `{"email": "not-an-email"}`)
+	if w.Code != http.StatusBadRequest || len(verifier.resends) != 1 {
+		t.Errorf("invalid email: status = %d, resends = %v; want 400 and no resend", w.Code, verifier.resends)
+	}
+}
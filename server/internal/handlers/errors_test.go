@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+)
+
+// TestErrorResponsesCarryCode documents that error responses carry a
+// code next to the message, with rejected fields and other context under
+// details
+func TestErrorResponsesCarryCode(t *testing.T) {
+	input.InstallBinding()
+	known := uuid.New()
+	admin := newAdminTestHandler(stubAdminUsers{known: {ID: known, Email: "known@example.com"}})
+	auth := newTempTokenTestHandler()
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		id      string
+		body    string
+		status  int
+		code    string
+		details bool
+	}{
+		{"invalid id", admin.RejectUser, "not-a-uuid", "", http.StatusBadRequest, "INVALID_REQUEST", false},
+		{"unknown user", admin.ApproveUser, uuid.NewString(), "", http.StatusNotFound, "NOT_FOUND", false},
+		{"malformed body", admin.SetUserRole, known.String(), `{"is_admin":`, http.StatusBadRequest, "INVALID_REQUEST", false},
+		{"wrong field type", admin.SetUserRole, known.String(), `{"is_admin":"yes"}`, http.StatusBadRequest, "INVALID_INPUT", true},
+		{"missing field", auth.Login, "", `{"email":"a@example.com"}`, http.StatusBadRequest, "INVALID_INPUT", true},
+		{"needs confirmation", admin.BulkUsers, "", `{"action":"block","user_ids":["` + known.String() + `"]}`, http.StatusBadRequest, "CONFIRMATION_REQUIRED", false},
+		{"no user in context", (&APIKeyHandler{}).List, "", "", http.StatusUnauthorized, "UNAUTHORIZED", false},
+		{"temp token", auth.ExtendTempToken, "", `{"temp_token":"garbage"}`, http.StatusUnauthorized, "TEMP_TOKEN_INVALID", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, resp := callWithIDBody(tt.handler, tt.id, "/", tt.body)
+			if w.Code != tt.status || resp["code"] != tt.code {
+				t.Fatalf("status = %d, body = %s; want %d %s", w.Code, w.Body, tt.status, tt.code)
+			}
+			if msg, _ := resp["error"].(string); msg == "" {
+				t.Errorf("error message missing: %s", w.Body)
+			}
+			if _, ok := resp["details"]; ok != tt.details {
+				t.Errorf("details present = %v, want %v: %s", ok, tt.details, w.Body)
+			}
+			if strings.Contains(w.Body.String(), "Key: ") || strings.Contains(w.Body.String(), "Go value") {
+				t.Errorf("raw binding error leaked: %s", w.Body)
+			}
+		})
+	}
+}
+
+// TestHandlersRespondErrorsViaAPIError checks that no handler writes an
+// error status with a hand-built body, so every error path gets a code
+func TestHandlersRespondErrorsViaAPIError(t *testing.T) {
+	files, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 2 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "JSON" && sel.Sel.Name != "AbortWithStatusJSON") {
+				return true
+			}
+			if errorStatus(call.Args[0]) && !conflictResponse(call.Args[1]) {
+				t.Errorf("%s: error response not written with apierror", fset.Position(call.Pos()))
+			}
+			return true
+		})
+	}
+}
+
+// successStatuses are the statuses handlers answer with c.JSON directly
+var successStatuses = map[string]bool{
+	"StatusOK": true, "StatusCreated": true, "StatusAccepted": true, "StatusNoContent": true,
+}
+
+// errorStatus reports whether expr is an http.Status constant other than
+// a success. Variable statuses are only used for successes and pass.
+func errorStatus(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "http" && !successStatuses[sel.Sel.Name]
+}
+
+// conflictResponse reports whether expr is a models.VaultConflictResponse,
+// which carries its code next to the revisions a client merges against
+func conflictResponse(expr ast.Expr) bool {
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return false
+	}
+	sel, ok := lit.Type.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "VaultConflictResponse"
+}
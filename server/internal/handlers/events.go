@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// Event export sizes
+const (
+	defaultEventExportLimit = 1000
+	maxEventExportLimit     = 10000
+	eventExportPageSize     = 500
+)
+
+// domainEventLister is the subset of DomainEventRepository needed for export
+type domainEventLister interface {
+	List(ctx context.Context, q models.DomainEventQuery) ([]models.DomainEvent, error)
+}
+
+// EventsHandler exports the domain event log to external collectors
+type EventsHandler struct {
+	events domainEventLister
+}
+
+// NewEventsHandler creates a new events handler
+func NewEventsHandler(events domainEventLister) *EventsHandler {
+	return &EventsHandler{events: events}
+}
+
+// Export streams domain events as JSON Lines in sequence order. A
+// collector resumes by passing the seq of the last line it stored as
+// "after".
+func (h *EventsHandler) Export(c *gin.Context) {
+	q, err := parseEventQuery(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, "INVALID_QUERY", err.Error(), nil)
+		return
+	}
+	ctx := c.Request.Context()
+
+	limit := q.Limit
+	q.Limit = min(limit, eventExportPageSize)
+	page, err := h.events.List(ctx, q)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list events", nil)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+
+	for written := 0; ; {
+		for i := range page {
+			if err := enc.Encode(&page[i]); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+
+		written += len(page)
+		if len(page) < q.Limit || written >= limit {
+			return
+		}
+		q.After = page[len(page)-1].Seq
+		q.Limit = min(limit-written, eventExportPageSize)
+		if page, err = h.events.List(ctx, q); err != nil {
+			// The status is already sent; the collector resumes from the
+			// last line it received.
+			log.Error().Err(err).Int64("after", q.After).Msg("Failed to list events during export")
+			return
+		}
+	}
+}
+
+// parseEventQuery reads the export query parameters. All errors it
+// returns are client errors.
+func parseEventQuery(c *gin.Context) (models.DomainEventQuery, error) {
+	q := models.DomainEventQuery{Limit: defaultEventExportLimit}
+
+	if v := c.Query("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return q, errors.New("invalid after cursor")
+		}
+		q.After = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = min(n, maxEventExportLimit)
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if v := c.Query(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return q, errors.New("invalid " + bound.name + " timestamp")
+			}
+			*bound.dst = &t
+		}
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return q, errors.New("from must be before to")
+	}
+	for _, t := range strings.Split(c.Query("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			q.Types = append(q.Types, t)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// memEventLog filters like DomainEventRepository.List
+type memEventLog struct {
+	events []models.DomainEvent
+	calls  int
+}
+
+func (m *memEventLog) List(_ context.Context, q models.DomainEventQuery) ([]models.DomainEvent, error) {
+	m.calls++
+	var out []models.DomainEvent
+	for _, e := range m.events {
+		if e.Seq <= q.After || (q.From != nil && e.OccurredAt.Before(*q.From)) || (q.To != nil && !e.OccurredAt.Before(*q.To)) {
+			continue
+		}
+		if len(q.Types) > 0 && !matchesEventType(q.Types, e.Type) {
+			continue
+		}
+		if len(out) == q.Limit {
+			break
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func matchesEventType(filters []string, eventType string) bool {
+	for _, f := range filters {
+		if prefix, ok := strings.CutSuffix(f, ".*"); ok && strings.HasPrefix(eventType, prefix+".") || f == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func newMemEventLog(n int) *memEventLog {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := &memEventLog{}
+	for i := 1; i <= n; i++ {
+		eventType := "auth.login_failed"
+		if i%2 == 0 {
+			eventType = "audit.user.blocked"
+		}
+		m.events = append(m.events, models.DomainEvent{
+			Seq:        int64(i),
+			Type:       eventType,
+			OccurredAt: start.Add(time.Duration(i) * time.Minute),
+			Data:       json.RawMessage(`{}`),
+		})
+	}
+	return m
+}
+
+// exportEvents calls Export and decodes the JSON Lines response
+func exportEvents(t *testing.T, h *EventsHandler, query string) (*httptest.ResponseRecorder, []models.DomainEvent) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/export?"+query, nil)
+	h.Export(c)
+	c.Writer.WriteHeaderNow()
+
+	if w.Code != http.StatusOK {
+		return w, nil
+	}
+	var events []models.DomainEvent
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var e models.DomainEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q is not an event: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return w, events
+}
+
+func seqs(events []models.DomainEvent) []int64 {
+	out := make([]int64, len(events))
+	for i, e := range events {
+		out[i] = e.Seq
+	}
+	return out
+}
+
+func TestEventExport_ResumesFromCursor(t *testing.T) {
+	log := newMemEventLog(5)
+	h := NewEventsHandler(log)
+
+	w, first := exportEvents(t, h, "limit=3")
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if got := seqs(first); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Fatalf("first batch = %v, want [1 2 3]", got)
+	}
+
+	_, second := exportEvents(t, h, "limit=3&after=3")
+	if got := seqs(second); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Fatalf("resumed batch = %v, want [4 5]", got)
+	}
+
+	// A new event after the collector caught up is the only one returned
+	log.events = append(log.events, models.DomainEvent{Seq: 6, Type: "auth.login_failed", OccurredAt: time.Now()})
+	_, third := exportEvents(t, h, "after=5")
+	if got := seqs(third); len(got) != 1 || got[0] != 6 {
+		t.Fatalf("batch after catching up = %v, want [6]", got)
+	}
+}
+
+func TestEventExport_StreamsAcrossPages(t *testing.T) {
+	log := newMemEventLog(eventExportPageSize*2 + 10)
+	h := NewEventsHandler(log)
+
+	_, events := exportEvents(t, h, "limit=1200")
+	if len(events) != 1010 {
+		t.Fatalf("got %d events, want all 1010", len(events))
+	}
+	for i, e := range events {
+		if e.Seq != int64(i+1) {
+			t.Fatalf("event %d has seq %d; sequence must be gapless and ascending", i, e.Seq)
+		}
+	}
+	if log.calls != 3 {
+		t.Errorf("List called %d times, want 3 pages", log.calls)
+	}
+
+	_, events = exportEvents(t, h, "limit=600")
+	if len(events) != 600 || events[599].Seq != 600 {
+		t.Errorf("limited export returned %d events, want 600 ending at seq 600", len(events))
+	}
+}
+
+func TestEventExport_Filters(t *testing.T) {
+	h := NewEventsHandler(newMemEventLog(10))
+
+	_, events := exportEvents(t, h, "type=audit.*")
+	for _, e := range events {
+		if e.Type != "audit.user.blocked" {
+			t.Fatalf("type filter returned %q", e.Type)
+		}
+	}
+	if len(events) != 5 {
+		t.Errorf("got %d audit events, want 5", len(events))
+	}
+
+	_, events = exportEvents(t, h, "from=2026-01-01T00:03:00Z&to=2026-01-01T00:06:00Z&after=3")
+	if got := seqs(events); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Errorf("time range with cursor = %v, want [4 5]", got)
+	}
+}
+
+func TestEventExport_RejectsInvalidQuery(t *testing.T) {
+	h := NewEventsHandler(newMemEventLog(1))
+	for _, query := range []string{
+		"after=-1",
+		"after=abc",
+		"limit=0",
+		"from=yesterday",
+		"from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z",
+	} {
+		if w, _ := exportEvents(t, h, query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// fuzzEndpoints are the unauthenticated auth endpoints, picked by index
+var fuzzEndpoints = []struct{ method, path string }{
+	{http.MethodPost, "/api/v1/auth/register"},
+	{http.MethodPost, "/api/v1/auth/login"},
+	{http.MethodPost, "/api/v1/auth/login/totp"},
+	{http.MethodPost, "/api/v1/auth/login/totp/extend"},
+	{http.MethodPost, "/api/v1/auth/login/recovery"},
+	{http.MethodPost, "/api/v1/auth/refresh"},
+	{http.MethodPost, "/api/v1/auth/logout"},
+	{http.MethodGet, "/api/v1/auth/verify-email"},
+	{http.MethodPost, "/api/v1/auth/verify-email/resend"},
+}
+
+// leakMarkers appear in Go error strings that must not reach clients
+var leakMarkers = []string{
+	"json:", "Go struct", "strconv", "invalid character", "unexpected EOF",
+	"illegal base64", "uuid", "token is malformed", "runtime error", "Key: '",
+}
+
+// rejectingVerifier knows no verification tokens
+type rejectingVerifier struct{}
+
+func (rejectingVerifier) Verify(context.Context, string) (uuid.UUID, error) {
+	return uuid.Nil, service.ErrVerificationTokenInvalid
+}
+
+func (rejectingVerifier) Resend(context.Context, string) error { return nil }
+
+// newPublicAuthRouter serves the public auth endpoints from in-memory
+// stores. It has no recovery middleware, so a panic fails the test.
+func newPublicAuthRouter(t testing.TB) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	input.InstallBinding()
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true, TOTPEnabled: true}
+	h, m := newGrantTestHandler(t, user)
+	h.config.RefreshTokenDuration = time.Hour
+	h.tokenRefresh = service.NewTokenRefresh(authRefreshTokens{m}, m, authDevices{m}, nil)
+	h.registration = service.NewRegistration(nil, nil, nil, nil, nil, nil, nil, service.RegistrationPolicy{Mode: service.RegistrationClosed})
+	totpHandler := &TOTPHandler{
+		userRepo:     memTOTPUsers{user.ID: user},
+		recoveryRepo: &memRecoveryCodes{},
+		tempTokens:   h.tempTokens,
+		totpGuard:    h.totpGuard,
+		logins:       h,
+		notifier:     &recordingNotifier{},
+		config:       h.config,
+	}
+	verification := NewEmailVerificationHandler(rejectingVerifier{})
+
+	r := gin.New()
+	v1 := r.Group("/api/v1")
+	v1.Use(middleware.RequireJSON())
+	auth := v1.Group("/auth")
+	auth.POST("/register", h.Register)
+	auth.POST("/login", h.Login)
+	auth.POST("/login/totp", h.ValidateTOTP)
+	auth.POST("/login/totp/extend", h.ExtendTempToken)
+	auth.POST("/login/recovery", totpHandler.ValidateRecovery)
+	auth.POST("/refresh", h.Refresh)
+	auth.POST("/logout", h.Logout)
+	auth.GET("/verify-email", verification.VerifyEmail)
+	auth.POST("/verify-email/resend", verification.ResendVerification)
+	return r
+}
+
+// FuzzPublicAuthEndpoints drives the public auth endpoints with arbitrary
+// bodies, headers and query strings. Malformed input must get a JSON 4xx
+// that does not echo Go error strings. The seeds run with go test; fuzz
+// with go test -run='^$' -fuzz=FuzzPublicAuthEndpoints ./internal/handlers
+func FuzzPublicAuthEndpoints(f *testing.F) {
+	seeds := []struct {
+		endpoint    uint8
+		contentType string
+		query       string
+		body        string
+	}{
+		{0, "application/json", "", `{"email":"new@example.com","password":"password123"}`},
+		{0, "application/json", "", `{"email":1}`},
+		{1, "application/json", "", `{"email":"user@example.com","password":"correct horse","device_name":"Laptop","device_type":"linux"}`},
+		{1, "application/json", "", `{"email":"user@example.com","password":"wrong","device_name":"Laptop","device_type":"linux"}`},
+		{1, "application/json", "", `{"email":"user@example.com","password":`},
+		{1, "text/plain", "", `email=user@example.com`},
+		{2, "application/json", "", `{"temp_token":"a.b.c","code":"123456"}`},
+		{3, "application/json", "", `{"temp_token":"eyJhbGciOiJub25lIn0.e30."}`},
+		{4, "application/json", "", `{"temp_token":"x","code":"ABCD-EFGH"}`},
+		{5, "application/json", "", `{"refresh_token":"` + strings.Repeat("A", 64) + `"}`},
+		{5, "application/json", "", `{"refresh_token":null}`},
+		{6, "application/json", "", `[]`},
+		{7, "", "token=abc", ``},
+		{7, "", "token=%zz", ``},
+		{8, "application/json; charset=utf-8", "", `{"email":"\u0000@example.com"}`},
+	}
+	for _, s := range seeds {
+		f.Add(s.endpoint, s.contentType, s.query, "", []byte(s.body))
+	}
+
+	r := newPublicAuthRouter(f)
+	f.Fuzz(func(t *testing.T, endpoint uint8, contentType, query, authorization string, body []byte) {
+		e := fuzzEndpoints[int(endpoint)%len(fuzzEndpoints)]
+		target := e.path
+		if query != "" {
+			target += "?" + url.PathEscape(query)
+		}
+		req := httptest.NewRequest(e.method, target, bytes.NewReader(body))
+		if len(body) == 0 {
+			req.Body, req.ContentLength = http.NoBody, 0
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code >= http.StatusInternalServerError {
+			t.Fatalf("%s %s: status %d %s", e.method, e.path, w.Code, w.Body.String())
+		}
+		if w.Code < http.StatusBadRequest {
+			return
+		}
+		var resp map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: status %d with a non-JSON body %q", e.method, e.path, w.Code, w.Body.String())
+		}
+		if msg, ok := resp["error"].(string); !ok || msg == "" {
+			t.Fatalf("%s %s: status %d without an error message: %s", e.method, e.path, w.Code, w.Body.String())
+		}
+		for _, marker := range leakMarkers {
+			if strings.Contains(w.Body.String(), marker) {
+				t.Fatalf("%s %s: response leaks %q: %s", e.method, e.path, marker, w.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// healthPingTimeout bounds the database ping of the readiness check, so a
+// hanging database fails the check instead of the load balancer's probe
+const healthPingTimeout = 2 * time.Second
+
+// pinger is the subset of pgxpool.Pool needed to check the database
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthHandler reports whether the process is up and whether it can
+// serve requests
+type HealthHandler struct {
+	db      pinger
+	version string
+	commit  string
+}
+
+// NewHealthHandler creates a new health handler. version and commit
+// identify the build and may be empty.
+func NewHealthHandler(db pinger, version, commit string) *HealthHandler {
+	return &HealthHandler{db: db, version: version, commit: commit}
+}
+
+// Live reports that the process is up. It never checks dependencies, so
+// a database outage does not get the instance restarted.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, models.HealthResponse{Status: models.HealthOK, Version: h.version, Commit: h.commit})
+}
+
+// Ready pings the database and reports each dependency with its latency.
+// It returns 503 if one is unavailable, so traffic is routed elsewhere.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	resp := models.HealthResponse{
+		Status:  models.HealthOK,
+		Version: h.version,
+		Commit:  h.commit,
+		Checks:  map[string]models.DependencyHealth{"database": h.checkDatabase(c.Request.Context())},
+	}
+	status := http.StatusOK
+	for _, check := range resp.Checks {
+		if check.Status != models.HealthOK {
+			resp.Status = models.HealthUnavailable
+			status = http.StatusServiceUnavailable
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, resp)
+}
+
+// checkDatabase pings the pool. The error is logged, not returned, since
+// the endpoint is public.
+func (h *HealthHandler) checkDatabase(ctx context.Context) models.DependencyHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.db.Ping(ctx)
+	check := models.DependencyHealth{
+		Status:    models.HealthOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("Readiness check: database unreachable")
+		check.Status = models.HealthUnavailable
+		check.Error = "unreachable"
+	}
+	return check
+}
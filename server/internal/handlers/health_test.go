@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+type okPinger struct{}
+
+func (okPinger) Ping(context.Context) error { return nil }
+
+func serveHealth(h *HealthHandler, ctx context.Context, target string) (*httptest.ResponseRecorder, models.HealthResponse) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health", h.Live)
+	r.GET("/health/live", h.Live)
+	r.GET("/health/ready", h.Ready)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", target, nil).WithContext(ctx))
+	var resp models.HealthResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestHealth_Ready(t *testing.T) {
+	h := NewHealthHandler(okPinger{}, "1.2.3", "abc123")
+
+	w, resp := serveHealth(h, context.Background(), "/health/ready")
+	if w.Code != http.StatusOK || resp.Status != models.HealthOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if db := resp.Checks["database"]; db.Status != models.HealthOK || db.Error != "" {
+		t.Errorf("database check = %+v", db)
+	}
+	if resp.Version != "1.2.3" || resp.Commit != "abc123" {
+		t.Errorf("build = %q %q", resp.Version, resp.Commit)
+	}
+}
+
+func TestHealth_DeadDatabase(t *testing.T) {
+	// The pool connects lazily; with the request cancelled the ping fails
+	// without dialing, like a database that does not answer
+	pool, err := pgxpool.New(context.Background(), "postgres://health@127.0.0.1:1/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	h := NewHealthHandler(pool, "", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w, resp := serveHealth(h, ctx, "/health/ready")
+	if w.Code != http.StatusServiceUnavailable || resp.Status != models.HealthUnavailable {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if db := resp.Checks["database"]; db.Status != models.HealthUnavailable || db.Error == "" {
+		t.Errorf("database check = %+v", db)
+	}
+
+	// Liveness does not depend on the database
+	for _, target := range []string{"/health", "/health/live"} {
+		if w, resp := serveHealth(h, ctx, target); w.Code != http.StatusOK || resp.Status != models.HealthOK || resp.Checks != nil {
+			t.Errorf("%s: status = %d, body = %s", target, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestBindingRejectsInvalidInput(t *testing.T) {
+	input.InstallBinding()
+
+	var bound models.RegisterDeviceRequest
+	handler := func(c *gin.Context) {
+		var req models.RegisterDeviceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierror.RespondBindError(c, err)
+			return
+		}
+		bound = req
+		c.JSON(http.StatusOK, gin.H{})
+	}
+
+	// JSON turns invalid UTF-8 into U+FFFD, which text fields reject
+	long := strings.Repeat("x", input.DefaultLimits()[input.KindDeviceType]+1)
+	w, resp := callWithIDBody(handler, "", "/", "{\"device_name\": \"bad\xffname\", \"device_type\": \""+long+"\"}")
+	if w.Code != http.StatusBadRequest || resp["code"] != "INVALID_INPUT" {
+		t.Fatalf("status = %d, body = %v; want 400 INVALID_INPUT", w.Code, resp)
+	}
+	fields, _ := resp["details"].([]interface{})
+	if len(fields) != 2 {
+		t.Fatalf("fields = %v, want device_name and device_type", resp["details"])
+	}
+	first := fields[0].(map[string]interface{})
+	if first["field"] != "device_name" || first["code"] != input.CodeInvalidUTF8 {
+		t.Errorf("first field error = %v", first)
+	}
+
+	// Emoji are fine and control characters are stripped before `required`
+	w, _ = callWithIDBody(handler, "", "/", `{"device_name": "Pixel 🚀\u0000", "device_type": "android"}`)
+	if w.Code != http.StatusOK || bound.DeviceName != "Pixel 🚀" {
+		t.Errorf("status = %d, device name = %q", w.Code, bound.DeviceName)
+	}
+	w, resp = callWithIDBody(handler, "", "/", `{"device_name": "\u0007", "device_type": "android"}`)
+	if w.Code != http.StatusBadRequest || resp["code"] != "INVALID_INPUT" {
+		t.Fatalf("control-only name: status = %d, body = %v", w.Code, resp)
+	}
+	fields, _ = resp["details"].([]interface{})
+	if len(fields) != 1 {
+		t.Fatalf("control-only name: fields = %v", resp["details"])
+	}
+	first = fields[0].(map[string]interface{})
+	if first["field"] != "device_name" || first["code"] != input.CodeRequired {
+		t.Errorf("control-only name: field error = %v", first)
+	}
+
+	// Wrong JSON types name the field, not the Go type
+	w, resp = callWithIDBody(handler, "", "/", `{"device_name": 7, "device_type": "android"}`)
+	fields, _ = resp["details"].([]interface{})
+	if w.Code != http.StatusBadRequest || resp["code"] != "INVALID_INPUT" || len(fields) != 1 {
+		t.Fatalf("wrong type: status = %d, body = %v", w.Code, resp)
+	}
+	if first = fields[0].(map[string]interface{}); first["field"] != "device_name" || strings.Contains(w.Body.String(), "string") {
+		t.Errorf("wrong type: field error = %v", first)
+	}
+
+	w, resp = callWithIDBody(handler, "", "/", `{"device_name":`)
+	if w.Code != http.StatusBadRequest || resp["code"] != "INVALID_REQUEST" || resp["details"] != nil {
+		t.Errorf("malformed body: status = %d, body = %v", w.Code, resp)
+	}
+}
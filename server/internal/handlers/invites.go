@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+type inviteIssuer interface {
+	Create(ctx context.Context, actorID uuid.UUID, note string) (string, *models.Invite, error)
+	List(ctx context.Context) ([]models.Invite, error)
+}
+
+// InviteHandler lets admins create and list registration invites
+type InviteHandler struct {
+	invites inviteIssuer
+}
+
+// NewInviteHandler creates a new invite handler
+func NewInviteHandler(invites inviteIssuer) *InviteHandler {
+	return &InviteHandler{invites: invites}
+}
+
+// Create generates a single-use invite code. The code is only returned here.
+func (h *InviteHandler) Create(c *gin.Context) {
+	var req models.CreateInviteRequest
+	if !bindOptionalJSON(c, &req) {
+		return
+	}
+	actorID, _ := middleware.GetUserID(c)
+
+	code, invite, err := h.invites.Create(c.Request.Context(), actorID, req.Note)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to create invite", nil)
+		return
+	}
+	c.JSON(http.StatusCreated, models.CreateInviteResponse{Code: code, Invite: invite})
+}
+
+// List returns the most recent invites without their codes
+func (h *InviteHandler) List(c *gin.Context) {
+	invites, err := h.invites.List(c.Request.Context())
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list invites", nil)
+		return
+	}
+	if invites == nil {
+		invites = []models.Invite{}
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// JWKSHandler publishes the public key of access tokens
+type JWKSHandler struct {
+	set models.JWKSet
+}
+
+// NewJWKSHandler creates a new JWKS handler. Without keys the set is
+// empty, since HMAC-signed tokens cannot be validated by third parties.
+func NewJWKSHandler(keys *middleware.SigningKeys) *JWKSHandler {
+	set := models.JWKSet{Keys: []models.JWK{}}
+	if keys != nil {
+		set.Keys = append(set.Keys, keys.JWK())
+	}
+	return &JWKSHandler{set: set}
+}
+
+// Get returns the JSON Web Key Set
+func (h *JWKSHandler) Get(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, h.set)
+}
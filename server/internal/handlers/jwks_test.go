@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJWKS_WithoutKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/.well-known/jwks.json", NewJWKSHandler(nil).Get)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"keys":[]}` {
+		t.Errorf("GET jwks = %d %s, want empty key set", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// devicePruner is the subset of service.DeviceCleanup needed by the
+// maintenance handler
+type devicePruner interface {
+	Prune(ctx context.Context) (int, error)
+}
+
+// MaintenanceHandler lets admins trigger maintenance jobs right away
+type MaintenanceHandler struct {
+	devices devicePruner
+}
+
+// NewMaintenanceHandler creates a new maintenance handler
+func NewMaintenanceHandler(devices *service.DeviceCleanup) *MaintenanceHandler {
+	return &MaintenanceHandler{devices: devices}
+}
+
+// PruneDevices removes inactive devices like the background job does
+func (h *MaintenanceHandler) PruneDevices(c *gin.Context) {
+	n, err := h.devices.Prune(c.Request.Context())
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrDeviceCleanupDisabled):
+		apierror.RespondError(c, http.StatusConflict, "DEVICE_CLEANUP_DISABLED", "device cleanup is disabled, set DEVICE_INACTIVE_AFTER", nil)
+		return
+	default:
+		log.Error().Err(err).Int("pruned", n).Msg("Failed to prune inactive devices")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to prune devices", gin.H{"pruned": n})
+		return
+	}
+
+	log.Info().Int("pruned", n).Msg("Pruned inactive devices on admin request")
+	c.JSON(http.StatusOK, gin.H{"pruned": n})
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+type fixedPruner struct {
+	n   int
+	err error
+}
+
+func (p fixedPruner) Prune(context.Context) (int, error) { return p.n, p.err }
+
+func TestPruneDevices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		pruner fixedPruner
+		want   int
+	}{
+		{fixedPruner{n: 3}, http.StatusOK},
+		{fixedPruner{err: service.ErrDeviceCleanupDisabled}, http.StatusConflict},
+	} {
+		h := &MaintenanceHandler{devices: tc.pruner}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/api/v1/admin/maintenance/prune-devices", nil)
+		h.PruneDevices(c)
+		if w.Code != tc.want {
+			t.Errorf("%+v: status = %d, want %d", tc.pruner, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+// outboxQueue is the subset of notify.Outbox needed by the outbox handler
+type outboxQueue interface {
+	List() []notify.Entry
+	Retry(ctx context.Context, id uuid.UUID) (*notify.Entry, error)
+	Drop(id uuid.UUID) error
+}
+
+// OutboxHandler lets admins inspect, retry and drop undelivered
+// notifications
+type OutboxHandler struct {
+	outbox outboxQueue
+}
+
+// NewOutboxHandler creates a new outbox handler
+func NewOutboxHandler(outbox *notify.Outbox) *OutboxHandler {
+	return &OutboxHandler{outbox: outbox}
+}
+
+// List returns the pending and failed deliveries, oldest first
+func (h *OutboxHandler) List(c *gin.Context) {
+	entries := h.outbox.List()
+	resp := models.OutboxListResponse{
+		Total:   len(entries),
+		Entries: make([]models.OutboxEntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, outboxEntryResponse(e))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Retry attempts a delivery right away
+func (h *OutboxHandler) Retry(c *gin.Context) {
+	id, ok := parseOutboxID(c)
+	if !ok {
+		return
+	}
+
+	entry, err := h.outbox.Retry(c.Request.Context(), id)
+	if err != nil {
+		writeOutboxError(c, err)
+		return
+	}
+
+	resp := models.OutboxRetryResponse{Delivered: entry == nil}
+	if entry != nil {
+		e := outboxEntryResponse(*entry)
+		resp.Entry = &e
+	}
+	log.Info().Str("outbox_id", id.String()).Bool("delivered", resp.Delivered).Msg("Notification retried by admin")
+	c.JSON(http.StatusOK, resp)
+}
+
+// Drop removes a delivery that keeps failing
+func (h *OutboxHandler) Drop(c *gin.Context) {
+	id, ok := parseOutboxID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.outbox.Drop(id); err != nil {
+		writeOutboxError(c, err)
+		return
+	}
+
+	log.Info().Str("outbox_id", id.String()).Msg("Notification dropped by admin")
+	c.JSON(http.StatusOK, gin.H{"message": "notification dropped"})
+}
+
+// parseOutboxID reads the entry ID from the path. On failure it writes the
+// error response and returns false.
+func parseOutboxID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid outbox entry ID", nil)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeOutboxError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, notify.ErrEntryNotFound):
+		apierror.RespondError(c, http.StatusNotFound, "OUTBOX_ENTRY_NOT_FOUND", "outbox entry not found", nil)
+	case errors.Is(err, notify.ErrEntryBusy):
+		apierror.RespondError(c, http.StatusConflict, "OUTBOX_ENTRY_BUSY", "notification is being delivered", nil)
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "internal server error", nil)
+	}
+}
+
+func outboxEntryResponse(e notify.Entry) models.OutboxEntry {
+	entry := models.OutboxEntry{
+		ID:        e.ID,
+		Kind:      e.Notification.Kind,
+		UserID:    e.Notification.UserID,
+		Target:    e.Target(),
+		Subject:   e.Notification.Subject,
+		Status:    e.Status,
+		Attempts:  e.Attempts,
+		LastError: e.LastError,
+		CreatedAt: e.CreatedAt,
+	}
+	if !e.NextAttemptAt.IsZero() {
+		next := e.NextAttemptAt
+		entry.NextAttemptAt = &next
+	}
+	return entry
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+// flakyTarget fails deliveries until it is fixed
+type flakyTarget struct {
+	mu        sync.Mutex
+	broken    bool
+	delivered []notify.Notification
+}
+
+func (f *flakyTarget) Notify(_ context.Context, n notify.Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.broken {
+		return errors.New("smtp: connection refused")
+	}
+	f.delivered = append(f.delivered, n)
+	return nil
+}
+
+func TestOutboxHandler_RetryAfterFix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	target := &flakyTarget{broken: true}
+	outbox := notify.NewOutbox(target, 10)
+	h := NewOutboxHandler(outbox)
+
+	r := gin.New()
+	r.GET("/outbox", h.List)
+	r.POST("/outbox/:id/retry", h.Retry)
+	r.DELETE("/outbox/:id", h.Drop)
+	call := func(method, path string, out any) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if out != nil {
+			if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return w.Code
+	}
+
+	ctx := context.Background()
+	n := notify.Notification{Kind: notify.KindNewDeviceLogin, UserID: uuid.New(), Email: "user@example.com", Subject: "New login"}
+	if err := outbox.Notify(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Notify(ctx, notify.Notification{Kind: notify.KindNewDeviceLogin, UserID: uuid.New()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var list models.OutboxListResponse
+	call(http.MethodGet, "/outbox", &list)
+	if list.Total != 2 {
+		t.Fatalf("total = %d, want 2", list.Total)
+	}
+	id := list.Entries[0].ID
+	poison := list.Entries[1].ID
+
+	// The target is down
+	var retry models.OutboxRetryResponse
+	if code := call(http.MethodPost, "/outbox/"+id.String()+"/retry", &retry); code != http.StatusOK {
+		t.Fatalf("retry: status %d", code)
+	}
+	if retry.Delivered || retry.Entry == nil {
+		t.Fatalf("retry against a broken target = %+v", retry)
+	}
+
+	call(http.MethodGet, "/outbox", &list)
+	entry := list.Entries[0]
+	if entry.Target != "user@example.com" || entry.Attempts != 1 || entry.LastError == "" || entry.NextAttemptAt == nil {
+		t.Errorf("failed entry = %+v", entry)
+	}
+	if entry.Status != notify.OutboxPending {
+		t.Errorf("status = %q, want pending", entry.Status)
+	}
+
+	// Fixed, the forced retry delivers exactly once
+	target.mu.Lock()
+	target.broken = false
+	target.mu.Unlock()
+	retry = models.OutboxRetryResponse{}
+	call(http.MethodPost, "/outbox/"+id.String()+"/retry", &retry)
+	if !retry.Delivered {
+		t.Fatalf("retry after fix = %+v", retry)
+	}
+	if code := call(http.MethodPost, "/outbox/"+id.String()+"/retry", nil); code != http.StatusNotFound {
+		t.Errorf("second retry: status %d, want 404", code)
+	}
+	if len(target.delivered) != 1 || target.delivered[0].Subject != "New login" {
+		t.Errorf("delivered %+v, want the notification once", target.delivered)
+	}
+
+	// The other one is dropped without delivery
+	if code := call(http.MethodDelete, "/outbox/"+poison.String(), nil); code != http.StatusOK {
+		t.Fatalf("drop: status %d", code)
+	}
+	call(http.MethodGet, "/outbox", &list)
+	if list.Total != 0 || len(list.Entries) != 0 {
+		t.Errorf("outbox after drop = %+v", list)
+	}
+	if len(target.delivered) != 1 {
+		t.Errorf("dropped notification was delivered")
+	}
+	if code := call(http.MethodDelete, "/outbox/not-a-uuid", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid ID: status %d, want 400", code)
+	}
+}
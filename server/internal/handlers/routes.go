@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// RoutesHandler exposes the effective API route table
+type RoutesHandler struct {
+	engine *gin.Engine
+	table  *middleware.RouteTable
+	public bool
+}
+
+// NewRoutesHandler creates a new routes handler
+func NewRoutesHandler(engine *gin.Engine, table *middleware.RouteTable, public bool) *RoutesHandler {
+	return &RoutesHandler{
+		engine: engine,
+		table:  table,
+		public: public,
+	}
+}
+
+// Register mounts GET /routes on the public group, or behind
+// AdminMiddleware on the protected group unless listing is public
+func (h *RoutesHandler) Register(public, protected gin.IRoutes) {
+	if h.public {
+		public.GET("/routes", h.List)
+		return
+	}
+	protected.GET("/routes", middleware.AdminMiddleware(), h.List)
+}
+
+// List returns every annotated route with its auth requirement and scope
+func (h *RoutesHandler) List(c *gin.Context) {
+	routes := make([]models.RouteInfo, 0)
+	for _, r := range h.engine.Routes() {
+		rule, ok := h.table.Lookup(r.Method, r.Path)
+		if !ok {
+			continue
+		}
+		routes = append(routes, models.RouteInfo{
+			Method: r.Method,
+			Path:   r.Path,
+			Auth:   rule.Auth,
+			Scope:  rule.Scope,
+		})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	c.JSON(http.StatusOK, models.RouteListResponse{Routes: routes})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+const routesTestSecret = "routes-test-secret"
+
+func noop(c *gin.Context) { c.Status(http.StatusOK) }
+
+// newRoutesTestEngine mirrors a subset of the real route tree
+func newRoutesTestEngine(public bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health", noop)
+	r.GET("/admin/login", noop) // web UI, not annotated
+
+	v1 := r.Group("/api/v1")
+	v1.POST("/auth/login", noop)
+	v1.GET("/capabilities", noop)
+
+	protected := v1.Group("")
+	protected.Use(middleware.JWTMiddleware(routesTestSecret))
+	protected.POST("/auth/logout-all", noop)
+	protected.DELETE("/auth/sessions/:id", noop)
+	protected.GET("/vault/pull", noop)
+	protected.POST("/vault/push", noop)
+	protected.DELETE("/vault", noop)
+	protected.PUT("/devices/:id", noop)
+	protected.POST("/admin/users/:id/approve", noop)
+
+	h := NewRoutesHandler(r, middleware.APIRouteTable(public), public)
+	h.Register(v1, protected)
+	return r
+}
+
+func getRoutes(t *testing.T, r *gin.Engine, token string) (*httptest.ResponseRecorder, map[string]models.RouteInfo) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/routes", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return w, nil
+	}
+
+	var resp models.RouteListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	byKey := make(map[string]models.RouteInfo)
+	for _, ri := range resp.Routes {
+		byKey[ri.Method+" "+ri.Path] = ri
+	}
+	return w, byKey
+}
+
+func TestRoutesList_KnownRoutes(t *testing.T) {
+	r := newRoutesTestEngine(true)
+	_, routes := getRoutes(t, r, "")
+	if routes == nil {
+		t.Fatal("expected route listing")
+	}
+
+	tests := []struct {
+		key   string
+		auth  string
+		scope string
+	}{
+		{"GET /health", middleware.AuthPublic, ""},
+		{"POST /api/v1/auth/login", middleware.AuthPublic, ""},
+		{"GET /api/v1/capabilities", middleware.AuthPublic, ""},
+		{"POST /api/v1/auth/logout-all", middleware.AuthUser, middleware.ScopeAccount},
+		{"DELETE /api/v1/auth/sessions/:id", middleware.AuthUser, middleware.ScopeAccount},
+		{"GET /api/v1/vault/pull", middleware.AuthUser, middleware.ScopeVaultRead},
+		{"POST /api/v1/vault/push", middleware.AuthUser, middleware.ScopeVaultWrite},
+		{"DELETE /api/v1/vault", middleware.AuthUser, middleware.ScopeVaultWrite},
+		{"PUT /api/v1/devices/:id", middleware.AuthUser, middleware.ScopeDevices},
+		{"POST /api/v1/admin/users/:id/approve", middleware.AuthAdmin, middleware.ScopeAdmin},
+		{"GET /api/v1/routes", middleware.AuthPublic, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, ok := routes[tt.key]
+			if !ok {
+				t.Fatalf("route %q missing from listing", tt.key)
+			}
+			if got.Auth != tt.auth {
+				t.Errorf("auth = %q, want %q", got.Auth, tt.auth)
+			}
+			if got.Scope != tt.scope {
+				t.Errorf("scope = %q, want %q", got.Scope, tt.scope)
+			}
+		})
+	}
+
+	if _, ok := routes["GET /admin/login"]; ok {
+		t.Error("web UI route should not be listed")
+	}
+}
+
+func TestRoutesList_AdminOnlyByDefault(t *testing.T) {
+	r := newRoutesTestEngine(false)
+
+	w, _ := getRoutes(t, r, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	userToken, _ := middleware.GenerateToken(uuid.New(), "u@test.com", uuid.New(), false, routesTestSecret, time.Hour)
+	w, _ = getRoutes(t, r, userToken)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("non-admin status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	adminToken, _ := middleware.GenerateToken(uuid.New(), "a@test.com", uuid.New(), true, routesTestSecret, time.Hour)
+	w, routes := getRoutes(t, r, adminToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := routes["GET /api/v1/routes"]; got.Auth != middleware.AuthAdmin {
+		t.Errorf("routes endpoint auth = %q, want %q", got.Auth, middleware.AuthAdmin)
+	}
+}
+
+func TestRoutesList_PublicWhenConfigured(t *testing.T) {
+	r := newRoutesTestEngine(true)
+
+	w, _ := getRoutes(t, r, "")
+	if w.Code != http.StatusOK {
+		t.Errorf("anonymous status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
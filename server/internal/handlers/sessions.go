@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// sessionStore is the subset of RefreshTokenRepository needed for sessions
+type sessionStore interface {
+	GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	RevokeByID(ctx context.Context, userID, sessionID uuid.UUID) error
+}
+
+// SessionHandler lists and revokes the refresh token sessions of a user
+type SessionHandler struct {
+	sessions sessionStore
+}
+
+// NewSessionHandler creates a new session handler
+func NewSessionHandler(sessions sessionStore) *SessionHandler {
+	return &SessionHandler{sessions: sessions}
+}
+
+// List lists the active sessions of the current user. Sessions of the
+// caller's device are marked as current.
+func (h *SessionHandler) List(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+
+	sessions, err := h.sessions.GetActiveByUserID(c.Request.Context(), userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list sessions")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list sessions", nil)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = deviceID != uuid.Nil && sessions[i].DeviceID == deviceID
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// Revoke ends one session of the current user. The device stays
+// registered and can log in again.
+func (h *SessionHandler) Revoke(c *gin.Context) {
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid session ID", nil)
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	err = h.sessions.RevokeByID(c.Request.Context(), userID, sessionID)
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		apierror.RespondError(c, http.StatusNotFound, "SESSION_NOT_FOUND", "session not found", nil)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke session")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to revoke session", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
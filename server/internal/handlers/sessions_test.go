@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memSessions keeps the active sessions per user like RefreshTokenRepository
+type memSessions map[uuid.UUID][]models.Session
+
+func (m memSessions) GetActiveByUserID(_ context.Context, userID uuid.UUID) ([]models.Session, error) {
+	return append([]models.Session(nil), m[userID]...), nil
+}
+
+func (m memSessions) RevokeByID(_ context.Context, userID, sessionID uuid.UUID) error {
+	for i, s := range m[userID] {
+		if s.ID == sessionID {
+			m[userID] = append(m[userID][:i], m[userID][i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrRefreshTokenNotFound
+}
+
+func callSessions(handler gin.HandlerFunc, userID, deviceID uuid.UUID, id string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/v1/auth/sessions", nil)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	c.Set("user_id", userID)
+	c.Set("device_id", deviceID)
+	handler(c)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestSessions_ListMarksCurrentDevice(t *testing.T) {
+	userID, phone, laptop := uuid.New(), uuid.New(), uuid.New()
+	now := time.Now()
+	store := memSessions{userID: {
+		{ID: uuid.New(), DeviceID: phone, DeviceName: "Phone", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LastUsedAt: now},
+		{ID: uuid.New(), DeviceID: laptop, DeviceName: "Laptop", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LastUsedAt: now},
+	}}
+	h := NewSessionHandler(store)
+
+	w, resp := callSessions(h.List, userID, laptop, "")
+	sessions, _ := resp["sessions"].([]interface{})
+	if w.Code != http.StatusOK || len(sessions) != 2 {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+	for _, s := range sessions {
+		s := s.(map[string]interface{})
+		if want := s["device_name"] == "Laptop"; s["current"] != want {
+			t.Errorf("%v: current = %v, want %v", s["device_name"], s["current"], want)
+		}
+		if s["last_used_at"] == nil || s["expires_at"] == nil {
+			t.Errorf("session lacks timestamps: %v", s)
+		}
+	}
+
+	// API keys carry no device, so no session is current
+	_, resp = callSessions(h.List, userID, uuid.Nil, "")
+	for _, s := range resp["sessions"].([]interface{}) {
+		if s.(map[string]interface{})["current"] != false {
+			t.Errorf("session marked current without a device: %v", s)
+		}
+	}
+
+	// No sessions is an empty list, not null
+	_, resp = callSessions(h.List, uuid.New(), laptop, "")
+	if sessions, ok := resp["sessions"].([]interface{}); !ok || len(sessions) != 0 {
+		t.Errorf("sessions = %v, want []", resp["sessions"])
+	}
+}
+
+func TestSessions_Revoke(t *testing.T) {
+	userID, other := uuid.New(), uuid.New()
+	sessionID := uuid.New()
+	store := memSessions{userID: {{ID: sessionID, DeviceID: uuid.New()}}}
+	h := NewSessionHandler(store)
+
+	if w, _ := callSessions(h.Revoke, userID, uuid.Nil, "not-a-uuid"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID: status = %d, want 400", w.Code)
+	}
+
+	// Another user's session is not found
+	w, resp := callSessions(h.Revoke, other, uuid.Nil, sessionID.String())
+	if w.Code != http.StatusNotFound || resp["code"] != "SESSION_NOT_FOUND" {
+		t.Errorf("foreign session: status = %d, body = %v", w.Code, resp)
+	}
+
+	if w, _ := callSessions(h.Revoke, userID, uuid.Nil, sessionID.String()); w.Code != http.StatusOK {
+		t.Fatalf("revoke: status = %d", w.Code)
+	}
+	if len(store[userID]) != 0 {
+		t.Error("session still active")
+	}
+	if w, _ := callSessions(h.Revoke, userID, uuid.Nil, sessionID.String()); w.Code != http.StatusNotFound {
+		t.Errorf("second revoke: status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// SettingsBlobHandler handles the client settings blob endpoints
+type SettingsBlobHandler struct {
+	settingsSync *service.ClientSettingsSync
+}
+
+// NewSettingsBlobHandler creates a new client settings blob handler
+func NewSettingsBlobHandler(settingsSync *service.ClientSettingsSync) *SettingsBlobHandler {
+	return &SettingsBlobHandler{settingsSync: settingsSync}
+}
+
+// Get downloads the encrypted client settings blob
+func (h *SettingsBlobHandler) Get(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	settings, err := h.settingsSync.Get(c.Request.Context(), userID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get settings", nil)
+		return
+	}
+	if settings == nil {
+		apierror.RespondError(c, http.StatusNotFound, "NO_SETTINGS", "no settings found", nil)
+		return
+	}
+
+	var updatedByDevice string
+	if settings.UpdatedByDevice != nil {
+		updatedByDevice = settings.UpdatedByDevice.String()
+	}
+
+	c.JSON(http.StatusOK, models.SettingsBlobResponse{
+		SettingsBlob:    base64.StdEncoding.EncodeToString(settings.SettingsBlob),
+		Revision:        settings.Revision,
+		UpdatedAt:       settings.UpdatedAt.Unix(),
+		UpdatedByDevice: updatedByDevice,
+	})
+}
+
+// Put uploads the encrypted client settings blob with the same revision
+// check as a vault push
+func (h *SettingsBlobHandler) Put(c *gin.Context) {
+	var req models.SettingsBlobPutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	deviceID, _ := middleware.GetDeviceID(c)
+
+	verdict, settings, err := h.settingsSync.Put(c.Request.Context(), userID, deviceID, &req)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to store settings", nil)
+		return
+	}
+
+	switch verdict.Code {
+	case "":
+	case service.PushCodeConflict:
+		c.JSON(http.StatusConflict, models.VaultConflictResponse{
+			Error:          verdict.Error,
+			Code:           verdict.Code,
+			LocalRevision:  verdict.LocalRevision,
+			ServerRevision: verdict.ServerRevision,
+			ServerDeviceID: verdict.ServerDeviceID,
+			ServerUpdated:  verdict.ServerUpdated,
+		})
+		return
+	case service.PushCodeTooLarge:
+		apierror.RespondError(c, http.StatusRequestEntityTooLarge, verdict.Code, verdict.Error, nil)
+		return
+	default:
+		apierror.RespondError(c, http.StatusBadRequest, verdict.Code, verdict.Error, nil)
+		return
+	}
+
+	status := "updated"
+	if verdict.WouldCreate {
+		status = "created"
+	}
+	c.JSON(http.StatusOK, models.VaultPushResponse{
+		Status:    status,
+		Revision:  settings.Revision,
+		Timestamp: settings.UpdatedAt.Unix(),
+	})
+}
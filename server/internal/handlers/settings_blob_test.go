@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// memSettingsBlobs stores one client settings blob per user
+type memSettingsBlobs map[uuid.UUID]*models.ClientSettings
+
+func (m memSettingsBlobs) GetByUserID(_ context.Context, userID uuid.UUID) (*models.ClientSettings, error) {
+	if s, ok := m[userID]; ok {
+		return s, nil
+	}
+	return nil, repository.ErrClientSettingsNotFound
+}
+
+func (m memSettingsBlobs) Create(_ context.Context, userID uuid.UUID, blob []byte, deviceID *uuid.UUID) (*models.ClientSettings, error) {
+	m[userID] = &models.ClientSettings{UserID: userID, SettingsBlob: blob, Revision: 1, UpdatedByDevice: deviceID, UpdatedAt: time.Now()}
+	return m[userID], nil
+}
+
+func (m memSettingsBlobs) UpdateWithRevisionCheck(_ context.Context, userID uuid.UUID, blob []byte, expected int, deviceID *uuid.UUID) (*models.ClientSettings, error) {
+	s := m[userID]
+	if s.Revision != expected {
+		return nil, repository.ErrClientSettingsConflict
+	}
+	s.SettingsBlob, s.Revision, s.UpdatedByDevice = blob, s.Revision+1, deviceID
+	return s, nil
+}
+
+func callSettingsBlob(handler gin.HandlerFunc, userID uuid.UUID, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("PUT", "/api/v1/settings-blob", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", userID)
+	handler(c)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestSettingsBlob_PutAndGet(t *testing.T) {
+	userID := uuid.New()
+	h := NewSettingsBlobHandler(service.NewClientSettingsSync(memSettingsBlobs{}))
+	blob := base64.StdEncoding.EncodeToString([]byte("theme=dark"))
+
+	w, resp := callSettingsBlob(h.Get, userID, "")
+	if w.Code != http.StatusNotFound || resp["code"] != "NO_SETTINGS" {
+		t.Fatalf("empty get: status = %d, body = %v", w.Code, resp)
+	}
+
+	w, resp = callSettingsBlob(h.Put, userID, `{"settings_blob": "`+blob+`", "revision": 0}`)
+	if w.Code != http.StatusOK || resp["status"] != "created" || resp["revision"] != float64(1) {
+		t.Fatalf("first put: status = %d, body = %v", w.Code, resp)
+	}
+
+	w, resp = callSettingsBlob(h.Put, userID, `{"settings_blob": "`+blob+`", "revision": 0}`)
+	if w.Code != http.StatusConflict || resp["code"] != service.PushCodeConflict || resp["server_revision"] != float64(1) {
+		t.Errorf("stale put: status = %d, body = %v", w.Code, resp)
+	}
+
+	w, resp = callSettingsBlob(h.Get, userID, "")
+	if w.Code != http.StatusOK || resp["settings_blob"] != blob || resp["revision"] != float64(1) {
+		t.Errorf("get: status = %d, body = %v", w.Code, resp)
+	}
+}
+
+func TestSettingsBlob_PutTooLarge(t *testing.T) {
+	h := NewSettingsBlobHandler(service.NewClientSettingsSync(memSettingsBlobs{}))
+	blob := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("x"), service.MaxClientSettingsBytes+1))
+
+	w, resp := callSettingsBlob(h.Put, uuid.New(), `{"settings_blob": "`+blob+`"}`)
+	if w.Code != http.StatusRequestEntityTooLarge || resp["code"] != service.PushCodeTooLarge {
+		t.Errorf("status = %d, body = %v; want 413 TOO_LARGE", w.Code, resp)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
+)
+
+// streamRetryAfter is the Retry-After hint when a stream limit is hit
+const streamRetryAfter = 30
+
+// StreamsHandler lets admins inspect and terminate streaming connections
+type StreamsHandler struct {
+	hub *stream.Hub
+}
+
+// NewStreamsHandler creates a new streams handler
+func NewStreamsHandler(hub *stream.Hub) *StreamsHandler {
+	return &StreamsHandler{hub: hub}
+}
+
+// List returns open streaming connections grouped by user
+func (h *StreamsHandler) List(c *gin.Context) {
+	conns := h.hub.List()
+	perUser, global := h.hub.Limits()
+
+	resp := models.StreamListResponse{
+		Total:      len(conns),
+		MaxPerUser: perUser,
+		MaxGlobal:  global,
+		Users:      []models.UserStreams{},
+	}
+	index := make(map[uuid.UUID]int)
+	for _, conn := range conns {
+		i, ok := index[conn.UserID]
+		if !ok {
+			i = len(resp.Users)
+			index[conn.UserID] = i
+			resp.Users = append(resp.Users, models.UserStreams{UserID: conn.UserID})
+		}
+		resp.Users[i].Streams = append(resp.Users[i].Streams, models.StreamInfo{
+			ID:       conn.ID,
+			DeviceID: conn.DeviceID,
+			OpenedAt: conn.OpenedAt,
+		})
+		resp.Users[i].Count++
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// Terminate closes a single streaming connection
+func (h *StreamsHandler) Terminate(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid stream ID", nil)
+		return
+	}
+
+	if !h.hub.Terminate(id) {
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "stream not found", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "stream terminated"})
+}
+
+// TerminateUser closes all streaming connections of a user
+func (h *StreamsHandler) TerminateUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid user ID", nil)
+		return
+	}
+
+	n := h.hub.TerminateUser(userID)
+	c.JSON(http.StatusOK, gin.H{"message": "streams terminated", "terminated": n})
+}
+
+// openStream registers a streaming connection for the authenticated
+// device. On failure it writes the error response and returns false.
+// Callers must defer conn.Close() and use conn.Context() for the stream.
+func openStream(c *gin.Context, hub *stream.Hub) (*stream.Conn, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return nil, false
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+
+	conn, err := hub.Open(c.Request.Context(), userID, deviceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, stream.ErrUserLimit), errors.Is(err, stream.ErrGlobalLimit):
+			c.Header("Retry-After", strconv.Itoa(streamRetryAfter))
+			apierror.RespondError(c, http.StatusTooManyRequests, "STREAM_LIMIT", err.Error(), nil)
+		default:
+			apierror.RespondError(c, http.StatusServiceUnavailable, apierror.CodeUnavailable, "server shutting down", nil)
+		}
+		return nil, false
+	}
+	return conn, true
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/stream"
+)
+
+func TestOpenStream_LimitAndRecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := stream.NewHub(1, 0)
+	userID := uuid.New()
+
+	var opened *stream.Conn
+	r := gin.New()
+	r.GET("/stream", func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("device_id", uuid.New())
+		conn, ok := openStream(c, hub)
+		if !ok {
+			return
+		}
+		opened = conn
+		c.Status(http.StatusOK)
+	})
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+		return w
+	}
+
+	if w := get(); w.Code != http.StatusOK {
+		t.Fatalf("first stream: status %d", w.Code)
+	}
+
+	w := get()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second stream: status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+
+	opened.Close()
+	if w := get(); w.Code != http.StatusOK {
+		t.Fatalf("stream after release: status %d", w.Code)
+	}
+}
@@ -1,39 +1,94 @@
 package handlers
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base32"
-	"encoding/hex"
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/pquerna/otp/totp"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/rs/zerolog/log"
 
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
 	"github.com/sprobst76/vibedterm-server/internal/config"
+	"github.com/sprobst76/vibedterm-server/internal/events"
 	"github.com/sprobst76/vibedterm-server/internal/middleware"
 	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/recoverycode"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
 )
 
+// totpUserStore is the subset of UserRepository used by TOTPHandler
+type totpUserStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	SetTOTPSecret(ctx context.Context, id uuid.UUID, secret []byte) error
+	EnableTOTP(ctx context.Context, id uuid.UUID) error
+	DisableTOTP(ctx context.Context, id uuid.UUID) error
+}
+
+// recoveryCodeStore is the subset of RecoveryCodeRepository used by TOTPHandler
+type recoveryCodeStore interface {
+	ReplaceAllForUser(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	GetUnusedByUser(ctx context.Context, userID uuid.UUID) ([]models.RecoveryCode, error)
+	MarkUsed(ctx context.Context, id uuid.UUID) error
+	DeleteAllForUser(ctx context.Context, userID uuid.UUID) error
+	CountUnused(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// totpChecker is the subset of service.TOTPGuard used to check second
+// factors
+type totpChecker interface {
+	Check(ctx context.Context, user *models.User, code, surface string) error
+	Attempt(ctx context.Context, user *models.User, surface string) error
+}
+
+// loginIssuer is the subset of AuthHandler that finishes a login
+type loginIssuer interface {
+	issueLogin(c *gin.Context, user *models.User, device loginDevice, tempToken *middleware.Claims, remember bool) (*models.LoginResponse, bool)
+}
+
 // TOTPHandler handles TOTP-related endpoints
 type TOTPHandler struct {
-	userRepo     *repository.UserRepository
-	recoveryRepo *repository.RecoveryCodeRepository
+	userRepo     totpUserStore
+	recoveryRepo recoveryCodeStore
+	tempTokens   tempTokenStore
+	trust        trustedDeviceManager
+	totpGuard    totpChecker
+	logins       loginIssuer
+	notifier     notify.Notifier
+	events       *events.Log
 	config       *config.Config
+	secretSize   uint // bytes of generated secrets, totpsecret.Size if 0
 }
 
 // NewTOTPHandler creates a new TOTP handler
 func NewTOTPHandler(
 	userRepo *repository.UserRepository,
 	recoveryRepo *repository.RecoveryCodeRepository,
+	tempTokenRepo *repository.TempTokenRepository,
+	trust *service.DeviceTrust,
+	totpGuard *service.TOTPGuard,
+	logins *AuthHandler,
+	notifier notify.Notifier,
+	eventLog *events.Log,
 	cfg *config.Config,
 ) *TOTPHandler {
 	return &TOTPHandler{
 		userRepo:     userRepo,
 		recoveryRepo: recoveryRepo,
+		tempTokens:   tempTokenRepo,
+		trust:        trust,
+		totpGuard:    totpGuard,
+		logins:       logins,
+		notifier:     notifier,
+		events:       eventLog,
 		config:       cfg,
 	}
 }
@@ -42,35 +97,32 @@ func NewTOTPHandler(
 func (h *TOTPHandler) Setup(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	user, err := h.userRepo.GetByID(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
 		return
 	}
 
 	if user.TOTPEnabled {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP already enabled"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "TOTP already enabled", nil)
 		return
 	}
 
 	// Generate TOTP key
-	key, err := totp.Generate(totp.GenerateOpts{
-		Issuer:      h.config.TOTPIssuer,
-		AccountName: user.Email,
-	})
+	key, secret, err := totpsecret.Generate(h.config.TOTPIssuer, user.Email, h.secretSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate TOTP"})
+		log.Error().Err(err).Str("user_id", userID.String()).Msg("Failed to generate TOTP secret")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to generate TOTP", nil)
 		return
 	}
 
 	// Store secret (not yet enabled)
-	secret, _ := base32.StdEncoding.DecodeString(key.Secret())
 	if err := h.userRepo.SetTOTPSecret(c.Request.Context(), userID, secret); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save TOTP secret"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to save TOTP secret", nil)
 		return
 	}
 
@@ -85,49 +137,50 @@ func (h *TOTPHandler) Setup(c *gin.Context) {
 func (h *TOTPHandler) Verify(c *gin.Context) {
 	var req models.TOTPVerifyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	user, err := h.userRepo.GetByID(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
 		return
 	}
 
 	if user.TOTPEnabled {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP already enabled"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "TOTP already enabled", nil)
 		return
 	}
 
 	if len(user.TOTPSecret) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP not set up"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "TOTP not set up", nil)
 		return
 	}
 
 	// Validate code
-	secret := base32.StdEncoding.EncodeToString(user.TOTPSecret)
-	if !totp.Validate(req.Code, secret) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid TOTP code"})
+	if !totpsecret.Validate(req.Code, user.TOTPSecret) {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid TOTP code", nil)
 		return
 	}
 
 	// Enable TOTP
 	if err := h.userRepo.EnableTOTP(c.Request.Context(), userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable TOTP"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to enable TOTP", nil)
 		return
 	}
 
+	h.events.Record(c.Request.Context(), events.Entry{SubjectID: &userID, Payload: events.TOTPEnabled{Surface: events.SurfaceAPI}})
+
 	// Generate recovery codes
-	codes, err := h.generateRecoveryCodes(c, userID)
+	codes, err := recoverycode.Issue(c.Request.Context(), h.recoveryRepo, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "TOTP enabled but failed to generate recovery codes"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "TOTP enabled but failed to generate recovery codes", nil)
 		return
 	}
 
@@ -140,43 +193,50 @@ func (h *TOTPHandler) Verify(c *gin.Context) {
 func (h *TOTPHandler) Disable(c *gin.Context) {
 	var req models.TOTPDisableRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	user, err := h.userRepo.GetByID(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
 		return
 	}
 
 	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+	if err := password.Compare(c.Request.Context(), user.PasswordHash, req.Password); err != nil {
+		if errors.Is(err, password.ErrBusy) {
+			respondBusy(c)
+			return
+		}
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid password", nil)
 		return
 	}
 
 	// Verify TOTP code
-	secret := base32.StdEncoding.EncodeToString(user.TOTPSecret)
-	if !totp.Validate(req.Code, secret) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid TOTP code"})
+	if err := h.totpGuard.Check(c.Request.Context(), user, req.Code, events.SurfaceAPI); err != nil {
+		respondTOTPError(c, err)
 		return
 	}
 
 	// Disable TOTP
 	if err := h.userRepo.DisableTOTP(c.Request.Context(), userID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable TOTP"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to disable TOTP", nil)
 		return
 	}
 
-	// Delete recovery codes
+	// Delete recovery codes and forget trusted devices
 	_ = h.recoveryRepo.DeleteAllForUser(c.Request.Context(), userID)
+	if err := h.trust.RevokeAll(c.Request.Context(), userID); err != nil {
+		log.Error().Err(err).Str("user_id", userID.String()).Msg("Failed to revoke trusted devices")
+	}
+	h.events.Record(c.Request.Context(), events.Entry{SubjectID: &userID, Payload: events.TOTPDisabled{Surface: events.SurfaceAPI}})
 
 	c.JSON(http.StatusOK, gin.H{"message": "TOTP disabled"})
 }
@@ -184,141 +244,183 @@ func (h *TOTPHandler) Disable(c *gin.Context) {
 // RegenerateRecoveryCodes generates new recovery codes
 func (h *TOTPHandler) RegenerateRecoveryCodes(c *gin.Context) {
 	var req struct {
-		Code string `json:"code" binding:"required"`
+		Code string `json:"code" binding:"required" input:"token"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	user, err := h.userRepo.GetByID(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
 		return
 	}
 
 	if !user.TOTPEnabled {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP not enabled"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "TOTP not enabled", nil)
 		return
 	}
 
 	// Verify TOTP code
-	secret := base32.StdEncoding.EncodeToString(user.TOTPSecret)
-	if !totp.Validate(req.Code, secret) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid TOTP code"})
+	if err := h.totpGuard.Check(c.Request.Context(), user, req.Code, events.SurfaceAPI); err != nil {
+		respondTOTPError(c, err)
 		return
 	}
 
-	// Delete old recovery codes
-	_ = h.recoveryRepo.DeleteAllForUser(c.Request.Context(), userID)
-
-	// Generate new codes
-	codes, err := h.generateRecoveryCodes(c, userID)
+	// Replace the old codes with new ones
+	codes, err := recoverycode.Issue(c.Request.Context(), h.recoveryRepo, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate recovery codes"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to generate recovery codes", nil)
 		return
 	}
+	h.events.Record(c.Request.Context(), events.Entry{SubjectID: &userID, Payload: events.RecoveryCodesReset{Surface: events.SurfaceAPI}})
 
 	c.JSON(http.StatusOK, models.RecoveryCodesResponse{
 		Codes: codes,
 	})
 }
 
-// ValidateRecovery validates recovery code during login
+// ValidateRecovery validates recovery code during login.
+// Attempts are capped per temp token; once the cap is reached the temp
+// token is invalidated for both recovery and TOTP validation.
 func (h *TOTPHandler) ValidateRecovery(c *gin.Context) {
 	var req models.RecoveryValidateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		apierror.RespondBindError(c, err)
 		return
 	}
 
-	// Parse temp token (reusing from auth handler)
-	claims, err := middleware.ValidateToken(req.TempToken, h.config.JWTSecret)
+	// Only temp tokens are accepted here, never access tokens
+	claims, err := middleware.ValidateTokenType(req.TempToken, h.config.JWTSecret, middleware.TokenTypeTempTOTP)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+		respondTempTokenError(c, err)
 		return
 	}
 
-	userID := claims.UserID
+	jti, err := uuid.Parse(claims.ID)
+	if err != nil || claims.ExpiresAt == nil {
+		respondTempTokenError(c, middleware.ErrInvalidToken)
+		return
+	}
 
-	// Hash the recovery code
-	codeHash := hashRecoveryCode(req.Code)
+	ctx := c.Request.Context()
+	userID := claims.UserID
 
-	// Find and use recovery code
-	recoveryCode, err := h.recoveryRepo.GetByUserAndHash(c.Request.Context(), userID, codeHash)
+	user, err := h.userRepo.GetByID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid recovery code"})
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
 		return
 	}
-
-	if recoveryCode.Used {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "recovery code already used"})
+	// A temp token completes a single login
+	used, err := h.tempTokens.Used(ctx, jti)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to process recovery code", nil)
 		return
 	}
-
-	// Mark as used
-	if err := h.recoveryRepo.MarkUsed(c.Request.Context(), recoveryCode.ID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process recovery code"})
+	if used {
+		respondTempTokenError(c, middleware.ErrInvalidToken)
 		return
 	}
-
-	// Get device info from temp token
-	parts := splitDeviceInfo(claims.Email)
-	if len(parts) != 2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token format"})
+	if err := h.totpGuard.Attempt(ctx, user, events.SurfaceAPI); err != nil {
+		respondTOTPError(c, err)
 		return
 	}
 
-	// Verify user exists
-	_, err = h.userRepo.GetByID(c.Request.Context(), userID)
+	// Count the attempt before checking the code
+	maxAttempts := recoveryMaxAttempts(h.config)
+	attempts, err := h.tempTokens.RecordRecoveryAttempt(ctx, jti, claims.ExpiresAt.Time)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to process recovery code", nil)
+		return
+	}
+	if attempts > maxAttempts {
+		apierror.RespondError(c, http.StatusUnauthorized, "TOO_MANY_ATTEMPTS", "too many recovery attempts", nil)
 		return
 	}
 
-	// Return success - client needs to re-login with credentials
-	c.JSON(http.StatusOK, gin.H{
-		"message":          "recovery code accepted",
-		"remaining_codes":  h.countRemainingCodes(c, userID),
-		"requires_relogin": true,
-	})
-}
-
-func (h *TOTPHandler) generateRecoveryCodes(c *gin.Context, userID uuid.UUID) ([]string, error) {
-	codes := make([]string, 10)
-	ctx := c.Request.Context()
+	// Find recovery code
+	codes, err := h.recoveryRepo.GetUnusedByUser(ctx, userID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to process recovery code", nil)
+		return
+	}
 
-	for i := 0; i < 10; i++ {
-		code := generateRecoveryCode()
-		codes[i] = code
+	recoveryCode := recoverycode.Match(codes, req.Code)
+	if recoveryCode == nil {
+		h.events.Record(ctx, events.Entry{
+			SubjectID: &userID,
+			IP:        c.ClientIP(),
+			Payload:   events.LoginFailed{Surface: events.SurfaceAPI, Reason: events.ReasonInvalidRecoveryCode},
+		})
+		apierror.RespondError(c, http.StatusUnauthorized, "INVALID_RECOVERY_CODE", "invalid recovery code", gin.H{
+			"remaining_attempts": maxAttempts - attempts,
+		})
+		return
+	}
 
-		codeHash := hashRecoveryCode(code)
-		if _, err := h.recoveryRepo.Create(ctx, userID, codeHash); err != nil {
-			return nil, err
+	// Mark as used (fails if a concurrent request used it first)
+	if err := h.recoveryRepo.MarkUsed(ctx, recoveryCode.ID); err != nil {
+		if errors.Is(err, repository.ErrRecoveryCodeNotFound) {
+			apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "recovery code already used", nil)
+			return
 		}
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to process recovery code", nil)
+		return
 	}
 
-	return codes, nil
+	remaining := h.countRemainingCodes(c, userID)
+	h.notifyRecoveryCodeUsed(c, user, claims.DeviceName, remaining)
+
+	// The recovery code replaces the second factor, so the login completes
+	resp, ok := h.logins.issueLogin(c, user, tempTokenDevice(claims), claims, false)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, models.RecoveryLoginResponse{LoginResponse: *resp, RemainingCodes: remaining})
 }
 
-func (h *TOTPHandler) countRemainingCodes(c *gin.Context, userID uuid.UUID) int {
-	count, _ := h.recoveryRepo.CountUnused(c.Request.Context(), userID)
-	return count
+// respondTOTPError answers a second-factor attempt rejected by the TOTP
+// guard. Throttled clients learn when to retry.
+func respondTOTPError(c *gin.Context, err error) {
+	var throttled *service.TOTPThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		apierror.RespondError(c, http.StatusTooManyRequests, "TOTP_THROTTLED", "too many TOTP attempts", nil)
+	case errors.Is(err, service.ErrTOTPReused):
+		apierror.RespondError(c, http.StatusUnauthorized, "TOTP_CODE_REUSED", "TOTP code already used", nil)
+	default:
+		apierror.RespondError(c, http.StatusUnauthorized, "INVALID_TOTP_CODE", "invalid TOTP code", nil)
+	}
 }
 
-func generateRecoveryCode() string {
-	b := make([]byte, 5)
-	rand.Read(b)
-	return hex.EncodeToString(b)
+// notifyRecoveryCodeUsed tells the account owner that a recovery code was used
+func (h *TOTPHandler) notifyRecoveryCodeUsed(c *gin.Context, user *models.User, deviceName string, remaining int) {
+	err := h.notifier.Notify(c.Request.Context(), notify.Notification{
+		Kind:    notify.KindRecoveryCodeUsed,
+		UserID:  user.ID,
+		Email:   user.Email,
+		Subject: "A recovery code was used for your account",
+		Body: fmt.Sprintf(
+			"A recovery code was used to sign in on device %q. %d recovery codes remain. "+
+				"If this wasn't you, change your password and regenerate your recovery codes.",
+			deviceName, remaining,
+		),
+		IP: c.ClientIP(),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to send recovery code notification")
+	}
 }
 
-func hashRecoveryCode(code string) string {
-	hash := sha256.Sum256([]byte(code))
-	return hex.EncodeToString(hash[:])
+func (h *TOTPHandler) countRemainingCodes(c *gin.Context, userID uuid.UUID) int {
+	count, _ := h.recoveryRepo.CountUnused(c.Request.Context(), userID)
+	return count
 }
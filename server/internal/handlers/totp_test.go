@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/sprobst76/vibedterm-server/internal/config"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/recoverycode"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
+)
+
+type memTOTPUsers map[uuid.UUID]*models.User
+
+func (m memTOTPUsers) GetByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	if u, ok := m[id]; ok {
+		return u, nil
+	}
+	return nil, repository.ErrUserNotFound
+}
+func (m memTOTPUsers) SetTOTPSecret(_ context.Context, id uuid.UUID, secret []byte) error {
+	m[id].TOTPSecret = secret
+	return nil
+}
+func (m memTOTPUsers) EnableTOTP(_ context.Context, id uuid.UUID) error {
+	m[id].TOTPEnabled = true
+	return nil
+}
+func (m memTOTPUsers) DisableTOTP(context.Context, uuid.UUID) error { return nil }
+
+type memRecoveryCodes struct {
+	mu    sync.Mutex
+	codes []models.RecoveryCode
+}
+
+func (m *memRecoveryCodes) Create(_ context.Context, userID uuid.UUID, codeHash string) (*models.RecoveryCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := models.RecoveryCode{ID: uuid.New(), UserID: userID, CodeHash: codeHash}
+	m.codes = append(m.codes, code)
+	return &code, nil
+}
+
+func (m *memRecoveryCodes) ReplaceAllForUser(_ context.Context, userID uuid.UUID, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.codes[:0]
+	for _, c := range m.codes {
+		if c.UserID != userID {
+			kept = append(kept, c)
+		}
+	}
+	m.codes = kept
+	for _, h := range codeHashes {
+		m.codes = append(m.codes, models.RecoveryCode{ID: uuid.New(), UserID: userID, CodeHash: h})
+	}
+	return nil
+}
+
+func (m *memRecoveryCodes) GetUnusedByUser(_ context.Context, userID uuid.UUID) ([]models.RecoveryCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.RecoveryCode
+	for _, c := range m.codes {
+		if c.UserID == userID && !c.Used {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (m *memRecoveryCodes) MarkUsed(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.codes {
+		if m.codes[i].ID == id && !m.codes[i].Used {
+			m.codes[i].Used = true
+			return nil
+		}
+	}
+	return repository.ErrRecoveryCodeNotFound
+}
+
+func (m *memRecoveryCodes) DeleteAllForUser(context.Context, uuid.UUID) error { return nil }
+
+func (m *memRecoveryCodes) CountUnused(ctx context.Context, userID uuid.UUID) (int, error) {
+	codes, _ := m.GetUnusedByUser(ctx, userID)
+	return len(codes), nil
+}
+
+type recordingNotifier struct {
+	sent []notify.Notification
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, n notify.Notification) error {
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+// failingNotifier cannot deliver anything
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(context.Context, notify.Notification) error {
+	return errors.New("mail server down")
+}
+
+type recoveryTestEnv struct {
+	handler  *TOTPHandler
+	auth     *AuthHandler
+	stores   *memAuthStores
+	codes    *memRecoveryCodes
+	notifier *recordingNotifier
+	user     *models.User
+}
+
+func newRecoveryTestEnv() *recoveryTestEnv {
+	cfg := &config.Config{JWTSecret: "recovery-secret", RecoveryMaxAttempts: 3, AccessTokenDuration: time.Minute}
+	user := &models.User{ID: uuid.New(), Email: "owner@example.com", TOTPEnabled: true, EmailVerified: true, IsApproved: true}
+	tempTokens := newMemTempTokenStore()
+	stores := &memAuthStores{users: map[uuid.UUID]*models.User{user.ID: user}, devices: map[uuid.UUID]*models.Device{}}
+	env := &recoveryTestEnv{
+		stores:   stores,
+		codes:    &memRecoveryCodes{},
+		notifier: &recordingNotifier{},
+		user:     user,
+	}
+	env.auth = &AuthHandler{
+		userRepo:    stores,
+		deviceRepo:  stores,
+		refreshRepo: authRefreshTokens{stores},
+		tempTokens:  tempTokens,
+		totpGuard:   service.NewTOTPGuard(nopAudit{}, 5, 5*time.Minute),
+		config:      cfg,
+	}
+	env.handler = &TOTPHandler{
+		userRepo:     memTOTPUsers{user.ID: user},
+		recoveryRepo: env.codes,
+		tempTokens:   tempTokens,
+		totpGuard:    env.auth.totpGuard,
+		logins:       env.auth,
+		notifier:     env.notifier,
+		config:       cfg,
+	}
+	return env
+}
+
+func (e *recoveryTestEnv) tempToken(t *testing.T) string {
+	t.Helper()
+	token, err := e.auth.generateTempToken(e.user.ID, loginDevice{Name: "Laptop", Type: "linux"})
+	if err != nil {
+		t.Fatalf("generateTempToken failed: %v", err)
+	}
+	return token
+}
+
+func TestValidateRecovery_AttemptCap(t *testing.T) {
+	env := newRecoveryTestEnv()
+	valid := recoverycode.Generate()
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, valid))
+	token := env.tempToken(t)
+
+	for i := 1; i <= 3; i++ {
+		w, resp := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": token, "code": "0000000000"})
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %d, want %d", i, w.Code, http.StatusUnauthorized)
+		}
+		details, _ := resp["details"].(map[string]interface{})
+		if details["remaining_attempts"] != float64(3-i) {
+			t.Errorf("attempt %d remaining_attempts = %v, want %d", i, details["remaining_attempts"], 3-i)
+		}
+	}
+
+	// Even the correct code is refused once the cap is reached
+	w, resp := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": token, "code": valid})
+	if w.Code != http.StatusUnauthorized || resp["code"] != "TOO_MANY_ATTEMPTS" {
+		t.Errorf("after cap: status = %d code = %v, want 401 TOO_MANY_ATTEMPTS", w.Code, resp["code"])
+	}
+
+	// The temp token is invalidated for TOTP validation as well
+	w, resp = postJSON(t, env.auth.ValidateTOTP, gin.H{"temp_token": token, "code": "123456"})
+	if w.Code != http.StatusUnauthorized || resp["code"] != "TEMP_TOKEN_INVALID" {
+		t.Errorf("TOTP after cap: status = %d code = %v, want 401 TEMP_TOKEN_INVALID", w.Code, resp["code"])
+	}
+
+	if len(env.notifier.sent) != 0 {
+		t.Errorf("sent %d notifications for failed attempts, want 0", len(env.notifier.sent))
+	}
+}
+
+func TestValidateTOTP_ReplayAndThrottle(t *testing.T) {
+	env := newRecoveryTestEnv()
+	_, secret, err := totpsecret.Generate("VibedTerm", env.user.Email, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.user.TOTPSecret = secret
+	code, err := totp.GenerateCode(totpsecret.Encode(secret), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, _ := postJSON(t, env.auth.ValidateTOTP, gin.H{"temp_token": env.tempToken(t), "code": code})
+	if w.Code != http.StatusOK {
+		t.Fatalf("first use = %d %s", w.Code, w.Body.String())
+	}
+	w, resp := postJSON(t, env.auth.ValidateTOTP, gin.H{"temp_token": env.tempToken(t), "code": code})
+	if w.Code != http.StatusUnauthorized || resp["code"] != "TOTP_CODE_REUSED" {
+		t.Fatalf("replay: status = %d code = %v, want 401 TOTP_CODE_REUSED", w.Code, resp["code"])
+	}
+
+	// The replay counted; four more attempts exhaust the limit of five
+	for i := 0; i < 4; i++ {
+		postJSON(t, env.auth.ValidateTOTP, gin.H{"temp_token": env.tempToken(t), "code": "000000"})
+	}
+	w, resp = postJSON(t, env.auth.ValidateTOTP, gin.H{"temp_token": env.tempToken(t), "code": code})
+	if w.Code != http.StatusTooManyRequests || resp["code"] != "TOTP_THROTTLED" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("throttled TOTP: status = %d code = %v Retry-After = %q", w.Code, resp["code"], w.Header().Get("Retry-After"))
+	}
+
+	// Recovery codes share the limit
+	valid := recoverycode.Generate()
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, valid))
+	w, resp = postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": env.tempToken(t), "code": valid})
+	if w.Code != http.StatusTooManyRequests || resp["code"] != "TOTP_THROTTLED" {
+		t.Errorf("throttled recovery: status = %d code = %v", w.Code, resp["code"])
+	}
+}
+
+// Changing the second factor takes a code like a login does: it counts
+// towards the same limit and cannot be replayed
+func TestTOTPSettings_ReplayAndThrottle(t *testing.T) {
+	env := newRecoveryTestEnv()
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.user.PasswordHash = hash
+	_, secret, err := totpsecret.Generate("VibedTerm", env.user.Email, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.user.TOTPSecret = secret
+	code, err := totp.GenerateCode(totpsecret.Encode(secret), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	regenerate := func(code string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return callSettingsBlob(env.handler.RegenerateRecoveryCodes, env.user.ID, `{"code": "`+code+`"}`)
+	}
+	disable := func(code string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		return callSettingsBlob(env.handler.Disable, env.user.ID, `{"password": "correct horse", "code": "`+code+`"}`)
+	}
+
+	if w, resp := regenerate(code); w.Code != http.StatusOK {
+		t.Fatalf("regenerate = %d %v", w.Code, resp)
+	}
+	if w, resp := regenerate(code); w.Code != http.StatusUnauthorized || resp["code"] != "TOTP_CODE_REUSED" {
+		t.Errorf("regenerate replay: status = %d code = %v, want 401 TOTP_CODE_REUSED", w.Code, resp["code"])
+	}
+	if w, resp := disable(code); w.Code != http.StatusUnauthorized || resp["code"] != "TOTP_CODE_REUSED" {
+		t.Errorf("disable replay: status = %d code = %v, want 401 TOTP_CODE_REUSED", w.Code, resp["code"])
+	}
+	for i := 0; i < 3; i++ {
+		disable("000000")
+	}
+	if w, resp := disable("000000"); w.Code != http.StatusTooManyRequests || resp["code"] != "TOTP_THROTTLED" {
+		t.Errorf("throttled disable: status = %d code = %v, want 429 TOTP_THROTTLED", w.Code, resp["code"])
+	}
+	if w, resp := regenerate("000000"); w.Code != http.StatusTooManyRequests || resp["code"] != "TOTP_THROTTLED" {
+		t.Errorf("throttled regenerate: status = %d code = %v, want 429 TOTP_THROTTLED", w.Code, resp["code"])
+	}
+}
+
+func TestValidateTOTP_BlockedAfterPasswordStep(t *testing.T) {
+	env := newRecoveryTestEnv()
+	_, secret, err := totpsecret.Generate("VibedTerm", env.user.Email, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.user.TOTPSecret = secret
+	code, err := totp.GenerateCode(totpsecret.Encode(secret), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := env.tempToken(t)
+
+	env.user.IsApproved = false
+	w, resp := postJSON(t, env.auth.ValidateTOTP, gin.H{"temp_token": token, "code": code})
+	if w.Code != http.StatusForbidden || resp["code"] != "PENDING_APPROVAL" || resp["access_token"] != nil {
+		t.Errorf("unapproved account: status = %d %v, want 403 PENDING_APPROVAL", w.Code, resp)
+	}
+	if len(env.stores.tokens) != 0 {
+		t.Errorf("unapproved account got %d refresh tokens", len(env.stores.tokens))
+	}
+}
+
+func TestValidateTOTP_TempTokenReusedAfterSuccess(t *testing.T) {
+	env := newRecoveryTestEnv()
+	_, secret, err := totpsecret.Generate("VibedTerm", env.user.Email, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.user.TOTPSecret = secret
+	code, err := totp.GenerateCode(totpsecret.Encode(secret), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := env.tempToken(t)
+
+	if w, _ := postJSON(t, env.auth.ValidateTOTP, gin.H{"temp_token": token, "code": code}); w.Code != http.StatusOK {
+		t.Fatalf("first login = %d %s", w.Code, w.Body.String())
+	}
+	valid := recoverycode.Generate()
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, valid))
+	for _, tc := range []struct {
+		name    string
+		handler gin.HandlerFunc
+		body    gin.H
+	}{
+		{"totp", env.auth.ValidateTOTP, gin.H{"temp_token": token, "code": code}},
+		{"recovery", env.handler.ValidateRecovery, gin.H{"temp_token": token, "code": valid}},
+		{"extend", env.auth.ExtendTempToken, gin.H{"temp_token": token}},
+	} {
+		if w, resp := postJSON(t, tc.handler, tc.body); w.Code != http.StatusUnauthorized || resp["code"] != "TEMP_TOKEN_INVALID" {
+			t.Errorf("%s with a used temp token: status = %d code = %v, want 401 TEMP_TOKEN_INVALID", tc.name, w.Code, resp["code"])
+		}
+	}
+	if len(env.stores.tokens) != 1 {
+		t.Errorf("issued %d refresh tokens, want 1", len(env.stores.tokens))
+	}
+}
+
+func TestValidateRecovery_NotifiesOwner(t *testing.T) {
+	env := newRecoveryTestEnv()
+	valid := recoverycode.Generate()
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, valid))
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, recoverycode.Generate()))
+
+	w, resp := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": env.tempToken(t), "code": valid})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+	if resp["remaining_codes"] != float64(1) {
+		t.Errorf("remaining_codes = %v, want 1", resp["remaining_codes"])
+	}
+
+	if len(env.notifier.sent) != 1 {
+		t.Fatalf("sent %d notifications, want 1", len(env.notifier.sent))
+	}
+	n := env.notifier.sent[0]
+	if n.Kind != notify.KindRecoveryCodeUsed || n.UserID != env.user.ID || n.Email != env.user.Email {
+		t.Errorf("notification = %+v", n)
+	}
+
+	// A used code can't be replayed with a fresh temp token
+	w, _ = postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": env.tempToken(t), "code": valid})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("replay status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestValidateRecovery_CompletesLogin(t *testing.T) {
+	env := newRecoveryTestEnv()
+	valid := recoverycode.Generate()
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, valid))
+
+	w, resp := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": env.tempToken(t), "code": valid})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+	if resp["requires_relogin"] != false || resp["remaining_codes"] != float64(0) {
+		t.Errorf("requires_relogin = %v, remaining_codes = %v", resp["requires_relogin"], resp["remaining_codes"])
+	}
+	access, _ := resp["access_token"].(string)
+	claims, err := middleware.ValidateTokenType(access, "recovery-secret", middleware.TokenTypeAccess)
+	if err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+	device, ok := env.stores.devices[claims.DeviceID]
+	if claims.UserID != env.user.ID || !ok || device.DeviceName != "Laptop" || resp["device_id"] != claims.DeviceID.String() {
+		t.Errorf("token for user %s device %s, devices %v", claims.UserID, claims.DeviceID, env.stores.devices)
+	}
+	if len(env.stores.tokens) != 1 || resp["refresh_token"] == "" {
+		t.Errorf("no refresh token issued: %v", resp["refresh_token"])
+	}
+}
+
+// An admin may block the account between the password step and the
+// recovery code
+func TestValidateRecovery_BlockedAfterPasswordStep(t *testing.T) {
+	env := newRecoveryTestEnv()
+	valid := recoverycode.Generate()
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, valid))
+	token := env.tempToken(t)
+
+	env.user.IsBlocked = true
+	w, resp := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": token, "code": valid})
+	if w.Code != http.StatusForbidden || resp["code"] != "ACCOUNT_BLOCKED" || resp["access_token"] != nil {
+		t.Errorf("blocked account: status = %d %v, want 403 ACCOUNT_BLOCKED", w.Code, resp)
+	}
+	if len(env.stores.tokens) != 0 || len(env.stores.devices) != 0 {
+		t.Errorf("blocked account got %d tokens for %d devices", len(env.stores.tokens), len(env.stores.devices))
+	}
+}
+
+func TestValidateRecovery_TempTokenReusedAfterSuccess(t *testing.T) {
+	env := newRecoveryTestEnv()
+	first, second := recoverycode.Generate(), recoverycode.Generate()
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, first))
+	_, _ = env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, second))
+	token := env.tempToken(t)
+
+	if w, _ := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": token, "code": first}); w.Code != http.StatusOK {
+		t.Fatalf("first login = %d %s", w.Code, w.Body.String())
+	}
+	w, resp := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": token, "code": second})
+	if w.Code != http.StatusUnauthorized || resp["code"] != "TEMP_TOKEN_INVALID" {
+		t.Errorf("reused temp token: status = %d code = %v, want 401 TEMP_TOKEN_INVALID", w.Code, resp["code"])
+	}
+	if len(env.stores.tokens) != 1 {
+		t.Errorf("issued %d refresh tokens, want 1", len(env.stores.tokens))
+	}
+	if remaining, _ := env.codes.CountUnused(context.Background(), env.user.ID); remaining != 1 {
+		t.Errorf("reused temp token burned a recovery code: %d left, want 1", remaining)
+	}
+}
+
+func TestValidateRecovery_RejectedCodesIssueNoTokens(t *testing.T) {
+	env := newRecoveryTestEnv()
+	used := recoverycode.Generate()
+	code, _ := env.codes.Create(context.Background(), env.user.ID, recoverycode.Hash(env.user.ID, used))
+	_ = env.codes.MarkUsed(context.Background(), code.ID)
+
+	for name, c := range map[string]string{"used": used, "unknown": recoverycode.Generate()} {
+		w, resp := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": env.tempToken(t), "code": c})
+		if w.Code != http.StatusUnauthorized || resp["access_token"] != nil {
+			t.Errorf("%s code: status = %d %v", name, w.Code, resp)
+		}
+	}
+	if len(env.stores.tokens) != 0 || len(env.stores.devices) != 0 {
+		t.Errorf("rejected codes issued %d tokens for %d devices", len(env.stores.tokens), len(env.stores.devices))
+	}
+}
+
+func TestValidateRecovery_LegacyCodeLength(t *testing.T) {
+	env := newRecoveryTestEnv()
+	// Codes generated before the entropy increase were 10 hex characters,
+	// stored as unsalted SHA-256
+	legacy := sha256.Sum256([]byte("a1b2c3d4e5"))
+	_, _ = env.codes.Create(context.Background(), env.user.ID, hex.EncodeToString(legacy[:]))
+
+	w, _ := postJSON(t, env.handler.ValidateRecovery, gin.H{"temp_token": env.tempToken(t), "code": " A1B2C-3D4E5 "})
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d (%s)", w.Code, http.StatusOK, w.Body.String())
+	}
+}
+
+func TestTOTPSetup_SecretSizes(t *testing.T) {
+	// Sizes whose base32 form needs padding used to be stored empty
+	for _, size := range []uint{16, 20, 21, 23, 32, 64} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			user := &models.User{ID: uuid.New(), Email: "totp@example.com"}
+			h := &TOTPHandler{
+				userRepo:     memTOTPUsers{user.ID: user},
+				recoveryRepo: &memRecoveryCodes{},
+				config:       &config.Config{TOTPIssuer: "VibedTerm"},
+				secretSize:   size,
+			}
+			asUser := func(handler gin.HandlerFunc) gin.HandlerFunc {
+				return func(c *gin.Context) {
+					c.Set("user_id", user.ID)
+					handler(c)
+				}
+			}
+
+			w, resp := postJSON(t, asUser(h.Setup), nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("setup: status %d %v", w.Code, resp)
+			}
+			if len(user.TOTPSecret) != int(size) {
+				t.Fatalf("stored %d secret bytes, want %d", len(user.TOTPSecret), size)
+			}
+			if resp["secret"] != totpsecret.Encode(user.TOTPSecret) {
+				t.Errorf("shown secret %v does not match the stored one", resp["secret"])
+			}
+
+			code, err := totp.GenerateCode(resp["secret"].(string), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, resp = postJSON(t, asUser(h.Verify), models.TOTPVerifyRequest{Code: code})
+			if w.Code != http.StatusOK || !user.TOTPEnabled {
+				t.Fatalf("verify: status %d %v", w.Code, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// trustedDeviceManager is the subset of service.DeviceTrust used to manage
+// the user's trusted devices
+type trustedDeviceManager interface {
+	List(ctx context.Context, userID uuid.UUID) ([]models.TrustedDevice, error)
+	Revoke(ctx context.Context, userID, id uuid.UUID) error
+	RevokeAll(ctx context.Context, userID uuid.UUID) error
+}
+
+// TrustedDeviceHandler lists and revokes devices that skip TOTP
+type TrustedDeviceHandler struct {
+	trust trustedDeviceManager
+}
+
+// NewTrustedDeviceHandler creates a new trusted device handler
+func NewTrustedDeviceHandler(trust *service.DeviceTrust) *TrustedDeviceHandler {
+	return &TrustedDeviceHandler{trust: trust}
+}
+
+// List lists the trusted devices and browsers of the current user
+func (h *TrustedDeviceHandler) List(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	trusted, err := h.trust.List(c.Request.Context(), userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list trusted devices")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list trusted devices", nil)
+		return
+	}
+	if trusted == nil {
+		trusted = []models.TrustedDevice{}
+	}
+	c.JSON(http.StatusOK, gin.H{"trusted_devices": trusted})
+}
+
+// Revoke ends the trust of one device; its next login asks for TOTP again
+func (h *TrustedDeviceHandler) Revoke(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid trusted device ID", nil)
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	err = h.trust.Revoke(c.Request.Context(), userID, id)
+	if errors.Is(err, repository.ErrTrustedDeviceNotFound) {
+		apierror.RespondError(c, http.StatusNotFound, "TRUSTED_DEVICE_NOT_FOUND", "trusted device not found", nil)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke trusted device")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to revoke trusted device", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "trusted device revoked"})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base32"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// memTrustedDevices keeps trust entries by token hash
+type memTrustedDevices map[string]*models.TrustedDevice
+
+func (m memTrustedDevices) Create(_ context.Context, trusted *models.TrustedDevice) error {
+	m[trusted.TokenHash] = trusted
+	return nil
+}
+
+func (m memTrustedDevices) Use(_ context.Context, tokenHash string) (*models.TrustedDevice, error) {
+	trusted, ok := m[tokenHash]
+	if !ok || !trusted.ExpiresAt.After(time.Now()) {
+		return nil, repository.ErrTrustedDeviceNotFound
+	}
+	return trusted, nil
+}
+
+func (m memTrustedDevices) GetActiveByUser(_ context.Context, userID uuid.UUID) ([]models.TrustedDevice, error) {
+	var trusted []models.TrustedDevice
+	for _, t := range m {
+		if t.UserID == userID {
+			trusted = append(trusted, *t)
+		}
+	}
+	return trusted, nil
+}
+
+func (m memTrustedDevices) Delete(_ context.Context, userID, id uuid.UUID) error {
+	for hash, t := range m {
+		if t.ID == id && t.UserID == userID {
+			delete(m, hash)
+			return nil
+		}
+	}
+	return repository.ErrTrustedDeviceNotFound
+}
+
+func (m memTrustedDevices) DeleteAllForUser(_ context.Context, userID uuid.UUID) error {
+	for hash, t := range m {
+		if t.UserID == userID {
+			delete(m, hash)
+		}
+	}
+	return nil
+}
+
+func (m memTrustedDevices) DeleteExpired(context.Context) (int64, error) { return 0, nil }
+
+func TestTrustedDevice_SkipsTOTP(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("12345678901234567890")
+	user := &models.User{ID: uuid.New(), Email: "totp@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true, TOTPEnabled: true, TOTPSecret: secret}
+	h, _ := newGrantTestHandler(t, user)
+	store := memTrustedDevices{}
+	trust := service.NewDeviceTrust(store, 30*24*time.Hour)
+	h.trust = trust
+
+	login := func(trustToken, deviceName string) map[string]interface{} {
+		t.Helper()
+		w, resp := postJSON(t, h.Login, gin.H{"email": user.Email, "password": "correct horse", "device_name": deviceName, "device_type": "linux", "trust_token": trustToken})
+		if w.Code != http.StatusOK {
+			t.Fatalf("login = %d %s", w.Code, w.Body.String())
+		}
+		return resp
+	}
+
+	resp := login("", "Laptop")
+	tempToken, _ := resp["temp_token"].(string)
+	if tempToken == "" {
+		t.Fatalf("first login skipped TOTP: %v", resp)
+	}
+	code, _ := totp.GenerateCode(base32.StdEncoding.EncodeToString(secret), time.Now())
+	w, resp := postJSON(t, h.ValidateTOTP, gin.H{"temp_token": tempToken, "code": code, "remember_device": true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("TOTP = %d %s", w.Code, w.Body.String())
+	}
+	trustToken, _ := resp["trust_token"].(string)
+	if trustToken == "" || len(store) != 1 {
+		t.Fatalf("no trust token issued: %v", resp)
+	}
+
+	if resp := login(trustToken, "Laptop"); resp["access_token"] == nil {
+		t.Errorf("trusted device still asked for TOTP: %v", resp)
+	}
+	if resp := login(trustToken, "Other laptop"); resp["temp_token"] == nil {
+		t.Errorf("trust token skipped TOTP for another device: %v", resp)
+	}
+	if resp := login("forged", "Laptop"); resp["temp_token"] == nil {
+		t.Errorf("unknown trust token skipped TOTP: %v", resp)
+	}
+
+	// Revoking the device brings TOTP back
+	list, _ := trust.List(context.Background(), user.ID)
+	if w, resp := callSessions(NewTrustedDeviceHandler(trust).Revoke, user.ID, uuid.Nil, list[0].ID.String()); w.Code != http.StatusOK {
+		t.Fatalf("revoke = %d %v", w.Code, resp)
+	}
+	if resp := login(trustToken, "Laptop"); resp["temp_token"] == nil {
+		t.Errorf("revoked trust token skipped TOTP: %v", resp)
+	}
+}
+
+func TestDisableTOTP_ForgetsTrustedDevices(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("12345678901234567890")
+	user := &models.User{ID: uuid.New(), Email: "totp@example.com", PasswordHash: hash, TOTPEnabled: true, TOTPSecret: secret}
+	store := memTrustedDevices{}
+	trust := service.NewDeviceTrust(store, time.Hour)
+	if _, _, err := trust.TrustDevice(context.Background(), user.ID, uuid.New(), "Laptop"); err != nil {
+		t.Fatal(err)
+	}
+	h := &TOTPHandler{
+		userRepo:     memTOTPUsers{user.ID: user},
+		recoveryRepo: &memRecoveryCodes{},
+		trust:        trust,
+		totpGuard:    service.NewTOTPGuard(nopAudit{}, 5, 5*time.Minute),
+	}
+
+	code, _ := totp.GenerateCode(base32.StdEncoding.EncodeToString(secret), time.Now())
+	w, resp := callSettingsBlob(h.Disable, user.ID, `{"password": "correct horse", "code": "`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("disable = %d %v", w.Code, resp)
+	}
+	if len(store) != 0 {
+		t.Errorf("%d trusted devices left after disabling TOTP", len(store))
+	}
+}
@@ -1,16 +1,24 @@
 package handlers
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
 	"github.com/sprobst76/vibedterm-server/internal/middleware"
 	"github.com/sprobst76/vibedterm-server/internal/models"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
 )
 
 // VaultHandler handles vault sync endpoints
@@ -18,6 +26,17 @@ type VaultHandler struct {
 	vaultRepo  *repository.VaultRepository
 	deviceRepo *repository.DeviceRepository
 	syncRepo   *repository.SyncLogRepository
+	vaultSync  *service.VaultSync
+	trash      *service.VaultTrash
+	receipts   *service.PushReceipts
+	delta      *service.VaultDelta
+	settings   *service.ClientSettingsSync
+	migration  *service.VaultMigration
+	maxSize    int64 // largest decoded vault a push may store; 0 = unlimited
+
+	hub          *stream.Hub
+	changes      *stream.Broker
+	watchTimeout time.Duration // longest a watch is held open
 }
 
 // NewVaultHandler creates a new vault handler
@@ -25,223 +44,567 @@ func NewVaultHandler(
 	vaultRepo *repository.VaultRepository,
 	deviceRepo *repository.DeviceRepository,
 	syncRepo *repository.SyncLogRepository,
+	vaultSync *service.VaultSync,
+	trash *service.VaultTrash,
+	receipts *service.PushReceipts,
+	delta *service.VaultDelta,
+	settings *service.ClientSettingsSync,
+	migration *service.VaultMigration,
+	maxSize int64,
+	hub *stream.Hub,
+	changes *stream.Broker,
+	watchTimeout time.Duration,
 ) *VaultHandler {
 	return &VaultHandler{
 		vaultRepo:  vaultRepo,
 		deviceRepo: deviceRepo,
 		syncRepo:   syncRepo,
+		vaultSync:  vaultSync,
+		trash:      trash,
+		receipts:   receipts,
+		delta:      delta,
+		settings:   settings,
+		migration:  migration,
+		maxSize:    maxSize,
+
+		hub:          hub,
+		changes:      changes,
+		watchTimeout: watchTimeout,
 	}
 }
 
-// Status returns the current vault status
-func (h *VaultHandler) Status(c *gin.Context) {
+// vaultName returns the vault a request is about: name if set, else the
+// ?vault= query parameter, else DefaultVaultName. It responds and returns
+// false if the name is invalid.
+func vaultName(c *gin.Context, name string) (string, bool) {
+	if name == "" {
+		name = c.Query("vault")
+	}
+	if name == "" {
+		return models.DefaultVaultName, true
+	}
+	if !models.ValidVaultName(name) {
+		apierror.RespondInvalidInput(c, input.Errors{{Field: "vault", Code: input.CodeInvalid, Message: "must be lowercase letters, digits, dots, dashes or underscores"}})
+		return "", false
+	}
+	return name, true
+}
+
+// List returns the user's vaults without their blobs
+func (h *VaultHandler) List(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
-	vault, err := h.vaultRepo.GetByUserID(c.Request.Context(), userID)
+	vaults, err := h.vaultRepo.List(c.Request.Context(), userID)
 	if err != nil {
-		if err == repository.ErrVaultNotFound {
-			c.JSON(http.StatusOK, models.VaultStatusResponse{
-				HasVault:  false,
-				Revision:  0,
-				UpdatedAt: 0,
-			})
+		middleware.Logger(c).Error().Err(err).Msg("Failed to list vaults")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list vaults", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.VaultListResponse{Vaults: vaults, MaxVaults: h.vaultRepo.MaxPerUser()})
+}
+
+// Status returns the status of the vault picked by ?vault=. With
+// ?devices=true it also lists the sync state of all of the user's
+// devices; device lag is only tracked for the default vault.
+func (h *VaultHandler) Status(c *gin.Context) {
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	status := models.VaultStatusResponse{Name: name}
+	vault, err := h.vaultRepo.GetByUserID(c.Request.Context(), userID, name)
+	switch {
+	case err == nil:
+		status.HasVault = true
+		status.Revision = vault.Revision
+		status.UpdatedAt = vault.UpdatedAt.Unix()
+		status.VaultVersion = vault.VaultVersion
+		status.SizeBytes = len(vault.VaultBlob)
+		status.MaxSizeBytes = h.maxSize
+		status.SHA256 = vault.Checksum
+	case err != repository.ErrVaultNotFound:
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get vault status")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault status", nil)
+		return
+	}
+
+	if status.HasVault {
+		if status.MigrationRequired, err = h.migrationNotice(c, status.VaultVersion); err != nil {
+			middleware.Logger(c).Error().Err(err).Msg("Failed to get vault status")
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault status", nil)
+			return
+		}
+	}
+
+	if status.HasVault && name == models.DefaultVaultName {
+
+		// A caller without a known device gets no lag
+		deviceID, _ := middleware.GetDeviceID(c)
+		device, err := h.deviceRepo.GetByID(c.Request.Context(), deviceID)
+		switch {
+		case err == nil:
+			lag := service.RevisionLag(status.Revision, device.LastSeenRevision)
+			status.BehindBy = &lag
+		case !errors.Is(err, repository.ErrDeviceNotFound):
+			middleware.Logger(c).Error().Err(err).Msg("Failed to get vault status")
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault status", nil)
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get vault status"})
+
+		if c.Query("devices") == "true" {
+			devices, err := h.deviceRepo.GetByUserID(c.Request.Context(), userID)
+			if err != nil {
+				middleware.Logger(c).Error().Err(err).Msg("Failed to get vault status")
+				apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault status", nil)
+				return
+			}
+			status.Devices = service.DeviceSyncStatus(devices, status.Revision)
+		}
+	}
+
+	settings, err := h.settings.Get(c.Request.Context(), userID)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get vault status")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault status", nil)
 		return
 	}
+	if settings != nil {
+		status.HasSettings = true
+		status.SettingsRevision = settings.Revision
+		status.SettingsUpdatedAt = settings.UpdatedAt.Unix()
+	}
 
-	c.JSON(http.StatusOK, models.VaultStatusResponse{
-		HasVault:  true,
-		Revision:  vault.Revision,
-		UpdatedAt: vault.UpdatedAt.Unix(),
-	})
+	c.JSON(http.StatusOK, status)
 }
 
-// Pull downloads the encrypted vault
+// Pull downloads the encrypted vault picked by ?vault=
 func (h *VaultHandler) Pull(c *gin.Context) {
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	deviceID, _ := middleware.GetDeviceID(c)
 
-	vault, err := h.vaultRepo.GetByUserID(c.Request.Context(), userID)
+	stream, err := h.vaultSync.Pull(c.Request.Context(), userID, deviceID, name)
 	if err != nil {
 		if err == repository.ErrVaultNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "no vault found", "code": "NO_VAULT"})
+			apierror.RespondError(c, http.StatusNotFound, "NO_VAULT", "no vault found", nil)
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get vault"})
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get vault")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault", nil)
 		return
 	}
+	defer stream.Close()
+	vault := stream.Vault
 
-	// Log sync
-	_ = h.syncRepo.Create(c.Request.Context(), userID, &deviceID, "pull", &vault.Revision, nil)
+	notice, err := h.migrationNotice(c, vault.VaultVersion)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get vault")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault", nil)
+		return
+	}
 
-	// Update device last sync
-	_ = h.deviceRepo.UpdateLastSync(c.Request.Context(), deviceID)
+	blob := make([]byte, stream.SizeBytes)
+	if _, err := io.ReadFull(stream, blob); err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get vault")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault", nil)
+		return
+	}
 
 	var updatedByDevice string
 	if vault.UpdatedByDevice != nil {
 		updatedByDevice = vault.UpdatedByDevice.String()
 	}
 
-	c.JSON(http.StatusOK, models.VaultPullResponse{
-		VaultBlob:       base64.StdEncoding.EncodeToString(vault.VaultBlob),
+	respondJSON(c, http.StatusOK, models.VaultPullResponse{
+		Name:            vault.Name,
+		VaultBlob:       base64.StdEncoding.EncodeToString(blob),
 		Revision:        vault.Revision,
 		UpdatedAt:       vault.UpdatedAt.Unix(),
 		UpdatedByDevice: updatedByDevice,
+		VaultVersion:    vault.VaultVersion,
+		SizeBytes:       len(blob),
+		SHA256:          vault.Checksum,
+
+		MigrationRequired: notice,
 	})
 }
 
-// Push uploads the encrypted vault
+// migrationNotice returns the migration notice for a vault of vaultVersion
+func (h *VaultHandler) migrationNotice(c *gin.Context, vaultVersion int) (*models.VaultMigrationNotice, error) {
+	campaign, err := h.migration.Campaign(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	return campaign.Notice(vaultVersion, time.Now()), nil
+}
+
+// Push uploads the encrypted vault. A push sent with an idempotency key
+// can be retried: a repeated key gets the original response.
 func (h *VaultHandler) Push(c *gin.Context) {
-	var req models.VaultPushRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+	req, blob, ok := h.bindPush(c)
+	if !ok {
 		return
 	}
+	h.push(c, req, blob)
+}
 
+// push applies a decoded push and responds with the outcome
+func (h *VaultHandler) push(c *gin.Context, req *models.VaultPushRequest, blob []byte) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	deviceID, _ := middleware.GetDeviceID(c)
 
-	// Decode vault blob
-	vaultBlob, err := base64.StdEncoding.DecodeString(req.VaultBlob)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault blob encoding"})
+	key, ok := idempotencyKey(c, req)
+	if !ok || (key != "" && h.replayPush(c, userID, key, req.Name)) {
 		return
 	}
 
-	ctx := c.Request.Context()
-
-	// Check current vault state
-	currentVault, err := h.vaultRepo.GetByUserID(ctx, userID)
-	if err != nil && err != repository.ErrVaultNotFound {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check vault"})
+	verdict, vault, err := h.vaultSync.PushBlob(c.Request.Context(), userID, deviceID, req, blob)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to push vault")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to push vault", nil)
 		return
 	}
 
-	// Handle first vault creation
-	if currentVault == nil {
-		vault, err := h.vaultRepo.Create(ctx, userID, vaultBlob, &deviceID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create vault"})
+	switch verdict.Code {
+	case "":
+	case service.PushCodeConflict:
+		// A concurrent retry with the same key may have won the race
+		if key != "" && h.replayPush(c, userID, key, req.Name) {
 			return
 		}
-
-		_ = h.syncRepo.Create(ctx, userID, &deviceID, "push_initial", nil, &vault.Revision)
-		_ = h.deviceRepo.UpdateLastSync(ctx, deviceID)
-
-		c.JSON(http.StatusOK, models.VaultPushResponse{
-			Status:    "created",
-			Revision:  vault.Revision,
-			Timestamp: vault.UpdatedAt.Unix(),
+		c.JSON(http.StatusConflict, models.VaultConflictResponse{
+			Error:          verdict.Error,
+			Code:           verdict.Code,
+			LocalRevision:  verdict.LocalRevision,
+			ServerRevision: verdict.ServerRevision,
+			ServerDeviceID: verdict.ServerDeviceID,
+			ServerUpdated:  verdict.ServerUpdated,
 		})
 		return
+	case service.PushCodeMigrationNeeded:
+		apierror.RespondError(c, http.StatusUpgradeRequired, verdict.Code, verdict.Error, nil)
+		return
+	case service.PushCodeDowngrade:
+		apierror.RespondError(c, http.StatusConflict, verdict.Code, verdict.Error, gin.H{"server_vault_version": verdict.ServerVersion})
+		return
+	case service.PushCodeUnsupported:
+		respondVersionUnsupported(c)
+		return
+	case service.PushCodeVaultLimit:
+		respondVaultLimit(c)
+		return
+	case service.PushCodeReadOnly:
+		respondDeviceReadOnly(c)
+		return
+	default:
+		apierror.RespondError(c, http.StatusBadRequest, verdict.Code, verdict.Error, nil)
+		return
 	}
 
-	// Check for conflicts
-	if req.Revision != currentVault.Revision {
-		var serverDeviceID string
-		if currentVault.UpdatedByDevice != nil {
-			serverDeviceID = currentVault.UpdatedByDevice.String()
+	h.changes.Publish(userID, stream.Event{Vault: vault.Name, Revision: vault.Revision, DeviceID: deviceID})
+
+	status := "updated"
+	if verdict.WouldCreate {
+		status = "created"
+	}
+	resp := models.VaultPushResponse{
+		Status:    status,
+		Revision:  vault.Revision,
+		Timestamp: vault.UpdatedAt.Unix(),
+	}
+	if key != "" {
+		// The vault is written; a client that hangs up now must still
+		// find the receipt when it retries
+		if err := h.receipts.Remember(context.WithoutCancel(c.Request.Context()), userID, key, vault.Name, resp); err != nil {
+			middleware.Logger(c).Warn().Err(err).Str("user_id", userID.String()).Msg("Failed to store push receipt")
 		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
 
-		c.JSON(http.StatusConflict, models.VaultConflictResponse{
-			Error:          "revision mismatch",
-			Code:           "CONFLICT",
-			LocalRevision:  req.Revision,
-			ServerRevision: currentVault.Revision,
-			ServerDeviceID: serverDeviceID,
-			ServerUpdated:  currentVault.UpdatedAt.Unix(),
-		})
+// ValidatePush runs all push checks without writing anything and returns
+// the verdict a real push would get
+func (h *VaultHandler) ValidatePush(c *gin.Context) {
+	req, blob, ok := h.bindPush(c)
+	if !ok {
 		return
 	}
 
-	// Update vault
-	oldRevision := currentVault.Revision
-	vault, err := h.vaultRepo.Update(ctx, userID, vaultBlob, currentVault.Revision+1, &deviceID)
+	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update vault"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
-	_ = h.syncRepo.Create(ctx, userID, &deviceID, "push", &oldRevision, &vault.Revision)
-	_ = h.deviceRepo.UpdateLastSync(ctx, deviceID)
+	verdict, err := h.vaultSync.ValidatePushBlob(c.Request.Context(), userID, req, blob)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to validate push")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to validate push", nil)
+		return
+	}
 
-	c.JSON(http.StatusOK, models.VaultPushResponse{
-		Status:    "updated",
-		Revision:  vault.Revision,
-		Timestamp: vault.UpdatedAt.Unix(),
-	})
+	c.JSON(http.StatusOK, verdict)
 }
 
-// ForceOverwrite overwrites the vault ignoring revision (requires confirmation)
+// bindPush decodes a push request body, streaming the blob through the
+// base64 decoder. It responds and returns false if the body is rejected.
+func (h *VaultHandler) bindPush(c *gin.Context) (req *models.VaultPushRequest, blob []byte, ok bool) {
+	body, sizeHint, err := decodedBody(c)
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		respondUnsupportedEncoding(c)
+		return nil, nil, false
+	case err != nil:
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid gzip body", nil)
+		return nil, nil, false
+	}
+
+	req, blob, err = decodeVaultPush(body, sizeHint, h.maxSize)
+	var encodingErr *blobEncodingError
+	switch {
+	case err == nil:
+		if req.Name, ok = vaultName(c, req.Name); !ok {
+			return nil, nil, false
+		}
+		return req, blob, true
+	case errors.As(err, &encodingErr):
+		apierror.RespondError(c, http.StatusBadRequest, service.PushCodeInvalidEncoding, encodingErr.Error(), gin.H{"offset": encodingErr.Offset})
+	case errors.Is(err, errVaultTooLarge), isBodyTooLarge(err):
+		h.respondVaultTooLarge(c)
+	default:
+		apierror.RespondBindError(c, err)
+	}
+	return nil, nil, false
+}
+
+// LimitBody rejects request bodies too large to carry a vault within the
+// size limit before a handler reads them: up front if the length is
+// declared, otherwise once reading passes the limit
+func (h *VaultHandler) LimitBody(c *gin.Context) {
+	if h.maxSize <= 0 {
+		c.Next()
+		return
+	}
+	limit := vaultBodyLimit(h.maxSize)
+	if c.Request.ContentLength > limit {
+		h.respondVaultTooLarge(c)
+		c.Abort()
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	c.Next()
+}
+
+// vaultBodyLimit is the largest request body that can hold a vault of
+// maxSize bytes: the base64 blob plus the other fields
+func vaultBodyLimit(maxSize int64) int64 {
+	return int64(base64.StdEncoding.EncodedLen(int(maxSize))) + pushFieldsLimit
+}
+
+// isBodyTooLarge reports whether err comes from reading past LimitBody
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func (h *VaultHandler) respondVaultTooLarge(c *gin.Context) {
+	apierror.RespondError(c, http.StatusRequestEntityTooLarge, "VAULT_TOO_LARGE", "vault too large", gin.H{"max_size_bytes": h.maxSize})
+}
+
+func respondVaultLimit(c *gin.Context) {
+	apierror.RespondError(c, http.StatusForbidden, service.PushCodeVaultLimit, "vault limit reached, delete a vault first", nil)
+}
+
+func respondDeviceReadOnly(c *gin.Context) {
+	apierror.RespondError(c, http.StatusForbidden, service.PushCodeReadOnly, "this device is read-only and may not push", nil)
+}
+
+func respondVersionUnsupported(c *gin.Context) {
+	apierror.RespondError(c, http.StatusUnprocessableEntity, service.PushCodeUnsupported, "vault version is not supported by the server yet", nil)
+}
+
+// ForceOverwrite overwrites the vault ignoring revision (requires
+// confirmation). The revision keeps counting from the replaced vault.
 func (h *VaultHandler) ForceOverwrite(c *gin.Context) {
 	var req struct {
-		VaultBlob string `json:"vault_blob" binding:"required"`
-		DeviceID  string `json:"device_id" binding:"required"`
-		Confirm   bool   `json:"confirm" binding:"required"`
+		VaultBlob    string `json:"vault_blob" binding:"required" input:"blob"`
+		DeviceID     string `json:"device_id" binding:"required" input:"token"`
+		Name         string `json:"name" input:"token"`
+		Confirm      bool   `json:"confirm" binding:"required"`
+		VaultVersion int    `json:"vault_version" binding:"omitempty,min=1"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		if isBodyTooLarge(err) {
+			h.respondVaultTooLarge(c)
+			return
+		}
+		apierror.RespondBindError(c, err)
 		return
 	}
 
 	if !req.Confirm {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "confirmation required"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "confirmation required", nil)
+		return
+	}
+	name, ok := vaultName(c, req.Name)
+	if !ok {
 		return
 	}
 
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	deviceID, _ := uuid.Parse(req.DeviceID)
 
+	// The device in the body may differ from the calling one
+	caller, _ := middleware.GetDeviceID(c)
+	err = h.vaultSync.CheckWritable(c.Request.Context(), caller)
+	if errors.Is(err, service.ErrDeviceReadOnly) {
+		respondDeviceReadOnly(c)
+		return
+	}
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to overwrite vault")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to overwrite vault", nil)
+		return
+	}
+
 	vaultBlob, err := base64.StdEncoding.DecodeString(req.VaultBlob)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vault blob encoding"})
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid vault blob encoding", nil)
+		return
+	}
+	if h.maxSize > 0 && int64(len(vaultBlob)) > h.maxSize {
+		h.respondVaultTooLarge(c)
 		return
 	}
 
-	ctx := c.Request.Context()
+	vault, err := h.vaultSync.ForceOverwrite(c.Request.Context(), userID, deviceID, name, vaultBlob, req.VaultVersion)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrForeignDevice):
+		apierror.RespondError(c, http.StatusBadRequest, "UNKNOWN_DEVICE", "device does not belong to this account", nil)
+		return
+	case errors.Is(err, service.ErrDeviceReadOnly):
+		respondDeviceReadOnly(c)
+		return
+	case errors.Is(err, service.ErrVaultLimit):
+		respondVaultLimit(c)
+		return
+	case errors.Is(err, service.ErrVaultVersionRefused):
+		apierror.RespondError(c, http.StatusUpgradeRequired, service.PushCodeMigrationNeeded, "vault version is no longer accepted, migrate the vault first", nil)
+		return
+	case errors.Is(err, service.ErrVaultVersionUnsupported):
+		respondVersionUnsupported(c)
+		return
+	default:
+		middleware.Logger(c).Error().Err(err).Msg("Failed to overwrite vault")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to overwrite vault", nil)
+		return
+	}
+	h.changes.Publish(userID, stream.Event{Vault: vault.Name, Revision: vault.Revision, DeviceID: deviceID})
 
-	// Get current revision for logging
-	currentVault, _ := h.vaultRepo.GetByUserID(ctx, userID)
-	var oldRevision *int
-	if currentVault != nil {
-		oldRevision = &currentVault.Revision
+	c.JSON(http.StatusOK, models.VaultPushResponse{
+		Status:    "overwritten",
+		Revision:  vault.Revision,
+		Timestamp: vault.UpdatedAt.Unix(),
+	})
+}
+
+// Revisions lists the replaced vaults kept in the history of the vault
+// picked by ?vault=
+func (h *VaultHandler) Revisions(c *gin.Context) {
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
 	}
 
-	// Delete and recreate
-	_ = h.vaultRepo.Delete(ctx, userID)
+	revisions, err := h.vaultRepo.History(c.Request.Context(), userID, name)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to list revisions")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list revisions", nil)
+		return
+	}
 
-	vault, err := h.vaultRepo.Create(ctx, userID, vaultBlob, &deviceID)
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+// Restore copies a revision from the history back as the new head
+// revision. The current vault stays in the history.
+func (h *VaultHandler) Restore(c *gin.Context) {
+	revision, err := strconv.Atoi(c.Param("revision"))
+	if err != nil || revision < 1 {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid revision", nil)
+		return
+	}
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to overwrite vault"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
+	deviceID, _ := middleware.GetDeviceID(c)
 
-	_ = h.syncRepo.Create(ctx, userID, &deviceID, "force_overwrite", oldRevision, &vault.Revision)
-	_ = h.deviceRepo.UpdateLastSync(ctx, deviceID)
+	vault, err := h.vaultSync.Restore(c.Request.Context(), userID, deviceID, name, revision)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrVaultRevisionNotFound):
+		apierror.RespondError(c, http.StatusNotFound, "REVISION_NOT_FOUND", "revision not in history", nil)
+		return
+	case errors.Is(err, repository.ErrVaultNotFound):
+		apierror.RespondError(c, http.StatusNotFound, "NO_VAULT", "no vault found", nil)
+		return
+	case errors.Is(err, service.ErrVaultVersionRefused):
+		apierror.RespondError(c, http.StatusUpgradeRequired, service.PushCodeMigrationNeeded, "vault version is no longer accepted, migrate the vault first", nil)
+		return
+	case errors.Is(err, service.ErrVaultVersionUnsupported):
+		respondVersionUnsupported(c)
+		return
+	default:
+		middleware.Logger(c).Error().Err(err).Msg("Failed to restore vault")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to restore vault", nil)
+		return
+	}
+	h.changes.Publish(userID, stream.Event{Vault: vault.Name, Revision: vault.Revision, DeviceID: deviceID})
 
 	c.JSON(http.StatusOK, models.VaultPushResponse{
-		Status:    "overwritten",
+		Status:    "restored",
 		Revision:  vault.Revision,
 		Timestamp: vault.UpdatedAt.Unix(),
 	})
@@ -251,21 +614,32 @@ func (h *VaultHandler) ForceOverwrite(c *gin.Context) {
 func (h *VaultHandler) History(c *gin.Context) {
 	userID, err := middleware.GetUserID(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
 		return
 	}
 
 	logs, err := h.syncRepo.GetByUserID(c.Request.Context(), userID, 50)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get history"})
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get history")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get history", nil)
 		return
 	}
 
+	// The chain fields let clients verify the entries, see
+	// models.SyncLog.ComputeHash
 	type historyEntry struct {
-		Action    string    `json:"action"`
-		DeviceID  *string   `json:"device_id,omitempty"`
-		Revision  *int      `json:"revision,omitempty"`
-		Timestamp time.Time `json:"timestamp"`
+		ID             uuid.UUID `json:"id"`
+		Vault          string    `json:"vault"`
+		Action         string    `json:"action"`
+		DeviceID       *string   `json:"device_id,omitempty"`
+		RevisionBefore *int      `json:"revision_before,omitempty"`
+		Revision       *int      `json:"revision,omitempty"`
+		VersionBefore  *int      `json:"version_before,omitempty"`
+		VersionAfter   *int      `json:"version_after,omitempty"`
+		Timestamp      time.Time `json:"timestamp"`
+		Seq            int64     `json:"seq,omitempty"`
+		PrevHash       string    `json:"prev_hash,omitempty"`
+		EntryHash      string    `json:"entry_hash,omitempty"`
 	}
 
 	entries := make([]historyEntry, len(logs))
@@ -276,12 +650,45 @@ func (h *VaultHandler) History(c *gin.Context) {
 			deviceID = &id
 		}
 		entries[i] = historyEntry{
-			Action:    log.Action,
-			DeviceID:  deviceID,
-			Revision:  log.RevisionAfter,
-			Timestamp: log.CreatedAt,
+			ID:             log.ID,
+			Vault:          log.Vault,
+			Action:         log.Action,
+			DeviceID:       deviceID,
+			RevisionBefore: log.RevisionBefore,
+			Revision:       log.RevisionAfter,
+			VersionBefore:  log.VersionBefore,
+			VersionAfter:   log.VersionAfter,
+			Timestamp:      log.CreatedAt,
+			Seq:            log.Seq,
+			PrevHash:       log.PrevHash,
+			EntryHash:      log.EntryHash,
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{"history": entries})
 }
+
+// VerifyHistory recomputes the hash chain of the sync history and reports
+// the first entry that does not verify
+func (h *VaultHandler) VerifyHistory(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	logs, err := h.syncRepo.GetChain(c.Request.Context(), userID)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get history")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get history", nil)
+		return
+	}
+	retention, err := h.syncRepo.GetRetention(c.Request.Context(), userID)
+	if err != nil {
+		middleware.Logger(c).Error().Err(err).Msg("Failed to get history retention")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get history", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, service.VerifySyncChain(logs, retention))
+}
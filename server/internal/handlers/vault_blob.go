@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// The raw blob endpoints move the vault as application/octet-stream and
+// its metadata in headers, sparing both ends the base64 step of the JSON
+// endpoints.
+const (
+	headerVaultRevision  = "X-Vault-Revision"
+	headerVaultVersion   = "X-Vault-Version"
+	headerVaultUpdatedAt = "X-Vault-Updated-At" // unix seconds
+	headerVaultSHA256    = "X-Vault-Sha256"
+	headerVaultForce     = "X-Vault-Force" // "true" accepts a lower vault version
+	headerUpdatedBy      = "X-Vault-Updated-By-Device"
+	headerDeviceID       = "X-Device-Id"
+)
+
+// PullBlob downloads the encrypted vault picked by ?vault= as raw bytes,
+// streamed from the database in chunks. The migration notice is only in
+// the status.
+func (h *VaultHandler) PullBlob(c *gin.Context) {
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+
+	stream, err := h.vaultSync.Pull(c.Request.Context(), userID, deviceID, name)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrVaultNotFound):
+		apierror.RespondError(c, http.StatusNotFound, "NO_VAULT", "no vault found", nil)
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault", nil)
+		return
+	}
+	defer stream.Close()
+
+	vault := stream.Vault
+	c.Header(headerVaultRevision, strconv.Itoa(vault.Revision))
+	c.Header(headerVaultVersion, strconv.Itoa(vault.VaultVersion))
+	c.Header(headerVaultUpdatedAt, strconv.FormatInt(vault.UpdatedAt.Unix(), 10))
+	c.Header(headerVaultSHA256, vault.Checksum)
+	if vault.UpdatedByDevice != nil {
+		c.Header(headerUpdatedBy, vault.UpdatedByDevice.String())
+	}
+	// Once the body started the status cannot change; a failed read
+	// leaves the response short of Content-Length
+	c.DataFromReader(http.StatusOK, stream.SizeBytes, "application/octet-stream", stream, nil)
+}
+
+// PushBlob is Push with the raw blob as body. The revision is in
+// X-Vault-Revision, the device in X-Device-Id and the optional vault
+// version and checksum in X-Vault-Version and X-Vault-Sha256. X-Vault-Force
+// is the force field of Push. The vault is picked by ?vault=. The size
+// limit applies while reading.
+func (h *VaultHandler) PushBlob(c *gin.Context) {
+	req, err := blobPushRequest(c.Request.Header)
+	if err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+	var ok bool
+	if req.Name, ok = vaultName(c, ""); !ok {
+		return
+	}
+
+	blob, err := readVaultBlob(c.Request.Body, c.Request.ContentLength, h.maxSize)
+	switch {
+	case err == nil:
+	case errors.Is(err, errVaultTooLarge), isBodyTooLarge(err):
+		h.respondVaultTooLarge(c)
+		return
+	default:
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "failed to read vault blob", nil)
+		return
+	}
+	if len(blob) == 0 {
+		apierror.RespondInvalidInput(c, input.Errors{{Field: "vault_blob", Code: input.CodeRequired, Message: "is required"}})
+		return
+	}
+
+	h.push(c, req, blob)
+}
+
+// blobPushRequest reads the push fields of a raw blob push from its
+// headers. The error is input.Errors.
+func blobPushRequest(header http.Header) (*models.VaultPushRequest, error) {
+	var req models.VaultPushRequest
+	var errs input.Errors
+
+	revision := header.Get(headerVaultRevision)
+	if revision == "" {
+		errs = append(errs, input.FieldError{Field: headerVaultRevision, Code: input.CodeRequired, Message: "is required"})
+	} else if n, err := strconv.Atoi(revision); err != nil || n < 0 {
+		errs = append(errs, input.FieldError{Field: headerVaultRevision, Code: input.CodeInvalid, Message: "must be a revision number"})
+	} else {
+		req.Revision = n
+	}
+
+	if version := header.Get(headerVaultVersion); version != "" {
+		if n, err := strconv.Atoi(version); err != nil || n < 1 {
+			errs = append(errs, input.FieldError{Field: headerVaultVersion, Code: input.CodeInvalid, Message: "must be a positive number"})
+		} else {
+			req.VaultVersion = n
+		}
+	}
+
+	if force := header.Get(headerVaultForce); force != "" {
+		if b, err := strconv.ParseBool(force); err != nil {
+			errs = append(errs, input.FieldError{Field: headerVaultForce, Code: input.CodeInvalid, Message: "must be true or false"})
+		} else {
+			req.Force = b
+		}
+	}
+
+	checksum, err := input.Clean(headerVaultSHA256, input.KindToken, header.Get(headerVaultSHA256))
+	var fieldErr *input.FieldError
+	if errors.As(err, &fieldErr) {
+		errs = append(errs, *fieldErr)
+	}
+	req.SHA256 = checksum
+
+	deviceID, err := input.Clean(headerDeviceID, input.KindToken, header.Get(headerDeviceID))
+	switch {
+	case errors.As(err, &fieldErr):
+		errs = append(errs, *fieldErr)
+	case deviceID == "":
+		errs = append(errs, input.FieldError{Field: headerDeviceID, Code: input.CodeRequired, Message: "is required"})
+	}
+	req.DeviceID = deviceID
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &req, nil
+}
+
+// readVaultBlob reads a raw blob of at most maxSize bytes (0 = no limit)
+// and fails with errVaultTooLarge as soon as it grows past it. sizeHint
+// is the body length if known.
+func readVaultBlob(r io.Reader, sizeHint, maxSize int64) ([]byte, error) {
+	if maxSize > 0 {
+		if sizeHint > maxSize {
+			return nil, errVaultTooLarge
+		}
+		r = io.LimitReader(r, maxSize+1)
+	}
+	var buf bytes.Buffer
+	if sizeHint > 0 && maxSize > 0 {
+		buf.Grow(int(sizeHint))
+	}
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(buf.Len()) > maxSize {
+		return nil, errVaultTooLarge
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
+)
+
+// vaultKey identifies a named vault of a user in memVaults
+type vaultKey struct {
+	userID uuid.UUID
+	name   string
+}
+
+// memVaults stores the named vaults of each user without history
+type memVaults struct {
+	mu      sync.Mutex
+	vaults  map[vaultKey]*models.EncryptedVault
+	deleted map[vaultKey]*models.EncryptedVault // soft-deleted, replaced by Create
+	limit   int                                 // vaults per user; 0 = unlimited
+}
+
+func (m *memVaults) GetByUserID(_ context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.vaults[vaultKey{userID, name}]
+	if !ok {
+		return nil, repository.ErrVaultNotFound
+	}
+	cp := *v
+	return &cp, nil
+}
+
+func (m *memVaults) OpenBlob(ctx context.Context, userID uuid.UUID, name string) (*models.VaultBlobStream, error) {
+	v, err := m.GetByUserID(ctx, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	stream := &models.VaultBlobStream{Vault: *v, SizeBytes: int64(len(v.VaultBlob)), ReadCloser: io.NopCloser(bytes.NewReader(v.VaultBlob))}
+	stream.Vault.VaultBlob = nil
+	return stream, nil
+}
+
+func (m *memVaults) GetRevision(ctx context.Context, userID uuid.UUID, name string) (int, error) {
+	v, err := m.GetByUserID(ctx, userID, name)
+	if err != nil {
+		return 0, err
+	}
+	return v.Revision, nil
+}
+
+// createAllowed counts the user's other vaults. The caller holds m.mu.
+func (m *memVaults) createAllowed(userID uuid.UUID, name string) bool {
+	others := 0
+	for key := range m.vaults {
+		if key.userID == userID && key.name != name {
+			others++
+		}
+	}
+	return m.limit == 0 || others < m.limit
+}
+
+func (m *memVaults) CreateAllowed(_ context.Context, userID uuid.UUID, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.createAllowed(userID, name), nil
+}
+
+func (m *memVaults) Create(ctx context.Context, userID uuid.UUID, name string, blob []byte, vaultVersion int, deviceID *uuid.UUID, _ repository.VaultWriteLog) (*models.EncryptedVault, error) {
+	key := vaultKey{userID, name}
+	m.mu.Lock()
+	if _, ok := m.vaults[key]; ok {
+		m.mu.Unlock()
+		return nil, repository.ErrVaultConflict
+	}
+	if !m.createAllowed(userID, name) {
+		m.mu.Unlock()
+		return nil, repository.ErrVaultLimit
+	}
+	delete(m.deleted, key)
+	m.vaults[key] = &models.EncryptedVault{UserID: userID, Name: name, VaultBlob: blob, Revision: 1, VaultVersion: vaultVersion, UpdatedByDevice: deviceID, Checksum: models.BlobChecksum(blob), UpdatedAt: time.Now()}
+	m.mu.Unlock()
+	return m.GetByUserID(ctx, userID, name)
+}
+
+func (m *memVaults) UpdateWithRevisionCheck(ctx context.Context, userID uuid.UUID, name string, blob []byte, expected, vaultVersion int, deviceID *uuid.UUID, _ repository.VaultWriteLog) (*models.EncryptedVault, error) {
+	m.mu.Lock()
+	v, ok := m.vaults[vaultKey{userID, name}]
+	if !ok || v.Revision != expected {
+		m.mu.Unlock()
+		return nil, repository.ErrVaultConflict
+	}
+	v.VaultBlob, v.Revision, v.VaultVersion, v.UpdatedByDevice = blob, v.Revision+1, vaultVersion, deviceID
+	v.Checksum = models.BlobChecksum(blob)
+	m.mu.Unlock()
+	return m.GetByUserID(ctx, userID, name)
+}
+
+func (m *memVaults) Overwrite(ctx context.Context, userID uuid.UUID, name string, blob []byte, vaultVersion int, deviceID *uuid.UUID, _ repository.VaultWriteLog) (*models.EncryptedVault, bool, error) {
+	key := vaultKey{userID, name}
+	m.mu.Lock()
+	v, replaced := m.vaults[key]
+	if !replaced {
+		if !m.createAllowed(userID, name) {
+			m.mu.Unlock()
+			return nil, false, repository.ErrVaultLimit
+		}
+		v = &models.EncryptedVault{UserID: userID, Name: name}
+		m.vaults[key] = v
+	}
+	v.VaultBlob, v.Revision, v.VaultVersion, v.UpdatedByDevice = blob, v.Revision+1, vaultVersion, deviceID
+	v.Checksum, v.UpdatedAt = models.BlobChecksum(blob), time.Now()
+	m.mu.Unlock()
+	vault, err := m.GetByUserID(ctx, userID, name)
+	return vault, replaced, err
+}
+
+func (m *memVaults) HistoryRevision(context.Context, uuid.UUID, string, int) (*models.VaultRevision, error) {
+	return nil, repository.ErrVaultRevisionNotFound
+}
+
+func (m *memVaults) Restore(context.Context, uuid.UUID, string, int, *uuid.UUID, repository.VaultWriteLog) (*models.EncryptedVault, error) {
+	return nil, repository.ErrVaultRevisionNotFound
+}
+
+func (m *memVaults) SoftDelete(_ context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error) {
+	key := vaultKey{userID, name}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.vaults[key]
+	if !ok {
+		return nil, repository.ErrVaultNotFound
+	}
+	now := time.Now()
+	v.DeletedAt = &now
+	m.deleted[key] = v
+	delete(m.vaults, key)
+	cp := *v
+	return &cp, nil
+}
+
+func (m *memVaults) Undelete(ctx context.Context, userID uuid.UUID, name string, deletedAfter time.Time) (*models.EncryptedVault, error) {
+	key := vaultKey{userID, name}
+	m.mu.Lock()
+	v, ok := m.deleted[key]
+	if !ok || !v.DeletedAt.After(deletedAfter) {
+		m.mu.Unlock()
+		return nil, repository.ErrVaultNotFound
+	}
+	if !m.createAllowed(userID, name) {
+		m.mu.Unlock()
+		return nil, repository.ErrVaultLimit
+	}
+	v.DeletedAt = nil
+	m.vaults[key] = v
+	delete(m.deleted, key)
+	m.mu.Unlock()
+	return m.GetByUserID(ctx, userID, name)
+}
+
+func (m *memVaults) PurgeDeleted(context.Context, time.Time) (int64, error) { return 0, nil }
+
+// nopSyncStores drops sync log entries and device sync state and knows
+// no devices
+type nopSyncStores struct{}
+
+func (nopSyncStores) Create(context.Context, uuid.UUID, *uuid.UUID, string, string, *int, *int) error {
+	return nil
+}
+
+func (nopSyncStores) GetByID(context.Context, uuid.UUID) (*models.Device, error) {
+	return nil, repository.ErrDeviceNotFound
+}
+
+func (nopSyncStores) UpdateLastSync(context.Context, uuid.UUID, int) error { return nil }
+
+func (nopSyncStores) UpdateActivity(context.Context, uuid.UUID, string, string) error { return nil }
+
+// testDeviceHeader picks the calling device in newVaultTestRouter
+const testDeviceHeader = "X-Test-Device"
+
+// testVaultLimit is the vault limit per user of newVaultTestRouter
+const testVaultLimit = 3
+
+// testMaxVersion is the highest vault version newVaultTestRouter accepts
+const testMaxVersion = 5
+
+// testChunkQuota is the pending chunk quota of newVaultTestRouter
+const testChunkQuota = 8 * service.DeltaMinChunkSize
+
+// newVaultTestRouter serves the vault endpoints of one user from memory.
+// Requests come from the device in testDeviceHeader, or a fixed one.
+func newVaultTestRouter(maxSize int64) (*gin.Engine, *VaultHandler, uuid.UUID) {
+	gin.SetMode(gin.TestMode)
+	migration := service.NewVaultMigration(memSettings{}, nil)
+	vaults := &memVaults{vaults: map[vaultKey]*models.EncryptedVault{}, deleted: map[vaultKey]*models.EncryptedVault{}, limit: testVaultLimit}
+	h := NewVaultHandler(nil, nil, nil,
+		service.NewVaultSync(vaults, nopSyncStores{}, nopSyncStores{}, migration, testMaxVersion),
+		service.NewVaultTrash(vaults, nopSyncStores{}, time.Hour), service.NewPushReceipts(&memPushReceipts{}),
+		service.NewVaultDelta(&memChunks{chunks: map[string][]byte{}, maxPending: testChunkQuota}, vaults), nil, migration, maxSize,
+		stream.NewHub(0, 0), stream.NewBroker(), time.Second)
+	userID, deviceID := uuid.New(), uuid.New()
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("device_id", deviceID)
+		if id, err := uuid.Parse(c.GetHeader(testDeviceHeader)); err == nil {
+			c.Set("device_id", id)
+		}
+	}, h.LimitBody)
+	r.GET("/vault/pull", h.Pull)
+	r.POST("/vault/push", h.Push)
+	r.GET("/vault/watch", h.Watch)
+	r.GET("/vault/blob", h.PullBlob)
+	r.PUT("/vault/blob", h.PushBlob)
+	r.DELETE("/vault", h.Delete)
+	r.POST("/vault/undelete", h.Undelete)
+	r.POST("/vault/delta/manifest", h.DeltaManifest)
+	r.POST("/vault/delta/chunks", h.DeltaChunks)
+	r.POST("/vault/delta/commit", h.DeltaCommit)
+	return r, h, userID
+}
+
+func putVaultBlob(r http.Handler, blob []byte, revision string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/vault/blob", bytes.NewReader(blob))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(headerVaultRevision, revision)
+	req.Header.Set(headerDeviceID, "dev-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestVaultBlob_RoundTrip(t *testing.T) {
+	r, _, _ := newVaultTestRouter(1 << 20)
+	blob := make([]byte, 256<<10)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	if w := get("/vault/blob"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NO_VAULT") {
+		t.Fatalf("pull without vault: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := putVaultBlob(r, blob, "0"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"created"`) {
+		t.Fatalf("raw push: %d %s", w.Code, w.Body.String())
+	}
+	w := get("/vault/blob")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), blob) {
+		t.Fatalf("raw pull: %d, %d bytes, want the pushed %d", w.Code, w.Body.Len(), len(blob))
+	}
+	if w.Header().Get(headerVaultRevision) != "1" || w.Header().Get("Content-Length") != strconv.Itoa(len(blob)) || w.Header().Get(headerVaultSHA256) != models.BlobChecksum(blob) {
+		t.Errorf("raw pull headers = %v", w.Header())
+	}
+
+	// The JSON endpoints see the same bytes
+	var pulled models.VaultPullResponse
+	if w := get("/vault/pull"); w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &pulled) != nil {
+		t.Fatalf("json pull: %d %s", w.Code, w.Body.String())
+	}
+	if got, _ := base64.StdEncoding.DecodeString(pulled.VaultBlob); !bytes.Equal(got, blob) || pulled.SizeBytes != len(blob) || pulled.SHA256 != models.BlobChecksum(blob) {
+		t.Fatalf("json pull returned %d bytes, want the pushed %d", len(got), len(blob))
+	}
+
+	blob[0] ^= 0xff
+	jsonPush := func(checksum string) *httptest.ResponseRecorder {
+		body := `{"revision": 1, "vault_blob": "` + base64.StdEncoding.EncodeToString(blob) + `", "device_id": "dev-1", "sha256": "` + checksum + `"}`
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/vault/push", strings.NewReader(body)))
+		return w
+	}
+	// A blob damaged on the way does not match the checksum of the client
+	if w := jsonPush(pulled.SHA256); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), service.PushCodeChecksum) {
+		t.Fatalf("json push with a stale checksum: %d %s", w.Code, w.Body.String())
+	}
+	if w := jsonPush(models.BlobChecksum(blob)); w.Code != http.StatusOK {
+		t.Fatalf("json push: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/vault/blob"); !bytes.Equal(w.Body.Bytes(), blob) || w.Header().Get(headerVaultRevision) != "2" {
+		t.Fatalf("raw pull after json push: revision %s, %d bytes", w.Header().Get(headerVaultRevision), w.Body.Len())
+	}
+}
+
+func TestVaultBlob_PushRejections(t *testing.T) {
+	r, _, _ := newVaultTestRouter(1 << 10)
+	if w := putVaultBlob(r, []byte("first"), "0"); w.Code != http.StatusOK {
+		t.Fatalf("first push: %d %s", w.Code, w.Body.String())
+	}
+
+	w := putVaultBlob(r, []byte("stale"), "0")
+	var conflict models.VaultConflictResponse
+	if w.Code != http.StatusConflict || json.Unmarshal(w.Body.Bytes(), &conflict) != nil || conflict.ServerRevision != 1 || conflict.Code != service.PushCodeConflict {
+		t.Errorf("stale push: %d %s", w.Code, w.Body.String())
+	}
+	if w := putVaultBlob(r, make([]byte, 1<<10+1), "1"); w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "VAULT_TOO_LARGE") {
+		t.Errorf("oversized push: %d %s", w.Code, w.Body.String())
+	}
+	// Without a length the limit applies while reading
+	req := httptest.NewRequest(http.MethodPut, "/vault/blob", struct{ io.Reader }{bytes.NewReader(make([]byte, 1<<10+1))})
+	req.Header.Set(headerVaultRevision, "1")
+	req.Header.Set(headerDeviceID, "dev-1")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized push of unknown length: %d %s", w.Code, w.Body.String())
+	}
+	for _, revision := range []string{"", "-1", "one"} {
+		if w := putVaultBlob(r, []byte("next"), revision); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), headerVaultRevision) {
+			t.Errorf("revision %q: %d %s", revision, w.Code, w.Body.String())
+		}
+	}
+	if w := putVaultBlob(r, nil, "1"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "vault_blob") {
+		t.Errorf("empty push: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestVaultBlob_VaultVersion(t *testing.T) {
+	r, _, _ := newVaultTestRouter(1 << 10)
+	push := func(revision, version, force string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/vault/blob", bytes.NewReader([]byte("blob")))
+		req.Header.Set(headerVaultRevision, revision)
+		req.Header.Set(headerVaultVersion, version)
+		req.Header.Set(headerDeviceID, "dev-1")
+		if force != "" {
+			req.Header.Set(headerVaultForce, force)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := push("0", "2", ""); w.Code != http.StatusOK {
+		t.Fatalf("first push: %d %s", w.Code, w.Body.String())
+	}
+	if w := push("1", "1", ""); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), service.PushCodeDowngrade) {
+		t.Errorf("downgrade: %d %s", w.Code, w.Body.String())
+	}
+	if w := push("1", "6", ""); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), service.PushCodeUnsupported) {
+		t.Errorf("version above the maximum: %d %s", w.Code, w.Body.String())
+	}
+	if w := push("1", "1", "yes please"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), headerVaultForce) {
+		t.Errorf("invalid force header: %d %s", w.Code, w.Body.String())
+	}
+	if w := push("1", "1", "true"); w.Code != http.StatusOK {
+		t.Errorf("forced downgrade: %d %s", w.Code, w.Body.String())
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vault/blob", nil))
+	if got := w.Header().Get(headerVaultVersion); got != "1" {
+		t.Errorf("pulled vault version %q, want 1", got)
+	}
+}
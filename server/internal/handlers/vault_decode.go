@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+const (
+	// pushFieldsLimit caps the JSON of a push request besides the blob
+	pushFieldsLimit = 64 << 10
+	// blobBatchSize is how many base64 characters are decoded at once. It
+	// is a multiple of 4 so only the last batch can end in padding.
+	blobBatchSize = 16 << 10
+)
+
+var (
+	errVaultTooLarge      = errors.New("vault too large")
+	errPushFieldsTooLarge = errors.New("push request fields too large")
+	errDuplicateBlob      = errors.New("duplicate vault_blob")
+)
+
+// blobEncodingError reports invalid base64 in vault_blob. Offset counts
+// base64 characters from the start of the blob.
+type blobEncodingError struct {
+	Offset int64
+}
+
+func (e *blobEncodingError) Error() string {
+	return fmt.Sprintf("invalid vault blob encoding at offset %d", e.Offset)
+}
+
+// decodeVaultPush reads a push request from r. The blob is base64-decoded
+// while it streams in, so neither the encoded string nor a second copy of
+// the body is held in memory, and a blob over maxSize bytes is rejected
+// as soon as it grows past it. maxSize 0 means no limit; sizeHint is the
+// body length if known and sizes the blob buffer up front. The returned request has an empty VaultBlob.
+func decodeVaultPush(r io.Reader, sizeHint, maxSize int64) (*models.VaultPushRequest, []byte, error) {
+	d := &pushDecoder{r: bufio.NewReaderSize(r, 32<<10), maxSize: maxSize}
+	if sizeHint > 0 && maxSize > 0 {
+		// Only trust the hint as far as the limit, it comes from the client
+		d.blob = make([]byte, 0, min(int64(base64.StdEncoding.DecodedLen(int(sizeHint))), maxSize+1))
+	}
+	if err := d.scan(); err != nil {
+		return nil, nil, err
+	}
+
+	var req models.VaultPushRequest
+	if err := json.Unmarshal(d.fields.Bytes(), &req); err != nil {
+		return nil, nil, err
+	}
+	// An empty blob is validated like a missing one and fails `required`
+	if err := validatePushFields(&req, d.found && d.offset > 0); err != nil {
+		return nil, nil, err
+	}
+	return &req, d.blob, nil
+}
+
+// validatePushFields runs the binding checks gin would run. The blob was
+// validated while decoding, so its emptied field is skipped if it was sent.
+func validatePushFields(req *models.VaultPushRequest, blobSent bool) error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !blobSent || !ok {
+		return binding.Validator.ValidateStruct(req)
+	}
+	if err := input.Struct(req); err != nil {
+		return err
+	}
+	return v.StructExcept(req, "VaultBlob")
+}
+
+// pushDecoder splits a push request into its small fields, copied to
+// fields with vault_blob emptied, and the decoded blob
+type pushDecoder struct {
+	r       *bufio.Reader
+	maxSize int64
+
+	fields bytes.Buffer
+	blob   []byte
+	found  bool
+
+	batch  []byte // base64 characters not decoded yet
+	offset int64  // base64 characters read so far
+	padded bool   // a decoded batch ended in padding
+}
+
+// scan walks the top-level JSON object. Only strings and nesting are
+// tracked; everything else is left to json.Unmarshal on the fields.
+func (d *pushDecoder) scan() error {
+	const (
+		stateNone = iota
+		stateBlobColon
+		stateBlobValue
+	)
+	depth, state := 0, stateNone
+	expectKey := false
+
+	for {
+		b, err := d.r.ReadByte()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+		case state == stateBlobColon && b == ':':
+			state = stateBlobValue
+		case state == stateBlobValue && b == '"':
+			state = stateNone
+			if d.found {
+				return errDuplicateBlob
+			}
+			d.found = true
+			if err := d.readBlob(); err != nil {
+				return err
+			}
+			if err := d.write('"', '"'); err != nil {
+				return err
+			}
+			continue
+		default:
+			state = stateNone
+		}
+
+		if err := d.write(b); err != nil {
+			return err
+		}
+		switch b {
+		case '"':
+			key, err := d.copyString(depth == 1 && expectKey)
+			if err != nil {
+				return err
+			}
+			if depth == 1 && expectKey {
+				expectKey = false
+				if key == "vault_blob" {
+					state = stateBlobColon
+				}
+			}
+		case '{', '[':
+			depth++
+			expectKey = b == '{' && depth == 1
+		case '}', ']':
+			depth--
+			if depth <= 0 {
+				return nil
+			}
+		case ',':
+			expectKey = depth == 1
+		}
+	}
+}
+
+// write appends to the fields, which must stay small
+func (d *pushDecoder) write(b ...byte) error {
+	if d.fields.Len()+len(b) > pushFieldsLimit {
+		return errPushFieldsTooLarge
+	}
+	d.fields.Write(b)
+	return nil
+}
+
+// copyString copies a string up to its closing quote to the fields and
+// returns it if it may be a key
+func (d *pushDecoder) copyString(isKey bool) (string, error) {
+	var key []byte
+	escaped := false
+	for {
+		b, err := d.r.ReadByte()
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := d.write(b); err != nil {
+			return "", err
+		}
+		if !escaped && b == '"' {
+			return string(key), nil
+		}
+		escaped = !escaped && b == '\\'
+		if isKey && len(key) < 32 {
+			key = append(key, b)
+		}
+	}
+}
+
+// readBlob decodes the blob string up to its closing quote. The only JSON
+// escape valid in base64 is \/.
+func (d *pushDecoder) readBlob() error {
+	for {
+		chunk, err := d.r.ReadSlice('"')
+		end := err == nil
+		switch {
+		case end:
+			chunk = chunk[:len(chunk)-1]
+		case err == io.EOF:
+			return io.ErrUnexpectedEOF
+		case !errors.Is(err, bufio.ErrBufferFull):
+			return err
+		}
+
+		for len(chunk) > 0 {
+			i := bytes.IndexByte(chunk, '\\')
+			if i < 0 {
+				if err := d.add(chunk); err != nil {
+					return err
+				}
+				break
+			}
+			if err := d.add(chunk[:i]); err != nil {
+				return err
+			}
+
+			var esc byte
+			switch {
+			case i+1 < len(chunk):
+				esc, chunk = chunk[i+1], chunk[i+2:]
+			case end:
+				esc, chunk = '"', nil // the closing quote was escaped
+			default:
+				if esc, err = d.r.ReadByte(); err != nil {
+					return io.ErrUnexpectedEOF
+				}
+				chunk = nil
+			}
+			if esc != '/' {
+				return &blobEncodingError{Offset: d.offset}
+			}
+			if err := d.add([]byte{'/'}); err != nil {
+				return err
+			}
+		}
+
+		if end {
+			return d.flush()
+		}
+	}
+}
+
+// add queues base64 characters and decodes every full batch
+func (d *pushDecoder) add(p []byte) error {
+	if i := bytes.IndexAny(p, "\r\n"); i >= 0 {
+		// Decode would skip these, but they are not valid in a JSON string
+		return &blobEncodingError{Offset: d.offset + int64(i)}
+	}
+	for len(p) > 0 {
+		if d.batch == nil {
+			d.batch = make([]byte, 0, blobBatchSize)
+		}
+		n := min(len(p), blobBatchSize-len(d.batch))
+		d.batch = append(d.batch, p[:n]...)
+		d.offset += int64(n)
+		p = p[n:]
+		if len(d.batch) == blobBatchSize {
+			if err := d.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush decodes the queued characters
+func (d *pushDecoder) flush() error {
+	if len(d.batch) == 0 {
+		return nil
+	}
+	start := d.offset - int64(len(d.batch))
+	if d.padded {
+		return &blobEncodingError{Offset: start}
+	}
+
+	want := base64.StdEncoding.DecodedLen(len(d.batch))
+	d.blob = slices.Grow(d.blob, want)
+	n, err := base64.StdEncoding.Decode(d.blob[len(d.blob):len(d.blob)+want], d.batch)
+	if err != nil {
+		var corrupt base64.CorruptInputError
+		if errors.As(err, &corrupt) {
+			return &blobEncodingError{Offset: start + int64(corrupt)}
+		}
+		return err
+	}
+
+	d.blob = d.blob[:len(d.blob)+n]
+	d.padded = n < want
+	d.batch = d.batch[:0]
+	if d.maxSize > 0 && int64(len(d.blob)) > d.maxSize {
+		return errVaultTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func pushBody(blob string) string {
+	return `{"revision": 4, "vault_blob": "` + blob + `", "device_id": "dev-1"}`
+}
+
+func TestDecodeVaultPush(t *testing.T) {
+	raw := make([]byte, 3*blobBatchSize+7) // several batches plus a padded tail
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(raw)
+	escaped := strings.ReplaceAll(encoded, "/", `\/`)
+
+	for name, body := range map[string]string{
+		"plain":         pushBody(encoded),
+		"escaped slash": pushBody(escaped),
+		"blob first":    `{"vault_blob":"` + encoded + `","revision":4,"device_id":"dev-1"}`,
+		"nested blob":   `{"meta": {"vault_blob": "x"}, "vault_blob": "` + encoded + `", "revision": 4, "device_id": "dev-1"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, blob, err := decodeVaultPush(strings.NewReader(body), int64(len(body)), 0)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !bytes.Equal(blob, raw) {
+				t.Errorf("blob differs: got %d bytes, want %d", len(blob), len(raw))
+			}
+			if req.Revision != 4 || req.DeviceID != "dev-1" || req.VaultBlob != "" {
+				t.Errorf("fields = %+v", req)
+			}
+		})
+	}
+}
+
+func TestDecodeVaultPush_InvalidBase64(t *testing.T) {
+	valid := strings.Repeat("QUFB", blobBatchSize/4) // exactly one batch
+
+	tests := []struct {
+		name       string
+		blob       string
+		wantOffset int64
+	}{
+		{"bad character", "QUFBQU*B", 6},
+		{"bad character in later batch", valid + "QU!B", int64(len(valid)) + 2},
+		{"truncated", "QUFBQU", 4},
+		{"data after padding", "QQ==QUFB", 4},
+		{"data after padded batch", valid[:len(valid)-4] + "QQ==" + "QUFB", int64(len(valid))},
+		{"escaped newline", `QUFB\nQUFB`, 4},
+		{"other escape", `QUFB\u0041`, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := pushBody(tt.blob)
+			_, _, err := decodeVaultPush(strings.NewReader(body), int64(len(body)), 0)
+			var encodingErr *blobEncodingError
+			if !errors.As(err, &encodingErr) {
+				t.Fatalf("err = %v, want encoding error", err)
+			}
+			if encodingErr.Offset != tt.wantOffset {
+				t.Errorf("offset = %d, want %d", encodingErr.Offset, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestDecodeVaultPush_MalformedRequests(t *testing.T) {
+	for name, body := range map[string]string{
+		"unterminated blob": `{"revision": 1, "vault_blob": "QUFB`,
+		"missing blob":      `{"revision": 1, "device_id": "dev-1"}`,
+		"empty blob":        pushBody(""),
+		"duplicate blob":    `{"vault_blob": "QUFB", "vault_blob": "QUFB", "device_id": "dev-1"}`,
+		"missing device":    `{"revision": 1, "vault_blob": "QUFB"}`,
+		"not an object":     `"QUFB"`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeVaultPush(strings.NewReader(body), -1, 0); err == nil {
+				t.Error("request accepted")
+			}
+		})
+	}
+}
+
+func TestDecodeVaultPush_SizeLimitInStream(t *testing.T) {
+	const maxSize = 64 << 10
+	encoded := strings.Repeat("QUFB", 1<<20) // 3 MiB decoded
+	body := &countingReader{r: strings.NewReader(pushBody(encoded))}
+
+	_, blob, err := decodeVaultPush(body, -1, maxSize)
+	if !errors.Is(err, errVaultTooLarge) {
+		t.Fatalf("err = %v, want errVaultTooLarge", err)
+	}
+	if blob != nil {
+		t.Error("oversized blob returned")
+	}
+	if body.n > 2*maxSize {
+		t.Errorf("read %d bytes of the body before rejecting it", body.n)
+	}
+
+	// Exactly at the limit is fine
+	exact := base64.StdEncoding.EncodeToString(make([]byte, maxSize))
+	if _, blob, err := decodeVaultPush(strings.NewReader(pushBody(exact)), -1, maxSize); err != nil || len(blob) != maxSize {
+		t.Errorf("blob at limit: %d bytes, %v", len(blob), err)
+	}
+}
+
+func TestVaultPush_RejectsBlobEarly(t *testing.T) {
+	h := &VaultHandler{maxSize: 16}
+	push := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		h.Push(c)
+		return w.Code, w.Body.String()
+	}
+
+	if code, body := push(pushBody("QUFB$UFB")); code != http.StatusBadRequest || !strings.Contains(body, `"details":{"offset":4}`) {
+		t.Errorf("invalid base64: %d %s", code, body)
+	}
+	big := base64.StdEncoding.EncodeToString(make([]byte, 17))
+	if code, body := push(pushBody(big)); code != http.StatusRequestEntityTooLarge || !strings.Contains(body, "VAULT_TOO_LARGE") {
+		t.Errorf("oversized vault: %d %s", code, body)
+	}
+}
+
+func TestVaultLimitBody(t *testing.T) {
+	h := &VaultHandler{maxSize: 16}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", uuid.New()) }, h.LimitBody)
+	r.POST("/push", h.Push)
+	r.POST("/force-overwrite", h.ForceOverwrite)
+	send := func(path string, body io.Reader) (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, body))
+		return w.Code, w.Body.String()
+	}
+
+	// Rejected from the declared length, before the handler runs
+	huge := base64.StdEncoding.EncodeToString(make([]byte, 128<<10))
+	if code, body := send("/push", strings.NewReader(pushBody(huge))); code != http.StatusRequestEntityTooLarge || !strings.Contains(body, "VAULT_TOO_LARGE") {
+		t.Errorf("declared length: %d %s", code, body)
+	}
+	// Without a length binding fails once reading passes the limit
+	overwrite := func(blob string) string {
+		return `{"vault_blob":"` + blob + `","device_id":"` + uuid.NewString() + `","confirm":true}`
+	}
+	if code, body := send("/force-overwrite", struct{ io.Reader }{strings.NewReader(overwrite(huge))}); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body of unknown length: %d %s", code, body)
+	}
+
+	big := base64.StdEncoding.EncodeToString(make([]byte, 17))
+	if code, body := send("/force-overwrite", strings.NewReader(overwrite(big))); code != http.StatusRequestEntityTooLarge || !strings.Contains(body, "VAULT_TOO_LARGE") {
+		t.Errorf("oversized force overwrite: %d %s", code, body)
+	}
+}
+
+func benchmarkPushBody(b *testing.B) []byte {
+	raw := make([]byte, 8<<20)
+	if _, err := rand.Read(raw); err != nil {
+		b.Fatal(err)
+	}
+	return []byte(pushBody(base64.StdEncoding.EncodeToString(raw)))
+}
+
+func BenchmarkDecodeVaultPush(b *testing.B) {
+	body := benchmarkPushBody(b)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeVaultPush(bytes.NewReader(body), int64(len(body)), 16<<20); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkBindAndDecodeVaultPush is the path decodeVaultPush replaced
+func BenchmarkBindAndDecodeVaultPush(b *testing.B) {
+	body := benchmarkPushBody(b)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		var req models.VaultPushRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := base64.StdEncoding.DecodeString(req.VaultBlob); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// Delta pushes send only the chunks of a blob the server does not have:
+// the client posts the manifest of chunk hashes, uploads the missing
+// chunks and commits the manifest, which is then pushed like a whole
+// blob. See service.VaultDelta.
+
+// DeltaManifest reports which chunks of a manifest have to be uploaded
+// before it can be committed
+func (h *VaultHandler) DeltaManifest(c *gin.Context) {
+	var req models.VaultDeltaManifest
+	if !h.bindDelta(c, &req) {
+		return
+	}
+	name, ok := vaultName(c, req.Name)
+	if !ok || !h.checkManifest(c, &req) {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	missing, err := h.delta.Missing(c.Request.Context(), userID, name, req.ChunkSize, req.Chunks)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to check chunks", nil)
+		return
+	}
+	c.JSON(http.StatusOK, models.VaultDeltaManifestResponse{Missing: missing})
+}
+
+// DeltaChunks uploads chunks of a delta push. They are kept for an hour
+// if no commit uses them. Chunks past the user's pending quota are
+// refused with 413.
+func (h *VaultHandler) DeltaChunks(c *gin.Context) {
+	var req models.VaultDeltaChunksRequest
+	if !h.bindDelta(c, &req) {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	chunks := make(map[string][]byte, len(req.Chunks))
+	for i, chunk := range req.Chunks {
+		if len(chunk.Data) > service.DeltaMaxChunkSize {
+			apierror.RespondInvalidInput(c, input.Errors{{Field: "chunks[" + strconv.Itoa(i) + "].data", Code: input.CodeTooLong, Message: "is larger than the largest chunk size", Max: service.DeltaMaxChunkSize}})
+			return
+		}
+		chunks[strings.ToLower(chunk.SHA256)] = chunk.Data
+	}
+
+	err = h.delta.Upload(c.Request.Context(), userID, chunks)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrChunkHash):
+		apierror.RespondError(c, http.StatusBadRequest, service.PushCodeChecksum, err.Error(), nil)
+		return
+	case errors.Is(err, service.ErrChunkQuota):
+		apierror.RespondError(c, http.StatusRequestEntityTooLarge, "CHUNK_QUOTA_EXCEEDED", "too many pending chunks, commit or wait for them to expire", gin.H{"max_pending_bytes": h.delta.MaxPending()})
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to store chunks", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"stored": len(chunks)})
+}
+
+// DeltaCommit assembles the blob of a manifest from the stored vault and
+// the uploaded chunks and pushes it with the usual revision check. The
+// uploaded chunks are released once the push succeeded.
+func (h *VaultHandler) DeltaCommit(c *gin.Context) {
+	var req models.VaultDeltaCommitRequest
+	if !h.bindDelta(c, &req) {
+		return
+	}
+	name, ok := vaultName(c, req.Name)
+	if !ok || !h.checkManifest(c, &req.VaultDeltaManifest) {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	blob, err := h.delta.Assemble(c.Request.Context(), userID, name, req.ChunkSize, req.Chunks)
+	var missing *service.MissingChunksError
+	switch {
+	case err == nil:
+	case errors.As(err, &missing):
+		apierror.RespondError(c, http.StatusUnprocessableEntity, "CHUNKS_MISSING", "chunks missing, upload them first", gin.H{"missing": missing.Hashes})
+		return
+	case errors.Is(err, service.ErrChunkLayout):
+		apierror.RespondInvalidInput(c, input.Errors{{Field: "chunks", Code: input.CodeInvalid, Message: "all chunks but the last must be chunk_size bytes"}})
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to assemble vault", nil)
+		return
+	}
+	if h.maxSize > 0 && int64(len(blob)) > h.maxSize {
+		h.respondVaultTooLarge(c)
+		return
+	}
+
+	h.push(c, &models.VaultPushRequest{
+		Revision:       req.Revision,
+		DeviceID:       req.DeviceID,
+		Name:           name,
+		IdempotencyKey: req.IdempotencyKey,
+		VaultVersion:   req.VaultVersion,
+		Force:          req.Force,
+		SHA256:         req.SHA256,
+	}, blob)
+	if c.Writer.Status() == http.StatusOK {
+		if err := h.delta.Release(c.Request.Context(), userID, req.Chunks); err != nil {
+			middleware.Logger(c).Warn().Err(err).Str("user_id", userID.String()).Msg("Failed to release vault chunks")
+		}
+	}
+}
+
+// bindDelta binds a delta request body. It responds and returns false
+// if the body is rejected.
+func (h *VaultHandler) bindDelta(c *gin.Context, req any) bool {
+	err := c.ShouldBindJSON(req)
+	switch {
+	case err == nil:
+		return true
+	case isBodyTooLarge(err):
+		h.respondVaultTooLarge(c)
+	default:
+		apierror.RespondBindError(c, err)
+	}
+	return false
+}
+
+// checkManifest checks the chunk size of a manifest and that its blob can
+// fit the vault size limit, and lowercases its hashes. It responds and
+// returns false if the manifest is rejected.
+func (h *VaultHandler) checkManifest(c *gin.Context, m *models.VaultDeltaManifest) bool {
+	if m.ChunkSize < service.DeltaMinChunkSize || m.ChunkSize > service.DeltaMaxChunkSize {
+		apierror.RespondInvalidInput(c, input.Errors{{Field: "chunk_size", Code: input.CodeInvalid,
+			Message: "must be between " + strconv.Itoa(service.DeltaMinChunkSize) + " and " + strconv.Itoa(service.DeltaMaxChunkSize)}})
+		return false
+	}
+	// Every chunk but the last is full
+	if h.maxSize > 0 && int64(len(m.Chunks)-1)*int64(m.ChunkSize) >= h.maxSize {
+		h.respondVaultTooLarge(c)
+		return false
+	}
+	for i := range m.Chunks {
+		m.Chunks[i] = strings.ToLower(m.Chunks[i])
+	}
+	return true
+}
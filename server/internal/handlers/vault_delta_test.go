@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// memChunks follows the contract of VaultChunkRepository for one user
+type memChunks struct {
+	chunks     map[string][]byte
+	maxPending int64
+}
+
+func (m *memChunks) Existing(_ context.Context, _ uuid.UUID, hashes []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	for _, hash := range hashes {
+		if _, ok := m.chunks[hash]; ok {
+			existing[hash] = true
+		}
+	}
+	return existing, nil
+}
+
+func (m *memChunks) MaxPending() int64 { return m.maxPending }
+
+func (m *memChunks) Save(_ context.Context, _ uuid.UUID, chunks map[string][]byte) error {
+	var pending int64
+	for hash, data := range m.chunks {
+		if _, again := chunks[hash]; !again {
+			pending += int64(len(data))
+		}
+	}
+	for _, data := range chunks {
+		pending += int64(len(data))
+	}
+	if m.maxPending > 0 && pending > m.maxPending {
+		return repository.ErrVaultChunkQuota
+	}
+	for hash, data := range chunks {
+		m.chunks[hash] = data
+	}
+	return nil
+}
+
+func (m *memChunks) Get(_ context.Context, _ uuid.UUID, hashes []string) (map[string][]byte, error) {
+	found := map[string][]byte{}
+	for _, hash := range hashes {
+		if data, ok := m.chunks[hash]; ok {
+			found[hash] = data
+		}
+	}
+	return found, nil
+}
+
+func (m *memChunks) Delete(_ context.Context, _ uuid.UUID, hashes []string) error {
+	for _, hash := range hashes {
+		delete(m.chunks, hash)
+	}
+	return nil
+}
+
+func (m *memChunks) DeleteOlderThan(context.Context, time.Time) (int64, error) { return 0, nil }
+
+// deltaManifest cuts blob into chunks of size and returns their hashes
+func deltaManifest(blob []byte, size int) ([]string, map[string][]byte) {
+	var hashes []string
+	chunks := map[string][]byte{}
+	for ; len(blob) > 0; blob = blob[min(size, len(blob)):] {
+		chunk := blob[:min(size, len(blob))]
+		hash := models.BlobChecksum(chunk)
+		hashes = append(hashes, hash)
+		chunks[hash] = chunk
+	}
+	return hashes, chunks
+}
+
+func TestVaultDelta(t *testing.T) {
+	const chunkSize = service.DeltaMinChunkSize
+	r, _, _ := newVaultTestRouter(1 << 20)
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b)))
+		return w
+	}
+	missing := func(hashes []string) []string {
+		t.Helper()
+		w := post("/vault/delta/manifest", models.VaultDeltaManifest{ChunkSize: chunkSize, Chunks: hashes})
+		var resp models.VaultDeltaManifestResponse
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("manifest: %d %s", w.Code, w.Body.String())
+		}
+		return resp.Missing
+	}
+	commit := func(blob []byte, hashes []string, revision int) *httptest.ResponseRecorder {
+		return post("/vault/delta/commit", models.VaultDeltaCommitRequest{
+			VaultDeltaManifest: models.VaultDeltaManifest{ChunkSize: chunkSize, Chunks: hashes},
+			Revision:           revision,
+			DeviceID:           "dev-1",
+			SHA256:             models.BlobChecksum(blob),
+		})
+	}
+
+	// The first push uploads every chunk
+	blob := bytes.Repeat([]byte("a"), 3*chunkSize+100)
+	copy(blob[chunkSize:], bytes.Repeat([]byte("b"), chunkSize))
+	hashes, chunks := deltaManifest(blob, chunkSize)
+	if got := missing(hashes); len(got) != 3 {
+		t.Fatalf("missing %d chunks of a new vault, want the 3 distinct ones", len(got))
+	}
+	if w := commit(blob, hashes, 0); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "CHUNKS_MISSING") {
+		t.Fatalf("commit without chunks: %d %s", w.Code, w.Body.String())
+	}
+	var upload models.VaultDeltaChunksRequest
+	for hash, data := range chunks {
+		upload.Chunks = append(upload.Chunks, models.VaultDeltaChunk{SHA256: strings.ToUpper(hash), Data: data})
+	}
+	if w := post("/vault/delta/chunks", upload); w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	if got := missing(hashes); len(got) != 0 {
+		t.Fatalf("missing %v after the upload", got)
+	}
+	if w := commit(blob, hashes, 0); w.Code != http.StatusOK {
+		t.Fatalf("commit: %d %s", w.Code, w.Body.String())
+	}
+
+	// A change in one chunk only uploads that chunk
+	changed := slices.Clone(blob)
+	changed[2*chunkSize] = 'c'
+	hashes, chunks = deltaManifest(changed, chunkSize)
+	got := missing(hashes)
+	if len(got) != 1 || got[0] != hashes[2] {
+		t.Fatalf("missing %v after a one-chunk change, want [%s]", got, hashes[2])
+	}
+	w := post("/vault/delta/chunks", models.VaultDeltaChunksRequest{Chunks: []models.VaultDeltaChunk{{SHA256: hashes[2], Data: chunks[hashes[2]]}}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	if w := commit(changed, hashes, 0); w.Code != http.StatusConflict {
+		t.Errorf("stale commit: %d %s", w.Code, w.Body.String())
+	}
+	if w := commit(changed, hashes, 1); w.Code != http.StatusOK {
+		t.Fatalf("commit: %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vault/blob", nil))
+	if !bytes.Equal(w.Body.Bytes(), changed) {
+		t.Error("pulled vault differs from the committed one")
+	}
+}
+
+func TestVaultDelta_Rejections(t *testing.T) {
+	r, _, _ := newVaultTestRouter(64 << 10)
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b)))
+		return w
+	}
+	hash := models.BlobChecksum([]byte("chunk"))
+
+	if w := post("/vault/delta/manifest", models.VaultDeltaManifest{ChunkSize: 100, Chunks: []string{hash}}); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "chunk_size") {
+		t.Errorf("small chunk size: %d %s", w.Code, w.Body.String())
+	}
+	if w := post("/vault/delta/manifest", models.VaultDeltaManifest{ChunkSize: service.DeltaMinChunkSize, Chunks: []string{"not-a-hash"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid hash: %d %s", w.Code, w.Body.String())
+	}
+	tooMany := slices.Repeat([]string{hash}, 17)
+	if w := post("/vault/delta/manifest", models.VaultDeltaManifest{ChunkSize: service.DeltaMinChunkSize, Chunks: tooMany}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("manifest beyond the size limit: %d %s", w.Code, w.Body.String())
+	}
+	w := post("/vault/delta/chunks", models.VaultDeltaChunksRequest{Chunks: []models.VaultDeltaChunk{{SHA256: hash, Data: []byte("other")}}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), service.PushCodeChecksum) {
+		t.Errorf("chunk not matching its hash: %d %s", w.Code, w.Body.String())
+	}
+
+	// Only the last chunk may be short
+	short, long := []byte("short"), bytes.Repeat([]byte("x"), service.DeltaMinChunkSize)
+	chunks := []models.VaultDeltaChunk{{SHA256: models.BlobChecksum(short), Data: short}, {SHA256: models.BlobChecksum(long), Data: long}}
+	if w := post("/vault/delta/chunks", models.VaultDeltaChunksRequest{Chunks: chunks}); w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	w = post("/vault/delta/commit", models.VaultDeltaCommitRequest{
+		VaultDeltaManifest: models.VaultDeltaManifest{ChunkSize: service.DeltaMinChunkSize, Chunks: []string{chunks[0].SHA256, chunks[1].SHA256}},
+		DeviceID:           "dev-1",
+		SHA256:             models.BlobChecksum(append(short, long...)),
+	})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"chunks"`) {
+		t.Errorf("commit with a short chunk in between: %d %s", w.Code, w.Body.String())
+	}
+
+	// Uploads may not pile up beyond the pending quota
+	var upload models.VaultDeltaChunksRequest
+	for i := range testChunkQuota / service.DeltaMinChunkSize {
+		data := bytes.Repeat([]byte{byte('a' + i)}, service.DeltaMinChunkSize)
+		upload.Chunks = append(upload.Chunks, models.VaultDeltaChunk{SHA256: models.BlobChecksum(data), Data: data})
+	}
+	w = post("/vault/delta/chunks", upload)
+	var resp struct {
+		Code    string           `json:"code"`
+		Details map[string]int64 `json:"details"`
+	}
+	if w.Code != http.StatusRequestEntityTooLarge || json.Unmarshal(w.Body.Bytes(), &resp) != nil ||
+		resp.Code != "CHUNK_QUOTA_EXCEEDED" || resp.Details["max_pending_bytes"] != testChunkQuota {
+		t.Errorf("upload beyond the quota: %d %s", w.Code, w.Body.String())
+	}
+	// Uploading pending chunks again does not count them twice
+	if w := post("/vault/delta/chunks", models.VaultDeltaChunksRequest{Chunks: chunks}); w.Code != http.StatusOK {
+		t.Errorf("upload of pending chunks again: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
+)
+
+// exportMetadataLimit caps the sync history and devices of an imported
+// export besides the blob
+const exportMetadataLimit = 16 << 20
+
+var (
+	errExportFormat   = errors.New("unsupported export format")
+	errExportChecksum = errors.New("sha256 does not match the vault blob")
+)
+
+// Export downloads the vault picked by ?vault= with its metadata, sync
+// history and the user's devices as one JSON document for offline
+// backups. The export is recorded in the sync log.
+func (h *VaultHandler) Export(c *gin.Context) {
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+	ctx := c.Request.Context()
+
+	vault, err := h.vaultRepo.GetByUserID(ctx, userID, name)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrVaultNotFound):
+		apierror.RespondError(c, http.StatusNotFound, "NO_VAULT", "no vault found", nil)
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to export vault", nil)
+		return
+	}
+	history, err := h.syncRepo.GetChain(ctx, userID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to export vault", nil)
+		return
+	}
+	devices, err := h.deviceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to export vault", nil)
+		return
+	}
+	_ = h.syncRepo.Create(ctx, userID, &deviceID, name, "export", &vault.Revision, nil)
+
+	export := buildVaultExport(vault, history, devices, time.Now())
+	c.Header("Content-Disposition", `attachment; filename="`+exportFilename(name, export.ExportedAt)+`"`)
+	c.Header("Cache-Control", "no-store")
+	respondJSON(c, http.StatusOK, export)
+}
+
+// buildVaultExport assembles the export document of vault
+func buildVaultExport(vault *models.EncryptedVault, history []models.SyncLog, devices []models.Device, now time.Time) *models.VaultExport {
+	if history == nil {
+		history = []models.SyncLog{}
+	}
+	if devices == nil {
+		devices = []models.Device{}
+	}
+	return &models.VaultExport{
+		Format:       models.VaultExportFormat,
+		ExportedAt:   now.UTC(),
+		Name:         vault.Name,
+		VaultBlob:    base64.StdEncoding.EncodeToString(vault.VaultBlob),
+		Revision:     vault.Revision,
+		VaultVersion: vault.VaultVersion,
+		SizeBytes:    len(vault.VaultBlob),
+		SHA256:       models.BlobChecksum(vault.VaultBlob),
+		UpdatedAt:    vault.UpdatedAt,
+		History:      history,
+		Devices:      devices,
+	}
+}
+
+// exportFilename names the download of an export made at t
+func exportFilename(name string, t time.Time) string {
+	return "vibedterm-vault-" + name + "-" + t.UTC().Format("20060102T150405Z") + ".json"
+}
+
+// Import replaces the vault with the blob of an export document, like a
+// force overwrite, and requires ?confirm=true. The vault is the one
+// picked by ?vault=, else the one named in the export. The history and
+// devices of the export are not imported.
+func (h *VaultHandler) Import(c *gin.Context) {
+	if c.Query("confirm") != "true" {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "confirmation required", nil)
+		return
+	}
+
+	body, _, err := decodedBody(c)
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		respondUnsupportedEncoding(c)
+		return
+	case err != nil:
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid gzip body", nil)
+		return
+	}
+	export, blob, err := readVaultImport(body, h.maxSize)
+	switch {
+	case err == nil:
+	case errors.Is(err, errVaultTooLarge), isBodyTooLarge(err):
+		h.respondVaultTooLarge(c)
+		return
+	case errors.Is(err, errExportFormat):
+		apierror.RespondError(c, http.StatusBadRequest, "UNSUPPORTED_EXPORT_FORMAT", err.Error(), nil)
+		return
+	case errors.Is(err, errExportChecksum):
+		apierror.RespondError(c, http.StatusBadRequest, service.PushCodeChecksum, err.Error(), nil)
+		return
+	default:
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid export", nil)
+		return
+	}
+
+	name := c.Query("vault")
+	if name == "" {
+		name = export.Name
+	}
+	name, ok := vaultName(c, name)
+	if !ok {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+
+	vault, err := h.vaultSync.ForceOverwrite(c.Request.Context(), userID, deviceID, name, blob, export.VaultVersion)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrForeignDevice):
+		apierror.RespondError(c, http.StatusBadRequest, "UNKNOWN_DEVICE", "device does not belong to this account", nil)
+		return
+	case errors.Is(err, service.ErrVaultLimit):
+		respondVaultLimit(c)
+		return
+	case errors.Is(err, service.ErrVaultVersionRefused):
+		apierror.RespondError(c, http.StatusUpgradeRequired, service.PushCodeMigrationNeeded, "vault version is no longer accepted, migrate the vault first", nil)
+		return
+	case errors.Is(err, service.ErrVaultVersionUnsupported):
+		respondVersionUnsupported(c)
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to import vault", nil)
+		return
+	}
+	h.changes.Publish(userID, stream.Event{Vault: vault.Name, Revision: vault.Revision, DeviceID: deviceID})
+
+	c.JSON(http.StatusOK, models.VaultPushResponse{
+		Status:    "imported",
+		Revision:  vault.Revision,
+		Timestamp: vault.UpdatedAt.Unix(),
+	})
+}
+
+// readVaultImport decodes an export document and its blob of at most
+// maxSize bytes (0 = no limit) and checks the blob against its checksum
+func readVaultImport(r io.Reader, maxSize int64) (*models.VaultExport, []byte, error) {
+	var export models.VaultExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, nil, err
+	}
+	if export.Format != models.VaultExportFormat {
+		return nil, nil, errExportFormat
+	}
+	blob, err := base64.StdEncoding.DecodeString(export.VaultBlob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(blob) == 0 {
+		return nil, nil, errors.New("empty vault blob")
+	}
+	if maxSize > 0 && int64(len(blob)) > maxSize {
+		return nil, nil, errVaultTooLarge
+	}
+	if export.SHA256 != "" && !strings.EqualFold(export.SHA256, models.BlobChecksum(blob)) {
+		return nil, nil, errExportChecksum
+	}
+	return &export, blob, nil
+}
+
+// LimitImportBody is LimitBody for imports, whose body also carries the
+// sync history and devices of the export
+func (h *VaultHandler) LimitImportBody(c *gin.Context) {
+	if h.maxSize <= 0 {
+		c.Next()
+		return
+	}
+	limit := vaultBodyLimit(h.maxSize) + exportMetadataLimit
+	if c.Request.ContentLength > limit {
+		h.respondVaultTooLarge(c)
+		c.Abort()
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	c.Next()
+}
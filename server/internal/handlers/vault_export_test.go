@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestVaultExport_RoundTrip(t *testing.T) {
+	userID := uuid.New()
+	vault := &models.EncryptedVault{UserID: userID, Name: "work", VaultBlob: []byte("encrypted"), Revision: 7, VaultVersion: 2}
+	history := []models.SyncLog{{ID: uuid.New(), UserID: userID, Vault: "work", Action: "push"}}
+	now := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	export := buildVaultExport(vault, history, nil, now)
+	if export.Devices == nil || len(export.History) != 1 || export.SizeBytes != 9 {
+		t.Errorf("export = %+v", export)
+	}
+	if name := exportFilename(export.Name, export.ExportedAt); name != "vibedterm-vault-work-20260301T123000Z.json" {
+		t.Errorf("filename = %q", name)
+	}
+
+	doc, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, blob, err := readVaultImport(bytes.NewReader(doc), 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blob) != "encrypted" || imported.Revision != 7 || imported.VaultVersion != 2 {
+		t.Errorf("imported %q at revision %d, version %d", blob, imported.Revision, imported.VaultVersion)
+	}
+
+	if _, _, err := readVaultImport(bytes.NewReader(doc), 4); !errors.Is(err, errVaultTooLarge) {
+		t.Errorf("oversized import: error = %v, want errVaultTooLarge", err)
+	}
+	tampered := *export
+	tampered.SHA256 = models.BlobChecksum([]byte("other"))
+	doc, _ = json.Marshal(&tampered)
+	if _, _, err := readVaultImport(bytes.NewReader(doc), 0); !errors.Is(err, errExportChecksum) {
+		t.Errorf("tampered import: error = %v, want errExportChecksum", err)
+	}
+	if _, _, err := readVaultImport(strings.NewReader(`{"format":99}`), 0); !errors.Is(err, errExportFormat) {
+		t.Errorf("unknown format: error = %v, want errExportFormat", err)
+	}
+}
+
+func TestVaultImport_RequiresConfirmation(t *testing.T) {
+	r, h, _ := newVaultTestRouter(1 << 10)
+	r.POST("/vault/import", h.Import)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/vault/import", strings.NewReader(`{"format":1}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "confirmation") {
+		t.Errorf("import without confirmation: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	// headerIdempotentReplay marks a response repeated for a retried push
+	headerIdempotentReplay = "Idempotent-Replayed"
+)
+
+// idempotencyKey returns the key of a push from the Idempotency-Key
+// header, else from the request body. It responds and returns false if
+// the header is invalid.
+func idempotencyKey(c *gin.Context, req *models.VaultPushRequest) (string, bool) {
+	key, err := input.Clean(headerIdempotencyKey, input.KindToken, c.GetHeader(headerIdempotencyKey))
+	var fieldErr *input.FieldError
+	if errors.As(err, &fieldErr) {
+		apierror.RespondInvalidInput(c, input.Errors{*fieldErr})
+		return "", false
+	}
+	if key == "" {
+		key = req.IdempotencyKey
+	}
+	return key, true
+}
+
+// replayPush responds with the outcome of an earlier push with key and
+// returns true, or returns false if there was none and the push goes
+// ahead
+func (h *VaultHandler) replayPush(c *gin.Context, userID uuid.UUID, key, vault string) bool {
+	replay, err := h.receipts.Replay(c.Request.Context(), userID, key, vault)
+	switch {
+	case errors.Is(err, service.ErrIdempotencyKeyReused):
+		apierror.RespondError(c, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "idempotency key was used for another vault", nil)
+		return true
+	case err != nil:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to push vault", nil)
+		return true
+	case replay == nil:
+		return false
+	}
+	c.Header(headerIdempotentReplay, "true")
+	c.JSON(http.StatusOK, replay)
+	return true
+}
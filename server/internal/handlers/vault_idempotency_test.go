@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
+)
+
+// memPushReceipts keeps push receipts without expiry. Like the database it
+// fails to save with a cancelled context.
+type memPushReceipts struct {
+	mu       sync.Mutex
+	receipts map[string]models.VaultPushReceipt // by user ID and key
+}
+
+func (m *memPushReceipts) Get(_ context.Context, userID uuid.UUID, key string, _ time.Time) (*models.VaultPushReceipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	receipt, ok := m.receipts[userID.String()+"/"+key]
+	if !ok {
+		return nil, repository.ErrPushReceiptNotFound
+	}
+	return &receipt, nil
+}
+
+func (m *memPushReceipts) Save(ctx context.Context, userID uuid.UUID, key string, receipt *models.VaultPushReceipt, _ time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.receipts == nil {
+		m.receipts = map[string]models.VaultPushReceipt{}
+	}
+	if _, ok := m.receipts[userID.String()+"/"+key]; !ok {
+		m.receipts[userID.String()+"/"+key] = *receipt
+	}
+	return nil
+}
+
+func (m *memPushReceipts) DeleteOlderThan(context.Context, time.Time) (int64, error) { return 0, nil }
+
+func TestVaultPush_IdempotencyKey(t *testing.T) {
+	r, _, _ := newVaultTestRouter(1 << 10)
+
+	push := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/vault/push", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(headerIdempotencyKey, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := func(blob string, revision int, fields string) string {
+		return `{"vault_blob":"` + base64.StdEncoding.EncodeToString([]byte(blob)) + `","revision":` + strconv.Itoa(revision) +
+			`,"device_id":"dev-1"` + fields + `}`
+	}
+
+	first := push("key-1", body("v1", 0, ""))
+	if first.Code != http.StatusOK {
+		t.Fatalf("first push: %d %s", first.Code, first.Body.String())
+	}
+
+	// The response to a lost request is replayed for its retry, byte for
+	// byte, instead of a conflict
+	retry := push("key-1", body("v1", 0, ""))
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry: %d %s, want %s", retry.Code, retry.Body.String(), first.Body.String())
+	}
+	if retry.Header().Get(headerIdempotentReplay) != "true" {
+		t.Errorf("retry is not marked as replayed")
+	}
+
+	// The key may also be sent in the body
+	second := push("", body("v2", 1, `,"idempotency_key":"key-2"`))
+	if second.Code != http.StatusOK {
+		t.Fatalf("second push: %d %s", second.Code, second.Body.String())
+	}
+	if w := push("", body("v2", 1, `,"idempotency_key":"key-2"`)); w.Body.String() != second.Body.String() {
+		t.Errorf("retry with the key in the body: %d %s", w.Code, w.Body.String())
+	}
+
+	// Different keys at the same revision still conflict
+	if w := push("key-3", body("v3", 2, "")); w.Code != http.StatusOK {
+		t.Fatalf("third push: %d %s", w.Code, w.Body.String())
+	}
+	if w := push("key-4", body("v3b", 2, "")); w.Code != http.StatusConflict {
+		t.Errorf("push with another key at the same revision: %d, want 409", w.Code)
+	}
+	if w := push("", body("v3c", 2, "")); w.Code != http.StatusConflict {
+		t.Errorf("push without a key at the same revision: %d, want 409", w.Code)
+	}
+
+	w := push("key-1", body("v1", 0, `,"name":"work"`))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another vault: %d %s, want 422", w.Code, w.Body.String())
+	}
+}
+
+// cancellingVaults cancels the request once the vault is created, as if
+// the client hung up right after the write
+type cancellingVaults struct {
+	*memVaults
+	cancel context.CancelFunc
+}
+
+func (v cancellingVaults) Create(ctx context.Context, userID uuid.UUID, name string, blob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, error) {
+	defer v.cancel()
+	return v.memVaults.Create(ctx, userID, name, blob, vaultVersion, deviceID, writeLog)
+}
+
+func TestVaultPush_ReceiptSurvivesCancelledRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vaults := cancellingVaults{&memVaults{vaults: map[vaultKey]*models.EncryptedVault{}, deleted: map[vaultKey]*models.EncryptedVault{}, limit: testVaultLimit}, cancel}
+	migration := service.NewVaultMigration(memSettings{}, nil)
+	h := NewVaultHandler(nil, nil, nil,
+		service.NewVaultSync(vaults, nopSyncStores{}, nopSyncStores{}, migration, testMaxVersion),
+		nil, service.NewPushReceipts(&memPushReceipts{}), nil, nil, migration, 1<<10,
+		stream.NewHub(0, 0), stream.NewBroker(), time.Second)
+	userID := uuid.New()
+	r := gin.New()
+	r.POST("/vault/push", func(c *gin.Context) { c.Set("user_id", userID) }, h.Push)
+
+	push := func(ctx context.Context) *httptest.ResponseRecorder {
+		body := `{"vault_blob":"` + base64.StdEncoding.EncodeToString([]byte("v1")) + `","revision":0,"device_id":"dev-1"}`
+		req := httptest.NewRequest(http.MethodPost, "/vault/push", strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(headerIdempotencyKey, "key-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := push(ctx); w.Code != http.StatusOK {
+		t.Fatalf("push: %d %s", w.Code, w.Body.String())
+	}
+	if ctx.Err() == nil {
+		t.Fatal("request was not cancelled")
+	}
+	w := push(context.Background())
+	if w.Code != http.StatusOK || w.Header().Get(headerIdempotentReplay) != "true" {
+		t.Errorf("retry after the client hung up: %d %s, want the replayed receipt", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestVaultNames(t *testing.T) {
+	r, _, _ := newVaultTestRouter(1 << 10)
+
+	push := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/vault/push"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	pushBody := func(blob, name string) string {
+		body, _ := json.Marshal(models.VaultPushRequest{VaultBlob: base64.StdEncoding.EncodeToString([]byte(blob)), DeviceID: "dev-1", Name: name})
+		return string(body)
+	}
+	pull := func(query string) (int, models.VaultPullResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vault/pull"+query, nil))
+		var resp models.VaultPullResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	// Clients that name no vault use the default one
+	if w := push("", pushBody("main", "")); w.Code != http.StatusOK {
+		t.Fatalf("default push: %d %s", w.Code, w.Body.String())
+	}
+	if w := push("?vault=work", pushBody("work", "")); w.Code != http.StatusOK {
+		t.Fatalf("push to work: %d %s", w.Code, w.Body.String())
+	}
+	// The name in the body wins over the query
+	if w := push("?vault=work", pushBody("home", "home")); w.Code != http.StatusOK {
+		t.Fatalf("push to home: %d %s", w.Code, w.Body.String())
+	}
+
+	for query, want := range map[string]string{"": "main", "?vault=default": "main", "?vault=work": "work", "?vault=home": "home"} {
+		code, resp := pull(query)
+		blob, _ := base64.StdEncoding.DecodeString(resp.VaultBlob)
+		if code != http.StatusOK || string(blob) != want || resp.Revision != 1 {
+			t.Errorf("pull%s = %d %q revision %d, want %q at revision 1", query, code, blob, resp.Revision, want)
+		}
+	}
+	if code, _ := pull("?vault=other"); code != http.StatusNotFound {
+		t.Errorf("pull of a missing vault = %d, want 404", code)
+	}
+	if code, _ := pull("?vault=Bad%20Name"); code != http.StatusBadRequest {
+		t.Errorf("pull with an invalid name = %d, want 400", code)
+	}
+
+	w := push("?vault=other", pushBody("other", ""))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "VAULT_LIMIT") {
+		t.Errorf("push beyond the limit of %d vaults: %d %s", testVaultLimit, w.Code, w.Body.String())
+	}
+	// Existing vaults can still be updated at the limit
+	if w := push("?vault=work", `{"vault_blob":"`+base64.StdEncoding.EncodeToString([]byte("work 2"))+`","revision":1,"device_id":"dev-1"}`); w.Code != http.StatusOK {
+		t.Errorf("update at the limit: %d %s", w.Code, w.Body.String())
+	}
+}
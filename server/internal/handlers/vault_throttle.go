@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// RecordThrottle notes in the sync log that a user hit a sync rate limit.
+// It is meant as the onThrottle callback of RateLimiter.PerUser.
+func (h *VaultHandler) RecordThrottle(c *gin.Context, userID uuid.UUID) {
+	name := c.Query("vault")
+	if !models.ValidVaultName(name) {
+		name = models.DefaultVaultName
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+	if err := h.syncRepo.Create(c.Request.Context(), userID, &deviceID, name, "throttled", nil, nil); err != nil {
+		middleware.Logger(c).Error().Err(err).Str("user_id", userID.String()).Msg("Failed to log throttled sync")
+	}
+	middleware.Logger(c).Warn().Str("user_id", userID.String()).Str("path", c.FullPath()).Msg("Vault sync throttled")
+}
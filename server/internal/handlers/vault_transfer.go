@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+type vaultTransferer interface {
+	Transfer(ctx context.Context, actorID, sourceID, targetID uuid.UUID, opts models.VaultTransferOptions) (*service.VaultTransferResult, error)
+}
+
+// VaultTransferHandler lets admins move a vault between user accounts
+type VaultTransferHandler struct {
+	transfer vaultTransferer
+}
+
+// NewVaultTransferHandler creates a new vault transfer handler
+func NewVaultTransferHandler(transfer vaultTransferer) *VaultTransferHandler {
+	return &VaultTransferHandler{transfer: transfer}
+}
+
+// Transfer moves or copies the vault of the user in the path to
+// target_user_id. Requires confirm; an existing target vault is only
+// replaced with overwrite.
+func (h *VaultTransferHandler) Transfer(c *gin.Context) {
+	actorID, sourceID, ok := adminTarget(c)
+	if !ok {
+		return
+	}
+
+	var req models.VaultTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+	targetID, err := uuid.Parse(req.TargetUserID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid target user ID", nil)
+		return
+	}
+	if !req.Confirm {
+		apierror.RespondError(c, http.StatusBadRequest, "CONFIRMATION_REQUIRED", "confirmation required", nil)
+		return
+	}
+
+	result, err := h.transfer.Transfer(c.Request.Context(), actorID, sourceID, targetID, models.VaultTransferOptions{
+		Copy:            req.Copy,
+		Overwrite:       req.Overwrite,
+		IncludeSyncLogs: req.IncludeSyncLogs,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTransferToSelf):
+			apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "source and target user are the same", nil)
+		case errors.Is(err, repository.ErrUserNotFound):
+			apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+		case errors.Is(err, repository.ErrVaultNotFound):
+			apierror.RespondError(c, http.StatusNotFound, "VAULT_NOT_FOUND", "source user has no vault", nil)
+		case errors.Is(err, repository.ErrVaultExists):
+			apierror.RespondError(c, http.StatusConflict, "TARGET_HAS_VAULT", "target user already has a vault, set overwrite to replace it", nil)
+		default:
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to transfer vault", nil)
+		}
+		return
+	}
+
+	message := "vault moved"
+	if req.Copy {
+		message = "vault copied"
+	}
+	c.JSON(http.StatusOK, models.VaultTransferResponse{
+		Message:      message,
+		SourceUserID: result.Source.ID,
+		TargetUserID: result.Target.ID,
+		Copied:       req.Copy,
+		Overwritten:  result.Overwritten,
+		Revision:     result.Vault.Revision,
+	})
+}
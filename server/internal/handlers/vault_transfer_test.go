@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+type stubTransferer struct {
+	err   error
+	calls int
+}
+
+func (s *stubTransferer) Transfer(_ context.Context, _, sourceID, targetID uuid.UUID, _ models.VaultTransferOptions) (*service.VaultTransferResult, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &service.VaultTransferResult{
+		Source: &models.User{ID: sourceID},
+		Target: &models.User{ID: targetID},
+		Vault:  &models.EncryptedVault{Revision: 3},
+	}, nil
+}
+
+func TestVaultTransfer_RequiresConfirm(t *testing.T) {
+	stub := &stubTransferer{}
+	h := NewVaultTransferHandler(stub)
+
+	body := fmt.Sprintf(`{"target_user_id": %q}`, uuid.New())
+	w, resp := callWithIDBody(h.Transfer, uuid.New().String(), "/", body)
+	if w.Code != http.StatusBadRequest || resp["code"] != "CONFIRMATION_REQUIRED" {
+		t.Fatalf("status = %d, body = %v; want 400 CONFIRMATION_REQUIRED", w.Code, resp)
+	}
+	if stub.calls != 0 {
+		t.Error("transfer ran without confirmation")
+	}
+}
+
+func TestVaultTransfer_TargetHasVault(t *testing.T) {
+	h := NewVaultTransferHandler(&stubTransferer{err: repository.ErrVaultExists})
+
+	body := fmt.Sprintf(`{"target_user_id": %q, "confirm": true}`, uuid.New())
+	w, resp := callWithIDBody(h.Transfer, uuid.New().String(), "/", body)
+	if w.Code != http.StatusConflict || resp["code"] != "TARGET_HAS_VAULT" {
+		t.Fatalf("status = %d, body = %v; want 409 TARGET_HAS_VAULT", w.Code, resp)
+	}
+}
+
+func TestVaultTransfer_Success(t *testing.T) {
+	h := NewVaultTransferHandler(&stubTransferer{})
+
+	body := fmt.Sprintf(`{"target_user_id": %q, "confirm": true, "copy": true}`, uuid.New())
+	w, resp := callWithIDBody(h.Transfer, uuid.New().String(), "/", body)
+	if w.Code != http.StatusOK || resp["message"] != "vault copied" || resp["revision"] != float64(3) {
+		t.Fatalf("status = %d, body = %v", w.Code, resp)
+	}
+}
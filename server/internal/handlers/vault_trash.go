@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/stream"
+)
+
+// Delete soft-deletes the vault picked by ?vault=. Until it is undeleted,
+// devices see no vault and a push starts a new one.
+func (h *VaultHandler) Delete(c *gin.Context) {
+	var req struct {
+		Confirm bool `json:"confirm" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+	if !req.Confirm {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "confirmation required", nil)
+		return
+	}
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+
+	deleted, err := h.trash.Delete(c.Request.Context(), userID, deviceID, name)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrVaultNotFound):
+		apierror.RespondError(c, http.StatusNotFound, "NO_VAULT", "no vault found", nil)
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to delete vault", nil)
+		return
+	}
+	h.changes.Publish(userID, stream.Event{Vault: name, DeviceID: deviceID})
+
+	c.JSON(http.StatusOK, deleted)
+}
+
+// Undelete brings back the vault picked by ?vault= if it was deleted
+// within the grace period
+func (h *VaultHandler) Undelete(c *gin.Context) {
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+	deviceID, _ := middleware.GetDeviceID(c)
+
+	vault, err := h.trash.Undelete(c.Request.Context(), userID, deviceID, name)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrVaultNotFound):
+		apierror.RespondError(c, http.StatusNotFound, "NO_DELETED_VAULT", "no deleted vault to restore", nil)
+		return
+	case errors.Is(err, repository.ErrVaultLimit):
+		respondVaultLimit(c)
+		return
+	default:
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to undelete vault", nil)
+		return
+	}
+	h.changes.Publish(userID, stream.Event{Vault: name, Revision: vault.Revision, DeviceID: deviceID})
+
+	c.JSON(http.StatusOK, models.VaultPushResponse{
+		Status:    "undeleted",
+		Revision:  vault.Revision,
+		Timestamp: vault.UpdatedAt.Unix(),
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestVaultDeleteAndUndelete(t *testing.T) {
+	r, _, _ := newVaultTestRouter(1 << 10)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := serve(http.MethodDelete, "/vault", `{"confirm": true}`); w.Code != http.StatusNotFound {
+		t.Fatalf("delete without vault: %d %s", w.Code, w.Body.String())
+	}
+	if w := putVaultBlob(r, []byte("first"), "0"); w.Code != http.StatusOK {
+		t.Fatalf("push: %d %s", w.Code, w.Body.String())
+	}
+	for _, body := range []string{``, `{}`, `{"confirm": false}`} {
+		if w := serve(http.MethodDelete, "/vault", body); w.Code != http.StatusBadRequest {
+			t.Errorf("delete with %q: %d %s", body, w.Code, w.Body.String())
+		}
+	}
+
+	w := serve(http.MethodDelete, "/vault", `{"confirm": true}`)
+	var deleted models.VaultDeleteResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &deleted) != nil || deleted.Revision != 1 || deleted.RestorableUntil-deleted.DeletedAt != 3600 {
+		t.Fatalf("delete: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodGet, "/vault/pull", ""); w.Code != http.StatusNotFound {
+		t.Errorf("pull of a deleted vault: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := serve(http.MethodPost, "/vault/undelete", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"undeleted"`) {
+		t.Fatalf("undelete: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodGet, "/vault/blob", ""); w.Code != http.StatusOK || w.Body.String() != "first" {
+		t.Fatalf("pull after undelete: %d %s", w.Code, w.Body.String())
+	}
+
+	// A new vault pushed after deletion ends the grace period
+	if w := serve(http.MethodDelete, "/vault", `{"confirm": true}`); w.Code != http.StatusOK {
+		t.Fatalf("second delete: %d %s", w.Code, w.Body.String())
+	}
+	if w := putVaultBlob(r, []byte("second"), "0"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"created"`) {
+		t.Fatalf("push after delete: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPost, "/vault/undelete", ""); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "NO_DELETED_VAULT") {
+		t.Errorf("undelete after a new push: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// Watch long-polls for changes to the vault picked by ?vault= made by
+// other devices and returns the new revision. With ?revision=N it returns at once if the vault is
+// no longer at N, so no change between two watches is missed. After the
+// watch timeout, or ?timeout seconds if shorter, it answers 204 and the
+// client watches again.
+func (h *VaultHandler) Watch(c *gin.Context) {
+	name, ok := vaultName(c, "")
+	if !ok {
+		return
+	}
+	known := -1
+	if q := c.Query("revision"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 0 {
+			apierror.RespondInvalidInput(c, input.Errors{{Field: "revision", Code: input.CodeInvalid, Message: "must be a revision number"}})
+			return
+		}
+		known = n
+	}
+	timeout := h.watchTimeout
+	if q := c.Query("timeout"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 {
+			apierror.RespondInvalidInput(c, input.Errors{{Field: "timeout", Code: input.CodeInvalid, Message: "must be a number of seconds"}})
+			return
+		}
+		timeout = min(timeout, time.Duration(n)*time.Second)
+	}
+
+	conn, ok := openStream(c, h.hub)
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	// Subscribed before reading the head, so a push in between still
+	// arrives as an event
+	events, unsubscribe := h.changes.Subscribe(conn.UserID, name)
+	defer unsubscribe()
+
+	if known >= 0 {
+		head, err := h.vaultSync.Head(conn.Context(), conn.UserID, name)
+		if err != nil {
+			apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to get vault status", nil)
+			return
+		}
+		if head != known {
+			c.JSON(http.StatusOK, models.VaultWatchResponse{Name: name, Revision: head})
+			return
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case ev := <-events:
+			// A device already has its own pushes
+			if ev.DeviceID == conn.DeviceID {
+				continue
+			}
+			c.JSON(http.StatusOK, models.VaultWatchResponse{Name: name, Revision: ev.Revision, DeviceID: ev.DeviceID.String()})
+			return
+		case <-timer.C:
+			c.Status(http.StatusNoContent)
+			return
+		case <-conn.Context().Done():
+			// Client gone, stream terminated or server shutting down
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+}
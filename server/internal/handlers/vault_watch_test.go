@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestVaultWatch(t *testing.T) {
+	r, h, userID := newVaultTestRouter(1 << 10)
+	watcher, pusher := uuid.New(), uuid.New()
+
+	// watch starts a long poll by watcher and returns its response once
+	// the poll is waiting for events
+	watch := func(query string) <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		watchers := h.changes.Watchers(userID)
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/vault/watch"+query, nil)
+			req.Header.Set(testDeviceHeader, watcher.String())
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			done <- w
+		}()
+		deadline := time.Now().Add(time.Second)
+		for h.changes.Watchers(userID) == watchers && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return done
+	}
+	push := func(device uuid.UUID, revision string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/vault/blob", strings.NewReader("vault"))
+		req.Header.Set(headerVaultRevision, revision)
+		req.Header.Set(headerDeviceID, "dev-1")
+		req.Header.Set(testDeviceHeader, device.String())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("push by %s: %d %s", device, w.Code, w.Body.String())
+		}
+	}
+	result := func(done <-chan *httptest.ResponseRecorder) (int, models.VaultWatchResponse) {
+		t.Helper()
+		var resp models.VaultWatchResponse
+		select {
+		case w := <-done:
+			_ = json.Unmarshal(w.Body.Bytes(), &resp)
+			return w.Code, resp
+		case <-time.After(2 * time.Second):
+			t.Fatal("watch did not return")
+			return 0, resp
+		}
+	}
+
+	done := watch("?revision=0")
+	push(pusher, "0")
+	if code, resp := result(done); code != http.StatusOK || resp.Revision != 1 || resp.DeviceID != pusher.String() {
+		t.Errorf("watch during push: %d %+v", code, resp)
+	}
+
+	// A stale revision returns at once
+	if code, resp := result(watch("?revision=0")); code != http.StatusOK || resp.Revision != 1 {
+		t.Errorf("stale watch: %d %+v", code, resp)
+	}
+
+	// The watcher's own pushes do not end its watch
+	done = watch("?revision=1&timeout=1")
+	push(watcher, "1")
+	if code, _ := result(done); code != http.StatusNoContent {
+		t.Errorf("watch during own push: %d, want 204 after the timeout", code)
+	}
+
+	done = watch("")
+	h.hub.Shutdown()
+	if code, _ := result(done); code != http.StatusNoContent {
+		t.Errorf("watch at shutdown: %d", code)
+	}
+	if n := h.changes.Watchers(userID); n != 0 {
+		t.Errorf("%d watchers left after the watches ended", n)
+	}
+
+	for _, query := range []string{"?revision=x", "?timeout=0"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/vault/watch"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("watch%s: %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// webAuthnLogin is the subset of service.WebAuthn needed by AuthHandler
+type webAuthnLogin interface {
+	Enabled(ctx context.Context, userID uuid.UUID) (bool, error)
+	BeginLogin(ctx context.Context, user *models.User) (*protocol.CredentialAssertion, uuid.UUID, error)
+	FinishLogin(ctx context.Context, user *models.User, challengeID uuid.UUID, response []byte) (*models.WebAuthnCredential, error)
+}
+
+// webAuthnCredentials is the subset of service.WebAuthn needed by WebAuthnHandler
+type webAuthnCredentials interface {
+	BeginRegistration(ctx context.Context, user *models.User) (*protocol.CredentialCreation, uuid.UUID, error)
+	FinishRegistration(ctx context.Context, user *models.User, challengeID uuid.UUID, name string, response []byte) (*models.WebAuthnCredential, error)
+	List(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+}
+
+// WebAuthnHandler manages the passkeys and security keys of the current user
+type WebAuthnHandler struct {
+	userRepo totpUserStore
+	webAuthn webAuthnCredentials
+}
+
+// NewWebAuthnHandler creates a new WebAuthn handler
+func NewWebAuthnHandler(userRepo *repository.UserRepository, webAuthn *service.WebAuthn) *WebAuthnHandler {
+	return &WebAuthnHandler{userRepo: userRepo, webAuthn: webAuthn}
+}
+
+// BeginRegistration returns the options for navigator.credentials.create
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	creation, challengeID, err := h.webAuthn.BeginRegistration(c.Request.Context(), user)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to begin WebAuthn registration")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to begin registration", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.WebAuthnBeginResponse{ChallengeID: challengeID, Options: creation})
+}
+
+// FinishRegistration verifies the new credential and stores it
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	var req models.WebAuthnRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+	challengeID, err := uuid.Parse(req.ChallengeID)
+	if err != nil {
+		respondWebAuthnError(c, service.ErrWebAuthnChallengeInvalid)
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	cred, err := h.webAuthn.FinishRegistration(c.Request.Context(), user, challengeID, req.Name, req.Credential)
+	if err != nil {
+		if errors.Is(err, repository.ErrWebAuthnCredentialExists) {
+			apierror.RespondError(c, http.StatusConflict, "WEBAUTHN_CREDENTIAL_EXISTS", "authenticator already registered", nil)
+			return
+		}
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, cred)
+}
+
+// List lists the credentials of the current user
+func (h *WebAuthnHandler) List(c *gin.Context) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	creds, err := h.webAuthn.List(c.Request.Context(), userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list WebAuthn credentials")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to list credentials", nil)
+		return
+	}
+	if creds == nil {
+		creds = []models.WebAuthnCredential{}
+	}
+	c.JSON(http.StatusOK, models.WebAuthnCredentialListResponse{Credentials: creds})
+}
+
+// Delete removes one credential of the current user
+func (h *WebAuthnHandler) Delete(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid credential ID", nil)
+		return
+	}
+
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	err = h.webAuthn.Delete(c.Request.Context(), userID, id)
+	if errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+		apierror.RespondError(c, http.StatusNotFound, "WEBAUTHN_CREDENTIAL_NOT_FOUND", "credential not found", nil)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete WebAuthn credential")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "failed to delete credential", nil)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "credential deleted"})
+}
+
+// currentUser loads the authenticated user or responds with an error
+func (h *WebAuthnHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, err := middleware.GetUserID(c)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized", nil)
+		return nil, false
+	}
+	user, err := h.userRepo.GetByID(c.Request.Context(), userID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusNotFound, apierror.CodeNotFound, "user not found", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// BeginWebAuthnLogin returns the options for navigator.credentials.get
+// to complete a login with a temp token
+func (h *AuthHandler) BeginWebAuthnLogin(c *gin.Context) {
+	var req models.WebAuthnLoginBeginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+
+	user, _, _, ok := h.tempTokenUser(c, req.TempToken)
+	if !ok {
+		return
+	}
+
+	assertion, challengeID, err := h.webAuthn.BeginLogin(c.Request.Context(), user)
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.WebAuthnBeginResponse{ChallengeID: challengeID, Options: assertion})
+}
+
+// FinishWebAuthnLogin verifies the assertion and completes the login
+func (h *AuthHandler) FinishWebAuthnLogin(c *gin.Context) {
+	var req models.WebAuthnLoginFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondBindError(c, err)
+		return
+	}
+	challengeID, err := uuid.Parse(req.ChallengeID)
+	if err != nil {
+		respondWebAuthnError(c, service.ErrWebAuthnChallengeInvalid)
+		return
+	}
+
+	user, device, claims, ok := h.tempTokenUser(c, req.TempToken)
+	if !ok {
+		return
+	}
+
+	if _, err := h.webAuthn.FinishLogin(c.Request.Context(), user, challengeID, req.Credential); err != nil {
+		if errors.Is(err, service.ErrWebAuthnVerification) {
+			recordLoginFailed(c, h.events, user, user.Email, events.ReasonInvalidWebAuthn)
+		}
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	h.completeLogin(c, user, device, claims, false)
+}
+
+// tempTokenUser validates a temp token and loads its user, or responds
+// with an error. The account is checked again since it may have been
+// blocked after the password step.
+func (h *AuthHandler) tempTokenUser(c *gin.Context, tempToken string) (*models.User, loginDevice, *middleware.Claims, bool) {
+	claims, err := h.parseTempTokenClaims(tempToken)
+	if err != nil {
+		respondTempTokenError(c, err)
+		return nil, loginDevice{}, nil, false
+	}
+	if !tempTokenUsable(c, h.tempTokens, claims, h.config) {
+		respondTempTokenError(c, middleware.ErrInvalidToken)
+		return nil, loginDevice{}, nil, false
+	}
+
+	user, err := h.userRepo.GetByID(c.Request.Context(), claims.UserID)
+	if err != nil {
+		apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "user not found", nil)
+		return nil, loginDevice{}, nil, false
+	}
+	if !h.checkAccountStatus(c, user, user.Email) {
+		return nil, loginDevice{}, nil, false
+	}
+	return user, tempTokenDevice(claims), claims, true
+}
+
+// respondWebAuthnError maps WebAuthn service errors to responses
+func respondWebAuthnError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrWebAuthnNotEnabled):
+		apierror.RespondError(c, http.StatusBadRequest, "WEBAUTHN_NOT_ENABLED", "no passkey or security key registered", nil)
+	case errors.Is(err, service.ErrWebAuthnChallengeInvalid):
+		apierror.RespondError(c, http.StatusBadRequest, "WEBAUTHN_CHALLENGE_INVALID", "challenge invalid or expired", nil)
+	case errors.Is(err, service.ErrWebAuthnVerification):
+		apierror.RespondError(c, http.StatusUnauthorized, "WEBAUTHN_VERIFICATION_FAILED", "authenticator response could not be verified", nil)
+	default:
+		log.Error().Err(err).Msg("WebAuthn ceremony failed")
+		apierror.RespondError(c, http.StatusInternalServerError, apierror.CodeInternal, "webauthn ceremony failed", nil)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// fakeWebAuthn accepts the assertion "valid" for users with a passkey
+type fakeWebAuthn struct {
+	users map[uuid.UUID]bool
+}
+
+func (f fakeWebAuthn) Enabled(_ context.Context, userID uuid.UUID) (bool, error) {
+	return f.users[userID], nil
+}
+
+func (f fakeWebAuthn) BeginLogin(_ context.Context, user *models.User) (*protocol.CredentialAssertion, uuid.UUID, error) {
+	if !f.users[user.ID] {
+		return nil, uuid.Nil, service.ErrWebAuthnNotEnabled
+	}
+	return &protocol.CredentialAssertion{}, uuid.New(), nil
+}
+
+func (f fakeWebAuthn) FinishLogin(_ context.Context, user *models.User, _ uuid.UUID, response []byte) (*models.WebAuthnCredential, error) {
+	if string(response) != `"valid"` {
+		return nil, service.ErrWebAuthnVerification
+	}
+	return &models.WebAuthnCredential{ID: uuid.New(), UserID: user.ID}, nil
+}
+
+func TestLogin_SecondFactorMethods(t *testing.T) {
+	hash, err := password.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	totpOnly := &models.User{ID: uuid.New(), Email: "totp@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true, TOTPEnabled: true}
+	passkeyOnly := &models.User{ID: uuid.New(), Email: "key@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true}
+	both := &models.User{ID: uuid.New(), Email: "both@example.com", PasswordHash: hash, EmailVerified: true, IsApproved: true, TOTPEnabled: true}
+	h, _ := newGrantTestHandler(t, totpOnly, passkeyOnly, both)
+	h.webAuthn = fakeWebAuthn{users: map[uuid.UUID]bool{passkeyOnly.ID: true, both.ID: true}}
+
+	for _, tc := range []struct {
+		user         *models.User
+		methods      []interface{}
+		requiresTOTP bool
+	}{
+		{totpOnly, []interface{}{"totp"}, true},
+		{passkeyOnly, []interface{}{"webauthn"}, false},
+		{both, []interface{}{"totp", "webauthn"}, true},
+	} {
+		w, resp := postJSON(t, h.Login, gin.H{"email": tc.user.Email, "password": "correct horse", "device_name": "Laptop", "device_type": "linux"})
+		if w.Code != http.StatusOK || resp["temp_token"] == nil {
+			t.Fatalf("%s: login = %d %s", tc.user.Email, w.Code, w.Body.String())
+		}
+		methods, _ := resp["second_factor_methods"].([]interface{})
+		if len(methods) != len(tc.methods) {
+			t.Fatalf("%s: methods = %v, want %v", tc.user.Email, methods, tc.methods)
+		}
+		for i := range methods {
+			if methods[i] != tc.methods[i] {
+				t.Errorf("%s: methods = %v, want %v", tc.user.Email, methods, tc.methods)
+			}
+		}
+		if resp["requires_totp"] != tc.requiresTOTP {
+			t.Errorf("%s: requires_totp = %v, want %v", tc.user.Email, resp["requires_totp"], tc.requiresTOTP)
+		}
+	}
+}
+
+func TestWebAuthnLogin_CompletesLogin(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Email: "key@example.com", EmailVerified: true, IsApproved: true}
+	h, m := newGrantTestHandler(t, user)
+	h.webAuthn = fakeWebAuthn{users: map[uuid.UUID]bool{user.ID: true}}
+
+	tempToken, err := h.generateTempToken(user.ID, loginDevice{Name: "Phone", Type: "android"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, resp := postJSON(t, h.BeginWebAuthnLogin, gin.H{"temp_token": tempToken})
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin = %d %s", w.Code, w.Body.String())
+	}
+	challengeID, _ := resp["challenge_id"].(string)
+
+	w, resp = postJSON(t, h.FinishWebAuthnLogin, gin.H{"temp_token": tempToken, "challenge_id": challengeID, "credential": "forged"})
+	if w.Code != http.StatusUnauthorized || resp["code"] != "WEBAUTHN_VERIFICATION_FAILED" {
+		t.Fatalf("forged assertion = %d %v, want 401 WEBAUTHN_VERIFICATION_FAILED", w.Code, resp["code"])
+	}
+
+	w, resp = postJSON(t, h.FinishWebAuthnLogin, gin.H{"temp_token": tempToken, "challenge_id": challengeID, "credential": "valid"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("finish = %d %s", w.Code, w.Body.String())
+	}
+	assertGrant(t, "WebAuthn login", resp, "account vault:read vault:write devices", m.tokens[0].FamilyID)
+
+	// The temp token completed its login and cannot complete another
+	w, resp = postJSON(t, h.FinishWebAuthnLogin, gin.H{"temp_token": tempToken, "challenge_id": challengeID, "credential": "valid"})
+	if w.Code != http.StatusUnauthorized || resp["code"] != "TEMP_TOKEN_INVALID" {
+		t.Errorf("reused temp token = %d %v, want 401 TEMP_TOKEN_INVALID", w.Code, resp["code"])
+	}
+	if len(m.tokens) != 1 {
+		t.Errorf("issued %d refresh tokens, want 1", len(m.tokens))
+	}
+}
+
+func TestWebAuthnLogin_BlockedAfterPasswordStep(t *testing.T) {
+	user := &models.User{ID: uuid.New(), Email: "key@example.com", EmailVerified: true, IsApproved: true}
+	h, m := newGrantTestHandler(t, user)
+	h.webAuthn = fakeWebAuthn{users: map[uuid.UUID]bool{user.ID: true}}
+
+	tempToken, err := h.generateTempToken(user.ID, loginDevice{Name: "Phone", Type: "android"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.IsBlocked = true
+
+	w, resp := postJSON(t, h.BeginWebAuthnLogin, gin.H{"temp_token": tempToken})
+	if w.Code != http.StatusForbidden || resp["code"] != "ACCOUNT_BLOCKED" {
+		t.Errorf("begin = %d %v, want 403 ACCOUNT_BLOCKED", w.Code, resp["code"])
+	}
+	w, resp = postJSON(t, h.FinishWebAuthnLogin, gin.H{"temp_token": tempToken, "challenge_id": uuid.NewString(), "credential": "valid"})
+	if w.Code != http.StatusForbidden || resp["code"] != "ACCOUNT_BLOCKED" {
+		t.Errorf("finish = %d %v, want 403 ACCOUNT_BLOCKED", w.Code, resp["code"])
+	}
+	if len(m.tokens) != 0 {
+		t.Errorf("blocked account got %d refresh tokens", len(m.tokens))
+	}
+}
+
+func TestValidateTOTP_RejectsUserWithoutTOTP(t *testing.T) {
+	// A passkey-only user has no TOTP secret; an empty secret must not
+	// validate any code
+	user := &models.User{ID: uuid.New(), Email: "key@example.com", EmailVerified: true, IsApproved: true}
+	h, _ := newGrantTestHandler(t, user)
+
+	tempToken, err := h.generateTempToken(user.ID, loginDevice{Name: "Phone", Type: "android"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := postJSON(t, h.ValidateTOTP, gin.H{"temp_token": tempToken, "code": "000000"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("TOTP for passkey-only user = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,66 @@
+package input
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// bindingValidator cleans request structs before the wrapped validator
+// checks their binding tags
+type bindingValidator struct {
+	binding.StructValidator
+}
+
+// ValidateStruct cleans obj and then runs the wrapped validator, so that
+// e.g. a field holding only control characters fails `required`. Failed
+// binding tags are reported as Errors like failed input checks.
+func (v bindingValidator) ValidateStruct(obj interface{}) error {
+	if err := Struct(obj); err != nil {
+		return err
+	}
+	err := v.StructValidator.ValidateStruct(obj)
+	var failed validator.ValidationErrors
+	if errors.As(err, &failed) {
+		return tagErrors(failed)
+	}
+	return err
+}
+
+// tagErrors converts binding tag failures. The validator's own messages
+// name Go types and are not shown to clients.
+func tagErrors(failed validator.ValidationErrors) Errors {
+	errs := make(Errors, len(failed))
+	for i, fe := range failed {
+		errs[i] = FieldError{Field: fe.Field(), Code: CodeInvalid, Message: "is invalid"}
+		if fe.Tag() == "required" {
+			errs[i].Code, errs[i].Message = CodeRequired, "is required"
+		}
+	}
+	return errs
+}
+
+// jsonFieldName names struct fields in validation errors as they appear
+// in the request body
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// InstallBinding makes gin's binding clean every bound request struct.
+// It is safe to call more than once.
+func InstallBinding() {
+	if _, ok := binding.Validator.(bindingValidator); ok {
+		return
+	}
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+	binding.Validator = bindingValidator{binding.Validator}
+}
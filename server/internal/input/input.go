@@ -0,0 +1,264 @@
+// Package input validates and normalizes user-provided strings before
+// they reach the database.
+//
+// Every string is checked for valid UTF-8 and a maximum length in runes.
+// Text fields are additionally stripped of control characters and
+// normalized to NFC. Secrets, tokens and blobs are checked but never
+// altered, so passwords hash the same as before.
+package input
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Field kinds. Each kind has its own length limit.
+const (
+	KindText        = "text" // default for untagged fields
+	KindEmail       = "email"
+	KindDeviceName  = "device_name"
+	KindDeviceType  = "device_type"
+	KindDeviceModel = "device_model"
+	KindAppVersion  = "app_version"
+	KindReason      = "reason"
+	KindName        = "name"
+	KindMessage     = "message" // free-form notes, e.g. to an admin
+	KindSecret      = "secret"  // passwords; never altered
+	KindToken       = "token"   // tokens, codes and IDs; never altered
+	KindBlob        = "blob"    // base64 payloads; never altered
+)
+
+// rawKinds are only checked, not normalized
+var rawKinds = map[string]bool{KindSecret: true, KindToken: true, KindBlob: true}
+
+// Error codes of FieldError
+const (
+	CodeInvalidUTF8 = "INVALID_UTF8"
+	CodeTooLong     = "TOO_LONG"
+	CodeRequired    = "REQUIRED"
+	CodeInvalid     = "INVALID" // fails a format or range check
+)
+
+// Limits maps field kinds to their maximum length in runes. 0 means
+// unlimited.
+type Limits map[string]int
+
+// DefaultLimits returns the built-in limits. They stay within the column
+// sizes of the schema.
+func DefaultLimits() Limits {
+	return Limits{
+		KindText:        1000,
+		KindEmail:       254,
+		KindDeviceName:  100,
+		KindDeviceType:  50,
+		KindDeviceModel: 255,
+		KindAppVersion:  50,
+		KindReason:      500,
+		KindName:        100,
+		KindMessage:     1000,
+		KindSecret:      1024,
+		KindToken:       512,
+		KindBlob:        0, // size is limited by the vault and settings checks
+	}
+}
+
+// ParseLimits overrides the defaults with "kind=max" entries
+func ParseLimits(entries []string) (Limits, error) {
+	limits := DefaultLimits()
+	for _, entry := range entries {
+		kind, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("input limit %q: want kind=max", entry)
+		}
+		kind = strings.TrimSpace(kind)
+		if _, known := limits[kind]; !known {
+			return nil, fmt.Errorf("input limit %q: unknown field kind %q", entry, kind)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("input limit %q: max must be a non-negative number", entry)
+		}
+		limits[kind] = max
+	}
+	return limits, nil
+}
+
+var limits atomic.Pointer[Limits]
+
+func init() {
+	l := DefaultLimits()
+	limits.Store(&l)
+}
+
+// Configure replaces the package-level limits at startup
+func Configure(l Limits) {
+	limits.Store(&l)
+}
+
+// FieldError describes one rejected field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Max     int    `json:"max,omitempty"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Errors lists all rejected fields of a request
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return "invalid input: " + strings.Join(msgs, "; ")
+}
+
+// Clean validates value as a field of the given kind and returns it
+// normalized. The error is a *FieldError.
+//
+// The JSON decoder replaces invalid UTF-8 with U+FFFD, so in text fields
+// the replacement character is rejected as well.
+func Clean(field, kind, value string) (string, error) {
+	raw := rawKinds[kind]
+	if !utf8.ValidString(value) || (!raw && strings.ContainsRune(value, utf8.RuneError)) {
+		return "", &FieldError{Field: field, Code: CodeInvalidUTF8, Message: "must be valid UTF-8"}
+	}
+	if !raw {
+		value = norm.NFC.String(strings.Map(dropControl, value))
+	}
+
+	max, ok := (*limits.Load())[kind]
+	if !ok {
+		max = (*limits.Load())[KindText]
+	}
+	if max > 0 && utf8.RuneCountInString(value) > max {
+		return "", &FieldError{Field: field, Code: CodeTooLong, Message: fmt.Sprintf("must be at most %d characters", max), Max: max}
+	}
+	return value, nil
+}
+
+// StripHTML removes HTML tags and comments from a plain text value, so
+// markup pasted into a text field is not stored. A "<" that does not
+// start a tag, as in "a < b", is kept.
+func StripHTML(value string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(value, '<')
+		if i < 0 || i+1 == len(value) {
+			break
+		}
+		b.WriteString(value[:i])
+		if next := value[i+1]; !isTagStart(next) {
+			b.WriteByte('<')
+			value = value[i+1:]
+			continue
+		}
+		end := ">"
+		if strings.HasPrefix(value[i:], "<!--") {
+			end = "-->"
+		}
+		j := strings.Index(value[i:], end)
+		if j < 0 {
+			// Unterminated tag: drop the rest
+			return strings.TrimSpace(b.String())
+		}
+		value = value[i+j+len(end):]
+	}
+	b.WriteString(value)
+	return strings.TrimSpace(b.String())
+}
+
+func isTagStart(c byte) bool {
+	return c == '/' || c == '!' || c == '?' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// dropControl removes control characters in strings.Map
+func dropControl(r rune) rune {
+	if unicode.IsControl(r) {
+		return -1
+	}
+	return r
+}
+
+// Struct cleans all string fields of the struct obj points to, including
+// nested structs and string maps. The kind is taken from the `input` tag and defaults to
+// KindText; fields are named after their json or form tag. On failure
+// obj is left partly cleaned and the error is Errors.
+func Struct(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	var errs Errors
+	cleanStruct(v.Elem(), &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func cleanStruct(v reflect.Value, errs *Errors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.String:
+			kind := sf.Tag.Get("input")
+			if kind == "" {
+				kind = KindText
+			}
+			cleaned, err := Clean(fieldName(sf), kind, f.String())
+			if err != nil {
+				*errs = append(*errs, *err.(*FieldError))
+				continue
+			}
+			f.SetString(cleaned)
+		case reflect.Struct:
+			cleanStruct(f, errs)
+		case reflect.Map:
+			cleanMap(fieldName(sf), f, errs)
+		}
+	}
+}
+
+// cleanMap cleans the values of a map[string]string as text
+func cleanMap(name string, m reflect.Value, errs *Errors) {
+	if m.Type().Key().Kind() != reflect.String || m.Type().Elem().Kind() != reflect.String {
+		return
+	}
+	iter := m.MapRange()
+	for iter.Next() {
+		cleaned, err := Clean(name+"."+iter.Key().String(), KindText, iter.Value().String())
+		if err != nil {
+			*errs = append(*errs, *err.(*FieldError))
+			continue
+		}
+		m.SetMapIndex(iter.Key(), reflect.ValueOf(cleaned).Convert(m.Type().Elem()))
+	}
+}
+
+// fieldName returns the name a client uses for sf
+func fieldName(sf reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		if name, _, _ := strings.Cut(sf.Tag.Get(tag), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
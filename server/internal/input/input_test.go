@@ -0,0 +1,150 @@
+package input
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	for _, tc := range []struct {
+		name, kind, value, want, code string
+	}{
+		{"plain", KindDeviceName, "Laptop", "Laptop", ""},
+		{"emoji", KindDeviceName, "My 💻 🚀", "My 💻 🚀", ""},
+		{"control characters stripped", KindDeviceName, "Lap\x00top\r\n\t\u0085", "Laptop", ""},
+		{"NFC", KindDeviceName, "Cafe\u0301", "Caf\u00e9", ""},
+		{"invalid byte", KindDeviceName, "bad\xffname", "", CodeInvalidUTF8},
+		{"truncated sequence", KindEmail, "a\xe2\x82@example.com", "", CodeInvalidUTF8},
+		{"overlong encoding", KindReason, "\xc0\xaf", "", CodeInvalidUTF8},
+		{"replacement character", KindDeviceName, "bad\ufffdname", "", CodeInvalidUTF8},
+		{"secret kept as is", KindSecret, "pa\tss\u00e9word\ufffd", "pa\tss\u00e9word\ufffd", ""},
+		{"secret invalid byte", KindSecret, "pass\xff", "", CodeInvalidUTF8},
+	} {
+		got, err := Clean("f", tc.kind, tc.value)
+		var fe *FieldError
+		switch {
+		case tc.code == "" && (err != nil || got != tc.want):
+			t.Errorf("%s: Clean = %q, %v; want %q", tc.name, got, err, tc.want)
+		case tc.code != "" && (!errors.As(err, &fe) || fe.Code != tc.code):
+			t.Errorf("%s: error = %v, want %s", tc.name, err, tc.code)
+		}
+	}
+}
+
+func TestClean_Length(t *testing.T) {
+	max := DefaultLimits()[KindDeviceName]
+
+	// Length is counted in characters, so emoji are not penalized
+	if _, err := Clean("f", KindDeviceName, strings.Repeat("🚀", max)); err != nil {
+		t.Errorf("%d emoji: %v", max, err)
+	}
+	_, err := Clean("f", KindDeviceName, strings.Repeat("a", max+1))
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Code != CodeTooLong || fe.Max != max {
+		t.Errorf("%d characters: error = %v, want TOO_LONG with max %d", max+1, err, max)
+	}
+
+	// Stripped characters do not count
+	if _, err := Clean("f", KindDeviceName, strings.Repeat("a", max)+"\x00"); err != nil {
+		t.Errorf("max plus control character: %v", err)
+	}
+
+	// Blobs are unlimited by default
+	if _, err := Clean("f", KindBlob, strings.Repeat("A", 1<<20)); err != nil {
+		t.Errorf("blob: %v", err)
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	for in, want := range map[string]string{
+		"Jane Doe":                              "Jane Doe",
+		"<b>Jane</b> Doe":                       "Jane Doe",
+		"<script>alert(1)</script>Jane":         "alert(1)Jane",
+		`<a href="https://x.example">link</a>`:  "link",
+		"Hi <!-- hidden <b> --> admin":          "Hi  admin",
+		"a < b and 1<2":                         "a < b and 1<2",
+		"trailing <":                            "trailing <",
+		"unterminated <img src=x onerror=alert": "unterminated",
+	} {
+		if got := StripHTML(in); got != want {
+			t.Errorf("StripHTML(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer Configure(DefaultLimits())
+
+	limits, err := ParseLimits([]string{"device_name=3", " reason = 0 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Configure(limits)
+	if _, err := Clean("f", KindDeviceName, "abcd"); err == nil {
+		t.Error("configured limit not applied")
+	}
+	if _, err := Clean("f", KindReason, strings.Repeat("a", 5000)); err != nil {
+		t.Errorf("limit 0 should be unlimited: %v", err)
+	}
+
+	for _, bad := range []string{"device_name", "nickname=10", "device_name=-1", "device_name=ten"} {
+		if _, err := ParseLimits([]string{bad}); err == nil {
+			t.Errorf("ParseLimits(%q) accepted", bad)
+		}
+	}
+}
+
+type testDevice struct {
+	Model string `json:"model" input:"device_model"`
+}
+
+type testRequest struct {
+	Name     string            `json:"device_name,omitempty" input:"device_name"`
+	Password string            `json:"password" input:"secret"`
+	Note     string            `form:"note"`
+	Device   testDevice        `json:"device"`
+	Settings map[string]string `json:"settings"`
+	Count    int               `json:"count"`
+	hidden   string
+}
+
+func TestStruct(t *testing.T) {
+	req := testRequest{
+		Name:     "Cafe\u0301\x07",
+		Password: "pass\x07",
+		Note:     "ok",
+		Device:   testDevice{Model: "Pixel\n"},
+		Settings: map[string]string{"motd": "hi\x00"},
+		hidden:   "\xff",
+	}
+	if err := Struct(&req); err != nil {
+		t.Fatalf("Struct: %v", err)
+	}
+	if req.Name != "Caf\u00e9" || req.Password != "pass\x07" || req.Device.Model != "Pixel" || req.Settings["motd"] != "hi" {
+		t.Errorf("cleaned = %+v", req)
+	}
+
+	req = testRequest{Name: "\xff", Note: strings.Repeat("n", DefaultLimits()[KindText]+1), Settings: map[string]string{"motd": "\xfe"}}
+	err := Struct(&req)
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("Struct = %v, want three field errors", err)
+	}
+	fields := map[string]string{}
+	for _, e := range errs {
+		fields[e.Field] = e.Code
+	}
+	want := map[string]string{"device_name": CodeInvalidUTF8, "note": CodeTooLong, "settings.motd": CodeInvalidUTF8}
+	for field, code := range want {
+		if fields[field] != code {
+			t.Errorf("field %s: code %q, want %q (all: %v)", field, fields[field], code, fields)
+		}
+	}
+
+	// Non-struct values are ignored
+	s := "\xff"
+	if err := Struct(&s); err != nil {
+		t.Errorf("Struct(*string) = %v", err)
+	}
+}
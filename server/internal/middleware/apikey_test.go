@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// staticKeys knows one API key secret per scope set
+type staticKeys map[string][]string
+
+func (k staticKeys) Authenticate(_ context.Context, secret string) (*models.User, *models.APIKey, error) {
+	scopes, ok := k[secret]
+	if !ok {
+		return nil, nil, errors.New("unknown key")
+	}
+	user := &models.User{ID: uuid.New(), Email: "cron@example.com", IsAdmin: true}
+	return user, &models.APIKey{ID: uuid.New(), UserID: user.ID, Scopes: scopes}, nil
+}
+
+func newAPIKeyTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	keys := staticKeys{
+		"vtk_read":  {ScopeVaultRead},
+		"vtk_write": {ScopeVaultRead, ScopeVaultWrite},
+	}
+
+	r := gin.New()
+	v1 := r.Group("/api/v1")
+	v1.Use(APIKeyMiddleware(keys, "vtk_"))
+	v1.Use(JWTMiddleware("test-secret"))
+	v1.Use(ScopeMiddleware(APIRouteTable(false)))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	v1.GET("/vault/pull", ok)
+	v1.POST("/vault/push", ok)
+	v1.POST("/totp/disable", ok)
+	v1.POST("/apikeys", ok)
+	v1.GET("/admin/users", AdminMiddleware(), ok)
+	return r
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	r := newAPIKeyTestRouter()
+
+	tests := []struct {
+		name          string
+		authorization string
+		method, path  string
+		want          int
+	}{
+		{"read key pulls", "ApiKey vtk_read", http.MethodGet, "/api/v1/vault/pull", http.StatusOK},
+		{"bearer prefix still works", "Bearer vtk_read", http.MethodGet, "/api/v1/vault/pull", http.StatusOK},
+		{"read key cannot push", "ApiKey vtk_read", http.MethodPost, "/api/v1/vault/push", http.StatusForbidden},
+		{"write key pushes", "ApiKey vtk_write", http.MethodPost, "/api/v1/vault/push", http.StatusOK},
+		{"no TOTP management", "ApiKey vtk_write", http.MethodPost, "/api/v1/totp/disable", http.StatusForbidden},
+		{"no minting keys", "ApiKey vtk_write", http.MethodPost, "/api/v1/apikeys", http.StatusForbidden},
+		{"no admin for admin owner", "ApiKey vtk_write", http.MethodGet, "/api/v1/admin/users", http.StatusForbidden},
+		{"unknown key", "ApiKey vtk_nope", http.MethodGet, "/api/v1/vault/pull", http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", tc.authorization)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
+
+func TestScopeMiddleware_AccessTokensUnrestricted(t *testing.T) {
+	r := newAPIKeyTestRouter()
+	token, err := GenerateToken(uuid.New(), "user@example.com", uuid.New(), false, "test-secret", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/totp/disable", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("access token on account route: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// AppVersionWarningHeader tells clients below the minimum supported app
+// version that they should update
+const AppVersionWarningHeader = "X-App-Version-Warning"
+
+// RespondAppVersionUnsupported rejects a client below the required app
+// version with 426
+func RespondAppVersionUnsupported(c *gin.Context, minRequired string) {
+	apierror.AbortWithError(c, http.StatusUpgradeRequired, "APP_VERSION_UNSUPPORTED", "app version no longer supported, please update",
+		gin.H{"min_version": minRequired})
+}
+
+// AppVersion checks the app version clients send in X-App-Version. Clients
+// below the required version get 426, outdated ones a warning header.
+func AppVersion(policy models.AppVersionPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		warning, ok := policy.Check(c.GetHeader(models.AppVersionHeader))
+		if !ok {
+			RespondAppVersionUnsupported(c, policy.MinRequired)
+			return
+		}
+		if warning != "" {
+			c.Header(AppVersionWarningHeader, warning)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestAppVersionPolicy_Check(t *testing.T) {
+	policy := models.AppVersionPolicy{MinSupported: "1.4", MinRequired: "v1.2.0"}
+	for _, tc := range []struct {
+		version string
+		warn    bool
+		ok      bool
+	}{
+		{"", false, true},
+		{"1.4.0", false, true},
+		{"1.10", false, true},
+		{"1.3.9", true, true},
+		{"1.4.0-beta", false, true},
+		{"1.2", true, true},
+		{"1.1.99", false, false},
+		{"v0.9", false, false},
+		{"nightly", false, true},
+	} {
+		warning, ok := policy.Check(tc.version)
+		if ok != tc.ok || (warning != "") != tc.warn {
+			t.Errorf("Check(%q) = %q, %v; want warning %v, ok %v", tc.version, warning, ok, tc.warn, tc.ok)
+		}
+	}
+
+	if warning, ok := (models.AppVersionPolicy{}).Check("0.1"); warning != "" || !ok {
+		t.Errorf("empty policy: Check = %q, %v", warning, ok)
+	}
+}
+
+func TestAppVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(AppVersion(models.AppVersionPolicy{MinSupported: "2.0", MinRequired: "1.5"}))
+	r.GET("/vault/status", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(version string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/vault/status", nil)
+		if version != "" {
+			req.Header.Set(models.AppVersionHeader, version)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve("1.4.2"); w.Code != http.StatusUpgradeRequired {
+		t.Errorf("below the floor: status = %d, want 426", w.Code)
+	}
+	if w := serve("1.9"); w.Code != http.StatusOK || w.Header().Get(AppVersionWarningHeader) == "" {
+		t.Errorf("outdated: status = %d, warning = %q", w.Code, w.Header().Get(AppVersionWarningHeader))
+	}
+	if w := serve(""); w.Code != http.StatusOK || w.Header().Get(AppVersionWarningHeader) != "" {
+		t.Errorf("no version: status = %d, warning = %q", w.Code, w.Header().Get(AppVersionWarningHeader))
+	}
+}
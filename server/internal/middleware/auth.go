@@ -9,45 +9,67 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
 )
 
 var (
-	ErrInvalidToken = errors.New("invalid token")
-	ErrExpiredToken = errors.New("token expired")
+	ErrInvalidToken   = errors.New("invalid token")
+	ErrExpiredToken   = errors.New("token expired")
+	ErrWrongTokenType = errors.New("wrong token type")
+)
+
+// Token types
+const (
+	TokenTypeAccess   = "access"
+	TokenTypeTempTOTP = "temp_totp" // pending second factor, never an access token
 )
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID   uuid.UUID `json:"user_id"`
-	Email    string    `json:"email"`
-	DeviceID uuid.UUID `json:"device_id"`
-	IsAdmin  bool      `json:"is_admin"`
+	TokenType string    `json:"token_type"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	DeviceID  uuid.UUID `json:"device_id"`
+	IsAdmin   bool      `json:"is_admin"`
+
+	// Device info carried by temp tokens until the login completes
+	DeviceName  string `json:"device_name,omitempty"`
+	DeviceType  string `json:"device_type,omitempty"`
+	DeviceModel string `json:"device_model,omitempty"`
+	AppVersion  string `json:"app_version,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // JWTMiddleware creates JWT authentication middleware
 func JWTMiddleware(secret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Already authenticated by APIKeyMiddleware
+		if _, ok := c.Get("api_key_id"); ok {
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header required"})
+			apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "authorization header required", nil)
 			c.Abort()
 			return
 		}
 
 		parts := strings.SplitN(authHeader, " ", 2)
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
+			apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid authorization header format", nil)
 			c.Abort()
 			return
 		}
 
-		claims, err := ValidateToken(parts[1], secret)
+		claims, err := ValidateTokenType(parts[1], secret, TokenTypeAccess)
 		if err != nil {
 			if errors.Is(err, ErrExpiredToken) {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "token expired", "code": "TOKEN_EXPIRED"})
+				apierror.RespondError(c, http.StatusUnauthorized, "TOKEN_EXPIRED", "token expired", nil)
 			} else {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+				apierror.RespondError(c, http.StatusUnauthorized, apierror.CodeUnauthorized, "invalid token", nil)
 			}
 			c.Abort()
 			return
@@ -68,7 +90,7 @@ func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		isAdmin, exists := c.Get("is_admin")
 		if !exists || !isAdmin.(bool) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			apierror.RespondError(c, http.StatusForbidden, apierror.CodeForbidden, "admin access required", nil)
 			c.Abort()
 			return
 		}
@@ -79,10 +101,11 @@ func AdminMiddleware() gin.HandlerFunc {
 // GenerateToken generates a new JWT access token
 func GenerateToken(userID uuid.UUID, email string, deviceID uuid.UUID, isAdmin bool, secret string, duration time.Duration) (string, error) {
 	claims := &Claims{
-		UserID:   userID,
-		Email:    email,
-		DeviceID: deviceID,
-		IsAdmin:  isAdmin,
+		TokenType: TokenTypeAccess,
+		UserID:    userID,
+		Email:     email,
+		DeviceID:  deviceID,
+		IsAdmin:   isAdmin,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -91,18 +114,33 @@ func GenerateToken(userID uuid.UUID, email string, deviceID uuid.UUID, isAdmin b
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+	// Signed with the configured private key, if any
+	if keys := signingKeys.Load(); keys != nil && keys.private != nil {
+		token := jwt.NewWithClaims(keys.method, claims)
+		token.Header["kid"] = keys.kid
+		return token.SignedString(keys.private)
+	}
+
+	return SignHS256(claims, secret)
 }
 
-// ValidateToken validates a JWT token and returns claims
+// ValidateToken validates a JWT token and returns claims. HMAC tokens
+// are checked against secret and the configured previous secrets,
+// asymmetric ones against the configured public key. The public key is never used as an HMAC secret.
+// Only the configured algorithms are accepted, and tokens must expire
+// within the configured maximum lifetime.
 func ValidateToken(tokenString string, secret string) (*Claims, error) {
+	keys := signingKeys.Load()
+	policy := currentTokenPolicy()
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			return hmacKeys(secret, token.Header["kid"])
+		}
+		if keys == nil || token.Method.Alg() != keys.method.Alg() || token.Header["kid"] != keys.kid {
 			return nil, ErrInvalidToken
 		}
-		return []byte(secret), nil
-	})
+		return keys.public, nil
+	}, jwt.WithValidMethods(policy.algorithms), jwt.WithExpirationRequired())
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -112,10 +150,30 @@ func ValidateToken(tokenString string, secret string) (*Claims, error) {
 	}
 
 	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
+	if !ok || !token.Valid || !policy.lifetimeAllowed(claims) {
 		return nil, ErrInvalidToken
 	}
 
+	// Once the migration window is closed, access tokens must be signed
+	// with the key pair. Temp tokens never leave the server and stay HMAC.
+	_, hmac := token.Method.(*jwt.SigningMethodHMAC)
+	if hmac && keys != nil && !keys.acceptHS256 && claims.TokenType == TokenTypeAccess {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// ValidateTokenType validates a JWT token and requires it to be of the
+// given type. Tokens without a type predate typed tokens and are rejected.
+func ValidateTokenType(tokenString, secret, tokenType string) (*Claims, error) {
+	claims, err := ValidateToken(tokenString, secret)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != tokenType {
+		return nil, ErrWrongTokenType
+	}
 	return claims, nil
 }
 
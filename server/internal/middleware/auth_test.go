@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -187,6 +188,38 @@ func TestJWTMiddleware_ValidToken(t *testing.T) {
 	}
 }
 
+func TestJWTMiddleware_RejectsNonAccessTokens(t *testing.T) {
+	secret := "test-secret"
+	sign := func(tokenType string) string {
+		claims := &Claims{
+			TokenType: tokenType,
+			UserID:    uuid.New(),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("signing failed: %v", err)
+		}
+		return token
+	}
+
+	r := gin.New()
+	r.Use(JWTMiddleware(secret))
+	r.GET("/test", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tokenType := range []string{TokenTypeTempTOTP, "", "refresh"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Authorization", "Bearer "+sign(tokenType))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("token type %q: status = %d, want %d", tokenType, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
 func TestJWTMiddleware_ExpiredToken(t *testing.T) {
 	secret := "test-secret"
 	token, _ := GenerateToken(uuid.New(), "x@x.com", uuid.New(), false, secret, -time.Hour)
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+)
+
+// BodyLimit caps request bodies at limit bytes, and at largeLimit on the
+// routes under largePrefixes, like vault pushes. A declared length over
+// the cap is answered with 413 at once; a body that turns out longer
+// fails to read, which apierror.RespondBindError also answers with 413.
+// A limit of 0 or less disables the cap.
+func BodyLimit(limit, largeLimit int64, largePrefixes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		max := limit
+		for _, prefix := range largePrefixes {
+			if strings.HasPrefix(c.FullPath(), prefix) {
+				max = largeLimit
+				break
+			}
+		}
+		if max <= 0 || c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > max {
+			apierror.AbortWithError(c, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "request body too large", gin.H{"max_size_bytes": max})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+		c.Next()
+	}
+}
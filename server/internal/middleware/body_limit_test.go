@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+)
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(BodyLimit(64, 256, "/api/v1/vault"))
+	bind := func(c *gin.Context) {
+		var body struct {
+			Data string `json:"data"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			apierror.RespondBindError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+	r.POST("/api/v1/auth/login", bind)
+	r.POST("/api/v1/vault/push", bind)
+
+	body := func(size int) string {
+		return `{"data":"` + strings.Repeat("x", size-len(`{"data":""}`)) + `"}`
+	}
+	for _, tc := range []struct {
+		path    string
+		size    int
+		chunked bool // no Content-Length, so the limit hits while reading
+		want    int
+		max     float64
+	}{
+		{"/api/v1/auth/login", 64, false, http.StatusNoContent, 0},
+		{"/api/v1/auth/login", 65, false, http.StatusRequestEntityTooLarge, 64},
+		{"/api/v1/auth/login", 65, true, http.StatusRequestEntityTooLarge, 64},
+		{"/api/v1/vault/push", 256, true, http.StatusNoContent, 0},
+		{"/api/v1/vault/push", 257, false, http.StatusRequestEntityTooLarge, 256},
+		{"/api/v1/vault/push", 257, true, http.StatusRequestEntityTooLarge, 256},
+	} {
+		var reader io.Reader = strings.NewReader(body(tc.size))
+		if tc.chunked {
+			reader = io.MultiReader(reader)
+		}
+		req := httptest.NewRequest("POST", tc.path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tc.want {
+			t.Errorf("%s %d bytes (chunked %v): status = %d, want %d; body = %s", tc.path, tc.size, tc.chunked, w.Code, tc.want, w.Body)
+			continue
+		}
+		if tc.want != http.StatusRequestEntityTooLarge {
+			continue
+		}
+		var resp struct {
+			Code    string             `json:"code"`
+			Details map[string]float64 `json:"details"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != apierror.CodePayloadTooLarge || resp.Details["max_size_bytes"] != tc.max {
+			t.Errorf("%s %d bytes: body = %s", tc.path, tc.size, w.Body)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+)
+
+// EventClient attaches the client address and user agent to the request
+// context, so every event recorded while serving the request carries them
+func EventClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := events.WithClient(c.Request.Context(), c.ClientIP(), c.Request.UserAgent())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
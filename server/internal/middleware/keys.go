@@ -0,0 +1,293 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// SigningKeys is an optional asymmetric key pair for access tokens. With
+// a private key, access tokens are signed with it instead of the HMAC
+// secret. The public key is published as a JWKS so other services can
+// validate tokens without the secret.
+type SigningKeys struct {
+	private     crypto.Signer // nil: validate only
+	public      crypto.PublicKey
+	method      jwt.SigningMethod
+	kid         string
+	acceptHS256 bool // HMAC-signed access tokens are still accepted
+}
+
+// LoadSigningKeys reads a PEM private and/or public key. RSA keys sign
+// with RS256, Ed25519 keys with EdDSA. It returns nil when both paths are
+// empty. acceptHS256=false ends the migration window: access tokens
+// signed with the HMAC secret are rejected from then on.
+func LoadSigningKeys(privateFile, publicFile string, acceptHS256 bool) (*SigningKeys, error) {
+	if privateFile == "" && publicFile == "" {
+		return nil, nil
+	}
+
+	keys := &SigningKeys{acceptHS256: acceptHS256}
+	if privateFile != "" {
+		block, err := readPEM(privateFile)
+		if err != nil {
+			return nil, err
+		}
+		if keys.private, err = parsePrivateKey(block); err != nil {
+			return nil, fmt.Errorf("%s: %w", privateFile, err)
+		}
+		keys.public = keys.private.Public()
+	}
+	if publicFile != "" {
+		block, err := readPEM(publicFile)
+		if err != nil {
+			return nil, err
+		}
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", publicFile, err)
+		}
+		if keys.public != nil && !keys.public.(interface{ Equal(crypto.PublicKey) bool }).Equal(public) {
+			return nil, errors.New("JWT public key does not match the private key")
+		}
+		keys.public = public
+	}
+
+	switch keys.public.(type) {
+	case *rsa.PublicKey:
+		keys.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		keys.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported JWT key type %T, want RSA or Ed25519", keys.public)
+	}
+	if keys.private == nil && !acceptHS256 {
+		return nil, errors.New("rejecting HS256 tokens requires a JWT private key")
+	}
+
+	jwk := keys.JWK()
+	keys.kid = jwk.Kid
+	return keys, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+// parsePrivateKey accepts PKCS#8 and PKCS#1 RSA keys
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported JWT key type %T, want RSA or Ed25519", key)
+}
+
+// JWK returns the public key as a JSON Web Key. The key ID is its
+// RFC 7638 thumbprint.
+func (k *SigningKeys) JWK() models.JWK {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := models.JWK{Use: "sig", Alg: k.method.Alg()}
+	var members map[string]string
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(public.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(public.E)).Bytes())
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(public)
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+
+	// The thumbprint hashes the required members in lexicographic order;
+	// encoding/json sorts map keys
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	jwk.Kid = b64(sum[:])
+	return jwk
+}
+
+var signingKeys atomic.Pointer[SigningKeys]
+
+// ConfigureSigningKeys sets the key pair used by GenerateToken and
+// ValidateToken at startup. nil signs and validates with the HMAC secret
+// only.
+func ConfigureSigningKeys(k *SigningKeys) {
+	signingKeys.Store(k)
+}
+
+// CurrentSigningKeys returns the configured key pair, if any
+func CurrentSigningKeys() *SigningKeys {
+	return signingKeys.Load()
+}
+
+// hmacSecrets are the HMAC secrets accepted by ValidateToken, newest first
+var hmacSecrets atomic.Pointer[[]string]
+
+// ConfigureSecrets sets the HMAC secrets accepted besides the one passed
+// to ValidateToken. Keeping the previous secret in the list lets tokens
+// signed with it expire naturally after a rotation.
+func ConfigureSecrets(secrets []string) {
+	hmacSecrets.Store(&secrets)
+}
+
+// HMACKeyID identifies an HMAC secret in the kid header without
+// revealing it
+func HMACKeyID(secret string) string {
+	sum := sha256.Sum256([]byte("vibedterm-jwt-kid\x00" + secret))
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+// SignHS256 signs claims with secret and sets the kid header
+func SignHS256(claims jwt.Claims, secret string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = HMACKeyID(secret)
+	return token.SignedString([]byte(secret))
+}
+
+// hmacKeys lists the accepted HMAC secrets for a token. The secret
+// matching kid is tried first, then the others from newest to oldest.
+// Tokens from before key IDs carry no kid; a kid that matches none of
+// the secrets is rejected.
+func hmacKeys(secret string, kid interface{}) (jwt.VerificationKeySet, error) {
+	candidates := []string{secret}
+	if configured := hmacSecrets.Load(); configured != nil {
+		for _, s := range *configured {
+			if s != secret {
+				candidates = append(candidates, s)
+			}
+		}
+	}
+
+	var set jwt.VerificationKeySet
+	for i, s := range candidates {
+		if HMACKeyID(s) == kid {
+			set.Keys = append(set.Keys, []byte(s))
+			candidates = append(candidates[:i:i], candidates[i+1:]...)
+			break
+		}
+	}
+	if kid != nil && len(set.Keys) == 0 {
+		return set, ErrInvalidToken
+	}
+	for _, s := range candidates {
+		set.Keys = append(set.Keys, []byte(s))
+	}
+	return set, nil
+}
+
+// DefaultMaxTokenLifetime caps token lifetimes unless configured otherwise
+const DefaultMaxTokenLifetime = 24 * time.Hour
+
+// supportedAlgorithms are the algorithms ValidateToken can verify
+var supportedAlgorithms = map[string]bool{
+	jwt.SigningMethodHS256.Alg(): true,
+	jwt.SigningMethodHS384.Alg(): true,
+	jwt.SigningMethodHS512.Alg(): true,
+	jwt.SigningMethodRS256.Alg(): true,
+	jwt.SigningMethodEdDSA.Alg(): true,
+}
+
+// tokenPolicy restricts which tokens ValidateToken accepts
+type tokenPolicy struct {
+	algorithms  []string
+	maxLifetime time.Duration
+}
+
+var tokenPolicies atomic.Pointer[tokenPolicy]
+
+// ConfigureTokenValidation sets the signing algorithms ValidateToken
+// accepts and the longest lifetime a token may claim. Empty algorithms
+// default to HS256 plus the algorithm of the configured signing keys, so
+// it must be called after ConfigureSigningKeys. HS256 is always required
+// since temp tokens are signed with the secret.
+func ConfigureTokenValidation(algorithms []string, maxLifetime time.Duration) error {
+	if maxLifetime <= 0 {
+		return errors.New("maximum token lifetime must be positive")
+	}
+	keys := signingKeys.Load()
+	if len(algorithms) == 0 {
+		algorithms = defaultAlgorithms(keys)
+	}
+
+	allowed := make(map[string]bool, len(algorithms))
+	for _, alg := range algorithms {
+		if !supportedAlgorithms[alg] {
+			return fmt.Errorf("unsupported JWT algorithm %q", alg)
+		}
+		allowed[alg] = true
+	}
+	if !allowed[jwt.SigningMethodHS256.Alg()] {
+		return errors.New("JWT algorithms must include HS256, which signs temp tokens")
+	}
+	if keys != nil && !allowed[keys.method.Alg()] {
+		return fmt.Errorf("JWT algorithms must include %s for the configured key", keys.method.Alg())
+	}
+
+	tokenPolicies.Store(&tokenPolicy{algorithms: algorithms, maxLifetime: maxLifetime})
+	return nil
+}
+
+func defaultAlgorithms(keys *SigningKeys) []string {
+	algorithms := []string{jwt.SigningMethodHS256.Alg()}
+	if keys != nil {
+		algorithms = append(algorithms, keys.method.Alg())
+	}
+	return algorithms
+}
+
+// currentTokenPolicy returns the configured policy or the defaults
+func currentTokenPolicy() *tokenPolicy {
+	if policy := tokenPolicies.Load(); policy != nil {
+		return policy
+	}
+	return &tokenPolicy{algorithms: defaultAlgorithms(signingKeys.Load()), maxLifetime: DefaultMaxTokenLifetime}
+}
+
+// lifetimeAllowed rejects tokens that expire further in the future than
+// the server would ever issue, whether counted from now or from iat
+func (p *tokenPolicy) lifetimeAllowed(claims *Claims) bool {
+	expiresAt := claims.ExpiresAt.Time
+	if time.Until(expiresAt) > p.maxLifetime {
+		return false
+	}
+	if claims.IssuedAt != nil && expiresAt.Sub(claims.IssuedAt.Time) > p.maxLifetime {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,405 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// writeKeyPair writes key as PKCS#8 and its public key as PKIX PEM files
+func writeKeyPair(t *testing.T, key crypto.Signer) (privateFile, publicFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateFile = filepath.Join(dir, "private.pem")
+	publicFile = filepath.Join(dir, "public.pem")
+	writePEM(t, privateFile, "PRIVATE KEY", priv)
+	writePEM(t, publicFile, "PUBLIC KEY", pub)
+	return privateFile, publicFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useKeys configures keys for the duration of the test
+func useKeys(t *testing.T, privateFile, publicFile string, acceptHS256 bool) *SigningKeys {
+	t.Helper()
+	keys, err := LoadSigningKeys(privateFile, publicFile, acceptHS256)
+	if err != nil {
+		t.Fatalf("LoadSigningKeys: %v", err)
+	}
+	ConfigureSigningKeys(keys)
+	t.Cleanup(func() { ConfigureSigningKeys(nil) })
+	return keys
+}
+
+func TestLoadSigningKeys_Errors(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaPriv, rsaPub := writeKeyPair(t, rsaKey)
+	edPriv, _ := writeKeyPair(t, edKey)
+	ecPriv, ecPub := writeKeyPair(t, ecKey)
+
+	garbage := filepath.Join(t.TempDir(), "garbage.pem")
+	os.WriteFile(garbage, []byte("not a key"), 0o600)
+
+	if keys, err := LoadSigningKeys("", "", true); keys != nil || err != nil {
+		t.Errorf("no files = %v, %v; want nil, nil", keys, err)
+	}
+
+	tests := []struct {
+		name                string
+		privateFile, public string
+		acceptHS256         bool
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.pem"), "", true},
+		{"not PEM", garbage, "", true},
+		{"public key as private key", rsaPub, "", true},
+		{"private key as public key", "", rsaPriv, true},
+		{"unsupported private key", ecPriv, "", true},
+		{"unsupported public key", "", ecPub, true},
+		{"mismatched pair", edPriv, rsaPub, true},
+		{"validate only without HS256", "", rsaPub, false},
+	}
+	for _, tc := range tests {
+		if _, err := LoadSigningKeys(tc.privateFile, tc.public, tc.acceptHS256); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+
+	// PKCS#1 RSA keys are accepted too
+	pkcs1 := filepath.Join(t.TempDir(), "rsa.pem")
+	writePEM(t, pkcs1, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	if _, err := LoadSigningKeys(pkcs1, rsaPub, false); err != nil {
+		t.Errorf("PKCS#1 key: %v", err)
+	}
+}
+
+func TestSigningKeys_RoundTrip(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, tc := range []struct {
+		alg string
+		key crypto.Signer
+	}{{"RS256", rsaKey}, {"EdDSA", edKey}} {
+		privateFile, publicFile := writeKeyPair(t, tc.key)
+		keys := useKeys(t, privateFile, "", true)
+
+		userID := uuid.New()
+		token, err := GenerateToken(userID, "a@example.com", uuid.New(), false, "secret", time.Hour)
+		if err != nil {
+			t.Fatalf("%s: GenerateToken: %v", tc.alg, err)
+		}
+		parsed, _, _ := jwt.NewParser().ParseUnverified(token, &Claims{})
+		if parsed.Method.Alg() != tc.alg || parsed.Header["kid"] != keys.JWK().Kid {
+			t.Errorf("%s: header = %v", tc.alg, parsed.Header)
+		}
+
+		// A validator holding only the public key accepts the token
+		useKeys(t, "", publicFile, true)
+		claims, err := ValidateTokenType(token, "other-secret", TokenTypeAccess)
+		if err != nil || claims.UserID != userID {
+			t.Errorf("%s: ValidateTokenType = %v, %v", tc.alg, claims, err)
+		}
+	}
+}
+
+func TestSigningKeys_JWK(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	privateFile, _ := writeKeyPair(t, edKey)
+	jwk := useKeys(t, privateFile, "", true).JWK()
+	if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != "EdDSA" || jwk.X == "" || jwk.Kid == "" || jwk.N != "" {
+		t.Errorf("JWK = %+v", jwk)
+	}
+}
+
+func TestSigningKeys_MigrationWindow(t *testing.T) {
+	secret := "test-secret"
+	legacy, _ := GenerateToken(uuid.New(), "a@example.com", uuid.New(), false, secret, time.Hour)
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	privateFile, _ := writeKeyPair(t, edKey)
+
+	useKeys(t, privateFile, "", true)
+	if _, err := ValidateTokenType(legacy, secret, TokenTypeAccess); err != nil {
+		t.Errorf("HS256 token during migration: %v", err)
+	}
+
+	useKeys(t, privateFile, "", false)
+	if _, err := ValidateTokenType(legacy, secret, TokenTypeAccess); err == nil {
+		t.Error("HS256 access token accepted after the migration window")
+	}
+
+	// Temp tokens stay HMAC-signed
+	temp := &Claims{
+		TokenType:        TokenTypeTempTOTP,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+	}
+	tempToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, temp).SignedString([]byte(secret))
+	if _, err := ValidateTokenType(tempToken, secret, TokenTypeTempTOTP); err != nil {
+		t.Errorf("temp token: %v", err)
+	}
+}
+
+func TestSigningKeys_AlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, publicFile := writeKeyPair(t, rsaKey)
+	publicPEM, _ := os.ReadFile(publicFile)
+	useKeys(t, "", publicFile, true)
+
+	claims := func() *Claims {
+		return &Claims{
+			TokenType:        TokenTypeAccess,
+			UserID:           uuid.New(),
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		}
+	}
+
+	// HS256 signed with the public key as the HMAC secret
+	hmacWithPublic, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims()).SignedString(publicPEM)
+	// EdDSA while the configured key is RSA
+	otherAlg, _ := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims()).SignedString(edKey)
+	// RS512 with the right key but an algorithm that is not configured
+	otherHash, _ := jwt.NewWithClaims(jwt.SigningMethodRS512, claims()).SignedString(rsaKey)
+	// Unsigned
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	for name, token := range map[string]string{
+		"HS256 with public key": hmacWithPublic,
+		"EdDSA":                 otherAlg,
+		"RS512":                 otherHash,
+		"none":                  unsigned,
+	} {
+		if _, err := ValidateToken(token, "server-secret"); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	t.Cleanup(func() { ConfigureSecrets(nil) })
+	userID := uuid.New()
+
+	old, _ := GenerateToken(userID, "a@example.com", uuid.New(), false, "old-secret", time.Hour)
+	// Tokens from before key IDs carry no kid
+	noKid, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		TokenType:        TokenTypeAccess,
+		UserID:           userID,
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString([]byte("old-secret"))
+
+	// Rotated: new tokens use the new secret, old ones still validate
+	ConfigureSecrets([]string{"new-secret", "old-secret"})
+	current, _ := GenerateToken(userID, "a@example.com", uuid.New(), false, "new-secret", time.Hour)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(current, &Claims{})
+	if parsed.Header["kid"] != HMACKeyID("new-secret") {
+		t.Errorf("kid = %v, want the new secret's ID", parsed.Header["kid"])
+	}
+	for name, token := range map[string]string{"current": current, "old": old, "no kid": noKid} {
+		if claims, err := ValidateToken(token, "new-secret"); err != nil || claims.UserID != userID {
+			t.Errorf("%s token during rotation: %v", name, err)
+		}
+	}
+
+	// Old secret removed
+	ConfigureSecrets([]string{"new-secret"})
+	if _, err := ValidateToken(old, "new-secret"); err == nil {
+		t.Error("token of removed secret accepted")
+	}
+	if _, err := ValidateToken(current, "new-secret"); err != nil {
+		t.Errorf("current token: %v", err)
+	}
+
+	// Expiry is still reported as such when tried against several secrets
+	ConfigureSecrets([]string{"new-secret", "old-secret"})
+	expired, _ := GenerateToken(userID, "a@example.com", uuid.New(), false, "old-secret", -time.Hour)
+	if _, err := ValidateToken(expired, "new-secret"); err != ErrExpiredToken {
+		t.Errorf("expired old token: %v, want ErrExpiredToken", err)
+	}
+}
+
+// useTokenValidation configures validation for the duration of the test
+func useTokenValidation(t *testing.T, algorithms []string, maxLifetime time.Duration) {
+	t.Helper()
+	if err := ConfigureTokenValidation(algorithms, maxLifetime); err != nil {
+		t.Fatalf("ConfigureTokenValidation: %v", err)
+	}
+	t.Cleanup(func() { tokenPolicies.Store(nil) })
+}
+
+func TestTokenValidation_HMACConfusion(t *testing.T) {
+	secret := "server-secret"
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, publicFile := writeKeyPair(t, rsaKey)
+	publicPEM, _ := os.ReadFile(publicFile)
+
+	claims := func() *Claims {
+		return &Claims{
+			TokenType:        TokenTypeAccess,
+			UserID:           uuid.New(),
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		}
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, kid string) string {
+		token := jwt.NewWithClaims(method, claims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	// Without a key pair, only HS256 with the secret is accepted by default
+	for name, token := range map[string]string{
+		"none":                  sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, ""),
+		"HS256 with public key": sign(jwt.SigningMethodHS256, publicPEM, ""),
+		"HS512 with secret":     sign(jwt.SigningMethodHS512, []byte(secret), HMACKeyID(secret)),
+		"HS384 with secret":     sign(jwt.SigningMethodHS384, []byte(secret), ""),
+		"RS256 without key":     sign(jwt.SigningMethodRS256, rsaKey, ""),
+		"unknown kid":           sign(jwt.SigningMethodHS256, []byte(secret), "not-a-key"),
+	} {
+		if _, err := ValidateToken(token, secret); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// HS512 validates once it is explicitly allowed
+	useTokenValidation(t, []string{"HS256", "HS512"}, 24*time.Hour)
+	if _, err := ValidateToken(sign(jwt.SigningMethodHS512, []byte(secret), HMACKeyID(secret)), secret); err != nil {
+		t.Errorf("allowed HS512 token: %v", err)
+	}
+}
+
+func TestTokenValidation_KeyPairKid(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	privateFile, _ := writeKeyPair(t, edKey)
+	useKeys(t, privateFile, "", true)
+
+	claims := &Claims{
+		TokenType:        TokenTypeAccess,
+		UserID:           uuid.New(),
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}
+	for name, kid := range map[string]interface{}{"missing kid": nil, "foreign kid": "other-key"} {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+		if kid != nil {
+			token.Header["kid"] = kid
+		}
+		signed, _ := token.SignedString(edKey)
+		if _, err := ValidateToken(signed, "server-secret"); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestTokenValidation_Lifetime(t *testing.T) {
+	secret := "server-secret"
+	sign := func(claims *Claims) string {
+		claims.TokenType = TokenTypeAccess
+		signed, err := SignHS256(claims, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	now := time.Now()
+
+	for name, tc := range map[string]struct {
+		claims *Claims
+		valid  bool
+	}{
+		"within cap": {&Claims{RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}}, true},
+		"no expiry": {&Claims{RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt: jwt.NewNumericDate(now),
+		}}, false},
+		"far future": {&Claims{RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(100 * 365 * 24 * time.Hour)),
+		}}, false},
+		"long-lived from iat": {&Claims{RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt: jwt.NewNumericDate(now.Add(-47 * time.Hour)), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}}, false},
+	} {
+		_, err := ValidateToken(sign(tc.claims), secret)
+		if tc.valid && err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// A shorter configured cap rejects tokens the default would accept
+	useTokenValidation(t, nil, 30*time.Minute)
+	token, _ := GenerateToken(uuid.New(), "a@example.com", uuid.New(), false, secret, time.Hour)
+	if _, err := ValidateToken(token, secret); err == nil {
+		t.Error("token beyond the configured lifetime accepted")
+	}
+}
+
+func TestConfigureTokenValidation_Errors(t *testing.T) {
+	t.Cleanup(func() { tokenPolicies.Store(nil) })
+	for name, algorithms := range map[string][]string{
+		"unsupported": {"HS256", "ES256"},
+		"none":        {"HS256", "none"},
+		"no HS256":    {"HS512"},
+	} {
+		if err := ConfigureTokenValidation(algorithms, time.Hour); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if err := ConfigureTokenValidation(nil, 0); err == nil {
+		t.Error("zero lifetime: no error")
+	}
+
+	// The key pair's algorithm must stay allowed
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	privateFile, _ := writeKeyPair(t, edKey)
+	useKeys(t, privateFile, "", true)
+	if err := ConfigureTokenValidation([]string{"HS256", "RS256"}, time.Hour); err == nil {
+		t.Error("list without the key's algorithm: no error")
+	}
+	if err := ConfigureTokenValidation(nil, time.Hour); err != nil {
+		t.Errorf("defaults with key pair: %v", err)
+	}
+}
+
+func TestHMACKeyID(t *testing.T) {
+	if HMACKeyID("a") == HMACKeyID("b") || HMACKeyID("a") != HMACKeyID("a") {
+		t.Error("key IDs must be stable and distinct")
+	}
+	if strings.Contains(HMACKeyID("secret"), "secret") {
+		t.Error("key ID reveals the secret")
+	}
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+)
+
+// bucket is a token bucket for one client
+type bucket struct {
+	tokens    float64
+	last      time.Time
+	throttled bool // a request was rejected since the last allowed one
+}
+
+// RateLimiter limits requests per key (the client IP by default) with a
+// token bucket. Each key may burst up to the limit and refills
+// continuously at that rate.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	rate    float64 // tokens per second
+	burst   float64
+	now     func() time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests per key.
+// A limit <= 0 disables limiting.
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return NewRateLimiterPer(perMinute, time.Minute)
+}
+
+// NewRateLimiterPer creates a limiter allowing limit requests per key in
+// each period. A limit of 1 enforces a minimum interval of period. A
+// limit or period <= 0 disables limiting.
+func NewRateLimiterPer(limit int, period time.Duration) *RateLimiter {
+	if period <= 0 {
+		limit, period = 0, time.Minute
+	}
+	return &RateLimiter{
+		buckets: make(map[string]*bucket),
+		rate:    float64(limit) / period.Seconds(),
+		burst:   float64(limit),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token for key. If none is left it returns false and how
+// long until the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	ok, wait, _ := l.take(key)
+	return ok, wait
+}
+
+// take is Allow that also reports whether a rejection is the first since
+// key was last allowed a request
+func (l *RateLimiter) take(key string) (ok bool, wait time.Duration, first bool) {
+	if l.burst <= 0 {
+		return true, 0, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.last).Seconds()
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.throttled = false
+		return true, 0, false
+	}
+	first = !b.throttled
+	b.throttled = true
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), first
+}
+
+// Cleanup drops buckets that have been idle long enough to be full again,
+// since a fresh bucket behaves the same. It returns the number removed.
+func (l *RateLimiter) Cleanup() int {
+	if l.burst <= 0 {
+		return 0
+	}
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	removed := 0
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of tracked keys
+func (l *RateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Run calls Cleanup every interval until ctx is cancelled
+func (l *RateLimiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Cleanup()
+		}
+	}
+}
+
+// Middleware limits requests per client IP. Rejected requests get 429
+// with a Retry-After header.
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, wait := l.Allow(c.ClientIP())
+		if !ok {
+			abortRateLimited(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// PerUser limits requests per authenticated user, so a client stuck in a
+// loop is stopped whatever its address. It must run after the auth
+// middleware. onThrottle, if set, is called for the first rejected
+// request after the user was last allowed one.
+func (l *RateLimiter) PerUser(onThrottle func(c *gin.Context, userID uuid.UUID)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserID(c)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		ok, wait, first := l.take(userID.String())
+		if !ok {
+			if first && onThrottle != nil {
+				onThrottle(c, userID)
+			}
+			abortRateLimited(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// abortRateLimited rejects a request with 429 and a Retry-After header
+func abortRateLimited(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	apierror.AbortWithError(c, http.StatusTooManyRequests, apierror.CodeRateLimited, "too many requests", nil)
+}
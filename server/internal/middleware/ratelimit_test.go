@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func newTestLimiter(perMinute int) (*RateLimiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(perMinute)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestRateLimiterBurstThenRefill(t *testing.T) {
+	l, now := newTestLimiter(60) // one token per second
+
+	for i := range 60 {
+		if ok, _ := l.Allow("1.2.3.4"); !ok {
+			t.Fatalf("request %d within burst was rejected", i+1)
+		}
+	}
+	ok, wait := l.Allow("1.2.3.4")
+	if ok {
+		t.Fatal("request beyond burst was allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("retry after %v, want (0, 1s]", wait)
+	}
+
+	// Other clients have their own bucket
+	if ok, _ := l.Allow("5.6.7.8"); !ok {
+		t.Error("second client was limited by the first")
+	}
+
+	*now = now.Add(time.Second)
+	if ok, _ := l.Allow("1.2.3.4"); !ok {
+		t.Error("request after refill was rejected")
+	}
+	if ok, _ := l.Allow("1.2.3.4"); ok {
+		t.Error("refill granted more than one token per second")
+	}
+}
+
+func TestRateLimiterCleanupExpiresIdleBuckets(t *testing.T) {
+	l, now := newTestLimiter(60)
+	l.Allow("idle")
+	*now = now.Add(30 * time.Second)
+	l.Allow("active")
+
+	// idle has been unused for a full refill period, active has not
+	*now = now.Add(30 * time.Second)
+	if removed := l.Cleanup(); removed != 1 {
+		t.Fatalf("Cleanup removed %d buckets, want 1", removed)
+	}
+	if l.Len() != 1 {
+		t.Errorf("Len = %d, want 1", l.Len())
+	}
+
+	*now = now.Add(time.Minute)
+	l.Cleanup()
+	if l.Len() != 0 {
+		t.Errorf("Len = %d after all buckets expired, want 0", l.Len())
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l, _ := newTestLimiter(0)
+	for range 1000 {
+		if ok, _ := l.Allow("1.2.3.4"); !ok {
+			t.Fatal("disabled limiter rejected a request")
+		}
+	}
+	if l.Len() != 0 {
+		t.Error("disabled limiter tracked clients")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, _ := newTestLimiter(2)
+	r := gin.New()
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	api := r.Group("/api", l.Middleware())
+	api.GET("", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for range 2 {
+		if w := get("/api"); w.Code != http.StatusOK {
+			t.Fatalf("status %d within limit", w.Code)
+		}
+	}
+
+	w := get("/api")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "30" {
+		t.Errorf("Retry-After = %q, want 30", w.Header().Get("Retry-After"))
+	}
+	var resp models.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != "RATE_LIMITED" {
+		t.Errorf("body = %s", w.Body.String())
+	}
+
+	for range 5 {
+		if w := get("/health"); w.Code != http.StatusOK {
+			t.Fatalf("/health outside the limited group got status %d", w.Code)
+		}
+	}
+}
+
+func TestRateLimiterPerPeriod(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiterPer(1, 5*time.Minute)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.Allow("user"); !ok {
+		t.Fatal("first request was rejected")
+	}
+	ok, wait := l.Allow("user")
+	if ok || wait != 5*time.Minute {
+		t.Fatalf("second request: ok = %v, wait = %v, want rejected for 5m", ok, wait)
+	}
+	now = now.Add(5 * time.Minute)
+	if ok, _ := l.Allow("user"); !ok {
+		t.Error("request after the interval was rejected")
+	}
+
+	if ok, _ := NewRateLimiterPer(1, 0).Allow("user"); !ok {
+		t.Error("limiter without a period rejected a request")
+	}
+}
+
+func TestRateLimitPerUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, now := newTestLimiter(1)
+	userID := uuid.New()
+	var throttled int
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", userID) })
+	r.POST("/push", l.PerUser(func(_ *gin.Context, id uuid.UUID) {
+		if id != userID {
+			t.Errorf("throttled user %s, want %s", id, userID)
+		}
+		throttled++
+	}), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	push := func(addr string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/push", nil)
+		req.RemoteAddr = addr
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := push("192.0.2.1:1234"); code != http.StatusOK {
+		t.Fatalf("status %d within limit", code)
+	}
+	for range 3 {
+		if code := push("192.0.2.2:1234"); code != http.StatusTooManyRequests {
+			t.Fatalf("status %d from another address, want 429", code)
+		}
+	}
+	if throttled != 1 {
+		t.Errorf("onThrottle called %d times, want once per episode", throttled)
+	}
+
+	*now = now.Add(time.Minute)
+	push("192.0.2.1:1234")
+	push("192.0.2.1:1234")
+	if throttled != 2 {
+		t.Errorf("onThrottle called %d times after a new episode, want 2", throttled)
+	}
+}
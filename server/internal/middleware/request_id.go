@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// maxRequestIDLength bounds request IDs taken from clients
+const maxRequestIDLength = 64
+
+// RequestID tags every request with an ID: the client's X-Request-ID if it
+// is usable, otherwise a new UUID. The ID is echoed in the response header
+// and carried by the logger that Logger returns, so all log lines of a
+// request can be found from a client report.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(models.RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Header(models.RequestIDHeader, id)
+
+		logger := log.With().Str("request_id", id).Logger()
+		c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context()))
+		c.Next()
+	}
+}
+
+// validRequestID accepts IDs of letters, digits, dots, dashes and
+// underscores, so a client cannot forge log fields or headers with it
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// GetRequestID returns the ID of the request, empty outside RequestID
+func GetRequestID(c *gin.Context) string {
+	return c.Writer.Header().Get(models.RequestIDHeader)
+}
+
+// Logger returns the logger of the request, which tags each line with the
+// request ID. Outside RequestID it is the global logger.
+func Logger(c *gin.Context) *zerolog.Logger {
+	if logger := zerolog.Ctx(c.Request.Context()); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return &log.Logger
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestRequestID(t *testing.T) {
+	var logged bytes.Buffer
+	global := log.Logger
+	log.Logger = zerolog.New(&logged)
+	t.Cleanup(func() { log.Logger = global })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/fail", func(c *gin.Context) {
+		Logger(c).Error().Msg("failed")
+		apierror.RespondError(c, http.StatusInternalServerError, "", "failed", nil)
+	})
+
+	for _, tc := range []struct {
+		incoming string
+		kept     bool
+	}{
+		{"sync-1432_abc.7", true},
+		{"", false},
+		{"two words", false},
+		{"forged\nlevel=info", false},
+		{strings.Repeat("x", maxRequestIDLength+1), false},
+	} {
+		logged.Reset()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/fail", nil)
+		req.Header.Set(models.RequestIDHeader, tc.incoming)
+		r.ServeHTTP(w, req)
+
+		id := w.Header().Get(models.RequestIDHeader)
+		if tc.kept && id != tc.incoming {
+			t.Errorf("%q: request ID = %q, want it kept", tc.incoming, id)
+		}
+		if !tc.kept && uuid.Validate(id) != nil {
+			t.Errorf("%q: request ID = %q, want a new UUID", tc.incoming, id)
+		}
+
+		var resp models.ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.RequestID != id {
+			t.Errorf("%q: error body = %s, want request_id %q", tc.incoming, w.Body, id)
+		}
+		var line map[string]any
+		if err := json.Unmarshal(logged.Bytes(), &line); err != nil || line["request_id"] != id {
+			t.Errorf("%q: log line = %s, want request_id %q", tc.incoming, logged.String(), id)
+		}
+	}
+}
+
+func TestLogger_OutsideRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	if Logger(c) != &log.Logger {
+		t.Error("Logger without RequestID is not the global logger")
+	}
+	if GetRequestID(c) != "" {
+		t.Errorf("GetRequestID = %q, want empty", GetRequestID(c))
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/apierror"
+)
+
+// RequireJSON rejects requests that carry a body in anything but JSON with
+// 415, before a handler tries to bind it. Requests without a body pass,
+// and the routes in binaryRoutes also take application/octet-stream.
+func RequireJSON(binaryRoutes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+		// ContentLength is -1 for a chunked body of unknown length
+		if c.Request.ContentLength == 0 {
+			c.Next()
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if err == nil && mediaType == "application/octet-stream" && slices.Contains(binaryRoutes, c.FullPath()) {
+			c.Next()
+			return
+		}
+		if err != nil || mediaType != "application/json" {
+			apierror.AbortWithError(c, http.StatusUnsupportedMediaType, apierror.CodeUnsupportedMediaType, "Content-Type must be application/json", nil)
+			return
+		}
+		c.Next()
+	}
+}
+
+// StrictRouting answers a path with a stray or missing trailing slash with
+// 404 instead of redirecting to the registered route, so clients never
+// follow a redirect with their credentials and every path has one answer
+func StrictRouting(r *gin.Engine) {
+	r.RedirectTrailingSlash = false
+	r.RedirectFixedPath = false
+}
+
+// HeadAsGet serves HEAD requests with the GET route of the path. Status and
+// headers are those of the GET response; the body is discarded.
+func HeadAsGet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// Clone, so the server still sees the HEAD request it answers
+			r = r.Clone(r.Context())
+			r.Method = http.MethodGet
+			w = headWriter{w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headWriter drops the body of a response to a HEAD request
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w headWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
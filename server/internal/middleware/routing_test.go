@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func newRoutingTestHandler() http.Handler {
+	r := gin.New()
+	StrictRouting(r)
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	v1 := r.Group("/api/v1")
+	v1.Use(RequireJSON("/api/v1/vault/blob"))
+	v1.Use(JWTMiddleware("test-secret"))
+	v1.GET("/vault/status", func(c *gin.Context) {
+		c.Header("X-Vault-Revision", "3")
+		c.JSON(http.StatusOK, gin.H{"has_vault": true})
+	})
+	v1.POST("/vault/push", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	v1.PUT("/vault/blob", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	v1.POST("/auth/logout", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return HeadAsGet(r)
+}
+
+func serveRouting(t *testing.T, h http.Handler, method, path, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := GenerateToken(uuid.New(), "user@example.com", uuid.New(), false, "test-secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, path, nil)
+	} else {
+		req = httptest.NewRequest(method, path, strings.NewReader(body))
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireJSON(t *testing.T) {
+	h := newRoutingTestHandler()
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"json", http.MethodPost, "/api/v1/vault/push", "application/json", `{}`, http.StatusOK},
+		{"json with charset", http.MethodPost, "/api/v1/vault/push", "application/json; charset=utf-8", `{}`, http.StatusOK},
+		{"form post", http.MethodPost, "/api/v1/vault/push", "application/x-www-form-urlencoded", "a=b", http.StatusUnsupportedMediaType},
+		{"no content type", http.MethodPost, "/api/v1/vault/push", "", `{}`, http.StatusUnsupportedMediaType},
+		{"garbage content type", http.MethodPost, "/api/v1/vault/push", ";;", `{}`, http.StatusUnsupportedMediaType},
+		{"empty body", http.MethodPost, "/api/v1/auth/logout", "", "", http.StatusOK},
+		{"binary route", http.MethodPut, "/api/v1/vault/blob", "application/octet-stream", "\x00\x01", http.StatusOK},
+		{"binary elsewhere", http.MethodPost, "/api/v1/vault/push", "application/octet-stream", "\x00\x01", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRouting(t, h, tt.method, tt.path, tt.contentType, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusUnsupportedMediaType && !strings.Contains(w.Body.String(), `"code":"UNSUPPORTED_MEDIA_TYPE"`) {
+				t.Errorf("body = %s, want the UNSUPPORTED_MEDIA_TYPE code", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHeadAsGet(t *testing.T) {
+	h := newRoutingTestHandler()
+
+	for _, path := range []string{"/health", "/api/v1/vault/status"} {
+		w := serveRouting(t, h, http.MethodHead, path, "", "")
+		if w.Code != http.StatusOK {
+			t.Errorf("HEAD %s = %d, want 200", path, w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("HEAD %s sent a body: %s", path, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+			t.Errorf("HEAD %s Content-Type = %q, want the GET headers", path, got)
+		}
+	}
+	if got := serveRouting(t, h, http.MethodHead, "/api/v1/vault/status", "", "").Header().Get("X-Vault-Revision"); got != "3" {
+		t.Errorf("X-Vault-Revision = %q, want 3", got)
+	}
+
+	// HEAD still needs the credentials GET needs
+	req := httptest.NewRequest(http.MethodHead, "/api/v1/vault/status", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous HEAD = %d, want 401", w.Code)
+	}
+}
+
+func TestStrictRouting_TrailingSlash(t *testing.T) {
+	h := newRoutingTestHandler()
+
+	if w := serveRouting(t, h, http.MethodGet, "/api/v1/vault/status", "", ""); w.Code != http.StatusOK {
+		t.Errorf("GET without slash = %d, want 200", w.Code)
+	}
+	w := serveRouting(t, h, http.MethodGet, "/api/v1/vault/status/", "", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET with slash = %d, want 404", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "" {
+		t.Errorf("redirected to %q", loc)
+	}
+	if w := serveRouting(t, h, http.MethodPost, "/api/v1/vault/push/", "application/json", `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("POST with slash = %d, want 404", w.Code)
+	}
+}
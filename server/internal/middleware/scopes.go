@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Authentication requirements for API routes
+const (
+	AuthPublic = "public"
+	AuthUser   = "user"
+	AuthAdmin  = "admin"
+)
+
+// Scopes grouping API routes by the kind of access they need
+const (
+	ScopeAccount    = "account"
+	ScopeVaultRead  = "vault:read"
+	ScopeVaultWrite = "vault:write"
+	ScopeDevices    = "devices"
+	ScopeAdmin      = "admin"
+)
+
+// GrantedScopes lists the scopes of a user's access tokens. Tokens are
+// not narrowed yet, so users get every scope but admin. API keys carry
+// the scopes chosen at their creation instead.
+func GrantedScopes(isAdmin bool) []string {
+	scopes := []string{ScopeAccount, ScopeVaultRead, ScopeVaultWrite, ScopeDevices}
+	if isAdmin {
+		scopes = append(scopes, ScopeAdmin)
+	}
+	return scopes
+}
+
+// RouteRule annotates all routes below a path prefix
+type RouteRule struct {
+	Method string // empty matches any method
+	Prefix string
+	Auth   string
+	Scope  string
+}
+
+// RouteTable resolves the auth requirement and scope of a route.
+// The rule with the longest matching prefix wins; at equal length a
+// method-specific rule beats a method-agnostic one.
+type RouteTable struct {
+	rules []RouteRule
+}
+
+// NewRouteTable creates a route table from the given rules
+func NewRouteTable(rules ...RouteRule) *RouteTable {
+	return &RouteTable{rules: rules}
+}
+
+// Lookup returns the annotation for a route path template.
+// ok is false if no rule covers the route.
+func (t *RouteTable) Lookup(method, path string) (rule RouteRule, ok bool) {
+	for _, r := range t.rules {
+		if r.Method != "" && r.Method != method {
+			continue
+		}
+		if path != r.Prefix && !strings.HasPrefix(path, r.Prefix) {
+			continue
+		}
+		if ok {
+			if len(r.Prefix) < len(rule.Prefix) {
+				continue
+			}
+			if len(r.Prefix) == len(rule.Prefix) && r.Method == "" {
+				continue
+			}
+		}
+		rule, ok = r, true
+	}
+	return rule, ok
+}
+
+// APIRouteTable returns the annotations for the JSON API.
+// routesPublic controls the visibility of the route listing itself.
+// There is no catch-all: a route without a rule is left out of the
+// listing and refused to API keys.
+func APIRouteTable(routesPublic bool) *RouteTable {
+	routesAuth, routesScope := AuthAdmin, ScopeAdmin
+	if routesPublic {
+		routesAuth, routesScope = AuthPublic, ""
+	}
+
+	return NewRouteTable(
+		RouteRule{Prefix: "/health", Auth: AuthPublic},
+		RouteRule{Prefix: "/.well-known/", Auth: AuthPublic},
+		RouteRule{Prefix: "/api/v1/auth/", Auth: AuthPublic},
+		RouteRule{Prefix: "/api/v1/bootstrap", Auth: AuthPublic},
+		RouteRule{Prefix: "/api/v1/capabilities", Auth: AuthPublic},
+		RouteRule{Prefix: "/api/v1/auth/logout-all", Auth: AuthUser, Scope: ScopeAccount},
+		RouteRule{Prefix: "/api/v1/auth/sessions", Auth: AuthUser, Scope: ScopeAccount},
+		RouteRule{Prefix: "/api/v1/auth/trusted-devices", Auth: AuthUser, Scope: ScopeAccount},
+		RouteRule{Prefix: "/api/v1/auth/events", Auth: AuthUser, Scope: ScopeAccount},
+		RouteRule{Prefix: "/api/v1/apikeys", Auth: AuthUser, Scope: ScopeAccount},
+		RouteRule{Prefix: "/api/v1/totp/", Auth: AuthUser, Scope: ScopeAccount},
+		RouteRule{Prefix: "/api/v1/webauthn/", Auth: AuthUser, Scope: ScopeAccount},
+		RouteRule{Prefix: "/api/v1/webauthn/login/", Auth: AuthPublic},
+		RouteRule{Method: http.MethodGet, Prefix: "/api/v1/vault/", Auth: AuthUser, Scope: ScopeVaultRead},
+		RouteRule{Prefix: "/api/v1/vault", Auth: AuthUser, Scope: ScopeVaultWrite}, // includes DELETE /vault
+		RouteRule{Method: http.MethodGet, Prefix: "/api/v1/settings-blob", Auth: AuthUser, Scope: ScopeVaultRead},
+		RouteRule{Prefix: "/api/v1/settings-blob", Auth: AuthUser, Scope: ScopeVaultWrite},
+		RouteRule{Prefix: "/api/v1/devices", Auth: AuthUser, Scope: ScopeDevices},
+		RouteRule{Prefix: "/api/v1/admin/", Auth: AuthAdmin, Scope: ScopeAdmin},
+		RouteRule{Prefix: "/api/v1/routes", Auth: routesAuth, Scope: routesScope},
+	)
+}
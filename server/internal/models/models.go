@@ -1,6 +1,14 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,18 +16,59 @@ import (
 
 // User represents a registered user
 type User struct {
-	ID           uuid.UUID  `json:"id"`
-	Email        string     `json:"email"`
-	PasswordHash string     `json:"-"`
-	IsApproved   bool       `json:"is_approved"`
-	IsAdmin      bool       `json:"is_admin"`
-	IsBlocked    bool       `json:"is_blocked"`
-	TOTPSecret   []byte     `json:"-"`
-	TOTPEnabled  bool       `json:"totp_enabled"`
-	TOTPVerified *time.Time `json:"-"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
-	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	ID                  uuid.UUID  `json:"id"`
+	Email               string     `json:"email"`
+	PasswordHash        string     `json:"-"`
+	EmailVerified       bool       `json:"email_verified"`
+	IsApproved          bool       `json:"is_approved"`
+	IsAdmin             bool       `json:"is_admin"`
+	IsBlocked           bool       `json:"is_blocked"`
+	ApprovedAt          *time.Time `json:"approved_at,omitempty"`
+	ApprovedBy          *uuid.UUID `json:"approved_by,omitempty"`
+	BlockedAt           *time.Time `json:"blocked_at,omitempty"`
+	BlockedReason       string     `json:"blocked_reason,omitempty"`
+	DisplayName         string     `json:"display_name,omitempty"`
+	RegistrationMessage string     `json:"-"` // the registrant's note to the admins
+	TOTPSecret          []byte     `json:"-"`
+	TOTPEnabled         bool       `json:"totp_enabled"`
+	TOTPVerified        *time.Time `json:"-"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	LastLoginAt         *time.Time `json:"last_login_at,omitempty"`
+}
+
+// InactiveUser is an account considered by the inactivity cleanup
+type InactiveUser struct {
+	ID           uuid.UUID
+	Email        string
+	LastActiveAt time.Time  // last login, or approval if never logged in
+	WarnedAt     *time.Time // when the inactivity warning was sent
+	HasVault     bool
+}
+
+// Plausible size of a stored TOTP secret in bytes. RFC 4226 requires at
+// least 128 bits; Setup generates 160.
+const (
+	MinTOTPSecretSize = 16
+	MaxTOTPSecretSize = 64
+)
+
+// BrokenTOTPSecret is an account with two-factor login enabled whose
+// stored secret cannot be right, e.g. one emptied by a failed decode
+type BrokenTOTPSecret struct {
+	ID         uuid.UUID
+	Email      string
+	SecretSize int // bytes
+}
+
+// EmailVerificationToken is a pending proof of email ownership. Only the
+// hash of the token sent to the user is stored.
+type EmailVerificationToken struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	TokenHash string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Device represents a registered app instance
@@ -31,31 +80,250 @@ type Device struct {
 	DeviceModel string     `json:"device_model,omitempty"`
 	AppVersion  string     `json:"app_version,omitempty"`
 	LastSyncAt  *time.Time `json:"last_sync_at,omitempty"`
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// Client the device last logged in, refreshed or synced from. LastIP
+	// is empty if the server does not record addresses.
+	LastIP        string `json:"last_ip,omitempty"`
+	LastUserAgent string `json:"last_user_agent,omitempty"`
+
+	LastSeenRevision *int `json:"last_seen_revision,omitempty"` // last vault revision pulled or pushed
+	BehindBy         *int `json:"behind_by,omitempty"`          // revisions behind the vault; not stored, nil without a vault
+
+	ReadOnly   bool `json:"read_only"`   // the device may pull vaults but not push them
+	IsApproved bool `json:"is_approved"` // false while the device awaits approval
+}
+
+// AppVersionHeader carries the app version of the client on API requests
+const AppVersionHeader = "X-App-Version"
+
+// RequestIDHeader carries the ID of a request, sent by the client or
+// assigned by the server, in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// AppVersionCount is the number of devices reporting one app version
+type AppVersionCount struct {
+	Version  string `json:"version"` // empty for devices that never reported one
+	Devices  int    `json:"devices"`
+	Outdated bool   `json:"outdated"` // below the minimum supported version
+}
+
+// CompareAppVersions compares dotted numeric versions such as "1.4.2" or
+// "v2.0.0-beta.1". A leading v and any pre-release or build suffix are
+// ignored and missing components count as 0. ok is false if either
+// version does not parse.
+func CompareAppVersions(a, b string) (cmp int, ok bool) {
+	pa, okA := parseAppVersion(a)
+	pb, okB := parseAppVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+func parseAppVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil, false
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
 }
 
-// EncryptedVault represents the user's encrypted vault blob
+// AppVersionPolicy holds the oldest app versions the server still serves.
+// Empty versions disable the respective check.
+type AppVersionPolicy struct {
+	MinSupported string // older clients are warned
+	MinRequired  string // older clients are rejected
+}
+
+// Check returns a warning for versions below MinSupported and false for
+// versions below MinRequired. Clients that send no version, or one that
+// does not parse, pass without a warning.
+func (p AppVersionPolicy) Check(version string) (warning string, ok bool) {
+	if version == "" {
+		return "", true
+	}
+	if cmp, valid := CompareAppVersions(version, p.MinRequired); valid && cmp < 0 {
+		return "", false
+	}
+	if cmp, valid := CompareAppVersions(version, p.MinSupported); valid && cmp < 0 {
+		return "app version " + version + " is outdated, please update to " + p.MinSupported + " or later", true
+	}
+	return "", true
+}
+
+// MarkOutdated flags the counts of versions the policy warns about or
+// rejects
+func (p AppVersionPolicy) MarkOutdated(counts []AppVersionCount) {
+	for i := range counts {
+		warning, ok := p.Check(counts[i].Version)
+		counts[i].Outdated = warning != "" || !ok
+	}
+}
+
+// DefaultVaultName is the vault of clients that do not name one
+const DefaultVaultName = "default"
+
+// maxVaultNameLength is the longest vault name in bytes
+const maxVaultNameLength = 64
+
+// ValidVaultName reports whether name may name a vault: lowercase letters,
+// digits, dots, dashes and underscores, starting with a letter or digit
+func ValidVaultName(name string) bool {
+	if name == "" || len(name) > maxVaultNameLength {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case i > 0 && (c == '.' || c == '-' || c == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// EncryptedVault represents one of the user's encrypted vault blobs
 type EncryptedVault struct {
 	ID              uuid.UUID  `json:"id"`
 	UserID          uuid.UUID  `json:"user_id"`
+	Name            string     `json:"name"`
 	VaultBlob       []byte     `json:"vault_blob"`
 	Revision        int        `json:"revision"`
 	VaultVersion    int        `json:"vault_version"`
 	UpdatedByDevice *uuid.UUID `json:"updated_by_device,omitempty"`
+	Checksum        string     `json:"checksum"` // see BlobChecksum
+	UpdatedAt       time.Time  `json:"updated_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"` // set while soft-deleted
+}
+
+// BlobChecksum is the hex SHA-256 of a vault blob as stored. It detects
+// corruption in transit or storage; it does not authenticate the blob.
+func BlobChecksum(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return hex.EncodeToString(sum[:])
+}
+
+// VaultBlobStream reads the blob of one vault revision without holding
+// all of it in memory. Vault has the metadata and an empty VaultBlob.
+type VaultBlobStream struct {
+	Vault     EncryptedVault
+	SizeBytes int64
+	io.ReadCloser
+}
+
+// VaultMetadata is a vault without its blob. Vault has an empty
+// VaultBlob.
+type VaultMetadata struct {
+	Vault     EncryptedVault
+	SizeBytes int
+}
+
+// ClientSettings is the encrypted app preferences blob synced next to the
+// vault with its own revision counter
+type ClientSettings struct {
+	ID              uuid.UUID  `json:"id"`
+	UserID          uuid.UUID  `json:"user_id"`
+	SettingsBlob    []byte     `json:"settings_blob"`
+	Revision        int        `json:"revision"`
+	UpdatedByDevice *uuid.UUID `json:"updated_by_device,omitempty"`
 	UpdatedAt       time.Time  `json:"updated_at"`
 	CreatedAt       time.Time  `json:"created_at"`
 }
 
+// Vault history snapshot reasons
+const (
+	VaultHistoryTransferOverwritten = "transfer_overwritten"
+	VaultHistoryPushed              = "push"
+	VaultHistoryForceOverwritten    = "force_overwrite"
+	VaultHistoryRestored            = "restore"
+	VaultHistoryDeleted             = "deleted"
+)
+
+// VaultRevision describes an earlier vault kept in the history
+type VaultRevision struct {
+	Revision     int        `json:"revision"`
+	VaultVersion int        `json:"vault_version"`
+	SizeBytes    int        `json:"size_bytes"`
+	DeviceID     *uuid.UUID `json:"device_id,omitempty"` // device that wrote the revision
+	Reason       string     `json:"reason"`              // what replaced it
+	ReplacedAt   time.Time  `json:"replaced_at"`
+}
+
+// VaultTransferOptions controls how a vault moves between accounts
+type VaultTransferOptions struct {
+	Copy            bool // keep the source vault instead of moving it
+	Overwrite       bool // replace an existing target vault
+	IncludeSyncLogs bool // carry the sync log over as well
+}
+
 // RefreshToken for JWT refresh
 type RefreshToken struct {
-	ID        uuid.UUID `json:"id"`
-	UserID    uuid.UUID `json:"user_id"`
-	DeviceID  uuid.UUID `json:"device_id"`
-	TokenHash string    `json:"-"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Revoked   bool      `json:"revoked"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	DeviceID   uuid.UUID  `json:"device_id"`
+	FamilyID   uuid.UUID  `json:"family_id"` // first token of the rotation chain
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Revoked    bool       `json:"revoked"`
+	ReplacedBy *uuid.UUID `json:"replaced_by,omitempty"` // set once rotated
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Session is an active refresh token chain as listed to its user
+type Session struct {
+	ID            uuid.UUID `json:"id"`              // rotation chain ID, stable across refreshes
+	AuthSessionID uuid.UUID `json:"auth_session_id"` // same as ID, as returned by login and refresh
+	DeviceID      uuid.UUID `json:"device_id"`
+	DeviceName    string    `json:"device_name"`
+	DeviceType    string    `json:"device_type"`
+	CreatedAt     time.Time `json:"created_at"` // login that started the session
+	ExpiresAt     time.Time `json:"expires_at"`
+	LastUsedAt    time.Time `json:"last_used_at"` // login or latest refresh
+	Current       bool      `json:"current"`      // belongs to the caller's device
+}
+
+// TrustedDevice is a device or browser that may skip the second factor
+// until ExpiresAt
+type TrustedDevice struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"-"`
+	DeviceID   *uuid.UUID `json:"device_id,omitempty"` // nil for web browsers
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 // RecoveryCode for 2FA recovery
@@ -68,31 +336,395 @@ type RecoveryCode struct {
 	CreatedAt time.Time  `json:"created_at"`
 }
 
-// SyncLog for audit trail
+// WebAuthnCredential is a passkey or security key registered as second
+// factor
+type WebAuthnCredential struct {
+	ID              uuid.UUID  `json:"id"`
+	UserID          uuid.UUID  `json:"user_id"`
+	Name            string     `json:"name"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Transports      []string   `json:"transports"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"` // synced to other devices, as with passkeys
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// WebAuthn ceremonies
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+)
+
+// WebAuthnChallenge is the server state of a ceremony between its begin
+// and finish call
+type WebAuthnChallenge struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Ceremony    string
+	SessionData []byte // JSON encoded session data of the WebAuthn library
+	ExpiresAt   time.Time
+}
+
+// APIKey for headless and scripted clients
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Revoked    bool       `json:"revoked"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateAPIKeyRequest is the body of POST /apikeys. Access is "read" or
+// "read_write" and defaults to "read".
+type CreateAPIKeyRequest struct {
+	Name   string `json:"name" binding:"required" input:"name"`
+	Access string `json:"access,omitempty" input:"token"`
+}
+
+// CreateAPIKeyResponse returns a new API key with its secret
+type CreateAPIKeyResponse struct {
+	Key    string  `json:"key"` // shown only once
+	APIKey *APIKey `json:"api_key"`
+}
+
+// SyncLog for audit trail. Entries of a user form a hash chain: Seq
+// counts them from 1 and EntryHash covers the entry and PrevHash, the
+// EntryHash of its predecessor. Entries written before the chain existed
+// have Seq 0 until the user's next entry chains them.
 type SyncLog struct {
 	ID             uuid.UUID  `json:"id"`
 	UserID         uuid.UUID  `json:"user_id"`
 	DeviceID       *uuid.UUID `json:"device_id,omitempty"`
+	Vault          string     `json:"vault"` // name of the vault touched
 	Action         string     `json:"action"`
 	RevisionBefore *int       `json:"revision_before,omitempty"`
 	RevisionAfter  *int       `json:"revision_after,omitempty"`
+	VersionBefore  *int       `json:"version_before,omitempty"` // vault versions, only on version_change
+	VersionAfter   *int       `json:"version_after,omitempty"`
 	CreatedAt      time.Time  `json:"created_at"`
+	Seq            int64      `json:"seq,omitempty"`
+	PrevHash       string     `json:"prev_hash,omitempty"`
+	EntryHash      string     `json:"entry_hash,omitempty"`
+}
+
+// NewSyncLog returns an unchained entry logging action on the named vault
+func NewSyncLog(userID uuid.UUID, deviceID *uuid.UUID, vault, action string, revisionBefore, revisionAfter *int) *SyncLog {
+	return &SyncLog{
+		ID:             uuid.New(),
+		UserID:         userID,
+		DeviceID:       deviceID,
+		Vault:          vault,
+		Action:         action,
+		RevisionBefore: revisionBefore,
+		RevisionAfter:  revisionAfter,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// NewVersionChangeLog returns an unchained entry logging that the named
+// vault changed from vault version from to version to at revision
+func NewVersionChangeLog(userID uuid.UUID, deviceID *uuid.UUID, vault string, revision, from, to int) *SyncLog {
+	log := NewSyncLog(userID, deviceID, vault, "version_change", nil, &revision)
+	log.VersionBefore, log.VersionAfter = &from, &to
+	return log
+}
+
+// syncLogTimeLayout is the stored precision of CreatedAt, without zone
+const syncLogTimeLayout = "2006-01-02T15:04:05.000000"
+
+// ComputeHash returns the hex SHA-256 of PrevHash and the canonical entry
+// fields, one per line: seq, id, user_id, device_id, action,
+// revision_before, revision_after and created_at with microseconds and no
+// zone, then the vault name unless it is the default vault, so entries
+// from before named vaults keep their hash, and "version:<before>-><after>"
+// if the entry records a vault version change. Absent values are empty
+// lines.
+func (l *SyncLog) ComputeHash() string {
+	optional := func(n *int) string {
+		if n == nil {
+			return ""
+		}
+		return strconv.Itoa(*n)
+	}
+	deviceID := ""
+	if l.DeviceID != nil {
+		deviceID = l.DeviceID.String()
+	}
+
+	fields := []string{
+		l.PrevHash,
+		strconv.FormatInt(l.Seq, 10),
+		l.ID.String(),
+		l.UserID.String(),
+		deviceID,
+		l.Action,
+		optional(l.RevisionBefore),
+		optional(l.RevisionAfter),
+		l.CreatedAt.Format(syncLogTimeLayout),
+	}
+	if l.Vault != "" && l.Vault != DefaultVaultName {
+		fields = append(fields, l.Vault)
+	}
+	if l.VersionBefore != nil || l.VersionAfter != nil {
+		fields = append(fields, "version:"+optional(l.VersionBefore)+"->"+optional(l.VersionAfter))
+	}
+
+	h := sha256.New()
+	for _, field := range fields {
+		h.Write([]byte(field))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ChainAfter links the entry to prev, the user's latest chained entry or
+// nil for the first, and seals it with its hash
+func (l *SyncLog) ChainAfter(prev *SyncLog) {
+	l.Seq, l.PrevHash = 1, ""
+	if prev != nil {
+		l.Seq, l.PrevHash = prev.Seq+1, prev.EntryHash
+	}
+	l.CreatedAt = l.CreatedAt.Truncate(time.Microsecond)
+	l.EntryHash = l.ComputeHash()
+}
+
+// Sync chain divergence reasons
+const (
+	SyncChainHashMismatch = "hash_mismatch" // the entry was altered
+	SyncChainBrokenLink   = "broken_link"   // prev_hash is not the previous entry's hash
+	SyncChainSequenceGap  = "sequence_gap"  // entries are missing
+	SyncChainTruncated    = "truncated"     // the oldest entries are missing, not pruned
+)
+
+// SyncChainRetention records where retention cut a user's sync log chain
+type SyncChainRetention struct {
+	Seq      int64  // first retained entry
+	PrevHash string // hash of the last pruned entry
+	PrunedAt time.Time
+}
+
+// SyncChainReport is the result of verifying a user's sync log chain
+type SyncChainReport struct {
+	Valid           bool                 `json:"valid"`
+	Entries         int                  `json:"entries"`
+	HeadHash        string               `json:"head_hash,omitempty"`
+	PrunedBefore    int64                `json:"pruned_before,omitempty"`
+	FirstDivergence *SyncChainDivergence `json:"first_divergence,omitempty"`
+}
+
+// SyncChainDivergence is the first entry that does not verify
+type SyncChainDivergence struct {
+	Seq    int64     `json:"seq"`
+	ID     uuid.UUID `json:"id"`
+	Reason string    `json:"reason"`
+}
+
+// Audit event actions
+const (
+	AuditUserApproved   = "user.approved"
+	AuditUserUnapproved = "user.unapproved"
+	AuditUserBlocked    = "user.blocked"
+	AuditUserUnblocked  = "user.unblocked"
+	AuditUserRejected   = "user.rejected"
+	AuditUserDeleted    = "user.deleted"
+	AuditUserWarned     = "user.inactivity_warned"
+	AuditUserPromoted   = "user.promoted"
+	AuditUserDemoted    = "user.demoted"
+	AuditVaultMoved     = "vault.moved"
+	AuditVaultCopied    = "vault.copied"
+	AuditInviteCreated  = "invite.created"
+	AuditTOTPThrottled  = "user.totp_throttled"
+	AuditDeviceDeleted  = "device.deleted"
+	AuditSessionRevoked = "session.revoked"
+)
+
+// Invite is a single-use registration code. Only a hash of the code is
+// stored; the admin sees it once when creating the invite.
+type Invite struct {
+	ID         uuid.UUID  `json:"id"`
+	CodePrefix string     `json:"code_prefix"`
+	CodeHash   string     `json:"-"`
+	Note       string     `json:"note,omitempty"`
+	CreatedBy  *uuid.UUID `json:"created_by,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UsedAt     *time.Time `json:"used_at,omitempty"`
+	UsedBy     *uuid.UUID `json:"used_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateInviteRequest is the body of POST /admin/invites
+type CreateInviteRequest struct {
+	Note string `json:"note,omitempty" input:"reason"`
+}
+
+// CreateInviteResponse returns a new invite with its code
+type CreateInviteResponse struct {
+	Code   string  `json:"code"`
+	Invite *Invite `json:"invite"`
+}
+
+// AuditEvent records an administrative action
+type AuditEvent struct {
+	ID        uuid.UUID         `json:"id"`
+	Action    string            `json:"action"`
+	ActorID   *uuid.UUID        `json:"actor_id,omitempty"`
+	TargetID  *uuid.UUID        `json:"target_id,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// DomainEvent is a security-relevant event exported to external
+// collectors. Seq increases monotonically and serves as export cursor.
+type DomainEvent struct {
+	Seq        int64           `json:"seq"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	ActorID    *uuid.UUID      `json:"actor_id,omitempty"`
+	SubjectID  *uuid.UUID      `json:"subject_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// DomainEventQuery selects domain events for export
+type DomainEventQuery struct {
+	After int64      // only events with a greater Seq
+	From  *time.Time // inclusive
+	To    *time.Time // exclusive
+	Types []string   // exact types, or prefixes ending in ".*"; empty means all
+	Limit int
+}
+
+// Auth event outcomes
+const (
+	AuthOutcomeSuccess = "success"
+	AuthOutcomeFailure = "failure"
+)
+
+// AuthEvent is an entry of the account activity log users and admins can
+// review. Type is the domain event type. UserID is nil for failed logins
+// of unknown emails; ActorID differs from UserID for admin actions.
+type AuthEvent struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	ActorID   *uuid.UUID `json:"actor_id,omitempty"`
+	Type      string     `json:"type"`
+	Outcome   string     `json:"outcome"`
+	Reason    string     `json:"reason,omitempty"`
+	Email     string     `json:"email,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// AuthEventQuery selects auth events, newest first
+type AuthEventQuery struct {
+	UserID *uuid.UUID
+	Type   string     // exact type, or a prefix ending in ".*"; empty means all
+	Before *time.Time // exclusive, for paging
+	Limit  int
+}
+
+// User status filters of admin user listings
+const (
+	UserStatusActive  = "active"  // approved and not blocked
+	UserStatusPending = "pending" // awaiting approval
+	UserStatusBlocked = "blocked"
+	UserStatusAdmin   = "admin"
+)
+
+// UserListQuery selects a page of users for admin listings
+type UserListQuery struct {
+	Status string // one of the UserStatus values; empty means all
+	Search string // case-insensitive substring of the email
+	Limit  int
+	Offset int
+}
+
+// UserExportRow is a user in the admin CSV export
+type UserExportRow struct {
+	Email         string
+	EmailVerified bool
+	IsApproved    bool
+	IsAdmin       bool
+	IsBlocked     bool
+	CreatedAt     time.Time
+	LastLoginAt   *time.Time
+	Devices       int
+	VaultRevision *int // of the default vault, nil without one
+}
+
+// SyncLogExportRow is a sync log entry in the admin CSV export
+type SyncLogExportRow struct {
+	Email          string
+	DeviceName     string // empty if the device is gone or unknown
+	Vault          string
+	Action         string
+	RevisionBefore *int
+	RevisionAfter  *int
+	CreatedAt      time.Time
 }
 
 // --- Request/Response Types ---
 
 // RegisterRequest for user registration
 type RegisterRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Email               string `json:"email" binding:"required,email" input:"email"`
+	Password            string `json:"password" binding:"required,min=8" input:"secret"`
+	DisplayName         string `json:"display_name,omitempty" input:"name"`
+	RegistrationMessage string `json:"registration_message,omitempty" input:"message"`
+	InviteCode          string `json:"invite_code,omitempty" input:"token"`
+}
+
+// RegistrationProfile tells the admins who is asking for an account
+type RegistrationProfile struct {
+	DisplayName string
+	Message     string
+}
+
+// ResendVerificationRequest asks for a new email verification link
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email" input:"email"`
 }
 
 // LoginRequest for user login
 type LoginRequest struct {
-	Email      string `json:"email" binding:"required,email"`
-	Password   string `json:"password" binding:"required"`
-	DeviceName string `json:"device_name" binding:"required"`
-	DeviceType string `json:"device_type" binding:"required"`
+	Email      string `json:"email" binding:"required,email" input:"email"`
+	Password   string `json:"password" binding:"required" input:"secret"`
+	DeviceName string `json:"device_name" binding:"required" input:"device_name"`
+	DeviceType string `json:"device_type" binding:"required" input:"device_type"`
+	TrustToken string `json:"trust_token,omitempty" input:"token"` // skips the second factor if still trusted
+
+	DeviceModel string `json:"device_model,omitempty" input:"device_model"`
+	AppVersion  string `json:"app_version,omitempty" input:"app_version"`
+}
+
+// TokenTypeBearer is the token_type of all issued credentials
+const TokenTypeBearer = "Bearer"
+
+// TokenGrant describes what an issued credential grants, so clients need
+// not decode the token
+type TokenGrant struct {
+	TokenType     string    `json:"token_type"`
+	Scope         string    `json:"scope"`           // space-separated
+	AuthSessionID uuid.UUID `json:"auth_session_id"` // refresh token family or API key ID
+}
+
+// NewTokenGrant creates a bearer grant of the given scopes
+func NewTokenGrant(scopes []string, authSessionID uuid.UUID) TokenGrant {
+	return TokenGrant{
+		TokenType:     TokenTypeBearer,
+		Scope:         strings.Join(scopes, " "),
+		AuthSessionID: authSessionID,
+	}
 }
 
 // LoginResponse on successful login
@@ -100,31 +732,61 @@ type LoginResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 	ExpiresIn    int64  `json:"expires_in"`
-	User         User   `json:"user"`
-	DeviceID     string `json:"device_id"`
+	TokenGrant
+	User     User   `json:"user"`
+	DeviceID string `json:"device_id"`
+	// DevicePendingApproval is set while the device may not use the vault
+	DevicePendingApproval bool `json:"device_pending_approval,omitempty"`
+
+	// TrustToken is issued when the login asked to remember the device
+	TrustToken          string `json:"trust_token,omitempty"`
+	TrustTokenExpiresAt int64  `json:"trust_token_expires_at,omitempty"`
+
+	// Warning is set if the app version is below the minimum supported
+	// one; the login still succeeded
+	Warning string `json:"warning,omitempty"`
 }
 
-// LoginTOTPResponse when TOTP is required
+// Second factor methods
+const (
+	SecondFactorTOTP     = "totp"
+	SecondFactorWebAuthn = "webauthn"
+)
+
+// LoginTOTPResponse when a second factor is required. The temp token
+// completes the login with any of the listed methods.
 type LoginTOTPResponse struct {
-	RequiresTOTP bool   `json:"requires_totp"`
-	TempToken    string `json:"temp_token"`
+	RequiresSecondFactor bool     `json:"requires_second_factor"`
+	Methods              []string `json:"second_factor_methods"`
+	RequiresTOTP         bool     `json:"requires_totp"` // TOTP is one of the methods
+	TempToken            string   `json:"temp_token"`
+	ExpiresIn            int64    `json:"expires_in"`
+}
+
+// TempTokenExtendRequest for re-issuing a TOTP temp token
+type TempTokenExtendRequest struct {
+	TempToken string `json:"temp_token" binding:"required" input:"token"`
 }
 
 // TOTPValidateRequest for TOTP validation during login
 type TOTPValidateRequest struct {
-	TempToken string `json:"temp_token" binding:"required"`
-	Code      string `json:"code" binding:"required,len=6"`
+	TempToken string `json:"temp_token" binding:"required" input:"token"`
+	Code      string `json:"code" binding:"required,len=6" input:"token"`
+
+	RememberDevice bool `json:"remember_device"` // issue a trust token to skip TOTP next time
 }
 
 // RefreshRequest for token refresh
 type RefreshRequest struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken string `json:"refresh_token" binding:"required" input:"token"`
 }
 
 // RefreshResponse on successful refresh
 type RefreshResponse struct {
-	AccessToken string `json:"access_token"`
-	ExpiresIn   int64  `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"` // replaces the presented token
+	ExpiresIn    int64  `json:"expires_in"`
+	TokenGrant
 }
 
 // TOTPSetupResponse for TOTP setup
@@ -136,13 +798,13 @@ type TOTPSetupResponse struct {
 
 // TOTPVerifyRequest for verifying TOTP setup
 type TOTPVerifyRequest struct {
-	Code string `json:"code" binding:"required,len=6"`
+	Code string `json:"code" binding:"required,len=6" input:"token"`
 }
 
 // TOTPDisableRequest for disabling TOTP
 type TOTPDisableRequest struct {
-	Code     string `json:"code" binding:"required,len=6"`
-	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required,len=6" input:"token"`
+	Password string `json:"password" binding:"required" input:"secret"`
 }
 
 // RecoveryCodesResponse returns recovery codes
@@ -152,15 +814,116 @@ type RecoveryCodesResponse struct {
 
 // RecoveryValidateRequest for recovery code login
 type RecoveryValidateRequest struct {
-	TempToken string `json:"temp_token" binding:"required"`
-	Code      string `json:"code" binding:"required"`
+	TempToken string `json:"temp_token" binding:"required" input:"token"`
+	Code      string `json:"code" binding:"required" input:"token"`
+}
+
+// RecoveryLoginResponse completes a login with a recovery code
+type RecoveryLoginResponse struct {
+	LoginResponse
+	RemainingCodes int `json:"remaining_codes"`
+
+	// RequiresRelogin is always false. Clients from before recovery codes
+	// completed the login sent the password again when it was true.
+	RequiresRelogin bool `json:"requires_relogin"`
+}
+
+// WebAuthnBeginResponse starts a WebAuthn ceremony. Options are passed
+// to navigator.credentials.create or .get as is; the challenge ID goes
+// back with the authenticator's response.
+type WebAuthnBeginResponse struct {
+	ChallengeID uuid.UUID   `json:"challenge_id"`
+	Options     interface{} `json:"options"`
+}
+
+// WebAuthnRegisterFinishRequest completes the registration of a credential
+type WebAuthnRegisterFinishRequest struct {
+	ChallengeID string          `json:"challenge_id" binding:"required" input:"token"`
+	Name        string          `json:"name" input:"name"`
+	Credential  json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential as JSON
+}
+
+// WebAuthnLoginBeginRequest starts a WebAuthn login with a temp token
+type WebAuthnLoginBeginRequest struct {
+	TempToken string `json:"temp_token" binding:"required" input:"token"`
+}
+
+// WebAuthnLoginFinishRequest completes a login with a WebAuthn assertion
+type WebAuthnLoginFinishRequest struct {
+	TempToken   string          `json:"temp_token" binding:"required" input:"token"`
+	ChallengeID string          `json:"challenge_id" binding:"required" input:"token"`
+	Credential  json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential as JSON
+}
+
+// WebAuthnCredentialListResponse lists a user's WebAuthn credentials
+type WebAuthnCredentialListResponse struct {
+	Credentials []WebAuthnCredential `json:"credentials"`
 }
 
 // VaultPushRequest for uploading vault
 type VaultPushRequest struct {
-	VaultBlob string `json:"vault_blob" binding:"required"` // Base64
-	Revision  int    `json:"revision"`                      // 0 is valid for initial push
-	DeviceID  string `json:"device_id" binding:"required"`
+	VaultBlob string `json:"vault_blob" binding:"required" input:"blob"` // Base64
+	Revision  int    `json:"revision"`                                   // 0 is valid for initial push
+	DeviceID  string `json:"device_id" binding:"required" input:"token"`
+
+	// Name picks the vault, see ValidVaultName. Empty falls back to the
+	// ?vault= query parameter, then to DefaultVaultName.
+	Name string `json:"name,omitempty" input:"token"`
+
+	// IdempotencyKey makes retries of the push safe, see
+	// VaultPushReceipt. The Idempotency-Key header takes precedence.
+	IdempotencyKey string `json:"idempotency_key,omitempty" input:"token"`
+
+	// VaultVersion is the encryption format of VaultBlob. 0 keeps the
+	// version of the stored vault (1 for a new vault).
+	VaultVersion int `json:"vault_version,omitempty" binding:"omitempty,min=1"`
+
+	// Force accepts a VaultVersion below the stored one. Without it such
+	// a push is rejected so an outdated client cannot undo a migration.
+	Force bool `json:"force,omitempty"`
+
+	// SHA256 is the optional hex checksum of the decoded blob, see
+	// BlobChecksum. A push that does not match it is rejected.
+	SHA256 string `json:"sha256,omitempty" input:"token"`
+}
+
+// VaultDeltaManifest lists the chunks of a blob for a delta push: the
+// lowercase hex SHA-256 of each chunk of ChunkSize bytes, in order. The
+// last chunk may be shorter.
+type VaultDeltaManifest struct {
+	Name      string   `json:"name,omitempty" input:"token"`
+	ChunkSize int      `json:"chunk_size" binding:"required"`
+	Chunks    []string `json:"chunks" binding:"required,min=1,dive,len=64,hexadecimal"`
+}
+
+// VaultDeltaManifestResponse lists the chunks of a manifest the client
+// still has to upload
+type VaultDeltaManifestResponse struct {
+	Missing []string `json:"missing"`
+}
+
+// VaultDeltaChunk is one uploaded chunk of a delta push
+type VaultDeltaChunk struct {
+	SHA256 string `json:"sha256" binding:"required,len=64,hexadecimal"`
+	Data   []byte `json:"data" binding:"required"` // base64
+}
+
+// VaultDeltaChunksRequest uploads chunks of a delta push
+type VaultDeltaChunksRequest struct {
+	Chunks []VaultDeltaChunk `json:"chunks" binding:"required,min=1,dive"`
+}
+
+// VaultDeltaCommitRequest assembles the blob of a manifest and pushes it.
+// The other fields are those of VaultPushRequest; SHA256 of the whole
+// blob is required.
+type VaultDeltaCommitRequest struct {
+	VaultDeltaManifest
+	Revision       int    `json:"revision"`
+	DeviceID       string `json:"device_id" binding:"required" input:"token"`
+	IdempotencyKey string `json:"idempotency_key,omitempty" input:"token"`
+	VaultVersion   int    `json:"vault_version,omitempty" binding:"omitempty,min=1"`
+	Force          bool   `json:"force,omitempty"`
+	SHA256         string `json:"sha256" binding:"required" input:"token"`
 }
 
 // VaultPushResponse on successful push
@@ -170,19 +933,168 @@ type VaultPushResponse struct {
 	Timestamp int64  `json:"timestamp"`
 }
 
+// VaultPushReceipt is the outcome of a push sent with an idempotency key.
+// A retry with the same key gets Response again instead of a conflict.
+type VaultPushReceipt struct {
+	Vault    string
+	Response VaultPushResponse
+}
+
+// VaultDeleteResponse on soft deletion of a vault
+type VaultDeleteResponse struct {
+	Status          string `json:"status"`
+	Revision        int    `json:"revision"`
+	DeletedAt       int64  `json:"deleted_at"`
+	RestorableUntil int64  `json:"restorable_until"` // POST /vault/undelete works until then
+}
+
+// VaultPushVerdict is the outcome of validating a push. The dry-run
+// endpoint returns it as is; a real push acts on it.
+type VaultPushVerdict struct {
+	Valid          bool   `json:"valid"`
+	Code           string `json:"code,omitempty"` // why the push would be rejected
+	Error          string `json:"error,omitempty"`
+	SizeBytes      int    `json:"size_bytes"` // decoded blob size
+	WouldCreate    bool   `json:"would_create"`
+	WouldConflict  bool   `json:"would_conflict"`
+	LocalRevision  int    `json:"local_revision"`
+	ServerRevision int    `json:"server_revision"`
+	ServerVersion  int    `json:"server_vault_version,omitempty"` // vault version stored now
+	NextRevision   int    `json:"next_revision,omitempty"`
+	ServerDeviceID string `json:"server_device_id,omitempty"`
+	ServerUpdated  int64  `json:"server_updated_at,omitempty"`
+}
+
 // VaultPullResponse for downloading vault
 type VaultPullResponse struct {
+	Name            string `json:"name"`
 	VaultBlob       string `json:"vault_blob"` // Base64
 	Revision        int    `json:"revision"`
 	UpdatedAt       int64  `json:"updated_at"`
 	UpdatedByDevice string `json:"updated_by_device,omitempty"`
+	VaultVersion    int    `json:"vault_version"`
+	SizeBytes       int    `json:"size_bytes"` // decoded blob size
+	SHA256          string `json:"sha256"`     // checksum of the decoded blob
+
+	MigrationRequired *VaultMigrationNotice `json:"migration_required,omitempty"`
+}
+
+// VaultMigrationNotice asks a client to re-encrypt its vault to
+// TargetVersion before Deadline
+type VaultMigrationNotice struct {
+	TargetVersion int   `json:"target_version"`
+	Deadline      int64 `json:"deadline"`       // unix seconds
+	PushesRefused bool  `json:"pushes_refused"` // pushes of the old version are rejected now
+}
+
+// VaultVersionCount is the number of vaults stored with one vault_version
+type VaultVersionCount struct {
+	Version int `json:"version"`
+	Vaults  int `json:"vaults"`
+}
+
+// VaultWatchResponse announces a vault change to a watching device
+type VaultWatchResponse struct {
+	Name     string `json:"name"`
+	Revision int    `json:"revision"`
+	DeviceID string `json:"device_id,omitempty"` // device that made the change, if known
+}
+
+// VaultUsage is the stored size of one of a user's vaults
+type VaultUsage struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	SizeBytes int       `json:"size_bytes"`
+	Revision  int       `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // VaultStatusResponse for sync status
 type VaultStatusResponse struct {
-	HasVault  bool  `json:"has_vault"`
-	Revision  int   `json:"revision"`
-	UpdatedAt int64 `json:"updated_at"`
+	Name         string `json:"name"`
+	HasVault     bool   `json:"has_vault"`
+	Revision     int    `json:"revision"`
+	UpdatedAt    int64  `json:"updated_at"`
+	VaultVersion int    `json:"vault_version,omitempty"`
+	SizeBytes    int    `json:"size_bytes,omitempty"`     // decoded blob size
+	MaxSizeBytes int64  `json:"max_size_bytes,omitempty"` // push limit, omitted if unlimited
+	SHA256       string `json:"sha256,omitempty"`         // checksum of the decoded blob
+
+	MigrationRequired *VaultMigrationNotice `json:"migration_required,omitempty"`
+
+	// BehindBy counts the revisions the calling device has not pulled yet
+	BehindBy *int `json:"behind_by,omitempty"`
+
+	HasSettings       bool  `json:"has_settings"`
+	SettingsRevision  int   `json:"settings_revision"`
+	SettingsUpdatedAt int64 `json:"settings_updated_at"`
+
+	// Devices is only included with ?devices=true
+	Devices []VaultDeviceStatus `json:"devices,omitempty"`
+}
+
+// VaultSummary describes one of the user's vaults without its blob
+type VaultSummary struct {
+	Name         string    `json:"name"`
+	Revision     int       `json:"revision"`
+	VaultVersion int       `json:"vault_version"`
+	SizeBytes    int       `json:"size_bytes"`
+	SHA256       string    `json:"sha256"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// VaultListResponse lists the user's vaults by name
+type VaultListResponse struct {
+	Vaults    []VaultSummary `json:"vaults"`
+	MaxVaults int            `json:"max_vaults,omitempty"` // omitted if unlimited
+}
+
+// VaultExportFormat is the version of the VaultExport document
+const VaultExportFormat = 1
+
+// VaultExport is an offline backup of one vault with everything needed to
+// audit it or import it again
+type VaultExport struct {
+	Format       int       `json:"format"` // VaultExportFormat
+	ExportedAt   time.Time `json:"exported_at"`
+	Name         string    `json:"name"`
+	VaultBlob    string    `json:"vault_blob"` // Base64
+	Revision     int       `json:"revision"`
+	VaultVersion int       `json:"vault_version"`
+	SizeBytes    int       `json:"size_bytes"`
+	SHA256       string    `json:"sha256"` // checksum of the decoded blob
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// History is the user's whole sync log, so its hash chain can be
+	// verified, see SyncLog.ComputeHash
+	History []SyncLog `json:"history"`
+	Devices []Device  `json:"devices"`
+}
+
+// VaultDeviceStatus is the sync state of one of the user's devices
+type VaultDeviceStatus struct {
+	ID               uuid.UUID  `json:"id"`
+	DeviceName       string     `json:"device_name"`
+	DeviceType       string     `json:"device_type"`
+	LastSyncAt       *time.Time `json:"last_sync_at,omitempty"`
+	LastSeenRevision *int       `json:"last_seen_revision,omitempty"`
+	BehindBy         int        `json:"behind_by"`
+	Current          bool       `json:"current"` // has pulled or pushed the latest revision
+}
+
+// SettingsBlobPutRequest for uploading the client settings blob
+type SettingsBlobPutRequest struct {
+	SettingsBlob string `json:"settings_blob" binding:"required" input:"blob"` // Base64
+	Revision     int    `json:"revision"`                                      // 0 is valid for initial put
+}
+
+// SettingsBlobResponse for downloading the client settings blob
+type SettingsBlobResponse struct {
+	SettingsBlob    string `json:"settings_blob"` // Base64
+	Revision        int    `json:"revision"`
+	UpdatedAt       int64  `json:"updated_at"`
+	UpdatedByDevice string `json:"updated_by_device,omitempty"`
 }
 
 // VaultConflictResponse when conflict detected
@@ -200,22 +1112,384 @@ type DeviceListResponse struct {
 	Devices []Device `json:"devices"`
 }
 
+// UpdateDeviceRequest changes a device; omitted fields are kept
+type UpdateDeviceRequest struct {
+	Name     *string `json:"name"`
+	ReadOnly *bool   `json:"read_only"`
+}
+
 // RegisterDeviceRequest for registering a device
 type RegisterDeviceRequest struct {
-	DeviceName  string `json:"device_name" binding:"required"`
-	DeviceType  string `json:"device_type" binding:"required"`
-	DeviceModel string `json:"device_model,omitempty"`
-	AppVersion  string `json:"app_version,omitempty"`
+	DeviceName  string `json:"device_name" binding:"required" input:"device_name"`
+	DeviceType  string `json:"device_type" binding:"required" input:"device_type"`
+	DeviceModel string `json:"device_model,omitempty" input:"device_model"`
+	AppVersion  string `json:"app_version,omitempty" input:"app_version"`
 }
 
 // ErrorResponse for API errors
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Code    string `json:"code,omitempty"`
-	Details string `json:"details,omitempty"`
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"` // quote in support requests
 }
 
 // MessageResponse for simple messages
 type MessageResponse struct {
 	Message string `json:"message"`
 }
+
+// RouteInfo describes a single API route
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Auth   string `json:"auth"`
+	Scope  string `json:"scope,omitempty"`
+}
+
+// RouteListResponse for the route listing
+type RouteListResponse struct {
+	Routes []RouteInfo `json:"routes"`
+}
+
+// Health statuses
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// HealthResponse for the liveness and readiness checks
+type HealthResponse struct {
+	Status  string                      `json:"status"`
+	Version string                      `json:"version,omitempty"`
+	Commit  string                      `json:"commit,omitempty"`
+	Checks  map[string]DependencyHealth `json:"checks,omitempty"`
+}
+
+// DependencyHealth is the state of one dependency checked for readiness
+type DependencyHealth struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Capabilities describes the optional features of a deployment
+type Capabilities struct {
+	WebUI        WebUICapabilities        `json:"web_ui"`
+	Registration RegistrationCapabilities `json:"registration"`
+}
+
+// RegistrationCapabilities tells clients what registration asks for
+type RegistrationCapabilities struct {
+	Mode                string `json:"mode"` // open, invite or closed
+	DisplayNameRequired bool   `json:"display_name_required"`
+}
+
+// AuthRequirements tells clients up front what registration and
+// passwords must satisfy
+type AuthRequirements struct {
+	Password     PasswordRequirements     `json:"password"`
+	Registration RegistrationRequirements `json:"registration"`
+	// Captcha is null while the server does not use a CAPTCHA
+	Captcha *CaptchaRequirements `json:"captcha"`
+}
+
+// PasswordRequirements is the password policy
+type PasswordRequirements struct {
+	MinLength     int  `json:"min_length"`
+	BreachedCheck bool `json:"breached_check"` // passwords are checked against breach lists
+}
+
+// RegistrationRequirements is the effective registration policy
+type RegistrationRequirements struct {
+	Mode                      string   `json:"mode"` // open, invite or closed
+	InviteRequired            bool     `json:"invite_required"`
+	EmailVerificationRequired bool     `json:"email_verification_required"`
+	DisplayNameRequired       bool     `json:"display_name_required"`
+	AllowedEmailDomains       []string `json:"allowed_email_domains"` // empty allows all
+	// EmailDomainPattern is a regular expression the email must match,
+	// ignoring case; empty when all domains are allowed
+	EmailDomainPattern string `json:"email_domain_pattern,omitempty"`
+}
+
+// CaptchaRequirements names the CAPTCHA a registration must solve
+type CaptchaRequirements struct {
+	Provider string `json:"provider"`
+	SiteKey  string `json:"site_key"`
+}
+
+// WebUICapabilities tells which web interfaces are served
+type WebUICapabilities struct {
+	Admin bool `json:"admin"`
+	User  bool `json:"user"`
+}
+
+// JWK is a public JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"` // RSA
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"` // OKP
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the response of the JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// AdminUser is the admin view of a user without sensitive data
+type AdminUser struct {
+	ID            uuid.UUID  `json:"id"`
+	Email         string     `json:"email"`
+	IsApproved    bool       `json:"is_approved"`
+	IsAdmin       bool       `json:"is_admin"`
+	IsBlocked     bool       `json:"is_blocked"`
+	EmailVerified bool       `json:"email_verified"`
+	TOTPEnabled   bool       `json:"totp_enabled"`
+	CreatedAt     string     `json:"created_at"`
+	LastLoginAt   *string    `json:"last_login_at,omitempty"`
+	ApprovedAt    *string    `json:"approved_at,omitempty"`
+	ApprovedBy    *uuid.UUID `json:"approved_by,omitempty"`
+	BlockedAt     *string    `json:"blocked_at,omitempty"`
+	BlockedReason string     `json:"blocked_reason,omitempty"`
+	// Profile given at registration to help the approval decision
+	DisplayName         string `json:"display_name,omitempty"`
+	RegistrationMessage string `json:"registration_message,omitempty"`
+	// WaitingSeconds is how long a pending user has waited for approval
+	WaitingSeconds *int64 `json:"waiting_seconds,omitempty"`
+}
+
+// NewAdminUser strips sensitive data from a user for admin responses
+func NewAdminUser(u *User) AdminUser {
+	var waiting *int64
+	if !u.IsApproved && !u.IsBlocked {
+		w := int64(time.Since(u.CreatedAt).Seconds())
+		waiting = &w
+	}
+	return AdminUser{
+		ID:          u.ID,
+		Email:       u.Email,
+		IsApproved:  u.IsApproved,
+		IsAdmin:     u.IsAdmin,
+		IsBlocked:   u.IsBlocked,
+		TOTPEnabled: u.TOTPEnabled,
+		CreatedAt:   u.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		LastLoginAt: formatAdminTime(u.LastLoginAt),
+		ApprovedAt:  formatAdminTime(u.ApprovedAt),
+		ApprovedBy:  u.ApprovedBy,
+		BlockedAt:   formatAdminTime(u.BlockedAt),
+
+		EmailVerified:       u.EmailVerified,
+		BlockedReason:       u.BlockedReason,
+		DisplayName:         u.DisplayName,
+		RegistrationMessage: u.RegistrationMessage,
+		WaitingSeconds:      waiting,
+	}
+}
+
+// formatAdminTime formats an optional timestamp for admin responses
+func formatAdminTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format("2006-01-02T15:04:05Z")
+	return &s
+}
+
+// AdminVaultInfo is vault metadata shown to admins (never the blob)
+type AdminVaultInfo struct {
+	Revision        int        `json:"revision"`
+	VaultVersion    int        `json:"vault_version"`
+	SizeBytes       int        `json:"size_bytes"`
+	UpdatedByDevice *uuid.UUID `json:"updated_by_device,omitempty"`
+	DeviceName      string     `json:"updated_by_device_name,omitempty"` // empty if the device is gone
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// AdminUserVault is the vault of a user with recent sync activity
+type AdminUserVault struct {
+	Vault          *AdminVaultInfo `json:"vault"` // null without a vault
+	RecentActivity []SyncLog       `json:"recent_activity"`
+}
+
+// AdminUserDetail is a single user with devices, vault and recent activity
+type AdminUserDetail struct {
+	User           AdminUser       `json:"user"`
+	Devices        []Device        `json:"devices"`
+	Sessions       []Session       `json:"sessions"`
+	Vault          *AdminVaultInfo `json:"vault,omitempty"`
+	RecentActivity []SyncLog       `json:"recent_activity"`
+}
+
+// AdminDevice is a device as admins see it, with its active sessions
+type AdminDevice struct {
+	Device
+	ActiveTokens   int        `json:"active_tokens"`              // unrevoked, unexpired refresh tokens
+	LastActivityAt *time.Time `json:"last_activity_at,omitempty"` // latest of last sync and last seen
+}
+
+// AdminUserDevices is a user with their devices as admins see them
+type AdminUserDevices struct {
+	User    AdminUser     `json:"user"`
+	Devices []AdminDevice `json:"devices"`
+}
+
+// AdminUserSessions is a user with their active sessions
+type AdminUserSessions struct {
+	User     AdminUser `json:"user"`
+	Sessions []Session `json:"sessions"`
+}
+
+// Bulk user actions
+const (
+	BulkApprove = "approve"
+	BulkBlock   = "block"
+	BulkUnblock = "unblock"
+	BulkDelete  = "delete"
+)
+
+// Outcomes of a bulk action for a single user
+const (
+	BulkStatusDone     = "done"
+	BulkStatusSkipped  = "skipped"
+	BulkStatusNotFound = "not_found"
+)
+
+// BulkUserRequest applies one action to several users
+type BulkUserRequest struct {
+	Action  string      `json:"action" binding:"required"`
+	UserIDs []uuid.UUID `json:"user_ids" binding:"required,min=1"`
+	Confirm bool        `json:"confirm"`
+}
+
+// BulkUserResult is the outcome of a bulk action for one user. Code says
+// why a user was skipped.
+type BulkUserResult struct {
+	UserID uuid.UUID `json:"user_id"`
+	Status string    `json:"status"`
+	Code   string    `json:"code,omitempty"`
+}
+
+// BootstrapRequest configures a fresh instance in one call
+type BootstrapRequest struct {
+	Token         string            `json:"token" binding:"required" input:"token"`
+	AdminEmail    string            `json:"admin_email" binding:"required,email" input:"email"`
+	AdminPassword string            `json:"admin_password" binding:"required,min=8" input:"secret"`
+	Settings      map[string]string `json:"settings,omitempty"`
+	CreateAPIKey  bool              `json:"create_api_key,omitempty"`
+	APIKeyName    string            `json:"api_key_name,omitempty" input:"name"`
+}
+
+// BootstrapResponse on successful bootstrap
+type BootstrapResponse struct {
+	AdminID     uuid.UUID         `json:"admin_id"`
+	Settings    map[string]string `json:"settings"`
+	APIKey      string            `json:"api_key,omitempty"` // shown only once
+	*TokenGrant                   // of the API key, if one was created
+}
+
+// StreamInfo describes an open streaming connection
+type StreamInfo struct {
+	ID       uuid.UUID `json:"id"`
+	DeviceID uuid.UUID `json:"device_id"`
+	OpenedAt time.Time `json:"opened_at"`
+}
+
+// UserStreams lists the open streaming connections of one user
+type UserStreams struct {
+	UserID  uuid.UUID    `json:"user_id"`
+	Count   int          `json:"count"`
+	Streams []StreamInfo `json:"streams"`
+}
+
+// StreamListResponse for the admin streams endpoint
+type StreamListResponse struct {
+	Total      int           `json:"total"`
+	MaxPerUser int           `json:"max_per_user"`
+	MaxGlobal  int           `json:"max_global"`
+	Users      []UserStreams `json:"users"`
+}
+
+// OutboxEntry describes a notification awaiting delivery
+type OutboxEntry struct {
+	ID            uuid.UUID  `json:"id"`
+	Kind          string     `json:"kind"`
+	UserID        uuid.UUID  `json:"user_id"`
+	Target        string     `json:"target"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // nil once retries gave up
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// OutboxListResponse for the admin outbox endpoint
+type OutboxListResponse struct {
+	Total   int           `json:"total"`
+	Entries []OutboxEntry `json:"entries"`
+}
+
+// OutboxRetryResponse after a manual delivery attempt
+type OutboxRetryResponse struct {
+	Delivered bool         `json:"delivered"`
+	Entry     *OutboxEntry `json:"entry,omitempty"` // if the attempt failed
+}
+
+// VaultTransferRequest moves or copies a user's vault to another account
+type VaultTransferRequest struct {
+	TargetUserID    string `json:"target_user_id" binding:"required" input:"token"`
+	Copy            bool   `json:"copy"`
+	Overwrite       bool   `json:"overwrite"`
+	IncludeSyncLogs bool   `json:"include_sync_logs"`
+	Confirm         bool   `json:"confirm"`
+}
+
+// VaultTransferResponse after a vault transfer
+type VaultTransferResponse struct {
+	Message      string    `json:"message"`
+	SourceUserID uuid.UUID `json:"source_user_id"`
+	TargetUserID uuid.UUID `json:"target_user_id"`
+	Copied       bool      `json:"copied"`
+	Overwritten  bool      `json:"overwritten"` // previous target vault kept in history
+	Revision     int       `json:"revision"`
+}
+
+// ConnectLinkVersion is the version of the connect deep link format
+const ConnectLinkVersion = "1"
+
+// ErrInvalidConnectLink is returned for URIs that are not connect links
+var ErrInvalidConnectLink = errors.New("invalid connect link")
+
+// ConnectLink is the deep link that sets up an app for this server. It is
+// serialized as vibedterm://connect?v=1&server=<url>&email=<email>.
+type ConnectLink struct {
+	Server string // external base URL of the server
+	Email  string // prefilled login, may be empty
+}
+
+// URI serializes the link
+func (l ConnectLink) URI() string {
+	query := url.Values{"v": {ConnectLinkVersion}, "server": {l.Server}}
+	if l.Email != "" {
+		query.Set("email", l.Email)
+	}
+	return (&url.URL{Scheme: "vibedterm", Host: "connect", RawQuery: query.Encode()}).String()
+}
+
+// ParseConnectLink parses a URI produced by ConnectLink.URI
+func ParseConnectLink(uri string) (ConnectLink, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "vibedterm" || u.Host != "connect" {
+		return ConnectLink{}, ErrInvalidConnectLink
+	}
+	query := u.Query()
+	if query.Get("v") != ConnectLinkVersion || query.Get("server") == "" {
+		return ConnectLink{}, ErrInvalidConnectLink
+	}
+	return ConnectLink{Server: query.Get("server"), Email: query.Get("email")}, nil
+}
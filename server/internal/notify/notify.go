@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/geoip"
+)
+
+// Notification kinds
+const (
+	KindRecoveryCodeUsed     = "recovery_code_used"
+	KindPendingDigest        = "pending_digest"
+	KindRegistrationRejected = "registration_rejected"
+	KindRegistrationPending  = "registration_pending"
+	KindVaultTransferred     = "vault_transferred"
+	KindEmailVerification    = "email_verification"
+	KindRegistrationAttempt  = "registration_attempt"
+	KindInactivityWarning    = "inactivity_warning"
+	KindNewDeviceLogin       = "new_device_login"
+	KindDevicePending        = "device_pending_approval"
+)
+
+// Notification is a message to an account owner
+type Notification struct {
+	Kind    string
+	UserID  uuid.UUID
+	Email   string
+	Subject string
+	Body    string
+	IP      string // client address that triggered the notification, if any
+}
+
+// Notifier delivers notifications to account owners
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LogNotifier writes notifications to the server log. It is used when no
+// delivery channel is configured.
+type LogNotifier struct {
+	geo geoip.Locator
+}
+
+// NewLogNotifier creates a notifier that only logs. geo may be nil if
+// GeoIP is disabled.
+func NewLogNotifier(geo geoip.Locator) *LogNotifier {
+	return &LogNotifier{geo: geo}
+}
+
+// Notify logs the notification
+func (n *LogNotifier) Notify(_ context.Context, msg Notification) error {
+	log.Info().
+		Str("kind", msg.Kind).
+		Str("user_id", msg.UserID.String()).
+		Str("email", msg.Email).
+		Str("subject", msg.Subject).
+		Str("body", WithLocation(n.geo, msg).Body).
+		Msg("Notification")
+	return nil
+}
+
+// WithLocation appends the origin of the triggering request to the body,
+// e.g. "From: 203.0.113.7 (Berlin, Germany)". The location is resolved
+// here, when the notification is composed for delivery, rather than
+// while the triggering request is being handled.
+func WithLocation(geo geoip.Locator, msg Notification) Notification {
+	if msg.IP == "" {
+		return msg
+	}
+	origin := msg.IP
+	if summary := geoip.Summary(geo, msg.IP); summary != "" {
+		origin += " (" + summary + ")"
+	}
+	msg.Body += "\n\nFrom: " + origin
+	return msg
+}
@@ -0,0 +1,264 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Outbox entry states
+const (
+	OutboxPending = "pending" // waiting for its next attempt
+	OutboxFailed  = "failed"  // out of attempts, only retried by hand
+)
+
+const (
+	// outboxMaxAttempts is how often the worker tries a notification
+	outboxMaxAttempts = 5
+	// outboxBackoff is the wait after the first failed attempt; it doubles
+	// with every further failure
+	outboxBackoff = 30 * time.Second
+	// outboxIdle is how long the worker sleeps when nothing is due
+	outboxIdle = time.Hour
+)
+
+var (
+	// ErrQueueFull is returned when the outbox cannot take another
+	// notification
+	ErrQueueFull = errors.New("notification queue full")
+	// ErrEntryNotFound is returned for an entry that was delivered,
+	// dropped or never existed
+	ErrEntryNotFound = errors.New("outbox entry not found")
+	// ErrEntryBusy is returned while an entry is being delivered
+	ErrEntryBusy = errors.New("outbox entry is being delivered")
+)
+
+// Entry is a notification awaiting delivery
+type Entry struct {
+	ID            uuid.UUID
+	Notification  Notification
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time // zero once the worker gave up
+	CreatedAt     time.Time
+}
+
+// Target is where the notification is delivered to
+func (e Entry) Target() string {
+	if e.Notification.Email != "" {
+		return e.Notification.Email
+	}
+	return "user:" + e.Notification.UserID.String()
+}
+
+// outboxEntry is an entry and whether an attempt is in flight
+type outboxEntry struct {
+	Entry
+	seq        uint64 // queue order
+	delivering bool
+}
+
+// Outbox queues notifications and delivers them in the background, so the
+// request that triggered one does not wait for delivery. Failed deliveries
+// are retried with backoff and can be retried or dropped by hand. Entries
+// are kept in memory and do not survive a restart.
+type Outbox struct {
+	next        Notifier
+	size        int
+	maxAttempts int
+	backoff     time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[uuid.UUID]*outboxEntry
+	seq     uint64
+	wake    chan struct{}
+}
+
+// NewOutbox creates an outbox holding up to size undelivered
+// notifications. Nothing is delivered until Run is started.
+func NewOutbox(next Notifier, size int) *Outbox {
+	return &Outbox{
+		next:        next,
+		size:        size,
+		maxAttempts: outboxMaxAttempts,
+		backoff:     outboxBackoff,
+		now:         time.Now,
+		entries:     make(map[uuid.UUID]*outboxEntry),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Notify queues n without blocking
+func (o *Outbox) Notify(_ context.Context, n Notification) error {
+	o.mu.Lock()
+	if len(o.entries) >= o.size {
+		o.mu.Unlock()
+		return ErrQueueFull
+	}
+	now := o.now()
+	id := uuid.New()
+	o.seq++
+	o.entries[id] = &outboxEntry{seq: o.seq, Entry: Entry{
+		ID:            id,
+		Notification:  n,
+		Status:        OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}}
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// List returns the undelivered notifications, oldest first
+func (o *Outbox) List() []Entry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	queued := make([]*outboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		queued = append(queued, e)
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].seq < queued[j].seq })
+
+	entries := make([]Entry, len(queued))
+	for i, e := range queued {
+		entries[i] = e.Entry
+	}
+	return entries
+}
+
+// Retry attempts an entry right away, also one the worker gave up on. It
+// returns the entry as the attempt left it, or nil if it was delivered.
+func (o *Outbox) Retry(ctx context.Context, id uuid.UUID) (*Entry, error) {
+	o.mu.Lock()
+	e, ok := o.entries[id]
+	switch {
+	case !ok:
+		o.mu.Unlock()
+		return nil, ErrEntryNotFound
+	case e.delivering:
+		o.mu.Unlock()
+		return nil, ErrEntryBusy
+	}
+	e.delivering = true
+	o.mu.Unlock()
+
+	return o.attempt(ctx, e), nil
+}
+
+// Drop removes an entry without delivering it
+func (o *Outbox) Drop(id uuid.UUID) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e, ok := o.entries[id]
+	switch {
+	case !ok:
+		return ErrEntryNotFound
+	case e.delivering:
+		return ErrEntryBusy
+	}
+	delete(o.entries, id)
+	return nil
+}
+
+// Run delivers queued notifications until ctx is done
+func (o *Outbox) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(o.deliverDue(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-o.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue attempts every entry that is due and returns how long until
+// the next one is. Entries are claimed under the lock, so a manual retry
+// never delivers one a second time.
+func (o *Outbox) deliverDue(ctx context.Context) time.Duration {
+	o.mu.Lock()
+	now := o.now()
+	var due []*outboxEntry
+	for _, e := range o.entries {
+		if e.Status == OutboxPending && !e.delivering && !e.NextAttemptAt.After(now) {
+			e.delivering = true
+			due = append(due, e)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, e := range due {
+		if ctx.Err() != nil {
+			o.release(e)
+			continue
+		}
+		o.attempt(ctx, e)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	wait := outboxIdle
+	now = o.now()
+	for _, e := range o.entries {
+		if e.Status == OutboxPending && !e.delivering {
+			wait = min(wait, max(e.NextAttemptAt.Sub(now), 0))
+		}
+	}
+	return wait
+}
+
+// attempt delivers a claimed entry and records the outcome
+func (o *Outbox) attempt(ctx context.Context, e *outboxEntry) *Entry {
+	err := o.next.Notify(ctx, e.Notification)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e.delivering = false
+	if err == nil {
+		delete(o.entries, e.ID)
+		return nil
+	}
+
+	e.Attempts++
+	e.LastError = err.Error()
+	if e.Attempts >= o.maxAttempts {
+		e.Status = OutboxFailed
+		e.NextAttemptAt = time.Time{}
+	} else {
+		e.Status = OutboxPending
+		e.NextAttemptAt = o.now().Add(o.backoff << (e.Attempts - 1))
+	}
+	log.Error().Err(err).
+		Str("kind", e.Notification.Kind).
+		Str("user_id", e.Notification.UserID.String()).
+		Int("attempts", e.Attempts).
+		Str("status", e.Status).
+		Msg("Failed to deliver notification")
+
+	entry := e.Entry
+	return &entry
+}
+
+// release gives back a claimed entry that was not attempted
+func (o *Outbox) release(e *outboxEntry) {
+	o.mu.Lock()
+	e.delivering = false
+	o.mu.Unlock()
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// gatedTarget records deliveries and fails while broken. If gate is set,
+// each delivery waits for it.
+type gatedTarget struct {
+	mu        sync.Mutex
+	broken    bool
+	delivered int
+	entered   chan struct{}
+	gate      chan struct{}
+}
+
+func (g *gatedTarget) Notify(_ context.Context, _ Notification) error {
+	if g.gate != nil {
+		g.entered <- struct{}{}
+		<-g.gate
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.broken {
+		return errors.New("connection refused")
+	}
+	g.delivered++
+	return nil
+}
+
+func newTestOutbox(target Notifier) (*Outbox, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	o := NewOutbox(target, 2)
+	o.maxAttempts = 2
+	o.now = func() time.Time { return now }
+	return o, &now
+}
+
+func TestOutbox_BackoffAndGiveUp(t *testing.T) {
+	ctx := context.Background()
+	target := &gatedTarget{broken: true}
+	o, now := newTestOutbox(target)
+
+	if err := o.Notify(ctx, Notification{UserID: uuid.New()}); err != nil {
+		t.Fatal(err)
+	}
+	if wait := o.deliverDue(ctx); wait != o.backoff {
+		t.Errorf("wait after first failure = %v, want %v", wait, o.backoff)
+	}
+	entry := o.List()[0]
+	if entry.Attempts != 1 || entry.Status != OutboxPending || !entry.NextAttemptAt.Equal(now.Add(o.backoff)) {
+		t.Errorf("entry after first failure = %+v", entry)
+	}
+
+	// Not due yet
+	o.deliverDue(ctx)
+	if o.List()[0].Attempts != 1 {
+		t.Error("entry retried before its backoff")
+	}
+
+	*now = now.Add(o.backoff)
+	if wait := o.deliverDue(ctx); wait != outboxIdle {
+		t.Errorf("wait after giving up = %v, want idle", wait)
+	}
+	entry = o.List()[0]
+	if entry.Attempts != 2 || entry.Status != OutboxFailed || !entry.NextAttemptAt.IsZero() {
+		t.Errorf("entry after giving up = %+v", entry)
+	}
+
+	// Only a manual retry attempts it again
+	*now = now.Add(time.Hour)
+	target.broken = false
+	o.deliverDue(ctx)
+	if target.delivered != 0 {
+		t.Fatal("worker retried a failed entry")
+	}
+	if left, err := o.Retry(ctx, entry.ID); err != nil || left != nil {
+		t.Fatalf("Retry = %+v, %v", left, err)
+	}
+	if target.delivered != 1 || len(o.List()) != 0 {
+		t.Errorf("delivered %d, %d left", target.delivered, len(o.List()))
+	}
+}
+
+func TestOutbox_QueueFull(t *testing.T) {
+	ctx := context.Background()
+	o, _ := newTestOutbox(&gatedTarget{})
+	for i := 0; i < 2; i++ {
+		if err := o.Notify(ctx, Notification{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Notify(ctx, Notification{}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Notify on a full outbox = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestOutbox_ManualRetryRacesWorker(t *testing.T) {
+	ctx := context.Background()
+	target := &gatedTarget{entered: make(chan struct{}), gate: make(chan struct{})}
+	o, _ := newTestOutbox(target)
+	if err := o.Notify(ctx, Notification{}); err != nil {
+		t.Fatal(err)
+	}
+	id := o.List()[0].ID
+
+	done := make(chan struct{})
+	go func() {
+		o.deliverDue(ctx)
+		close(done)
+	}()
+	<-target.entered
+
+	// The worker is delivering it
+	if _, err := o.Retry(ctx, id); !errors.Is(err, ErrEntryBusy) {
+		t.Errorf("Retry during delivery = %v, want ErrEntryBusy", err)
+	}
+	if err := o.Drop(id); !errors.Is(err, ErrEntryBusy) {
+		t.Errorf("Drop during delivery = %v, want ErrEntryBusy", err)
+	}
+
+	close(target.gate)
+	<-done
+	if _, err := o.Retry(ctx, id); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("Retry after delivery = %v, want ErrEntryNotFound", err)
+	}
+	if target.delivered != 1 {
+		t.Errorf("delivered %d times, want once", target.delivered)
+	}
+}
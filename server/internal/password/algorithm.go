@@ -0,0 +1,169 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashing algorithms
+const (
+	AlgoBcrypt   = "bcrypt"
+	AlgoArgon2id = "argon2id"
+)
+
+// Argon2id salt and key sizes
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// ErrMalformedHash is returned if a stored hash cannot be parsed
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// Params selects the algorithm and cost of new hashes. Stored hashes
+// carry their own parameters and verify regardless of Params.
+type Params struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Memory  uint32 // KiB
+	Argon2Time    uint32 // iterations
+	Argon2Threads uint8
+}
+
+// DefaultParams returns bcrypt at its default cost, with Argon2id set to
+// the OWASP minimum (19 MiB, 2 iterations, 1 lane) once selected
+func DefaultParams() Params {
+	return Params{
+		Algorithm:     AlgoBcrypt,
+		BcryptCost:    bcrypt.DefaultCost,
+		Argon2Memory:  19 * 1024,
+		Argon2Time:    2,
+		Argon2Threads: 1,
+	}
+}
+
+// Validate checks that p describes a usable configuration
+func (p Params) Validate() error {
+	switch p.Algorithm {
+	case AlgoBcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgoArgon2id:
+		if p.Argon2Memory < 8*uint32(p.Argon2Threads) || p.Argon2Time < 1 || p.Argon2Threads < 1 {
+			return errors.New("argon2id needs time >= 1, threads >= 1 and memory >= 8 KiB per thread")
+		}
+	default:
+		return fmt.Errorf("unknown password hash algorithm %q", p.Algorithm)
+	}
+	return nil
+}
+
+// hash computes a new hash of password with p
+func (p Params) hash(password string) (string, error) {
+	if p.Algorithm == AlgoArgon2id {
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, argon2KeyLen)
+		return encodeArgon2(p, salt, key), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// needsRehash reports whether hash was made with another algorithm or
+// other parameters than p
+func (p Params) needsRehash(hash string) bool {
+	if isArgon2(hash) {
+		stored, _, key, err := decodeArgon2(hash)
+		return err != nil || p.Algorithm != AlgoArgon2id ||
+			stored.Argon2Memory != p.Argon2Memory ||
+			stored.Argon2Time != p.Argon2Time ||
+			stored.Argon2Threads != p.Argon2Threads ||
+			len(key) != argon2KeyLen
+	}
+	if p.Algorithm != AlgoBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != p.BcryptCost
+}
+
+// compareHash checks password against a bcrypt or Argon2id hash
+func compareHash(hash, password string) error {
+	if !isArgon2(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatch
+		}
+		return err
+	}
+
+	p, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		return err
+	}
+	got := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func isArgon2(hash string) bool {
+	return strings.HasPrefix(hash, "$"+AlgoArgon2id+"$")
+}
+
+// encodeArgon2 renders an Argon2id hash in PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>
+func encodeArgon2(p Params, salt, key []byte) string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		AlgoArgon2id, argon2.Version,
+		p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2 parses a PHC string produced by encodeArgon2
+func decodeArgon2(hash string) (Params, []byte, []byte, error) {
+	var p Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != AlgoArgon2id {
+		return p, nil, nil, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrMalformedHash
+	}
+	p.Algorithm = AlgoArgon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Argon2Memory, &p.Argon2Time, &p.Argon2Threads); err != nil {
+		return p, nil, nil, ErrMalformedHash
+	}
+	if p.Argon2Time < 1 || p.Argon2Threads < 1 {
+		return p, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrMalformedHash
+	}
+	return p, salt, key, nil
+}
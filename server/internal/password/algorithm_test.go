@@ -0,0 +1,100 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestArgon2idHashIsPHCString(t *testing.T) {
+	l := NewLimiter(1, time.Second, fastParams(AlgoArgon2id))
+	ctx := context.Background()
+
+	hash, err := l.Hash(ctx, "secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !regexp.MustCompile(`^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`).MatchString(hash) {
+		t.Errorf("hash %q is not a PHC argon2id string", hash)
+	}
+	if err := l.Compare(ctx, hash, "secret"); err != nil {
+		t.Errorf("Compare with correct password: %v", err)
+	}
+	if err := l.Compare(ctx, hash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Compare with wrong password: got %v, want ErrMismatch", err)
+	}
+}
+
+func TestVerifyMigratesOutdatedHashes(t *testing.T) {
+	ctx := context.Background()
+	bcryptHash, _ := NewLimiter(1, time.Second, fastParams(AlgoBcrypt)).Hash(ctx, "secret")
+	argon := NewLimiter(1, time.Second, fastParams(AlgoArgon2id))
+
+	// bcrypt hashes still verify and are upgraded to the configured algorithm
+	rehashed, err := argon.Verify(ctx, bcryptHash, "secret")
+	if err != nil {
+		t.Fatalf("Verify bcrypt hash: %v", err)
+	}
+	if !isArgon2(rehashed) {
+		t.Fatalf("rehashed = %q, want an argon2id hash", rehashed)
+	}
+	if err := argon.Compare(ctx, rehashed, "secret"); err != nil {
+		t.Errorf("rehashed password does not verify: %v", err)
+	}
+
+	// a current hash is left alone
+	if again, err := argon.Verify(ctx, rehashed, "secret"); err != nil || again != "" {
+		t.Errorf("Verify current hash = %q, %v; want no rehash", again, err)
+	}
+
+	// raising the parameters upgrades existing argon2id hashes
+	stronger := fastParams(AlgoArgon2id)
+	stronger.Argon2Time = 2
+	upgraded, err := NewLimiter(1, time.Second, stronger).Verify(ctx, rehashed, "secret")
+	if err != nil || !regexp.MustCompile(`\$m=64,t=2,p=1\$`).MatchString(upgraded) {
+		t.Errorf("Verify with new parameters = %q, %v; want t=2 hash", upgraded, err)
+	}
+
+	// a wrong password never yields a new hash
+	if rehashed, err := argon.Verify(ctx, bcryptHash, "wrong"); !errors.Is(err, ErrMismatch) || rehashed != "" {
+		t.Errorf("Verify wrong password = %q, %v; want ErrMismatch", rehashed, err)
+	}
+}
+
+func TestCompareRejectsMalformedArgon2Hash(t *testing.T) {
+	l := NewLimiter(1, time.Second, fastParams(AlgoArgon2id))
+	for _, hash := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+	} {
+		if err := l.Compare(context.Background(), hash, "secret"); !errors.Is(err, ErrMalformedHash) {
+			t.Errorf("Compare(%q) = %v, want ErrMalformedHash", hash, err)
+		}
+	}
+}
+
+func TestParamsValidate(t *testing.T) {
+	if err := DefaultParams().Validate(); err != nil {
+		t.Errorf("default params: %v", err)
+	}
+	argon := DefaultParams()
+	argon.Algorithm = AlgoArgon2id
+	if err := argon.Validate(); err != nil {
+		t.Errorf("default argon2id params: %v", err)
+	}
+
+	for name, p := range map[string]Params{
+		"unknown algorithm": {Algorithm: "md5"},
+		"bcrypt cost":       {Algorithm: AlgoBcrypt, BcryptCost: 40},
+		"argon2 time":       {Algorithm: AlgoArgon2id, Argon2Memory: 64, Argon2Threads: 1},
+		"argon2 memory":     {Algorithm: AlgoArgon2id, Argon2Memory: 4, Argon2Time: 1, Argon2Threads: 1},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted %+v", name, p)
+		}
+	}
+}
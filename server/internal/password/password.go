@@ -0,0 +1,229 @@
+// Package password hashes and verifies passwords with bcrypt or Argon2id
+// behind a bounded concurrency limit. Argon2id hashes are stored in PHC
+// string format, so every hash records its own algorithm and parameters
+// and older hashes keep verifying after the configuration changes.
+//
+// Each hash costs ~100 ms of CPU; without
+// a limit a burst of logins (e.g. every client re-authenticating after a
+// restart) saturates all cores and slows unrelated endpoints. Excess
+// callers queue briefly and are shed with ErrBusy after a timeout.
+package password
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrMismatch is returned by Compare if the password does not match
+	ErrMismatch = errors.New("password mismatch")
+	// ErrBusy is returned if no hashing slot became free in time
+	ErrBusy = errors.New("password hashing busy")
+)
+
+// MinLength is the shortest accepted password. The request models repeat
+// it in their binding tags.
+const MinLength = 8
+
+// DefaultQueueTimeout is how long a caller waits for a hashing slot
+const DefaultQueueTimeout = 2 * time.Second
+
+// Stats describes the current state of a Limiter
+type Stats struct {
+	Limit    int    `json:"limit"`
+	InFlight int64  `json:"in_flight"`
+	Waiting  int64  `json:"waiting"` // queue depth
+	Shed     uint64 `json:"shed"`    // calls rejected with ErrBusy
+	Compares uint64 `json:"compares"`
+}
+
+// Limiter bounds concurrent hashing operations
+type Limiter struct {
+	slots    chan struct{}
+	timeout  time.Duration
+	params   Params
+	inFlight atomic.Int64
+	waiting  atomic.Int64
+	shed     atomic.Uint64
+	compares atomic.Uint64
+
+	dummyOnce sync.Once
+	dummy     string // hash compared against for unknown accounts
+}
+
+// NewLimiter creates a limiter allowing concurrency hashing operations at
+// once (NumCPU if <= 0). Callers wait up to timeout for a slot. New
+// hashes use params.
+func NewLimiter(concurrency int, timeout time.Duration, params Params) *Limiter {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	return &Limiter{
+		slots:   make(chan struct{}, concurrency),
+		timeout: timeout,
+		params:  params,
+	}
+}
+
+// acquire waits for a free slot. The returned func releases it.
+func (l *Limiter) acquire(ctx context.Context) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		l.waiting.Add(1)
+		timer := time.NewTimer(l.timeout)
+		select {
+		case l.slots <- struct{}{}:
+			timer.Stop()
+			l.waiting.Add(-1)
+		case <-timer.C:
+			l.waiting.Add(-1)
+			l.shed.Add(1)
+			return nil, ErrBusy
+		case <-ctx.Done():
+			timer.Stop()
+			l.waiting.Add(-1)
+			return nil, ctx.Err()
+		}
+	}
+	l.inFlight.Add(1)
+	return func() {
+		l.inFlight.Add(-1)
+		<-l.slots
+	}, nil
+}
+
+// Hash returns the hash of password with the configured algorithm
+func (l *Limiter) Hash(ctx context.Context, password string) (string, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	return l.params.hash(password)
+}
+
+// Compare checks password against a bcrypt or Argon2id hash. It returns
+// ErrMismatch if they do not match.
+func (l *Limiter) Compare(ctx context.Context, hash, password string) error {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return l.compare(hash, password)
+}
+
+// CompareDummy compares password against a hash of a fixed string made
+// with the configured parameters. Logins for unknown accounts call it so
+// they take as long as a wrong password for an existing one. It returns
+// ErrMismatch, or ErrBusy like Compare.
+func (l *Limiter) CompareDummy(ctx context.Context, password string) error {
+	l.dummyOnce.Do(func() {
+		l.dummy, _ = l.params.hash("vibedterm-dummy-password")
+	})
+
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// The result is ignored: no account exists to log in to
+	_ = l.compare(l.dummy, password)
+	return ErrMismatch
+}
+
+// compare counts and runs a hash comparison
+func (l *Limiter) compare(hash, password string) error {
+	l.compares.Add(1)
+	return compareHash(hash, password)
+}
+
+// Verify is Compare for logins. If the password matches a hash made with
+// outdated algorithm or parameters, it also returns a new hash for the
+// caller to store; otherwise rehashed is empty. Rehashing is best effort
+// and is retried on the next login if it fails.
+func (l *Limiter) Verify(ctx context.Context, hash, password string) (rehashed string, err error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	if err := l.compare(hash, password); err != nil {
+		return "", err
+	}
+	if !l.params.needsRehash(hash) {
+		return "", nil
+	}
+	rehashed, err = l.params.hash(password)
+	if err != nil {
+		return "", nil
+	}
+	return rehashed, nil
+}
+
+// Stats returns a snapshot of the limiter state
+func (l *Limiter) Stats() Stats {
+	return Stats{
+		Limit:    cap(l.slots),
+		InFlight: l.inFlight.Load(),
+		Waiting:  l.waiting.Load(),
+		Shed:     l.shed.Load(),
+		Compares: l.compares.Load(),
+	}
+}
+
+// The package-level limiter is used by Hash and Compare. Configure
+// replaces it at startup.
+var std atomic.Pointer[Limiter]
+
+func init() {
+	std.Store(NewLimiter(0, DefaultQueueTimeout, DefaultParams()))
+	expvar.Publish("password_hashing", expvar.Func(func() interface{} { return std.Load().Stats() }))
+}
+
+// Configure sets the concurrency limit, queue timeout and hashing
+// parameters of the package-level limiter
+func Configure(concurrency int, timeout time.Duration, params Params) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	std.Store(NewLimiter(concurrency, timeout, params))
+	return nil
+}
+
+// Hash returns the hash of password using the package-level limiter
+func Hash(ctx context.Context, password string) (string, error) {
+	return std.Load().Hash(ctx, password)
+}
+
+// Compare checks password against hash using the package-level limiter
+func Compare(ctx context.Context, hash, password string) error {
+	return std.Load().Compare(ctx, hash, password)
+}
+
+// Verify checks password against hash using the package-level limiter
+// and returns a replacement hash if hash is outdated
+func Verify(ctx context.Context, hash, password string) (string, error) {
+	return std.Load().Verify(ctx, hash, password)
+}
+
+// CompareDummy runs a comparison for an unknown account using the
+// package-level limiter
+func CompareDummy(ctx context.Context, password string) error {
+	return std.Load().CompareDummy(ctx, password)
+}
+
+// CurrentStats returns the state of the package-level limiter
+func CurrentStats() Stats {
+	return std.Load().Stats()
+}
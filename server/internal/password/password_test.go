@@ -0,0 +1,169 @@
+package password
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastParams keeps hashing cheap in tests
+func fastParams(algo string) Params {
+	return Params{Algorithm: algo, BcryptCost: bcrypt.MinCost, Argon2Memory: 64, Argon2Time: 1, Argon2Threads: 1}
+}
+
+func newTestLimiter(concurrency int, timeout time.Duration) *Limiter {
+	return NewLimiter(concurrency, timeout, fastParams(AlgoBcrypt))
+}
+
+func TestHashAndCompare(t *testing.T) {
+	l := newTestLimiter(2, time.Second)
+	ctx := context.Background()
+
+	hash, err := l.Hash(ctx, "secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if err := l.Compare(ctx, hash, "secret"); err != nil {
+		t.Errorf("Compare with correct password: %v", err)
+	}
+	if err := l.Compare(ctx, hash, "wrong"); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Compare with wrong password: got %v, want ErrMismatch", err)
+	}
+}
+
+func TestShedsWhenSlotsStayBusy(t *testing.T) {
+	l := newTestLimiter(1, 20*time.Millisecond)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	start := time.Now()
+	if _, err := l.Hash(context.Background(), "secret"); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected ErrBusy, got %v", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("shed after %v, before the queue timeout", waited)
+	}
+
+	stats := l.Stats()
+	if stats.Shed != 1 || stats.Waiting != 0 || stats.InFlight != 1 || stats.Limit != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQueuedCallerGetsFreedSlot(t *testing.T) {
+	l := newTestLimiter(1, 5*time.Second)
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Hash(context.Background(), "secret")
+		done <- err
+	}()
+
+	// Wait until the caller is queued, then free the slot
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().Waiting != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("caller never queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	release()
+
+	if err := <-done; err != nil {
+		t.Fatalf("queued Hash: %v", err)
+	}
+	if stats := l.Stats(); stats.Shed != 0 || stats.Waiting != 0 || stats.InFlight != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestQueuedCallerHonoursContext(t *testing.T) {
+	l := newTestLimiter(1, 5*time.Second)
+	release, _ := l.acquire(context.Background())
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Compare(ctx, "hash", "secret"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+	if l.Stats().Shed != 0 {
+		t.Error("cancelled caller counted as shed")
+	}
+}
+
+// simulatePull does the CPU work of a vault pull: encoding a blob as
+// base64 inside a JSON response
+func simulatePull(blob []byte) {
+	_, _ = json.Marshal(map[string]string{"vault_blob": base64.StdEncoding.EncodeToString(blob)})
+}
+
+// BenchmarkPullUnderLoginLoad measures vault pull latency while a burst of
+// logins runs bcrypt at the default cost, with and without a limit
+func BenchmarkPullUnderLoginLoad(b *testing.B) {
+	blob := make([]byte, 256<<10)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.DefaultCost)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name        string
+		concurrency int
+	}{
+		{"unbounded", 1 << 20},
+		{"limit=NumCPU", runtime.NumCPU()},
+		{"limit=NumCPU/2", max(runtime.NumCPU()/2, 1)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			l := NewLimiter(bc.concurrency, time.Minute, DefaultParams())
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			for range 4 * runtime.NumCPU() {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for ctx.Err() == nil {
+						_ = l.Compare(ctx, string(hash), "secret")
+					}
+				}()
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				simulatePull(blob)
+			}
+			b.StopTimer()
+			cancel()
+			wg.Wait()
+		})
+	}
+}
+
+func TestCompareDummy(t *testing.T) {
+	l := newTestLimiter(1, time.Second)
+	ctx := context.Background()
+
+	for _, pw := range []string{"guess", "vibedterm-dummy-password"} {
+		if err := l.CompareDummy(ctx, pw); !errors.Is(err, ErrMismatch) {
+			t.Errorf("CompareDummy(%q) = %v, want ErrMismatch", pw, err)
+		}
+	}
+	if got := l.Stats().Compares; got != 2 {
+		t.Errorf("compares = %d, want 2", got)
+	}
+}
@@ -0,0 +1,134 @@
+// Package recoverycode generates the one-time codes that replace the
+// second factor and matches them against their stored hashes.
+//
+// Codes are stored as an HMAC-SHA256 keyed with a server secret and
+// salted with the user ID, tagged with hmacPrefix. Untagged hashes are
+// unsalted SHA-256 from before the change and keep matching until the
+// user's codes are regenerated.
+package recoverycode
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// Bytes is the entropy of newly generated codes. Codes issued before the
+// increase used 5 bytes and remain valid.
+const Bytes = 10
+
+// Count is the number of codes issued at a time
+const Count = 10
+
+// groupSize is the number of hex digits between dashes of a code
+const groupSize = 5
+
+// hmacPrefix tags hashes keyed with the server secret
+const hmacPrefix = "hmac-sha256$"
+
+// key is the HMAC key set by Configure
+var key atomic.Pointer[[]byte]
+
+// Configure sets the server secret new hashes are keyed with. Changing it
+// invalidates all codes hashed with the previous one.
+func Configure(secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New("recovery code key is empty")
+	}
+	k := append([]byte(nil), secret...)
+	key.Store(&k)
+	return nil
+}
+
+func currentKey() []byte {
+	if k := key.Load(); k != nil {
+		return *k
+	}
+	return nil
+}
+
+// Store saves the hashes of issued codes
+type Store interface {
+	ReplaceAllForUser(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+}
+
+// Issue generates Count codes for userID and stores their hashes in
+// place of all earlier codes. The codes are returned to be shown to the
+// user once.
+func Issue(ctx context.Context, store Store, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, Count)
+	hashes := make([]string, Count)
+	for i := range codes {
+		codes[i] = Generate()
+		hashes[i] = Hash(userID, codes[i])
+	}
+	if err := store.ReplaceAllForUser(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Generate returns a new random code as dash-separated groups of hex
+// digits, e.g. 3f9a1-0c4d2-b7e05-61a8f
+func Generate() string {
+	b := make([]byte, Bytes)
+	rand.Read(b)
+	digits := hex.EncodeToString(b)
+
+	var code strings.Builder
+	for i := 0; i < len(digits); i += groupSize {
+		if i > 0 {
+			code.WriteByte('-')
+		}
+		code.WriteString(digits[i:min(i+groupSize, len(digits))])
+	}
+	return code.String()
+}
+
+// normalize accepts codes typed with spaces, dashes or uppercase
+func normalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Hash returns the stored form of a code issued to userID
+func Hash(userID uuid.UUID, code string) string {
+	mac := hmac.New(sha256.New, currentKey())
+	mac.Write(userID[:])
+	mac.Write([]byte(normalize(code)))
+	return hmacPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// legacyHash is the unsalted form of codes stored before Hash was keyed
+func legacyHash(code string) string {
+	hash := sha256.Sum256([]byte(normalize(code)))
+	return hex.EncodeToString(hash[:])
+}
+
+// Match finds the code matching input. Every candidate is compared in
+// constant time and the loop never exits early.
+func Match(codes []models.RecoveryCode, input string) *models.RecoveryCode {
+	legacy := []byte(legacyHash(input))
+
+	var match *models.RecoveryCode
+	for i := range codes {
+		want := legacy
+		if strings.HasPrefix(codes[i].CodeHash, hmacPrefix) {
+			want = []byte(Hash(codes[i].UserID, input))
+		}
+		if subtle.ConstantTimeCompare([]byte(codes[i].CodeHash), want) == 1 {
+			match = &codes[i]
+		}
+	}
+	return match
+}
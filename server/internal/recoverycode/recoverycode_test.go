@@ -0,0 +1,109 @@
+package recoverycode
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+type memStore struct{ hashes []string }
+
+func (m *memStore) ReplaceAllForUser(_ context.Context, _ uuid.UUID, codeHashes []string) error {
+	m.hashes = codeHashes
+	return nil
+}
+
+func TestGenerate_Format(t *testing.T) {
+	code := Generate()
+	if !regexp.MustCompile(`^[0-9a-f]{5}(-[0-9a-f]{5}){3}$`).MatchString(code) {
+		t.Errorf("code %q is not four dash-separated groups of five hex digits", code)
+	}
+	if len(normalize(code)) != Bytes*2 {
+		t.Errorf("code %q carries %d hex digits, want %d", code, len(normalize(code)), Bytes*2)
+	}
+}
+
+func TestIssue(t *testing.T) {
+	store := &memStore{hashes: []string{"old"}}
+	userID := uuid.New()
+	codes, err := Issue(context.Background(), store, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != Count || len(store.hashes) != Count {
+		t.Fatalf("issued %d codes, stored %d hashes, want %d", len(codes), len(store.hashes), Count)
+	}
+	for i, code := range codes {
+		if store.hashes[i] != Hash(userID, code) {
+			t.Errorf("code %d stored as %q, want its hash", i, store.hashes[i])
+		}
+	}
+}
+
+func TestHash_SaltedAndKeyed(t *testing.T) {
+	t.Cleanup(func() { key.Store(nil) })
+	alice, bob := uuid.New(), uuid.New()
+
+	h := Hash(alice, "1111111111")
+	if !strings.HasPrefix(h, hmacPrefix) {
+		t.Fatalf("hash %q is not tagged", h)
+	}
+	if h == Hash(bob, "1111111111") {
+		t.Error("the same code hashes alike for different users")
+	}
+	if err := Configure([]byte("server secret")); err != nil {
+		t.Fatal(err)
+	}
+	if h == Hash(alice, "1111111111") {
+		t.Error("the hash does not depend on the key")
+	}
+	if Configure(nil) == nil {
+		t.Error("Configure accepted an empty key")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	userID := uuid.New()
+	codes := []models.RecoveryCode{
+		{ID: uuid.New(), UserID: userID, CodeHash: Hash(userID, "1111111111")},
+		{ID: uuid.New(), UserID: userID, CodeHash: Hash(userID, "2222222222")},
+	}
+
+	if got := Match(codes, "2222222222"); got == nil || got.ID != codes[1].ID {
+		t.Errorf("Match returned %v, want second code", got)
+	}
+	if got := Match(codes, " 22222-22222 "); got == nil || got.ID != codes[1].ID {
+		t.Errorf("Match returned %v for a formatted code, want second code", got)
+	}
+	if got := Match(codes, "3333333333"); got != nil {
+		t.Errorf("Match returned %v for unknown code, want nil", got)
+	}
+	if got := Match(nil, "1111111111"); got != nil {
+		t.Errorf("Match returned %v for no codes, want nil", got)
+	}
+}
+
+func TestMatch_LegacyHashes(t *testing.T) {
+	userID := uuid.New()
+	codes := []models.RecoveryCode{
+		// 5-byte code stored as unsalted SHA-256
+		{ID: uuid.New(), UserID: userID, CodeHash: legacyHash("a1b2c3d4e5")},
+		{ID: uuid.New(), UserID: userID, CodeHash: Hash(userID, "1111111111")},
+	}
+
+	if got := Match(codes, "A1B2C-3D4E5"); got == nil || got.ID != codes[0].ID {
+		t.Errorf("Match returned %v, want the legacy code", got)
+	}
+	if got := Match(codes, "1111111111"); got == nil || got.ID != codes[1].ID {
+		t.Errorf("Match returned %v, want the keyed code", got)
+	}
+	// A keyed hash is not accepted as an unsalted one and vice versa
+	if got := Match(codes[1:], legacyHash("1111111111")); got != nil {
+		t.Errorf("Match accepted a hash as code: %v", got)
+	}
+}
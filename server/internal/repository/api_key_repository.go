@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyRepository handles API key database operations
+type APIKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(db *pgxpool.Pool) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// Create creates a new API key
+func (r *APIKeyRepository) Create(ctx context.Context, userID uuid.UUID, name, keyPrefix, keyHash string, scopes []string) (*models.APIKey, error) {
+	key := &models.APIKey{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		KeyPrefix: keyPrefix,
+		KeyHash:   keyHash,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO api_keys (id, user_id, name, key_prefix, key_hash, scopes, revoked, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, false, $7)
+	`, key.ID, key.UserID, key.Name, key.KeyPrefix, key.KeyHash, key.Scopes, key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetByHash retrieves an API key by the hash of its secret
+func (r *APIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, name, key_prefix, key_hash, scopes, revoked, last_used_at, created_at
+		FROM api_keys WHERE key_hash = $1
+	`, keyHash).Scan(
+		&key.ID, &key.UserID, &key.Name, &key.KeyPrefix, &key.KeyHash,
+		&key.Scopes, &key.Revoked, &key.LastUsedAt, &key.CreatedAt,
+	)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetActiveByUser lists the unrevoked API keys of a user, newest first
+func (r *APIKeyRepository) GetActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, name, key_prefix, key_hash, scopes, revoked, last_used_at, created_at
+		FROM api_keys WHERE user_id = $1 AND NOT revoked
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		if err := rows.Scan(
+			&key.ID, &key.UserID, &key.Name, &key.KeyPrefix, &key.KeyHash,
+			&key.Scopes, &key.Revoked, &key.LastUsedAt, &key.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke revokes one of a user's API keys
+func (r *APIKeyRepository) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE api_keys SET revoked = true WHERE id = $1 AND user_id = $2 AND NOT revoked
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// UpdateLastUsed updates the last used timestamp
+func (r *APIKeyRepository) UpdateLastUsed(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = NOW() WHERE id = $1
+	`, id)
+	return err
+}
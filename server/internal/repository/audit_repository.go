@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// AuditRepository handles audit event database operations
+type AuditRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewAuditRepository creates a new audit repository
+func NewAuditRepository(db *pgxpool.Pool) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// Create stores an audit event, filling in ID and CreatedAt if unset
+func (r *AuditRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	return insertAuditEvent(ctx, r.db, event)
+}
+
+// insertAuditEvent stores an audit event with q, so other repositories
+// can record events in their transactions
+func insertAuditEvent(ctx context.Context, q interface {
+	Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
+}, event *models.AuditEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	_, err := q.Exec(ctx, `
+		INSERT INTO audit_events (id, action, actor_id, target_id, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, event.ID, event.Action, event.ActorID, event.TargetID, event.Details, event.CreatedAt)
+	return err
+}
+
+// List returns audit events, newest first. If before is set only older
+// events are returned, for paging through the log.
+func (r *AuditRepository) List(ctx context.Context, before *time.Time, limit int) ([]models.AuditEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, action, actor_id, target_id, details, created_at
+		FROM audit_events
+		WHERE $1::timestamp IS NULL OR created_at < $1
+		ORDER BY created_at DESC LIMIT $2
+	`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		err := rows.Scan(&event.ID, &event.Action, &event.ActorID, &event.TargetID, &event.Details, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
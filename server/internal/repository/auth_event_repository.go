@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// AuthEventRepository handles auth event database operations
+type AuthEventRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewAuthEventRepository creates a new auth event repository
+func NewAuthEventRepository(db *pgxpool.Pool) *AuthEventRepository {
+	return &AuthEventRepository{db: db}
+}
+
+// Create stores an auth event, filling in ID and CreatedAt if unset
+func (r *AuthEventRepository) Create(ctx context.Context, event *models.AuthEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO auth_events (id, user_id, actor_id, type, outcome, reason, email, ip, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''), $10)
+	`, event.ID, event.UserID, event.ActorID, event.Type, event.Outcome, event.Reason, event.Email,
+		event.IP, event.UserAgent, event.CreatedAt)
+	return err
+}
+
+// ListByUser returns the latest events of a user, newest first
+func (r *AuthEventRepository) ListByUser(ctx context.Context, userID uuid.UUID, limit int) ([]models.AuthEvent, error) {
+	return r.ListAll(ctx, models.AuthEventQuery{UserID: &userID, Limit: limit})
+}
+
+// ListAll returns events matching the query, newest first
+func (r *AuthEventRepository) ListAll(ctx context.Context, q models.AuthEventQuery) ([]models.AuthEvent, error) {
+	pattern := ""
+	if q.Type != "" {
+		pattern = typePattern(q.Type)
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, actor_id, type, outcome, COALESCE(reason, ''), COALESCE(email, ''),
+		       COALESCE(ip, ''), COALESCE(user_agent, ''), created_at
+		FROM auth_events
+		WHERE ($1::uuid IS NULL OR user_id = $1)
+		  AND ($2 = '' OR type LIKE $2)
+		  AND ($3::timestamp IS NULL OR created_at < $3)
+		ORDER BY created_at DESC LIMIT $4
+	`, q.UserID, pattern, q.Before, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuthEvent
+	for rows.Next() {
+		var event models.AuthEvent
+		err := rows.Scan(
+			&event.ID, &event.UserID, &event.ActorID, &event.Type, &event.Outcome, &event.Reason, &event.Email,
+			&event.IP, &event.UserAgent, &event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// DeleteOlderThan removes events recorded before cutoff
+func (r *AuthEventRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM auth_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrBootstrapTokenNotFound = errors.New("bootstrap token not found")
+	ErrBootstrapTokenExpired  = errors.New("bootstrap token expired")
+	ErrBootstrapTokenUsed     = errors.New("bootstrap token already used")
+)
+
+// BootstrapTokenRepository handles one-time instance bootstrap tokens
+type BootstrapTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewBootstrapTokenRepository creates a new bootstrap token repository
+func NewBootstrapTokenRepository(db *pgxpool.Pool) *BootstrapTokenRepository {
+	return &BootstrapTokenRepository{db: db}
+}
+
+// Replace stores a new token hash, discarding any unused earlier tokens
+func (r *BootstrapTokenRepository) Replace(ctx context.Context, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM bootstrap_tokens WHERE used_at IS NULL`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO bootstrap_tokens (token_hash, expires_at) VALUES ($1, $2)
+	`, tokenHash, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Consume atomically marks an unused, unexpired token as used
+func (r *BootstrapTokenRepository) Consume(ctx context.Context, tokenHash string) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE bootstrap_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	`, tokenHash)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 1 {
+		return nil
+	}
+
+	// Explain why the token could not be consumed
+	var expiresAt time.Time
+	var usedAt *time.Time
+	err = r.db.QueryRow(ctx, `
+		SELECT expires_at, used_at FROM bootstrap_tokens WHERE token_hash = $1
+	`, tokenHash).Scan(&expiresAt, &usedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrBootstrapTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if usedAt != nil {
+		return ErrBootstrapTokenUsed
+	}
+	return ErrBootstrapTokenExpired
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+var (
+	ErrClientSettingsNotFound = errors.New("client settings not found")
+	// ErrClientSettingsConflict means another write won the race
+	ErrClientSettingsConflict = errors.New("client settings changed concurrently")
+)
+
+// ClientSettingsRepository handles the per-user client settings blob
+type ClientSettingsRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewClientSettingsRepository creates a new client settings repository
+func NewClientSettingsRepository(db *pgxpool.Pool) *ClientSettingsRepository {
+	return &ClientSettingsRepository{db: db}
+}
+
+// GetByUserID retrieves the settings blob of a user
+func (r *ClientSettingsRepository) GetByUserID(ctx context.Context, userID uuid.UUID) (*models.ClientSettings, error) {
+	settings := &models.ClientSettings{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, settings_blob, revision, updated_by_device, created_at, updated_at
+		FROM client_settings WHERE user_id = $1
+	`, userID).Scan(
+		&settings.ID, &settings.UserID, &settings.SettingsBlob, &settings.Revision,
+		&settings.UpdatedByDevice, &settings.CreatedAt, &settings.UpdatedAt,
+	)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrClientSettingsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// Create stores the first settings blob of a user at revision 1
+func (r *ClientSettingsRepository) Create(ctx context.Context, userID uuid.UUID, blob []byte, deviceID *uuid.UUID) (*models.ClientSettings, error) {
+	settings := &models.ClientSettings{}
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO client_settings (user_id, settings_blob, revision, updated_by_device)
+		VALUES ($1, $2, 1, $3)
+		RETURNING id, user_id, settings_blob, revision, updated_by_device, created_at, updated_at
+	`, userID, blob, deviceRef(deviceID)).Scan(
+		&settings.ID, &settings.UserID, &settings.SettingsBlob, &settings.Revision,
+		&settings.UpdatedByDevice, &settings.CreatedAt, &settings.UpdatedAt,
+	)
+
+	if isUniqueViolation(err, "") {
+		return nil, ErrClientSettingsConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// UpdateWithRevisionCheck replaces the blob only if the stored revision
+// still matches (optimistic locking)
+func (r *ClientSettingsRepository) UpdateWithRevisionCheck(ctx context.Context, userID uuid.UUID, blob []byte, expectedRevision int, deviceID *uuid.UUID) (*models.ClientSettings, error) {
+	settings := &models.ClientSettings{}
+	err := r.db.QueryRow(ctx, `
+		UPDATE client_settings
+		SET settings_blob = $2, revision = revision + 1, updated_by_device = $4, updated_at = NOW()
+		WHERE user_id = $1 AND revision = $3
+		RETURNING id, user_id, settings_blob, revision, updated_by_device, created_at, updated_at
+	`, userID, blob, expectedRevision, deviceRef(deviceID)).Scan(
+		&settings.ID, &settings.UserID, &settings.SettingsBlob, &settings.Revision,
+		&settings.UpdatedByDevice, &settings.CreatedAt, &settings.UpdatedAt,
+	)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrClientSettingsConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
@@ -12,20 +12,34 @@ import (
 	"github.com/sprobst76/vibedterm-server/internal/models"
 )
 
-var ErrDeviceNotFound = errors.New("device not found")
+var (
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrDeviceNameTaken is returned by UpdateName if another device of
+	// the user has the name
+	ErrDeviceNameTaken = errors.New("device name already in use")
+)
+
+// maxDeviceUserAgentLength caps the stored user agent of a device
+const maxDeviceUserAgentLength = 512
 
 // DeviceRepository handles device database operations
 type DeviceRepository struct {
-	db *pgxpool.Pool
+	db       *pgxpool.Pool
+	recordIP bool // store client addresses in UpdateActivity
 }
 
-// NewDeviceRepository creates a new device repository
-func NewDeviceRepository(db *pgxpool.Pool) *DeviceRepository {
-	return &DeviceRepository{db: db}
+// NewDeviceRepository creates a new device repository. Unless recordIP is
+// set, UpdateActivity stores only the user agent.
+func NewDeviceRepository(db *pgxpool.Pool, recordIP bool) *DeviceRepository {
+	return &DeviceRepository{db: db, recordIP: recordIP}
 }
 
-// Create creates a new device
-func (r *DeviceRepository) Create(ctx context.Context, userID uuid.UUID, name, deviceType, model, appVersion string) (*models.Device, error) {
+// Create creates a device, or updates the user's device of the same name;
+// an empty model or app version keeps the stored one. It reports whether
+// the device is new. A new device is unapproved if the user requires
+// device approval and already has an approved device, so the first device
+// never waits.
+func (r *DeviceRepository) Create(ctx context.Context, userID uuid.UUID, name, deviceType, model, appVersion string) (*models.Device, bool, error) {
 	device := &models.Device{
 		ID:          uuid.New(),
 		UserID:      userID,
@@ -37,33 +51,45 @@ func (r *DeviceRepository) Create(ctx context.Context, userID uuid.UUID, name, d
 		UpdatedAt:   time.Now(),
 	}
 
-	_, err := r.db.Exec(ctx, `
-		INSERT INTO devices (id, user_id, device_name, device_type, device_model, app_version, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	// On conflict the existing row is returned, not the values above.
+	// xmax is only set on the row if the conflict updated it.
+	var created bool
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO devices (id, user_id, device_name, device_type, device_model, app_version, created_at, updated_at, is_approved)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOT EXISTS (
+			SELECT 1 FROM users u WHERE u.id = $2 AND u.require_device_approval
+				AND EXISTS (SELECT 1 FROM devices d WHERE d.user_id = $2 AND d.is_approved)
+		))
 		ON CONFLICT (user_id, device_name) DO UPDATE SET
 			device_type = EXCLUDED.device_type,
-			device_model = EXCLUDED.device_model,
-			app_version = EXCLUDED.app_version,
+			device_model = COALESCE(NULLIF(EXCLUDED.device_model, ''), devices.device_model),
+			app_version = COALESCE(NULLIF(EXCLUDED.app_version, ''), devices.app_version),
 			updated_at = NOW()
-		RETURNING id
-	`, device.ID, device.UserID, device.DeviceName, device.DeviceType, device.DeviceModel, device.AppVersion, device.CreatedAt, device.UpdatedAt)
+		RETURNING id, COALESCE(device_model, ''), COALESCE(app_version, ''), created_at, updated_at, last_sync_at, last_seen_at, last_seen_revision,
+			COALESCE(last_ip, ''), COALESCE(last_user_agent, ''), read_only, is_approved, xmax = 0
+	`, device.ID, device.UserID, device.DeviceName, device.DeviceType, device.DeviceModel, device.AppVersion, device.CreatedAt, device.UpdatedAt).Scan(
+		&device.ID, &device.DeviceModel, &device.AppVersion, &device.CreatedAt, &device.UpdatedAt, &device.LastSyncAt, &device.LastSeenAt, &device.LastSeenRevision,
+		&device.LastIP, &device.LastUserAgent, &device.ReadOnly, &device.IsApproved, &created,
+	)
 
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	return device, nil
+	return device, created, nil
 }
 
 // GetByID retrieves a device by ID
 func (r *DeviceRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error) {
 	device := &models.Device{}
 	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, device_name, device_type, device_model, app_version, last_sync_at, created_at, updated_at
+		SELECT id, user_id, device_name, device_type, device_model, app_version, last_sync_at, last_seen_at, last_seen_revision,
+			COALESCE(last_ip, ''), COALESCE(last_user_agent, ''), read_only, is_approved, created_at, updated_at
 		FROM devices WHERE id = $1
 	`, id).Scan(
 		&device.ID, &device.UserID, &device.DeviceName, &device.DeviceType, &device.DeviceModel,
-		&device.AppVersion, &device.LastSyncAt, &device.CreatedAt, &device.UpdatedAt,
+		&device.AppVersion, &device.LastSyncAt, &device.LastSeenAt, &device.LastSeenRevision,
+		&device.LastIP, &device.LastUserAgent, &device.ReadOnly, &device.IsApproved, &device.CreatedAt, &device.UpdatedAt,
 	)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -79,7 +105,8 @@ func (r *DeviceRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.D
 // GetByUserID retrieves all devices for a user
 func (r *DeviceRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
 	rows, err := r.db.Query(ctx, `
-		SELECT id, user_id, device_name, device_type, device_model, app_version, last_sync_at, created_at, updated_at
+		SELECT id, user_id, device_name, device_type, device_model, app_version, last_sync_at, last_seen_at, last_seen_revision,
+			COALESCE(last_ip, ''), COALESCE(last_user_agent, ''), read_only, is_approved, created_at, updated_at
 		FROM devices WHERE user_id = $1 ORDER BY last_sync_at DESC NULLS LAST
 	`, userID)
 	if err != nil {
@@ -92,7 +119,8 @@ func (r *DeviceRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([
 		var device models.Device
 		err := rows.Scan(
 			&device.ID, &device.UserID, &device.DeviceName, &device.DeviceType, &device.DeviceModel,
-			&device.AppVersion, &device.LastSyncAt, &device.CreatedAt, &device.UpdatedAt,
+			&device.AppVersion, &device.LastSyncAt, &device.LastSeenAt, &device.LastSeenRevision,
+			&device.LastIP, &device.LastUserAgent, &device.ReadOnly, &device.IsApproved, &device.CreatedAt, &device.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -103,25 +131,61 @@ func (r *DeviceRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([
 	return devices, nil
 }
 
-// UpdateLastSync updates the last sync timestamp
-func (r *DeviceRepository) UpdateLastSync(ctx context.Context, id uuid.UUID) error {
+// UpdateLastSync records that the device just pulled or pushed revision
+func (r *DeviceRepository) UpdateLastSync(ctx context.Context, id uuid.UUID, revision int) error {
 	_, err := r.db.Exec(ctx, `
-		UPDATE devices SET last_sync_at = NOW(), updated_at = NOW() WHERE id = $1
+		UPDATE devices SET last_sync_at = NOW(), last_seen_revision = $2, updated_at = NOW() WHERE id = $1
+	`, id, revision)
+	return err
+}
+
+// TouchLastSeen records that the device just refreshed its tokens
+func (r *DeviceRepository) TouchLastSeen(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE devices SET last_seen_at = NOW() WHERE id = $1
 	`, id)
 	return err
 }
 
-// UpdateName updates the device name
-func (r *DeviceRepository) UpdateName(ctx context.Context, id uuid.UUID, name string) error {
+// UpdateActivity records the client address and user agent the device
+// was last used from
+func (r *DeviceRepository) UpdateActivity(ctx context.Context, id uuid.UUID, ip, userAgent string) error {
+	var lastIP *string
+	if r.recordIP && ip != "" {
+		lastIP = &ip
+	}
+	if len(userAgent) > maxDeviceUserAgentLength {
+		userAgent = userAgent[:maxDeviceUserAgentLength]
+	}
 	_, err := r.db.Exec(ctx, `
-		UPDATE devices SET device_name = $2, updated_at = NOW() WHERE id = $1
-	`, id, name)
+		UPDATE devices SET last_ip = $2, last_user_agent = NULLIF($3, '') WHERE id = $1
+	`, id, lastIP, userAgent)
 	return err
 }
 
-// Delete deletes a device
-func (r *DeviceRepository) Delete(ctx context.Context, id uuid.UUID) error {
-	result, err := r.db.Exec(ctx, `DELETE FROM devices WHERE id = $1`, id)
+// Update changes the name and read-only flag of a device; nil leaves a
+// field as it is. It returns ErrDeviceNameTaken if another device of the
+// user has the name.
+func (r *DeviceRepository) Update(ctx context.Context, id uuid.UUID, name *string, readOnly *bool) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE devices SET device_name = COALESCE($2, device_name), read_only = COALESCE($3, read_only), updated_at = NOW()
+		WHERE id = $1
+	`, id, name, readOnly)
+	if isUniqueViolation(err, "devices_user_id_device_name_key") {
+		return ErrDeviceNameTaken
+	}
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// Approve lets a device awaiting approval use the vault
+func (r *DeviceRepository) Approve(ctx context.Context, id uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `UPDATE devices SET is_approved = true, updated_at = NOW() WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
@@ -131,9 +195,94 @@ func (r *DeviceRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
+// Delete deletes a device. Vaults it last updated no longer name it.
+func (r *DeviceRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// The original vault table references devices without ON DELETE
+	if _, err := tx.Exec(ctx, `UPDATE encrypted_vaults SET updated_by_device = NULL WHERE updated_by_device = $1`, id); err != nil {
+		return err
+	}
+	result, err := tx.Exec(ctx, `DELETE FROM devices WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrDeviceNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+// ListInactive returns up to limit devices last synced before cutoff, or
+// created before it if they never synced, oldest first. Unless
+// allAccounts is set only devices of accounts that opted in are listed.
+// Devices named by sync log entries are left out: the entries are hash
+// chained and cannot drop the reference.
+func (r *DeviceRepository) ListInactive(ctx context.Context, cutoff time.Time, allAccounts bool, limit int) ([]models.Device, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT d.id, d.user_id, d.device_name, d.last_sync_at, d.created_at
+		FROM devices d JOIN users u ON u.id = d.user_id
+		WHERE COALESCE(d.last_sync_at, d.created_at) < $1
+			AND ($2 OR u.prune_inactive_devices)
+			AND NOT EXISTS (SELECT 1 FROM sync_logs l WHERE l.device_id = d.id)
+		ORDER BY COALESCE(d.last_sync_at, d.created_at)
+		LIMIT $3
+	`, cutoff, allAccounts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		var d models.Device
+		if err := rows.Scan(&d.ID, &d.UserID, &d.DeviceName, &d.LastSyncAt, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
 // Count returns the total number of devices
 func (r *DeviceRepository) Count(ctx context.Context) (int, error) {
 	var count int
 	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM devices`).Scan(&count)
 	return count, err
 }
+
+// CountByAppVersion returns the number of devices per reported app
+// version, most common first
+func (r *DeviceRepository) CountByAppVersion(ctx context.Context) ([]models.AppVersionCount, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT COALESCE(app_version, ''), COUNT(*) FROM devices
+		GROUP BY 1 ORDER BY 2 DESC, 1 DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.AppVersionCount
+	for rows.Next() {
+		var c models.AppVersionCount
+		if err := rows.Scan(&c.Version, &c.Devices); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// deviceRef returns deviceID for a column referencing devices, or nil for
+// callers without a device, like API keys, which pass uuid.Nil
+func deviceRef(deviceID *uuid.UUID) *uuid.UUID {
+	if deviceID == nil || *deviceID == uuid.Nil {
+		return nil
+	}
+	return deviceID
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// domainEventSettle is how old an event must be before it is exported.
+// Sequence numbers are handed out before the inserting statement
+// commits, so a cursor taken right at the head of the log could skip an
+// event that commits a moment later with a lower number.
+const domainEventSettle = "2 seconds"
+
+// DomainEventRepository handles domain event database operations
+type DomainEventRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewDomainEventRepository creates a new domain event repository
+func NewDomainEventRepository(db *pgxpool.Pool) *DomainEventRepository {
+	return &DomainEventRepository{db: db}
+}
+
+// Append stores an event and fills in its sequence ID
+func (r *DomainEventRepository) Append(ctx context.Context, event *models.DomainEvent) error {
+	return r.db.QueryRow(ctx, `
+		INSERT INTO domain_events (type, occurred_at, actor_id, subject_id, ip, payload)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		RETURNING seq
+	`, event.Type, event.OccurredAt, event.ActorID, event.SubjectID, event.IP, event.Data).Scan(&event.Seq)
+}
+
+// List returns settled events matching the query in sequence order
+func (r *DomainEventRepository) List(ctx context.Context, q models.DomainEventQuery) ([]models.DomainEvent, error) {
+	patterns := make([]string, 0, len(q.Types))
+	for _, t := range q.Types {
+		patterns = append(patterns, typePattern(t))
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT seq, type, occurred_at, actor_id, subject_id, COALESCE(ip, ''), payload
+		FROM domain_events
+		WHERE seq > $1
+		  AND ($2::timestamptz IS NULL OR occurred_at >= $2)
+		  AND ($3::timestamptz IS NULL OR occurred_at < $3)
+		  AND (cardinality($4::text[]) = 0 OR type LIKE ANY($4))
+		  AND recorded_at < NOW() - INTERVAL '`+domainEventSettle+`'
+		ORDER BY seq LIMIT $5
+	`, q.After, q.From, q.To, patterns, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.DomainEvent
+	for rows.Next() {
+		var event models.DomainEvent
+		err := rows.Scan(&event.Seq, &event.Type, &event.OccurredAt, &event.ActorID, &event.SubjectID, &event.IP, &event.Data)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// typePattern turns an event type filter into a LIKE pattern. A trailing
+// ".*" matches every type below that prefix.
+func typePattern(filter string) string {
+	prefix, wildcard := strings.CutSuffix(filter, ".*")
+	escaped := likeEscape(prefix)
+	if wildcard {
+		return escaped + ".%"
+	}
+	return escaped
+}
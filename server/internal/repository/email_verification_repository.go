@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// ErrVerificationTokenNotFound is returned if no token has the given hash
+var ErrVerificationTokenNotFound = errors.New("verification token not found")
+
+// EmailVerificationRepository handles email verification token database operations
+type EmailVerificationRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewEmailVerificationRepository creates a new email verification repository
+func NewEmailVerificationRepository(db *pgxpool.Pool) *EmailVerificationRepository {
+	return &EmailVerificationRepository{db: db}
+}
+
+// Replace stores a new token for the user, invalidating earlier ones
+func (r *EmailVerificationRepository) Replace(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		WITH removed AS (
+			DELETE FROM email_verification_tokens WHERE user_id = $1
+		)
+		INSERT INTO email_verification_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`, userID, tokenHash, expiresAt)
+	return err
+}
+
+// GetByTokenHash retrieves a token by its hash
+func (r *EmailVerificationRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.EmailVerificationToken, error) {
+	token := &models.EmailVerificationToken{}
+	err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, token_hash, expires_at, created_at
+		FROM email_verification_tokens WHERE token_hash = $1
+	`, tokenHash).Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVerificationTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// LastIssuedAt returns when the user's current token was created, or nil
+// if they have none
+func (r *EmailVerificationRepository) LastIssuedAt(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	var issued *time.Time
+	err := r.db.QueryRow(ctx, `
+		SELECT MAX(created_at) FROM email_verification_tokens WHERE user_id = $1
+	`, userID).Scan(&issued)
+	return issued, err
+}
+
+// DeleteForUser removes all tokens of the user
+func (r *EmailVerificationRepository) DeleteForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM email_verification_tokens WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+var ErrInviteNotFound = errors.New("invite not found, used or expired")
+
+// InviteRepository handles registration invite database operations
+type InviteRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewInviteRepository creates a new invite repository
+func NewInviteRepository(db *pgxpool.Pool) *InviteRepository {
+	return &InviteRepository{db: db}
+}
+
+// Create stores a new invite
+func (r *InviteRepository) Create(ctx context.Context, invite *models.Invite) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO invites (id, code_prefix, code_hash, note, created_by, expires_at, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7)
+	`, invite.ID, invite.CodePrefix, invite.CodeHash, invite.Note, invite.CreatedBy, invite.ExpiresAt, invite.CreatedAt)
+	return err
+}
+
+// List returns the most recent invites, newest first
+func (r *InviteRepository) List(ctx context.Context, limit int) ([]models.Invite, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, code_prefix, code_hash, COALESCE(note, ''), created_by, expires_at, used_at, used_by, created_at
+		FROM invites ORDER BY created_at DESC LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []models.Invite
+	for rows.Next() {
+		var i models.Invite
+		err := rows.Scan(&i.ID, &i.CodePrefix, &i.CodeHash, &i.Note, &i.CreatedBy, &i.ExpiresAt, &i.UsedAt, &i.UsedBy, &i.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, i)
+	}
+	return invites, rows.Err()
+}
+
+// Consume atomically marks an unused, unexpired invite as used and
+// returns its ID
+func (r *InviteRepository) Consume(ctx context.Context, codeHash string) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.db.QueryRow(ctx, `
+		UPDATE invites SET used_at = NOW()
+		WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id
+	`, codeHash).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrInviteNotFound
+	}
+	return id, err
+}
+
+// SetUsedBy records the user who registered with a consumed invite
+func (r *InviteRepository) SetUsedBy(ctx context.Context, id, userID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `UPDATE invites SET used_by = $2 WHERE id = $1`, id, userID)
+	return err
+}
+
+// Release makes a consumed invite usable again after the registration
+// it was consumed for failed
+func (r *InviteRepository) Release(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `UPDATE invites SET used_at = NULL WHERE id = $1 AND used_by IS NULL`, id)
+	return err
+}
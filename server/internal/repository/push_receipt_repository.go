@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// ErrPushReceiptNotFound is returned for an unknown or expired
+// idempotency key
+var ErrPushReceiptNotFound = errors.New("push receipt not found")
+
+// PushReceiptRepository stores the outcome of pushes by idempotency key
+type PushReceiptRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewPushReceiptRepository creates a new push receipt repository
+func NewPushReceiptRepository(db *pgxpool.Pool) *PushReceiptRepository {
+	return &PushReceiptRepository{db: db}
+}
+
+// Get returns the receipt of the user's key if it was stored after since
+func (r *PushReceiptRepository) Get(ctx context.Context, userID uuid.UUID, key string, since time.Time) (*models.VaultPushReceipt, error) {
+	receipt := &models.VaultPushReceipt{}
+	var pushedAt time.Time
+	err := r.db.QueryRow(ctx, `
+		SELECT vault_name, status, revision, pushed_at FROM vault_push_receipts
+		WHERE user_id = $1 AND idempotency_key = $2 AND created_at > $3
+	`, userID, key, since).Scan(&receipt.Vault, &receipt.Response.Status, &receipt.Response.Revision, &pushedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPushReceiptNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	receipt.Response.Timestamp = pushedAt.Unix()
+	return receipt, nil
+}
+
+// Save stores the receipt of the user's key. An expired receipt of the
+// same key is replaced; a live one is kept.
+func (r *PushReceiptRepository) Save(ctx context.Context, userID uuid.UUID, key string, receipt *models.VaultPushReceipt, expiredBefore time.Time) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO vault_push_receipts (user_id, idempotency_key, vault_name, status, revision, pushed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, idempotency_key) DO UPDATE
+		SET vault_name = EXCLUDED.vault_name, status = EXCLUDED.status, revision = EXCLUDED.revision,
+			pushed_at = EXCLUDED.pushed_at, created_at = NOW()
+		WHERE vault_push_receipts.created_at <= $7
+	`, userID, key, receipt.Vault, receipt.Response.Status, receipt.Response.Revision,
+		time.Unix(receipt.Response.Timestamp, 0), expiredBefore)
+	return err
+}
+
+// DeleteOlderThan removes receipts stored before cutoff
+func (r *PushReceiptRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM vault_push_receipts WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
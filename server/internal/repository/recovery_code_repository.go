@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/sprobst76/vibedterm-server/internal/models"
@@ -46,32 +45,47 @@ func (r *RecoveryCodeRepository) Create(ctx context.Context, userID uuid.UUID, c
 	return code, nil
 }
 
-// GetByUserAndHash retrieves a recovery code by user ID and hash
-func (r *RecoveryCodeRepository) GetByUserAndHash(ctx context.Context, userID uuid.UUID, codeHash string) (*models.RecoveryCode, error) {
-	code := &models.RecoveryCode{}
-	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, code_hash, used, used_at, created_at
-		FROM recovery_codes WHERE user_id = $1 AND code_hash = $2
-	`, userID, codeHash).Scan(
-		&code.ID, &code.UserID, &code.CodeHash, &code.Used, &code.UsedAt, &code.CreatedAt,
-	)
-
-	if errors.Is(err, pgx.ErrNoRows) {
-		return nil, ErrRecoveryCodeNotFound
-	}
+// ReplaceAllForUser atomically replaces all recovery codes of a user with
+// new ones. Concurrent replacements are serialized on the user row, so
+// only one set survives.
+func (r *RecoveryCodeRepository) ReplaceAllForUser(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer tx.Rollback(ctx)
 
-	return code, nil
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO recovery_codes (id, user_id, code_hash, used, created_at)
+			VALUES ($1, $2, $3, false, $4)
+		`, uuid.New(), userID, codeHash, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
 }
 
-// MarkUsed marks a recovery code as used
+// MarkUsed marks an unused recovery code as used.
+// It returns ErrRecoveryCodeNotFound if the code was already used.
 func (r *RecoveryCodeRepository) MarkUsed(ctx context.Context, id uuid.UUID) error {
-	_, err := r.db.Exec(ctx, `
-		UPDATE recovery_codes SET used = true, used_at = NOW() WHERE id = $1
+	result, err := r.db.Exec(ctx, `
+		UPDATE recovery_codes SET used = true, used_at = NOW() WHERE id = $1 AND used = false
 	`, id)
-	return err
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
 }
 
 // DeleteAllForUser deletes all recovery codes for a user
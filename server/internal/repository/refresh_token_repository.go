@@ -12,7 +12,10 @@ import (
 	"github.com/sprobst76/vibedterm-server/internal/models"
 )
 
-var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenRotated  = errors.New("refresh token already rotated or revoked")
+)
 
 // RefreshTokenRepository handles refresh token database operations
 type RefreshTokenRepository struct {
@@ -24,12 +27,14 @@ func NewRefreshTokenRepository(db *pgxpool.Pool) *RefreshTokenRepository {
 	return &RefreshTokenRepository{db: db}
 }
 
-// Create creates a new refresh token
+// Create creates a new refresh token starting a new rotation chain
 func (r *RefreshTokenRepository) Create(ctx context.Context, userID, deviceID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	id := uuid.New()
 	token := &models.RefreshToken{
-		ID:        uuid.New(),
+		ID:        id,
 		UserID:    userID,
 		DeviceID:  deviceID,
+		FamilyID:  id,
 		TokenHash: tokenHash,
 		ExpiresAt: expiresAt,
 		Revoked:   false,
@@ -37,9 +42,9 @@ func (r *RefreshTokenRepository) Create(ctx context.Context, userID, deviceID uu
 	}
 
 	_, err := r.db.Exec(ctx, `
-		INSERT INTO refresh_tokens (id, user_id, device_id, token_hash, expires_at, revoked, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, token.ID, token.UserID, token.DeviceID, token.TokenHash, token.ExpiresAt, token.Revoked, token.CreatedAt)
+		INSERT INTO refresh_tokens (id, user_id, device_id, family_id, token_hash, expires_at, revoked, created_at, session_created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $8)
+	`, token.ID, token.UserID, token.DeviceID, token.FamilyID, token.TokenHash, token.ExpiresAt, token.Revoked, token.CreatedAt)
 
 	if err != nil {
 		return nil, err
@@ -52,11 +57,11 @@ func (r *RefreshTokenRepository) Create(ctx context.Context, userID, deviceID uu
 func (r *RefreshTokenRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
 	token := &models.RefreshToken{}
 	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, device_id, token_hash, expires_at, revoked, created_at
+		SELECT id, user_id, device_id, COALESCE(family_id, id), token_hash, expires_at, revoked, replaced_by, created_at
 		FROM refresh_tokens WHERE token_hash = $1
 	`, tokenHash).Scan(
-		&token.ID, &token.UserID, &token.DeviceID, &token.TokenHash,
-		&token.ExpiresAt, &token.Revoked, &token.CreatedAt,
+		&token.ID, &token.UserID, &token.DeviceID, &token.FamilyID, &token.TokenHash,
+		&token.ExpiresAt, &token.Revoked, &token.ReplacedBy, &token.CreatedAt,
 	)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -69,6 +74,94 @@ func (r *RefreshTokenRepository) GetByTokenHash(ctx context.Context, tokenHash s
 	return token, nil
 }
 
+// Rotate revokes the active token with oldHash and issues its successor
+// for the same user, device and chain. The rotation counts as a use of
+// the session. If the old token was already revoked, e.g. by a
+// concurrent rotation, it returns ErrRefreshTokenRotated.
+func (r *RefreshTokenRepository) Rotate(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	token := &models.RefreshToken{
+		ID:        uuid.New(),
+		TokenHash: newHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	var sessionCreatedAt time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE refresh_tokens SET revoked = true, replaced_by = $2, last_used_at = $3
+		WHERE token_hash = $1 AND revoked = false
+		RETURNING user_id, device_id, COALESCE(family_id, id), COALESCE(session_created_at, created_at)
+	`, oldHash, token.ID, token.CreatedAt).Scan(&token.UserID, &token.DeviceID, &token.FamilyID, &sessionCreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRefreshTokenRotated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO refresh_tokens (id, user_id, device_id, family_id, token_hash, expires_at, revoked, created_at, session_created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, false, $7, $8, $7)
+	`, token.ID, token.UserID, token.DeviceID, token.FamilyID, token.TokenHash, token.ExpiresAt, token.CreatedAt, sessionCreatedAt); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// GetActiveByUserID lists the unexpired, unrevoked sessions of a user,
+// most recently used first
+func (r *RefreshTokenRepository) GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT COALESCE(t.family_id, t.id), t.device_id, d.device_name, d.device_type,
+		       COALESCE(t.session_created_at, t.created_at), t.expires_at,
+		       COALESCE(t.last_used_at, t.created_at)
+		FROM refresh_tokens t JOIN devices d ON d.id = t.device_id
+		WHERE t.user_id = $1 AND t.revoked = false AND t.expires_at > NOW()
+		ORDER BY 7 DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.DeviceID, &s.DeviceName, &s.DeviceType, &s.CreatedAt, &s.ExpiresAt, &s.LastUsedAt); err != nil {
+			return nil, err
+		}
+		s.AuthSessionID = s.ID
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeByID revokes the active token of the user's session with the
+// given ID. It returns ErrRefreshTokenNotFound if the user has no such
+// active session.
+func (r *RefreshTokenRepository) RevokeByID(ctx context.Context, userID, sessionID uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked = true
+		WHERE user_id = $1 AND COALESCE(family_id, id) = $2 AND revoked = false
+	`, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRefreshTokenNotFound
+	}
+	return nil
+}
+
 // Revoke revokes a refresh token by hash
 func (r *RefreshTokenRepository) Revoke(ctx context.Context, tokenHash string) error {
 	_, err := r.db.Exec(ctx, `
@@ -93,10 +186,12 @@ func (r *RefreshTokenRepository) RevokeAllForDevice(ctx context.Context, deviceI
 	return err
 }
 
-// CleanupExpired removes expired tokens
+// CleanupExpired removes expired and revoked tokens. Rotated tokens are
+// kept until they expire so their reuse can still be detected.
 func (r *RefreshTokenRepository) CleanupExpired(ctx context.Context) (int64, error) {
 	result, err := r.db.Exec(ctx, `
-		DELETE FROM refresh_tokens WHERE expires_at < NOW() OR revoked = true
+		DELETE FROM refresh_tokens
+		WHERE expires_at < NOW() OR (revoked = true AND replaced_by IS NULL)
 	`)
 	if err != nil {
 		return 0, err
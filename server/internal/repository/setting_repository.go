@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrSettingNotFound = errors.New("setting not found")
+
+// SettingRepository handles runtime settings stored in the database
+type SettingRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewSettingRepository creates a new setting repository
+func NewSettingRepository(db *pgxpool.Pool) *SettingRepository {
+	return &SettingRepository{db: db}
+}
+
+// Get returns the value of a setting
+func (r *SettingRepository) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := r.db.QueryRow(ctx, `SELECT value FROM settings WHERE key = $1`, key).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrSettingNotFound
+	}
+	return value, err
+}
+
+// Set creates or updates a setting
+func (r *SettingRepository) Set(ctx context.Context, key, value string) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO settings (key, value, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+	`, key, value)
+	return err
+}
+
+// All returns all settings
+func (r *SettingRepository) All(ctx context.Context) (map[string]string, error) {
+	rows, err := r.db.Query(ctx, `SELECT key, value FROM settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
@@ -2,9 +2,11 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/sprobst76/vibedterm-server/internal/models"
@@ -20,59 +22,180 @@ func NewSyncLogRepository(db *pgxpool.Pool) *SyncLogRepository {
 	return &SyncLogRepository{db: db}
 }
 
-// Create creates a new sync log entry
-func (r *SyncLogRepository) Create(ctx context.Context, userID uuid.UUID, deviceID *uuid.UUID, action string, revisionBefore, revisionAfter *int) error {
-	log := &models.SyncLog{
-		ID:             uuid.New(),
-		UserID:         userID,
-		DeviceID:       deviceID,
-		Action:         action,
-		RevisionBefore: revisionBefore,
-		RevisionAfter:  revisionAfter,
-		CreatedAt:      time.Now(),
+// syncLogColumns are the columns scanned by scanSyncLog
+const syncLogColumns = `id, user_id, device_id, vault_name, action, revision_before, revision_after,
+	version_before, version_after, created_at,
+	COALESCE(seq, 0), COALESCE(prev_hash, ''), COALESCE(entry_hash, '')`
+
+func scanSyncLog(row pgx.Row) (models.SyncLog, error) {
+	var log models.SyncLog
+	err := row.Scan(
+		&log.ID, &log.UserID, &log.DeviceID, &log.Vault, &log.Action, &log.RevisionBefore, &log.RevisionAfter,
+		&log.VersionBefore, &log.VersionAfter, &log.CreatedAt,
+		&log.Seq, &log.PrevHash, &log.EntryHash,
+	)
+	return log, err
+}
+
+func collectSyncLogs(rows pgx.Rows) ([]models.SyncLog, error) {
+	defer rows.Close()
+
+	var logs []models.SyncLog
+	for rows.Next() {
+		log, err := scanSyncLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+// Create creates a new sync log entry about the named vault and appends
+// it to the user's hash chain. Entries of the user that are not chained
+// yet are chained first, oldest first, in the same transaction.
+func (r *SyncLogRepository) Create(ctx context.Context, userID uuid.UUID, deviceID *uuid.UUID, vault, action string, revisionBefore, revisionAfter *int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback(ctx)
 
-	_, err := r.db.Exec(ctx, `
-		INSERT INTO sync_logs (id, user_id, device_id, action, revision_before, revision_after, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, log.ID, log.UserID, log.DeviceID, log.Action, log.RevisionBefore, log.RevisionAfter, log.CreatedAt)
+	if err := appendSyncLog(ctx, tx, models.NewSyncLog(userID, deviceID, vault, action, revisionBefore, revisionAfter)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
 
+// appendSyncLog inserts log in tx as the new head of its user's hash
+// chain. Chain writes of a user are serialized until tx ends.
+func appendSyncLog(ctx context.Context, tx pgx.Tx, log *models.SyncLog) error {
+	userID := log.UserID
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1::text))`, userID); err != nil {
+		return err
+	}
+
+	var head *models.SyncLog
+	last, err := scanSyncLog(tx.QueryRow(ctx, `
+		SELECT `+syncLogColumns+`
+		FROM sync_logs WHERE user_id = $1 AND seq IS NOT NULL ORDER BY seq DESC LIMIT 1
+	`, userID))
+	switch {
+	case err == nil:
+		head = &last
+	case !errors.Is(err, pgx.ErrNoRows):
+		return err
+	}
+
+	if head, err = chainUnchained(ctx, tx, userID, head); err != nil {
+		return err
+	}
+
+	log.DeviceID = deviceRef(log.DeviceID)
+	log.ChainAfter(head)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO sync_logs (id, user_id, device_id, vault_name, action, revision_before, revision_after,
+			version_before, version_after, created_at, seq, prev_hash, entry_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, log.ID, log.UserID, log.DeviceID, log.Vault, log.Action, log.RevisionBefore, log.RevisionAfter,
+		log.VersionBefore, log.VersionAfter, log.CreatedAt, log.Seq, log.PrevHash, log.EntryHash)
 	return err
 }
 
+// chainUnchained appends the user's unchained entries after head and
+// returns the new head
+func chainUnchained(ctx context.Context, tx pgx.Tx, userID uuid.UUID, head *models.SyncLog) (*models.SyncLog, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT `+syncLogColumns+`
+		FROM sync_logs WHERE user_id = $1 AND seq IS NULL ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := collectSyncLogs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pending {
+		log := &pending[i]
+		log.ChainAfter(head)
+		if _, err := tx.Exec(ctx, `
+			UPDATE sync_logs SET seq = $2, prev_hash = $3, entry_hash = $4 WHERE id = $1
+		`, log.ID, log.Seq, log.PrevHash, log.EntryHash); err != nil {
+			return nil, err
+		}
+		head = log
+	}
+	return head, nil
+}
+
 // GetByUserID retrieves sync logs for a user
 func (r *SyncLogRepository) GetByUserID(ctx context.Context, userID uuid.UUID, limit int) ([]models.SyncLog, error) {
 	rows, err := r.db.Query(ctx, `
-		SELECT id, user_id, device_id, action, revision_before, revision_after, created_at
-		FROM sync_logs WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2
+		SELECT `+syncLogColumns+`
+		FROM sync_logs WHERE user_id = $1 ORDER BY created_at DESC, seq DESC LIMIT $2
 	`, userID, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return collectSyncLogs(rows)
+}
 
-	var logs []models.SyncLog
-	for rows.Next() {
-		var log models.SyncLog
-		err := rows.Scan(&log.ID, &log.UserID, &log.DeviceID, &log.Action, &log.RevisionBefore, &log.RevisionAfter, &log.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		logs = append(logs, log)
+// GetChain retrieves the chained sync logs of a user, oldest first
+func (r *SyncLogRepository) GetChain(ctx context.Context, userID uuid.UUID) ([]models.SyncLog, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+syncLogColumns+`
+		FROM sync_logs WHERE user_id = $1 AND seq IS NOT NULL ORDER BY seq
+	`, userID)
+	if err != nil {
+		return nil, err
 	}
-
-	return logs, nil
+	return collectSyncLogs(rows)
 }
 
-// DeleteOld deletes logs older than the specified duration
-func (r *SyncLogRepository) DeleteOld(ctx context.Context, olderThan time.Duration) (int64, error) {
-	result, err := r.db.Exec(ctx, `
-		DELETE FROM sync_logs WHERE created_at < $1
-	`, time.Now().Add(-olderThan))
+// GetRetention retrieves where retention cut the sync log chain of a
+// user, or nil if it never did
+func (r *SyncLogRepository) GetRetention(ctx context.Context, userID uuid.UUID) (*models.SyncChainRetention, error) {
+	var retention models.SyncChainRetention
+	err := r.db.QueryRow(ctx, `
+		SELECT seq, prev_hash, pruned_at FROM sync_log_retention WHERE user_id = $1
+	`, userID).Scan(&retention.Seq, &retention.PrevHash, &retention.PrunedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return result.RowsAffected(), nil
+	return &retention, nil
+}
+
+// DeleteOld deletes logs older than the specified duration. Chained logs
+// are deleted below a cutoff seq per user, so each chain keeps a contiguous
+// suffix and at least its head, and the cut is recorded for verification.
+func (r *SyncLogRepository) DeleteOld(ctx context.Context, olderThan time.Duration) (int64, error) {
+	var deleted int64
+	err := r.db.QueryRow(ctx, `
+		WITH cutoffs AS (
+			SELECT user_id, COALESCE(MIN(seq) FILTER (WHERE created_at >= $1), MAX(seq)) AS seq
+			FROM sync_logs WHERE seq IS NOT NULL GROUP BY user_id
+		), deleted AS (
+			DELETE FROM sync_logs l
+			WHERE (l.seq IS NULL AND l.created_at < $1)
+			   OR l.seq < (SELECT c.seq FROM cutoffs c WHERE c.user_id = l.user_id)
+			RETURNING l.user_id, l.seq, l.entry_hash
+		), retained AS (
+			INSERT INTO sync_log_retention (user_id, seq, prev_hash, pruned_at)
+			SELECT DISTINCT ON (user_id) user_id, seq + 1, entry_hash, NOW()
+			FROM deleted WHERE seq IS NOT NULL
+			ORDER BY user_id, seq DESC
+			ON CONFLICT (user_id) DO UPDATE
+			SET seq = EXCLUDED.seq, prev_hash = EXCLUDED.prev_hash, pruned_at = EXCLUDED.pruned_at
+			WHERE sync_log_retention.seq < EXCLUDED.seq
+		)
+		SELECT COUNT(*) FROM deleted
+	`, time.Now().Add(-olderThan)).Scan(&deleted)
+	return deleted, err
 }
 
 // Count returns total sync log count
@@ -81,3 +204,31 @@ func (r *SyncLogRepository) Count(ctx context.Context) (int, error) {
 	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM sync_logs`).Scan(&count)
 	return count, err
 }
+
+// EachForExport calls fn for every sync log entry of the users matching
+// the filters of q, oldest first, reading rows as fn consumes them.
+// Limit and Offset are ignored.
+func (r *SyncLogRepository) EachForExport(ctx context.Context, q models.UserListQuery, fn func(*models.SyncLogExportRow) error) error {
+	rows, err := r.db.Query(ctx, `
+		SELECT users.email, COALESCE((SELECT d.device_name FROM devices d WHERE d.id = l.device_id), ''),
+		       l.vault_name, l.action, l.revision_before, l.revision_after, l.created_at
+		FROM sync_logs l JOIN users ON users.id = l.user_id`+userListFilter+`
+		ORDER BY l.created_at, l.id
+	`, q.Status, likeEscape(q.Search))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var row models.SyncLogExportRow
+	for rows.Next() {
+		err := rows.Scan(&row.Email, &row.DeviceName, &row.Vault, &row.Action, &row.RevisionBefore, &row.RevisionAfter, &row.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TempTokenRepository tracks extensions, recovery attempts and uses of TOTP temp tokens
+type TempTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewTempTokenRepository creates a new temp token repository
+func NewTempTokenRepository(db *pgxpool.Pool) *TempTokenRepository {
+	return &TempTokenRepository{db: db}
+}
+
+// MarkExtended records that the temp token with the given jti may no longer
+// be extended. It returns false if the token was already marked.
+func (r *TempTokenRepository) MarkExtended(ctx context.Context, jti uuid.UUID, expiresAt time.Time) (bool, error) {
+	result, err := r.db.Exec(ctx, `
+		INSERT INTO temp_token_extensions (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// MarkUsed records that the temp token with the given jti completed a
+// login. It returns false if the token was already used.
+func (r *TempTokenRepository) MarkUsed(ctx context.Context, jti uuid.UUID, expiresAt time.Time) (bool, error) {
+	result, err := r.db.Exec(ctx, `
+		INSERT INTO temp_token_uses (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`, jti, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// Used reports whether the temp token with the given jti completed a login
+func (r *TempTokenRepository) Used(ctx context.Context, jti uuid.UUID) (bool, error) {
+	var used bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM temp_token_uses WHERE jti = $1)
+	`, jti).Scan(&used)
+	return used, err
+}
+
+// RecordRecoveryAttempt counts a recovery code attempt made with the temp
+// token and returns the number of attempts including this one
+func (r *TempTokenRepository) RecordRecoveryAttempt(ctx context.Context, jti uuid.UUID, expiresAt time.Time) (int, error) {
+	var attempts int
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO temp_token_recovery_attempts (jti, attempts, expires_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (jti) DO UPDATE SET attempts = temp_token_recovery_attempts.attempts + 1
+		RETURNING attempts
+	`, jti, expiresAt).Scan(&attempts)
+	return attempts, err
+}
+
+// RecoveryAttempts returns the number of recovery code attempts made with the temp token
+func (r *TempTokenRepository) RecoveryAttempts(ctx context.Context, jti uuid.UUID) (int, error) {
+	var attempts int
+	err := r.db.QueryRow(ctx, `
+		SELECT COALESCE((SELECT attempts FROM temp_token_recovery_attempts WHERE jti = $1), 0)
+	`, jti).Scan(&attempts)
+	return attempts, err
+}
+
+// DeleteExpired removes records for temp tokens that have expired
+func (r *TempTokenRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.db.Exec(ctx, `
+		DELETE FROM temp_token_extensions WHERE expires_at < NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+	deleted := result.RowsAffected()
+
+	result, err = r.db.Exec(ctx, `
+		DELETE FROM temp_token_recovery_attempts WHERE expires_at < NOW()
+	`)
+	if err != nil {
+		return deleted, err
+	}
+	deleted += result.RowsAffected()
+
+	result, err = r.db.Exec(ctx, `
+		DELETE FROM temp_token_uses WHERE expires_at < NOW()
+	`)
+	if err != nil {
+		return deleted, err
+	}
+	return deleted + result.RowsAffected(), nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+var ErrTrustedDeviceNotFound = errors.New("trusted device not found")
+
+// TrustedDeviceRepository handles trusted device database operations
+type TrustedDeviceRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewTrustedDeviceRepository creates a new trusted device repository
+func NewTrustedDeviceRepository(db *pgxpool.Pool) *TrustedDeviceRepository {
+	return &TrustedDeviceRepository{db: db}
+}
+
+// Create stores a trust entry
+func (r *TrustedDeviceRepository) Create(ctx context.Context, trusted *models.TrustedDevice) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO trusted_devices (id, user_id, device_id, name, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, trusted.ID, trusted.UserID, trusted.DeviceID, trusted.Name, trusted.TokenHash, trusted.ExpiresAt, trusted.CreatedAt)
+	return err
+}
+
+// Use returns the unexpired trust entry with the given token hash and
+// records that it was used
+func (r *TrustedDeviceRepository) Use(ctx context.Context, tokenHash string) (*models.TrustedDevice, error) {
+	trusted := &models.TrustedDevice{}
+	err := r.db.QueryRow(ctx, `
+		UPDATE trusted_devices SET last_used_at = NOW()
+		WHERE token_hash = $1 AND expires_at > NOW()
+		RETURNING id, user_id, device_id, name, token_hash, expires_at, last_used_at, created_at
+	`, tokenHash).Scan(
+		&trusted.ID, &trusted.UserID, &trusted.DeviceID, &trusted.Name, &trusted.TokenHash,
+		&trusted.ExpiresAt, &trusted.LastUsedAt, &trusted.CreatedAt,
+	)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTrustedDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return trusted, nil
+}
+
+// GetActiveByUser lists the unexpired trust entries of a user, newest first
+func (r *TrustedDeviceRepository) GetActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.TrustedDevice, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, device_id, name, token_hash, expires_at, last_used_at, created_at
+		FROM trusted_devices WHERE user_id = $1 AND expires_at > NOW()
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trusted []models.TrustedDevice
+	for rows.Next() {
+		var t models.TrustedDevice
+		if err := rows.Scan(
+			&t.ID, &t.UserID, &t.DeviceID, &t.Name, &t.TokenHash,
+			&t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		trusted = append(trusted, t)
+	}
+	return trusted, rows.Err()
+}
+
+// Delete removes one trust entry of a user
+func (r *TrustedDeviceRepository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM trusted_devices WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTrustedDeviceNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser removes every trust entry of a user
+func (r *TrustedDeviceRepository) DeleteAllForUser(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM trusted_devices WHERE user_id = $1`, userID)
+	return err
+}
+
+// DeleteExpired removes expired trust entries
+func (r *TrustedDeviceRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM trusted_devices WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
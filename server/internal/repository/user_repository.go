@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,6 +16,7 @@ import (
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrLastAdmin         = errors.New("cannot demote the last admin")
 )
 
 // UserRepository handles user database operations
@@ -47,8 +49,7 @@ func (r *UserRepository) Create(ctx context.Context, email, passwordHash string)
 	`, user.ID, user.Email, user.PasswordHash, user.IsApproved, user.IsAdmin, user.IsBlocked, user.TOTPEnabled, user.CreatedAt, user.UpdatedAt)
 
 	if err != nil {
-		// Check for unique constraint violation
-		if err.Error() == "ERROR: duplicate key value violates unique constraint \"users_email_key\" (SQLSTATE 23505)" {
+		if isUniqueViolation(err, "users_email_key") {
 			return nil, ErrUserAlreadyExists
 		}
 		return nil, err
@@ -61,11 +62,15 @@ func (r *UserRepository) Create(ctx context.Context, email, passwordHash string)
 func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	user := &models.User{}
 	err := r.db.QueryRow(ctx, `
-		SELECT id, email, password_hash, is_approved, is_admin, is_blocked,
+		SELECT id, email, password_hash, email_verified, is_approved, is_admin, is_blocked,
+		       approved_at, approved_by, blocked_at, COALESCE(blocked_reason, ''),
+		       COALESCE(display_name, ''), COALESCE(registration_message, ''),
 		       totp_secret, totp_enabled, totp_verified_at, created_at, updated_at, last_login_at
 		FROM users WHERE id = $1
 	`, id).Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.IsApproved, &user.IsAdmin, &user.IsBlocked,
+		&user.ID, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.IsApproved, &user.IsAdmin, &user.IsBlocked,
+		&user.ApprovedAt, &user.ApprovedBy, &user.BlockedAt, &user.BlockedReason,
+		&user.DisplayName, &user.RegistrationMessage,
 		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPVerified, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
 	)
 
@@ -83,11 +88,15 @@ func (r *UserRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Use
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := &models.User{}
 	err := r.db.QueryRow(ctx, `
-		SELECT id, email, password_hash, is_approved, is_admin, is_blocked,
+		SELECT id, email, password_hash, email_verified, is_approved, is_admin, is_blocked,
+		       approved_at, approved_by, blocked_at, COALESCE(blocked_reason, ''),
+		       COALESCE(display_name, ''), COALESCE(registration_message, ''),
 		       totp_secret, totp_enabled, totp_verified_at, created_at, updated_at, last_login_at
 		FROM users WHERE email = $1
 	`, email).Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.IsApproved, &user.IsAdmin, &user.IsBlocked,
+		&user.ID, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.IsApproved, &user.IsAdmin, &user.IsBlocked,
+		&user.ApprovedAt, &user.ApprovedBy, &user.BlockedAt, &user.BlockedReason,
+		&user.DisplayName, &user.RegistrationMessage,
 		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPVerified, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
 	)
 
@@ -101,6 +110,15 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.
 	return user, nil
 }
 
+// SetRegistrationProfile stores the profile a user gave at registration
+func (r *UserRepository) SetRegistrationProfile(ctx context.Context, id uuid.UUID, profile models.RegistrationProfile) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE users SET display_name = NULLIF($2, ''), registration_message = NULLIF($3, ''), updated_at = NOW()
+		WHERE id = $1
+	`, id, profile.DisplayName, profile.Message)
+	return err
+}
+
 // UpdateLastLogin updates the last login timestamp
 func (r *UserRepository) UpdateLastLogin(ctx context.Context, id uuid.UUID) error {
 	_, err := r.db.Exec(ctx, `
@@ -141,34 +159,300 @@ func (r *UserRepository) UpdatePassword(ctx context.Context, id uuid.UUID, passw
 	return err
 }
 
-// SetApproved sets the approval status
-func (r *UserRepository) SetApproved(ctx context.Context, id uuid.UUID, approved bool) error {
+// RehashPassword replaces the password hash with an upgraded hash of the
+// same password. It does nothing if the hash changed since oldHash was
+// read, so a concurrent password change is never reverted.
+func (r *UserRepository) RehashPassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) error {
 	_, err := r.db.Exec(ctx, `
-		UPDATE users SET is_approved = $2, updated_at = NOW() WHERE id = $1
-	`, id, approved)
+		UPDATE users SET password_hash = $3 WHERE id = $1 AND password_hash = $2
+	`, id, oldHash, newHash)
 	return err
 }
 
-// SetBlocked sets the blocked status
-func (r *UserRepository) SetBlocked(ctx context.Context, id uuid.UUID, blocked bool) error {
+// MarkEmailVerified records that the user proved ownership of their email
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID) error {
 	_, err := r.db.Exec(ctx, `
-		UPDATE users SET is_blocked = $2, updated_at = NOW() WHERE id = $1
-	`, id, blocked)
+		UPDATE users SET email_verified = true, updated_at = NOW() WHERE id = $1
+	`, id)
+	return err
+}
+
+// SetApproved sets the approval status. Approving records when and by whom
+// (nil for the system); unapproving clears both.
+func (r *UserRepository) SetApproved(ctx context.Context, id uuid.UUID, approved bool, by *uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE users SET is_approved = $2,
+		       approved_at = CASE WHEN $2 THEN NOW() END,
+		       approved_by = CASE WHEN $2 THEN $3::uuid END,
+		       updated_at = NOW()
+		WHERE id = $1
+	`, id, approved, by)
+	return err
+}
+
+// SetBlocked sets the blocked status. Blocking records when and why;
+// unblocking clears both and restores a soft-deleted account, the audit
+// log keeps the history.
+func (r *UserRepository) SetBlocked(ctx context.Context, id uuid.UUID, blocked bool, reason string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE users SET is_blocked = $2,
+		       blocked_at = CASE WHEN $2 THEN NOW() END,
+		       blocked_reason = CASE WHEN $2 THEN NULLIF($3, '') END,
+		       deleted_at = CASE WHEN $2 THEN deleted_at END,
+		       updated_at = NOW()
+		WHERE id = $1
+	`, id, blocked, reason)
+	return err
+}
+
+// SoftDelete blocks a user and marks them deleted. Their data is kept
+// until an admin deletes the account or unblocks it.
+func (r *UserRepository) SoftDelete(ctx context.Context, id uuid.UUID, reason string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE users SET is_blocked = true, blocked_at = NOW(), blocked_reason = NULLIF($2, ''),
+		       deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, id, reason)
+	return err
+}
+
+// ListInactive lists approved, unblocked non-admin users whose last login
+// (or approval, if they never logged in) is before cutoff, least recently
+// active first
+func (r *UserRepository) ListInactive(ctx context.Context, cutoff time.Time) ([]models.InactiveUser, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT u.id, u.email, COALESCE(u.last_login_at, u.approved_at, u.created_at) AS last_active,
+		       u.inactivity_warned_at,
+		       EXISTS (SELECT 1 FROM encrypted_vaults v WHERE v.user_id = u.id AND v.deleted_at IS NULL)
+		FROM users u
+		WHERE u.is_approved = true AND u.is_blocked = false AND u.is_admin = false
+		  AND COALESCE(u.last_login_at, u.approved_at, u.created_at) < $1
+		ORDER BY last_active
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.InactiveUser
+	for rows.Next() {
+		var u models.InactiveUser
+		if err := rows.Scan(&u.ID, &u.Email, &u.LastActiveAt, &u.WarnedAt, &u.HasVault); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// ListBrokenTOTPSecrets lists users with TOTP enabled whose stored secret
+// is shorter than minSize or longer than maxSize bytes
+func (r *UserRepository) ListBrokenTOTPSecrets(ctx context.Context, minSize, maxSize int) ([]models.BrokenTOTPSecret, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, email, COALESCE(length(totp_secret), 0) AS secret_size
+		FROM users
+		WHERE totp_enabled = true AND deleted_at IS NULL
+		  AND COALESCE(length(totp_secret), 0) NOT BETWEEN $1 AND $2
+		ORDER BY email
+	`, minSize, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.BrokenTOTPSecret
+	for rows.Next() {
+		var u models.BrokenTOTPSecret
+		if err := rows.Scan(&u.ID, &u.Email, &u.SecretSize); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// MarkInactivityWarned records that a user was warned about inactivity
+func (r *UserRepository) MarkInactivityWarned(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `UPDATE users SET inactivity_warned_at = NOW() WHERE id = $1`, id)
 	return err
 }
 
+// DevicePruning reports whether the user opted in to having inactive
+// devices removed
+func (r *UserRepository) DevicePruning(ctx context.Context, id uuid.UUID) (bool, error) {
+	var on bool
+	err := r.db.QueryRow(ctx, `SELECT prune_inactive_devices FROM users WHERE id = $1`, id).Scan(&on)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+	return on, err
+}
+
+// SetDevicePruning sets whether inactive devices of the user are removed
+func (r *UserRepository) SetDevicePruning(ctx context.Context, id uuid.UUID, on bool) error {
+	_, err := r.db.Exec(ctx, `UPDATE users SET prune_inactive_devices = $2 WHERE id = $1`, id, on)
+	return err
+}
+
+// DeviceApproval reports whether new devices of the user need approval
+func (r *UserRepository) DeviceApproval(ctx context.Context, id uuid.UUID) (bool, error) {
+	var on bool
+	err := r.db.QueryRow(ctx, `SELECT require_device_approval FROM users WHERE id = $1`, id).Scan(&on)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+	return on, err
+}
+
+// SetDeviceApproval sets whether new devices of the user need approval
+func (r *UserRepository) SetDeviceApproval(ctx context.Context, id uuid.UUID, on bool) error {
+	_, err := r.db.Exec(ctx, `UPDATE users SET require_device_approval = $2 WHERE id = $1`, id, on)
+	return err
+}
+
+// SetAdmin sets the admin flag. It returns ErrLastAdmin instead of
+// demoting the only remaining admin; the admins are locked so two
+// concurrent demotions cannot both pass the check.
+func (r *UserRepository) SetAdmin(ctx context.Context, id uuid.UUID, admin bool) error {
+	tag, err := r.db.Exec(ctx, `
+		WITH admins AS (SELECT id FROM users WHERE is_admin = true FOR UPDATE)
+		UPDATE users SET is_admin = $2, updated_at = NOW()
+		WHERE id = $1 AND ($2 OR (SELECT COUNT(*) FROM admins WHERE admins.id <> $1) > 0)
+	`, id, admin)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := r.GetByID(ctx, id); err != nil {
+			return err
+		}
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// HasUsers reports whether any user exists
+func (r *UserRepository) HasUsers(ctx context.Context) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users)`).Scan(&exists)
+	return exists, err
+}
+
+// bulkUpdates are the statements of the bulk actions. Each rechecks that
+// the action applies, so users changed since the caller looked are left
+// alone, and returns the changed users. Approving takes the approving
+// admin as $2.
+var bulkUpdates = map[string]string{
+	models.BulkApprove: `
+		UPDATE users SET is_approved = true, approved_at = NOW(), approved_by = $2, updated_at = NOW()
+		WHERE id = ANY($1) AND is_approved = false AND is_blocked = false
+		RETURNING id, email`,
+	models.BulkBlock: `
+		UPDATE users SET is_blocked = true, blocked_at = NOW(), blocked_reason = NULL, updated_at = NOW()
+		WHERE id = ANY($1) AND is_blocked = false AND is_admin = false
+		RETURNING id, email`,
+	models.BulkUnblock: `
+		UPDATE users SET is_blocked = false, blocked_at = NULL, blocked_reason = NULL, deleted_at = NULL, updated_at = NOW()
+		WHERE id = ANY($1) AND is_blocked = true
+		RETURNING id, email`,
+	models.BulkDelete: `
+		DELETE FROM users WHERE id = ANY($1) AND is_admin = false
+		RETURNING id, email`,
+}
+
+// bulkAuditActions are the audit actions recorded per changed user
+var bulkAuditActions = map[string]string{
+	models.BulkApprove: models.AuditUserApproved,
+	models.BulkBlock:   models.AuditUserBlocked,
+	models.BulkUnblock: models.AuditUserUnblocked,
+	models.BulkDelete:  models.AuditUserDeleted,
+}
+
+// BulkUpdate applies a bulk action to users in one transaction and
+// records an audit event by actorID for each changed user. Blocking
+// revokes the users' refresh tokens. It returns the IDs of the changed
+// users; the others did not qualify any more.
+func (r *UserRepository) BulkUpdate(ctx context.Context, action string, ids []uuid.UUID, actorID uuid.UUID) ([]uuid.UUID, error) {
+	query, ok := bulkUpdates[action]
+	if !ok {
+		return nil, errors.New("unknown bulk action " + action)
+	}
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	args := []any{ids}
+	if action == models.BulkApprove {
+		args = append(args, actorID)
+	}
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var changed []uuid.UUID
+	emails := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changed = append(changed, id)
+		emails[id] = email
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if action == models.BulkBlock && len(changed) > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = true WHERE user_id = ANY($1)`, changed); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range changed {
+		event := &models.AuditEvent{
+			Action:   bulkAuditActions[action],
+			ActorID:  &actorID,
+			TargetID: &id,
+			Details:  map[string]string{"email": emails[id], "bulk": "true"},
+		}
+		if err := insertAuditEvent(ctx, tx, event); err != nil {
+			return nil, err
+		}
+	}
+	return changed, tx.Commit(ctx)
+}
+
 // Delete deletes a user
 func (r *UserRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	_, err := r.db.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
 	return err
 }
 
-// List lists all users (for admin)
-func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+// DeleteIfPending deletes a user only if it is still awaiting approval
+func (r *UserRepository) DeleteIfPending(ctx context.Context, id uuid.UUID) (bool, error) {
+	result, err := r.db.Exec(ctx, `
+		DELETE FROM users WHERE id = $1 AND is_approved = false AND is_blocked = false
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+// ListPending lists users awaiting approval, oldest first
+func (r *UserRepository) ListPending(ctx context.Context) ([]models.User, error) {
 	rows, err := r.db.Query(ctx, `
-		SELECT id, email, password_hash, is_approved, is_admin, is_blocked,
+		SELECT id, email, password_hash, email_verified, is_approved, is_admin, is_blocked,
+		       approved_at, approved_by, blocked_at, COALESCE(blocked_reason, ''),
+		       COALESCE(display_name, ''), COALESCE(registration_message, ''),
 		       totp_enabled, created_at, updated_at, last_login_at
-		FROM users ORDER BY created_at DESC
+		FROM users WHERE is_approved = false AND is_blocked = false
+		ORDER BY created_at ASC
 	`)
 	if err != nil {
 		return nil, err
@@ -179,7 +463,9 @@ func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
 	for rows.Next() {
 		var user models.User
 		err := rows.Scan(
-			&user.ID, &user.Email, &user.PasswordHash, &user.IsApproved, &user.IsAdmin, &user.IsBlocked,
+			&user.ID, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.IsApproved, &user.IsAdmin, &user.IsBlocked,
+			&user.ApprovedAt, &user.ApprovedBy, &user.BlockedAt, &user.BlockedReason,
+			&user.DisplayName, &user.RegistrationMessage,
 			&user.TOTPEnabled, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
 		)
 		if err != nil {
@@ -191,6 +477,139 @@ func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
 	return users, nil
 }
 
+// ListAdmins lists all admin users
+func (r *UserRepository) ListAdmins(ctx context.Context) ([]models.User, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, email FROM users WHERE is_admin = true AND is_blocked = false
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// OldestPendingCreatedAt returns when the oldest pending registration was
+// created, or nil if no registration is pending
+func (r *UserRepository) OldestPendingCreatedAt(ctx context.Context) (*time.Time, error) {
+	var oldest *time.Time
+	err := r.db.QueryRow(ctx, `
+		SELECT MIN(created_at) FROM users WHERE is_approved = false AND is_blocked = false
+	`).Scan(&oldest)
+	return oldest, err
+}
+
+// AverageApprovalSeconds returns the mean time from registration to
+// approval, or nil if no user has an approval timestamp
+func (r *UserRepository) AverageApprovalSeconds(ctx context.Context) (*int64, error) {
+	var avg *float64
+	err := r.db.QueryRow(ctx, `
+		SELECT AVG(EXTRACT(EPOCH FROM approved_at - created_at)) FROM users WHERE approved_at IS NOT NULL
+	`).Scan(&avg)
+	if err != nil || avg == nil {
+		return nil, err
+	}
+	seconds := int64(*avg)
+	return &seconds, nil
+}
+
+// userListFilter is the WHERE clause of paged user listings. $1 is the
+// status filter and $2 the escaped email search pattern.
+const userListFilter = `
+	WHERE ($1 = ''
+	       OR ($1 = 'active' AND is_approved = true AND is_blocked = false)
+	       OR ($1 = 'pending' AND is_approved = false AND is_blocked = false)
+	       OR ($1 = 'blocked' AND is_blocked = true)
+	       OR ($1 = 'admin' AND is_admin = true))
+	  AND ($2 = '' OR email ILIKE '%' || $2 || '%')
+`
+
+// ListPage lists one page of users matching q, newest first
+func (r *UserRepository) ListPage(ctx context.Context, q models.UserListQuery) ([]models.User, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, email, password_hash, email_verified, is_approved, is_admin, is_blocked,
+		       approved_at, approved_by, blocked_at, COALESCE(blocked_reason, ''),
+		       COALESCE(display_name, ''), COALESCE(registration_message, ''),
+		       totp_enabled, created_at, updated_at, last_login_at
+		FROM users`+userListFilter+`
+		ORDER BY created_at DESC, id
+		LIMIT $3 OFFSET $4
+	`, q.Status, likeEscape(q.Search), q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID, &user.Email, &user.PasswordHash, &user.EmailVerified, &user.IsApproved, &user.IsAdmin, &user.IsBlocked,
+			&user.ApprovedAt, &user.ApprovedBy, &user.BlockedAt, &user.BlockedReason,
+			&user.DisplayName, &user.RegistrationMessage,
+			&user.TOTPEnabled, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// EachForExport calls fn for every user matching the filters of q, oldest
+// first, reading rows as fn consumes them. Limit and Offset are ignored.
+func (r *UserRepository) EachForExport(ctx context.Context, q models.UserListQuery, fn func(*models.UserExportRow) error) error {
+	rows, err := r.db.Query(ctx, `
+		SELECT email, email_verified, is_approved, is_admin, is_blocked, created_at, last_login_at,
+		       (SELECT COUNT(*) FROM devices d WHERE d.user_id = users.id),
+		       (SELECT v.revision FROM encrypted_vaults v
+		        WHERE v.user_id = users.id AND v.name = $3 AND v.deleted_at IS NULL)
+		FROM users`+userListFilter+`
+		ORDER BY created_at, id
+	`, q.Status, likeEscape(q.Search), models.DefaultVaultName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var row models.UserExportRow
+	for rows.Next() {
+		err := rows.Scan(&row.Email, &row.EmailVerified, &row.IsApproved, &row.IsAdmin, &row.IsBlocked,
+			&row.CreatedAt, &row.LastLoginAt, &row.Devices, &row.VaultRevision)
+		if err != nil {
+			return err
+		}
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CountMatching counts the users matching the filters of q
+func (r *UserRepository) CountMatching(ctx context.Context, q models.UserListQuery) (int, error) {
+	var n int
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM users`+userListFilter, q.Status, likeEscape(q.Search)).Scan(&n)
+	return n, err
+}
+
+// likeEscape escapes the LIKE wildcards in s
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 // Count returns user statistics
 func (r *UserRepository) Count(ctx context.Context) (total, approved, pending, blocked int, err error) {
 	err = r.db.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&total)
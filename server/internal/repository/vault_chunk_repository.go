@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrVaultChunkQuota means storing the chunks would take the user's
+// pending chunks past the allowed size
+var ErrVaultChunkQuota = errors.New("pending chunk quota exceeded")
+
+// VaultChunkRepository stores the chunks uploaded for delta pushes, keyed
+// by their SHA-256
+type VaultChunkRepository struct {
+	db         *pgxpool.Pool
+	maxPending int64 // bytes of pending chunks a user may have; 0 = unlimited
+}
+
+// NewVaultChunkRepository creates a new vault chunk repository that lets
+// every user keep maxPending bytes of chunks. 0 disables the limit.
+func NewVaultChunkRepository(db *pgxpool.Pool, maxPending int64) *VaultChunkRepository {
+	return &VaultChunkRepository{db: db, maxPending: max(maxPending, 0)}
+}
+
+// MaxPending returns how many bytes of chunks a user may keep, 0 if
+// unlimited
+func (r *VaultChunkRepository) MaxPending() int64 {
+	return r.maxPending
+}
+
+// Existing returns which of hashes the user has uploaded
+func (r *VaultChunkRepository) Existing(ctx context.Context, userID uuid.UUID, hashes []string) (map[string]bool, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT hash FROM vault_chunks WHERE user_id = $1 AND hash = ANY($2)
+	`, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		existing[hash] = true
+	}
+	return existing, rows.Err()
+}
+
+// Save stores chunks by hash. Chunks uploaded again are kept and their
+// expiry starts over. It returns ErrVaultChunkQuota and stores nothing if
+// the user's chunks would exceed maxPending bytes.
+func (r *VaultChunkRepository) Save(ctx context.Context, userID uuid.UUID, chunks map[string][]byte) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if r.maxPending > 0 {
+		// Serialize uploads of the user so they cannot pass the quota together
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('vault_chunks'), hashtext($1::text))`, userID); err != nil {
+			return err
+		}
+		hashes := make([]string, 0, len(chunks))
+		var size int64
+		for hash, data := range chunks {
+			hashes = append(hashes, hash)
+			size += int64(len(data))
+		}
+		var pending int64
+		if err := tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM(octet_length(data)), 0) FROM vault_chunks WHERE user_id = $1 AND hash <> ALL($2)
+		`, userID, hashes).Scan(&pending); err != nil {
+			return err
+		}
+		if pending+size > r.maxPending {
+			return ErrVaultChunkQuota
+		}
+	}
+
+	batch := &pgx.Batch{}
+	for hash, data := range chunks {
+		batch.Queue(`
+			INSERT INTO vault_chunks (user_id, hash, data) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, hash) DO UPDATE SET created_at = NOW()
+		`, userID, hash, data)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Get returns the user's chunks among hashes. Unknown hashes are left
+// out.
+func (r *VaultChunkRepository) Get(ctx context.Context, userID uuid.UUID, hashes []string) (map[string][]byte, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT hash, data FROM vault_chunks WHERE user_id = $1 AND hash = ANY($2)
+	`, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := make(map[string][]byte)
+	for rows.Next() {
+		var hash string
+		var data []byte
+		if err := rows.Scan(&hash, &data); err != nil {
+			return nil, err
+		}
+		chunks[hash] = data
+	}
+	return chunks, rows.Err()
+}
+
+// Delete removes the user's chunks among hashes
+func (r *VaultChunkRepository) Delete(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM vault_chunks WHERE user_id = $1 AND hash = ANY($2)`, userID, hashes)
+	return err
+}
+
+// DeleteOlderThan removes chunks uploaded before cutoff
+func (r *VaultChunkRepository) DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM vault_chunks WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
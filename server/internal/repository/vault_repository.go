@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"io"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,52 +13,263 @@ import (
 	"github.com/sprobst76/vibedterm-server/internal/models"
 )
 
-var ErrVaultNotFound = errors.New("vault not found")
+var (
+	ErrVaultNotFound         = errors.New("vault not found")
+	ErrVaultExists           = errors.New("vault already exists")
+	ErrVaultRevisionNotFound = errors.New("vault revision not in history")
+	// ErrVaultConflict means another write won the race
+	ErrVaultConflict = errors.New("vault changed concurrently")
+	// ErrVaultLimit means the user already has as many vaults as allowed
+	ErrVaultLimit = errors.New("vault limit reached")
+)
 
-// VaultRepository handles vault database operations
+// VaultRepository handles vault database operations. A user has one vault
+// per name.
 type VaultRepository struct {
-	db *pgxpool.Pool
+	db           *pgxpool.Pool
+	historyLimit int // replaced vaults kept per vault
+	maxPerUser   int // vaults a user may have; 0 = unlimited
+}
+
+// NewVaultRepository creates a new vault repository that keeps the last
+// historyLimit replaced vaults of each vault and lets every user have
+// maxPerUser vaults. 0 disables the history or the limit.
+func NewVaultRepository(db *pgxpool.Pool, historyLimit, maxPerUser int) *VaultRepository {
+	return &VaultRepository{db: db, historyLimit: max(historyLimit, 0), maxPerUser: max(maxPerUser, 0)}
+}
+
+// MaxPerUser returns how many vaults a user may have, 0 if unlimited
+func (r *VaultRepository) MaxPerUser() int {
+	return r.maxPerUser
+}
+
+// VaultWriteLog returns the sync log entries of a vault write, given the
+// written vault and whether it replaced one. They join the user's hash
+// chain in the transaction of the write.
+type VaultWriteLog func(vault *models.EncryptedVault, replaced bool) []*models.SyncLog
+
+// logWrite appends the entries writeLog returns for vault in tx. A nil
+// writeLog logs nothing.
+func logWrite(ctx context.Context, tx pgx.Tx, writeLog VaultWriteLog, vault *models.EncryptedVault, replaced bool) error {
+	if writeLog == nil {
+		return nil
+	}
+	for _, entry := range writeLog(vault, replaced) {
+		if err := appendSyncLog(ctx, tx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot copies the named vault into the history, locking it for the
+// rest of tx, and prunes its history to historyLimit. It returns
+// ErrVaultNotFound if the user has no such vault.
+func (r *VaultRepository) snapshot(ctx context.Context, tx pgx.Tx, userID uuid.UUID, name, reason string) error {
+	var revision int
+	err := tx.QueryRow(ctx, `
+		SELECT revision FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL FOR UPDATE
+	`, userID, name).Scan(&revision)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrVaultNotFound
+	}
+	if err != nil || r.historyLimit == 0 {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO vault_history (user_id, vault_name, vault_blob, revision, vault_version, device_id, reason)
+		SELECT user_id, name, vault_blob, revision, vault_version, updated_by_device, $3
+		FROM encrypted_vaults WHERE user_id = $1 AND name = $2
+	`, userID, name, reason); err != nil {
+		return err
+	}
+	return r.pruneHistory(ctx, tx, userID, name)
+}
+
+// pruneHistory keeps the historyLimit newest history entries of the named
+// vault
+func (r *VaultRepository) pruneHistory(ctx context.Context, tx pgx.Tx, userID uuid.UUID, name string) error {
+	_, err := tx.Exec(ctx, `
+		DELETE FROM vault_history WHERE user_id = $1 AND vault_name = $2 AND id NOT IN (
+			SELECT id FROM vault_history WHERE user_id = $1 AND vault_name = $2
+			ORDER BY created_at DESC, revision DESC LIMIT $3
+		)
+	`, userID, name, r.historyLimit)
+	return err
+}
+
+// clearDeleted moves a soft-deleted vault of that name into the history,
+// ending its grace period, so a new vault can take its place
+func (r *VaultRepository) clearDeleted(ctx context.Context, tx pgx.Tx, userID uuid.UUID, name string) error {
+	if r.historyLimit == 0 {
+		_, err := tx.Exec(ctx, `
+			DELETE FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NOT NULL
+		`, userID, name)
+		return err
+	}
+	tag, err := tx.Exec(ctx, `
+		WITH deleted AS (
+			DELETE FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NOT NULL
+			RETURNING user_id, name, vault_blob, revision, vault_version, updated_by_device
+		)
+		INSERT INTO vault_history (user_id, vault_name, vault_blob, revision, vault_version, device_id, reason)
+		SELECT user_id, name, vault_blob, revision, vault_version, updated_by_device, $3 FROM deleted
+	`, userID, name, models.VaultHistoryDeleted)
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	return r.pruneHistory(ctx, tx, userID, name)
+}
+
+// checkLimit returns ErrVaultLimit if the user may not add a vault named
+// name. It locks the user's row for the rest of tx, so concurrent creates
+// cannot both take the last slot.
+func (r *VaultRepository) checkLimit(ctx context.Context, tx pgx.Tx, userID uuid.UUID, name string) error {
+	if r.maxPerUser == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE`, userID); err != nil {
+		return err
+	}
+	allowed, err := r.createAllowed(ctx, tx, userID, name)
+	if err == nil && !allowed {
+		return ErrVaultLimit
+	}
+	return err
+}
+
+// createAllowed counts the user's other vaults against maxPerUser
+func (r *VaultRepository) createAllowed(ctx context.Context, q interface {
+	QueryRow(context.Context, string, ...any) pgx.Row
+}, userID uuid.UUID, name string) (bool, error) {
+	if r.maxPerUser == 0 {
+		return true, nil
+	}
+	var others int
+	err := q.QueryRow(ctx, `
+		SELECT COUNT(*) FROM encrypted_vaults WHERE user_id = $1 AND name <> $2 AND deleted_at IS NULL
+	`, userID, name).Scan(&others)
+	return others < r.maxPerUser, err
 }
 
-// NewVaultRepository creates a new vault repository
-func NewVaultRepository(db *pgxpool.Pool) *VaultRepository {
-	return &VaultRepository{db: db}
+// CreateAllowed reports whether the user may have a vault named name
+// without exceeding the vault limit
+func (r *VaultRepository) CreateAllowed(ctx context.Context, userID uuid.UUID, name string) (bool, error) {
+	return r.createAllowed(ctx, r.db, userID, name)
 }
 
-// Create creates a new vault
-func (r *VaultRepository) Create(ctx context.Context, userID uuid.UUID, vaultBlob []byte, deviceID *uuid.UUID) (*models.EncryptedVault, error) {
+// Overwrite replaces the named vault regardless of its revision, or
+// creates it, in one transaction. The revision keeps counting, so no
+// device appears to be ahead of the new vault. The replaced vault is kept
+// in the history; replaced reports whether there was one. Creating a
+// vault may fail with ErrVaultLimit.
+func (r *VaultRepository) Overwrite(ctx context.Context, userID uuid.UUID, name string, vaultBlob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog VaultWriteLog) (vault *models.EncryptedVault, replaced bool, err error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	switch err := r.snapshot(ctx, tx, userID, name, models.VaultHistoryForceOverwritten); {
+	case err == nil:
+		replaced = true
+	case !errors.Is(err, ErrVaultNotFound):
+		return nil, false, err
+	default:
+		if err := r.checkLimit(ctx, tx, userID, name); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := r.clearDeleted(ctx, tx, userID, name); err != nil {
+		return nil, false, err
+	}
+
+	vault = &models.EncryptedVault{}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO encrypted_vaults (id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, 1, $5, $6, NOW(), NOW())
+		ON CONFLICT (user_id, name) DO UPDATE
+		SET vault_blob = EXCLUDED.vault_blob, revision = encrypted_vaults.revision + 1,
+		    vault_version = EXCLUDED.vault_version, updated_by_device = EXCLUDED.updated_by_device, updated_at = NOW()
+		RETURNING id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+	`, uuid.New(), userID, name, vaultBlob, vaultVersion, deviceRef(deviceID)).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := logWrite(ctx, tx, writeLog, vault, replaced); err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return vault, replaced, nil
+}
+
+// Create creates a new vault named name. A soft-deleted vault of that
+// name is moved into the history. It returns ErrVaultLimit if the user
+// has as many vaults as allowed.
+func (r *VaultRepository) Create(ctx context.Context, userID uuid.UUID, name string, vaultBlob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog VaultWriteLog) (*models.EncryptedVault, error) {
 	vault := &models.EncryptedVault{
 		ID:              uuid.New(),
 		UserID:          userID,
+		Name:            name,
 		VaultBlob:       vaultBlob,
 		Revision:        1,
-		VaultVersion:    1,
-		UpdatedByDevice: deviceID,
+		VaultVersion:    vaultVersion,
+		UpdatedByDevice: deviceRef(deviceID),
+		Checksum:        models.BlobChecksum(vaultBlob),
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
 
-	_, err := r.db.Exec(ctx, `
-		INSERT INTO encrypted_vaults (id, user_id, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`, vault.ID, vault.UserID, vault.VaultBlob, vault.Revision, vault.VaultVersion, vault.UpdatedByDevice, vault.CreatedAt, vault.UpdatedAt)
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.checkLimit(ctx, tx, userID, name); err != nil {
+		return nil, err
+	}
+	if err := r.clearDeleted(ctx, tx, userID, name); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO encrypted_vaults (id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, vault.ID, vault.UserID, vault.Name, vault.VaultBlob, vault.Revision, vault.VaultVersion, vault.UpdatedByDevice, vault.CreatedAt, vault.UpdatedAt)
 
+	if isUniqueViolation(err, "") {
+		return nil, ErrVaultConflict
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := logWrite(ctx, tx, writeLog, vault, false); err != nil {
+		return nil, err
+	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
 	return vault, nil
 }
 
-// GetByUserID retrieves a vault by user ID
-func (r *VaultRepository) GetByUserID(ctx context.Context, userID uuid.UUID) (*models.EncryptedVault, error) {
+// GetByUserID retrieves the user's vault named name unless it is
+// soft-deleted
+func (r *VaultRepository) GetByUserID(ctx context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error) {
 	vault := &models.EncryptedVault{}
 	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at
-		FROM encrypted_vaults WHERE user_id = $1
-	`, userID).Scan(
-		&vault.ID, &vault.UserID, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
-		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt,
+		SELECT id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+		FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL
+	`, userID, name).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
 	)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -70,17 +282,17 @@ func (r *VaultRepository) GetByUserID(ctx context.Context, userID uuid.UUID) (*m
 	return vault, nil
 }
 
-// Update updates the vault blob and revision
-func (r *VaultRepository) Update(ctx context.Context, userID uuid.UUID, vaultBlob []byte, revision int, deviceID *uuid.UUID) (*models.EncryptedVault, error) {
-	vault := &models.EncryptedVault{}
+// GetMetadataByUserID retrieves the user's vault named name like
+// GetByUserID but leaves out the blob, reporting only its size
+func (r *VaultRepository) GetMetadataByUserID(ctx context.Context, userID uuid.UUID, name string) (*models.VaultMetadata, error) {
+	meta := &models.VaultMetadata{}
+	vault := &meta.Vault
 	err := r.db.QueryRow(ctx, `
-		UPDATE encrypted_vaults
-		SET vault_blob = $2, revision = $3, updated_by_device = $4, updated_at = NOW()
-		WHERE user_id = $1
-		RETURNING id, user_id, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at
-	`, userID, vaultBlob, revision, deviceID).Scan(
-		&vault.ID, &vault.UserID, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
-		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt,
+		SELECT id, user_id, name, octet_length(vault_blob), revision, vault_version, updated_by_device, created_at, updated_at, checksum
+		FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL
+	`, userID, name).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &meta.SizeBytes, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
 	)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -90,41 +302,525 @@ func (r *VaultRepository) Update(ctx context.Context, userID uuid.UUID, vaultBlo
 		return nil, err
 	}
 
-	return vault, nil
+	return meta, nil
 }
 
-// UpdateWithRevisionCheck updates only if revision matches (optimistic locking)
-func (r *VaultRepository) UpdateWithRevisionCheck(ctx context.Context, userID uuid.UUID, vaultBlob []byte, expectedRevision int, deviceID *uuid.UUID) (*models.EncryptedVault, error) {
+// vaultBlobChunk is how much of a blob OpenBlob reads per query
+const vaultBlobChunk = 1 << 20
+
+// OpenBlob streams the named vault in chunks. All chunks come from the
+// snapshot the metadata was read in, so a concurrent push cannot mix two
+// revisions. The stream holds a connection until it is closed.
+func (r *VaultRepository) OpenBlob(ctx context.Context, userID uuid.UUID, name string) (*models.VaultBlobStream, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &models.VaultBlobStream{}
+	vault := &stream.Vault
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, name, octet_length(vault_blob), revision, vault_version, updated_by_device, created_at, updated_at, checksum
+		FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL
+	`, userID, name).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &stream.SizeBytes, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
+	)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrVaultNotFound
+		}
+		return nil, err
+	}
+
+	stream.ReadCloser = &vaultBlobReader{ctx: ctx, tx: tx, id: vault.ID, size: stream.SizeBytes}
+	return stream, nil
+}
+
+// vaultBlobReader reads a vault blob chunk by chunk within tx
+type vaultBlobReader struct {
+	ctx    context.Context
+	tx     pgx.Tx
+	id     uuid.UUID // of the vault row
+	size   int64
+	offset int64 // bytes fetched so far
+	chunk  []byte
+}
+
+func (b *vaultBlobReader) Read(p []byte) (int, error) {
+	if len(b.chunk) == 0 {
+		if b.offset >= b.size {
+			return 0, io.EOF
+		}
+		// substring counts from 1
+		err := b.tx.QueryRow(b.ctx, `
+			SELECT substring(vault_blob FROM $2 FOR $3) FROM encrypted_vaults WHERE id = $1
+		`, b.id, b.offset+1, vaultBlobChunk).Scan(&b.chunk)
+		if err != nil {
+			return 0, err
+		}
+		if len(b.chunk) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		b.offset += int64(len(b.chunk))
+	}
+	n := copy(p, b.chunk)
+	b.chunk = b.chunk[n:]
+	return n, nil
+}
+
+// Close ends the snapshot and releases the connection
+func (b *vaultBlobReader) Close() error {
+	return b.tx.Rollback(b.ctx)
+}
+
+// UpdateWithRevisionCheck replaces the vault blob and vault version and
+// increments the revision, but only if the stored revision still is
+// expectedRevision (optimistic locking). Otherwise it returns
+// ErrVaultConflict. The replaced vault is kept in the history.
+func (r *VaultRepository) UpdateWithRevisionCheck(ctx context.Context, userID uuid.UUID, name string, vaultBlob []byte, expectedRevision, vaultVersion int, deviceID *uuid.UUID, writeLog VaultWriteLog) (*models.EncryptedVault, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var revision int
+	err = tx.QueryRow(ctx, `
+		SELECT revision FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL FOR UPDATE
+	`, userID, name).Scan(&revision)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && revision != expectedRevision) {
+		return nil, ErrVaultConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.snapshot(ctx, tx, userID, name, models.VaultHistoryPushed); err != nil {
+		return nil, err
+	}
+
 	vault := &models.EncryptedVault{}
+	err = tx.QueryRow(ctx, `
+		UPDATE encrypted_vaults
+		SET vault_blob = $3, revision = revision + 1, vault_version = $4, updated_by_device = $5, updated_at = NOW()
+		WHERE user_id = $1 AND name = $2
+		RETURNING id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+	`, userID, name, vaultBlob, vaultVersion, deviceRef(deviceID)).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := logWrite(ctx, tx, writeLog, vault, true); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return vault, nil
+}
+
+// History lists the replaced vaults kept for the named vault, newest
+// first
+func (r *VaultRepository) History(ctx context.Context, userID uuid.UUID, name string) ([]models.VaultRevision, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT revision, vault_version, octet_length(vault_blob), device_id, reason, created_at
+		FROM vault_history WHERE user_id = $1 AND vault_name = $2
+		ORDER BY created_at DESC, revision DESC
+	`, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.VaultRevision{}
+	for rows.Next() {
+		var rev models.VaultRevision
+		if err := rows.Scan(&rev.Revision, &rev.VaultVersion, &rev.SizeBytes, &rev.DeviceID, &rev.Reason, &rev.ReplacedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// HistoryRevision returns the latest history entry of revision. Revisions
+// start over when a vault is created anew, so older entries may share it.
+func (r *VaultRepository) HistoryRevision(ctx context.Context, userID uuid.UUID, name string, revision int) (*models.VaultRevision, error) {
+	rev := &models.VaultRevision{}
 	err := r.db.QueryRow(ctx, `
+		SELECT revision, vault_version, octet_length(vault_blob), device_id, reason, created_at
+		FROM vault_history WHERE user_id = $1 AND vault_name = $2 AND revision = $3
+		ORDER BY created_at DESC LIMIT 1
+	`, userID, name, revision).Scan(&rev.Revision, &rev.VaultVersion, &rev.SizeBytes, &rev.DeviceID, &rev.Reason, &rev.ReplacedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVaultRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// Restore makes the latest history entry of revision the new head
+// revision of the named vault. The replaced vault is kept in the history.
+func (r *VaultRepository) Restore(ctx context.Context, userID uuid.UUID, name string, revision int, deviceID *uuid.UUID, writeLog VaultWriteLog) (*models.EncryptedVault, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var blob []byte
+	var vaultVersion int
+	err = tx.QueryRow(ctx, `
+		SELECT vault_blob, vault_version FROM vault_history
+		WHERE user_id = $1 AND vault_name = $2 AND revision = $3
+		ORDER BY created_at DESC LIMIT 1
+	`, userID, name, revision).Scan(&blob, &vaultVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVaultRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.snapshot(ctx, tx, userID, name, models.VaultHistoryRestored); err != nil {
+		return nil, err
+	}
+
+	vault := &models.EncryptedVault{}
+	err = tx.QueryRow(ctx, `
 		UPDATE encrypted_vaults
-		SET vault_blob = $2, revision = revision + 1, updated_by_device = $4, updated_at = NOW()
-		WHERE user_id = $1 AND revision = $3
-		RETURNING id, user_id, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at
-	`, userID, vaultBlob, expectedRevision, deviceID).Scan(
-		&vault.ID, &vault.UserID, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
-		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt,
+		SET vault_blob = $3, revision = revision + 1, vault_version = $4, updated_by_device = $5, updated_at = NOW()
+		WHERE user_id = $1 AND name = $2
+		RETURNING id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+	`, userID, name, blob, vaultVersion, deviceRef(deviceID)).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
 	)
+	if err != nil {
+		return nil, err
+	}
+	if err := logWrite(ctx, tx, writeLog, vault, true); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return vault, nil
+}
 
+// Transfer moves or copies the source user's default vault, its history
+// and optionally its sync log to the target user in one transaction.
+// Other named vaults stay with the source. An existing target vault is
+// only replaced with opts.Overwrite and is then kept in the target's
+// history. replaced reports whether that happened.
+// The target's sync log gets a transfer_in entry and, with
+// opts.IncludeSyncLogs, copies of the source's entries about the vault,
+// appended to its chain in order. The copies lose their device reference,
+// since the devices stay with the source account. The source's entries
+// stay in place so its chain remains intact; a move appends transfer_out.
+// The target's devices count as never having synced the vault.
+func (r *VaultRepository) Transfer(ctx context.Context, sourceID, targetID uuid.UUID, opts models.VaultTransferOptions) (vault *models.EncryptedVault, replaced bool, err error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	source := &models.EncryptedVault{}
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+		FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL FOR UPDATE
+	`, sourceID, models.DefaultVaultName).Scan(
+		&source.ID, &source.UserID, &source.Name, &source.VaultBlob, &source.Revision, &source.VaultVersion,
+		&source.UpdatedByDevice, &source.CreatedAt, &source.UpdatedAt, &source.Checksum,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, ErrVaultNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Snapshot and remove an existing target vault. A soft-deleted one
+	// does not count.
+	if err := r.clearDeleted(ctx, tx, targetID, source.Name); err != nil {
+		return nil, false, err
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO vault_history (user_id, vault_name, vault_blob, revision, vault_version, device_id, reason)
+		SELECT user_id, name, vault_blob, revision, vault_version, updated_by_device, $3
+		FROM encrypted_vaults WHERE user_id = $1 AND name = $2
+	`, targetID, source.Name, models.VaultHistoryTransferOverwritten)
+	if err != nil {
+		return nil, false, err
+	}
+	if replaced = tag.RowsAffected() > 0; replaced {
+		if !opts.Overwrite {
+			return nil, false, ErrVaultExists
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM encrypted_vaults WHERE user_id = $1 AND name = $2`, targetID, source.Name); err != nil {
+			return nil, false, err
+		}
+	} else if err := r.checkLimit(ctx, tx, targetID, source.Name); err != nil {
+		return nil, false, err
+	}
+
+	vault = &models.EncryptedVault{}
+	if opts.Copy {
+		err = tx.QueryRow(ctx, `
+			INSERT INTO encrypted_vaults (id, user_id, name, vault_blob, revision, vault_version, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+			RETURNING id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+		`, uuid.New(), targetID, source.Name, source.VaultBlob, source.Revision, source.VaultVersion).Scan(
+			&vault.ID, &vault.UserID, &vault.Name, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
+			&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO vault_history (user_id, vault_name, vault_blob, revision, vault_version, reason, created_at)
+			SELECT $2, vault_name, vault_blob, revision, vault_version, reason, created_at
+			FROM vault_history WHERE user_id = $1 AND vault_name = $3
+		`, sourceID, targetID, source.Name); err != nil {
+			return nil, false, err
+		}
+	} else {
+		err = tx.QueryRow(ctx, `
+			UPDATE encrypted_vaults SET user_id = $2, updated_by_device = NULL, updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+		`, source.ID, targetID).Scan(
+			&vault.ID, &vault.UserID, &vault.Name, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
+			&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE vault_history SET user_id = $2, device_id = NULL WHERE user_id = $1 AND vault_name = $3
+		`, sourceID, targetID, source.Name); err != nil {
+			return nil, false, err
+		}
+		if err := appendSyncLog(ctx, tx, models.NewSyncLog(sourceID, nil, source.Name, "transfer_out", &source.Revision, nil)); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := appendSyncLog(ctx, tx, models.NewSyncLog(targetID, nil, source.Name, "transfer_in", nil, &vault.Revision)); err != nil {
+		return nil, false, err
+	}
+	if opts.IncludeSyncLogs {
+		if err := copySyncLogs(ctx, tx, sourceID, targetID, source.Name); err != nil {
+			return nil, false, err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE devices SET last_seen_revision = NULL WHERE user_id = $1
+	`, targetID); err != nil {
+		return nil, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return vault, replaced, nil
+}
+
+// copySyncLogs appends copies of the source user's entries about the named
+// vault to the target user's chain, in the source's chain order
+func copySyncLogs(ctx context.Context, tx pgx.Tx, sourceID, targetID uuid.UUID, name string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT `+syncLogColumns+`
+		FROM sync_logs WHERE user_id = $1 AND vault_name = $2 ORDER BY seq NULLS LAST, created_at, id
+	`, sourceID, name)
+	if err != nil {
+		return err
+	}
+	logs, err := collectSyncLogs(rows)
+	if err != nil {
+		return err
+	}
+	for _, log := range logs {
+		log.ID, log.UserID, log.DeviceID = uuid.New(), targetID, nil
+		if err := appendSyncLog(ctx, tx, &log); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRevision returns the revision of the named vault without loading it
+func (r *VaultRepository) GetRevision(ctx context.Context, userID uuid.UUID, name string) (int, error) {
+	var revision int
+	err := r.db.QueryRow(ctx, `
+		SELECT revision FROM encrypted_vaults WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL
+	`, userID, name).Scan(&revision)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrVaultNotFound
+	}
+	return revision, err
+}
+
+// SoftDelete marks the named vault as deleted. It returns the vault
+// without its blob, or ErrVaultNotFound.
+func (r *VaultRepository) SoftDelete(ctx context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error) {
+	vault := &models.EncryptedVault{}
+	err := r.db.QueryRow(ctx, `
+		UPDATE encrypted_vaults SET deleted_at = NOW()
+		WHERE user_id = $1 AND name = $2 AND deleted_at IS NULL
+		RETURNING id, user_id, name, revision, vault_version, updated_by_device, created_at, updated_at, checksum, deleted_at
+	`, userID, name).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum, &vault.DeletedAt,
+	)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return nil, ErrVaultNotFound
 	}
 	if err != nil {
 		return nil, err
 	}
+	return vault, nil
+}
 
+// Undelete brings back the named vault if it was soft-deleted after
+// deletedAfter. Otherwise it returns ErrVaultNotFound, or ErrVaultLimit
+// if the user has created too many vaults since.
+func (r *VaultRepository) Undelete(ctx context.Context, userID uuid.UUID, name string, deletedAfter time.Time) (*models.EncryptedVault, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	vault := &models.EncryptedVault{}
+	err = tx.QueryRow(ctx, `
+		UPDATE encrypted_vaults SET deleted_at = NULL
+		WHERE user_id = $1 AND name = $2 AND deleted_at > $3
+		RETURNING id, user_id, name, vault_blob, revision, vault_version, updated_by_device, created_at, updated_at, checksum
+	`, userID, name, deletedAfter).Scan(
+		&vault.ID, &vault.UserID, &vault.Name, &vault.VaultBlob, &vault.Revision, &vault.VaultVersion,
+		&vault.UpdatedByDevice, &vault.CreatedAt, &vault.UpdatedAt, &vault.Checksum,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVaultNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkLimit(ctx, tx, userID, name); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
 	return vault, nil
 }
 
-// Delete deletes a vault
-func (r *VaultRepository) Delete(ctx context.Context, userID uuid.UUID) error {
-	_, err := r.db.Exec(ctx, `DELETE FROM encrypted_vaults WHERE user_id = $1`, userID)
-	return err
+// List returns the user's vaults without their blobs, ordered by name
+func (r *VaultRepository) List(ctx context.Context, userID uuid.UUID) ([]models.VaultSummary, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT name, revision, vault_version, octet_length(vault_blob), checksum, updated_at
+		FROM encrypted_vaults WHERE user_id = $1 AND deleted_at IS NULL
+		ORDER BY name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vaults := []models.VaultSummary{}
+	for rows.Next() {
+		var v models.VaultSummary
+		if err := rows.Scan(&v.Name, &v.Revision, &v.VaultVersion, &v.SizeBytes, &v.SHA256, &v.UpdatedAt); err != nil {
+			return nil, err
+		}
+		vaults = append(vaults, v)
+	}
+	return vaults, rows.Err()
+}
+
+// PurgeDeleted removes vaults soft-deleted before cutoff for good
+func (r *VaultRepository) PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM encrypted_vaults WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
 }
 
 // Count returns vault statistics
 func (r *VaultRepository) Count(ctx context.Context) (int, error) {
 	var count int
-	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM encrypted_vaults`).Scan(&count)
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM encrypted_vaults WHERE deleted_at IS NULL`).Scan(&count)
 	return count, err
 }
+
+// CountDeleted returns the number of soft-deleted vaults awaiting purge
+func (r *VaultRepository) CountDeleted(ctx context.Context) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `SELECT COUNT(*) FROM encrypted_vaults WHERE deleted_at IS NOT NULL`).Scan(&count)
+	return count, err
+}
+
+// TotalSize returns the bytes stored in all current vaults
+func (r *VaultRepository) TotalSize(ctx context.Context) (int, error) {
+	var size int
+	err := r.db.QueryRow(ctx, `SELECT COALESCE(SUM(octet_length(vault_blob)), 0) FROM encrypted_vaults WHERE deleted_at IS NULL`).Scan(&size)
+	return size, err
+}
+
+// Largest returns the limit largest vaults with their owners, largest first
+func (r *VaultRepository) Largest(ctx context.Context, limit int) ([]models.VaultUsage, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT v.user_id, u.email, v.name, octet_length(v.vault_blob), v.revision, v.updated_at
+		FROM encrypted_vaults v JOIN users u ON u.id = v.user_id
+		WHERE v.deleted_at IS NULL
+		ORDER BY octet_length(v.vault_blob) DESC, v.user_id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []models.VaultUsage
+	for rows.Next() {
+		var u models.VaultUsage
+		if err := rows.Scan(&u.UserID, &u.Email, &u.Name, &u.SizeBytes, &u.Revision, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// CountByVersion returns the number of vaults per vault_version
+func (r *VaultRepository) CountByVersion(ctx context.Context) ([]models.VaultVersionCount, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT vault_version, COUNT(*) FROM encrypted_vaults WHERE deleted_at IS NULL
+		GROUP BY vault_version ORDER BY vault_version
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.VaultVersionCount
+	for rows.Next() {
+		var c models.VaultVersionCount
+		if err := rows.Scan(&c.Version, &c.Vaults); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
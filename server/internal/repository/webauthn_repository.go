@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+var (
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	ErrWebAuthnCredentialExists   = errors.New("webauthn credential already registered")
+	ErrWebAuthnChallengeNotFound  = errors.New("webauthn challenge not found or expired")
+)
+
+// WebAuthnRepository handles WebAuthn credential and challenge database operations
+type WebAuthnRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewWebAuthnRepository creates a new WebAuthn repository
+func NewWebAuthnRepository(db *pgxpool.Pool) *WebAuthnRepository {
+	return &WebAuthnRepository{db: db}
+}
+
+// CreateCredential stores a newly registered credential. It returns
+// ErrWebAuthnCredentialExists if the authenticator is already registered.
+func (r *WebAuthnRepository) CreateCredential(ctx context.Context, cred *models.WebAuthnCredential) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO webauthn_credentials (id, user_id, name, credential_id, public_key, attestation_type,
+			aaguid, sign_count, transports, backup_eligible, backup_state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, cred.ID, cred.UserID, cred.Name, cred.CredentialID, cred.PublicKey, cred.AttestationType,
+		cred.AAGUID, int64(cred.SignCount), cred.Transports, cred.BackupEligible, cred.BackupState, cred.CreatedAt)
+	if isUniqueViolation(err, "webauthn_credentials_credential_id_key") {
+		return ErrWebAuthnCredentialExists
+	}
+	return err
+}
+
+// GetCredentialsByUser returns a user's credentials, oldest first
+func (r *WebAuthnRepository) GetCredentialsByUser(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count,
+			transports, backup_eligible, backup_state, last_used_at, created_at
+		FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []models.WebAuthnCredential
+	for rows.Next() {
+		var c models.WebAuthnCredential
+		var signCount int64
+		err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.CredentialID, &c.PublicKey, &c.AttestationType, &c.AAGUID,
+			&signCount, &c.Transports, &c.BackupEligible, &c.BackupState, &c.LastUsedAt, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.SignCount = uint32(signCount)
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+// CountCredentials counts a user's credentials
+func (r *WebAuthnRepository) CountCredentials(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int
+	err := r.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// RecordCredentialUse stores the signature counter and backup state of a
+// successful login
+func (r *WebAuthnRepository) RecordCredentialUse(ctx context.Context, id uuid.UUID, signCount uint32, backupState bool) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = NOW() WHERE id = $1
+	`, id, int64(signCount), backupState)
+	return err
+}
+
+// DeleteCredential removes one of a user's credentials
+func (r *WebAuthnRepository) DeleteCredential(ctx context.Context, userID, id uuid.UUID) error {
+	result, err := r.db.Exec(ctx, `
+		DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+// CreateChallenge stores the state of a started ceremony
+func (r *WebAuthnRepository) CreateChallenge(ctx context.Context, ch *models.WebAuthnChallenge) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO webauthn_challenges (id, user_id, ceremony, session_data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, ch.ID, ch.UserID, ch.Ceremony, ch.SessionData, ch.ExpiresAt)
+	return err
+}
+
+// ConsumeChallenge deletes and returns an unexpired challenge of the
+// user's ceremony, so each challenge is answered at most once
+func (r *WebAuthnRepository) ConsumeChallenge(ctx context.Context, id, userID uuid.UUID, ceremony string) (*models.WebAuthnChallenge, error) {
+	ch := &models.WebAuthnChallenge{}
+	err := r.db.QueryRow(ctx, `
+		DELETE FROM webauthn_challenges
+		WHERE id = $1 AND user_id = $2 AND ceremony = $3 AND expires_at > NOW()
+		RETURNING id, user_id, ceremony, session_data, expires_at
+	`, id, userID, ceremony).Scan(&ch.ID, &ch.UserID, &ch.Ceremony, &ch.SessionData, &ch.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWebAuthnChallengeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// DeleteExpiredChallenges removes challenges of abandoned ceremonies
+func (r *WebAuthnRepository) DeleteExpiredChallenges(ctx context.Context) (int64, error) {
+	result, err := r.db.Exec(ctx, `DELETE FROM webauthn_challenges WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// userExportSource is the subset of UserRepository needed to export users
+type userExportSource interface {
+	EachForExport(ctx context.Context, q models.UserListQuery, fn func(*models.UserExportRow) error) error
+}
+
+// syncLogExportSource is the subset of SyncLogRepository needed to export
+// sync activity
+type syncLogExportSource interface {
+	EachForExport(ctx context.Context, q models.UserListQuery, fn func(*models.SyncLogExportRow) error) error
+}
+
+// Header rows of the CSV exports
+var (
+	userExportHeader    = []string{"email", "email_verified", "approved", "admin", "blocked", "created_at", "last_login_at", "devices", "vault_revision"}
+	syncLogExportHeader = []string{"user", "device", "vault", "action", "revision_before", "revision_after", "created_at"}
+)
+
+// AccountExport writes users and their sync activity as CSV for periodic
+// account exports. Rows are written as they are read, so an export never
+// holds all of them.
+type AccountExport struct {
+	users    userExportSource
+	syncLogs syncLogExportSource
+}
+
+// NewAccountExport creates the account export
+func NewAccountExport(users userExportSource, syncLogs syncLogExportSource) *AccountExport {
+	return &AccountExport{users: users, syncLogs: syncLogs}
+}
+
+// WriteUsers writes the users matching the filters of q as CSV
+func (e *AccountExport) WriteUsers(ctx context.Context, w io.Writer, q models.UserListQuery) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(userExportHeader); err != nil {
+		return err
+	}
+	err := e.users.EachForExport(ctx, q, func(u *models.UserExportRow) error {
+		return out.Write([]string{
+			csvText(u.Email),
+			strconv.FormatBool(u.EmailVerified),
+			strconv.FormatBool(u.IsApproved),
+			strconv.FormatBool(u.IsAdmin),
+			strconv.FormatBool(u.IsBlocked),
+			csvTime(&u.CreatedAt),
+			csvTime(u.LastLoginAt),
+			strconv.Itoa(u.Devices),
+			csvInt(u.VaultRevision),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteSyncLogs writes the sync log entries of the users matching the
+// filters of q as CSV
+func (e *AccountExport) WriteSyncLogs(ctx context.Context, w io.Writer, q models.UserListQuery) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(syncLogExportHeader); err != nil {
+		return err
+	}
+	err := e.syncLogs.EachForExport(ctx, q, func(l *models.SyncLogExportRow) error {
+		return out.Write([]string{
+			csvText(l.Email),
+			csvText(l.DeviceName),
+			csvText(l.Vault),
+			csvText(l.Action),
+			csvInt(l.RevisionBefore),
+			csvInt(l.RevisionAfter),
+			csvTime(&l.CreatedAt),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// csvText returns s with a leading ' if spreadsheets would otherwise read
+// it as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// csvTime formats t as RFC 3339 in UTC, or empty if nil
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvInt formats n, or empty if nil
+func csvInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+type fakeUserExport []models.UserExportRow
+
+func (f fakeUserExport) EachForExport(_ context.Context, _ models.UserListQuery, fn func(*models.UserExportRow) error) error {
+	for i := range f {
+		if err := fn(&f[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fakeSyncLogExport []models.SyncLogExportRow
+
+func (f fakeSyncLogExport) EachForExport(_ context.Context, _ models.UserListQuery, fn func(*models.SyncLogExportRow) error) error {
+	for i := range f {
+		if err := fn(&f[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestAccountExport_WriteUsers(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	revision := 7
+	users := fakeUserExport{
+		{Email: `"smith, j"@example.com`, IsApproved: true, CreatedAt: created, Devices: 2, VaultRevision: &revision},
+		{Email: "plain@example.com", IsBlocked: true, CreatedAt: created, LastLoginAt: &created},
+	}
+	var out strings.Builder
+	if err := NewAccountExport(users, nil).WriteUsers(context.Background(), &out, models.UserListQuery{}); err != nil {
+		t.Fatalf("WriteUsers failed: %v", err)
+	}
+
+	if !strings.Contains(out.String(), `"""smith, j""@example.com"`) {
+		t.Errorf("email with comma and quotes not escaped:\n%s", out.String())
+	}
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	want := [][]string{
+		userExportHeader,
+		{`"smith, j"@example.com`, "false", "true", "false", "false", "2026-03-01T11:00:00Z", "", "2", "7"},
+		{"plain@example.com", "false", "false", "false", "true", "2026-03-01T11:00:00Z", "2026-03-01T11:00:00Z", "0", ""},
+	}
+	if !slices.EqualFunc(records, want, slices.Equal) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+}
+
+func TestAccountExport_WriteSyncLogs(t *testing.T) {
+	before, after := 3, 4
+	logs := fakeSyncLogExport{
+		{Email: "a@example.com", DeviceName: "Laptop", Vault: "default", Action: "push", RevisionBefore: &before, RevisionAfter: &after, CreatedAt: time.Unix(0, 0)},
+		{Email: "a@example.com", Vault: "work", Action: "pull"},
+	}
+	var out strings.Builder
+	if err := NewAccountExport(nil, logs).WriteSyncLogs(context.Background(), &out, models.UserListQuery{}); err != nil {
+		t.Fatalf("WriteSyncLogs failed: %v", err)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if len(records) != 3 || !slices.Equal(records[0], syncLogExportHeader) {
+		t.Fatalf("records = %q", records)
+	}
+	if want := []string{"a@example.com", "Laptop", "default", "push", "3", "4", "1970-01-01T00:00:00Z"}; !slices.Equal(records[1], want) {
+		t.Errorf("row = %q, want %q", records[1], want)
+	}
+	if records[2][1] != "" || records[2][4] != "" {
+		t.Errorf("row = %q, want empty device and revisions", records[2])
+	}
+}
+
+// Cells a spreadsheet would evaluate are exported as text
+func TestAccountExport_FormulaCells(t *testing.T) {
+	users := fakeUserExport{{Email: "=HYPERLINK(\"http://evil\")@example.com"}}
+	logs := fakeSyncLogExport{{Email: "+a@example.com", DeviceName: "@SUM(A1)", Vault: "-work", Action: "\tpush"}, {Email: "\rb@example.com", DeviceName: "Laptop =1"}}
+
+	var out strings.Builder
+	if err := NewAccountExport(users, nil).WriteUsers(context.Background(), &out, models.UserListQuery{}); err != nil {
+		t.Fatalf("WriteUsers failed: %v", err)
+	}
+	records, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if got := records[1][0]; got != "'=HYPERLINK(\"http://evil\")@example.com" {
+		t.Errorf("email = %q, want it quoted as text", got)
+	}
+
+	out.Reset()
+	if err := NewAccountExport(nil, logs).WriteSyncLogs(context.Background(), &out, models.UserListQuery{}); err != nil {
+		t.Fatalf("WriteSyncLogs failed: %v", err)
+	}
+	records, err = csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if want := []string{"'+a@example.com", "'@SUM(A1)", "'-work", "'\tpush"}; !slices.Equal(records[1][:4], want) {
+		t.Errorf("row = %q, want %q", records[1][:4], want)
+	}
+	if want := []string{"'\rb@example.com", "Laptop =1"}; !slices.Equal(records[2][:2], want) {
+		t.Errorf("row = %q, want %q", records[2][:2], want)
+	}
+}
@@ -0,0 +1,574 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+var (
+	ErrUserAlreadyApproved  = errors.New("user already approved")
+	ErrUserNotApproved      = errors.New("user is not approved")
+	ErrUserAlreadyBlocked   = errors.New("user already blocked")
+	ErrUserNotBlocked       = errors.New("user is not blocked")
+	ErrUserBlocked          = errors.New("user is blocked")
+	ErrCannotBlockAdmin     = errors.New("cannot block admin users")
+	ErrCannotUnapproveAdmin = errors.New("cannot unapprove admin users")
+	ErrBlockReasonTooLong   = errors.New("block reason too long")
+	ErrUserAlreadyAdmin     = errors.New("user is already an admin")
+	ErrUserNotAdmin         = errors.New("user is not an admin")
+	ErrUnknownBulkAction    = errors.New("unknown bulk action")
+	ErrTooManyUsers         = errors.New("too many users")
+)
+
+// MaxBulkUsers caps the users of one bulk action
+const MaxBulkUsers = 200
+
+// recentActivityLimit is the number of sync log entries in a user detail
+const recentActivityLimit = 20
+
+// maxBlockReasonLength caps the free-text reason stored with a block,
+// in characters
+const maxBlockReasonLength = 500
+
+type adminUserStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	SetApproved(ctx context.Context, id uuid.UUID, approved bool, by *uuid.UUID) error
+	SetBlocked(ctx context.Context, id uuid.UUID, blocked bool, reason string) error
+	SetAdmin(ctx context.Context, id uuid.UUID, admin bool) error
+	BulkUpdate(ctx context.Context, action string, ids []uuid.UUID, actorID uuid.UUID) ([]uuid.UUID, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type adminDeviceStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error)
+	GetByUserID(ctx context.Context, userID uuid.UUID) ([]models.Device, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type adminVaultStore interface {
+	GetMetadataByUserID(ctx context.Context, userID uuid.UUID, name string) (*models.VaultMetadata, error)
+}
+
+type adminTokenStore interface {
+	RevokeAllForUser(ctx context.Context, userID uuid.UUID) error
+	RevokeAllForDevice(ctx context.Context, deviceID uuid.UUID) error
+	GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	RevokeByID(ctx context.Context, userID, sessionID uuid.UUID) error
+}
+
+type adminSyncLogStore interface {
+	GetByUserID(ctx context.Context, userID uuid.UUID, limit int) ([]models.SyncLog, error)
+}
+
+type adminAuditStore interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+}
+
+// UserAdmin implements user management shared by the admin API and admin web
+type UserAdmin struct {
+	users    adminUserStore
+	devices  adminDeviceStore
+	vaults   adminVaultStore
+	tokens   adminTokenStore
+	syncLogs adminSyncLogStore
+	audit    adminAuditStore
+}
+
+// NewUserAdmin creates a new user administration service
+func NewUserAdmin(
+	users adminUserStore,
+	devices adminDeviceStore,
+	vaults adminVaultStore,
+	tokens adminTokenStore,
+	syncLogs adminSyncLogStore,
+	audit adminAuditStore,
+) *UserAdmin {
+	return &UserAdmin{
+		users:    users,
+		devices:  devices,
+		vaults:   vaults,
+		tokens:   tokens,
+		syncLogs: syncLogs,
+		audit:    audit,
+	}
+}
+
+// Approve approves a user. A blocked user is only approved if unblock is
+// set, in which case the user is unblocked as well.
+func (s *UserAdmin) Approve(ctx context.Context, actorID, id uuid.UUID, unblock bool) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsBlocked && !unblock {
+		return nil, ErrUserBlocked
+	}
+	if user.IsApproved && !user.IsBlocked {
+		return nil, ErrUserAlreadyApproved
+	}
+
+	if user.IsBlocked {
+		if err := s.users.SetBlocked(ctx, id, false, ""); err != nil {
+			return nil, err
+		}
+		s.record(ctx, models.AuditUserUnblocked, actorID, user, nil)
+	}
+	if !user.IsApproved {
+		if err := s.users.SetApproved(ctx, id, true, &actorID); err != nil {
+			return nil, err
+		}
+		s.record(ctx, models.AuditUserApproved, actorID, user, nil)
+	}
+	return s.users.GetByID(ctx, id)
+}
+
+// Unapprove moves an approved non-admin user back to pending and revokes
+// all of their refresh tokens
+func (s *UserAdmin) Unapprove(ctx context.Context, actorID, id uuid.UUID) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsApproved {
+		return nil, ErrUserNotApproved
+	}
+	if user.IsAdmin {
+		return nil, ErrCannotUnapproveAdmin
+	}
+
+	if err := s.users.SetApproved(ctx, id, false, nil); err != nil {
+		return nil, err
+	}
+	_ = s.tokens.RevokeAllForUser(ctx, id)
+	s.record(ctx, models.AuditUserUnapproved, actorID, user, nil)
+	return s.users.GetByID(ctx, id)
+}
+
+// Reject deletes a user that has not been approved yet and returns the
+// user as it was before deletion
+func (s *UserAdmin) Reject(ctx context.Context, actorID, id uuid.UUID) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsApproved {
+		return nil, ErrUserAlreadyApproved
+	}
+
+	if err := s.users.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	s.record(ctx, models.AuditUserRejected, actorID, user, nil)
+	return user, nil
+}
+
+// Block blocks a non-admin user for an optional reason and revokes all of
+// their refresh tokens
+func (s *UserAdmin) Block(ctx context.Context, actorID, id uuid.UUID, reason string) (*models.User, error) {
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > maxBlockReasonLength {
+		return nil, ErrBlockReasonTooLong
+	}
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsAdmin {
+		return nil, ErrCannotBlockAdmin
+	}
+	if user.IsBlocked {
+		return nil, ErrUserAlreadyBlocked
+	}
+
+	if err := s.users.SetBlocked(ctx, id, true, reason); err != nil {
+		return nil, err
+	}
+	_ = s.tokens.RevokeAllForUser(ctx, id)
+	var details map[string]string
+	if reason != "" {
+		details = map[string]string{"reason": reason}
+	}
+	s.record(ctx, models.AuditUserBlocked, actorID, user, details)
+	return s.users.GetByID(ctx, id)
+}
+
+// Unblock unblocks a user
+func (s *UserAdmin) Unblock(ctx context.Context, actorID, id uuid.UUID) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsBlocked {
+		return nil, ErrUserNotBlocked
+	}
+
+	if err := s.users.SetBlocked(ctx, id, false, ""); err != nil {
+		return nil, err
+	}
+	s.record(ctx, models.AuditUserUnblocked, actorID, user, nil)
+	return s.users.GetByID(ctx, id)
+}
+
+// SetAdmin promotes a user to admin or demotes an admin. Only approved,
+// unblocked users can be promoted, and the last admin cannot be demoted
+// (repository.ErrLastAdmin). The user's refresh tokens are revoked so
+// their next token carries the new role.
+func (s *UserAdmin) SetAdmin(ctx context.Context, actorID, id uuid.UUID, admin bool) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case admin && user.IsAdmin:
+		return nil, ErrUserAlreadyAdmin
+	case !admin && !user.IsAdmin:
+		return nil, ErrUserNotAdmin
+	case admin && user.IsBlocked:
+		return nil, ErrUserBlocked
+	case admin && !user.IsApproved:
+		return nil, ErrUserNotApproved
+	}
+
+	if err := s.users.SetAdmin(ctx, id, admin); err != nil {
+		return nil, err
+	}
+	_ = s.tokens.RevokeAllForUser(ctx, id)
+	action := models.AuditUserDemoted
+	if admin {
+		action = models.AuditUserPromoted
+	}
+	s.record(ctx, action, actorID, user, nil)
+	return s.users.GetByID(ctx, id)
+}
+
+// Delete deletes a user and all their data and returns the user as it
+// was before deletion
+func (s *UserAdmin) Delete(ctx context.Context, actorID, id uuid.UUID) (*models.User, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	s.record(ctx, models.AuditUserDeleted, actorID, user, nil)
+	return user, nil
+}
+
+// Bulk applies one action to several users at once and returns the
+// outcome per user in request order. Users the action does not apply to
+// are skipped with the code the single action would fail with; admins
+// are never blocked or deleted. All changes and their audit events are
+// written in one transaction.
+func (s *UserAdmin) Bulk(ctx context.Context, actorID uuid.UUID, action string, ids []uuid.UUID) ([]models.BulkUserResult, error) {
+	if _, ok := bulkActions[action]; !ok {
+		return nil, ErrUnknownBulkAction
+	}
+	if len(ids) > MaxBulkUsers {
+		return nil, ErrTooManyUsers
+	}
+
+	results := make([]models.BulkUserResult, 0, len(ids))
+	var eligible []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result := models.BulkUserResult{UserID: id, Status: models.BulkStatusDone}
+		user, err := s.users.GetByID(ctx, id)
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			result.Status = models.BulkStatusNotFound
+		case err != nil:
+			return nil, err
+		default:
+			if result.Code = bulkActions[action](user); result.Code != "" {
+				result.Status = models.BulkStatusSkipped
+			} else {
+				eligible = append(eligible, id)
+			}
+		}
+		results = append(results, result)
+	}
+	if len(eligible) == 0 {
+		return results, nil
+	}
+
+	changed, err := s.users.BulkUpdate(ctx, action, eligible, actorID)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[uuid.UUID]bool, len(changed))
+	for _, id := range changed {
+		done[id] = true
+	}
+	for i, r := range results {
+		if r.Status == models.BulkStatusDone && !done[r.UserID] {
+			results[i].Status, results[i].Code = models.BulkStatusSkipped, "USER_CHANGED"
+		}
+	}
+	return results, nil
+}
+
+// bulkActions return why a bulk action does not apply to a user, or ""
+// if it does
+var bulkActions = map[string]func(user *models.User) string{
+	models.BulkApprove: func(u *models.User) string {
+		switch {
+		case u.IsBlocked:
+			return "USER_BLOCKED"
+		case u.IsApproved:
+			return "USER_ALREADY_APPROVED"
+		}
+		return ""
+	},
+	models.BulkBlock: func(u *models.User) string {
+		switch {
+		case u.IsAdmin:
+			return "CANNOT_BLOCK_ADMIN"
+		case u.IsBlocked:
+			return "USER_ALREADY_BLOCKED"
+		}
+		return ""
+	},
+	models.BulkUnblock: func(u *models.User) string {
+		if !u.IsBlocked {
+			return "USER_NOT_BLOCKED"
+		}
+		return ""
+	},
+	models.BulkDelete: func(u *models.User) string {
+		if u.IsAdmin {
+			return "CANNOT_DELETE_ADMIN"
+		}
+		return ""
+	},
+}
+
+// Devices returns a user with their devices, active token counts, last
+// activity and how far behind the default vault they are
+func (s *UserAdmin) Devices(ctx context.Context, id uuid.UUID) (*models.AdminUserDevices, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := s.devices.GetByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.tokens.GetActiveByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[uuid.UUID]int)
+	for _, session := range sessions {
+		active[session.DeviceID]++
+	}
+	vault, err := s.vaults.GetMetadataByUserID(ctx, id, models.DefaultVaultName)
+	switch {
+	case err == nil:
+		SetRevisionLag(devices, vault.Vault.Revision)
+	case !errors.Is(err, repository.ErrVaultNotFound):
+		return nil, err
+	}
+
+	result := &models.AdminUserDevices{User: models.NewAdminUser(user), Devices: make([]models.AdminDevice, len(devices))}
+	for i, d := range devices {
+		result.Devices[i] = models.AdminDevice{Device: d, ActiveTokens: active[d.ID], LastActivityAt: d.LastSyncAt}
+		if d.LastSeenAt != nil && (d.LastSyncAt == nil || d.LastSeenAt.After(*d.LastSyncAt)) {
+			result.Devices[i].LastActivityAt = d.LastSeenAt
+		}
+	}
+	return result, nil
+}
+
+// DeleteDevice revokes the refresh tokens of a user's device and deletes
+// it. It returns the device as it was before deletion, or
+// repository.ErrDeviceNotFound if the device is not the user's.
+func (s *UserAdmin) DeleteDevice(ctx context.Context, actorID, userID, deviceID uuid.UUID) (*models.Device, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	device, err := s.devices.GetByID(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device.UserID != userID {
+		return nil, repository.ErrDeviceNotFound
+	}
+
+	if err := s.tokens.RevokeAllForDevice(ctx, deviceID); err != nil {
+		return nil, err
+	}
+	if err := s.devices.Delete(ctx, deviceID); err != nil {
+		return nil, err
+	}
+	s.record(ctx, models.AuditDeviceDeleted, actorID, user, map[string]string{
+		"device_id":   deviceID.String(),
+		"device_name": device.DeviceName,
+	})
+	return device, nil
+}
+
+// Sessions returns a user with their active sessions, most recently used
+// first
+func (s *UserAdmin) Sessions(ctx context.Context, id uuid.UUID) (*models.AdminUserSessions, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.tokens.GetActiveByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+	return &models.AdminUserSessions{User: models.NewAdminUser(user), Sessions: sessions}, nil
+}
+
+// RevokeSession ends one session of a user. The device stays registered
+// and can sign in again. It returns the revoked session, or
+// repository.ErrRefreshTokenNotFound if the user has no such active
+// session.
+func (s *UserAdmin) RevokeSession(ctx context.Context, actorID, userID, sessionID uuid.UUID) (*models.Session, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.tokens.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(sessions, func(session models.Session) bool { return session.ID == sessionID })
+	if i < 0 {
+		return nil, repository.ErrRefreshTokenNotFound
+	}
+	session := sessions[i]
+
+	if err := s.tokens.RevokeByID(ctx, userID, sessionID); err != nil {
+		return nil, err
+	}
+	s.record(ctx, models.AuditSessionRevoked, actorID, user, map[string]string{
+		"session_id":  sessionID.String(),
+		"device_id":   session.DeviceID.String(),
+		"device_name": session.DeviceName,
+	})
+	return &session, nil
+}
+
+// record writes an audit event for an action on user with optional extra
+// details. Failures are logged but do not undo the action.
+func (s *UserAdmin) record(ctx context.Context, action string, actorID uuid.UUID, user *models.User, extra map[string]string) {
+	details := map[string]string{"email": user.Email}
+	for k, v := range extra {
+		details[k] = v
+	}
+	event := &models.AuditEvent{
+		Action:   action,
+		ActorID:  &actorID,
+		TargetID: &user.ID,
+		Details:  details,
+	}
+	if err := s.audit.Create(ctx, event); err != nil {
+		log.Error().Err(err).Str("action", action).Str("user_id", user.ID.String()).Msg("Failed to record audit event")
+	}
+}
+
+// Detail composes a user with their devices, active sessions, vault
+// metadata and recent sync activity
+func (s *UserAdmin) Detail(ctx context.Context, id uuid.UUID) (*models.AdminUserDetail, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := s.devices.GetByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if devices == nil {
+		devices = []models.Device{}
+	}
+
+	sessions, err := s.tokens.GetActiveByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+
+	vault, err := s.vaultActivity(ctx, id, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AdminUserDetail{
+		User:           models.NewAdminUser(user),
+		Devices:        devices,
+		Sessions:       sessions,
+		Vault:          vault.Vault,
+		RecentActivity: vault.RecentActivity,
+	}, nil
+}
+
+// Vault returns the metadata of a user's default vault, never its blob,
+// with their recent sync activity
+func (s *UserAdmin) Vault(ctx context.Context, id uuid.UUID) (*models.AdminUserVault, error) {
+	if _, err := s.users.GetByID(ctx, id); err != nil {
+		return nil, err
+	}
+	devices, err := s.devices.GetByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.vaultActivity(ctx, id, devices)
+}
+
+// vaultActivity reads the vault metadata and recent sync activity of a
+// user, naming the device of the last update from devices
+func (s *UserAdmin) vaultActivity(ctx context.Context, id uuid.UUID, devices []models.Device) (*models.AdminUserVault, error) {
+	result := &models.AdminUserVault{}
+	meta, err := s.vaults.GetMetadataByUserID(ctx, id, models.DefaultVaultName)
+	switch {
+	case err == nil:
+		vault := &meta.Vault
+		result.Vault = &models.AdminVaultInfo{
+			Revision:        vault.Revision,
+			VaultVersion:    vault.VaultVersion,
+			SizeBytes:       meta.SizeBytes,
+			UpdatedByDevice: vault.UpdatedByDevice,
+			UpdatedAt:       vault.UpdatedAt,
+		}
+		for _, d := range devices {
+			if vault.UpdatedByDevice != nil && d.ID == *vault.UpdatedByDevice {
+				result.Vault.DeviceName = d.DeviceName
+			}
+		}
+	case !errors.Is(err, repository.ErrVaultNotFound):
+		return nil, err
+	}
+
+	result.RecentActivity, err = s.syncLogs.GetByUserID(ctx, id, recentActivityLimit)
+	if err != nil {
+		return nil, err
+	}
+	if result.RecentActivity == nil {
+		result.RecentActivity = []models.SyncLog{}
+	}
+	return result, nil
+}
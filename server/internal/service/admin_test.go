@@ -0,0 +1,749 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+type fakeAdminStores struct {
+	users    map[uuid.UUID]*models.User
+	devices  map[uuid.UUID][]models.Device
+	vaults   map[uuid.UUID]*models.EncryptedVault
+	logs     map[uuid.UUID][]models.SyncLog
+	revoked  map[uuid.UUID]bool // users, devices and sessions
+	sessions map[uuid.UUID][]models.Session
+	audit    []models.AuditEvent
+	logLimit int
+}
+
+func newFakeAdminStores() *fakeAdminStores {
+	return &fakeAdminStores{
+		users:   make(map[uuid.UUID]*models.User),
+		devices: make(map[uuid.UUID][]models.Device),
+		vaults:  make(map[uuid.UUID]*models.EncryptedVault),
+		logs:    make(map[uuid.UUID][]models.SyncLog),
+		revoked: make(map[uuid.UUID]bool),
+	}
+}
+
+func (f *fakeAdminStores) service() *UserAdmin {
+	return NewUserAdmin(fakeUsers{f}, fakeDevices{f}, fakeVaults{f}, fakeTokens{f}, fakeSyncLogs{f}, fakeAudit{f})
+}
+
+type fakeUsers struct{ f *fakeAdminStores }
+
+func (s fakeUsers) GetByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	u, ok := s.f.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	// Return a copy so only the setters change stored state
+	cp := *u
+	return &cp, nil
+}
+
+// The setters mirror how UserRepository maintains the state timestamps
+func (s fakeUsers) SetApproved(_ context.Context, id uuid.UUID, approved bool, by *uuid.UUID) error {
+	u := s.f.users[id]
+	u.IsApproved, u.ApprovedAt, u.ApprovedBy = approved, nil, nil
+	if approved {
+		now := time.Now()
+		u.ApprovedAt, u.ApprovedBy = &now, by
+	}
+	return nil
+}
+
+func (s fakeUsers) SetBlocked(_ context.Context, id uuid.UUID, blocked bool, reason string) error {
+	u := s.f.users[id]
+	u.IsBlocked, u.BlockedAt, u.BlockedReason = blocked, nil, ""
+	if blocked {
+		now := time.Now()
+		u.BlockedAt, u.BlockedReason = &now, reason
+	}
+	return nil
+}
+
+func (s fakeUsers) SetAdmin(_ context.Context, id uuid.UUID, admin bool) error {
+	if !admin {
+		others := 0
+		for _, u := range s.f.users {
+			if u.IsAdmin && u.ID != id {
+				others++
+			}
+		}
+		if others == 0 {
+			return repository.ErrLastAdmin
+		}
+	}
+	s.f.users[id].IsAdmin = admin
+	return nil
+}
+
+// BulkUpdate applies the action without rechecking whether it applies,
+// unless the user was removed meanwhile
+func (s fakeUsers) BulkUpdate(ctx context.Context, action string, ids []uuid.UUID, actorID uuid.UUID) ([]uuid.UUID, error) {
+	var changed []uuid.UUID
+	for _, id := range ids {
+		if _, ok := s.f.users[id]; !ok {
+			continue
+		}
+		switch action {
+		case models.BulkApprove:
+			_ = s.SetApproved(ctx, id, true, &actorID)
+		case models.BulkBlock, models.BulkUnblock:
+			_ = s.SetBlocked(ctx, id, action == models.BulkBlock, "")
+		case models.BulkDelete:
+			_ = s.Delete(ctx, id)
+		}
+		s.f.audit = append(s.f.audit, models.AuditEvent{Action: "bulk." + action, ActorID: &actorID, TargetID: &id})
+		changed = append(changed, id)
+	}
+	return changed, nil
+}
+
+func (s fakeUsers) Delete(_ context.Context, id uuid.UUID) error {
+	delete(s.f.users, id)
+	return nil
+}
+
+type fakeDevices struct{ f *fakeAdminStores }
+
+func (s fakeDevices) GetByUserID(_ context.Context, userID uuid.UUID) ([]models.Device, error) {
+	return s.f.devices[userID], nil
+}
+
+func (s fakeDevices) GetByID(_ context.Context, id uuid.UUID) (*models.Device, error) {
+	for _, devices := range s.f.devices {
+		for _, d := range devices {
+			if d.ID == id {
+				return &d, nil
+			}
+		}
+	}
+	return nil, repository.ErrDeviceNotFound
+}
+
+func (s fakeDevices) Delete(_ context.Context, id uuid.UUID) error {
+	for userID, devices := range s.f.devices {
+		s.f.devices[userID] = slices.DeleteFunc(devices, func(d models.Device) bool { return d.ID == id })
+	}
+	return nil
+}
+
+type fakeVaults struct{ f *fakeAdminStores }
+
+func (s fakeVaults) GetMetadataByUserID(_ context.Context, userID uuid.UUID, _ string) (*models.VaultMetadata, error) {
+	v, ok := s.f.vaults[userID]
+	if !ok {
+		return nil, repository.ErrVaultNotFound
+	}
+	meta := &models.VaultMetadata{Vault: *v, SizeBytes: len(v.VaultBlob)}
+	meta.Vault.VaultBlob = nil
+	return meta, nil
+}
+
+type fakeTokens struct{ f *fakeAdminStores }
+
+func (s fakeTokens) RevokeAllForUser(_ context.Context, userID uuid.UUID) error {
+	s.f.revoked[userID] = true
+	return nil
+}
+
+func (s fakeTokens) RevokeAllForDevice(_ context.Context, deviceID uuid.UUID) error {
+	s.f.revoked[deviceID] = true
+	return nil
+}
+
+func (s fakeTokens) GetActiveByUserID(_ context.Context, userID uuid.UUID) ([]models.Session, error) {
+	return s.f.sessions[userID], nil
+}
+
+func (s fakeTokens) RevokeByID(_ context.Context, userID, sessionID uuid.UUID) error {
+	sessions := s.f.sessions[userID]
+	i := slices.IndexFunc(sessions, func(session models.Session) bool { return session.ID == sessionID })
+	if i < 0 {
+		return repository.ErrRefreshTokenNotFound
+	}
+	s.f.sessions[userID] = slices.Delete(sessions, i, i+1)
+	s.f.revoked[sessionID] = true
+	return nil
+}
+
+type fakeSyncLogs struct{ f *fakeAdminStores }
+
+func (s fakeSyncLogs) GetByUserID(_ context.Context, userID uuid.UUID, limit int) ([]models.SyncLog, error) {
+	s.f.logLimit = limit
+	return s.f.logs[userID], nil
+}
+
+type fakeAudit struct{ f *fakeAdminStores }
+
+func (s fakeAudit) Create(_ context.Context, event *models.AuditEvent) error {
+	s.f.audit = append(s.f.audit, *event)
+	return nil
+}
+
+func TestUserAdmin_RejectApprovedUser(t *testing.T) {
+	f := newFakeAdminStores()
+	id := uuid.New()
+	f.users[id] = &models.User{ID: id, IsApproved: true}
+
+	_, err := f.service().Reject(context.Background(), uuid.New(), id)
+	if !errors.Is(err, ErrUserAlreadyApproved) {
+		t.Fatalf("error = %v, want ErrUserAlreadyApproved", err)
+	}
+	if _, ok := f.users[id]; !ok {
+		t.Error("approved user was deleted")
+	}
+}
+
+func TestUserAdmin_RejectPendingUser(t *testing.T) {
+	f := newFakeAdminStores()
+	id := uuid.New()
+	f.users[id] = &models.User{ID: id}
+
+	if _, err := f.service().Reject(context.Background(), uuid.New(), id); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if _, ok := f.users[id]; ok {
+		t.Error("pending user was not deleted")
+	}
+}
+
+func TestUserAdmin_BlockAdminRefused(t *testing.T) {
+	f := newFakeAdminStores()
+	id := uuid.New()
+	f.users[id] = &models.User{ID: id, IsAdmin: true, IsApproved: true}
+
+	_, err := f.service().Block(context.Background(), uuid.New(), id, "")
+	if !errors.Is(err, ErrCannotBlockAdmin) {
+		t.Fatalf("error = %v, want ErrCannotBlockAdmin", err)
+	}
+	if f.users[id].IsBlocked || f.revoked[id] {
+		t.Error("admin was blocked or had tokens revoked")
+	}
+}
+
+func TestUserAdmin_BlockRevokesTokens(t *testing.T) {
+	f := newFakeAdminStores()
+	id := uuid.New()
+	f.users[id] = &models.User{ID: id, IsApproved: true}
+
+	if _, err := f.service().Block(context.Background(), uuid.New(), id, ""); err != nil {
+		t.Fatalf("Block failed: %v", err)
+	}
+	if !f.users[id].IsBlocked {
+		t.Error("user not blocked")
+	}
+	if !f.revoked[id] {
+		t.Error("tokens not revoked")
+	}
+}
+
+func TestUserAdmin_SetAdmin(t *testing.T) {
+	f := newFakeAdminStores()
+	actor, id := uuid.New(), uuid.New()
+	f.users[actor] = &models.User{ID: actor, IsApproved: true, IsAdmin: true}
+	f.users[id] = &models.User{ID: id, IsApproved: true}
+	ctx := context.Background()
+
+	user, err := f.service().SetAdmin(ctx, actor, id, true)
+	if err != nil {
+		t.Fatalf("promote failed: %v", err)
+	}
+	if !user.IsAdmin || !f.revoked[id] {
+		t.Errorf("admin = %v, revoked = %v, want promoted with tokens revoked", user.IsAdmin, f.revoked[id])
+	}
+	if _, err := f.service().SetAdmin(ctx, actor, id, true); !errors.Is(err, ErrUserAlreadyAdmin) {
+		t.Errorf("promote again: error = %v, want ErrUserAlreadyAdmin", err)
+	}
+
+	// The actor may step down while another admin remains, but that one
+	// is then the last
+	if _, err := f.service().SetAdmin(ctx, actor, actor, false); err != nil {
+		t.Fatalf("demote self failed: %v", err)
+	}
+	if _, err := f.service().SetAdmin(ctx, actor, id, false); !errors.Is(err, repository.ErrLastAdmin) {
+		t.Errorf("demote last admin: error = %v, want ErrLastAdmin", err)
+	}
+	if _, err := f.service().SetAdmin(ctx, id, actor, false); !errors.Is(err, ErrUserNotAdmin) {
+		t.Errorf("demote non-admin: error = %v, want ErrUserNotAdmin", err)
+	}
+
+	var actions []string
+	for _, e := range f.audit {
+		actions = append(actions, e.Action)
+	}
+	if want := []string{models.AuditUserPromoted, models.AuditUserDemoted}; !slices.Equal(actions, want) {
+		t.Errorf("audit actions = %v, want %v", actions, want)
+	}
+}
+
+func TestUserAdmin_SetAdminRequiresActiveUser(t *testing.T) {
+	f := newFakeAdminStores()
+	pending, blocked := uuid.New(), uuid.New()
+	f.users[pending] = &models.User{ID: pending}
+	f.users[blocked] = &models.User{ID: blocked, IsApproved: true, IsBlocked: true}
+
+	if _, err := f.service().SetAdmin(context.Background(), uuid.New(), pending, true); !errors.Is(err, ErrUserNotApproved) {
+		t.Errorf("pending: error = %v, want ErrUserNotApproved", err)
+	}
+	if _, err := f.service().SetAdmin(context.Background(), uuid.New(), blocked, true); !errors.Is(err, ErrUserBlocked) {
+		t.Errorf("blocked: error = %v, want ErrUserBlocked", err)
+	}
+}
+
+func TestUserAdmin_Bulk(t *testing.T) {
+	f := newFakeAdminStores()
+	pending, active, blocked, admin, gone := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	f.users[pending] = &models.User{ID: pending}
+	f.users[active] = &models.User{ID: active, IsApproved: true}
+	f.users[blocked] = &models.User{ID: blocked, IsApproved: true, IsBlocked: true}
+	f.users[admin] = &models.User{ID: admin, IsApproved: true, IsAdmin: true}
+	ctx := context.Background()
+
+	results, err := f.service().Bulk(ctx, uuid.New(), models.BulkApprove, []uuid.UUID{pending, active, blocked, gone, pending})
+	if err != nil {
+		t.Fatalf("Bulk failed: %v", err)
+	}
+	want := []models.BulkUserResult{
+		{UserID: pending, Status: models.BulkStatusDone},
+		{UserID: active, Status: models.BulkStatusSkipped, Code: "USER_ALREADY_APPROVED"},
+		{UserID: blocked, Status: models.BulkStatusSkipped, Code: "USER_BLOCKED"},
+		{UserID: gone, Status: models.BulkStatusNotFound},
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+	if !f.users[pending].IsApproved || len(f.audit) != 1 {
+		t.Errorf("approved = %v, %d audit events; want approved with one event", f.users[pending].IsApproved, len(f.audit))
+	}
+
+	results, err = f.service().Bulk(ctx, uuid.New(), models.BulkDelete, []uuid.UUID{admin, active})
+	if err != nil {
+		t.Fatalf("Bulk failed: %v", err)
+	}
+	if results[0].Code != "CANNOT_DELETE_ADMIN" || results[1].Status != models.BulkStatusDone {
+		t.Errorf("results = %+v, want admin skipped and user deleted", results)
+	}
+	if _, ok := f.users[admin]; !ok {
+		t.Error("admin was deleted")
+	}
+
+	if _, err := f.service().Bulk(ctx, uuid.New(), "promote", []uuid.UUID{pending}); !errors.Is(err, ErrUnknownBulkAction) {
+		t.Errorf("error = %v, want ErrUnknownBulkAction", err)
+	}
+	if _, err := f.service().Bulk(ctx, uuid.New(), models.BulkBlock, make([]uuid.UUID, MaxBulkUsers+1)); !errors.Is(err, ErrTooManyUsers) {
+		t.Errorf("error = %v, want ErrTooManyUsers", err)
+	}
+}
+
+// userState is a user's approval/blocked/admin status in the transition matrix
+type userState struct {
+	approved, blocked, admin bool
+}
+
+func TestUserAdmin_TransitionMatrix(t *testing.T) {
+	var (
+		pending         = userState{}
+		approved        = userState{approved: true}
+		blockedPending  = userState{blocked: true}
+		blockedApproved = userState{approved: true, blocked: true}
+		admin           = userState{approved: true, admin: true}
+	)
+	deleted := &userState{}
+
+	type action func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error)
+	actions := map[string]action{
+		"approve": func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error) {
+			return s.Approve(context.Background(), actor, id, false)
+		},
+		"approve+unblock": func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error) {
+			return s.Approve(context.Background(), actor, id, true)
+		},
+		"unapprove": func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error) {
+			return s.Unapprove(context.Background(), actor, id)
+		},
+		"block": func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error) {
+			return s.Block(context.Background(), actor, id, "")
+		},
+		"unblock": func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error) {
+			return s.Unblock(context.Background(), actor, id)
+		},
+		"reject": func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error) {
+			return s.Reject(context.Background(), actor, id)
+		},
+		"delete": func(s *UserAdmin, actor, id uuid.UUID) (*models.User, error) {
+			return s.Delete(context.Background(), actor, id)
+		},
+	}
+
+	tests := []struct {
+		from    userState
+		action  string
+		want    *userState // nil when err is expected; deleted when removed
+		wantErr error
+		audits  []string
+	}{
+		{pending, "approve", &approved, nil, []string{models.AuditUserApproved}},
+		{approved, "approve", nil, ErrUserAlreadyApproved, nil},
+		{blockedPending, "approve", nil, ErrUserBlocked, nil},
+		{blockedApproved, "approve", nil, ErrUserBlocked, nil},
+		{admin, "approve", nil, ErrUserAlreadyApproved, nil},
+
+		{pending, "approve+unblock", &approved, nil, []string{models.AuditUserApproved}},
+		{approved, "approve+unblock", nil, ErrUserAlreadyApproved, nil},
+		{blockedPending, "approve+unblock", &approved, nil, []string{models.AuditUserUnblocked, models.AuditUserApproved}},
+		{blockedApproved, "approve+unblock", &approved, nil, []string{models.AuditUserUnblocked}},
+
+		{pending, "unapprove", nil, ErrUserNotApproved, nil},
+		{approved, "unapprove", &pending, nil, []string{models.AuditUserUnapproved}},
+		{blockedPending, "unapprove", nil, ErrUserNotApproved, nil},
+		{blockedApproved, "unapprove", &blockedPending, nil, []string{models.AuditUserUnapproved}},
+		{admin, "unapprove", nil, ErrCannotUnapproveAdmin, nil},
+
+		{pending, "block", &blockedPending, nil, []string{models.AuditUserBlocked}},
+		{approved, "block", &blockedApproved, nil, []string{models.AuditUserBlocked}},
+		{blockedPending, "block", nil, ErrUserAlreadyBlocked, nil},
+		{blockedApproved, "block", nil, ErrUserAlreadyBlocked, nil},
+		{admin, "block", nil, ErrCannotBlockAdmin, nil},
+
+		{pending, "unblock", nil, ErrUserNotBlocked, nil},
+		{approved, "unblock", nil, ErrUserNotBlocked, nil},
+		{blockedPending, "unblock", &pending, nil, []string{models.AuditUserUnblocked}},
+		{blockedApproved, "unblock", &approved, nil, []string{models.AuditUserUnblocked}},
+		{admin, "unblock", nil, ErrUserNotBlocked, nil},
+
+		{pending, "reject", deleted, nil, []string{models.AuditUserRejected}},
+		{approved, "reject", nil, ErrUserAlreadyApproved, nil},
+		{blockedPending, "reject", deleted, nil, []string{models.AuditUserRejected}},
+		{blockedApproved, "reject", nil, ErrUserAlreadyApproved, nil},
+		{admin, "reject", nil, ErrUserAlreadyApproved, nil},
+
+		{pending, "delete", deleted, nil, []string{models.AuditUserDeleted}},
+		{approved, "delete", deleted, nil, []string{models.AuditUserDeleted}},
+		{blockedApproved, "delete", deleted, nil, []string{models.AuditUserDeleted}},
+		{admin, "delete", deleted, nil, []string{models.AuditUserDeleted}},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s/%+v", tt.action, tt.from)
+		t.Run(name, func(t *testing.T) {
+			f := newFakeAdminStores()
+			id, actor := uuid.New(), uuid.New()
+			f.users[id] = &models.User{ID: id, IsApproved: tt.from.approved, IsBlocked: tt.from.blocked, IsAdmin: tt.from.admin}
+
+			user, err := actions[tt.action](f.service(), actor, id)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				stored := f.users[id]
+				if stored == nil || stored.IsApproved != tt.from.approved || stored.IsBlocked != tt.from.blocked {
+					t.Errorf("invalid transition changed the user: %+v", stored)
+				}
+				if len(f.audit) != 0 {
+					t.Errorf("invalid transition was audited: %+v", f.audit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if user == nil || user.ID != id {
+				t.Fatalf("returned user = %+v", user)
+			}
+
+			if tt.want == deleted {
+				if _, ok := f.users[id]; ok {
+					t.Error("user was not deleted")
+				}
+			} else {
+				got := userState{approved: user.IsApproved, blocked: user.IsBlocked, admin: user.IsAdmin}
+				stored := f.users[id]
+				if got != *tt.want || stored.IsApproved != tt.want.approved || stored.IsBlocked != tt.want.blocked {
+					t.Errorf("state = %+v (stored %+v), want %+v", got, stored, *tt.want)
+				}
+			}
+
+			if len(f.audit) != len(tt.audits) {
+				t.Fatalf("audit events = %+v, want %v", f.audit, tt.audits)
+			}
+			for i, event := range f.audit {
+				if event.Action != tt.audits[i] || *event.ActorID != actor || *event.TargetID != id {
+					t.Errorf("audit event %d = %+v, want %s by %s on %s", i, event, tt.audits[i], actor, id)
+				}
+			}
+		})
+	}
+}
+
+func TestUserAdmin_StateTimestamps(t *testing.T) {
+	f := newFakeAdminStores()
+	s := f.service()
+	ctx := context.Background()
+	id, actor := uuid.New(), uuid.New()
+	f.users[id] = &models.User{ID: id, Email: "ts@example.com"}
+
+	user, err := s.Approve(ctx, actor, id, false)
+	if err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if user.ApprovedAt == nil || user.ApprovedBy == nil || *user.ApprovedBy != actor {
+		t.Errorf("approved user = %+v, want approved_at and approved_by set", user)
+	}
+
+	user, err = s.Block(ctx, actor, id, "  spam  ")
+	if err != nil {
+		t.Fatalf("Block failed: %v", err)
+	}
+	if user.BlockedAt == nil || user.BlockedReason != "spam" {
+		t.Errorf("blocked user = %+v, want blocked_at and reason set", user)
+	}
+
+	user, err = s.Unblock(ctx, actor, id)
+	if err != nil {
+		t.Fatalf("Unblock failed: %v", err)
+	}
+	if user.BlockedAt != nil || user.BlockedReason != "" {
+		t.Errorf("unblocked user = %+v, want block state cleared", user)
+	}
+	if user.ApprovedAt == nil {
+		t.Error("unblock cleared approved_at")
+	}
+
+	// The audit log keeps the block reason after the user is unblocked
+	var blocked *models.AuditEvent
+	for i := range f.audit {
+		if f.audit[i].Action == models.AuditUserBlocked {
+			blocked = &f.audit[i]
+		}
+	}
+	if blocked == nil || blocked.Details["reason"] != "spam" {
+		t.Errorf("block audit event = %+v, want reason recorded", blocked)
+	}
+
+	user, err = s.Unapprove(ctx, actor, id)
+	if err != nil {
+		t.Fatalf("Unapprove failed: %v", err)
+	}
+	if user.ApprovedAt != nil || user.ApprovedBy != nil {
+		t.Errorf("unapproved user = %+v, want approval state cleared", user)
+	}
+
+	if _, err := s.Block(ctx, actor, id, strings.Repeat("x", maxBlockReasonLength+1)); !errors.Is(err, ErrBlockReasonTooLong) {
+		t.Errorf("long reason error = %v, want ErrBlockReasonTooLong", err)
+	}
+	if f.users[id].IsBlocked {
+		t.Error("user blocked despite rejected reason")
+	}
+}
+
+func TestUserAdmin_UnknownUser(t *testing.T) {
+	s := newFakeAdminStores().service()
+	ctx := context.Background()
+	id := uuid.New()
+
+	if _, err := s.Approve(ctx, uuid.New(), id, true); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Approve error = %v", err)
+	}
+	if _, err := s.Block(ctx, uuid.New(), id, ""); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Block error = %v", err)
+	}
+	if _, err := s.Delete(ctx, uuid.New(), id); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Delete error = %v", err)
+	}
+}
+
+func TestUserAdmin_Detail(t *testing.T) {
+	f := newFakeAdminStores()
+	id := uuid.New()
+	deviceID := uuid.New()
+	lastLogin := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	f.users[id] = &models.User{
+		ID:           id,
+		Email:        "detail@example.com",
+		PasswordHash: "secret-hash",
+		IsApproved:   true,
+		TOTPSecret:   []byte("totp"),
+		LastLoginAt:  &lastLogin,
+	}
+	f.devices[id] = []models.Device{{ID: deviceID, UserID: id, DeviceName: "Laptop"}}
+	f.vaults[id] = &models.EncryptedVault{UserID: id, VaultBlob: make([]byte, 1234), Revision: 7, VaultVersion: 1, UpdatedByDevice: &deviceID}
+	f.logs[id] = []models.SyncLog{{UserID: id, Action: "push"}}
+
+	detail, err := f.service().Detail(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Detail failed: %v", err)
+	}
+
+	if detail.User.Email != "detail@example.com" || !detail.User.IsApproved {
+		t.Errorf("user = %+v", detail.User)
+	}
+	if detail.User.LastLoginAt == nil || *detail.User.LastLoginAt != "2026-01-02T03:04:05Z" {
+		t.Errorf("last_login_at = %v", detail.User.LastLoginAt)
+	}
+	if len(detail.Devices) != 1 || detail.Devices[0].ID != deviceID {
+		t.Errorf("devices = %+v", detail.Devices)
+	}
+	if detail.Vault == nil {
+		t.Fatal("vault metadata missing")
+	}
+	if detail.Vault.Revision != 7 || detail.Vault.SizeBytes != 1234 || *detail.Vault.UpdatedByDevice != deviceID || detail.Vault.DeviceName != "Laptop" {
+		t.Errorf("vault = %+v", detail.Vault)
+	}
+	if len(detail.RecentActivity) != 1 || detail.RecentActivity[0].Action != "push" {
+		t.Errorf("recent activity = %+v", detail.RecentActivity)
+	}
+	if f.logLimit != recentActivityLimit {
+		t.Errorf("activity limit = %d, want %d", f.logLimit, recentActivityLimit)
+	}
+}
+
+func TestUserAdmin_DetailWithoutVault(t *testing.T) {
+	f := newFakeAdminStores()
+	id := uuid.New()
+	f.users[id] = &models.User{ID: id, Email: "new@example.com"}
+
+	detail, err := f.service().Detail(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Detail failed: %v", err)
+	}
+	if detail.Vault != nil {
+		t.Errorf("vault = %+v, want nil", detail.Vault)
+	}
+	if detail.Devices == nil || detail.RecentActivity == nil {
+		t.Error("devices and recent activity should be empty slices, not nil")
+	}
+}
+
+func TestUserAdmin_DetailUnknownUser(t *testing.T) {
+	f := newFakeAdminStores()
+
+	_, err := f.service().Detail(context.Background(), uuid.New())
+	if !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUserAdmin_Vault(t *testing.T) {
+	f := newFakeAdminStores()
+	id, gone := uuid.New(), uuid.New()
+	f.users[id] = &models.User{ID: id}
+	f.vaults[id] = &models.EncryptedVault{UserID: id, VaultBlob: make([]byte, 99), Revision: 3, UpdatedByDevice: &gone}
+
+	vault, err := f.service().Vault(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Vault failed: %v", err)
+	}
+	if vault.Vault == nil || vault.Vault.SizeBytes != 99 || vault.Vault.DeviceName != "" {
+		t.Errorf("vault = %+v, want size 99 and no name for a removed device", vault.Vault)
+	}
+	if vault.RecentActivity == nil {
+		t.Error("recent activity should be an empty slice, not nil")
+	}
+
+	if _, err := f.service().Vault(context.Background(), uuid.New()); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("unknown user: error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUserAdmin_Devices(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeAdminStores()
+	f.sessions = map[uuid.UUID][]models.Session{}
+	userID, otherID, laptop, phone := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	f.users[userID] = &models.User{ID: userID, Email: "user@example.com"}
+	f.users[otherID] = &models.User{ID: otherID}
+	synced, seen := time.Unix(1_700_000_000, 0), time.Unix(1_700_000_600, 0)
+	f.devices[userID] = []models.Device{
+		{ID: laptop, UserID: userID, DeviceName: "Laptop", LastSyncAt: &synced, LastSeenAt: &seen},
+		{ID: phone, UserID: userID, DeviceName: "Phone", LastSyncAt: &synced},
+	}
+	f.sessions[userID] = []models.Session{{DeviceID: laptop}, {DeviceID: laptop}}
+	s := f.service()
+
+	result, err := s.Devices(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := result.Devices
+	if result.User.Email != "user@example.com" {
+		t.Errorf("user = %+v", result.User)
+	}
+	if devices[0].ActiveTokens != 2 || !devices[0].LastActivityAt.Equal(seen) {
+		t.Errorf("laptop = %d tokens, active %v", devices[0].ActiveTokens, devices[0].LastActivityAt)
+	}
+	if devices[1].ActiveTokens != 0 || !devices[1].LastActivityAt.Equal(synced) {
+		t.Errorf("phone = %d tokens, active %v", devices[1].ActiveTokens, devices[1].LastActivityAt)
+	}
+
+	actor := uuid.New()
+	if _, err := s.DeleteDevice(ctx, actor, otherID, laptop); !errors.Is(err, repository.ErrDeviceNotFound) {
+		t.Errorf("deleting another user's device: error = %v, want ErrDeviceNotFound", err)
+	}
+	deleted, err := s.DeleteDevice(ctx, actor, userID, laptop)
+	if err != nil || deleted.DeviceName != "Laptop" {
+		t.Fatalf("DeleteDevice = %+v, %v", deleted, err)
+	}
+	if !f.revoked[laptop] || len(f.devices[userID]) != 1 {
+		t.Errorf("tokens revoked %v, %d devices left", f.revoked[laptop], len(f.devices[userID]))
+	}
+	if len(f.audit) != 1 {
+		t.Fatalf("%d audit events, want 1", len(f.audit))
+	}
+	if e := f.audit[0]; e.Action != models.AuditDeviceDeleted || *e.ActorID != actor || *e.TargetID != userID || e.Details["device_name"] != "Laptop" {
+		t.Errorf("audit event = %+v", e)
+	}
+}
+
+func TestUserAdmin_RevokeSession(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeAdminStores()
+	userID, otherID, laptop := uuid.New(), uuid.New(), uuid.New()
+	mine, kept, theirs := uuid.New(), uuid.New(), uuid.New()
+	f.users[userID] = &models.User{ID: userID, Email: "user@example.com"}
+	f.users[otherID] = &models.User{ID: otherID}
+	f.sessions = map[uuid.UUID][]models.Session{
+		userID:  {{ID: mine, DeviceID: laptop, DeviceName: "Laptop"}, {ID: kept, DeviceID: laptop, DeviceName: "Laptop"}},
+		otherID: {{ID: theirs}},
+	}
+	s := f.service()
+
+	listed, err := s.Sessions(ctx, userID)
+	if err != nil || len(listed.Sessions) != 2 || listed.User.Email != "user@example.com" {
+		t.Fatalf("Sessions = %+v, %v", listed, err)
+	}
+
+	actor := uuid.New()
+	if _, err := s.RevokeSession(ctx, actor, userID, theirs); !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		t.Errorf("revoking another user's session: error = %v, want ErrRefreshTokenNotFound", err)
+	}
+	revoked, err := s.RevokeSession(ctx, actor, userID, mine)
+	if err != nil || revoked.ID != mine {
+		t.Fatalf("RevokeSession = %+v, %v", revoked, err)
+	}
+	if !f.revoked[mine] || f.revoked[kept] || len(f.sessions[otherID]) != 1 {
+		t.Errorf("revoked = %v, want only the chosen session", f.revoked)
+	}
+	if len(f.audit) != 1 {
+		t.Fatalf("%d audit events, want 1", len(f.audit))
+	}
+	if e := f.audit[0]; e.Action != models.AuditSessionRevoked || *e.ActorID != actor || *e.TargetID != userID ||
+		e.Details["session_id"] != mine.String() || e.Details["device_name"] != "Laptop" {
+		t.Errorf("audit event = %+v", e)
+	}
+	if _, err := s.RevokeSession(ctx, actor, userID, mine); !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		t.Errorf("revoking twice: error = %v, want ErrRefreshTokenNotFound", err)
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// APIKeyPrefix marks bearer credentials that are API keys rather than JWTs
+const APIKeyPrefix = "vtk_"
+
+// apiKeyBytes is the amount of randomness in an API key secret
+const apiKeyBytes = 32
+
+// API key access levels, chosen when a key is created
+const (
+	APIKeyAccessRead      = "read"
+	APIKeyAccessReadWrite = "read_write"
+)
+
+// apiKeyScopes maps access levels to the scopes of a key. API keys never
+// get the account or admin scope, whatever their owner may do.
+var apiKeyScopes = map[string][]string{
+	APIKeyAccessRead:      {middleware.ScopeVaultRead},
+	APIKeyAccessReadWrite: {middleware.ScopeVaultRead, middleware.ScopeVaultWrite},
+}
+
+var (
+	ErrInvalidAPIKey       = errors.New("invalid api key")
+	ErrInvalidAPIKeyAccess = errors.New("invalid api key access level")
+)
+
+// apiKeyStore is the subset of APIKeyRepository needed for API keys
+type apiKeyStore interface {
+	Create(ctx context.Context, userID uuid.UUID, name, keyPrefix, keyHash string, scopes []string) (*models.APIKey, error)
+	GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	GetActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error)
+	Revoke(ctx context.Context, userID, id uuid.UUID) error
+	UpdateLastUsed(ctx context.Context, id uuid.UUID) error
+}
+
+// apiKeyUserStore is the subset of UserRepository needed to authenticate API keys
+type apiKeyUserStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+}
+
+// APIKeys mints and authenticates API keys
+type APIKeys struct {
+	keys  apiKeyStore
+	users apiKeyUserStore
+}
+
+// NewAPIKeys creates a new API key service
+func NewAPIKeys(keys apiKeyStore, users apiKeyUserStore) *APIKeys {
+	return &APIKeys{keys: keys, users: users}
+}
+
+// Create mints a new API key for a user with the given access level. The
+// returned secret is not stored and cannot be recovered later.
+func (s *APIKeys) Create(ctx context.Context, userID uuid.UUID, name, access string) (string, *models.APIKey, error) {
+	scopes, ok := apiKeyScopes[access]
+	if !ok {
+		return "", nil, ErrInvalidAPIKeyAccess
+	}
+
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret := APIKeyPrefix + hex.EncodeToString(b)
+
+	key, err := s.keys.Create(ctx, userID, name, secret[:len(APIKeyPrefix)+8], hashAPIKey(secret), scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, key, nil
+}
+
+// Authenticate resolves an API key secret to its active owner
+func (s *APIKeys) Authenticate(ctx context.Context, secret string) (*models.User, *models.APIKey, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.keys.GetByHash(ctx, hashAPIKey(secret))
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if key.Revoked {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.users.GetByID(ctx, key.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.IsApproved || user.IsBlocked {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	_ = s.keys.UpdateLastUsed(ctx, key.ID)
+	return user, key, nil
+}
+
+// List lists the active API keys of a user
+func (s *APIKeys) List(ctx context.Context, userID uuid.UUID) ([]models.APIKey, error) {
+	return s.keys.GetActiveByUser(ctx, userID)
+}
+
+// Revoke revokes one of a user's API keys
+func (s *APIKeys) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	return s.keys.Revoke(ctx, userID, id)
+}
+
+// hashAPIKey hashes an API key secret for storage
+func hashAPIKey(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+// approvalQueueCheckInterval is how often the approval queue job wakes up
+const approvalQueueCheckInterval = time.Hour
+
+// pendingQueueStore is the subset of UserRepository needed for the approval queue
+type pendingQueueStore interface {
+	ListPending(ctx context.Context) ([]models.User, error)
+	ListAdmins(ctx context.Context) ([]models.User, error)
+	DeleteIfPending(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+// ApprovalQueue reminds admins of pending registrations and optionally
+// rejects registrations that have waited too long
+type ApprovalQueue struct {
+	users           pendingQueueStore
+	notifier        notify.Notifier
+	digestInterval  time.Duration
+	autoRejectAfter time.Duration
+	notifyNew       bool
+	now             func() time.Time
+	lastDigest      time.Time
+}
+
+// NewApprovalQueue creates a new approval queue service. A zero
+// digestInterval disables the digest; autoRejectDays <= 0 disables
+// auto-reject. With notifyNew admins are also told of each registration
+// as it arrives.
+func NewApprovalQueue(users pendingQueueStore, notifier notify.Notifier, digestInterval time.Duration, autoRejectDays int, notifyNew bool) *ApprovalQueue {
+	q := &ApprovalQueue{
+		users:          users,
+		notifier:       notifier,
+		digestInterval: digestInterval,
+		notifyNew:      notifyNew,
+		now:            time.Now,
+	}
+	if autoRejectDays > 0 {
+		q.autoRejectAfter = time.Duration(autoRejectDays) * 24 * time.Hour
+	}
+	return q
+}
+
+// Run performs queue maintenance until ctx is cancelled
+func (q *ApprovalQueue) Run(ctx context.Context) {
+	if q.digestInterval <= 0 && q.autoRejectAfter <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(approvalQueueCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		q.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick runs auto-reject and, when due, the digest
+func (q *ApprovalQueue) tick(ctx context.Context) {
+	if q.autoRejectAfter > 0 {
+		if n, err := q.AutoReject(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to auto-reject pending registrations")
+		} else if n > 0 {
+			log.Info().Int("count", n).Msg("Auto-rejected stale registrations")
+		}
+	}
+
+	if q.digestInterval > 0 && q.now().Sub(q.lastDigest) >= q.digestInterval {
+		if err := q.SendDigest(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to send pending registration digest")
+			return
+		}
+		q.lastDigest = q.now()
+	}
+}
+
+// SendDigest notifies every admin about pending registrations. Nothing is
+// sent when the queue is empty.
+func (q *ApprovalQueue) SendDigest(ctx context.Context) error {
+	pending, err := q.users.ListPending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	admins, err := q.users.ListAdmins(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := composeDigest(pending, q.now())
+	subject := fmt.Sprintf("%d registration(s) awaiting approval", len(pending))
+	for _, admin := range admins {
+		err := q.notifier.Notify(ctx, notify.Notification{
+			Kind:    notify.KindPendingDigest,
+			UserID:  admin.ID,
+			Email:   admin.Email,
+			Subject: subject,
+			Body:    body,
+		})
+		if err != nil {
+			log.Error().Err(err).Str("user_id", admin.ID.String()).Msg("Failed to send pending registration digest")
+		}
+	}
+	return nil
+}
+
+// NotifyRegistration tells every admin that user registered and awaits
+// approval, if enabled. Failures are logged; the registration stands.
+func (q *ApprovalQueue) NotifyRegistration(ctx context.Context, user *models.User) {
+	if !q.notifyNew || user.IsApproved {
+		return
+	}
+	admins, err := q.users.ListAdmins(ctx)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to list admins for registration notice")
+		return
+	}
+
+	who := user.Email
+	if user.DisplayName != "" {
+		who = fmt.Sprintf("%s <%s>", user.DisplayName, user.Email)
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s registered and is waiting for approval.\n", who)
+	if user.RegistrationMessage != "" {
+		fmt.Fprintf(&body, "\nMessage: %s\n", user.RegistrationMessage)
+	}
+	body.WriteString("\nReview it in the admin panel under Approvals.")
+
+	for _, admin := range admins {
+		err := q.notifier.Notify(ctx, notify.Notification{
+			Kind:    notify.KindRegistrationPending,
+			UserID:  admin.ID,
+			Email:   admin.Email,
+			Subject: "New registration awaiting approval",
+			Body:    body.String(),
+		})
+		if err != nil {
+			log.Error().Err(err).Str("user_id", admin.ID.String()).Msg("Failed to send registration notice")
+		}
+	}
+}
+
+// AutoReject deletes registrations pending longer than the configured
+// cutoff and notifies the rejected addresses. Approved and blocked users
+// are never touched.
+func (q *ApprovalQueue) AutoReject(ctx context.Context) (int, error) {
+	if q.autoRejectAfter <= 0 {
+		return 0, nil
+	}
+
+	pending, err := q.users.ListPending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := q.now().Add(-q.autoRejectAfter)
+	rejected := 0
+	for _, u := range pending {
+		if !u.CreatedAt.Before(cutoff) {
+			continue
+		}
+		deleted, err := q.users.DeleteIfPending(ctx, u.ID)
+		if err != nil {
+			return rejected, err
+		}
+		if !deleted {
+			continue
+		}
+		rejected++
+
+		err = q.notifier.Notify(ctx, notify.Notification{
+			Kind:    notify.KindRegistrationRejected,
+			UserID:  u.ID,
+			Email:   u.Email,
+			Subject: "Your registration was not approved",
+			Body: fmt.Sprintf(
+				"Your registration from %s was not approved within %d days and has been removed. "+
+					"You are welcome to register again.",
+				u.CreatedAt.Format("2006-01-02"), int(q.autoRejectAfter.Hours()/24),
+			),
+		})
+		if err != nil {
+			log.Error().Err(err).Str("user_id", u.ID.String()).Msg("Failed to send rejection notification")
+		}
+	}
+	return rejected, nil
+}
+
+// composeDigest lists pending users, oldest first, with their waiting time
+func composeDigest(pending []models.User, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d registration(s) are waiting for approval:\n\n", len(pending))
+	for _, u := range pending {
+		who := u.Email
+		if u.DisplayName != "" {
+			who = fmt.Sprintf("%s <%s>", u.DisplayName, u.Email)
+		}
+		fmt.Fprintf(&b, "- %s (waiting %s)\n", who, formatWaiting(now.Sub(u.CreatedAt)))
+	}
+	b.WriteString("\nReview them in the admin panel under Approvals.")
+	return b.String()
+}
+
+// formatWaiting renders a waiting time in days or hours
+func formatWaiting(d time.Duration) string {
+	if days := int(d.Hours() / 24); days >= 1 {
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+	if hours := int(d.Hours()); hours >= 1 {
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return "less than an hour"
+}
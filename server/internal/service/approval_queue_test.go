@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+type memQueueUsers struct {
+	users map[uuid.UUID]*models.User
+}
+
+func (m *memQueueUsers) add(email string, createdAt time.Time, approved, admin bool) *models.User {
+	u := &models.User{ID: uuid.New(), Email: email, CreatedAt: createdAt, IsApproved: approved, IsAdmin: admin}
+	m.users[u.ID] = u
+	return u
+}
+
+func (m *memQueueUsers) ListPending(context.Context) ([]models.User, error) {
+	var out []models.User
+	for _, u := range m.users {
+		if !u.IsApproved && !u.IsBlocked {
+			out = append(out, *u)
+		}
+	}
+	// oldest first, like the repository
+	for i := 1; i < len(out); i++ {
+		for j := i; j > 0 && out[j].CreatedAt.Before(out[j-1].CreatedAt); j-- {
+			out[j], out[j-1] = out[j-1], out[j]
+		}
+	}
+	return out, nil
+}
+
+func (m *memQueueUsers) ListAdmins(context.Context) ([]models.User, error) {
+	var out []models.User
+	for _, u := range m.users {
+		if u.IsAdmin {
+			out = append(out, *u)
+		}
+	}
+	return out, nil
+}
+
+func (m *memQueueUsers) DeleteIfPending(_ context.Context, id uuid.UUID) (bool, error) {
+	u, ok := m.users[id]
+	if !ok || u.IsApproved || u.IsBlocked {
+		return false, nil
+	}
+	delete(m.users, id)
+	return true, nil
+}
+
+type recordingNotifier struct {
+	sent []notify.Notification
+}
+
+func (r *recordingNotifier) Notify(_ context.Context, n notify.Notification) error {
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func newTestQueue(autoRejectDays int) (*ApprovalQueue, *memQueueUsers, *recordingNotifier, time.Time) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	users := &memQueueUsers{users: make(map[uuid.UUID]*models.User)}
+	notifier := &recordingNotifier{}
+	q := NewApprovalQueue(users, notifier, 24*time.Hour, autoRejectDays, false)
+	q.now = func() time.Time { return now }
+	return q, users, notifier, now
+}
+
+func TestApprovalQueueDigestComposition(t *testing.T) {
+	q, users, notifier, now := newTestQueue(0)
+	admin := users.add("admin@example.com", now.Add(-90*24*time.Hour), true, true)
+	users.add("newer@example.com", now.Add(-3*time.Hour), false, false)
+	users.add("older@example.com", now.Add(-5*24*time.Hour), false, false).DisplayName = "Olga Older"
+	users.add("active@example.com", now.Add(-10*24*time.Hour), true, false)
+
+	if err := q.SendDigest(context.Background()); err != nil {
+		t.Fatalf("SendDigest: %v", err)
+	}
+	if len(notifier.sent) != 1 {
+		t.Fatalf("sent %d digests, want 1 (one admin)", len(notifier.sent))
+	}
+
+	n := notifier.sent[0]
+	if n.Kind != notify.KindPendingDigest || n.UserID != admin.ID {
+		t.Errorf("digest sent as %q to %s", n.Kind, n.UserID)
+	}
+	if !strings.Contains(n.Subject, "2 registration") {
+		t.Errorf("subject = %q", n.Subject)
+	}
+	older := strings.Index(n.Body, "Olga Older <older@example.com> (waiting 5 days)")
+	newer := strings.Index(n.Body, "newer@example.com (waiting 3 hours)")
+	if older < 0 || newer < 0 || older > newer {
+		t.Errorf("digest body not listing pending users oldest first:\n%s", n.Body)
+	}
+	if strings.Contains(n.Body, "active@example.com") {
+		t.Error("digest lists an approved user")
+	}
+}
+
+func TestApprovalQueueNotifyRegistration(t *testing.T) {
+	q, users, notifier, now := newTestQueue(0)
+	admin := users.add("admin@example.com", now.Add(-time.Hour), true, true)
+	user := users.add("new@example.com", now, false, false)
+	user.RegistrationMessage = "Ops team"
+
+	q.NotifyRegistration(context.Background(), user)
+	if len(notifier.sent) != 0 {
+		t.Fatalf("sent %d notices while disabled", len(notifier.sent))
+	}
+
+	q.notifyNew = true
+	q.NotifyRegistration(context.Background(), user)
+	if len(notifier.sent) != 1 {
+		t.Fatalf("sent %d notices, want 1 (one admin)", len(notifier.sent))
+	}
+	n := notifier.sent[0]
+	if n.Kind != notify.KindRegistrationPending || n.UserID != admin.ID {
+		t.Errorf("notice sent as %q to %s", n.Kind, n.UserID)
+	}
+	if !strings.Contains(n.Body, "new@example.com") || !strings.Contains(n.Body, "Ops team") {
+		t.Errorf("notice body = %q", n.Body)
+	}
+}
+
+func TestApprovalQueueDigestSkipsEmptyQueue(t *testing.T) {
+	q, users, notifier, now := newTestQueue(0)
+	users.add("admin@example.com", now.Add(-time.Hour), true, true)
+
+	if err := q.SendDigest(context.Background()); err != nil {
+		t.Fatalf("SendDigest: %v", err)
+	}
+	if len(notifier.sent) != 0 {
+		t.Errorf("sent %d notifications for an empty queue", len(notifier.sent))
+	}
+}
+
+func TestApprovalQueueAutoRejectCutoff(t *testing.T) {
+	q, users, notifier, now := newTestQueue(7)
+	stale := users.add("stale@example.com", now.Add(-8*24*time.Hour), false, false)
+	fresh := users.add("fresh@example.com", now.Add(-6*24*time.Hour), false, false)
+	approved := users.add("approved@example.com", now.Add(-30*24*time.Hour), true, false)
+	blocked := users.add("blocked@example.com", now.Add(-30*24*time.Hour), false, false)
+	blocked.IsBlocked = true
+
+	n, err := q.AutoReject(context.Background())
+	if err != nil {
+		t.Fatalf("AutoReject: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("rejected %d users, want 1", n)
+	}
+	if _, ok := users.users[stale.ID]; ok {
+		t.Error("stale registration was not removed")
+	}
+	for _, u := range []*models.User{fresh, approved, blocked} {
+		if _, ok := users.users[u.ID]; !ok {
+			t.Errorf("%s was removed", u.Email)
+		}
+	}
+
+	if len(notifier.sent) != 1 || notifier.sent[0].Email != "stale@example.com" ||
+		notifier.sent[0].Kind != notify.KindRegistrationRejected {
+		t.Errorf("unexpected notifications: %+v", notifier.sent)
+	}
+}
+
+func TestApprovalQueueAutoRejectDisabled(t *testing.T) {
+	q, users, _, now := newTestQueue(0)
+	u := users.add("ancient@example.com", now.Add(-365*24*time.Hour), false, false)
+
+	if n, err := q.AutoReject(context.Background()); err != nil || n != 0 {
+		t.Fatalf("AutoReject = %d, %v; want 0, nil", n, err)
+	}
+	if _, ok := users.users[u.ID]; !ok {
+		t.Error("registration removed with auto-reject disabled")
+	}
+}
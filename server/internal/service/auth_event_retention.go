@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// authEventRetentionInterval is how often old auth events are pruned
+const authEventRetentionInterval = time.Hour
+
+// authEventPruner is the subset of AuthEventRepository needed for retention
+type authEventPruner interface {
+	DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// AuthEventRetention prunes the account activity log
+type AuthEventRetention struct {
+	events    authEventPruner
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewAuthEventRetention creates the retention job. Events older than
+// retention are deleted; 0 keeps them forever.
+func NewAuthEventRetention(events authEventPruner, retention time.Duration) *AuthEventRetention {
+	return &AuthEventRetention{events: events, retention: retention, now: time.Now}
+}
+
+// Run prunes old events until ctx is cancelled
+func (r *AuthEventRetention) Run(ctx context.Context) {
+	if r.retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(authEventRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := r.Prune(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to prune auth events")
+		} else if n > 0 {
+			log.Info().Int64("count", n).Msg("Pruned old auth events")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune deletes events older than the retention period
+func (r *AuthEventRetention) Prune(ctx context.Context) (int64, error) {
+	if r.retention <= 0 {
+		return 0, nil
+	}
+	return r.events.DeleteOlderThan(ctx, r.now().Add(-r.retention))
+}
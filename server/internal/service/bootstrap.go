@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// BootstrapTokenLifetime is how long a bootstrap token stays valid
+const BootstrapTokenLifetime = time.Hour
+
+// bootstrapTokenBytes is the amount of randomness in a bootstrap token
+const bootstrapTokenBytes = 32
+
+// defaultBootstrapAPIKeyName names the API key minted during bootstrap
+const defaultBootstrapAPIKeyName = "bootstrap"
+
+// Runtime settings that may be set during bootstrap
+const (
+	SettingRegistrationEnabled = "registration_enabled"
+)
+
+// bootstrapSettings validates each runtime setting accepted by bootstrap
+var bootstrapSettings = map[string]func(string) error{
+	SettingRegistrationEnabled: func(v string) error {
+		_, err := strconv.ParseBool(v)
+		return err
+	},
+}
+
+var (
+	ErrBootstrapComplete     = errors.New("instance already set up")
+	ErrInvalidBootstrapToken = errors.New("invalid bootstrap token")
+	ErrUnknownSetting        = errors.New("unknown setting")
+	ErrInvalidSettingValue   = errors.New("invalid setting value")
+)
+
+// bootstrapTokenStore is the subset of BootstrapTokenRepository needed for bootstrap
+type bootstrapTokenStore interface {
+	Replace(ctx context.Context, tokenHash string, expiresAt time.Time) error
+	Consume(ctx context.Context, tokenHash string) error
+}
+
+// bootstrapUserStore is the subset of UserRepository needed for bootstrap
+type bootstrapUserStore interface {
+	HasUsers(ctx context.Context) (bool, error)
+	Create(ctx context.Context, email, passwordHash string) (*models.User, error)
+	SetApproved(ctx context.Context, id uuid.UUID, approved bool, by *uuid.UUID) error
+	SetAdmin(ctx context.Context, id uuid.UUID, admin bool) error
+	MarkEmailVerified(ctx context.Context, id uuid.UUID) error
+}
+
+// settingStore is the subset of SettingRepository needed for bootstrap
+type settingStore interface {
+	Set(ctx context.Context, key, value string) error
+}
+
+// apiKeyMinter creates API keys
+type apiKeyMinter interface {
+	Create(ctx context.Context, userID uuid.UUID, name, access string) (string, *models.APIKey, error)
+}
+
+// Bootstrap configures a fresh instance through a one-time token
+type Bootstrap struct {
+	tokens   bootstrapTokenStore
+	users    bootstrapUserStore
+	settings settingStore
+	apiKeys  apiKeyMinter
+	now      func() time.Time
+}
+
+// NewBootstrap creates a new bootstrap service
+func NewBootstrap(tokens bootstrapTokenStore, users bootstrapUserStore, settings settingStore, apiKeys apiKeyMinter) *Bootstrap {
+	return &Bootstrap{
+		tokens:   tokens,
+		users:    users,
+		settings: settings,
+		apiKeys:  apiKeys,
+		now:      time.Now,
+	}
+}
+
+// Available reports whether the instance still needs to be set up
+func (s *Bootstrap) Available(ctx context.Context) (bool, error) {
+	hasUsers, err := s.users.HasUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+	return !hasUsers, nil
+}
+
+// IssueToken generates a new bootstrap token if no users exist yet.
+// Only the hash is stored; any earlier unused token is discarded.
+func (s *Bootstrap) IssueToken(ctx context.Context) (string, error) {
+	available, err := s.Available(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !available {
+		return "", ErrBootstrapComplete
+	}
+
+	b := make([]byte, bootstrapTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	if err := s.tokens.Replace(ctx, hashBootstrapToken(token), s.now().Add(BootstrapTokenLifetime)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Run consumes the bootstrap token and creates the admin, the initial
+// settings and, if requested, an admin API key
+func (s *Bootstrap) Run(ctx context.Context, req *models.BootstrapRequest) (*models.BootstrapResponse, error) {
+	available, err := s.Available(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, ErrBootstrapComplete
+	}
+
+	for key, value := range req.Settings {
+		validate, ok := bootstrapSettings[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+		}
+		if err := validate(value); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSettingValue, key)
+		}
+	}
+
+	hashedPassword, err := password.Hash(ctx, req.AdminPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.tokens.Consume(ctx, hashBootstrapToken(req.Token)); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrBootstrapTokenNotFound),
+			errors.Is(err, repository.ErrBootstrapTokenExpired):
+			return nil, ErrInvalidBootstrapToken
+		case errors.Is(err, repository.ErrBootstrapTokenUsed):
+			return nil, ErrBootstrapComplete
+		}
+		return nil, err
+	}
+
+	user, err := s.users.Create(ctx, req.AdminEmail, hashedPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.SetApproved(ctx, user.ID, true, nil); err != nil {
+		return nil, err
+	}
+	if err := s.users.SetAdmin(ctx, user.ID, true); err != nil {
+		return nil, err
+	}
+	if err := s.users.MarkEmailVerified(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string, len(req.Settings))
+	for key, value := range req.Settings {
+		if err := s.settings.Set(ctx, key, value); err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+
+	resp := &models.BootstrapResponse{
+		AdminID:  user.ID,
+		Settings: settings,
+	}
+
+	if req.CreateAPIKey {
+		name := req.APIKeyName
+		if name == "" {
+			name = defaultBootstrapAPIKeyName
+		}
+		// Like every API key, it has no admin access
+		secret, key, err := s.apiKeys.Create(ctx, user.ID, name, APIKeyAccessReadWrite)
+		if err != nil {
+			return nil, err
+		}
+		grant := models.NewTokenGrant(key.Scopes, key.ID)
+		resp.APIKey = secret
+		resp.TokenGrant = &grant
+	}
+
+	return resp, nil
+}
+
+// hashBootstrapToken hashes a bootstrap token for storage
+func hashBootstrapToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+type memBootstrapToken struct {
+	expiresAt time.Time
+	used      bool
+}
+
+type memBootstrapStores struct {
+	now      time.Time
+	tokens   map[string]*memBootstrapToken
+	users    map[uuid.UUID]*models.User
+	settings map[string]string
+	keys     int
+}
+
+func newMemBootstrapStores() *memBootstrapStores {
+	return &memBootstrapStores{
+		now:      time.Now(),
+		tokens:   make(map[string]*memBootstrapToken),
+		users:    make(map[uuid.UUID]*models.User),
+		settings: make(map[string]string),
+	}
+}
+
+func (m *memBootstrapStores) Replace(_ context.Context, tokenHash string, expiresAt time.Time) error {
+	for h, t := range m.tokens {
+		if !t.used {
+			delete(m.tokens, h)
+		}
+	}
+	m.tokens[tokenHash] = &memBootstrapToken{expiresAt: expiresAt}
+	return nil
+}
+
+func (m *memBootstrapStores) Consume(_ context.Context, tokenHash string) error {
+	t, ok := m.tokens[tokenHash]
+	switch {
+	case !ok:
+		return repository.ErrBootstrapTokenNotFound
+	case t.used:
+		return repository.ErrBootstrapTokenUsed
+	case !m.now.Before(t.expiresAt):
+		return repository.ErrBootstrapTokenExpired
+	}
+	t.used = true
+	return nil
+}
+
+func (m *memBootstrapStores) HasUsers(context.Context) (bool, error) {
+	return len(m.users) > 0, nil
+}
+
+func (m *memBootstrapStores) Create(_ context.Context, email, passwordHash string) (*models.User, error) {
+	u := &models.User{ID: uuid.New(), Email: email, PasswordHash: passwordHash}
+	m.users[u.ID] = u
+	return u, nil
+}
+
+func (m *memBootstrapStores) SetApproved(_ context.Context, id uuid.UUID, approved bool, _ *uuid.UUID) error {
+	m.users[id].IsApproved = approved
+	return nil
+}
+
+func (m *memBootstrapStores) SetAdmin(_ context.Context, id uuid.UUID, admin bool) error {
+	m.users[id].IsAdmin = admin
+	return nil
+}
+
+func (m *memBootstrapStores) MarkEmailVerified(_ context.Context, id uuid.UUID) error {
+	m.users[id].EmailVerified = true
+	return nil
+}
+
+func (m *memBootstrapStores) Set(_ context.Context, key, value string) error {
+	m.settings[key] = value
+	return nil
+}
+
+// bootstrapKeys adapts memBootstrapStores to apiKeyMinter
+type bootstrapKeys struct{ m *memBootstrapStores }
+
+func (k bootstrapKeys) Create(_ context.Context, userID uuid.UUID, name, access string) (string, *models.APIKey, error) {
+	k.m.keys++
+	return APIKeyPrefix + "test", &models.APIKey{ID: uuid.New(), UserID: userID, Name: name, Scopes: apiKeyScopes[access]}, nil
+}
+
+func newBootstrapService(m *memBootstrapStores) *Bootstrap {
+	s := NewBootstrap(m, m, m, bootstrapKeys{m})
+	s.now = func() time.Time { return m.now }
+	return s
+}
+
+func bootstrapRequest(token string) *models.BootstrapRequest {
+	return &models.BootstrapRequest{
+		Token:         token,
+		AdminEmail:    "admin@example.com",
+		AdminPassword: "correct horse battery",
+		Settings:      map[string]string{SettingRegistrationEnabled: "false"},
+		CreateAPIKey:  true,
+	}
+}
+
+func TestBootstrapCreatesAdminSettingsAndKey(t *testing.T) {
+	m := newMemBootstrapStores()
+	s := newBootstrapService(m)
+	ctx := context.Background()
+
+	token, err := s.IssueToken(ctx)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	for hash := range m.tokens {
+		if hash == token {
+			t.Fatal("bootstrap token stored in plain text")
+		}
+	}
+
+	resp, err := s.Run(ctx, bootstrapRequest(token))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	admin := m.users[resp.AdminID]
+	if admin == nil || !admin.IsAdmin || !admin.IsApproved || !admin.EmailVerified {
+		t.Fatalf("admin not created correctly: %+v", admin)
+	}
+	if m.settings[SettingRegistrationEnabled] != "false" {
+		t.Errorf("setting not stored: %v", m.settings)
+	}
+	if resp.APIKey == "" || m.keys != 1 {
+		t.Errorf("api key not minted: %q (%d)", resp.APIKey, m.keys)
+	}
+	if g := resp.TokenGrant; g == nil || g.TokenType != models.TokenTypeBearer || g.Scope != "vault:read vault:write" || g.AuthSessionID == uuid.Nil {
+		t.Errorf("api key grant = %+v", g)
+	}
+}
+
+func TestBootstrapExpiredToken(t *testing.T) {
+	m := newMemBootstrapStores()
+	s := newBootstrapService(m)
+	ctx := context.Background()
+
+	token, err := s.IssueToken(ctx)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	m.now = m.now.Add(BootstrapTokenLifetime + time.Second)
+
+	if _, err := s.Run(ctx, bootstrapRequest(token)); !errors.Is(err, ErrInvalidBootstrapToken) {
+		t.Fatalf("expected ErrInvalidBootstrapToken, got %v", err)
+	}
+	if len(m.users) != 0 {
+		t.Error("expired token created a user")
+	}
+}
+
+func TestBootstrapTokenReuse(t *testing.T) {
+	m := newMemBootstrapStores()
+	s := newBootstrapService(m)
+	ctx := context.Background()
+
+	token, err := s.IssueToken(ctx)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	if _, err := s.Run(ctx, bootstrapRequest(token)); err != nil {
+		t.Fatalf("first Run: %v", err)
+	}
+
+	// Even if the admin is removed again, the consumed token stays dead
+	m.users = make(map[uuid.UUID]*models.User)
+	if _, err := s.Run(ctx, bootstrapRequest(token)); !errors.Is(err, ErrBootstrapComplete) {
+		t.Fatalf("expected ErrBootstrapComplete on reuse, got %v", err)
+	}
+}
+
+func TestBootstrapAfterSetup(t *testing.T) {
+	m := newMemBootstrapStores()
+	s := newBootstrapService(m)
+	ctx := context.Background()
+
+	token, err := s.IssueToken(ctx)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+	m.Create(ctx, "someone@example.com", "x")
+
+	if _, err := s.Run(ctx, bootstrapRequest(token)); !errors.Is(err, ErrBootstrapComplete) {
+		t.Fatalf("expected ErrBootstrapComplete, got %v", err)
+	}
+	if _, err := s.IssueToken(ctx); !errors.Is(err, ErrBootstrapComplete) {
+		t.Fatalf("expected no token once users exist, got %v", err)
+	}
+}
+
+func TestBootstrapRejectsUnknownSetting(t *testing.T) {
+	m := newMemBootstrapStores()
+	s := newBootstrapService(m)
+	ctx := context.Background()
+
+	token, _ := s.IssueToken(ctx)
+	req := bootstrapRequest(token)
+	req.Settings = map[string]string{"jwt_secret": "x"}
+
+	if _, err := s.Run(ctx, req); !errors.Is(err, ErrUnknownSetting) {
+		t.Fatalf("expected ErrUnknownSetting, got %v", err)
+	}
+
+	// Token is not consumed by a rejected request
+	if _, err := s.Run(ctx, bootstrapRequest(token)); err != nil {
+		t.Fatalf("Run after rejected request: %v", err)
+	}
+}
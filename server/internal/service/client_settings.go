@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// PushCodeTooLarge rejects a client settings blob over the size cap
+const PushCodeTooLarge = "TOO_LARGE"
+
+// MaxClientSettingsBytes caps the decoded client settings blob
+const MaxClientSettingsBytes = 64 << 10
+
+// clientSettingsStore is the subset of ClientSettingsRepository needed for sync
+type clientSettingsStore interface {
+	GetByUserID(ctx context.Context, userID uuid.UUID) (*models.ClientSettings, error)
+	Create(ctx context.Context, userID uuid.UUID, blob []byte, deviceID *uuid.UUID) (*models.ClientSettings, error)
+	UpdateWithRevisionCheck(ctx context.Context, userID uuid.UUID, blob []byte, expectedRevision int, deviceID *uuid.UUID) (*models.ClientSettings, error)
+}
+
+// ClientSettingsSync implements the client settings blob. It follows the
+// vault push semantics but keeps its own revision, so a preferences
+// change never touches the vault revision.
+type ClientSettingsSync struct {
+	settings clientSettingsStore
+}
+
+// NewClientSettingsSync creates a new client settings sync service
+func NewClientSettingsSync(settings clientSettingsStore) *ClientSettingsSync {
+	return &ClientSettingsSync{settings: settings}
+}
+
+// Get returns the settings blob of a user or nil if there is none
+func (s *ClientSettingsSync) Get(ctx context.Context, userID uuid.UUID) (*models.ClientSettings, error) {
+	settings, err := s.settings.GetByUserID(ctx, userID)
+	if errors.Is(err, repository.ErrClientSettingsNotFound) {
+		return nil, nil
+	}
+	return settings, err
+}
+
+// Put validates and stores req. If the verdict is not valid nothing is
+// written and the returned settings are nil.
+func (s *ClientSettingsSync) Put(ctx context.Context, userID, deviceID uuid.UUID, req *models.SettingsBlobPutRequest) (*models.VaultPushVerdict, *models.ClientSettings, error) {
+	verdict, blob, current, err := s.plan(ctx, userID, req)
+	if err != nil || !verdict.Valid {
+		return verdict, nil, err
+	}
+
+	var device *uuid.UUID
+	if deviceID != uuid.Nil {
+		device = &deviceID
+	}
+
+	var settings *models.ClientSettings
+	if current == nil {
+		settings, err = s.settings.Create(ctx, userID, blob, device)
+	} else {
+		settings, err = s.settings.UpdateWithRevisionCheck(ctx, userID, blob, current.Revision, device)
+	}
+	if errors.Is(err, repository.ErrClientSettingsConflict) {
+		// Another device wrote in between; report it as a regular conflict
+		verdict, _, _, err = s.plan(ctx, userID, req)
+		if err == nil && verdict.Valid {
+			verdict.Valid = false
+			verdict.WouldConflict = true
+			verdict.Code, verdict.Error = PushCodeConflict, "revision mismatch"
+		}
+		return verdict, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return verdict, settings, nil
+}
+
+// plan runs every put check without writing anything
+func (s *ClientSettingsSync) plan(ctx context.Context, userID uuid.UUID, req *models.SettingsBlobPutRequest) (*models.VaultPushVerdict, []byte, *models.ClientSettings, error) {
+	v := &models.VaultPushVerdict{LocalRevision: req.Revision}
+
+	blob, err := base64.StdEncoding.DecodeString(req.SettingsBlob)
+	if err != nil {
+		v.Code, v.Error = PushCodeInvalidEncoding, "invalid settings blob encoding"
+		return v, nil, nil, nil
+	}
+	v.SizeBytes = len(blob)
+	if len(blob) > MaxClientSettingsBytes {
+		v.Code, v.Error = PushCodeTooLarge, fmt.Sprintf("settings blob exceeds %d bytes", MaxClientSettingsBytes)
+		return v, nil, nil, nil
+	}
+
+	current, err := s.settings.GetByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, repository.ErrClientSettingsNotFound) {
+		return nil, nil, nil, err
+	}
+
+	if current == nil {
+		v.Valid = true
+		v.WouldCreate = true
+		v.NextRevision = 1
+		return v, blob, nil, nil
+	}
+
+	v.ServerRevision = current.Revision
+	v.ServerUpdated = current.UpdatedAt.Unix()
+	if current.UpdatedByDevice != nil {
+		v.ServerDeviceID = current.UpdatedByDevice.String()
+	}
+
+	if req.Revision != current.Revision {
+		v.WouldConflict = true
+		v.Code, v.Error = PushCodeConflict, "revision mismatch"
+		return v, blob, current, nil
+	}
+
+	v.Valid = true
+	v.NextRevision = current.Revision + 1
+	return v, blob, current, nil
+}
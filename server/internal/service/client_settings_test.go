@@ -0,0 +1,148 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+type memClientSettings struct {
+	settings map[uuid.UUID]*models.ClientSettings
+	// racer, if set, runs before a write to simulate a concurrent device
+	racer func()
+}
+
+func (m *memClientSettings) GetByUserID(_ context.Context, userID uuid.UUID) (*models.ClientSettings, error) {
+	s, ok := m.settings[userID]
+	if !ok {
+		return nil, repository.ErrClientSettingsNotFound
+	}
+	cp := *s
+	return &cp, nil
+}
+
+func (m *memClientSettings) Create(_ context.Context, userID uuid.UUID, blob []byte, deviceID *uuid.UUID) (*models.ClientSettings, error) {
+	m.race()
+	if _, ok := m.settings[userID]; ok {
+		return nil, repository.ErrClientSettingsConflict
+	}
+	s := &models.ClientSettings{ID: uuid.New(), UserID: userID, SettingsBlob: blob, Revision: 1, UpdatedByDevice: deviceID, UpdatedAt: time.Now()}
+	m.settings[userID] = s
+	return s, nil
+}
+
+func (m *memClientSettings) UpdateWithRevisionCheck(_ context.Context, userID uuid.UUID, blob []byte, expected int, deviceID *uuid.UUID) (*models.ClientSettings, error) {
+	m.race()
+	s, ok := m.settings[userID]
+	if !ok || s.Revision != expected {
+		return nil, repository.ErrClientSettingsConflict
+	}
+	s.SettingsBlob, s.Revision, s.UpdatedByDevice, s.UpdatedAt = blob, s.Revision+1, deviceID, time.Now()
+	return s, nil
+}
+
+func (m *memClientSettings) race() {
+	if m.racer != nil {
+		m.racer()
+		m.racer = nil
+	}
+}
+
+func TestClientSettingsRevisionIndependentOfVault(t *testing.T) {
+	ctx := context.Background()
+	userID, deviceID := uuid.New(), uuid.New()
+	blob := base64.StdEncoding.EncodeToString([]byte("prefs"))
+
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+	vaultSync := NewVaultSync(vaults, &memSyncLogs{}, &memDeviceSync{}, fixedCampaign{}, 0)
+	settingsStore := &memClientSettings{settings: map[uuid.UUID]*models.ClientSettings{}}
+	settingsSync := NewClientSettingsSync(settingsStore)
+
+	if _, _, err := vaultSync.Push(ctx, userID, deviceID, &models.VaultPushRequest{VaultBlob: blob}); err != nil {
+		t.Fatalf("vault push: %v", err)
+	}
+
+	for rev := 0; rev < 3; rev++ {
+		verdict, settings, err := settingsSync.Put(ctx, userID, deviceID, &models.SettingsBlobPutRequest{SettingsBlob: blob, Revision: rev})
+		if err != nil {
+			t.Fatalf("put revision %d: %v", rev, err)
+		}
+		if !verdict.Valid || settings.Revision != rev+1 {
+			t.Fatalf("put revision %d: verdict %+v, settings %+v", rev, verdict, settings)
+		}
+	}
+	if vaults.vaults[userID].Revision != 1 || vaults.writes != 1 {
+		t.Errorf("settings puts touched the vault: revision %d, writes %d", vaults.vaults[userID].Revision, vaults.writes)
+	}
+
+	// A vault push at the vault's own revision is unaffected by the settings revision
+	verdict, vault, err := vaultSync.Push(ctx, userID, deviceID, &models.VaultPushRequest{VaultBlob: blob, Revision: 1})
+	if err != nil || !verdict.Valid || vault.Revision != 2 {
+		t.Fatalf("vault push after settings puts: verdict %+v, vault %+v, err %v", verdict, vault, err)
+	}
+	if settingsStore.settings[userID].Revision != 3 {
+		t.Errorf("vault push changed settings revision to %d", settingsStore.settings[userID].Revision)
+	}
+
+	// Stale settings revisions conflict like the vault does
+	verdict, settings, err := settingsSync.Put(ctx, userID, deviceID, &models.SettingsBlobPutRequest{SettingsBlob: blob, Revision: 2})
+	if err != nil {
+		t.Fatalf("stale put: %v", err)
+	}
+	if settings != nil || verdict.Code != PushCodeConflict || verdict.ServerRevision != 3 || verdict.ServerDeviceID != deviceID.String() {
+		t.Errorf("stale put verdict = %+v", verdict)
+	}
+}
+
+func TestClientSettingsSizeCap(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	store := &memClientSettings{settings: map[uuid.UUID]*models.ClientSettings{}}
+	s := NewClientSettingsSync(store)
+
+	atCap := bytes.Repeat([]byte("x"), MaxClientSettingsBytes)
+	verdict, settings, err := s.Put(ctx, userID, uuid.Nil, &models.SettingsBlobPutRequest{SettingsBlob: base64.StdEncoding.EncodeToString(atCap)})
+	if err != nil || !verdict.Valid || settings == nil {
+		t.Fatalf("blob at cap rejected: verdict %+v, err %v", verdict, err)
+	}
+	if settings.UpdatedByDevice != nil {
+		t.Errorf("put without device recorded device %v", settings.UpdatedByDevice)
+	}
+
+	overCap := append(atCap, 'x')
+	verdict, settings, err = s.Put(ctx, userID, uuid.Nil, &models.SettingsBlobPutRequest{SettingsBlob: base64.StdEncoding.EncodeToString(overCap), Revision: 1})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if verdict.Valid || verdict.Code != PushCodeTooLarge || settings != nil {
+		t.Errorf("oversized verdict = %+v, settings %+v", verdict, settings)
+	}
+	if store.settings[userID].Revision != 1 {
+		t.Errorf("oversized put changed revision to %d", store.settings[userID].Revision)
+	}
+}
+
+func TestClientSettingsConcurrentWriteConflicts(t *testing.T) {
+	ctx := context.Background()
+	userID, other := uuid.New(), uuid.New()
+	blob := base64.StdEncoding.EncodeToString([]byte("prefs"))
+	store := &memClientSettings{settings: map[uuid.UUID]*models.ClientSettings{}}
+	store.racer = func() {
+		store.settings[userID] = &models.ClientSettings{UserID: userID, Revision: 1, UpdatedByDevice: &other, UpdatedAt: time.Now()}
+	}
+
+	verdict, settings, err := NewClientSettingsSync(store).Put(ctx, userID, uuid.New(), &models.SettingsBlobPutRequest{SettingsBlob: blob})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if settings != nil || verdict.Valid || verdict.Code != PushCodeConflict || verdict.ServerDeviceID != other.String() {
+		t.Errorf("racing put verdict = %+v", verdict)
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+const (
+	// deviceCleanupInterval is how often inactive devices are pruned
+	deviceCleanupInterval = time.Hour
+	// deviceCleanupBatch caps the devices removed per run
+	deviceCleanupBatch = 500
+)
+
+// ErrDeviceCleanupDisabled is returned by Prune if no inactivity
+// threshold is configured
+var ErrDeviceCleanupDisabled = errors.New("device cleanup is disabled")
+
+// inactiveDeviceStore is the subset of DeviceRepository needed to prune
+// devices
+type inactiveDeviceStore interface {
+	ListInactive(ctx context.Context, cutoff time.Time, allAccounts bool, limit int) ([]models.Device, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// deviceTokenRevoker is the subset of RefreshTokenRepository needed to
+// prune devices
+type deviceTokenRevoker interface {
+	RevokeAllForDevice(ctx context.Context, deviceID uuid.UUID) error
+}
+
+// devicePruningStore is the subset of UserRepository holding the per
+// account opt-in
+type devicePruningStore interface {
+	DevicePruning(ctx context.Context, id uuid.UUID) (bool, error)
+	SetDevicePruning(ctx context.Context, id uuid.UUID, on bool) error
+}
+
+// DeviceCleanup removes devices that have not synced for a while, for
+// accounts that opted in or for all accounts
+type DeviceCleanup struct {
+	devices     inactiveDeviceStore
+	tokens      deviceTokenRevoker
+	users       devicePruningStore
+	after       time.Duration
+	allAccounts bool
+	now         func() time.Time
+}
+
+// NewDeviceCleanup creates the device cleanup. Devices unused for after
+// are removed, 0 disables it. Unless allAccounts is set only accounts
+// that opted in are pruned.
+func NewDeviceCleanup(devices inactiveDeviceStore, tokens deviceTokenRevoker, users devicePruningStore, after time.Duration, allAccounts bool) *DeviceCleanup {
+	return &DeviceCleanup{
+		devices:     devices,
+		tokens:      tokens,
+		users:       users,
+		after:       after,
+		allAccounts: allAccounts,
+		now:         time.Now,
+	}
+}
+
+// Policy returns the inactivity threshold, 0 if disabled, and whether it
+// applies to all accounts
+func (d *DeviceCleanup) Policy() (after time.Duration, allAccounts bool) {
+	return d.after, d.allAccounts
+}
+
+// OptedIn reports whether the user opted in to the cleanup
+func (d *DeviceCleanup) OptedIn(ctx context.Context, userID uuid.UUID) (bool, error) {
+	return d.users.DevicePruning(ctx, userID)
+}
+
+// SetOptIn opts the user in to or out of the cleanup
+func (d *DeviceCleanup) SetOptIn(ctx context.Context, userID uuid.UUID, on bool) error {
+	return d.users.SetDevicePruning(ctx, userID, on)
+}
+
+// Prune removes up to deviceCleanupBatch inactive devices and returns how
+// many were removed. Their refresh tokens are revoked first, so a device
+// that is deleted halfway cannot keep a session.
+func (d *DeviceCleanup) Prune(ctx context.Context) (int, error) {
+	if d.after <= 0 {
+		return 0, ErrDeviceCleanupDisabled
+	}
+	devices, err := d.devices.ListInactive(ctx, d.now().Add(-d.after), d.allAccounts, deviceCleanupBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, device := range devices {
+		if err := d.tokens.RevokeAllForDevice(ctx, device.ID); err != nil {
+			return pruned, err
+		}
+		err := d.devices.Delete(ctx, device.ID)
+		switch {
+		case err == nil:
+			pruned++
+		case errors.Is(err, repository.ErrDeviceNotFound):
+			// Removed by its user meanwhile
+		default:
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// Run prunes inactive devices every hour until ctx is cancelled
+func (d *DeviceCleanup) Run(ctx context.Context) {
+	if d.after <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(deviceCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := d.Prune(ctx)
+		if err != nil {
+			log.Error().Err(err).Int("pruned", n).Msg("Failed to prune inactive devices")
+		} else {
+			log.Info().Int("pruned", n).Dur("inactive_after", d.after).Msg("Pruned inactive devices")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
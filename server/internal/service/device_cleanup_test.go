@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memInactiveDevices filters like DeviceRepository.ListInactive
+type memInactiveDevices struct {
+	devices map[uuid.UUID]models.Device
+	optIn   map[uuid.UUID]bool // by user
+	revoked []uuid.UUID
+}
+
+func (m *memInactiveDevices) ListInactive(_ context.Context, cutoff time.Time, allAccounts bool, limit int) ([]models.Device, error) {
+	var out []models.Device
+	for _, d := range m.devices {
+		last := d.CreatedAt
+		if d.LastSyncAt != nil {
+			last = *d.LastSyncAt
+		}
+		if last.Before(cutoff) && (allAccounts || m.optIn[d.UserID]) && len(out) < limit {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (m *memInactiveDevices) Delete(_ context.Context, id uuid.UUID) error {
+	if _, ok := m.devices[id]; !ok {
+		return repository.ErrDeviceNotFound
+	}
+	delete(m.devices, id)
+	return nil
+}
+
+func (m *memInactiveDevices) RevokeAllForDevice(_ context.Context, id uuid.UUID) error {
+	m.revoked = append(m.revoked, id)
+	return nil
+}
+
+func (m *memInactiveDevices) DevicePruning(_ context.Context, id uuid.UUID) (bool, error) {
+	return m.optIn[id], nil
+}
+
+func (m *memInactiveDevices) SetDevicePruning(_ context.Context, id uuid.UUID, on bool) error {
+	m.optIn[id] = on
+	return nil
+}
+
+func TestDeviceCleanup(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	old, recent := now.Add(-200*24*time.Hour), now.Add(-10*24*time.Hour)
+	alice, bob := uuid.New(), uuid.New()
+	stale, synced, fresh, bobs := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	m := &memInactiveDevices{
+		devices: map[uuid.UUID]models.Device{
+			stale:  {ID: stale, UserID: alice, CreatedAt: old},
+			synced: {ID: synced, UserID: alice, CreatedAt: old, LastSyncAt: &recent},
+			fresh:  {ID: fresh, UserID: alice, CreatedAt: recent},
+			bobs:   {ID: bobs, UserID: bob, CreatedAt: old},
+		},
+		optIn: map[uuid.UUID]bool{},
+	}
+	cleanup := NewDeviceCleanup(m, m, m, 180*24*time.Hour, false)
+	cleanup.now = func() time.Time { return now }
+
+	if n, err := cleanup.Prune(ctx); err != nil || n != 0 {
+		t.Fatalf("without opt-in: pruned %d, %v", n, err)
+	}
+
+	if err := cleanup.SetOptIn(ctx, alice, true); err != nil {
+		t.Fatal(err)
+	}
+	if on, _ := cleanup.OptedIn(ctx, alice); !on {
+		t.Fatal("opt-in not stored")
+	}
+	n, err := cleanup.Prune(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("pruned %d, %v; want the stale device of the opted-in account", n, err)
+	}
+	if _, ok := m.devices[stale]; ok || len(m.revoked) != 1 || m.revoked[0] != stale {
+		t.Errorf("stale device left or tokens not revoked: revoked %v", m.revoked)
+	}
+
+	cleanup.allAccounts = true
+	if n, _ := cleanup.Prune(ctx); n != 1 || len(m.devices) != 2 {
+		t.Errorf("all accounts: pruned %d, %d devices left, want bob's removed", n, len(m.devices))
+	}
+}
+
+func TestDeviceCleanupDisabled(t *testing.T) {
+	m := &memInactiveDevices{devices: map[uuid.UUID]models.Device{}}
+	cleanup := NewDeviceCleanup(m, m, m, 0, true)
+	if _, err := cleanup.Prune(context.Background()); !errors.Is(err, ErrDeviceCleanupDisabled) {
+		t.Errorf("error = %v, want ErrDeviceCleanupDisabled", err)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// trustTokenBytes is the randomness of a trust token
+const trustTokenBytes = 32
+
+// trustedDeviceStore is the subset of TrustedDeviceRepository needed for device trust
+type trustedDeviceStore interface {
+	Create(ctx context.Context, trusted *models.TrustedDevice) error
+	Use(ctx context.Context, tokenHash string) (*models.TrustedDevice, error)
+	GetActiveByUser(ctx context.Context, userID uuid.UUID) ([]models.TrustedDevice, error)
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	DeleteAllForUser(ctx context.Context, userID uuid.UUID) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// DeviceTrust remembers devices and browsers that passed TOTP so later
+// logins from them can skip the second factor. Only the hash of a trust
+// token is stored.
+type DeviceTrust struct {
+	store    trustedDeviceStore
+	lifetime time.Duration
+	now      func() time.Time
+}
+
+// NewDeviceTrust creates the device trust service. Trust expires after
+// lifetime; 0 disables it.
+func NewDeviceTrust(store trustedDeviceStore, lifetime time.Duration) *DeviceTrust {
+	return &DeviceTrust{store: store, lifetime: lifetime, now: time.Now}
+}
+
+// Enabled reports whether devices can be trusted at all
+func (s *DeviceTrust) Enabled() bool {
+	return s != nil && s.lifetime > 0
+}
+
+// Lifetime returns how long trust lasts
+func (s *DeviceTrust) Lifetime() time.Duration {
+	return s.lifetime
+}
+
+// TrustDevice issues a trust token for an app device
+func (s *DeviceTrust) TrustDevice(ctx context.Context, userID, deviceID uuid.UUID, name string) (string, *models.TrustedDevice, error) {
+	return s.trust(ctx, userID, &deviceID, name)
+}
+
+// TrustBrowser issues a trust token for a web browser
+func (s *DeviceTrust) TrustBrowser(ctx context.Context, userID uuid.UUID, name string) (string, *models.TrustedDevice, error) {
+	return s.trust(ctx, userID, nil, name)
+}
+
+func (s *DeviceTrust) trust(ctx context.Context, userID uuid.UUID, deviceID *uuid.UUID, name string) (string, *models.TrustedDevice, error) {
+	b := make([]byte, trustTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := s.now()
+	trusted := &models.TrustedDevice{
+		ID:        uuid.New(),
+		UserID:    userID,
+		DeviceID:  deviceID,
+		Name:      name,
+		TokenHash: hashTrustToken(token),
+		ExpiresAt: now.Add(s.lifetime),
+		CreatedAt: now,
+	}
+	if err := s.store.Create(ctx, trusted); err != nil {
+		return "", nil, err
+	}
+
+	// Opportunistic cleanup; expired entries are never accepted anyway
+	if _, err := s.store.DeleteExpired(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to delete expired trusted devices")
+	}
+	return token, trusted, nil
+}
+
+// VerifyDevice reports whether token trusts the user's app device named
+// deviceName
+func (s *DeviceTrust) VerifyDevice(ctx context.Context, userID uuid.UUID, token, deviceName string) (bool, error) {
+	trusted, err := s.verify(ctx, userID, token)
+	if trusted == nil || err != nil {
+		return false, err
+	}
+	return trusted.DeviceID != nil && trusted.Name == deviceName, nil
+}
+
+// VerifyBrowser reports whether token trusts a web browser of the user
+func (s *DeviceTrust) VerifyBrowser(ctx context.Context, userID uuid.UUID, token string) (bool, error) {
+	trusted, err := s.verify(ctx, userID, token)
+	if trusted == nil || err != nil {
+		return false, err
+	}
+	return trusted.DeviceID == nil, nil
+}
+
+func (s *DeviceTrust) verify(ctx context.Context, userID uuid.UUID, token string) (*models.TrustedDevice, error) {
+	if !s.Enabled() || token == "" {
+		return nil, nil
+	}
+	trusted, err := s.store.Use(ctx, hashTrustToken(token))
+	if errors.Is(err, repository.ErrTrustedDeviceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if trusted.UserID != userID {
+		return nil, nil
+	}
+	return trusted, nil
+}
+
+// List returns the user's trusted devices and browsers
+func (s *DeviceTrust) List(ctx context.Context, userID uuid.UUID) ([]models.TrustedDevice, error) {
+	return s.store.GetActiveByUser(ctx, userID)
+}
+
+// Revoke ends the trust of one device or browser
+func (s *DeviceTrust) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	return s.store.Delete(ctx, userID, id)
+}
+
+// RevokeAll ends the trust of all of the user's devices and browsers, e.g.
+// after the password or the second factor changed
+func (s *DeviceTrust) RevokeAll(ctx context.Context, userID uuid.UUID) error {
+	return s.store.DeleteAllForUser(ctx, userID)
+}
+
+func hashTrustToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
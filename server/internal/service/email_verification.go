@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+var (
+	ErrVerificationTokenInvalid = errors.New("invalid verification token")
+	ErrVerificationTokenExpired = errors.New("verification token expired")
+)
+
+const (
+	// verificationTokenBytes is the entropy of verification tokens
+	verificationTokenBytes = 32
+	// verificationResendCooldown is how long after a link was sent a
+	// resend request for the same account is ignored
+	verificationResendCooldown = time.Minute
+	// VerifyEmailPath is the endpoint verification links point to
+	VerifyEmailPath = "/api/v1/auth/verify-email"
+)
+
+// verificationTokenStore is the subset of EmailVerificationRepository needed for verification
+type verificationTokenStore interface {
+	Replace(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error
+	GetByTokenHash(ctx context.Context, tokenHash string) (*models.EmailVerificationToken, error)
+	LastIssuedAt(ctx context.Context, userID uuid.UUID) (*time.Time, error)
+	DeleteForUser(ctx context.Context, userID uuid.UUID) error
+}
+
+// verificationUserStore is the subset of UserRepository needed for verification
+type verificationUserStore interface {
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	MarkEmailVerified(ctx context.Context, id uuid.UUID) error
+}
+
+// EmailVerification sends verification links to newly registered users
+// and confirms them
+type EmailVerification struct {
+	tokens   verificationTokenStore
+	users    verificationUserStore
+	notifier notify.Notifier
+	baseURL  string
+	lifetime time.Duration
+	now      func() time.Time
+}
+
+// NewEmailVerification creates a new email verification service. Links
+// point to baseURL and stay valid for lifetime.
+func NewEmailVerification(tokens verificationTokenStore, users verificationUserStore, notifier notify.Notifier, baseURL string, lifetime time.Duration) *EmailVerification {
+	return &EmailVerification{
+		tokens:   tokens,
+		users:    users,
+		notifier: notifier,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		lifetime: lifetime,
+		now:      time.Now,
+	}
+}
+
+// Send issues a new verification link to user, invalidating earlier links
+func (s *EmailVerification) Send(ctx context.Context, user *models.User) error {
+	b := make([]byte, verificationTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	if err := s.tokens.Replace(ctx, user.ID, hashVerificationToken(token), s.now().Add(s.lifetime)); err != nil {
+		return err
+	}
+
+	link := s.baseURL + VerifyEmailPath + "?token=" + url.QueryEscape(token)
+	return s.notifier.Notify(ctx, notify.Notification{
+		Kind:    notify.KindEmailVerification,
+		UserID:  user.ID,
+		Email:   user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Open this link to confirm your email address:\n\n%s\n\n"+
+				"The link is valid for %s. After confirming, an administrator will review your registration.",
+			link, s.lifetime),
+	})
+}
+
+// Verify marks the owner of token as verified and returns their ID.
+// Tokens are single use.
+func (s *EmailVerification) Verify(ctx context.Context, token string) (uuid.UUID, error) {
+	stored, err := s.tokens.GetByTokenHash(ctx, hashVerificationToken(token))
+	if errors.Is(err, repository.ErrVerificationTokenNotFound) {
+		return uuid.Nil, ErrVerificationTokenInvalid
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if s.now().After(stored.ExpiresAt) {
+		return uuid.Nil, ErrVerificationTokenExpired
+	}
+
+	if err := s.users.MarkEmailVerified(ctx, stored.UserID); err != nil {
+		return uuid.Nil, err
+	}
+	if err := s.tokens.DeleteForUser(ctx, stored.UserID); err != nil {
+		log.Error().Err(err).Str("user_id", stored.UserID.String()).Msg("Failed to delete used verification tokens")
+	}
+	return stored.UserID, nil
+}
+
+// Resend sends a new link to the unverified account with the given email.
+// Unknown or already verified addresses and requests within the resend
+// cooldown are silently ignored, so the response does not reveal which
+// accounts exist.
+func (s *EmailVerification) Resend(ctx context.Context, email string) error {
+	user, err := s.users.GetByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	issued, err := s.tokens.LastIssuedAt(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if issued != nil && s.now().Sub(*issued) < verificationResendCooldown {
+		return nil
+	}
+	return s.Send(ctx, user)
+}
+
+// hashVerificationToken hashes a verification token for storage
+func hashVerificationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
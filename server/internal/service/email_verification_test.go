@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+func (m *memUserStore) MarkEmailVerified(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.ID == id {
+			u.EmailVerified = true
+			return nil
+		}
+	}
+	return repository.ErrUserNotFound
+}
+
+// memVerificationTokens keeps one token per user like EmailVerificationRepository
+type memVerificationTokens struct {
+	byUser map[uuid.UUID]models.EmailVerificationToken
+	now    func() time.Time
+}
+
+func (m *memVerificationTokens) Replace(_ context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	m.byUser[userID] = models.EmailVerificationToken{ID: uuid.New(), UserID: userID, TokenHash: tokenHash, ExpiresAt: expiresAt, CreatedAt: m.now()}
+	return nil
+}
+
+func (m *memVerificationTokens) GetByTokenHash(_ context.Context, tokenHash string) (*models.EmailVerificationToken, error) {
+	for _, t := range m.byUser {
+		if t.TokenHash == tokenHash {
+			return &t, nil
+		}
+	}
+	return nil, repository.ErrVerificationTokenNotFound
+}
+
+func (m *memVerificationTokens) LastIssuedAt(_ context.Context, userID uuid.UUID) (*time.Time, error) {
+	t, ok := m.byUser[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &t.CreatedAt, nil
+}
+
+func (m *memVerificationTokens) DeleteForUser(_ context.Context, userID uuid.UUID) error {
+	delete(m.byUser, userID)
+	return nil
+}
+
+var verificationLink = regexp.MustCompile(`https://vt\.example\.com/api/v1/auth/verify-email\?token=(\S+)`)
+
+// linkToken extracts the token from the last verification link sent
+func linkToken(t *testing.T, n *recordingNotifier) string {
+	t.Helper()
+	if len(n.sent) == 0 {
+		t.Fatal("no verification email sent")
+	}
+	m := verificationLink.FindStringSubmatch(n.sent[len(n.sent)-1].Body)
+	if m == nil {
+		t.Fatalf("no verification link in %q", n.sent[len(n.sent)-1].Body)
+	}
+	token, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newTestVerification() (*EmailVerification, *memUserStore, *memVerificationTokens, *recordingNotifier, *time.Time) {
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	users := newMemUserStore()
+	tokens := &memVerificationTokens{byUser: make(map[uuid.UUID]models.EmailVerificationToken), now: clock}
+	notifier := &recordingNotifier{}
+	v := NewEmailVerification(tokens, users, notifier, "https://vt.example.com/", 48*time.Hour)
+	v.now = clock
+	return v, users, tokens, notifier, &now
+}
+
+func TestEmailVerification_SendAndVerify(t *testing.T) {
+	v, users, tokens, notifier, _ := newTestVerification()
+	ctx := context.Background()
+	user, _ := users.Create(ctx, "new@example.com", "hash")
+
+	if err := v.Send(ctx, user); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	token := linkToken(t, notifier)
+	if stored := tokens.byUser[user.ID]; stored.TokenHash == token || stored.TokenHash != hashVerificationToken(token) {
+		t.Error("the token must be stored hashed")
+	}
+	if notifier.sent[0].Email != "new@example.com" {
+		t.Errorf("link sent to %q", notifier.sent[0].Email)
+	}
+
+	id, err := v.Verify(ctx, token)
+	if err != nil || id != user.ID {
+		t.Fatalf("Verify = %v, %v; want %v", id, err, user.ID)
+	}
+	if !users.users["new@example.com"].EmailVerified {
+		t.Error("user not marked verified")
+	}
+	if _, err := v.Verify(ctx, token); !errors.Is(err, ErrVerificationTokenInvalid) {
+		t.Errorf("second Verify = %v, want ErrVerificationTokenInvalid", err)
+	}
+}
+
+func TestEmailVerification_Expired(t *testing.T) {
+	v, users, _, notifier, now := newTestVerification()
+	ctx := context.Background()
+	user, _ := users.Create(ctx, "slow@example.com", "hash")
+	_ = v.Send(ctx, user)
+
+	*now = now.Add(49 * time.Hour)
+	if _, err := v.Verify(ctx, linkToken(t, notifier)); !errors.Is(err, ErrVerificationTokenExpired) {
+		t.Errorf("Verify = %v, want ErrVerificationTokenExpired", err)
+	}
+	if users.users["slow@example.com"].EmailVerified {
+		t.Error("expired link verified the user")
+	}
+}
+
+func TestEmailVerification_Resend(t *testing.T) {
+	v, users, _, notifier, now := newTestVerification()
+	ctx := context.Background()
+	user, _ := users.Create(ctx, "again@example.com", "hash")
+	_ = v.Send(ctx, user)
+	first := linkToken(t, notifier)
+
+	// Unknown addresses are ignored without an error
+	if err := v.Resend(ctx, "nobody@example.com"); err != nil || len(notifier.sent) != 1 {
+		t.Fatalf("Resend unknown = %v, sent %d; want nil and no email", err, len(notifier.sent))
+	}
+
+	// Within the cooldown nothing is sent
+	*now = now.Add(verificationResendCooldown / 2)
+	if err := v.Resend(ctx, "again@example.com"); err != nil || len(notifier.sent) != 1 {
+		t.Fatalf("Resend during cooldown = %v, sent %d; want nil and no email", err, len(notifier.sent))
+	}
+
+	// Afterwards a new link replaces the old one
+	*now = now.Add(verificationResendCooldown)
+	if err := v.Resend(ctx, "again@example.com"); err != nil || len(notifier.sent) != 2 {
+		t.Fatalf("Resend after cooldown = %v, sent %d; want a second email", err, len(notifier.sent))
+	}
+	if _, err := v.Verify(ctx, first); !errors.Is(err, ErrVerificationTokenInvalid) {
+		t.Errorf("old link: Verify = %v, want ErrVerificationTokenInvalid", err)
+	}
+	if _, err := v.Verify(ctx, linkToken(t, notifier)); err != nil {
+		t.Fatalf("new link: %v", err)
+	}
+
+	// Verified accounts get no more links
+	*now = now.Add(2 * verificationResendCooldown)
+	if err := v.Resend(ctx, "again@example.com"); err != nil || len(notifier.sent) != 2 {
+		t.Errorf("Resend verified = %v, sent %d; want nil and no email", err, len(notifier.sent))
+	}
+}
+
+type recordingSender struct{ users []string }
+
+func (r *recordingSender) Send(_ context.Context, user *models.User) error {
+	r.users = append(r.users, user.Email)
+	return nil
+}
+
+func TestRegister_SendsVerificationOnce(t *testing.T) {
+	sender := &recordingSender{}
+	s := &Registration{users: newMemUserStore(), verification: sender, now: time.Now}
+
+	// A retried submission resolves to the same account and sends no second link
+	for range 2 {
+		if _, err := s.Register(context.Background(), "verify@example.com", "password123", "", models.RegistrationProfile{}); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+	if len(sender.users) != 1 || sender.users[0] != "verify@example.com" {
+		t.Errorf("verification sent to %v, want exactly once", sender.users)
+	}
+}
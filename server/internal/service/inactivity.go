@@ -0,0 +1,332 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+// inactivityCheckInterval is how often the inactivity cleanup runs
+const inactivityCheckInterval = 24 * time.Hour
+
+// Actions taken on accounts that stay inactive after the warning
+const (
+	InactivityActionBlock  = "block"
+	InactivityActionDelete = "delete" // soft delete; the data is kept
+)
+
+// Settings keys of the inactivity policy
+const (
+	settingInactivityWarnAfter     = "inactivity.warn_after_months"
+	settingInactivityActAfter      = "inactivity.act_after_months"
+	settingInactivityAction        = "inactivity.action"
+	settingInactivityIncludeVaults = "inactivity.include_vaults"
+	settingInactivityMaxPerRun     = "inactivity.max_per_run"
+	settingInactivityDryRun        = "inactivity.dry_run"
+)
+
+// ErrInvalidInactivityPolicy is returned when saving an unusable policy
+var ErrInvalidInactivityPolicy = errors.New("invalid inactivity policy")
+
+// InactivityPolicy decides when inactive accounts are warned and acted on
+type InactivityPolicy struct {
+	WarnAfterMonths int    // months without login before the warning; 0 disables the cleanup
+	ActAfterMonths  int    // months after the warning before Action is taken
+	Action          string // InactivityActionBlock or InactivityActionDelete
+	IncludeVaults   bool   // also act on accounts that store a vault
+	MaxPerRun       int    // accounts warned or acted on per run
+	DryRun          bool   // only report what would be done
+}
+
+// Validate checks that p is usable
+func (p InactivityPolicy) Validate() error {
+	if p.WarnAfterMonths < 0 || p.ActAfterMonths < 1 || p.MaxPerRun < 1 {
+		return fmt.Errorf("%w: months must not be negative, the grace period and run limit at least 1", ErrInvalidInactivityPolicy)
+	}
+	if p.Action != InactivityActionBlock && p.Action != InactivityActionDelete {
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidInactivityPolicy, p.Action)
+	}
+	return nil
+}
+
+// InactivityReport lists what a cleanup run did, or would do in a dry run
+type InactivityReport struct {
+	RanAt         time.Time
+	DryRun        bool
+	Action        string
+	Warned        []string // emails
+	Acted         []string // emails
+	SkippedVaults int      // inactive accounts kept because they store a vault
+	Limited       bool     // MaxPerRun was reached
+}
+
+// inactivityUserStore is the subset of UserRepository needed for the cleanup
+type inactivityUserStore interface {
+	ListInactive(ctx context.Context, cutoff time.Time) ([]models.InactiveUser, error)
+	MarkInactivityWarned(ctx context.Context, id uuid.UUID) error
+	SetBlocked(ctx context.Context, id uuid.UUID, blocked bool, reason string) error
+	SoftDelete(ctx context.Context, id uuid.UUID, reason string) error
+}
+
+// policySettingStore is the subset of SettingRepository holding the policy
+type policySettingStore interface {
+	All(ctx context.Context) (map[string]string, error)
+	Set(ctx context.Context, key, value string) error
+}
+
+// InactivityCleanup warns accounts that have not logged in for a while and
+// blocks or soft-deletes them if they stay inactive. Admins are never
+// touched; accounts with a vault only if the policy includes them.
+type InactivityCleanup struct {
+	users    inactivityUserStore
+	settings policySettingStore
+	tokens   adminTokenStore
+	audit    adminAuditStore
+	notifier notify.Notifier
+	defaults InactivityPolicy
+	now      func() time.Time
+
+	mu         sync.Mutex
+	lastReport *InactivityReport
+}
+
+// NewInactivityCleanup creates the cleanup job. defaults apply until an
+// admin saves a policy.
+func NewInactivityCleanup(users inactivityUserStore, settings policySettingStore, tokens adminTokenStore, audit adminAuditStore, notifier notify.Notifier, defaults InactivityPolicy) *InactivityCleanup {
+	return &InactivityCleanup{
+		users:    users,
+		settings: settings,
+		tokens:   tokens,
+		audit:    audit,
+		notifier: notifier,
+		defaults: defaults,
+		now:      time.Now,
+	}
+}
+
+// Policy returns the saved policy, falling back to the defaults for
+// settings that were never saved or cannot be parsed
+func (s *InactivityCleanup) Policy(ctx context.Context) (InactivityPolicy, error) {
+	p := s.defaults
+	saved, err := s.settings.All(ctx)
+	if err != nil {
+		return p, err
+	}
+
+	ints := map[string]*int{
+		settingInactivityWarnAfter: &p.WarnAfterMonths,
+		settingInactivityActAfter:  &p.ActAfterMonths,
+		settingInactivityMaxPerRun: &p.MaxPerRun,
+	}
+	for key, field := range ints {
+		if v, ok := saved[key]; ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				*field = n
+			}
+		}
+	}
+	bools := map[string]*bool{
+		settingInactivityIncludeVaults: &p.IncludeVaults,
+		settingInactivityDryRun:        &p.DryRun,
+	}
+	for key, field := range bools {
+		if v, ok := saved[key]; ok {
+			if b, err := strconv.ParseBool(v); err == nil {
+				*field = b
+			}
+		}
+	}
+	if v, ok := saved[settingInactivityAction]; ok {
+		p.Action = v
+	}
+
+	if err := p.Validate(); err != nil {
+		log.Warn().Err(err).Msg("Saved inactivity policy is invalid, using defaults")
+		return s.defaults, nil
+	}
+	return p, nil
+}
+
+// SavePolicy validates and stores p for the following runs
+func (s *InactivityCleanup) SavePolicy(ctx context.Context, p InactivityPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	values := map[string]string{
+		settingInactivityWarnAfter:     strconv.Itoa(p.WarnAfterMonths),
+		settingInactivityActAfter:      strconv.Itoa(p.ActAfterMonths),
+		settingInactivityAction:        p.Action,
+		settingInactivityIncludeVaults: strconv.FormatBool(p.IncludeVaults),
+		settingInactivityMaxPerRun:     strconv.Itoa(p.MaxPerRun),
+		settingInactivityDryRun:        strconv.FormatBool(p.DryRun),
+	}
+	for key, value := range values {
+		if err := s.settings.Set(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastReport returns the report of the most recent run, or nil
+func (s *InactivityCleanup) LastReport() *InactivityReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastReport
+}
+
+// Run performs the cleanup daily until ctx is cancelled. The policy is
+// read on every run, so changes apply without a restart.
+func (s *InactivityCleanup) Run(ctx context.Context) {
+	ticker := time.NewTicker(inactivityCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if report, err := s.RunOnce(ctx); err != nil {
+			log.Error().Err(err).Msg("Inactivity cleanup failed")
+		} else if report != nil && len(report.Warned)+len(report.Acted) > 0 {
+			log.Info().
+				Bool("dry_run", report.DryRun).
+				Int("warned", len(report.Warned)).
+				Int("acted", len(report.Acted)).
+				Str("action", report.Action).
+				Bool("limited", report.Limited).
+				Msg("Inactivity cleanup")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce warns accounts inactive for WarnAfterMonths and acts on those
+// still inactive ActAfterMonths after their warning. A login after the
+// warning resets the account. It returns nil, nil if the cleanup is
+// disabled.
+func (s *InactivityCleanup) RunOnce(ctx context.Context) (*InactivityReport, error) {
+	policy, err := s.Policy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy.WarnAfterMonths <= 0 {
+		return nil, nil
+	}
+
+	now := s.now()
+	report := &InactivityReport{RanAt: now, DryRun: policy.DryRun, Action: policy.Action}
+	defer func() {
+		s.mu.Lock()
+		s.lastReport = report
+		s.mu.Unlock()
+	}()
+
+	inactive, err := s.users.ListInactive(ctx, now.AddDate(0, -policy.WarnAfterMonths, 0))
+	if err != nil {
+		return report, err
+	}
+	for _, u := range inactive {
+		if u.HasVault && !policy.IncludeVaults {
+			report.SkippedVaults++
+			continue
+		}
+		warned := u.WarnedAt != nil && u.WarnedAt.After(u.LastActiveAt)
+		if warned && now.Before(u.WarnedAt.AddDate(0, policy.ActAfterMonths, 0)) {
+			continue
+		}
+		if len(report.Warned)+len(report.Acted) >= policy.MaxPerRun {
+			report.Limited = true
+			break
+		}
+
+		if !warned {
+			report.Warned = append(report.Warned, u.Email)
+			if !policy.DryRun {
+				if err := s.warn(ctx, u, policy, now); err != nil {
+					return report, err
+				}
+			}
+			continue
+		}
+		report.Acted = append(report.Acted, u.Email)
+		if !policy.DryRun {
+			if err := s.act(ctx, u, policy); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// warn notifies an inactive user and records the warning
+func (s *InactivityCleanup) warn(ctx context.Context, u models.InactiveUser, policy InactivityPolicy, now time.Time) error {
+	if err := s.users.MarkInactivityWarned(ctx, u.ID); err != nil {
+		return err
+	}
+	outcome := "blocked"
+	if policy.Action == InactivityActionDelete {
+		outcome = "deleted"
+	}
+	err := s.notifier.Notify(ctx, notify.Notification{
+		Kind:    notify.KindInactivityWarning,
+		UserID:  u.ID,
+		Email:   u.Email,
+		Subject: "Your VibedTerm account is inactive",
+		Body: fmt.Sprintf(
+			"You have not logged in since %s. Unless you log in by %s, your account will be %s.",
+			u.LastActiveAt.Format("2006-01-02"), now.AddDate(0, policy.ActAfterMonths, 0).Format("2006-01-02"), outcome,
+		),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("user_id", u.ID.String()).Msg("Failed to send inactivity warning")
+	}
+	s.record(ctx, models.AuditUserWarned, u, map[string]string{"last_active": u.LastActiveAt.Format(time.RFC3339)})
+	return nil
+}
+
+// act blocks or soft-deletes a user who stayed inactive after the warning
+func (s *InactivityCleanup) act(ctx context.Context, u models.InactiveUser, policy InactivityPolicy) error {
+	reason := "Inactive since " + u.LastActiveAt.Format("2006-01-02")
+	action := models.AuditUserBlocked
+	var err error
+	if policy.Action == InactivityActionDelete {
+		action = models.AuditUserDeleted
+		err = s.users.SoftDelete(ctx, u.ID, reason)
+	} else {
+		err = s.users.SetBlocked(ctx, u.ID, true, reason)
+	}
+	if err != nil {
+		return err
+	}
+	_ = s.tokens.RevokeAllForUser(ctx, u.ID)
+
+	details := map[string]string{"reason": reason, "policy": "inactivity"}
+	if policy.Action == InactivityActionDelete {
+		details["mode"] = "soft"
+	}
+	s.record(ctx, action, u, details)
+	return nil
+}
+
+// record writes an audit event without an actor. Failures are logged but
+// do not undo the action.
+func (s *InactivityCleanup) record(ctx context.Context, action string, u models.InactiveUser, extra map[string]string) {
+	details := map[string]string{"email": u.Email}
+	for k, v := range extra {
+		details[k] = v
+	}
+	event := &models.AuditEvent{Action: action, TargetID: &u.ID, Details: details}
+	if err := s.audit.Create(ctx, event); err != nil {
+		log.Error().Err(err).Str("action", action).Str("user_id", u.ID.String()).Msg("Failed to record audit event")
+	}
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+// fakeInactivityUsers emulates ListInactive on top of fakeAdminStores
+type fakeInactivityUsers struct {
+	f       *fakeAdminStores
+	now     *time.Time
+	warned  map[uuid.UUID]time.Time
+	deleted map[uuid.UUID]bool
+}
+
+func (s fakeInactivityUsers) ListInactive(_ context.Context, cutoff time.Time) ([]models.InactiveUser, error) {
+	var out []models.InactiveUser
+	for _, u := range s.f.users {
+		if !u.IsApproved || u.IsBlocked || u.IsAdmin {
+			continue
+		}
+		last := u.CreatedAt
+		if u.LastLoginAt != nil {
+			last = *u.LastLoginAt
+		}
+		if !last.Before(cutoff) {
+			continue
+		}
+		iu := models.InactiveUser{ID: u.ID, Email: u.Email, LastActiveAt: last, HasVault: s.f.vaults[u.ID] != nil}
+		if w, ok := s.warned[u.ID]; ok {
+			iu.WarnedAt = &w
+		}
+		out = append(out, iu)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastActiveAt.Before(out[j].LastActiveAt) })
+	return out, nil
+}
+
+func (s fakeInactivityUsers) MarkInactivityWarned(_ context.Context, id uuid.UUID) error {
+	s.warned[id] = *s.now
+	return nil
+}
+
+func (s fakeInactivityUsers) SetBlocked(_ context.Context, id uuid.UUID, blocked bool, reason string) error {
+	s.f.users[id].IsBlocked, s.f.users[id].BlockedReason = blocked, reason
+	return nil
+}
+
+func (s fakeInactivityUsers) SoftDelete(ctx context.Context, id uuid.UUID, reason string) error {
+	s.deleted[id] = true
+	return s.SetBlocked(ctx, id, true, reason)
+}
+
+type memSettings map[string]string
+
+func (m memSettings) All(context.Context) (map[string]string, error) { return m, nil }
+
+func (m memSettings) Set(_ context.Context, key, value string) error {
+	m[key] = value
+	return nil
+}
+
+type inactivityFixture struct {
+	cleanup  *InactivityCleanup
+	stores   *fakeAdminStores
+	users    fakeInactivityUsers
+	notifier *recordingNotifier
+	now      *time.Time
+}
+
+func newInactivityFixture(policy InactivityPolicy) *inactivityFixture {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := newFakeAdminStores()
+	users := fakeInactivityUsers{f: f, now: &now, warned: map[uuid.UUID]time.Time{}, deleted: map[uuid.UUID]bool{}}
+	notifier := &recordingNotifier{}
+	c := NewInactivityCleanup(users, memSettings{}, fakeTokens{f}, fakeAudit{f}, notifier, policy)
+	c.now = func() time.Time { return now }
+	return &inactivityFixture{cleanup: c, stores: f, users: users, notifier: notifier, now: &now}
+}
+
+// addUser adds an approved user who last logged in at lastLogin
+func (x *inactivityFixture) addUser(email string, lastLogin time.Time) *models.User {
+	u := &models.User{ID: uuid.New(), Email: email, IsApproved: true, CreatedAt: lastLogin, LastLoginAt: &lastLogin}
+	x.stores.users[u.ID] = u
+	return u
+}
+
+func (x *inactivityFixture) run(t *testing.T) *InactivityReport {
+	t.Helper()
+	report, err := x.cleanup.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	return report
+}
+
+func (x *inactivityFixture) auditActions() []string {
+	var actions []string
+	for _, e := range x.stores.audit {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+func testInactivityPolicy() InactivityPolicy {
+	return InactivityPolicy{WarnAfterMonths: 6, ActAfterMonths: 1, Action: InactivityActionBlock, MaxPerRun: 10}
+}
+
+func TestInactivityCleanup_WarnThenBlock(t *testing.T) {
+	x := newInactivityFixture(testInactivityPolicy())
+	start := *x.now
+	stale := x.addUser("stale@example.com", start)
+	x.addUser("recent@example.com", start.AddDate(0, 3, 0))
+
+	// Five months in: nobody is due
+	*x.now = start.AddDate(0, 5, 0)
+	if r := x.run(t); len(r.Warned)+len(r.Acted) != 0 {
+		t.Fatalf("early run = %+v", r)
+	}
+
+	// Past the warning threshold
+	*x.now = start.AddDate(0, 6, 1)
+	r := x.run(t)
+	if len(r.Warned) != 1 || r.Warned[0] != stale.Email || len(r.Acted) != 0 {
+		t.Fatalf("warning run = %+v", r)
+	}
+	if len(x.notifier.sent) != 1 || x.notifier.sent[0].Kind != notify.KindInactivityWarning || x.notifier.sent[0].UserID != stale.ID {
+		t.Errorf("notifications = %+v", x.notifier.sent)
+	}
+
+	// Within the grace period nothing more happens
+	*x.now = start.AddDate(0, 6, 20)
+	if r := x.run(t); len(r.Warned)+len(r.Acted) != 0 {
+		t.Errorf("grace period run = %+v", r)
+	}
+
+	// A month after the warning the account is blocked
+	*x.now = start.AddDate(0, 7, 2)
+	r = x.run(t)
+	if len(r.Acted) != 1 || r.Acted[0] != stale.Email {
+		t.Fatalf("action run = %+v", r)
+	}
+	if !stale.IsBlocked || !x.stores.revoked[stale.ID] || x.users.deleted[stale.ID] {
+		t.Errorf("stale user blocked=%v revoked=%v deleted=%v", stale.IsBlocked, x.stores.revoked[stale.ID], x.users.deleted[stale.ID])
+	}
+	want := []string{models.AuditUserWarned, models.AuditUserBlocked}
+	if got := x.auditActions(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("audit = %v, want %v", got, want)
+	}
+	if x.stores.audit[1].ActorID != nil || x.stores.audit[1].Details["policy"] != "inactivity" {
+		t.Errorf("block audit event = %+v", x.stores.audit[1])
+	}
+}
+
+func TestInactivityCleanup_LoginAfterWarningResets(t *testing.T) {
+	x := newInactivityFixture(testInactivityPolicy())
+	start := *x.now
+	u := x.addUser("back@example.com", start)
+
+	*x.now = start.AddDate(0, 6, 1)
+	x.run(t)
+
+	// Logs in during the grace period
+	login := start.AddDate(0, 6, 10)
+	u.LastLoginAt = &login
+	*x.now = start.AddDate(0, 7, 2)
+	if r := x.run(t); len(r.Warned)+len(r.Acted) != 0 || u.IsBlocked {
+		t.Errorf("run after login = %+v, blocked = %v", r, u.IsBlocked)
+	}
+
+	// Inactive again: a fresh warning comes before any action
+	*x.now = login.AddDate(0, 6, 1)
+	if r := x.run(t); len(r.Warned) != 1 || len(r.Acted) != 0 {
+		t.Errorf("second inactivity run = %+v", r)
+	}
+}
+
+func TestInactivityCleanup_SkipsAdminsAndVaults(t *testing.T) {
+	policy := testInactivityPolicy()
+	x := newInactivityFixture(policy)
+	start := *x.now
+	admin := x.addUser("admin@example.com", start)
+	admin.IsAdmin = true
+	withVault := x.addUser("vault@example.com", start)
+	x.stores.vaults[withVault.ID] = &models.EncryptedVault{UserID: withVault.ID}
+
+	*x.now = start.AddDate(0, 6, 1)
+	r := x.run(t)
+	if len(r.Warned) != 0 || r.SkippedVaults != 1 {
+		t.Errorf("run = %+v, want only a skipped vault", r)
+	}
+
+	// The stricter flag includes vault owners, never admins
+	policy.IncludeVaults = true
+	if err := x.cleanup.SavePolicy(context.Background(), policy); err != nil {
+		t.Fatal(err)
+	}
+	r = x.run(t)
+	if len(r.Warned) != 1 || r.Warned[0] != withVault.Email {
+		t.Errorf("run with vaults included = %+v", r)
+	}
+}
+
+func TestInactivityCleanup_SoftDelete(t *testing.T) {
+	policy := testInactivityPolicy()
+	policy.Action = InactivityActionDelete
+	x := newInactivityFixture(policy)
+	start := *x.now
+	u := x.addUser("gone@example.com", start)
+
+	*x.now = start.AddDate(0, 6, 1)
+	x.run(t)
+	*x.now = start.AddDate(0, 7, 2)
+	x.run(t)
+
+	if !x.users.deleted[u.ID] || !u.IsBlocked {
+		t.Errorf("deleted=%v blocked=%v, want a soft delete", x.users.deleted[u.ID], u.IsBlocked)
+	}
+	last := x.stores.audit[len(x.stores.audit)-1]
+	if last.Action != models.AuditUserDeleted || last.Details["mode"] != "soft" {
+		t.Errorf("audit event = %+v", last)
+	}
+}
+
+func TestInactivityCleanup_DryRunAndLimit(t *testing.T) {
+	policy := testInactivityPolicy()
+	policy.DryRun = true
+	policy.MaxPerRun = 2
+	x := newInactivityFixture(policy)
+	start := *x.now
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		x.addUser(email, start)
+	}
+
+	*x.now = start.AddDate(0, 6, 1)
+	r := x.run(t)
+	if !r.DryRun || len(r.Warned) != 2 || !r.Limited {
+		t.Errorf("dry run report = %+v", r)
+	}
+	if len(x.notifier.sent) != 0 || len(x.users.warned) != 0 || len(x.stores.audit) != 0 {
+		t.Error("dry run changed state")
+	}
+	if x.cleanup.LastReport() != r {
+		t.Error("last report not kept")
+	}
+}
+
+func TestInactivityCleanup_Policy(t *testing.T) {
+	x := newInactivityFixture(InactivityPolicy{ActAfterMonths: 1, Action: InactivityActionBlock, MaxPerRun: 10})
+	if r := x.run(t); r != nil {
+		t.Errorf("disabled cleanup ran: %+v", r)
+	}
+
+	invalid := testInactivityPolicy()
+	invalid.Action = "purge"
+	if err := x.cleanup.SavePolicy(context.Background(), invalid); !errors.Is(err, ErrInvalidInactivityPolicy) {
+		t.Errorf("SavePolicy(invalid) = %v", err)
+	}
+
+	saved := testInactivityPolicy()
+	saved.DryRun = true
+	if err := x.cleanup.SavePolicy(context.Background(), saved); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := x.cleanup.Policy(context.Background()); got != saved {
+		t.Errorf("Policy = %+v, want %+v", got, saved)
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// inviteCodeBytes is the randomness of an invite code, which renders as
+// 16 base32 characters
+const inviteCodeBytes = 10
+
+// maxListedInvites caps the invites listed to admins
+const maxListedInvites = 200
+
+var (
+	ErrInviteRequired = errors.New("invite code required")
+	ErrInviteInvalid  = errors.New("invite code invalid, used or expired")
+)
+
+// inviteStore is the subset of InviteRepository needed for invites
+type inviteStore interface {
+	Create(ctx context.Context, invite *models.Invite) error
+	List(ctx context.Context, limit int) ([]models.Invite, error)
+	Consume(ctx context.Context, codeHash string) (uuid.UUID, error)
+	SetUsedBy(ctx context.Context, id, userID uuid.UUID) error
+	Release(ctx context.Context, id uuid.UUID) error
+}
+
+// Invites creates single-use registration codes and redeems them for
+// invite-only registration
+type Invites struct {
+	invites inviteStore
+	audit   adminAuditStore
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// NewInvites creates a new invite service. Invites expire after ttl.
+func NewInvites(invites inviteStore, audit adminAuditStore, ttl time.Duration) *Invites {
+	return &Invites{invites: invites, audit: audit, ttl: ttl, now: time.Now}
+}
+
+// Create generates an invite code. The code is returned once; only its
+// hash is stored.
+func (s *Invites) Create(ctx context.Context, actorID uuid.UUID, note string) (string, *models.Invite, error) {
+	b := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	raw := base32.StdEncoding.EncodeToString(b)
+	code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+	now := s.now()
+	invite := &models.Invite{
+		ID:         uuid.New(),
+		CodePrefix: raw[0:4],
+		CodeHash:   hashInviteCode(code),
+		Note:       strings.TrimSpace(note),
+		CreatedBy:  &actorID,
+		ExpiresAt:  now.Add(s.ttl),
+		CreatedAt:  now,
+	}
+	if err := s.invites.Create(ctx, invite); err != nil {
+		return "", nil, err
+	}
+
+	event := &models.AuditEvent{
+		Action:  models.AuditInviteCreated,
+		ActorID: &actorID,
+		Details: map[string]string{"invite_id": invite.ID.String(), "code_prefix": invite.CodePrefix},
+	}
+	if err := s.audit.Create(ctx, event); err != nil {
+		log.Error().Err(err).Str("invite_id", invite.ID.String()).Msg("Failed to record audit event")
+	}
+	return code, invite, nil
+}
+
+// List returns the most recent invites, newest first
+func (s *Invites) List(ctx context.Context) ([]models.Invite, error) {
+	return s.invites.List(ctx, maxListedInvites)
+}
+
+// Redeem marks the invite with the given code as used and returns its
+// ID. It returns ErrInviteInvalid for unknown, used and expired codes.
+func (s *Invites) Redeem(ctx context.Context, code string) (uuid.UUID, error) {
+	id, err := s.invites.Consume(ctx, hashInviteCode(code))
+	if errors.Is(err, repository.ErrInviteNotFound) {
+		return uuid.Nil, ErrInviteInvalid
+	}
+	return id, err
+}
+
+// Claim records the user created with a redeemed invite
+func (s *Invites) Claim(ctx context.Context, id, userID uuid.UUID) error {
+	return s.invites.SetUsedBy(ctx, id, userID)
+}
+
+// Release makes a redeemed invite usable again if no user claimed it
+func (s *Invites) Release(ctx context.Context, id uuid.UUID) error {
+	return s.invites.Release(ctx, id)
+}
+
+// hashInviteCode hashes a code regardless of case, spaces and dashes
+func hashInviteCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memInviteStore emulates the atomic consume of InviteRepository
+type memInviteStore struct {
+	mu      sync.Mutex
+	invites map[uuid.UUID]*models.Invite
+	now     func() time.Time
+}
+
+func newMemInviteStore() *memInviteStore {
+	return &memInviteStore{invites: make(map[uuid.UUID]*models.Invite), now: time.Now}
+}
+
+func (m *memInviteStore) Create(_ context.Context, invite *models.Invite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	copied := *invite
+	m.invites[invite.ID] = &copied
+	return nil
+}
+
+func (m *memInviteStore) List(context.Context, int) ([]models.Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.Invite
+	for _, inv := range m.invites {
+		out = append(out, *inv)
+	}
+	return out, nil
+}
+
+func (m *memInviteStore) Consume(_ context.Context, codeHash string) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, inv := range m.invites {
+		if inv.CodeHash == codeHash && inv.UsedAt == nil && m.now().Before(inv.ExpiresAt) {
+			now := m.now()
+			inv.UsedAt = &now
+			return inv.ID, nil
+		}
+	}
+	return uuid.Nil, repository.ErrInviteNotFound
+}
+
+func (m *memInviteStore) SetUsedBy(_ context.Context, id, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invites[id].UsedBy = &userID
+	return nil
+}
+
+func (m *memInviteStore) Release(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if inv := m.invites[id]; inv.UsedBy == nil {
+		inv.UsedAt = nil
+	}
+	return nil
+}
+
+func newTestInvites() (*Invites, *memInviteStore, *fakeAdminStores) {
+	store := newMemInviteStore()
+	f := newFakeAdminStores()
+	return NewInvites(store, fakeAudit{f}, time.Hour), store, f
+}
+
+func TestInvites_CreateAndRedeem(t *testing.T) {
+	invites, store, f := newTestInvites()
+	actor := uuid.New()
+
+	code, invite, err := invites.Create(context.Background(), actor, " for Jane ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 19 || !strings.HasPrefix(code, invite.CodePrefix+"-") || invite.Note != "for Jane" {
+		t.Errorf("code = %q, invite = %+v", code, invite)
+	}
+	if strings.Contains(invite.CodeHash, invite.CodePrefix) || len(f.audit) != 1 || f.audit[0].Action != models.AuditInviteCreated {
+		t.Errorf("hash = %q, audit = %+v", invite.CodeHash, f.audit)
+	}
+
+	// Case, dashes and spaces do not matter
+	typed := " " + strings.ToLower(strings.ReplaceAll(code, "-", " ")) + " "
+	id, err := invites.Redeem(context.Background(), typed)
+	if err != nil || id != invite.ID {
+		t.Fatalf("Redeem(%q) = %v, %v", typed, id, err)
+	}
+	if _, err := invites.Redeem(context.Background(), code); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("second Redeem = %v, want ErrInviteInvalid", err)
+	}
+
+	// A released invite can be used again, a claimed one cannot
+	if err := invites.Release(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invites.Redeem(context.Background(), code); err != nil {
+		t.Errorf("Redeem after Release = %v", err)
+	}
+	invites.Claim(context.Background(), id, uuid.New())
+	invites.Release(context.Background(), id)
+	if _, err := invites.Redeem(context.Background(), code); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("Redeem of claimed invite = %v", err)
+	}
+
+	// Expired codes are rejected
+	code, _, _ = invites.Create(context.Background(), actor, "")
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := invites.Redeem(context.Background(), code); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("Redeem of expired invite = %v", err)
+	}
+}
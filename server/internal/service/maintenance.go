@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MaintenanceReport lists what a maintenance run removed
+type MaintenanceReport struct {
+	RanAt           time.Time
+	Duration        time.Duration
+	SyncLogsDeleted int64
+	TokensDeleted   int64
+	Failed          bool // a step failed; the counts cover the others
+}
+
+// syncLogPruner is the subset of SyncLogRepository needed for retention
+type syncLogPruner interface {
+	DeleteOld(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+// refreshTokenCleaner is the subset of RefreshTokenRepository needed for
+// the cleanup
+type refreshTokenCleaner interface {
+	CleanupExpired(ctx context.Context) (int64, error)
+}
+
+// Maintenance prunes old sync log entries and removes expired and revoked
+// refresh tokens
+type Maintenance struct {
+	syncLogs  syncLogPruner
+	tokens    refreshTokenCleaner
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+
+	running    sync.Mutex // held during a run
+	mu         sync.Mutex
+	lastReport *MaintenanceReport
+}
+
+// NewMaintenance creates the maintenance job. It runs every interval, 0
+// disables it. Sync log entries older than retention are deleted; 0 keeps
+// them forever.
+func NewMaintenance(syncLogs syncLogPruner, tokens refreshTokenCleaner, retention, interval time.Duration) *Maintenance {
+	return &Maintenance{
+		syncLogs:  syncLogs,
+		tokens:    tokens,
+		retention: retention,
+		interval:  interval,
+		now:       time.Now,
+	}
+}
+
+// LastReport returns the report of the most recent run, or nil
+func (m *Maintenance) LastReport() *MaintenanceReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastReport
+}
+
+// Run performs maintenance every interval until ctx is cancelled
+func (m *Maintenance) Run(ctx context.Context) {
+	if m.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if report := m.RunOnce(ctx); report == nil {
+			log.Warn().Msg("Skipped maintenance, the previous run is still in progress")
+		} else if report.SyncLogsDeleted+report.TokensDeleted > 0 {
+			log.Info().
+				Int64("sync_logs", report.SyncLogsDeleted).
+				Int64("refresh_tokens", report.TokensDeleted).
+				Dur("duration", report.Duration).
+				Msg("Maintenance removed old rows")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce prunes the sync log and the refresh tokens. It returns nil
+// without doing anything while another run is in progress.
+func (m *Maintenance) RunOnce(ctx context.Context) *MaintenanceReport {
+	if !m.running.TryLock() {
+		return nil
+	}
+	defer m.running.Unlock()
+
+	report := &MaintenanceReport{RanAt: m.now()}
+	if m.retention > 0 {
+		n, err := m.syncLogs.DeleteOld(ctx, m.retention)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to prune sync logs")
+			report.Failed = true
+		}
+		report.SyncLogsDeleted = n
+	}
+	n, err := m.tokens.CleanupExpired(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to clean up refresh tokens")
+		report.Failed = true
+	}
+	report.TokensDeleted = n
+	report.Duration = m.now().Sub(report.RanAt)
+
+	m.mu.Lock()
+	m.lastReport = report
+	m.mu.Unlock()
+	return report
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memMaintenanceStore counts the rows each step would remove
+type memMaintenanceStore struct {
+	syncLogs, tokens int64
+	olderThan        time.Duration
+	tokenErr         error
+	block            chan struct{} // if set, DeleteOld waits for it
+}
+
+func (m *memMaintenanceStore) DeleteOld(_ context.Context, olderThan time.Duration) (int64, error) {
+	if m.block != nil {
+		<-m.block
+	}
+	m.olderThan = olderThan
+	return m.syncLogs, nil
+}
+
+func (m *memMaintenanceStore) CleanupExpired(context.Context) (int64, error) {
+	return m.tokens, m.tokenErr
+}
+
+func TestMaintenance(t *testing.T) {
+	ctx := context.Background()
+	store := &memMaintenanceStore{syncLogs: 12, tokens: 3}
+	m := NewMaintenance(store, store, 90*24*time.Hour, time.Hour)
+
+	if m.LastReport() != nil {
+		t.Fatal("report before the first run")
+	}
+	report := m.RunOnce(ctx)
+	if report.SyncLogsDeleted != 12 || report.TokensDeleted != 3 || report.Failed {
+		t.Errorf("report = %+v", report)
+	}
+	if store.olderThan != 90*24*time.Hour {
+		t.Errorf("pruned sync logs older than %v", store.olderThan)
+	}
+	if m.LastReport() != report {
+		t.Error("LastReport does not return the last run")
+	}
+
+	store.tokenErr = errors.New("connection reset")
+	if report := m.RunOnce(ctx); !report.Failed || report.SyncLogsDeleted != 12 {
+		t.Errorf("report after a failed step = %+v", report)
+	}
+
+	// Without retention the sync log is kept
+	store.syncLogs, store.tokenErr = 5, nil
+	if report := NewMaintenance(store, store, 0, time.Hour).RunOnce(ctx); report.SyncLogsDeleted != 0 || report.TokensDeleted != 3 {
+		t.Errorf("report without retention = %+v", report)
+	}
+}
+
+func TestMaintenanceSkipsOverlappingRuns(t *testing.T) {
+	store := &memMaintenanceStore{block: make(chan struct{})}
+	m := NewMaintenance(store, store, time.Hour, time.Hour)
+
+	done := make(chan *MaintenanceReport)
+	go func() { done <- m.RunOnce(context.Background()) }()
+	for m.running.TryLock() {
+		m.running.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	if report := m.RunOnce(context.Background()); report != nil {
+		t.Errorf("overlapping run = %+v, want skipped", report)
+	}
+	close(store.block)
+	if report := <-done; report == nil {
+		t.Error("first run was skipped")
+	}
+}
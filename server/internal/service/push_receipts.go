@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+const (
+	// pushReceiptTTL is how long a push can be replayed by its idempotency
+	// key
+	pushReceiptTTL = 24 * time.Hour
+	// pushReceiptPruneInterval is how often expired receipts are deleted
+	pushReceiptPruneInterval = time.Hour
+)
+
+// ErrIdempotencyKeyReused is returned by Replay for a key that was used
+// for a push to another vault
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used for another vault")
+
+// pushReceiptStore is the subset of PushReceiptRepository needed for
+// idempotent pushes
+type pushReceiptStore interface {
+	Get(ctx context.Context, userID uuid.UUID, key string, since time.Time) (*models.VaultPushReceipt, error)
+	Save(ctx context.Context, userID uuid.UUID, key string, receipt *models.VaultPushReceipt, expiredBefore time.Time) error
+	DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// PushReceipts makes pushes idempotent: the response of a successful push
+// sent with an idempotency key is kept for a day, and a retry with the
+// same key gets it again instead of a revision conflict. Keys are scoped
+// per user.
+type PushReceipts struct {
+	store pushReceiptStore
+	now   func() time.Time
+}
+
+// NewPushReceipts creates the idempotent push service
+func NewPushReceipts(store pushReceiptStore) *PushReceipts {
+	return &PushReceipts{store: store, now: time.Now}
+}
+
+// Replay returns the response of the user's earlier push with key, or nil
+// if there was none within the last day
+func (p *PushReceipts) Replay(ctx context.Context, userID uuid.UUID, key, vault string) (*models.VaultPushResponse, error) {
+	receipt, err := p.store.Get(ctx, userID, key, p.now().Add(-pushReceiptTTL))
+	if errors.Is(err, repository.ErrPushReceiptNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if receipt.Vault != vault {
+		return nil, ErrIdempotencyKeyReused
+	}
+	return &receipt.Response, nil
+}
+
+// Remember stores the response of a successful push with key
+func (p *PushReceipts) Remember(ctx context.Context, userID uuid.UUID, key, vault string, resp models.VaultPushResponse) error {
+	receipt := &models.VaultPushReceipt{Vault: vault, Response: resp}
+	return p.store.Save(ctx, userID, key, receipt, p.now().Add(-pushReceiptTTL))
+}
+
+// Prune deletes expired receipts
+func (p *PushReceipts) Prune(ctx context.Context) (int64, error) {
+	return p.store.DeleteOlderThan(ctx, p.now().Add(-pushReceiptTTL))
+}
+
+// Run prunes expired receipts until ctx is cancelled
+func (p *PushReceipts) Run(ctx context.Context) {
+	ticker := time.NewTicker(pushReceiptPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := p.Prune(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to prune push receipts")
+		} else if n > 0 {
+			log.Info().Int64("count", n).Msg("Pruned expired push receipts")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memPushReceipts follows the contract of PushReceiptRepository with the
+// time of storage supplied by now
+type memPushReceipts struct {
+	mu       sync.Mutex
+	receipts map[string]storedReceipt // by user ID and key
+	now      func() time.Time
+}
+
+type storedReceipt struct {
+	receipt  models.VaultPushReceipt
+	storedAt time.Time
+}
+
+func newMemPushReceipts(now func() time.Time) *memPushReceipts {
+	return &memPushReceipts{receipts: map[string]storedReceipt{}, now: now}
+}
+
+func (m *memPushReceipts) Get(_ context.Context, userID uuid.UUID, key string, since time.Time) (*models.VaultPushReceipt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.receipts[userID.String()+"/"+key]
+	if !ok || !stored.storedAt.After(since) {
+		return nil, repository.ErrPushReceiptNotFound
+	}
+	return &stored.receipt, nil
+}
+
+func (m *memPushReceipts) Save(_ context.Context, userID uuid.UUID, key string, receipt *models.VaultPushReceipt, expiredBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := userID.String() + "/" + key
+	if stored, ok := m.receipts[id]; ok && stored.storedAt.After(expiredBefore) {
+		return nil
+	}
+	m.receipts[id] = storedReceipt{receipt: *receipt, storedAt: m.now()}
+	return nil
+}
+
+func (m *memPushReceipts) DeleteOlderThan(_ context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, stored := range m.receipts {
+		if stored.storedAt.Before(cutoff) {
+			delete(m.receipts, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestPushReceipts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	clock := func() time.Time { return now }
+	receipts := NewPushReceipts(newMemPushReceipts(clock))
+	receipts.now = clock
+	userID := uuid.New()
+	resp := models.VaultPushResponse{Status: "updated", Revision: 5, Timestamp: now.Unix()}
+
+	if replay, err := receipts.Replay(ctx, userID, "k1", "default"); err != nil || replay != nil {
+		t.Fatalf("replay of an unknown key = %+v, %v", replay, err)
+	}
+	if err := receipts.Remember(ctx, userID, "k1", "default", resp); err != nil {
+		t.Fatal(err)
+	}
+	if replay, err := receipts.Replay(ctx, userID, "k1", "default"); err != nil || replay == nil || *replay != resp {
+		t.Errorf("replay = %+v, %v, want %+v", replay, err, resp)
+	}
+	if replay, _ := receipts.Replay(ctx, uuid.New(), "k1", "default"); replay != nil {
+		t.Error("key of another user was replayed")
+	}
+	if _, err := receipts.Replay(ctx, userID, "k1", "work"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("replay for another vault: error = %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	now = now.Add(pushReceiptTTL + time.Second)
+	if replay, _ := receipts.Replay(ctx, userID, "k1", "default"); replay != nil {
+		t.Error("expired key was replayed")
+	}
+	if n, _ := receipts.Prune(ctx); n != 1 {
+		t.Errorf("pruned %d receipts, want 1", n)
+	}
+}
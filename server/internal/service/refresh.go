@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrAccountInactive     = errors.New("account no longer active")
+	ErrDeviceMismatch      = errors.New("refresh token does not match its device")
+	ErrRefreshTokenReused  = errors.New("rotated refresh token reused")
+)
+
+// refreshTokenStore is the subset of RefreshTokenRepository needed for refresh
+type refreshTokenStore interface {
+	GetByTokenHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	Rotate(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*models.RefreshToken, error)
+	Revoke(ctx context.Context, tokenHash string) error
+	RevokeAllForUser(ctx context.Context, userID uuid.UUID) error
+}
+
+// refreshUserStore is the subset of UserRepository needed for refresh
+type refreshUserStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+}
+
+// refreshDeviceStore is the subset of DeviceRepository needed for refresh
+type refreshDeviceStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error)
+	TouchLastSeen(ctx context.Context, id uuid.UUID) error
+	UpdateActivity(ctx context.Context, id uuid.UUID, ip, userAgent string) error
+}
+
+// TokenRefresh validates and rotates refresh tokens before new access
+// tokens are minted
+type TokenRefresh struct {
+	tokens  refreshTokenStore
+	users   refreshUserStore
+	devices refreshDeviceStore
+	events  *events.Log
+	now     func() time.Time
+}
+
+// NewTokenRefresh creates a new token refresh service. eventLog may be nil.
+func NewTokenRefresh(tokens refreshTokenStore, users refreshUserStore, devices refreshDeviceStore, eventLog *events.Log) *TokenRefresh {
+	return &TokenRefresh{
+		tokens:  tokens,
+		users:   users,
+		devices: devices,
+		events:  eventLog,
+		now:     time.Now,
+	}
+}
+
+// Validate checks the refresh token with the given hash and returns its
+// user and device. The device always comes from the token's own record;
+// if that device no longer exists or belongs to another user, the token
+// is revoked, a security event is logged and ErrDeviceMismatch returned.
+func (s *TokenRefresh) Validate(ctx context.Context, tokenHash string) (*models.User, *models.Device, error) {
+	token, err := s.tokens.GetByTokenHash(ctx, tokenHash)
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if token.Revoked {
+		if token.ReplacedBy != nil {
+			s.rejectReuse(ctx, token)
+			return nil, nil, ErrRefreshTokenReused
+		}
+		return nil, nil, ErrRefreshTokenRevoked
+	}
+	if s.now().After(token.ExpiresAt) {
+		return nil, nil, ErrRefreshTokenExpired
+	}
+
+	user, err := s.users.GetByID(ctx, token.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.IsBlocked || !user.IsApproved {
+		return nil, nil, ErrAccountInactive
+	}
+
+	device, err := s.devices.GetByID(ctx, token.DeviceID)
+	if errors.Is(err, repository.ErrDeviceNotFound) {
+		s.rejectMismatch(ctx, token, tokenHash, "device_deleted", uuid.Nil)
+		return nil, nil, ErrDeviceMismatch
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if device.UserID != token.UserID {
+		s.rejectMismatch(ctx, token, tokenHash, "device_user_mismatch", device.UserID)
+		return nil, nil, ErrDeviceMismatch
+	}
+
+	return user, device, nil
+}
+
+// Refresh validates the refresh token with the given hash like Validate
+// and replaces it with a token hashed as newHash, which is returned. Each
+// refresh token can be used once; presenting a rotated token again is
+// treated as theft and revokes every token of the user. A successful
+// refresh updates the device's last seen time.
+func (s *TokenRefresh) Refresh(ctx context.Context, tokenHash, newHash string, expiresAt time.Time) (*models.User, *models.Device, *models.RefreshToken, error) {
+	user, device, err := s.Validate(ctx, tokenHash)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rotated, err := s.tokens.Rotate(ctx, tokenHash, newHash, expiresAt)
+	if errors.Is(err, repository.ErrRefreshTokenRotated) {
+		// Lost a race against another use of the same token
+		token, getErr := s.tokens.GetByTokenHash(ctx, tokenHash)
+		if getErr != nil {
+			return nil, nil, nil, getErr
+		}
+		if token.ReplacedBy == nil {
+			return nil, nil, nil, ErrRefreshTokenRevoked
+		}
+		s.rejectReuse(ctx, token)
+		return nil, nil, nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := s.devices.TouchLastSeen(ctx, device.ID); err != nil {
+		log.Error().Err(err).Str("device_id", device.ID.String()).Msg("Failed to update device last seen time")
+	}
+	ip, userAgent := events.Client(ctx)
+	if err := s.devices.UpdateActivity(ctx, device.ID, ip, userAgent); err != nil {
+		log.Error().Err(err).Str("device_id", device.ID.String()).Msg("Failed to update device activity")
+	}
+	return user, device, rotated, nil
+}
+
+// rejectReuse revokes all tokens of a user after a rotated token was
+// presented again and records a security event
+func (s *TokenRefresh) rejectReuse(ctx context.Context, token *models.RefreshToken) {
+	if err := s.tokens.RevokeAllForUser(ctx, token.UserID); err != nil {
+		log.Error().Err(err).Str("user_id", token.UserID.String()).Msg("Failed to revoke refresh tokens after reuse")
+	}
+
+	log.Warn().
+		Str("event", "refresh_token_reuse").
+		Str("token_id", token.ID.String()).
+		Str("family_id", token.FamilyID.String()).
+		Str("user_id", token.UserID.String()).
+		Str("device_id", token.DeviceID.String()).
+		Msg("Security event: rotated refresh token reused, all sessions revoked")
+	s.events.Record(ctx, events.Entry{
+		SubjectID: &token.UserID,
+		Payload:   events.RefreshTokenReused{FamilyID: token.FamilyID, DeviceID: token.DeviceID},
+	})
+}
+
+// rejectMismatch revokes a refresh token whose device binding is broken
+// and records a security event
+func (s *TokenRefresh) rejectMismatch(ctx context.Context, token *models.RefreshToken, tokenHash, reason string, deviceOwner uuid.UUID) {
+	if err := s.tokens.Revoke(ctx, tokenHash); err != nil {
+		log.Error().Err(err).Str("token_id", token.ID.String()).Msg("Failed to revoke mismatched refresh token")
+	}
+
+	event := log.Warn().
+		Str("event", "refresh_device_mismatch").
+		Str("reason", reason).
+		Str("token_id", token.ID.String()).
+		Str("user_id", token.UserID.String()).
+		Str("device_id", token.DeviceID.String())
+	if deviceOwner != uuid.Nil {
+		event = event.Str("device_user_id", deviceOwner.String())
+	}
+	event.Msg("Security event: refresh token device binding violated")
+	s.events.Record(ctx, events.Entry{
+		SubjectID: &token.UserID,
+		Payload:   events.RefreshDeviceMismatch{DeviceID: token.DeviceID, Reason: reason},
+	})
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+type memRefreshStores struct {
+	tokens  map[string]*models.RefreshToken
+	users   map[uuid.UUID]*models.User
+	devices map[uuid.UUID]*models.Device
+}
+
+func (m *memRefreshStores) GetByTokenHash(_ context.Context, tokenHash string) (*models.RefreshToken, error) {
+	t, ok := m.tokens[tokenHash]
+	if !ok {
+		return nil, repository.ErrRefreshTokenNotFound
+	}
+	return t, nil
+}
+
+func (m *memRefreshStores) Revoke(_ context.Context, tokenHash string) error {
+	if t, ok := m.tokens[tokenHash]; ok {
+		t.Revoked = true
+	}
+	return nil
+}
+
+func (m *memRefreshStores) Rotate(_ context.Context, oldHash, newHash string, expiresAt time.Time) (*models.RefreshToken, error) {
+	old, ok := m.tokens[oldHash]
+	if !ok || old.Revoked {
+		return nil, repository.ErrRefreshTokenRotated
+	}
+	next := &models.RefreshToken{
+		ID:        uuid.New(),
+		UserID:    old.UserID,
+		DeviceID:  old.DeviceID,
+		FamilyID:  old.FamilyID,
+		TokenHash: newHash,
+		ExpiresAt: expiresAt,
+	}
+	old.Revoked = true
+	old.ReplacedBy = &next.ID
+	m.tokens[newHash] = next
+	return next, nil
+}
+
+func (m *memRefreshStores) RevokeAllForUser(_ context.Context, userID uuid.UUID) error {
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			t.Revoked = true
+		}
+	}
+	return nil
+}
+
+type refreshUsers struct{ m *memRefreshStores }
+
+func (s refreshUsers) GetByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	u, ok := s.m.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return u, nil
+}
+
+type refreshDevices struct{ m *memRefreshStores }
+
+func (s refreshDevices) GetByID(_ context.Context, id uuid.UUID) (*models.Device, error) {
+	d, ok := s.m.devices[id]
+	if !ok {
+		return nil, repository.ErrDeviceNotFound
+	}
+	return d, nil
+}
+
+func (s refreshDevices) TouchLastSeen(_ context.Context, id uuid.UUID) error {
+	now := time.Now()
+	s.m.devices[id].LastSeenAt = &now
+	return nil
+}
+
+func (s refreshDevices) UpdateActivity(_ context.Context, id uuid.UUID, ip, userAgent string) error {
+	s.m.devices[id].LastIP, s.m.devices[id].LastUserAgent = ip, userAgent
+	return nil
+}
+
+// newRefreshFixture creates a user with one device and a refresh token "hash" for it
+func newRefreshFixture() (*memRefreshStores, *TokenRefresh, *models.User, *models.Device) {
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", IsApproved: true}
+	device := &models.Device{ID: uuid.New(), UserID: user.ID, DeviceName: "laptop"}
+	familyID := uuid.New()
+	m := &memRefreshStores{
+		tokens: map[string]*models.RefreshToken{
+			"hash": {
+				ID:        familyID,
+				FamilyID:  familyID,
+				UserID:    user.ID,
+				DeviceID:  device.ID,
+				TokenHash: "hash",
+				ExpiresAt: time.Now().Add(time.Hour),
+			},
+		},
+		users:   map[uuid.UUID]*models.User{user.ID: user},
+		devices: map[uuid.UUID]*models.Device{device.ID: device},
+	}
+	return m, NewTokenRefresh(m, refreshUsers{m}, refreshDevices{m}, nil), user, device
+}
+
+func TestTokenRefreshHappyPath(t *testing.T) {
+	_, s, user, device := newRefreshFixture()
+
+	gotUser, gotDevice, err := s.Validate(context.Background(), "hash")
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if gotUser.ID != user.ID || gotDevice.ID != device.ID {
+		t.Errorf("got user %s device %s, want %s %s", gotUser.ID, gotDevice.ID, user.ID, device.ID)
+	}
+}
+
+func TestTokenRefreshDeletedDevice(t *testing.T) {
+	m, s, _, device := newRefreshFixture()
+	delete(m.devices, device.ID)
+
+	if _, _, err := s.Validate(context.Background(), "hash"); !errors.Is(err, ErrDeviceMismatch) {
+		t.Fatalf("expected ErrDeviceMismatch, got %v", err)
+	}
+	if !m.tokens["hash"].Revoked {
+		t.Error("refresh token for deleted device was not revoked")
+	}
+}
+
+func TestTokenRefreshDeviceUserMismatch(t *testing.T) {
+	m, s, _, device := newRefreshFixture()
+
+	// Simulate a manual DB edit moving the device to another account
+	other := &models.User{ID: uuid.New(), IsApproved: true}
+	m.users[other.ID] = other
+	device.UserID = other.ID
+
+	if _, _, err := s.Validate(context.Background(), "hash"); !errors.Is(err, ErrDeviceMismatch) {
+		t.Fatalf("expected ErrDeviceMismatch, got %v", err)
+	}
+	if !m.tokens["hash"].Revoked {
+		t.Error("mismatched refresh token was not revoked")
+	}
+	if _, _, err := s.Validate(context.Background(), "hash"); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("expected ErrRefreshTokenRevoked on retry, got %v", err)
+	}
+}
+
+func TestTokenRefreshRejectsInvalidTokens(t *testing.T) {
+	m, s, user, _ := newRefreshFixture()
+	ctx := context.Background()
+
+	if _, _, err := s.Validate(ctx, "unknown"); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Errorf("unknown token: got %v", err)
+	}
+
+	m.tokens["hash"].ExpiresAt = time.Now().Add(-time.Minute)
+	if _, _, err := s.Validate(ctx, "hash"); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Errorf("expired token: got %v", err)
+	}
+
+	m.tokens["hash"].ExpiresAt = time.Now().Add(time.Hour)
+	user.IsBlocked = true
+	if _, _, err := s.Validate(ctx, "hash"); !errors.Is(err, ErrAccountInactive) {
+		t.Errorf("blocked user: got %v", err)
+	}
+}
+
+func TestTokenRefreshRotates(t *testing.T) {
+	m, s, user, device := newRefreshFixture()
+	ctx := events.WithClient(context.Background(), "198.51.100.7", "vibedterm-app/2.0")
+	expiresAt := time.Now().Add(24 * time.Hour)
+
+	gotUser, gotDevice, rotated, err := s.Refresh(ctx, "hash", "hash2", expiresAt)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if gotUser.ID != user.ID || gotDevice.ID != device.ID {
+		t.Errorf("got user %s device %s, want %s %s", gotUser.ID, gotDevice.ID, user.ID, device.ID)
+	}
+
+	old, next := m.tokens["hash"], m.tokens["hash2"]
+	if !old.Revoked || old.ReplacedBy == nil || *old.ReplacedBy != next.ID {
+		t.Errorf("presented token not rotated: %+v", old)
+	}
+	if next.Revoked || next.DeviceID != device.ID || next.FamilyID != old.FamilyID || !next.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("unexpected successor %+v", next)
+	}
+	if rotated == nil || rotated.ID != next.ID || rotated.FamilyID != old.FamilyID {
+		t.Errorf("returned token %+v, want the successor", rotated)
+	}
+	if device.LastIP != "198.51.100.7" || device.LastUserAgent != "vibedterm-app/2.0" {
+		t.Errorf("device activity = %q %q, want the refreshing client", device.LastIP, device.LastUserAgent)
+	}
+
+	// The successor can be rotated in turn
+	if _, _, _, err := s.Refresh(ctx, "hash2", "hash3", expiresAt); err != nil {
+		t.Fatalf("second Refresh: %v", err)
+	}
+}
+
+func TestTokenRefreshDetectsReuse(t *testing.T) {
+	m, s, user, _ := newRefreshFixture()
+	ctx := context.Background()
+	expiresAt := time.Now().Add(24 * time.Hour)
+
+	// Another session of the same user, unrelated to the stolen chain
+	m.tokens["other"] = &models.RefreshToken{ID: uuid.New(), UserID: user.ID, DeviceID: uuid.New(), TokenHash: "other", ExpiresAt: expiresAt}
+
+	if _, _, _, err := s.Refresh(ctx, "hash", "hash2", expiresAt); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	// The rotated token is presented again, e.g. by a thief
+	if _, _, _, err := s.Refresh(ctx, "hash", "hash3", expiresAt); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	for hash, token := range m.tokens {
+		if !token.Revoked {
+			t.Errorf("token %s still active after reuse", hash)
+		}
+	}
+	if _, ok := m.tokens["hash3"]; ok {
+		t.Error("reused token was rotated")
+	}
+
+	// The legitimate successor is revoked too
+	if _, _, _, err := s.Refresh(ctx, "hash2", "hash4", expiresAt); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Errorf("successor after reuse: got %v, want ErrRefreshTokenRevoked", err)
+	}
+}
+
+func TestTokenRefreshRevokedTokenIsNotReuse(t *testing.T) {
+	m, s, _, _ := newRefreshFixture()
+	m.tokens["hash"].Revoked = true // e.g. logout
+
+	if _, _, _, err := s.Refresh(context.Background(), "hash", "hash2", time.Now().Add(time.Hour)); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("expected ErrRefreshTokenRevoked, got %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	passwords "github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// duplicateRegistrationWindow is how long after creation a repeated
+// registration with the same credentials is treated as the same request
+const duplicateRegistrationWindow = 10 * time.Second
+
+var (
+	// ErrDisplayNameRequired is returned when the server requires a
+	// display name and none was given
+	ErrDisplayNameRequired   = errors.New("display name required")
+	ErrRegistrationClosed    = errors.New("registration disabled")
+	ErrEmailDomainNotAllowed = errors.New("email domain not allowed")
+)
+
+// Registration modes
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite" // a single-use invite code is required
+	RegistrationClosed = "closed"
+)
+
+// Settings keys of the runtime registration policy
+const (
+	settingRegistrationMode    = "registration.mode"
+	settingRegistrationDomains = "registration.allowed_domains"
+)
+
+// userStore is the subset of UserRepository needed for registration
+type userStore interface {
+	Create(ctx context.Context, email, passwordHash string) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	SetRegistrationProfile(ctx context.Context, id uuid.UUID, profile models.RegistrationProfile) error
+}
+
+// verificationSender sends email verification links
+type verificationSender interface {
+	Send(ctx context.Context, user *models.User) error
+}
+
+// registrationWatcher is told of each account created by a registration
+type registrationWatcher interface {
+	NotifyRegistration(ctx context.Context, user *models.User)
+}
+
+// inviteRedeemer consumes invite codes for invite-only registration
+type inviteRedeemer interface {
+	Redeem(ctx context.Context, code string) (uuid.UUID, error)
+	Claim(ctx context.Context, id, userID uuid.UUID) error
+	Release(ctx context.Context, id uuid.UUID) error
+}
+
+// RegistrationPolicy configures who may register, what registration asks
+// for and what it reveals
+type RegistrationPolicy struct {
+	Mode               string   // RegistrationOpen, RegistrationInvite or RegistrationClosed
+	AllowedDomains     []string // email domains that may register; empty allows all
+	RequireDisplayName bool     // registrations must state a display name
+	// ConcealExisting answers registrations for a registered email like
+	// new ones and tells the account owner instead, so the endpoint does
+	// not reveal which emails are registered
+	ConcealExisting bool
+}
+
+// Validate checks that p is usable
+func (p RegistrationPolicy) Validate() error {
+	switch p.Mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+		return nil
+	}
+	return fmt.Errorf("unknown registration mode %q, want open, invite or closed", p.Mode)
+}
+
+// domainAllowed reports whether email may register under the allowlist.
+// Domains compare case-insensitively.
+func (p RegistrationPolicy) domainAllowed(email string) bool {
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range p.AllowedDomains {
+		if strings.EqualFold(domain, strings.TrimPrefix(strings.TrimSpace(allowed), "@")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registration creates user accounts for the API and web registration flows
+type Registration struct {
+	users        userStore
+	verification verificationSender
+	invites      inviteRedeemer
+	notifier     notify.Notifier
+	events       *events.Log
+	settings     policySettingStore  // runtime overrides of mode and domains; may be nil
+	watcher      registrationWatcher // told of new accounts; may be nil
+	policy       RegistrationPolicy
+	now          func() time.Time
+}
+
+// NewRegistration creates a new registration service. policy applies
+// until an admin saves a mode or domain allowlist in settings. eventLog
+// and watcher may be nil.
+func NewRegistration(userRepo *repository.UserRepository, verification verificationSender, invites *Invites, settings policySettingStore, notifier notify.Notifier, eventLog *events.Log, watcher registrationWatcher, policy RegistrationPolicy) *Registration {
+	return &Registration{
+		users:        userRepo,
+		verification: verification,
+		invites:      invites,
+		settings:     settings,
+		notifier:     notifier,
+		events:       eventLog,
+		watcher:      watcher,
+		policy:       policy,
+		now:          time.Now,
+	}
+}
+
+// Policy returns the effective policy: the configured one with the mode
+// and domain allowlist saved by an admin, if any
+func (s *Registration) Policy(ctx context.Context) (RegistrationPolicy, error) {
+	p := s.policy
+	if s.settings == nil {
+		return p, nil
+	}
+	saved, err := s.settings.All(ctx)
+	if err != nil {
+		return p, err
+	}
+	if v, ok := saved[settingRegistrationMode]; ok {
+		p.Mode = v
+	}
+	if v, ok := saved[settingRegistrationDomains]; ok {
+		p.AllowedDomains = splitDomains(v)
+	}
+	if err := p.Validate(); err != nil {
+		log.Warn().Err(err).Msg("Saved registration policy is invalid, using the configuration")
+		return s.policy, nil
+	}
+	return p, nil
+}
+
+// SaveAccess stores the registration mode and domain allowlist, which
+// take effect immediately
+func (s *Registration) SaveAccess(ctx context.Context, mode string, domains []string) error {
+	p := s.policy
+	p.Mode, p.AllowedDomains = mode, domains
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if err := s.settings.Set(ctx, settingRegistrationMode, mode); err != nil {
+		return err
+	}
+	return s.settings.Set(ctx, settingRegistrationDomains, strings.Join(domains, ","))
+}
+
+// splitDomains parses a comma-separated domain list
+func splitDomains(v string) []string {
+	var domains []string
+	for _, d := range strings.Split(v, ",") {
+		if d = strings.TrimPrefix(strings.TrimSpace(d), "@"); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// Requirements describes what a registration must satisfy under the
+// effective policy, so clients can check input before submitting it
+func (s *Registration) Requirements(ctx context.Context) (*models.AuthRequirements, error) {
+	p, err := s.Policy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	domains := make([]string, 0, len(p.AllowedDomains))
+	quoted := make([]string, 0, len(p.AllowedDomains))
+	for _, d := range p.AllowedDomains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		domains = append(domains, d)
+		quoted = append(quoted, regexp.QuoteMeta(d))
+	}
+	r := &models.AuthRequirements{
+		Password: models.PasswordRequirements{MinLength: passwords.MinLength},
+		Registration: models.RegistrationRequirements{
+			Mode:                      p.Mode,
+			InviteRequired:            p.Mode == RegistrationInvite,
+			EmailVerificationRequired: true,
+			DisplayNameRequired:       p.RequireDisplayName,
+			AllowedEmailDomains:       domains,
+		},
+	}
+	if len(quoted) > 0 {
+		r.Registration.EmailDomainPattern = "@(" + strings.Join(quoted, "|") + ")$"
+	}
+	return r, nil
+}
+
+// RequiresDisplayName reports whether registrations must state a display name
+func (s *Registration) RequiresDisplayName() bool {
+	return s.policy.RequireDisplayName
+}
+
+// ConcealsExisting reports whether callers should answer
+// repository.ErrUserAlreadyExists like a successful registration
+func (s *Registration) ConcealsExisting() bool {
+	return s.policy.ConcealExisting
+}
+
+// Register creates a new unapproved user and sends them a verification
+// link. A failure to send is logged; the user can request a new link.
+// HTML is stripped from the profile, which is shown to the admins. The
+// policy decides whether the email's domain may register and whether an
+// invite code is needed, which is used up by a successful registration.
+//
+// Concurrent or retried submissions with the same email and password that
+// arrive within duplicateRegistrationWindow resolve to the user created by
+// the first one. Any other registration for an existing email returns
+// repository.ErrUserAlreadyExists; with ConcealExisting the account owner
+// is notified of the attempt as well.
+func (s *Registration) Register(ctx context.Context, email, password, inviteCode string, profile models.RegistrationProfile) (user *models.User, err error) {
+	policy, err := s.Policy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy.Mode == RegistrationClosed {
+		return nil, ErrRegistrationClosed
+	}
+	if !policy.domainAllowed(email) {
+		return nil, ErrEmailDomainNotAllowed
+	}
+	profile.DisplayName = input.StripHTML(profile.DisplayName)
+	profile.Message = input.StripHTML(profile.Message)
+	if s.policy.RequireDisplayName && profile.DisplayName == "" {
+		return nil, ErrDisplayNameRequired
+	}
+
+	if policy.Mode == RegistrationInvite {
+		if strings.TrimSpace(inviteCode) == "" {
+			return nil, ErrInviteRequired
+		}
+		inviteID, err := s.invites.Redeem(ctx, inviteCode)
+		if errors.Is(err, ErrInviteInvalid) {
+			// A retried submission finds its invite used by the first one
+			if existing := s.duplicateOf(ctx, email, password); existing != nil {
+				return existing, nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if user == nil {
+				s.releaseInvite(ctx, inviteID)
+				return
+			}
+			if err := s.invites.Claim(ctx, inviteID, user.ID); err != nil {
+				log.Error().Err(err).Str("invite_id", inviteID.String()).Msg("Failed to record invite user")
+			}
+		}()
+	}
+
+	hashedPassword, err := passwords.Hash(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err = s.users.Create(ctx, email, hashedPassword)
+	if err == nil {
+		if profile != (models.RegistrationProfile{}) {
+			// The profile only informs the approval; the account stands without it
+			if err := s.users.SetRegistrationProfile(ctx, user.ID, profile); err != nil {
+				log.Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to store registration profile")
+			} else {
+				user.DisplayName, user.RegistrationMessage = profile.DisplayName, profile.Message
+			}
+		}
+		s.events.Record(ctx, events.Entry{SubjectID: &user.ID, Payload: events.AccountRegistered{Email: user.Email}})
+		if s.verification != nil {
+			if err := s.verification.Send(ctx, user); err != nil {
+				log.Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to send verification email")
+			}
+		}
+		if s.watcher != nil {
+			s.watcher.NotifyRegistration(ctx, user)
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrUserAlreadyExists) {
+		return nil, err
+	}
+
+	existing, lookupErr := s.users.GetByEmail(ctx, email)
+	if lookupErr != nil {
+		return nil, err
+	}
+	if !s.isDuplicate(ctx, existing, password) {
+		if s.policy.ConcealExisting {
+			s.notifyExisting(ctx, existing)
+		}
+		return nil, repository.ErrUserAlreadyExists
+	}
+	// The first submission claimed an invite already
+	if policy.Mode == RegistrationInvite {
+		return nil, ErrInviteInvalid
+	}
+	return existing, nil
+}
+
+// duplicateOf returns the user just created from the same credentials, if any
+func (s *Registration) duplicateOf(ctx context.Context, email, password string) *models.User {
+	existing, err := s.users.GetByEmail(ctx, email)
+	if err != nil || !s.isDuplicate(ctx, existing, password) {
+		return nil
+	}
+	return existing
+}
+
+// releaseInvite frees an invite redeemed by a failed registration
+func (s *Registration) releaseInvite(ctx context.Context, id uuid.UUID) {
+	if err := s.invites.Release(ctx, id); err != nil {
+		log.Error().Err(err).Str("invite_id", id.String()).Msg("Failed to release invite")
+	}
+}
+
+// notifyExisting tells the owner of existing that someone tried to
+// register their email address again
+func (s *Registration) notifyExisting(ctx context.Context, existing *models.User) {
+	if s.notifier == nil {
+		return
+	}
+	err := s.notifier.Notify(ctx, notify.Notification{
+		Kind:    notify.KindRegistrationAttempt,
+		UserID:  existing.ID,
+		Email:   existing.Email,
+		Subject: "Registration attempt for your account",
+		Body: "Someone tried to register a new account with your email address. " +
+			"You already have an account; if you forgot your password, contact an admin. " +
+			"If this was not you, no action is needed.",
+	})
+	if err != nil {
+		log.Error().Err(err).Str("user_id", existing.ID.String()).Msg("Failed to send registration attempt notification")
+	}
+}
+
+// isDuplicate reports whether existing was just created from the same credentials
+func (s *Registration) isDuplicate(ctx context.Context, existing *models.User, password string) bool {
+	if existing.IsApproved || existing.IsBlocked {
+		return false
+	}
+	if s.now().Sub(existing.CreatedAt) > duplicateRegistrationWindow {
+		return false
+	}
+	return passwords.Compare(ctx, existing.PasswordHash, password) == nil
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memUserStore emulates the unique email constraint of the users table
+type memUserStore struct {
+	mu    sync.Mutex
+	users map[string]*models.User
+}
+
+func newMemUserStore() *memUserStore {
+	return &memUserStore{users: make(map[string]*models.User)}
+}
+
+func (m *memUserStore) Create(_ context.Context, email, passwordHash string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.users[email]; exists {
+		return nil, repository.ErrUserAlreadyExists
+	}
+	u := &models.User{ID: uuid.New(), Email: email, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	m.users[email] = u
+	return u, nil
+}
+
+func (m *memUserStore) GetByEmail(_ context.Context, email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[email]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (m *memUserStore) SetRegistrationProfile(_ context.Context, id uuid.UUID, profile models.RegistrationProfile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.ID == id {
+			u.DisplayName, u.RegistrationMessage = profile.DisplayName, profile.Message
+			return nil
+		}
+	}
+	return repository.ErrUserNotFound
+}
+
+func TestRegister_ConcurrentSameCredentials(t *testing.T) {
+	store := newMemUserStore()
+	s := &Registration{users: store, now: time.Now}
+
+	const n = 8
+	ids := make([]uuid.UUID, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u, err := s.Register(context.Background(), "same@example.com", "password123", "", models.RegistrationProfile{})
+			errs[i] = err
+			if u != nil {
+				ids[i] = u.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(store.users) != 1 {
+		t.Fatalf("store has %d users, want 1", len(store.users))
+	}
+	want := store.users["same@example.com"].ID
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Errorf("registration %d: unexpected error %v", i, errs[i])
+		}
+		if ids[i] != want {
+			t.Errorf("registration %d: user ID = %v, want %v", i, ids[i], want)
+		}
+	}
+}
+
+func TestRegister_ConcurrentDifferentPasswords(t *testing.T) {
+	store := newMemUserStore()
+	s := &Registration{users: store, now: time.Now}
+
+	passwords := []string{"password-one", "password-two", "password-three", "password-four"}
+	errs := make([]error, len(passwords))
+
+	var wg sync.WaitGroup
+	for i, pw := range passwords {
+		wg.Add(1)
+		go func(i int, pw string) {
+			defer wg.Done()
+			_, errs[i] = s.Register(context.Background(), "race@example.com", pw, "", models.RegistrationProfile{})
+		}(i, pw)
+	}
+	wg.Wait()
+
+	if len(store.users) != 1 {
+		t.Fatalf("store has %d users, want 1", len(store.users))
+	}
+
+	var created, conflicts int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, repository.ErrUserAlreadyExists):
+			conflicts++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 || conflicts != len(passwords)-1 {
+		t.Errorf("created = %d, conflicts = %d, want 1 and %d", created, conflicts, len(passwords)-1)
+	}
+}
+
+func TestRegister_SameCredentialsAfterWindow(t *testing.T) {
+	store := newMemUserStore()
+	now := time.Now()
+	s := &Registration{users: store, now: func() time.Time { return now }}
+
+	if _, err := s.Register(context.Background(), "late@example.com", "password123", "", models.RegistrationProfile{}); err != nil {
+		t.Fatalf("first registration failed: %v", err)
+	}
+
+	now = now.Add(duplicateRegistrationWindow + time.Second)
+	_, err := s.Register(context.Background(), "late@example.com", "password123", "", models.RegistrationProfile{})
+	if !errors.Is(err, repository.ErrUserAlreadyExists) {
+		t.Errorf("error = %v, want ErrUserAlreadyExists", err)
+	}
+}
+
+func TestRegister_ApprovedUserIsNeverDuplicate(t *testing.T) {
+	store := newMemUserStore()
+	s := &Registration{users: store, now: time.Now}
+
+	if _, err := s.Register(context.Background(), "approved@example.com", "password123", "", models.RegistrationProfile{}); err != nil {
+		t.Fatalf("first registration failed: %v", err)
+	}
+	store.users["approved@example.com"].IsApproved = true
+
+	_, err := s.Register(context.Background(), "approved@example.com", "password123", "", models.RegistrationProfile{})
+	if !errors.Is(err, repository.ErrUserAlreadyExists) {
+		t.Errorf("error = %v, want ErrUserAlreadyExists", err)
+	}
+}
+
+func TestRegister_ProfileIsSanitized(t *testing.T) {
+	store := newMemUserStore()
+	s := &Registration{users: store, now: time.Now}
+
+	profile := models.RegistrationProfile{
+		DisplayName: "  <b>Jane</b> Doe ",
+		Message:     `<script>alert(1)</script>Team <a href="x">ops</a>, 1<2`,
+	}
+	if _, err := s.Register(context.Background(), "jane@example.com", "password123", "", profile); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	u := store.users["jane@example.com"]
+	if u.DisplayName != "Jane Doe" {
+		t.Errorf("display name = %q", u.DisplayName)
+	}
+	if u.RegistrationMessage != "alert(1)Team ops, 1<2" {
+		t.Errorf("message = %q", u.RegistrationMessage)
+	}
+}
+
+func TestRegister_DisplayNameRequired(t *testing.T) {
+	store := newMemUserStore()
+	s := &Registration{users: store, now: time.Now, policy: RegistrationPolicy{RequireDisplayName: true}}
+
+	// Markup alone does not count as a name
+	_, err := s.Register(context.Background(), "anon@example.com", "password123", "", models.RegistrationProfile{DisplayName: "<i></i>"})
+	if !errors.Is(err, ErrDisplayNameRequired) {
+		t.Fatalf("error = %v, want ErrDisplayNameRequired", err)
+	}
+	if len(store.users) != 0 {
+		t.Error("user created without a display name")
+	}
+
+	if _, err := s.Register(context.Background(), "anon@example.com", "password123", "", models.RegistrationProfile{DisplayName: "Anon"}); err != nil {
+		t.Errorf("Register with name: %v", err)
+	}
+}
+
+func TestRegister_ConcealExistingNotifiesOwner(t *testing.T) {
+	for _, conceal := range []bool{false, true} {
+		store := newMemUserStore()
+		notifier := &recordingNotifier{}
+		s := &Registration{users: store, notifier: notifier, now: time.Now, policy: RegistrationPolicy{ConcealExisting: conceal}}
+
+		owner, err := s.Register(context.Background(), "owner@example.com", "password123", "", models.RegistrationProfile{})
+		if err != nil {
+			t.Fatalf("first registration failed: %v", err)
+		}
+		store.users["owner@example.com"].IsApproved = true
+
+		_, err = s.Register(context.Background(), "owner@example.com", "other-password", "", models.RegistrationProfile{})
+		if !errors.Is(err, repository.ErrUserAlreadyExists) {
+			t.Errorf("conceal=%v: error = %v, want ErrUserAlreadyExists", conceal, err)
+		}
+		if !conceal {
+			if len(notifier.sent) != 0 {
+				t.Errorf("owner notified without ConcealExisting")
+			}
+			continue
+		}
+		if len(notifier.sent) != 1 || notifier.sent[0].Kind != notify.KindRegistrationAttempt || notifier.sent[0].UserID != owner.ID {
+			t.Errorf("notifications = %+v, want one registration attempt to the owner", notifier.sent)
+		}
+	}
+}
+
+func TestRegister_Closed(t *testing.T) {
+	store := newMemUserStore()
+	s := &Registration{users: store, now: time.Now, policy: RegistrationPolicy{Mode: RegistrationClosed}}
+
+	_, err := s.Register(context.Background(), "closed@example.com", "password123", "", models.RegistrationProfile{})
+	if !errors.Is(err, ErrRegistrationClosed) || len(store.users) != 0 {
+		t.Errorf("error = %v, users = %d; want ErrRegistrationClosed and no user", err, len(store.users))
+	}
+}
+
+func TestRegister_AllowedDomains(t *testing.T) {
+	store := newMemUserStore()
+	s := &Registration{users: store, now: time.Now, policy: RegistrationPolicy{Mode: RegistrationOpen, AllowedDomains: []string{"Example.COM"}}}
+
+	for email, allowed := range map[string]bool{
+		"a@example.com":         true,
+		"b@EXAMPLE.com":         true,
+		"c@other.org":           false,
+		"d@sub.example.com":     false,
+		"e@example.com.evil.io": false,
+	} {
+		_, err := s.Register(context.Background(), email, "password123", "", models.RegistrationProfile{})
+		if allowed && err != nil {
+			t.Errorf("%s: %v", email, err)
+		}
+		if !allowed && !errors.Is(err, ErrEmailDomainNotAllowed) {
+			t.Errorf("%s: error = %v, want ErrEmailDomainNotAllowed", email, err)
+		}
+	}
+}
+
+func TestRegister_InviteMode(t *testing.T) {
+	store := newMemUserStore()
+	invites, inviteStore, _ := newTestInvites()
+	s := &Registration{users: store, invites: invites, now: time.Now, policy: RegistrationPolicy{Mode: RegistrationInvite}}
+	code, invite, _ := invites.Create(context.Background(), uuid.New(), "")
+
+	if _, err := s.Register(context.Background(), "a@example.com", "password123", "", models.RegistrationProfile{}); !errors.Is(err, ErrInviteRequired) {
+		t.Errorf("no code: %v, want ErrInviteRequired", err)
+	}
+	if _, err := s.Register(context.Background(), "a@example.com", "password123", "AAAA-BBBB-CCCC-DDDD", models.RegistrationProfile{}); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("unknown code: %v, want ErrInviteInvalid", err)
+	}
+
+	// A failed registration gives the invite back
+	store.users["taken@example.com"] = &models.User{ID: uuid.New(), Email: "taken@example.com", IsApproved: true}
+	if _, err := s.Register(context.Background(), "taken@example.com", "password123", code, models.RegistrationProfile{}); !errors.Is(err, repository.ErrUserAlreadyExists) {
+		t.Fatalf("taken email: %v", err)
+	}
+	if inviteStore.invites[invite.ID].UsedAt != nil {
+		t.Error("invite not released after a failed registration")
+	}
+
+	user, err := s.Register(context.Background(), "a@example.com", "password123", code, models.RegistrationProfile{})
+	if err != nil {
+		t.Fatalf("valid code: %v", err)
+	}
+	if used := inviteStore.invites[invite.ID].UsedBy; used == nil || *used != user.ID {
+		t.Errorf("invite used by %v, want %s", used, user.ID)
+	}
+
+	// A retried submission resolves to the same user; anyone else is refused
+	retry, err := s.Register(context.Background(), "a@example.com", "password123", code, models.RegistrationProfile{})
+	if err != nil || retry.ID != user.ID {
+		t.Errorf("retry = %v, %v; want the first user", retry, err)
+	}
+	if _, err := s.Register(context.Background(), "b@example.com", "password123", code, models.RegistrationProfile{}); !errors.Is(err, ErrInviteInvalid) {
+		t.Errorf("reused code: %v, want ErrInviteInvalid", err)
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import "github.com/sprobst76/vibedterm-server/internal/models"
+
+// VerifySyncChain recomputes the hash chain of a user's sync log, given
+// oldest first, and reports the first entry that does not verify. The chain
+// starts at seq 1, or where retention cut it if retention is not nil.
+func VerifySyncChain(logs []models.SyncLog, retention *models.SyncChainRetention) *models.SyncChainReport {
+	report := &models.SyncChainReport{Valid: true, Entries: len(logs)}
+	seq, prevHash := int64(1), ""
+	if retention != nil {
+		seq, prevHash = retention.Seq, retention.PrevHash
+		report.PrunedBefore = retention.Seq
+	}
+	if len(logs) == 0 && retention != nil {
+		// Retention always keeps the head
+		report.Valid = false
+		report.FirstDivergence = &models.SyncChainDivergence{Seq: seq, Reason: models.SyncChainTruncated}
+		return report
+	}
+	for i := range logs {
+		entry := &logs[i]
+
+		var reason string
+		switch {
+		case i == 0 && entry.Seq > seq:
+			reason = models.SyncChainTruncated
+		case entry.Seq != seq:
+			reason = models.SyncChainSequenceGap
+		case entry.PrevHash != prevHash:
+			reason = models.SyncChainBrokenLink
+		case entry.ComputeHash() != entry.EntryHash:
+			reason = models.SyncChainHashMismatch
+		}
+		if reason != "" {
+			report.Valid = false
+			report.FirstDivergence = &models.SyncChainDivergence{Seq: entry.Seq, ID: entry.ID, Reason: reason}
+			return report
+		}
+		report.HeadHash = entry.EntryHash
+		seq, prevHash = entry.Seq+1, entry.EntryHash
+	}
+	return report
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/database"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// syncChainTestEnv writes through the real repositories of a fresh user
+type syncChainTestEnv struct {
+	user     uuid.UUID
+	device   uuid.UUID
+	sync     *VaultSync
+	trash    *VaultTrash
+	syncLogs *repository.SyncLogRepository
+}
+
+func newSyncChainTestEnv(t *testing.T) *syncChainTestEnv {
+	t.Helper()
+	testDatabase(t)
+	ctx := context.Background()
+	db := database.DB
+
+	user, err := repository.NewUserRepository(db).Create(ctx, "chain-"+uuid.NewString()+"@example.com", "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	devices := repository.NewDeviceRepository(db, false)
+	device, _, err := devices.Create(ctx, user.ID, "Laptop", "linux", "", "")
+	if err != nil {
+		t.Fatalf("create device: %v", err)
+	}
+	vaults := repository.NewVaultRepository(db, 10, 0)
+	syncLogs := repository.NewSyncLogRepository(db)
+	return &syncChainTestEnv{
+		user:     user.ID,
+		device:   device.ID,
+		sync:     NewVaultSync(vaults, syncLogs, devices, NewVaultMigration(repository.NewSettingRepository(db), vaults), 0),
+		trash:    NewVaultTrash(vaults, syncLogs, time.Hour),
+		syncLogs: syncLogs,
+	}
+}
+
+func (e *syncChainTestEnv) push(t *testing.T, revision int, blob string) *models.VaultPushVerdict {
+	t.Helper()
+	req := &models.VaultPushRequest{VaultBlob: base64.StdEncoding.EncodeToString([]byte(blob)), Revision: revision}
+	verdict, _, err := e.sync.Push(context.Background(), e.user, e.device, req)
+	if err != nil {
+		t.Fatalf("push at revision %d: %v", revision, err)
+	}
+	return verdict
+}
+
+func (e *syncChainTestEnv) chain(t *testing.T) []models.SyncLog {
+	t.Helper()
+	chain, err := e.syncLogs.GetChain(context.Background(), e.user)
+	if err != nil {
+		t.Fatalf("chain: %v", err)
+	}
+	return chain
+}
+
+// Every kind of vault write joins the chain in order, and a conflicting
+// push leaves no entry behind
+func TestSyncChain_RealWrites(t *testing.T) {
+	env := newSyncChainTestEnv(t)
+	ctx := context.Background()
+
+	if v := env.push(t, 0, "first"); !v.Valid {
+		t.Fatalf("initial push: %+v", v)
+	}
+	if v := env.push(t, 1, "second"); !v.Valid {
+		t.Fatalf("push: %+v", v)
+	}
+	if v := env.push(t, 1, "stale"); v.Valid || !v.WouldConflict {
+		t.Fatalf("stale push: %+v, want a conflict", v)
+	}
+	stream, err := env.sync.Pull(ctx, env.user, env.device, models.DefaultVaultName)
+	if err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	stream.Close()
+	if _, err := env.sync.ForceOverwrite(ctx, env.user, env.device, models.DefaultVaultName, []byte("wiped"), 0); err != nil {
+		t.Fatalf("force overwrite: %v", err)
+	}
+	if _, err := env.trash.Delete(ctx, env.user, env.device, models.DefaultVaultName); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := env.trash.Undelete(ctx, env.user, env.device, models.DefaultVaultName); err != nil {
+		t.Fatalf("undelete: %v", err)
+	}
+
+	chain := env.chain(t)
+	want := []string{"push_initial", "push", "pull", "force_overwrite", "delete", "undelete"}
+	if report := VerifySyncChain(chain, nil); !report.Valid || report.Entries != len(want) {
+		t.Fatalf("chain = %+v, want %d valid entries", report, len(want))
+	}
+	for i, action := range want {
+		if chain[i].Seq != int64(i+1) || chain[i].Action != action {
+			t.Errorf("entry %d = %d %s, want %d %s", i, chain[i].Seq, chain[i].Action, i+1, action)
+		}
+	}
+	all, err := env.syncLogs.GetByUserID(ctx, env.user, 100)
+	if err != nil {
+		t.Fatalf("sync logs: %v", err)
+	}
+	if len(all) != len(chain) {
+		t.Errorf("%d entries, %d chained", len(all), len(chain))
+	}
+}
+
+func TestSyncChain_DetectsTampering(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name   string
+		tamper string
+		reason string
+		after  int64 // entries between the tampered one and the divergence
+	}{
+		{"altered", `UPDATE sync_logs SET revision_after = 99 WHERE id = $1`, models.SyncChainHashMismatch, 0},
+		{"removed", `DELETE FROM sync_logs WHERE id = $1`, models.SyncChainSequenceGap, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newSyncChainTestEnv(t)
+			for revision := 0; revision < 3; revision++ {
+				if v := env.push(t, revision, "blob"); !v.Valid {
+					t.Fatalf("push at revision %d: %+v", revision, v)
+				}
+			}
+			target := env.chain(t)[1]
+			if _, err := database.DB.Exec(ctx, tc.tamper, target.ID); err != nil {
+				t.Fatalf("tamper: %v", err)
+			}
+
+			report := VerifySyncChain(env.chain(t), nil)
+			if report.Valid || report.FirstDivergence == nil || report.FirstDivergence.Reason != tc.reason {
+				t.Fatalf("report = %+v, want %s", report, tc.reason)
+			}
+			if want := target.Seq + tc.after; report.FirstDivergence.Seq != want {
+				t.Errorf("divergence at %d, want %d", report.FirstDivergence.Seq, want)
+			}
+		})
+	}
+}
+
+// Retention prunes the oldest entries but keeps the head, and the rest of
+// the chain still verifies against the recorded cut
+func TestSyncChain_Retention(t *testing.T) {
+	env := newSyncChainTestEnv(t)
+	ctx := context.Background()
+	for revision := 0; revision < 4; revision++ {
+		if v := env.push(t, revision, "blob"); !v.Valid {
+			t.Fatalf("push at revision %d: %+v", revision, v)
+		}
+	}
+	if _, err := database.DB.Exec(ctx, `
+		UPDATE sync_logs SET created_at = created_at - INTERVAL '2 days' WHERE user_id = $1 AND seq <= 2
+	`, env.user); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+
+	verify := func(wantSeqs ...int64) {
+		t.Helper()
+		chain := env.chain(t)
+		retention, err := env.syncLogs.GetRetention(ctx, env.user)
+		if err != nil {
+			t.Fatalf("retention: %v", err)
+		}
+		if report := VerifySyncChain(chain, retention); !report.Valid || report.Entries != len(wantSeqs) {
+			t.Fatalf("chain = %+v, want %d valid entries", report, len(wantSeqs))
+		}
+		for i, seq := range wantSeqs {
+			if chain[i].Seq != seq {
+				t.Errorf("entry %d has seq %d, want %d", i, chain[i].Seq, seq)
+			}
+		}
+		if report := VerifySyncChain(chain, nil); report.Valid {
+			t.Errorf("pruned chain verifies without its retention: %+v", report)
+		}
+	}
+
+	if _, err := env.syncLogs.DeleteOld(ctx, 24*time.Hour); err != nil {
+		t.Fatalf("delete old: %v", err)
+	}
+	verify(3, 4)
+
+	// Everything is old now; the head stays for the chain to continue
+	if _, err := env.syncLogs.DeleteOld(ctx, -time.Hour); err != nil {
+		t.Fatalf("delete old: %v", err)
+	}
+	verify(4)
+	if v := env.push(t, 4, "blob"); !v.Valid {
+		t.Fatalf("push after pruning: %+v", v)
+	}
+	verify(4, 5)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// memSyncChain chains entries like SyncLogRepository
+type memSyncChain struct{ logs []models.SyncLog }
+
+func (m *memSyncChain) Create(_ context.Context, userID uuid.UUID, deviceID *uuid.UUID, vault, action string, before, after *int) error {
+	stored := func(n *int) *int {
+		if n == nil {
+			return nil
+		}
+		v := *n
+		return &v
+	}
+	m.append(models.SyncLog{ID: uuid.New(), UserID: userID, DeviceID: deviceID, Vault: vault, Action: action, RevisionBefore: stored(before), RevisionAfter: stored(after), CreatedAt: time.Now()})
+	return nil
+}
+
+func (m *memSyncChain) add(entry *models.SyncLog) {
+	m.append(*entry)
+}
+
+func (m *memSyncChain) append(entry models.SyncLog) {
+	var head *models.SyncLog
+	if len(m.logs) > 0 {
+		head = &m.logs[len(m.logs)-1]
+	}
+	entry.ChainAfter(head)
+	m.logs = append(m.logs, entry)
+}
+
+func TestSyncChain_ContinuityAndTampering(t *testing.T) {
+	ctx := context.Background()
+	userID, deviceID := uuid.New(), uuid.New()
+	blob := base64.StdEncoding.EncodeToString([]byte("encrypted"))
+	logs := &memSyncChain{}
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}, logs: logs}
+	s := NewVaultSync(vaults, logs, &memDeviceSync{owners: map[uuid.UUID]uuid.UUID{deviceID: userID}}, fixedCampaign{}, 0)
+
+	push := func(revision int, wantCode string) {
+		t.Helper()
+		verdict, _, err := s.Push(ctx, userID, deviceID, &models.VaultPushRequest{VaultBlob: blob, Revision: revision})
+		if err != nil || verdict.Code != wantCode {
+			t.Fatalf("push at revision %d = %+v, %v; want code %q", revision, verdict, err, wantCode)
+		}
+	}
+	push(0, "")
+	push(1, "")
+	push(1, PushCodeConflict) // conflicts write nothing and leave the chain alone
+	push(2, "")
+
+	if _, err := s.ForceOverwrite(ctx, userID, deviceID, "", []byte("fresh"), 1); err != nil {
+		t.Fatal(err)
+	}
+	push(4, "")
+
+	report := VerifySyncChain(logs.logs, nil)
+	if !report.Valid || report.Entries != 5 || report.HeadHash != logs.logs[4].EntryHash {
+		t.Fatalf("intact chain = %+v", report)
+	}
+	for i, entry := range logs.logs {
+		if entry.Seq != int64(i+1) {
+			t.Errorf("entry %d has seq %d", i, entry.Seq)
+		}
+	}
+
+	// Pruning the oldest entries keeps the rest verifiable against the
+	// recorded cut, and only against it
+	pruned := &models.SyncChainRetention{Seq: 3, PrevHash: logs.logs[1].EntryHash}
+	if report := VerifySyncChain(logs.logs[2:], pruned); !report.Valid || report.PrunedBefore != 3 {
+		t.Errorf("pruned chain = %+v", report)
+	}
+	for name, tc := range map[string]struct {
+		logs      []models.SyncLog
+		retention *models.SyncChainRetention
+		wantSeq   int64
+		reason    string
+	}{
+		"unrecorded cut":       {logs.logs[2:], nil, 3, models.SyncChainTruncated},
+		"cut beyond retention": {logs.logs[3:], pruned, 4, models.SyncChainTruncated},
+		"forged retention":     {logs.logs[2:], &models.SyncChainRetention{Seq: 3, PrevHash: "forged"}, 3, models.SyncChainBrokenLink},
+		"all entries removed":  {nil, pruned, 3, models.SyncChainTruncated},
+	} {
+		report := VerifySyncChain(tc.logs, tc.retention)
+		if d := report.FirstDivergence; report.Valid || d == nil || d.Seq != tc.wantSeq || d.Reason != tc.reason {
+			t.Errorf("%s: report = %+v, want seq %d %s", name, report, tc.wantSeq, tc.reason)
+		}
+	}
+
+	tampered := func(name string, wantSeq int64, wantReason string, alter func(logs []models.SyncLog) []models.SyncLog) {
+		t.Run(name, func(t *testing.T) {
+			altered := alter(append([]models.SyncLog(nil), logs.logs...))
+			report := VerifySyncChain(altered, nil)
+			if report.Valid || report.FirstDivergence == nil {
+				t.Fatalf("tampering not detected: %+v", report)
+			}
+			if d := report.FirstDivergence; d.Seq != wantSeq || d.Reason != wantReason {
+				t.Errorf("divergence = %+v, want seq %d %s", d, wantSeq, wantReason)
+			}
+		})
+	}
+	tampered("altered row", 3, models.SyncChainHashMismatch, func(l []models.SyncLog) []models.SyncLog {
+		l[2].Action = "pull"
+		return l
+	})
+	tampered("altered row with recomputed hash", 4, models.SyncChainBrokenLink, func(l []models.SyncLog) []models.SyncLog {
+		l[2].CreatedAt = l[2].CreatedAt.Add(-time.Hour)
+		l[2].EntryHash = l[2].ComputeHash()
+		return l
+	})
+	tampered("deleted row", 4, models.SyncChainSequenceGap, func(l []models.SyncLog) []models.SyncLog {
+		return append(l[:2], l[3:]...)
+	})
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
+)
+
+// totpGuardCleanupInterval is how often Run forgets idle users
+const totpGuardCleanupInterval = time.Minute
+
+var (
+	ErrTOTPInvalid   = errors.New("invalid TOTP code")
+	ErrTOTPReused    = errors.New("TOTP code already used")
+	ErrTOTPThrottled = errors.New("too many TOTP attempts")
+)
+
+// TOTPThrottledError is returned while a user has no attempts left. It
+// matches ErrTOTPThrottled.
+type TOTPThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TOTPThrottledError) Error() string {
+	return fmt.Sprintf("%v, retry in %v", ErrTOTPThrottled, e.RetryAfter)
+}
+
+func (e *TOTPThrottledError) Is(target error) bool {
+	return target == ErrTOTPThrottled
+}
+
+// totpAttempts is the second-factor state of one user
+type totpAttempts struct {
+	at       []time.Time // attempts within the window, oldest first
+	lastStep uint64      // time step of the last accepted code
+	reported bool        // the current throttling is in the audit log
+}
+
+// TOTPGuard throttles second-factor attempts per user and rejects TOTP
+// codes of a time step that was already used. State is kept in memory,
+// so limits apply per server instance.
+type TOTPGuard struct {
+	mu     sync.Mutex
+	users  map[uuid.UUID]*totpAttempts
+	limit  int
+	window time.Duration
+	audit  adminAuditStore
+	now    func() time.Time
+}
+
+// NewTOTPGuard allows limit attempts per user within window. A limit or
+// window <= 0 disables throttling; replayed codes are rejected regardless.
+func NewTOTPGuard(audit adminAuditStore, limit int, window time.Duration) *TOTPGuard {
+	return &TOTPGuard{
+		users:  make(map[uuid.UUID]*totpAttempts),
+		limit:  limit,
+		window: window,
+		audit:  audit,
+		now:    time.Now,
+	}
+}
+
+// Check counts an attempt and validates code against the user's secret.
+// An accepted code resets the attempts. It returns ErrTOTPThrottled,
+// ErrTOTPInvalid or ErrTOTPReused.
+func (g *TOTPGuard) Check(ctx context.Context, user *models.User, code, surface string) error {
+	g.mu.Lock()
+	now := g.now()
+	state, report, err := g.attempt(user.ID, now)
+	if err == nil {
+		step, ok := totpsecret.Match(code, user.TOTPSecret, now)
+		switch {
+		case !ok:
+			err = ErrTOTPInvalid
+		case step <= state.lastStep:
+			err = ErrTOTPReused
+		default:
+			state.lastStep = step
+			state.at = nil
+		}
+	}
+	g.mu.Unlock()
+	if report {
+		g.reportThrottled(ctx, user, surface)
+	}
+	return err
+}
+
+// Attempt counts a second-factor attempt checked elsewhere, such as a
+// recovery code. It returns ErrTOTPThrottled once none are left.
+func (g *TOTPGuard) Attempt(ctx context.Context, user *models.User, surface string) error {
+	g.mu.Lock()
+	_, report, err := g.attempt(user.ID, g.now())
+	g.mu.Unlock()
+	if report {
+		g.reportThrottled(ctx, user, surface)
+	}
+	return err
+}
+
+// attempt records an attempt of userID unless the user is throttled.
+// report is set for the first rejection of a throttling period. The
+// caller holds g.mu.
+func (g *TOTPGuard) attempt(userID uuid.UUID, now time.Time) (state *totpAttempts, report bool, err error) {
+	state = g.users[userID]
+	if state == nil {
+		state = &totpAttempts{}
+		g.users[userID] = state
+	}
+	if g.limit <= 0 || g.window <= 0 {
+		return state, false, nil
+	}
+	state.prune(now.Add(-g.window))
+	if len(state.at) >= g.limit {
+		report = !state.reported
+		state.reported = true
+		return state, report, &TOTPThrottledError{RetryAfter: state.at[0].Add(g.window).Sub(now)}
+	}
+	state.at = append(state.at, now)
+	return state, false, nil
+}
+
+// reportThrottled records the start of a throttling period in the audit
+// log
+func (g *TOTPGuard) reportThrottled(ctx context.Context, user *models.User, surface string) {
+	log.Warn().Str("user_id", user.ID.String()).Str("surface", surface).Msg("TOTP attempts throttled")
+	event := &models.AuditEvent{
+		Action:   models.AuditTOTPThrottled,
+		TargetID: &user.ID,
+		Details: map[string]string{
+			"email":    user.Email,
+			"surface":  surface,
+			"attempts": strconv.Itoa(g.limit),
+			"window":   g.window.String(),
+		},
+	}
+	if err := g.audit.Create(ctx, event); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to record audit event")
+	}
+}
+
+// prune drops attempts before cutoff and ends a reported throttling
+// period once attempts are available again
+func (s *totpAttempts) prune(cutoff time.Time) {
+	i := 0
+	for i < len(s.at) && !s.at[i].After(cutoff) {
+		i++
+	}
+	s.at = s.at[i:]
+	if i > 0 {
+		s.reported = false
+	}
+}
+
+// Cleanup forgets users without recent attempts whose last accepted
+// code can no longer be replayed. It returns the number of users
+// forgotten.
+func (g *TOTPGuard) Cleanup() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	w := totpsecret.CurrentWindow()
+	replayable := time.Duration(w.Period*(w.Skew+1)) * time.Second
+	oldestStep := uint64(now.Add(-replayable).Unix()) / uint64(w.Period)
+	removed := 0
+	for id, state := range g.users {
+		state.prune(now.Add(-g.window))
+		if len(state.at) == 0 && state.lastStep < oldestStep {
+			delete(g.users, id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Run calls Cleanup periodically until ctx is done
+func (g *TOTPGuard) Run(ctx context.Context) {
+	ticker := time.NewTicker(totpGuardCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.Cleanup()
+		}
+	}
+}
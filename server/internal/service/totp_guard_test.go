@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
+)
+
+func newTOTPGuardTest(t *testing.T) (*TOTPGuard, *fakeAdminStores, *models.User, *time.Time) {
+	t.Helper()
+	_, secret, err := totpsecret.Generate("VibedTerm", "user@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFakeAdminStores()
+	g := NewTOTPGuard(fakeAudit{f}, 3, 5*time.Minute)
+	now := time.Unix(1_700_000_010, 0)
+	g.now = func() time.Time { return now }
+	user := &models.User{ID: uuid.New(), Email: "user@example.com", TOTPEnabled: true, TOTPSecret: secret}
+	return g, f, user, &now
+}
+
+func totpCodeAt(t *testing.T, user *models.User, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCode(totpsecret.Encode(user.TOTPSecret), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPGuard_RejectsReplay(t *testing.T) {
+	g, _, user, now := newTOTPGuardTest(t)
+	ctx := context.Background()
+
+	code := totpCodeAt(t, user, *now)
+	if err := g.Check(ctx, user, code, "api"); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := g.Check(ctx, user, code, "web"); !errors.Is(err, ErrTOTPReused) {
+		t.Fatalf("replay: error = %v, want ErrTOTPReused", err)
+	}
+	// The previous step is still in the skew window but older than the used one
+	if err := g.Check(ctx, user, totpCodeAt(t, user, now.Add(-30*time.Second)), "api"); !errors.Is(err, ErrTOTPReused) {
+		t.Errorf("older step: error = %v, want ErrTOTPReused", err)
+	}
+
+	*now = now.Add(30 * time.Second)
+	if err := g.Check(ctx, user, totpCodeAt(t, user, *now), "api"); err != nil {
+		t.Errorf("next step: %v", err)
+	}
+}
+
+func TestTOTPGuard_Throttles(t *testing.T) {
+	g, f, user, now := newTOTPGuardTest(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := g.Check(ctx, user, "000000", "api"); !errors.Is(err, ErrTOTPInvalid) {
+			t.Fatalf("attempt %d: error = %v, want ErrTOTPInvalid", i+1, err)
+		}
+	}
+	if err := g.Attempt(ctx, user, "api"); err != nil {
+		t.Fatalf("recovery attempt: %v", err)
+	}
+
+	// Even a valid code is refused while throttled
+	err := g.Check(ctx, user, totpCodeAt(t, user, *now), "web")
+	var throttled *TOTPThrottledError
+	if !errors.As(err, &throttled) || !errors.Is(err, ErrTOTPThrottled) || throttled.RetryAfter != 5*time.Minute {
+		t.Fatalf("error = %v, want throttled for 5m", err)
+	}
+	if err := g.Attempt(ctx, user, "api"); !errors.Is(err, ErrTOTPThrottled) {
+		t.Fatalf("recovery attempt: error = %v, want ErrTOTPThrottled", err)
+	}
+	if len(f.audit) != 1 || f.audit[0].Action != models.AuditTOTPThrottled || f.audit[0].Details["surface"] != "web" {
+		t.Fatalf("audit = %+v, want one %s event", f.audit, models.AuditTOTPThrottled)
+	}
+
+	*now = now.Add(5*time.Minute + time.Second)
+	if err := g.Check(ctx, user, totpCodeAt(t, user, *now), "api"); err != nil {
+		t.Errorf("after the window: %v", err)
+	}
+	// An accepted code resets the attempts
+	for i := 0; i < 3; i++ {
+		if err := g.Attempt(ctx, user, "api"); err != nil {
+			t.Fatalf("attempt %d after success: %v", i+1, err)
+		}
+	}
+}
+
+func TestTOTPGuard_Cleanup(t *testing.T) {
+	g, _, user, now := newTOTPGuardTest(t)
+	ctx := context.Background()
+
+	if err := g.Check(ctx, user, totpCodeAt(t, user, *now), "api"); err != nil {
+		t.Fatal(err)
+	}
+	if n := g.Cleanup(); n != 0 {
+		t.Fatalf("Cleanup removed %d users while their code is replayable", n)
+	}
+	*now = now.Add(2 * time.Minute)
+	if n := g.Cleanup(); n != 1 {
+		t.Errorf("Cleanup removed %d users, want 1", n)
+	}
+}
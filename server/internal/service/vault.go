@@ -0,0 +1,513 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// Push verdict codes
+const (
+	PushCodeInvalidEncoding = "INVALID_ENCODING"
+	PushCodeConflict        = "CONFLICT"
+	PushCodeMigrationNeeded = "MIGRATION_REQUIRED"
+	PushCodeChecksum        = "CHECKSUM_MISMATCH"
+	PushCodeVaultLimit      = "VAULT_LIMIT"
+	PushCodeDowngrade       = "VAULT_VERSION_DOWNGRADE"
+	PushCodeUnsupported     = "VAULT_VERSION_UNSUPPORTED"
+	PushCodeReadOnly        = "DEVICE_READ_ONLY"
+)
+
+var (
+	// ErrVaultVersionRefused is returned by Restore and ForceOverwrite if
+	// the migration campaign no longer accepts the version of the vault
+	ErrVaultVersionRefused = errors.New("vault version is no longer accepted")
+	// ErrVaultVersionUnsupported is returned by Restore and ForceOverwrite
+	// for a vault version above the highest one the server accepts
+	ErrVaultVersionUnsupported = errors.New("vault version is not supported yet")
+	// ErrForeignDevice is returned by ForceOverwrite for a device that
+	// does not belong to the user
+	ErrForeignDevice = errors.New("device does not belong to the user")
+	// ErrDeviceReadOnly is returned by ForceOverwrite and CheckWritable
+	// for a device that may only pull
+	ErrDeviceReadOnly = errors.New("device is read-only")
+	// ErrVaultLimit is returned by ForceOverwrite if creating the vault
+	// would exceed the per-user vault limit
+	ErrVaultLimit = repository.ErrVaultLimit
+)
+
+// vaultStore is the subset of VaultRepository needed for vault sync
+type vaultStore interface {
+	GetByUserID(ctx context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error)
+	OpenBlob(ctx context.Context, userID uuid.UUID, name string) (*models.VaultBlobStream, error)
+	GetRevision(ctx context.Context, userID uuid.UUID, name string) (int, error)
+	CreateAllowed(ctx context.Context, userID uuid.UUID, name string) (bool, error)
+	Create(ctx context.Context, userID uuid.UUID, name string, vaultBlob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, error)
+	UpdateWithRevisionCheck(ctx context.Context, userID uuid.UUID, name string, vaultBlob []byte, expectedRevision, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, error)
+	Overwrite(ctx context.Context, userID uuid.UUID, name string, vaultBlob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, bool, error)
+	HistoryRevision(ctx context.Context, userID uuid.UUID, name string, revision int) (*models.VaultRevision, error)
+	Restore(ctx context.Context, userID uuid.UUID, name string, revision int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, error)
+}
+
+// migrationCampaignSource is the subset of VaultMigration needed for vault sync
+type migrationCampaignSource interface {
+	Campaign(ctx context.Context) (MigrationCampaign, error)
+}
+
+// syncLogWriter is the subset of SyncLogRepository needed to log vault
+// changes
+type syncLogWriter interface {
+	Create(ctx context.Context, userID uuid.UUID, deviceID *uuid.UUID, vault, action string, revisionBefore, revisionAfter *int) error
+}
+
+// deviceSyncStore is the subset of DeviceRepository needed for vault sync
+type deviceSyncStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error)
+	UpdateLastSync(ctx context.Context, id uuid.UUID, revision int) error
+	UpdateActivity(ctx context.Context, id uuid.UUID, ip, userAgent string) error
+}
+
+// VaultSync implements vault pushes. Push and ValidatePush share one
+// validation path so the dry run cannot drift from the real thing.
+type VaultSync struct {
+	vaults     vaultStore
+	syncLogs   syncLogWriter
+	devices    deviceSyncStore
+	campaign   migrationCampaignSource
+	maxVersion int
+	now        func() time.Time
+}
+
+// NewVaultSync creates a new vault sync service. Vault versions above
+// maxVersion are rejected; 0 accepts any.
+func NewVaultSync(vaults vaultStore, syncLogs syncLogWriter, devices deviceSyncStore, campaign migrationCampaignSource, maxVersion int) *VaultSync {
+	return &VaultSync{
+		vaults:     vaults,
+		syncLogs:   syncLogs,
+		devices:    devices,
+		campaign:   campaign,
+		maxVersion: maxVersion,
+		now:        time.Now,
+	}
+}
+
+// pushPlan is a validated push ready to be applied
+type pushPlan struct {
+	verdict models.VaultPushVerdict
+	name    string
+	blob    []byte
+	version int
+	current *models.EncryptedVault
+}
+
+// vaultName returns name, or DefaultVaultName if it is empty
+func vaultName(name string) string {
+	if name == "" {
+		return models.DefaultVaultName
+	}
+	return name
+}
+
+// supportsVersion reports whether the server accepts vaultVersion at all
+func (s *VaultSync) supportsVersion(vaultVersion int) bool {
+	return s.maxVersion <= 0 || vaultVersion <= s.maxVersion
+}
+
+// writeLog logs a vault write by deviceID as action, and as a version
+// change if it replaced a vault of vault version before. A before of 0
+// skips the version check.
+func writeLog(userID, deviceID uuid.UUID, action string, before int) repository.VaultWriteLog {
+	return func(vault *models.EncryptedVault, replaced bool) []*models.SyncLog {
+		var revisionBefore *int
+		if replaced {
+			revision := vault.Revision - 1
+			revisionBefore = &revision
+		}
+		logs := []*models.SyncLog{models.NewSyncLog(userID, &deviceID, vault.Name, action, revisionBefore, &vault.Revision)}
+		if replaced && before != 0 && before != vault.VaultVersion {
+			logs = append(logs, models.NewVersionChangeLog(userID, &deviceID, vault.Name, vault.Revision, before, vault.VaultVersion))
+		}
+		return logs
+	}
+}
+
+// recordSync updates the revision last seen by deviceID and the client it
+// synced from. Device lag is only tracked for the default vault, and not
+// at all for callers without a device.
+func (s *VaultSync) recordSync(ctx context.Context, deviceID uuid.UUID, name string, revision int) {
+	if deviceID == uuid.Nil {
+		return
+	}
+	if name == models.DefaultVaultName {
+		_ = s.devices.UpdateLastSync(ctx, deviceID, revision)
+	}
+	ip, userAgent := events.Client(ctx)
+	_ = s.devices.UpdateActivity(ctx, deviceID, ip, userAgent)
+}
+
+// decodePush decodes req.VaultBlob, or returns the plan rejecting it
+func decodePush(req *models.VaultPushRequest) ([]byte, *pushPlan) {
+	blob, err := base64.StdEncoding.DecodeString(req.VaultBlob)
+	if err != nil {
+		return nil, &pushPlan{verdict: models.VaultPushVerdict{
+			LocalRevision: req.Revision,
+			Code:          PushCodeInvalidEncoding,
+			Error:         "invalid vault blob encoding",
+		}}
+	}
+	return blob, nil
+}
+
+// planPush runs every push check on the decoded blob without writing
+// anything
+func (s *VaultSync) planPush(ctx context.Context, userID uuid.UUID, req *models.VaultPushRequest, blob []byte) (*pushPlan, error) {
+	plan := &pushPlan{
+		verdict: models.VaultPushVerdict{LocalRevision: req.Revision},
+		name:    vaultName(req.Name),
+		blob:    blob,
+	}
+	v := &plan.verdict
+
+	v.SizeBytes = len(blob)
+	if req.SHA256 != "" && !strings.EqualFold(req.SHA256, models.BlobChecksum(blob)) {
+		v.Code, v.Error = PushCodeChecksum, "sha256 does not match the vault blob"
+		return plan, nil
+	}
+
+	current, err := s.vaults.GetByUserID(ctx, userID, plan.name)
+	if err != nil && !errors.Is(err, repository.ErrVaultNotFound) {
+		return nil, err
+	}
+	plan.current = current
+
+	plan.version = req.VaultVersion
+	if plan.version == 0 {
+		plan.version = 1
+		if current != nil {
+			plan.version = current.VaultVersion
+		}
+	}
+	if current != nil {
+		v.ServerRevision = current.Revision
+		v.ServerVersion = current.VaultVersion
+		v.ServerUpdated = current.UpdatedAt.Unix()
+		if current.UpdatedByDevice != nil {
+			v.ServerDeviceID = current.UpdatedByDevice.String()
+		}
+	}
+	if !s.supportsVersion(plan.version) {
+		v.Code, v.Error = PushCodeUnsupported, "vault version is not supported by the server yet"
+		return plan, nil
+	}
+	refused, err := s.RefusesVersion(ctx, plan.version)
+	if err != nil {
+		return nil, err
+	}
+	if refused {
+		v.Code, v.Error = PushCodeMigrationNeeded, "vault version is no longer accepted, migrate the vault first"
+		return plan, nil
+	}
+	if current != nil && plan.version < current.VaultVersion && !req.Force {
+		v.Code, v.Error = PushCodeDowngrade, "vault version is older than the stored one, update the app or push with force"
+		return plan, nil
+	}
+
+	if current == nil {
+		allowed, err := s.vaults.CreateAllowed(ctx, userID, plan.name)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			v.Code, v.Error = PushCodeVaultLimit, "vault limit reached, delete a vault first"
+			return plan, nil
+		}
+		v.Valid = true
+		v.WouldCreate = true
+		v.NextRevision = 1
+		return plan, nil
+	}
+
+	if req.Revision != current.Revision {
+		v.WouldConflict = true
+		v.Code, v.Error = PushCodeConflict, "revision mismatch"
+		return plan, nil
+	}
+
+	v.Valid = true
+	v.NextRevision = current.Revision + 1
+	return plan, nil
+}
+
+// RefusesVersion reports whether the migration campaign rejects writes of
+// vaultVersion right now
+func (s *VaultSync) RefusesVersion(ctx context.Context, vaultVersion int) (bool, error) {
+	campaign, err := s.campaign.Campaign(ctx)
+	if err != nil {
+		return false, err
+	}
+	return campaign.Refuses(vaultVersion, s.now()), nil
+}
+
+// ValidatePush reports what Push would do with req, without writing
+// anything or logging a sync entry
+func (s *VaultSync) ValidatePush(ctx context.Context, userID uuid.UUID, req *models.VaultPushRequest) (*models.VaultPushVerdict, error) {
+	blob, rejected := decodePush(req)
+	if rejected != nil {
+		return &rejected.verdict, nil
+	}
+	return s.ValidatePushBlob(ctx, userID, req, blob)
+}
+
+// ValidatePushBlob is ValidatePush for a request whose blob was already
+// decoded
+func (s *VaultSync) ValidatePushBlob(ctx context.Context, userID uuid.UUID, req *models.VaultPushRequest, blob []byte) (*models.VaultPushVerdict, error) {
+	plan, err := s.planPush(ctx, userID, req, blob)
+	if err != nil {
+		return nil, err
+	}
+	return &plan.verdict, nil
+}
+
+// Push validates and applies req. If the verdict is not valid nothing is
+// written and the returned vault is nil.
+func (s *VaultSync) Push(ctx context.Context, userID, deviceID uuid.UUID, req *models.VaultPushRequest) (*models.VaultPushVerdict, *models.EncryptedVault, error) {
+	blob, rejected := decodePush(req)
+	if rejected != nil {
+		return &rejected.verdict, nil, nil
+	}
+	return s.PushBlob(ctx, userID, deviceID, req, blob)
+}
+
+// PushBlob is Push for a request whose blob was already decoded.
+// req.VaultBlob is ignored.
+func (s *VaultSync) PushBlob(ctx context.Context, userID, deviceID uuid.UUID, req *models.VaultPushRequest, blob []byte) (*models.VaultPushVerdict, *models.EncryptedVault, error) {
+	if err := s.CheckWritable(ctx, deviceID); errors.Is(err, ErrDeviceReadOnly) {
+		return &models.VaultPushVerdict{LocalRevision: req.Revision, Code: PushCodeReadOnly, Error: "device is read-only"}, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := s.planPush(ctx, userID, req, blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !plan.verdict.Valid {
+		return &plan.verdict, nil, nil
+	}
+
+	// Handle first vault creation
+	if plan.current == nil {
+		vault, err := s.vaults.Create(ctx, userID, plan.name, plan.blob, plan.version, &deviceID, writeLog(userID, deviceID, "push_initial", 0))
+		if errors.Is(err, repository.ErrVaultConflict) || errors.Is(err, repository.ErrVaultLimit) {
+			return s.conflictAfterRace(ctx, userID, req, blob)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		s.recordSync(ctx, deviceID, plan.name, vault.Revision)
+		return &plan.verdict, vault, nil
+	}
+
+	oldRevision := plan.current.Revision
+	vault, err := s.vaults.UpdateWithRevisionCheck(ctx, userID, plan.name, plan.blob, oldRevision, plan.version, &deviceID,
+		writeLog(userID, deviceID, "push", plan.current.VaultVersion))
+	if errors.Is(err, repository.ErrVaultConflict) {
+		return s.conflictAfterRace(ctx, userID, req, blob)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	s.recordSync(ctx, deviceID, plan.name, vault.Revision)
+	return &plan.verdict, vault, nil
+}
+
+// conflictAfterRace reports a push that lost against another write made
+// after it was planned. The new plan fills in the server side.
+func (s *VaultSync) conflictAfterRace(ctx context.Context, userID uuid.UUID, req *models.VaultPushRequest, blob []byte) (*models.VaultPushVerdict, *models.EncryptedVault, error) {
+	plan, err := s.planPush(ctx, userID, req, blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if plan.verdict.Valid {
+		plan.verdict.Valid = false
+		plan.verdict.WouldCreate = false
+		plan.verdict.WouldConflict = true
+		plan.verdict.NextRevision = 0
+		plan.verdict.Code, plan.verdict.Error = PushCodeConflict, "revision mismatch"
+	}
+	return &plan.verdict, nil, nil
+}
+
+// CheckWritable returns ErrDeviceReadOnly if the device may not push.
+// Unknown devices and uuid.Nil, for callers without a device, may.
+func (s *VaultSync) CheckWritable(ctx context.Context, deviceID uuid.UUID) error {
+	if deviceID == uuid.Nil {
+		return nil
+	}
+	device, err := s.devices.GetByID(ctx, deviceID)
+	switch {
+	case errors.Is(err, repository.ErrDeviceNotFound):
+		return nil
+	case err != nil:
+		return err
+	case device.ReadOnly:
+		return ErrDeviceReadOnly
+	}
+	return nil
+}
+
+// ForceOverwrite replaces the named vault with blob regardless of its
+// revision, or creates it. A vaultVersion of 0 keeps the current one; a
+// lower one is accepted. deviceID must belong to the user, otherwise it
+// returns ErrForeignDevice, and must not be read-only, otherwise it
+// returns ErrDeviceReadOnly.
+// Creating a vault beyond the vault limit returns ErrVaultLimit.
+func (s *VaultSync) ForceOverwrite(ctx context.Context, userID, deviceID uuid.UUID, name string, blob []byte, vaultVersion int) (*models.EncryptedVault, error) {
+	name = vaultName(name)
+	device, err := s.devices.GetByID(ctx, deviceID)
+	if errors.Is(err, repository.ErrDeviceNotFound) || (err == nil && device.UserID != userID) {
+		return nil, ErrForeignDevice
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device.ReadOnly {
+		return nil, ErrDeviceReadOnly
+	}
+
+	current, err := s.vaults.GetByUserID(ctx, userID, name)
+	if err != nil && !errors.Is(err, repository.ErrVaultNotFound) {
+		return nil, err
+	}
+	if vaultVersion == 0 {
+		vaultVersion = 1
+		if current != nil {
+			vaultVersion = current.VaultVersion
+		}
+	}
+	if !s.supportsVersion(vaultVersion) {
+		return nil, ErrVaultVersionUnsupported
+	}
+	refused, err := s.RefusesVersion(ctx, vaultVersion)
+	if err != nil {
+		return nil, err
+	}
+	if refused {
+		return nil, ErrVaultVersionRefused
+	}
+
+	var before int
+	if current != nil {
+		before = current.VaultVersion
+	}
+	vault, _, err := s.vaults.Overwrite(ctx, userID, name, blob, vaultVersion, &deviceID, writeLog(userID, deviceID, "force_overwrite", before))
+	if err != nil {
+		return nil, err
+	}
+	s.recordSync(ctx, deviceID, name, vault.Revision)
+	return vault, nil
+}
+
+// Pull opens the named vault of userID for streaming and records the
+// pull by deviceID. The caller closes the stream.
+func (s *VaultSync) Pull(ctx context.Context, userID, deviceID uuid.UUID, name string) (*models.VaultBlobStream, error) {
+	name = vaultName(name)
+	stream, err := s.vaults.OpenBlob(ctx, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	revision := stream.Vault.Revision
+	if err := s.syncLogs.Create(ctx, userID, &deviceID, name, "pull", &revision, nil); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	s.recordSync(ctx, deviceID, name, revision)
+	return stream, nil
+}
+
+// Head returns the current revision of the named vault, 0 without one
+func (s *VaultSync) Head(ctx context.Context, userID uuid.UUID, name string) (int, error) {
+	revision, err := s.vaults.GetRevision(ctx, userID, vaultName(name))
+	if errors.Is(err, repository.ErrVaultNotFound) {
+		return 0, nil
+	}
+	return revision, err
+}
+
+// Restore makes revision from the history of the named vault its new
+// head revision. It returns repository.ErrVaultRevisionNotFound if the
+// history does not have it and ErrVaultVersionRefused or
+// ErrVaultVersionUnsupported if its vault version may not be written.
+func (s *VaultSync) Restore(ctx context.Context, userID, deviceID uuid.UUID, name string, revision int) (*models.EncryptedVault, error) {
+	name = vaultName(name)
+	old, err := s.vaults.HistoryRevision(ctx, userID, name, revision)
+	if err != nil {
+		return nil, err
+	}
+	if !s.supportsVersion(old.VaultVersion) {
+		return nil, ErrVaultVersionUnsupported
+	}
+	refused, err := s.RefusesVersion(ctx, old.VaultVersion)
+	if err != nil {
+		return nil, err
+	}
+	if refused {
+		return nil, ErrVaultVersionRefused
+	}
+	current, err := s.vaults.GetByUserID(ctx, userID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	vault, err := s.vaults.Restore(ctx, userID, name, revision, &deviceID, writeLog(userID, deviceID, "restore", current.VaultVersion))
+	if err != nil {
+		return nil, err
+	}
+	s.recordSync(ctx, deviceID, name, vault.Revision)
+	return vault, nil
+}
+
+// RevisionLag returns how many revisions of a vault at head a device that
+// last saw revision seen has missed. A device that never synced has missed
+// all of them.
+func RevisionLag(head int, seen *int) int {
+	if seen == nil {
+		return head
+	}
+	return max(head-*seen, 0)
+}
+
+// DeviceSyncStatus reports the sync state of devices against a vault at
+// head
+func DeviceSyncStatus(devices []models.Device, head int) []models.VaultDeviceStatus {
+	statuses := make([]models.VaultDeviceStatus, len(devices))
+	for i, d := range devices {
+		lag := RevisionLag(head, d.LastSeenRevision)
+		statuses[i] = models.VaultDeviceStatus{
+			ID:               d.ID,
+			DeviceName:       d.DeviceName,
+			DeviceType:       d.DeviceType,
+			LastSyncAt:       d.LastSyncAt,
+			LastSeenRevision: d.LastSeenRevision,
+			BehindBy:         lag,
+			Current:          lag == 0,
+		}
+	}
+	return statuses
+}
+
+// SetRevisionLag sets BehindBy of devices against a vault at head
+func SetRevisionLag(devices []models.Device, head int) {
+	for i := range devices {
+		lag := RevisionLag(head, devices[i].LastSeenRevision)
+		devices[i].BehindBy = &lag
+	}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/database"
+	"github.com/sprobst76/vibedterm-server/internal/middleware"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// testDatabase connects to and migrates the database at
+// TEST_DATABASE_URL, or skips the test if it is not set
+func testDatabase(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	if err := database.Connect(url); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(database.Close)
+	if err := database.RunMigrations(context.Background()); err != nil {
+		t.Fatalf("RunMigrations: %v", err)
+	}
+}
+
+// API keys act without a device; their pushes and pulls must still be
+// written, with no device recorded
+func TestVaultSync_APIKeyPushPull(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	db := database.DB
+
+	user, err := repository.NewUserRepository(db).Create(ctx, "apikey-"+uuid.NewString()+"@example.com", "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	apiKeys := NewAPIKeys(repository.NewAPIKeyRepository(db), repository.NewUserRepository(db))
+	secret, _, err := apiKeys.Create(ctx, user.ID, "ci", APIKeyAccessReadWrite)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+
+	vaults := repository.NewVaultRepository(db, 10, 0)
+	syncLogs := repository.NewSyncLogRepository(db)
+	sync := NewVaultSync(vaults, syncLogs, repository.NewDeviceRepository(db, false),
+		NewVaultMigration(repository.NewSettingRepository(db), vaults), 0)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.APIKeyMiddleware(apiKeys, APIKeyPrefix))
+	r.POST("/push", func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+		deviceID, _ := middleware.GetDeviceID(c)
+		req := &models.VaultPushRequest{VaultBlob: base64.StdEncoding.EncodeToString([]byte("encrypted"))}
+		verdict, _, err := sync.Push(c.Request.Context(), userID, deviceID, req)
+		if err != nil || !verdict.Valid {
+			t.Errorf("push: verdict = %+v, err = %v", verdict, err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	r.GET("/pull", func(c *gin.Context) {
+		userID, _ := middleware.GetUserID(c)
+		deviceID, _ := middleware.GetDeviceID(c)
+		stream, err := sync.Pull(c.Request.Context(), userID, deviceID, models.DefaultVaultName)
+		if err != nil {
+			t.Errorf("pull: %v", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		defer stream.Close()
+		blob, _ := io.ReadAll(stream)
+		c.Data(http.StatusOK, "application/octet-stream", blob)
+	})
+
+	for _, route := range [][2]string{{"POST", "/push"}, {"GET", "/pull"}} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(route[0], route[1], nil)
+		req.Header.Set("Authorization", "ApiKey "+secret)
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status = %d", route[0], route[1], w.Code)
+		}
+	}
+
+	vault, err := vaults.GetByUserID(ctx, user.ID, models.DefaultVaultName)
+	if err != nil {
+		t.Fatalf("get vault: %v", err)
+	}
+	if vault.UpdatedByDevice != nil {
+		t.Errorf("updated_by_device = %v, want NULL", *vault.UpdatedByDevice)
+	}
+
+	logs, err := syncLogs.GetByUserID(ctx, user.ID, 10)
+	if err != nil {
+		t.Fatalf("sync logs: %v", err)
+	}
+	actions := map[string]bool{}
+	for _, log := range logs {
+		actions[log.Action] = true
+		if log.DeviceID != nil {
+			t.Errorf("%s logged device %v, want NULL", log.Action, *log.DeviceID)
+		}
+	}
+	if !actions["push_initial"] || !actions["pull"] {
+		t.Errorf("logged actions = %v, want push_initial and pull", actions)
+	}
+}
+
+func TestVaultDelta_PendingQuota(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	user, err := repository.NewUserRepository(database.DB).Create(ctx, "chunks-"+uuid.NewString()+"@example.com", "x")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	d := NewVaultDelta(repository.NewVaultChunkRepository(database.DB, 10), nil)
+
+	chunk := func(data string) map[string][]byte {
+		return map[string][]byte{models.BlobChecksum([]byte(data)): []byte(data)}
+	}
+	if err := d.Upload(ctx, user.ID, chunk("123456")); err != nil {
+		t.Fatalf("upload within the quota: %v", err)
+	}
+	if err := d.Upload(ctx, user.ID, chunk("abcdef")); !errors.Is(err, ErrChunkQuota) {
+		t.Errorf("upload beyond the quota: error = %v, want ErrChunkQuota", err)
+	}
+	if err := d.Upload(ctx, user.ID, chunk("123456")); err != nil {
+		t.Errorf("upload of a pending chunk again: %v", err)
+	}
+}
+
+// A transfer appends to both users' chains; neither may be left with
+// gaps or unchained entries
+func TestVaultTransfer_SyncChains(t *testing.T) {
+	testDatabase(t)
+	ctx := context.Background()
+	users := repository.NewUserRepository(database.DB)
+	vaults := repository.NewVaultRepository(database.DB, 10, 0)
+	syncLogs := repository.NewSyncLogRepository(database.DB)
+
+	var source, target uuid.UUID
+	for _, id := range []*uuid.UUID{&source, &target} {
+		user, err := users.Create(ctx, "transfer-"+uuid.NewString()+"@example.com", "x")
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		*id = user.ID
+	}
+	if _, err := vaults.Create(ctx, source, models.DefaultVaultName, []byte("source"), 1, nil, writeLog(source, uuid.Nil, "push_initial", 0)); err != nil {
+		t.Fatalf("create source vault: %v", err)
+	}
+	if err := syncLogs.Create(ctx, source, nil, models.DefaultVaultName, "pull", nil, nil); err != nil {
+		t.Fatalf("log pull: %v", err)
+	}
+	if _, err := vaults.Create(ctx, target, "work", []byte("target"), 1, nil, writeLog(target, uuid.Nil, "push_initial", 0)); err != nil {
+		t.Fatalf("create target vault: %v", err)
+	}
+
+	if _, _, err := vaults.Transfer(ctx, source, target, models.VaultTransferOptions{IncludeSyncLogs: true}); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		userID  uuid.UUID
+		actions []string
+	}{
+		"source": {source, []string{"push_initial", "pull", "transfer_out"}},
+		"target": {target, []string{"push_initial", "transfer_in", "push_initial", "pull"}},
+	} {
+		chain, err := syncLogs.GetChain(ctx, tc.userID)
+		if err != nil {
+			t.Fatalf("%s chain: %v", name, err)
+		}
+		if report := VerifySyncChain(chain, nil); !report.Valid || report.Entries != len(tc.actions) {
+			t.Errorf("%s chain = %+v, want %d valid entries", name, report, len(tc.actions))
+		}
+		all, err := syncLogs.GetByUserID(ctx, tc.userID, 100)
+		if err != nil {
+			t.Fatalf("%s logs: %v", name, err)
+		}
+		if len(all) != len(chain) {
+			t.Errorf("%s has %d entries, %d chained", name, len(all), len(chain))
+		}
+		for i := range chain {
+			if i < len(tc.actions) && chain[i].Action != tc.actions[i] {
+				t.Errorf("%s entry %d = %s, want %s", name, chain[i].Seq, chain[i].Action, tc.actions[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+const (
+	// Chunk sizes a delta push may use
+	DeltaMinChunkSize = 4 << 10
+	DeltaMaxChunkSize = 4 << 20
+
+	// vaultChunkTTL is how long uploaded chunks wait for their commit
+	vaultChunkTTL = time.Hour
+	// vaultChunkPruneInterval is how often expired chunks are deleted
+	vaultChunkPruneInterval = 10 * time.Minute
+)
+
+var (
+	// ErrChunkSize is returned for a chunk size outside
+	// DeltaMinChunkSize and DeltaMaxChunkSize
+	ErrChunkSize = errors.New("chunk size out of range")
+	// ErrChunkHash is returned by Upload for a chunk that does not match
+	// its hash
+	ErrChunkHash = errors.New("chunk does not match its hash")
+	// ErrChunkLayout is returned by Assemble if the chunks are not cut at
+	// the chunk size: all but the last must be exactly that long
+	ErrChunkLayout = errors.New("chunks are not cut at the chunk size")
+	// ErrChunkQuota is returned by Upload if the user's pending chunks
+	// would grow past MaxPending bytes
+	ErrChunkQuota = repository.ErrVaultChunkQuota
+)
+
+// MissingChunksError is returned by Assemble for chunks neither uploaded
+// nor part of the stored vault
+type MissingChunksError struct {
+	Hashes []string
+}
+
+func (e *MissingChunksError) Error() string {
+	return fmt.Sprintf("%d chunks missing", len(e.Hashes))
+}
+
+// vaultChunkStore is the subset of VaultChunkRepository needed for delta
+// pushes
+type vaultChunkStore interface {
+	Existing(ctx context.Context, userID uuid.UUID, hashes []string) (map[string]bool, error)
+	Save(ctx context.Context, userID uuid.UUID, chunks map[string][]byte) error
+	MaxPending() int64
+	Get(ctx context.Context, userID uuid.UUID, hashes []string) (map[string][]byte, error)
+	Delete(ctx context.Context, userID uuid.UUID, hashes []string) error
+	DeleteOlderThan(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// deltaVaultSource is the subset of VaultRepository needed for delta
+// pushes
+type deltaVaultSource interface {
+	GetByUserID(ctx context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error)
+}
+
+// VaultDelta implements delta pushes. The client cuts its blob into
+// chunks of a fixed size and names them by SHA-256. Chunks the stored
+// vault already has, cut at the same size, need not be uploaded; the
+// others are kept until the push is committed or they expire. The
+// committed blob goes through the normal push path.
+type VaultDelta struct {
+	chunks vaultChunkStore
+	vaults deltaVaultSource
+	now    func() time.Time
+}
+
+// NewVaultDelta creates the delta push service
+func NewVaultDelta(chunks vaultChunkStore, vaults deltaVaultSource) *VaultDelta {
+	return &VaultDelta{chunks: chunks, vaults: vaults, now: time.Now}
+}
+
+// Missing returns the chunks of a manifest the server has neither in the
+// named vault nor uploaded, in manifest order without repeats
+func (d *VaultDelta) Missing(ctx context.Context, userID uuid.UUID, name string, chunkSize int, hashes []string) ([]string, error) {
+	known, err := d.storedChunks(ctx, userID, name, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	uploaded, err := d.chunks.Existing(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	seen := make(map[string]bool)
+	for _, hash := range hashes {
+		if known[hash] == nil && !uploaded[hash] && !seen[hash] {
+			missing = append(missing, hash)
+		}
+		seen[hash] = true
+	}
+	return missing, nil
+}
+
+// MaxPending returns how many bytes of uploaded chunks a user may keep,
+// 0 if unlimited
+func (d *VaultDelta) MaxPending() int64 {
+	return d.chunks.MaxPending()
+}
+
+// Upload stores chunks by hash until they are committed or expire. It
+// returns ErrChunkHash if a chunk does not match its hash and
+// ErrChunkQuota if they do not fit the quota, and stores nothing then.
+func (d *VaultDelta) Upload(ctx context.Context, userID uuid.UUID, chunks map[string][]byte) error {
+	for hash, data := range chunks {
+		if models.BlobChecksum(data) != hash {
+			return fmt.Errorf("%w: %s", ErrChunkHash, hash)
+		}
+	}
+	return d.chunks.Save(ctx, userID, chunks)
+}
+
+// Assemble joins the chunks of a manifest into the blob. Chunks come from
+// the named vault or the uploads; if any is missing it returns
+// *MissingChunksError.
+func (d *VaultDelta) Assemble(ctx context.Context, userID uuid.UUID, name string, chunkSize int, hashes []string) ([]byte, error) {
+	chunks, err := d.storedChunks(ctx, userID, name, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	var wanted []string
+	for _, hash := range hashes {
+		if chunks[hash] == nil {
+			wanted = append(wanted, hash)
+		}
+	}
+	if len(wanted) > 0 {
+		uploaded, err := d.chunks.Get(ctx, userID, wanted)
+		if err != nil {
+			return nil, err
+		}
+		for hash, data := range uploaded {
+			chunks[hash] = data
+		}
+	}
+
+	var missing []string
+	for _, hash := range hashes {
+		if chunks[hash] == nil {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingChunksError{Hashes: missing}
+	}
+
+	blob := make([]byte, 0, len(hashes)*chunkSize)
+	for i, hash := range hashes {
+		data := chunks[hash]
+		if len(data) > chunkSize || (i < len(hashes)-1 && len(data) != chunkSize) {
+			return nil, ErrChunkLayout
+		}
+		blob = append(blob, data...)
+	}
+	return blob, nil
+}
+
+// Release deletes uploaded chunks once their push is committed
+func (d *VaultDelta) Release(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	return d.chunks.Delete(ctx, userID, hashes)
+}
+
+// storedChunks cuts the named vault into chunks of chunkSize by hash. It
+// returns an empty map without a vault.
+func (d *VaultDelta) storedChunks(ctx context.Context, userID uuid.UUID, name string, chunkSize int) (map[string][]byte, error) {
+	if chunkSize < DeltaMinChunkSize || chunkSize > DeltaMaxChunkSize {
+		return nil, ErrChunkSize
+	}
+	chunks := make(map[string][]byte)
+	vault, err := d.vaults.GetByUserID(ctx, userID, vaultName(name))
+	if errors.Is(err, repository.ErrVaultNotFound) {
+		return chunks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for blob := vault.VaultBlob; len(blob) > 0; {
+		n := min(chunkSize, len(blob))
+		chunks[models.BlobChecksum(blob[:n])] = blob[:n]
+		blob = blob[n:]
+	}
+	return chunks, nil
+}
+
+// Prune deletes chunks that were not committed in time
+func (d *VaultDelta) Prune(ctx context.Context) (int64, error) {
+	return d.chunks.DeleteOlderThan(ctx, d.now().Add(-vaultChunkTTL))
+}
+
+// Run prunes expired chunks until ctx is cancelled
+func (d *VaultDelta) Run(ctx context.Context) {
+	ticker := time.NewTicker(vaultChunkPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := d.Prune(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to prune vault chunks")
+		} else if n > 0 {
+			log.Info().Int64("count", n).Msg("Pruned uncommitted vault chunks")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// memChunkStore keeps uploaded chunks with their upload time
+type memChunkStore struct {
+	chunks   map[string][]byte
+	uploaded map[string]time.Time
+	now      func() time.Time
+}
+
+func (m *memChunkStore) Existing(_ context.Context, _ uuid.UUID, hashes []string) (map[string]bool, error) {
+	existing := map[string]bool{}
+	for _, hash := range hashes {
+		existing[hash] = m.chunks[hash] != nil
+	}
+	return existing, nil
+}
+
+func (m *memChunkStore) MaxPending() int64 { return 0 }
+
+func (m *memChunkStore) Save(_ context.Context, _ uuid.UUID, chunks map[string][]byte) error {
+	for hash, data := range chunks {
+		m.chunks[hash], m.uploaded[hash] = data, m.now()
+	}
+	return nil
+}
+
+func (m *memChunkStore) Get(_ context.Context, _ uuid.UUID, hashes []string) (map[string][]byte, error) {
+	found := map[string][]byte{}
+	for _, hash := range hashes {
+		if data := m.chunks[hash]; data != nil {
+			found[hash] = data
+		}
+	}
+	return found, nil
+}
+
+func (m *memChunkStore) Delete(_ context.Context, _ uuid.UUID, hashes []string) error {
+	for _, hash := range hashes {
+		delete(m.chunks, hash)
+	}
+	return nil
+}
+
+func (m *memChunkStore) DeleteOlderThan(_ context.Context, cutoff time.Time) (int64, error) {
+	var n int64
+	for hash, at := range m.uploaded {
+		if at.Before(cutoff) && m.chunks[hash] != nil {
+			delete(m.chunks, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestVaultDelta(t *testing.T) {
+	ctx := context.Background()
+	const size = DeltaMinChunkSize
+	now := time.Unix(1_700_000_000, 0)
+	userID := uuid.New()
+	a, b, c := bytes.Repeat([]byte("a"), size), bytes.Repeat([]byte("b"), size), []byte("tail")
+	ha, hb, hc := models.BlobChecksum(a), models.BlobChecksum(b), models.BlobChecksum(c)
+
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{
+		userID: {UserID: userID, VaultBlob: slices.Concat(a, b), Revision: 1},
+	}}
+	store := &memChunkStore{chunks: map[string][]byte{}, uploaded: map[string]time.Time{}, now: func() time.Time { return now }}
+	d := NewVaultDelta(store, vaults)
+	d.now = store.now
+
+	// Chunks of the stored vault are known, repeats are listed once
+	missing, err := d.Missing(ctx, userID, "", size, []string{hb, hc, ha, hc})
+	if err != nil || !slices.Equal(missing, []string{hc}) {
+		t.Fatalf("missing = %v, %v, want [%s]", missing, err, hc)
+	}
+	if _, err := d.Missing(ctx, userID, "", size-1, []string{ha}); !errors.Is(err, ErrChunkSize) {
+		t.Errorf("chunk size below the minimum: error = %v", err)
+	}
+
+	if err := d.Upload(ctx, userID, map[string][]byte{hc: []byte("other")}); !errors.Is(err, ErrChunkHash) {
+		t.Errorf("upload of a mismatching chunk: error = %v, want ErrChunkHash", err)
+	}
+	if err := d.Upload(ctx, userID, map[string][]byte{hc: c}); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := d.Assemble(ctx, userID, "", size, []string{hb, ha, hc})
+	if err != nil || !bytes.Equal(blob, slices.Concat(b, a, c)) {
+		t.Fatalf("assembled %d bytes, %v", len(blob), err)
+	}
+	if _, err := d.Assemble(ctx, userID, "", size, []string{hc, ha}); !errors.Is(err, ErrChunkLayout) {
+		t.Errorf("short chunk before the last: error = %v, want ErrChunkLayout", err)
+	}
+
+	// Uncommitted chunks expire
+	now = now.Add(vaultChunkTTL + time.Second)
+	if n, _ := d.Prune(ctx); n != 1 {
+		t.Fatalf("pruned %d chunks, want 1", n)
+	}
+	var missingErr *MissingChunksError
+	if _, err := d.Assemble(ctx, userID, "", size, []string{ha, hc}); !errors.As(err, &missingErr) || !slices.Equal(missingErr.Hashes, []string{hc}) {
+		t.Errorf("assemble after expiry: error = %v, want %s missing", err, hc)
+	}
+}
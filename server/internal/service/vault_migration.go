@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// Settings keys of the vault migration campaign
+const (
+	settingMigrationTargetVersion = "vault_migration.target_version"
+	settingMigrationDeadline      = "vault_migration.deadline"
+	settingMigrationRefuseOld     = "vault_migration.refuse_old_pushes"
+)
+
+// ErrInvalidMigrationCampaign is returned when saving an unusable campaign
+var ErrInvalidMigrationCampaign = errors.New("invalid migration campaign")
+
+// MigrationCampaign asks clients to re-encrypt their vault to a new
+// vault_version. The server never sees the plaintext; clients do the
+// migration on their next sync.
+type MigrationCampaign struct {
+	TargetVersion   int       // 0 means no campaign is running
+	Deadline        time.Time // clients should have migrated by then
+	RefuseOldPushes bool      // after the deadline, reject pushes below TargetVersion
+}
+
+// Active reports whether a campaign is running
+func (c MigrationCampaign) Active() bool {
+	return c.TargetVersion > 0
+}
+
+// Validate checks that c is usable
+func (c MigrationCampaign) Validate() error {
+	if c.TargetVersion < 0 {
+		return fmt.Errorf("%w: target version must not be negative", ErrInvalidMigrationCampaign)
+	}
+	if c.Active() && c.Deadline.IsZero() {
+		return fmt.Errorf("%w: a deadline is required", ErrInvalidMigrationCampaign)
+	}
+	return nil
+}
+
+// Notice returns what to tell a client whose vault has vaultVersion, or
+// nil if it does not need to migrate
+func (c MigrationCampaign) Notice(vaultVersion int, now time.Time) *models.VaultMigrationNotice {
+	if !c.Active() || vaultVersion >= c.TargetVersion {
+		return nil
+	}
+	return &models.VaultMigrationNotice{
+		TargetVersion: c.TargetVersion,
+		Deadline:      c.Deadline.Unix(),
+		PushesRefused: c.Refuses(vaultVersion, now),
+	}
+}
+
+// Refuses reports whether a push of vaultVersion is rejected at now
+func (c MigrationCampaign) Refuses(vaultVersion int, now time.Time) bool {
+	return c.Active() && c.RefuseOldPushes && vaultVersion < c.TargetVersion && !now.Before(c.Deadline)
+}
+
+// MigrationProgress is how far a campaign has come
+type MigrationProgress struct {
+	Campaign MigrationCampaign
+	Versions []models.VaultVersionCount // ascending by version
+	Total    int
+	Migrated int // vaults at or above the target version
+}
+
+// Percent returns the share of migrated vaults, 100 without vaults
+func (p MigrationProgress) Percent() int {
+	if p.Total == 0 {
+		return 100
+	}
+	return p.Migrated * 100 / p.Total
+}
+
+// vaultVersionCounter is the subset of VaultRepository needed for progress
+type vaultVersionCounter interface {
+	CountByVersion(ctx context.Context) ([]models.VaultVersionCount, error)
+}
+
+// VaultMigration stores the migration campaign and reports its progress
+type VaultMigration struct {
+	settings policySettingStore
+	vaults   vaultVersionCounter
+}
+
+// NewVaultMigration creates the campaign service
+func NewVaultMigration(settings policySettingStore, vaults vaultVersionCounter) *VaultMigration {
+	return &VaultMigration{settings: settings, vaults: vaults}
+}
+
+// Campaign returns the saved campaign; without one it is inactive
+func (s *VaultMigration) Campaign(ctx context.Context) (MigrationCampaign, error) {
+	var c MigrationCampaign
+	saved, err := s.settings.All(ctx)
+	if err != nil {
+		return c, err
+	}
+
+	if v, ok := saved[settingMigrationTargetVersion]; ok {
+		c.TargetVersion, _ = strconv.Atoi(v)
+	}
+	if v, ok := saved[settingMigrationDeadline]; ok {
+		c.Deadline, _ = time.Parse(time.RFC3339, v)
+	}
+	if v, ok := saved[settingMigrationRefuseOld]; ok {
+		c.RefuseOldPushes, _ = strconv.ParseBool(v)
+	}
+
+	if err := c.Validate(); err != nil {
+		log.Warn().Err(err).Msg("Saved migration campaign is invalid, ignoring it")
+		return MigrationCampaign{}, nil
+	}
+	return c, nil
+}
+
+// SaveCampaign validates and stores c. A zero TargetVersion ends the
+// campaign.
+func (s *VaultMigration) SaveCampaign(ctx context.Context, c MigrationCampaign) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	deadline := ""
+	if !c.Deadline.IsZero() {
+		deadline = c.Deadline.UTC().Format(time.RFC3339)
+	}
+	values := map[string]string{
+		settingMigrationTargetVersion: strconv.Itoa(c.TargetVersion),
+		settingMigrationDeadline:      deadline,
+		settingMigrationRefuseOld:     strconv.FormatBool(c.RefuseOldPushes),
+	}
+	for key, value := range values {
+		if err := s.settings.Set(ctx, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Progress counts vaults per version against the current campaign
+func (s *VaultMigration) Progress(ctx context.Context) (*MigrationProgress, error) {
+	campaign, err := s.Campaign(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.vaults.CountByVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &MigrationProgress{Campaign: campaign, Versions: versions}
+	for _, v := range versions {
+		p.Total += v.Vaults
+		if v.Version >= campaign.TargetVersion {
+			p.Migrated += v.Vaults
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// fixedCampaign serves one campaign without a settings store
+type fixedCampaign MigrationCampaign
+
+func (f fixedCampaign) Campaign(context.Context) (MigrationCampaign, error) {
+	return MigrationCampaign(f), nil
+}
+
+type staticVersionCounts []models.VaultVersionCount
+
+func (s staticVersionCounts) CountByVersion(context.Context) ([]models.VaultVersionCount, error) {
+	return s, nil
+}
+
+func TestMigrationCampaign_BeforeAndAfterDeadline(t *testing.T) {
+	ctx := context.Background()
+	userID, deviceID := uuid.New(), uuid.New()
+	deadline := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	campaign := MigrationCampaign{TargetVersion: 2, Deadline: deadline, RefuseOldPushes: true}
+	blob := base64.StdEncoding.EncodeToString([]byte("encrypted"))
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		campaign    MigrationCampaign
+		req         models.VaultPushRequest
+		wantCode    string
+		wantVersion int
+	}{
+		{"old version before deadline", deadline.Add(-time.Hour), campaign, models.VaultPushRequest{VaultBlob: blob, Revision: 1}, "", 1},
+		{"old version after deadline", deadline, campaign, models.VaultPushRequest{VaultBlob: blob, Revision: 1}, PushCodeMigrationNeeded, 1},
+		{"explicit old version after deadline", deadline.Add(time.Hour), campaign, models.VaultPushRequest{VaultBlob: blob, Revision: 1, VaultVersion: 1}, PushCodeMigrationNeeded, 1},
+		{"migrated vault after deadline", deadline.Add(time.Hour), campaign, models.VaultPushRequest{VaultBlob: blob, Revision: 1, VaultVersion: 2}, "", 2},
+		{"deadline without refusal", deadline.Add(time.Hour), MigrationCampaign{TargetVersion: 2, Deadline: deadline}, models.VaultPushRequest{VaultBlob: blob, Revision: 1}, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{
+				userID: {UserID: userID, Revision: 1, VaultVersion: 1},
+			}}
+			s := NewVaultSync(vaults, &memSyncLogs{}, &memDeviceSync{}, fixedCampaign(tt.campaign), 0)
+			s.now = func() time.Time { return tt.now }
+
+			verdict, vault, err := s.Push(ctx, userID, deviceID, &tt.req)
+			if err != nil {
+				t.Fatalf("Push: %v", err)
+			}
+			if verdict.Code != tt.wantCode {
+				t.Fatalf("code = %q, want %q", verdict.Code, tt.wantCode)
+			}
+			if tt.wantCode != "" {
+				if vault != nil || vaults.writes != 0 {
+					t.Errorf("refused push wrote the vault")
+				}
+				return
+			}
+			if vault.VaultVersion != tt.wantVersion {
+				t.Errorf("stored version = %d, want %d", vault.VaultVersion, tt.wantVersion)
+			}
+		})
+	}
+
+	before := campaign.Notice(1, deadline.Add(-time.Hour))
+	if before == nil || before.TargetVersion != 2 || before.Deadline != deadline.Unix() || before.PushesRefused {
+		t.Errorf("notice before deadline = %+v", before)
+	}
+	if after := campaign.Notice(1, deadline); after == nil || !after.PushesRefused {
+		t.Errorf("notice after deadline = %+v", after)
+	}
+	if migrated := campaign.Notice(2, deadline); migrated != nil {
+		t.Errorf("migrated vault got notice %+v", migrated)
+	}
+}
+
+func TestVaultMigration_SaveAndProgress(t *testing.T) {
+	ctx := context.Background()
+	settings := memSettings{}
+	counts := staticVersionCounts{{Version: 1, Vaults: 3}, {Version: 2, Vaults: 1}}
+	m := NewVaultMigration(settings, counts)
+
+	if err := m.SaveCampaign(ctx, MigrationCampaign{TargetVersion: 2}); err == nil {
+		t.Error("campaign without deadline saved")
+	}
+	want := MigrationCampaign{TargetVersion: 2, Deadline: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), RefuseOldPushes: true}
+	if err := m.SaveCampaign(ctx, want); err != nil {
+		t.Fatalf("SaveCampaign: %v", err)
+	}
+	got, err := m.Campaign(ctx)
+	if err != nil || !got.Deadline.Equal(want.Deadline) || got.TargetVersion != 2 || !got.RefuseOldPushes {
+		t.Fatalf("Campaign = %+v, %v; want %+v", got, err, want)
+	}
+
+	p, err := m.Progress(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Total != 4 || p.Migrated != 1 || p.Percent() != 25 {
+		t.Errorf("progress = %d/%d (%d%%), want 1/4 (25%%)", p.Migrated, p.Total, p.Percent())
+	}
+
+	if err := m.SaveCampaign(ctx, MigrationCampaign{}); err != nil {
+		t.Fatalf("end campaign: %v", err)
+	}
+	if got, _ := m.Campaign(ctx); got.Active() {
+		t.Errorf("campaign still active after ending it: %+v", got)
+	}
+}
@@ -0,0 +1,670 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+type memVaults struct {
+	mu      sync.Mutex
+	vaults  map[uuid.UUID]*models.EncryptedVault
+	history []models.EncryptedVault // replaced vaults, oldest first
+	writes  int
+	logs    writeLogSink // receives the sync log entries of writes, if set
+}
+
+// writeLogSink collects the entries VaultRepository would append in the
+// transaction of a write
+type writeLogSink interface {
+	add(entry *models.SyncLog)
+}
+
+// logWrite hands the entries of writeLog for v to m.logs. The caller
+// holds m.mu.
+func (m *memVaults) logWrite(writeLog repository.VaultWriteLog, v *models.EncryptedVault, replaced bool) {
+	if writeLog == nil || m.logs == nil {
+		return
+	}
+	for _, entry := range writeLog(v, replaced) {
+		m.logs.add(entry)
+	}
+}
+
+func (m *memVaults) GetByUserID(_ context.Context, userID uuid.UUID, _ string) (*models.EncryptedVault, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.vaults[userID]
+	if !ok {
+		return nil, repository.ErrVaultNotFound
+	}
+	cp := *v
+	return &cp, nil
+}
+
+func (m *memVaults) OpenBlob(ctx context.Context, userID uuid.UUID, name string) (*models.VaultBlobStream, error) {
+	v, err := m.GetByUserID(ctx, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	stream := &models.VaultBlobStream{Vault: *v, SizeBytes: int64(len(v.VaultBlob)), ReadCloser: io.NopCloser(bytes.NewReader(v.VaultBlob))}
+	stream.Vault.VaultBlob = nil
+	return stream, nil
+}
+
+func (m *memVaults) GetRevision(ctx context.Context, userID uuid.UUID, name string) (int, error) {
+	v, err := m.GetByUserID(ctx, userID, name)
+	if err != nil {
+		return 0, err
+	}
+	return v.Revision, nil
+}
+
+func (m *memVaults) CreateAllowed(context.Context, uuid.UUID, string) (bool, error) {
+	return true, nil
+}
+
+func (m *memVaults) Create(_ context.Context, userID uuid.UUID, _ string, blob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.vaults[userID]; ok {
+		return nil, repository.ErrVaultConflict
+	}
+	m.writes++
+	v := &models.EncryptedVault{ID: uuid.New(), UserID: userID, VaultBlob: blob, Revision: 1, VaultVersion: vaultVersion, UpdatedByDevice: deviceID, UpdatedAt: time.Now()}
+	m.vaults[userID] = v
+	cp := *v
+	m.logWrite(writeLog, &cp, false)
+	return &cp, nil
+}
+
+func (m *memVaults) UpdateWithRevisionCheck(_ context.Context, userID uuid.UUID, _ string, blob []byte, expected, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.vaults[userID]
+	if !ok || v.Revision != expected {
+		return nil, repository.ErrVaultConflict
+	}
+	return m.replace(v, blob, vaultVersion, deviceID, writeLog), nil
+}
+
+func (m *memVaults) Overwrite(_ context.Context, userID uuid.UUID, _ string, blob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.vaults[userID]; ok {
+		return m.replace(v, blob, vaultVersion, deviceID, writeLog), true, nil
+	}
+	m.writes++
+	v := &models.EncryptedVault{ID: uuid.New(), UserID: userID, VaultBlob: blob, Revision: 1, VaultVersion: vaultVersion, UpdatedByDevice: deviceID, UpdatedAt: time.Now()}
+	m.vaults[userID] = v
+	cp := *v
+	m.logWrite(writeLog, &cp, false)
+	return &cp, false, nil
+}
+
+// replace keeps v in the history and writes the next revision. The
+// caller holds m.mu.
+func (m *memVaults) replace(v *models.EncryptedVault, blob []byte, vaultVersion int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) *models.EncryptedVault {
+	m.writes++
+	m.history = append(m.history, *v)
+	v.VaultBlob, v.Revision, v.VaultVersion, v.UpdatedByDevice, v.UpdatedAt = blob, v.Revision+1, vaultVersion, deviceID, time.Now()
+	cp := *v
+	m.logWrite(writeLog, &cp, true)
+	return &cp
+}
+
+func (m *memVaults) historyRevision(userID uuid.UUID, revision int) *models.EncryptedVault {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.history) - 1; i >= 0; i-- {
+		if m.history[i].UserID == userID && m.history[i].Revision == revision {
+			old := m.history[i]
+			return &old
+		}
+	}
+	return nil
+}
+
+func (m *memVaults) HistoryRevision(_ context.Context, userID uuid.UUID, _ string, revision int) (*models.VaultRevision, error) {
+	old := m.historyRevision(userID, revision)
+	if old == nil {
+		return nil, repository.ErrVaultRevisionNotFound
+	}
+	return &models.VaultRevision{Revision: old.Revision, VaultVersion: old.VaultVersion, SizeBytes: len(old.VaultBlob)}, nil
+}
+
+func (m *memVaults) Restore(_ context.Context, userID uuid.UUID, _ string, revision int, deviceID *uuid.UUID, writeLog repository.VaultWriteLog) (*models.EncryptedVault, error) {
+	old := m.historyRevision(userID, revision)
+	if old == nil {
+		return nil, repository.ErrVaultRevisionNotFound
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.replace(m.vaults[userID], old.VaultBlob, old.VaultVersion, deviceID, writeLog), nil
+}
+
+type memSyncLogs struct {
+	entries        int
+	versionChanges [][2]int // from, to
+	err            error    // returned by Create, if set
+}
+
+func (m *memSyncLogs) Create(context.Context, uuid.UUID, *uuid.UUID, string, string, *int, *int) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.entries++
+	return nil
+}
+
+func (m *memSyncLogs) add(entry *models.SyncLog) {
+	m.entries++
+	if entry.VersionBefore != nil {
+		m.versionChanges = append(m.versionChanges, [2]int{*entry.VersionBefore, *entry.VersionAfter})
+	}
+}
+
+// memDeviceSync records the revision each device saw last
+type memDeviceSync struct {
+	synced int
+	seen   map[uuid.UUID]int
+	owners map[uuid.UUID]uuid.UUID // device → user, for GetByID
+	agents map[uuid.UUID]string    // last user agent
+	ro     map[uuid.UUID]bool      // read-only devices
+}
+
+func (m *memDeviceSync) GetByID(_ context.Context, id uuid.UUID) (*models.Device, error) {
+	userID, ok := m.owners[id]
+	if !ok {
+		return nil, repository.ErrDeviceNotFound
+	}
+	d := m.device(id)
+	d.UserID = userID
+	return &d, nil
+}
+
+func (m *memDeviceSync) UpdateLastSync(_ context.Context, id uuid.UUID, revision int) error {
+	m.synced++
+	if m.seen == nil {
+		m.seen = map[uuid.UUID]int{}
+	}
+	m.seen[id] = revision
+	return nil
+}
+
+func (m *memDeviceSync) UpdateActivity(_ context.Context, id uuid.UUID, _, userAgent string) error {
+	if m.agents == nil {
+		m.agents = map[uuid.UUID]string{}
+	}
+	m.agents[id] = userAgent
+	return nil
+}
+
+// device returns the device as DeviceRepository would load it
+func (m *memDeviceSync) device(id uuid.UUID) models.Device {
+	d := models.Device{ID: id, ReadOnly: m.ro[id]}
+	if revision, ok := m.seen[id]; ok {
+		d.LastSeenRevision = &revision
+	}
+	return d
+}
+
+func TestValidatePushMatchesPush(t *testing.T) {
+	blob := base64.StdEncoding.EncodeToString([]byte("encrypted"))
+	userID, deviceID := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name       string
+		existing   *models.EncryptedVault
+		req        models.VaultPushRequest
+		wantValid  bool
+		wantCode   string
+		wantCreate bool
+		wantNext   int
+	}{
+		{
+			name:       "first push creates vault",
+			req:        models.VaultPushRequest{VaultBlob: blob},
+			wantValid:  true,
+			wantCreate: true,
+			wantNext:   1,
+		},
+		{
+			name:      "matching revision updates",
+			existing:  &models.EncryptedVault{UserID: userID, Revision: 3},
+			req:       models.VaultPushRequest{VaultBlob: blob, Revision: 3},
+			wantValid: true,
+			wantNext:  4,
+		},
+		{
+			name:     "stale revision conflicts",
+			existing: &models.EncryptedVault{UserID: userID, Revision: 3, UpdatedByDevice: &deviceID},
+			req:      models.VaultPushRequest{VaultBlob: blob, Revision: 2},
+			wantCode: PushCodeConflict,
+		},
+		{
+			name:      "matching checksum is accepted in any case",
+			existing:  &models.EncryptedVault{UserID: userID, Revision: 3},
+			req:       models.VaultPushRequest{VaultBlob: blob, Revision: 3, SHA256: strings.ToUpper(models.BlobChecksum([]byte("encrypted")))},
+			wantValid: true,
+			wantNext:  4,
+		},
+		{
+			name:     "checksum mismatch is rejected",
+			existing: &models.EncryptedVault{UserID: userID, Revision: 3},
+			req:      models.VaultPushRequest{VaultBlob: blob, Revision: 3, SHA256: models.BlobChecksum([]byte("truncated"))},
+			wantCode: PushCodeChecksum,
+		},
+		{
+			name:     "invalid base64 is rejected",
+			existing: &models.EncryptedVault{UserID: userID, Revision: 1},
+			req:      models.VaultPushRequest{VaultBlob: "not base64!", Revision: 1},
+			wantCode: PushCodeInvalidEncoding,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := events.WithClient(context.Background(), "203.0.113.9", "vibedterm-app/2.0")
+			vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+			if tt.existing != nil {
+				vaults.vaults[userID] = tt.existing
+			}
+			logs, devices := &memSyncLogs{}, &memDeviceSync{}
+			vaults.logs = logs
+			s := NewVaultSync(vaults, logs, devices, fixedCampaign{}, 0)
+
+			dry, err := s.ValidatePush(ctx, userID, &tt.req)
+			if err != nil {
+				t.Fatalf("ValidatePush: %v", err)
+			}
+			if vaults.writes != 0 || logs.entries != 0 || devices.synced != 0 {
+				t.Fatalf("dry run wrote state: vaults=%d logs=%d devices=%d", vaults.writes, logs.entries, devices.synced)
+			}
+			if dry.Valid != tt.wantValid || dry.Code != tt.wantCode || dry.WouldCreate != tt.wantCreate || dry.NextRevision != tt.wantNext {
+				t.Errorf("unexpected verdict %+v", dry)
+			}
+			if dry.WouldConflict != (tt.wantCode == PushCodeConflict) {
+				t.Errorf("WouldConflict = %v for code %q", dry.WouldConflict, tt.wantCode)
+			}
+
+			verdict, vault, err := s.Push(ctx, userID, deviceID, &tt.req)
+			if err != nil {
+				t.Fatalf("Push: %v", err)
+			}
+			if *verdict != *dry {
+				t.Errorf("push verdict %+v differs from dry run %+v", verdict, dry)
+			}
+			if !tt.wantValid {
+				if vault != nil || vaults.writes != 0 || logs.entries != 0 {
+					t.Errorf("rejected push wrote state")
+				}
+				return
+			}
+			if vault == nil || vault.Revision != dry.NextRevision {
+				t.Errorf("push produced %+v, dry run promised revision %d", vault, dry.NextRevision)
+			}
+			if logs.entries != 1 || devices.synced != 1 {
+				t.Errorf("expected one sync log and device sync, got %d and %d", logs.entries, devices.synced)
+			}
+			if devices.seen[deviceID] != vault.Revision {
+				t.Errorf("device saw revision %d, pushed %d", devices.seen[deviceID], vault.Revision)
+			}
+			if devices.agents[deviceID] != "vibedterm-app/2.0" {
+				t.Errorf("device activity user agent = %q", devices.agents[deviceID])
+			}
+		})
+	}
+}
+
+func TestRevisionLag(t *testing.T) {
+	seen := func(n int) *int { return &n }
+	for _, tt := range []struct {
+		name string
+		head int
+		seen *int
+		want int
+	}{
+		{"up to date", 5, seen(5), 0},
+		{"behind", 5, seen(2), 3},
+		{"never synced", 5, nil, 5},
+		{"saw a replaced vault", 1, seen(7), 0},
+	} {
+		if got := RevisionLag(tt.head, tt.seen); got != tt.want {
+			t.Errorf("%s: RevisionLag(%d, %v) = %d, want %d", tt.name, tt.head, tt.seen, got, tt.want)
+		}
+	}
+}
+
+func TestPush_TracksDeviceLag(t *testing.T) {
+	ctx := context.Background()
+	userID, laptop, phone, tablet := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+	devices := &memDeviceSync{}
+	s := NewVaultSync(vaults, &memSyncLogs{}, devices, fixedCampaign{}, 0)
+	blob := base64.StdEncoding.EncodeToString([]byte("encrypted"))
+
+	for revision := 0; revision < 3; revision++ {
+		if verdict, _, err := s.Push(ctx, userID, laptop, &models.VaultPushRequest{VaultBlob: blob, Revision: revision}); err != nil || !verdict.Valid {
+			t.Fatalf("push %d: %+v %v", revision, verdict, err)
+		}
+		if revision == 0 {
+			_ = devices.UpdateLastSync(ctx, phone, 1) // pulled the first revision
+		}
+	}
+
+	list := []models.Device{devices.device(laptop), devices.device(phone), devices.device(tablet)}
+	head := vaults.vaults[userID].Revision
+	statuses := DeviceSyncStatus(list, head)
+	SetRevisionLag(list, head)
+	for i, want := range []int{0, 2, 3} {
+		if list[i].BehindBy == nil || *list[i].BehindBy != want {
+			t.Errorf("device %d behind by %v, want %d", i, list[i].BehindBy, want)
+		}
+		if statuses[i].ID != list[i].ID || statuses[i].BehindBy != want || statuses[i].Current != (want == 0) {
+			t.Errorf("device %d status = %+v, want behind by %d", i, statuses[i], want)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	userID, laptop, phone := uuid.New(), uuid.New(), uuid.New()
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+	devices := &memDeviceSync{}
+	s := NewVaultSync(vaults, &memSyncLogs{}, devices, fixedCampaign{}, 0)
+
+	for revision, content := range []string{"good", "corrupted"} {
+		req := &models.VaultPushRequest{VaultBlob: base64.StdEncoding.EncodeToString([]byte(content)), Revision: revision}
+		if verdict, _, err := s.Push(ctx, userID, laptop, req); err != nil || !verdict.Valid {
+			t.Fatalf("push %d: %+v %v", revision, verdict, err)
+		}
+	}
+
+	vault, err := s.Restore(ctx, userID, phone, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(vault.VaultBlob) != "good" || vault.Revision != 3 || *vault.UpdatedByDevice != phone {
+		t.Fatalf("restored vault = %q at revision %d", vault.VaultBlob, vault.Revision)
+	}
+	if devices.seen[phone] != 3 {
+		t.Errorf("restoring device saw revision %d, want 3", devices.seen[phone])
+	}
+	// The corrupted head was kept and can be restored in turn
+	if old := vaults.historyRevision(userID, 2); old == nil || string(old.VaultBlob) != "corrupted" {
+		t.Errorf("replaced head not in the history: %v", old)
+	}
+
+	if _, err := s.Restore(ctx, userID, phone, "", 7); !errors.Is(err, repository.ErrVaultRevisionNotFound) {
+		t.Errorf("unknown revision: error = %v", err)
+	}
+}
+
+func TestPull_LogFailure(t *testing.T) {
+	ctx := context.Background()
+	userID, laptop := uuid.New(), uuid.New()
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{
+		userID: {UserID: userID, VaultBlob: []byte("vault"), Revision: 2},
+	}}
+	devices := &memDeviceSync{}
+	s := NewVaultSync(vaults, &memSyncLogs{err: errors.New("connection reset")}, devices, fixedCampaign{}, 0)
+
+	if stream, err := s.Pull(ctx, userID, laptop, ""); err == nil {
+		stream.Close()
+		t.Fatal("pull without a sync log entry returned no error")
+	}
+	if devices.synced != 0 {
+		t.Error("unlogged pull was recorded as synced")
+	}
+}
+
+// failingOverwrite loses the connection during Overwrite
+type failingOverwrite struct{ *memVaults }
+
+func (failingOverwrite) Overwrite(context.Context, uuid.UUID, string, []byte, int, *uuid.UUID, repository.VaultWriteLog) (*models.EncryptedVault, bool, error) {
+	return nil, false, errors.New("connection reset")
+}
+
+func TestForceOverwrite(t *testing.T) {
+	ctx := context.Background()
+	userID, laptop, phone := uuid.New(), uuid.New(), uuid.New()
+	newSync := func(vaults *memVaults) (*VaultSync, *memSyncChain, *memDeviceSync) {
+		logs := &memSyncChain{}
+		vaults.logs = logs
+		devices := &memDeviceSync{owners: map[uuid.UUID]uuid.UUID{laptop: userID, phone: userID}}
+		return NewVaultSync(vaults, logs, devices, fixedCampaign{}, 0), logs, devices
+	}
+
+	t.Run("without a vault", func(t *testing.T) {
+		s, logs, devices := newSync(&memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}})
+		vault, err := s.ForceOverwrite(ctx, userID, laptop, "", []byte("fresh"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vault.Revision != 1 || vault.VaultVersion != 1 || string(vault.VaultBlob) != "fresh" {
+			t.Errorf("created vault = %+v", vault)
+		}
+		if len(logs.logs) != 1 || logs.logs[0].RevisionBefore != nil || devices.seen[laptop] != 1 {
+			t.Errorf("logs = %+v, laptop saw %d", logs.logs, devices.seen[laptop])
+		}
+	})
+
+	t.Run("with a vault", func(t *testing.T) {
+		vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{
+			userID: {UserID: userID, VaultBlob: []byte("old"), Revision: 5, VaultVersion: 2},
+		}}
+		s, logs, devices := newSync(vaults)
+		vault, err := s.ForceOverwrite(ctx, userID, phone, "", []byte("new"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The revision keeps counting so no device believes it is ahead
+		if vault.Revision != 6 || vault.VaultVersion != 2 || *vault.UpdatedByDevice != phone {
+			t.Errorf("overwritten vault = %+v", vault)
+		}
+		if old := vaults.historyRevision(userID, 5); old == nil || string(old.VaultBlob) != "old" {
+			t.Errorf("replaced vault not in the history: %v", old)
+		}
+		if len(logs.logs) != 1 || *logs.logs[0].RevisionBefore != 5 || *logs.logs[0].RevisionAfter != 6 || devices.seen[phone] != 6 {
+			t.Errorf("logs = %+v, phone saw %d", logs.logs, devices.seen[phone])
+		}
+	})
+
+	t.Run("foreign device", func(t *testing.T) {
+		vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+		s, _, _ := newSync(vaults)
+		for _, device := range []uuid.UUID{uuid.Nil, uuid.New()} {
+			if _, err := s.ForceOverwrite(ctx, uuid.New(), device, "", []byte("x"), 1); !errors.Is(err, ErrForeignDevice) {
+				t.Errorf("device %s: error = %v, want ErrForeignDevice", device, err)
+			}
+		}
+		if _, err := s.ForceOverwrite(ctx, uuid.New(), laptop, "", []byte("x"), 1); !errors.Is(err, ErrForeignDevice) {
+			t.Errorf("device of another user: error = %v, want ErrForeignDevice", err)
+		}
+		if vaults.writes != 0 {
+			t.Error("rejected overwrite wrote a vault")
+		}
+	})
+
+	t.Run("failed write", func(t *testing.T) {
+		vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{
+			userID: {UserID: userID, VaultBlob: []byte("old"), Revision: 5, VaultVersion: 1},
+		}}
+		s, logs, devices := newSync(vaults)
+		s.vaults = failingOverwrite{vaults}
+		if _, err := s.ForceOverwrite(ctx, userID, laptop, "", []byte("new"), 1); err == nil {
+			t.Fatal("failed overwrite returned no error")
+		}
+		if v := vaults.vaults[userID]; string(v.VaultBlob) != "old" || v.Revision != 5 {
+			t.Errorf("vault after a failed overwrite = %+v", v)
+		}
+		if len(logs.logs) != 0 || devices.synced != 0 {
+			t.Error("failed overwrite was logged")
+		}
+	})
+}
+
+func TestPush_ReadOnlyDevice(t *testing.T) {
+	ctx := context.Background()
+	userID, laptop, kiosk := uuid.New(), uuid.New(), uuid.New()
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+	devices := &memDeviceSync{
+		owners: map[uuid.UUID]uuid.UUID{laptop: userID, kiosk: userID},
+		ro:     map[uuid.UUID]bool{kiosk: true},
+	}
+	s := NewVaultSync(vaults, &memSyncLogs{}, devices, fixedCampaign{}, 0)
+	blob := base64.StdEncoding.EncodeToString([]byte("vault"))
+
+	verdict, vault, err := s.Push(ctx, userID, kiosk, &models.VaultPushRequest{VaultBlob: blob})
+	if err != nil || verdict.Code != PushCodeReadOnly || vault != nil {
+		t.Errorf("push from a read-only device = %+v, %v, %v", verdict, vault, err)
+	}
+	if _, err := s.ForceOverwrite(ctx, userID, kiosk, "", []byte("x"), 1); !errors.Is(err, ErrDeviceReadOnly) {
+		t.Errorf("overwrite from a read-only device: error = %v, want ErrDeviceReadOnly", err)
+	}
+	if vaults.writes != 0 {
+		t.Fatal("read-only device wrote the vault")
+	}
+
+	if verdict, _, err := s.Push(ctx, userID, laptop, &models.VaultPushRequest{VaultBlob: blob}); err != nil || !verdict.Valid {
+		t.Errorf("push from a writable device = %+v, %v", verdict, err)
+	}
+}
+
+func TestPush_VaultVersion(t *testing.T) {
+	ctx := context.Background()
+	userID, laptop := uuid.New(), uuid.New()
+	vaults := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+	logs := &memSyncLogs{}
+	vaults.logs = logs
+	devices := &memDeviceSync{owners: map[uuid.UUID]uuid.UUID{laptop: userID}}
+	s := NewVaultSync(vaults, logs, devices, fixedCampaign{}, 3)
+	blob := base64.StdEncoding.EncodeToString([]byte("encrypted"))
+	push := func(revision, version int, force bool) *models.VaultPushVerdict {
+		t.Helper()
+		verdict, _, err := s.Push(ctx, userID, laptop, &models.VaultPushRequest{VaultBlob: blob, Revision: revision, VaultVersion: version, Force: force})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return verdict
+	}
+
+	if v := push(0, 2, false); !v.Valid {
+		t.Fatalf("initial push: %+v", v)
+	}
+	if v := push(1, 1, false); v.Code != PushCodeDowngrade || v.ServerVersion != 2 {
+		t.Errorf("downgrade = %+v, want %s", v, PushCodeDowngrade)
+	}
+	if v := push(1, 4, false); v.Code != PushCodeUnsupported {
+		t.Errorf("push above the maximum = %+v, want %s", v, PushCodeUnsupported)
+	}
+	if v := push(1, 0, false); !v.Valid || vaults.vaults[userID].VaultVersion != 2 {
+		t.Errorf("push keeping the version = %+v", v)
+	}
+	if len(logs.versionChanges) != 0 {
+		t.Fatalf("version changes %v without a change", logs.versionChanges)
+	}
+
+	if v := push(2, 3, false); !v.Valid {
+		t.Fatalf("upgrade: %+v", v)
+	}
+	if v := push(3, 1, true); !v.Valid || vaults.vaults[userID].VaultVersion != 1 {
+		t.Errorf("forced downgrade = %+v", v)
+	}
+	if want := [][2]int{{2, 3}, {3, 1}}; !slices.Equal(logs.versionChanges, want) {
+		t.Errorf("version changes = %v, want %v", logs.versionChanges, want)
+	}
+
+	if _, err := s.ForceOverwrite(ctx, userID, laptop, "", []byte("x"), 4); !errors.Is(err, ErrVaultVersionUnsupported) {
+		t.Errorf("overwrite above the maximum: error = %v, want ErrVaultVersionUnsupported", err)
+	}
+	if _, err := s.ForceOverwrite(ctx, userID, laptop, "", []byte("x"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := logs.versionChanges[len(logs.versionChanges)-1]; got != [2]int{1, 2} {
+		t.Errorf("overwrite logged version change %v, want 1 -> 2", got)
+	}
+}
+
+// racingVaults holds the first two reads until both happened, so two
+// pushes plan against the same revision before either writes
+type racingVaults struct {
+	*memVaults
+	reads   atomic.Int32
+	barrier sync.WaitGroup
+}
+
+func (r *racingVaults) GetByUserID(ctx context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error) {
+	v, err := r.memVaults.GetByUserID(ctx, userID, name)
+	if r.reads.Add(1) <= 2 {
+		r.barrier.Done()
+		r.barrier.Wait()
+	}
+	return v, err
+}
+
+func TestPush_ConcurrentPushesAtSameRevision(t *testing.T) {
+	for _, existing := range []bool{false, true} {
+		ctx := context.Background()
+		userID := uuid.New()
+		mem := &memVaults{vaults: map[uuid.UUID]*models.EncryptedVault{}}
+		revision := 0
+		if existing {
+			mem.vaults[userID] = &models.EncryptedVault{UserID: userID, VaultBlob: []byte("base"), Revision: 1, VaultVersion: 1}
+			revision = 1
+		}
+		vaults := &racingVaults{memVaults: mem}
+		vaults.barrier.Add(2)
+		s := NewVaultSync(vaults, &memSyncLogs{}, &memDeviceSync{}, fixedCampaign{}, 0)
+
+		verdicts := make([]*models.VaultPushVerdict, 2)
+		var wg sync.WaitGroup
+		for i := range verdicts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := &models.VaultPushRequest{VaultBlob: base64.StdEncoding.EncodeToString([]byte{byte('a' + i)}), Revision: revision}
+				verdict, _, err := s.Push(ctx, userID, uuid.New(), req)
+				if err != nil {
+					t.Errorf("push %d: %v", i, err)
+				}
+				verdicts[i] = verdict
+			}()
+		}
+		wg.Wait()
+		if t.Failed() {
+			return
+		}
+
+		var won, conflicts int
+		for _, v := range verdicts {
+			switch {
+			case v.Valid:
+				won++
+			case v.Code == PushCodeConflict && v.ServerRevision == revision+1:
+				conflicts++
+			}
+		}
+		if won != 1 || conflicts != 1 {
+			t.Errorf("existing vault %v: verdicts %+v %+v, want one success and one conflict", existing, *verdicts[0], *verdicts[1])
+		}
+		if head := mem.vaults[userID]; head.Revision != revision+1 || mem.writes != 1 {
+			t.Errorf("existing vault %v: head at revision %d after %d writes", existing, head.Revision, mem.writes)
+		}
+	}
+}
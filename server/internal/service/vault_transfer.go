@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+var ErrTransferToSelf = errors.New("source and target user are the same")
+
+type transferUserStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+}
+
+type vaultTransferStore interface {
+	Transfer(ctx context.Context, sourceID, targetID uuid.UUID, opts models.VaultTransferOptions) (*models.EncryptedVault, bool, error)
+}
+
+// VaultTransfer lets admins move a vault to another account, e.g. when a
+// user registered twice by mistake
+type VaultTransfer struct {
+	users    transferUserStore
+	vaults   vaultTransferStore
+	audit    adminAuditStore
+	notifier notify.Notifier
+}
+
+// NewVaultTransfer creates a new vault transfer service
+func NewVaultTransfer(users transferUserStore, vaults vaultTransferStore, audit adminAuditStore, notifier notify.Notifier) *VaultTransfer {
+	return &VaultTransfer{
+		users:    users,
+		vaults:   vaults,
+		audit:    audit,
+		notifier: notifier,
+	}
+}
+
+// VaultTransferResult describes a completed transfer
+type VaultTransferResult struct {
+	Source      *models.User
+	Target      *models.User
+	Vault       *models.EncryptedVault
+	Overwritten bool
+}
+
+// Transfer moves (or copies) the vault of sourceID to targetID. It fails
+// with repository.ErrVaultExists if the target has a vault and
+// opts.Overwrite is not set. Both owners are notified.
+func (s *VaultTransfer) Transfer(ctx context.Context, actorID, sourceID, targetID uuid.UUID, opts models.VaultTransferOptions) (*VaultTransferResult, error) {
+	if sourceID == targetID {
+		return nil, ErrTransferToSelf
+	}
+	source, err := s.users.GetByID(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.users.GetByID(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	vault, overwritten, err := s.vaults.Transfer(ctx, sourceID, targetID, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &VaultTransferResult{Source: source, Target: target, Vault: vault, Overwritten: overwritten}
+
+	s.record(ctx, actorID, result, opts)
+	s.notify(ctx, result, opts)
+	return result, nil
+}
+
+// record writes the audit event. Failures are logged but do not undo the transfer.
+func (s *VaultTransfer) record(ctx context.Context, actorID uuid.UUID, r *VaultTransferResult, opts models.VaultTransferOptions) {
+	action := models.AuditVaultMoved
+	if opts.Copy {
+		action = models.AuditVaultCopied
+	}
+	event := &models.AuditEvent{
+		Action:   action,
+		ActorID:  &actorID,
+		TargetID: &r.Source.ID,
+		Details: map[string]string{
+			"email":             r.Source.Email,
+			"target_user_id":    r.Target.ID.String(),
+			"target_email":      r.Target.Email,
+			"revision":          strconv.Itoa(r.Vault.Revision),
+			"overwritten":       strconv.FormatBool(r.Overwritten),
+			"include_sync_logs": strconv.FormatBool(opts.IncludeSyncLogs),
+		},
+	}
+	if err := s.audit.Create(ctx, event); err != nil {
+		log.Error().Err(err).Str("action", action).Str("user_id", r.Source.ID.String()).Msg("Failed to record audit event")
+	}
+}
+
+// notify tells both account owners what happened to their vaults
+func (s *VaultTransfer) notify(ctx context.Context, r *VaultTransferResult, opts models.VaultTransferOptions) {
+	sourceBody := fmt.Sprintf("An administrator moved the synced vault of this account to %s.", r.Target.Email)
+	if opts.Copy {
+		sourceBody = fmt.Sprintf("An administrator copied the synced vault of this account to %s.", r.Target.Email)
+	}
+	targetBody := fmt.Sprintf("An administrator transferred the synced vault of %s to this account.", r.Source.Email)
+	if r.Overwritten {
+		targetBody += " The vault previously stored for this account was replaced and kept in its history."
+	}
+
+	for _, n := range []notify.Notification{
+		{Kind: notify.KindVaultTransferred, UserID: r.Source.ID, Email: r.Source.Email, Subject: "Your vault was transferred", Body: sourceBody},
+		{Kind: notify.KindVaultTransferred, UserID: r.Target.ID, Email: r.Target.Email, Subject: "A vault was transferred to your account", Body: targetBody},
+	} {
+		if err := s.notifier.Notify(ctx, n); err != nil {
+			log.Error().Err(err).Str("user_id", n.UserID.String()).Msg("Failed to send vault transfer notification")
+		}
+	}
+}
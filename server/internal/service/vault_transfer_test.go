@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memTransferVaults follows the VaultRepository.Transfer contract in memory
+type memTransferVaults struct {
+	vaults  map[uuid.UUID]*models.EncryptedVault
+	history map[uuid.UUID][]models.EncryptedVault
+}
+
+func (m *memTransferVaults) Transfer(_ context.Context, sourceID, targetID uuid.UUID, opts models.VaultTransferOptions) (*models.EncryptedVault, bool, error) {
+	source, ok := m.vaults[sourceID]
+	if !ok {
+		return nil, false, repository.ErrVaultNotFound
+	}
+	old, replaced := m.vaults[targetID]
+	if replaced {
+		if !opts.Overwrite {
+			return nil, false, repository.ErrVaultExists
+		}
+		m.history[targetID] = append(m.history[targetID], *old)
+	}
+
+	moved := *source
+	moved.UserID = targetID
+	moved.UpdatedByDevice = nil
+	m.vaults[targetID] = &moved
+	m.history[targetID] = append(m.history[targetID], m.history[sourceID]...)
+	if !opts.Copy {
+		delete(m.vaults, sourceID)
+		delete(m.history, sourceID)
+	}
+	return &moved, replaced, nil
+}
+
+type vaultTransferFixture struct {
+	stores   *fakeAdminStores
+	vaults   *memTransferVaults
+	notifier *recordingNotifier
+	service  *VaultTransfer
+	source   *models.User
+	target   *models.User
+}
+
+func newVaultTransferFixture() *vaultTransferFixture {
+	f := &vaultTransferFixture{
+		stores: newFakeAdminStores(),
+		vaults: &memTransferVaults{
+			vaults:  make(map[uuid.UUID]*models.EncryptedVault),
+			history: make(map[uuid.UUID][]models.EncryptedVault),
+		},
+		notifier: &recordingNotifier{},
+		source:   &models.User{ID: uuid.New(), Email: "old@example.com"},
+		target:   &models.User{ID: uuid.New(), Email: "new@example.com"},
+	}
+	f.stores.users[f.source.ID] = f.source
+	f.stores.users[f.target.ID] = f.target
+	device := uuid.New()
+	f.vaults.vaults[f.source.ID] = &models.EncryptedVault{UserID: f.source.ID, VaultBlob: []byte("source"), Revision: 7, UpdatedByDevice: &device}
+	f.service = NewVaultTransfer(fakeUsers{f.stores}, f.vaults, fakeAudit{f.stores}, f.notifier)
+	return f
+}
+
+func TestVaultTransferRefusesExistingTargetVault(t *testing.T) {
+	f := newVaultTransferFixture()
+	f.vaults.vaults[f.target.ID] = &models.EncryptedVault{UserID: f.target.ID, VaultBlob: []byte("target"), Revision: 2}
+
+	_, err := f.service.Transfer(context.Background(), uuid.New(), f.source.ID, f.target.ID, models.VaultTransferOptions{})
+	if !errors.Is(err, repository.ErrVaultExists) {
+		t.Fatalf("expected ErrVaultExists, got %v", err)
+	}
+	if string(f.vaults.vaults[f.target.ID].VaultBlob) != "target" {
+		t.Error("target vault was changed")
+	}
+	if len(f.stores.audit) != 0 || len(f.notifier.sent) != 0 {
+		t.Error("refused transfer was audited or notified")
+	}
+}
+
+func TestVaultTransferOverwriteKeepsOldVaultInHistory(t *testing.T) {
+	f := newVaultTransferFixture()
+	f.vaults.vaults[f.target.ID] = &models.EncryptedVault{UserID: f.target.ID, VaultBlob: []byte("target"), Revision: 2}
+	f.vaults.history[f.source.ID] = []models.EncryptedVault{{VaultBlob: []byte("source-r6"), Revision: 6}}
+
+	result, err := f.service.Transfer(context.Background(), uuid.New(), f.source.ID, f.target.ID, models.VaultTransferOptions{Overwrite: true})
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if !result.Overwritten || result.Vault.Revision != 7 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if string(f.vaults.vaults[f.target.ID].VaultBlob) != "source" {
+		t.Error("target does not hold the source vault")
+	}
+	if _, ok := f.vaults.vaults[f.source.ID]; ok {
+		t.Error("move left the source vault in place")
+	}
+
+	history := f.vaults.history[f.target.ID]
+	if len(history) != 2 || string(history[0].VaultBlob) != "target" || string(history[1].VaultBlob) != "source-r6" {
+		t.Errorf("target history = %+v, want old target vault and source history", history)
+	}
+
+	if len(f.stores.audit) != 1 || f.stores.audit[0].Action != models.AuditVaultMoved {
+		t.Fatalf("audit = %+v", f.stores.audit)
+	}
+	if got := f.stores.audit[0].Details["overwritten"]; got != "true" {
+		t.Errorf("audit overwritten = %q", got)
+	}
+}
+
+func TestVaultTransferCopyNotifiesBothUsers(t *testing.T) {
+	f := newVaultTransferFixture()
+
+	result, err := f.service.Transfer(context.Background(), uuid.New(), f.source.ID, f.target.ID, models.VaultTransferOptions{Copy: true})
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if result.Overwritten {
+		t.Error("copy into empty account reported an overwrite")
+	}
+	if _, ok := f.vaults.vaults[f.source.ID]; !ok {
+		t.Error("copy removed the source vault")
+	}
+	if f.stores.audit[0].Action != models.AuditVaultCopied {
+		t.Errorf("audit action = %s", f.stores.audit[0].Action)
+	}
+
+	notified := map[uuid.UUID]bool{}
+	for _, n := range f.notifier.sent {
+		if n.Kind != notify.KindVaultTransferred {
+			t.Errorf("unexpected notification kind %s", n.Kind)
+		}
+		notified[n.UserID] = true
+	}
+	if !notified[f.source.ID] || !notified[f.target.ID] {
+		t.Errorf("notified %v, want both users", notified)
+	}
+}
+
+func TestVaultTransferRejectsInvalidTargets(t *testing.T) {
+	f := newVaultTransferFixture()
+	ctx := context.Background()
+
+	if _, err := f.service.Transfer(ctx, uuid.New(), f.source.ID, f.source.ID, models.VaultTransferOptions{}); !errors.Is(err, ErrTransferToSelf) {
+		t.Errorf("self transfer: got %v", err)
+	}
+	if _, err := f.service.Transfer(ctx, uuid.New(), f.source.ID, uuid.New(), models.VaultTransferOptions{}); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("unknown target: got %v", err)
+	}
+	if _, err := f.service.Transfer(ctx, uuid.New(), f.target.ID, f.source.ID, models.VaultTransferOptions{}); !errors.Is(err, repository.ErrVaultNotFound) {
+		t.Errorf("source without vault: got %v", err)
+	}
+}
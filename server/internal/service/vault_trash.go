@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// vaultTrashPurgeInterval is how often vaults past the grace period are
+// purged
+const vaultTrashPurgeInterval = time.Hour
+
+// vaultTrashStore is the subset of VaultRepository needed for soft deletion
+type vaultTrashStore interface {
+	SoftDelete(ctx context.Context, userID uuid.UUID, name string) (*models.EncryptedVault, error)
+	Undelete(ctx context.Context, userID uuid.UUID, name string, deletedAfter time.Time) (*models.EncryptedVault, error)
+	PurgeDeleted(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// VaultTrash soft-deletes vaults. A deleted vault can be undeleted during
+// the grace period and is purged after it.
+type VaultTrash struct {
+	vaults   vaultTrashStore
+	syncLogs syncLogWriter
+	grace    time.Duration
+	now      func() time.Time
+}
+
+// NewVaultTrash creates the soft deletion service. Deleted vaults are kept
+// for grace.
+func NewVaultTrash(vaults vaultTrashStore, syncLogs syncLogWriter, grace time.Duration) *VaultTrash {
+	return &VaultTrash{vaults: vaults, syncLogs: syncLogs, grace: max(grace, 0), now: time.Now}
+}
+
+// Delete soft-deletes the named vault and reports until when it can be
+// undeleted. It returns repository.ErrVaultNotFound without a vault.
+func (t *VaultTrash) Delete(ctx context.Context, userID, deviceID uuid.UUID, name string) (*models.VaultDeleteResponse, error) {
+	name = vaultName(name)
+	vault, err := t.vaults.SoftDelete(ctx, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	_ = t.syncLogs.Create(ctx, userID, &deviceID, name, "delete", &vault.Revision, nil)
+	return &models.VaultDeleteResponse{
+		Status:          "deleted",
+		Revision:        vault.Revision,
+		DeletedAt:       vault.DeletedAt.Unix(),
+		RestorableUntil: vault.DeletedAt.Add(t.grace).Unix(),
+	}, nil
+}
+
+// Undelete brings back the named vault deleted within the grace period.
+// It returns repository.ErrVaultNotFound if there is none.
+func (t *VaultTrash) Undelete(ctx context.Context, userID, deviceID uuid.UUID, name string) (*models.EncryptedVault, error) {
+	name = vaultName(name)
+	vault, err := t.vaults.Undelete(ctx, userID, name, t.now().Add(-t.grace))
+	if err != nil {
+		return nil, err
+	}
+	_ = t.syncLogs.Create(ctx, userID, &deviceID, name, "undelete", nil, &vault.Revision)
+	return vault, nil
+}
+
+// Purge removes vaults deleted before the grace period for good
+func (t *VaultTrash) Purge(ctx context.Context) (int64, error) {
+	return t.vaults.PurgeDeleted(ctx, t.now().Add(-t.grace))
+}
+
+// Run purges expired vaults until ctx is cancelled
+func (t *VaultTrash) Run(ctx context.Context) {
+	ticker := time.NewTicker(vaultTrashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := t.Purge(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to purge deleted vaults")
+		} else if n > 0 {
+			log.Info().Int64("count", n).Msg("Purged deleted vaults")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// memTrashVaults follows the soft deletion contract of VaultRepository
+type memTrashVaults struct {
+	vaults map[uuid.UUID]*models.EncryptedVault
+	now    func() time.Time
+}
+
+func (m *memTrashVaults) SoftDelete(_ context.Context, userID uuid.UUID, _ string) (*models.EncryptedVault, error) {
+	v, ok := m.vaults[userID]
+	if !ok || v.DeletedAt != nil {
+		return nil, repository.ErrVaultNotFound
+	}
+	now := m.now()
+	v.DeletedAt = &now
+	cp := *v
+	return &cp, nil
+}
+
+func (m *memTrashVaults) Undelete(_ context.Context, userID uuid.UUID, _ string, deletedAfter time.Time) (*models.EncryptedVault, error) {
+	v, ok := m.vaults[userID]
+	if !ok || v.DeletedAt == nil || !v.DeletedAt.After(deletedAfter) {
+		return nil, repository.ErrVaultNotFound
+	}
+	v.DeletedAt = nil
+	cp := *v
+	return &cp, nil
+}
+
+func (m *memTrashVaults) PurgeDeleted(_ context.Context, cutoff time.Time) (int64, error) {
+	var n int64
+	for id, v := range m.vaults {
+		if v.DeletedAt != nil && v.DeletedAt.Before(cutoff) {
+			delete(m.vaults, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestVaultTrash(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	userID, deviceID := uuid.New(), uuid.New()
+	vaults := &memTrashVaults{
+		vaults: map[uuid.UUID]*models.EncryptedVault{userID: {UserID: userID, Revision: 4}},
+		now:    func() time.Time { return now },
+	}
+	logs := &memSyncLogs{}
+	trash := NewVaultTrash(vaults, logs, 7*24*time.Hour)
+	trash.now = vaults.now
+
+	deleted, err := trash.Delete(ctx, userID, deviceID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Revision != 4 || deleted.RestorableUntil != now.Add(7*24*time.Hour).Unix() {
+		t.Errorf("delete = %+v", deleted)
+	}
+	if _, err := trash.Delete(ctx, userID, deviceID, ""); !errors.Is(err, repository.ErrVaultNotFound) {
+		t.Errorf("second delete: error = %v, want ErrVaultNotFound", err)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if n, _ := trash.Purge(ctx); n != 0 {
+		t.Fatalf("purged %d vaults within the grace period", n)
+	}
+	if vault, err := trash.Undelete(ctx, userID, deviceID, ""); err != nil || vault.Revision != 4 {
+		t.Fatalf("undelete = %+v, %v", vault, err)
+	}
+	if logs.entries != 2 {
+		t.Errorf("%d sync log entries, want delete and undelete", logs.entries)
+	}
+
+	if _, err := trash.Delete(ctx, userID, deviceID, ""); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(7*24*time.Hour + time.Second)
+	if _, err := trash.Undelete(ctx, userID, deviceID, ""); !errors.Is(err, repository.ErrVaultNotFound) {
+		t.Errorf("undelete after the grace period: error = %v, want ErrVaultNotFound", err)
+	}
+	if n, _ := trash.Purge(ctx); n != 1 || len(vaults.vaults) != 0 {
+		t.Errorf("purged %d vaults, want 1", n)
+	}
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+)
+
+// webAuthnChallengeTTL bounds the time between the begin and finish call
+// of a ceremony
+const webAuthnChallengeTTL = 5 * time.Minute
+
+// defaultWebAuthnCredentialName names credentials registered without a name
+const defaultWebAuthnCredentialName = "Security key"
+
+var (
+	ErrWebAuthnNotEnabled       = errors.New("no webauthn credentials registered")
+	ErrWebAuthnChallengeInvalid = errors.New("webauthn challenge invalid or expired")
+	ErrWebAuthnVerification     = errors.New("webauthn verification failed")
+)
+
+// webAuthnStore is the subset of WebAuthnRepository needed for WebAuthn
+type webAuthnStore interface {
+	CreateCredential(ctx context.Context, cred *models.WebAuthnCredential) error
+	GetCredentialsByUser(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	CountCredentials(ctx context.Context, userID uuid.UUID) (int, error)
+	RecordCredentialUse(ctx context.Context, id uuid.UUID, signCount uint32, backupState bool) error
+	DeleteCredential(ctx context.Context, userID, id uuid.UUID) error
+	CreateChallenge(ctx context.Context, ch *models.WebAuthnChallenge) error
+	ConsumeChallenge(ctx context.Context, id, userID uuid.UUID, ceremony string) (*models.WebAuthnChallenge, error)
+	DeleteExpiredChallenges(ctx context.Context) (int64, error)
+}
+
+// WebAuthnConfig identifies the relying party to authenticators
+type WebAuthnConfig struct {
+	RPID        string   // domain the credentials are scoped to
+	DisplayName string   // shown by the authenticator
+	Origins     []string // origins the ceremonies may run on
+}
+
+// WebAuthn registers passkeys and security keys and verifies them as a
+// second factor. Each ceremony is split into a begin call, which stores
+// a challenge, and a finish call, which consumes it.
+type WebAuthn struct {
+	rp    *webauthn.WebAuthn
+	store webAuthnStore
+	now   func() time.Time
+}
+
+// NewWebAuthn creates a new WebAuthn service
+func NewWebAuthn(cfg WebAuthnConfig, store webAuthnStore) (*WebAuthn, error) {
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.DisplayName,
+		RPOrigins:     cfg.Origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnChallengeTTL, TimeoutUVD: webAuthnChallengeTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnChallengeTTL, TimeoutUVD: webAuthnChallengeTTL},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: %w", err)
+	}
+	return &WebAuthn{rp: rp, store: store, now: time.Now}, nil
+}
+
+// BeginRegistration starts registering a credential for user. Already
+// registered authenticators are excluded.
+func (s *WebAuthn) BeginRegistration(ctx context.Context, user *models.User) (*protocol.CredentialCreation, uuid.UUID, error) {
+	account, err := s.account(ctx, user)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	creation, session, err := s.rp.BeginRegistration(account,
+		webauthn.WithExclusions(webauthn.Credentials(account.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	id, err := s.saveChallenge(ctx, user.ID, models.WebAuthnCeremonyRegistration, session)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	return creation, id, nil
+}
+
+// FinishRegistration verifies the authenticator's response to the
+// challenge and stores the new credential
+func (s *WebAuthn) FinishRegistration(ctx context.Context, user *models.User, challengeID uuid.UUID, name string, response []byte) (*models.WebAuthnCredential, error) {
+	session, err := s.consumeChallenge(ctx, user.ID, challengeID, models.WebAuthnCeremonyRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerification, err)
+	}
+	account, err := s.account(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := s.rp.CreateCredential(account, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerification, err)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultWebAuthnCredentialName
+	}
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+	cred := &models.WebAuthnCredential{
+		ID:              uuid.New(),
+		UserID:          user.ID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       s.now(),
+	}
+	if err := s.store.CreateCredential(ctx, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// BeginLogin starts verifying one of user's credentials as second
+// factor. It returns ErrWebAuthnNotEnabled if the user has none.
+func (s *WebAuthn) BeginLogin(ctx context.Context, user *models.User) (*protocol.CredentialAssertion, uuid.UUID, error) {
+	account, err := s.account(ctx, user)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if len(account.credentials) == 0 {
+		return nil, uuid.Nil, ErrWebAuthnNotEnabled
+	}
+
+	assertion, session, err := s.rp.BeginLogin(account)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	id, err := s.saveChallenge(ctx, user.ID, models.WebAuthnCeremonyLogin, session)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	return assertion, id, nil
+}
+
+// FinishLogin verifies the authenticator's assertion for the challenge
+// and returns the credential used. An assertion whose signature counter
+// went backwards points to a cloned authenticator and is rejected.
+func (s *WebAuthn) FinishLogin(ctx context.Context, user *models.User, challengeID uuid.UUID, response []byte) (*models.WebAuthnCredential, error) {
+	session, err := s.consumeChallenge(ctx, user.ID, challengeID, models.WebAuthnCeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerification, err)
+	}
+	account, err := s.account(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := s.rp.ValidateLogin(account, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerification, err)
+	}
+
+	cred := account.stored(credential.ID)
+	if cred == nil {
+		return nil, ErrWebAuthnVerification
+	}
+	if credential.Authenticator.CloneWarning {
+		log.Warn().Str("user_id", user.ID.String()).Str("credential_id", cred.ID.String()).
+			Msg("WebAuthn signature counter went backwards, possible cloned authenticator")
+		return nil, fmt.Errorf("%w: signature counter went backwards", ErrWebAuthnVerification)
+	}
+
+	if err := s.store.RecordCredentialUse(ctx, cred.ID, credential.Authenticator.SignCount, credential.Flags.BackupState); err != nil {
+		log.Error().Err(err).Str("credential_id", cred.ID.String()).Msg("Failed to record WebAuthn credential use")
+	}
+	return cred, nil
+}
+
+// List returns the user's credentials
+func (s *WebAuthn) List(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	return s.store.GetCredentialsByUser(ctx, userID)
+}
+
+// Delete removes one of the user's credentials
+func (s *WebAuthn) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	return s.store.DeleteCredential(ctx, userID, id)
+}
+
+// Enabled reports whether the user has a credential to log in with
+func (s *WebAuthn) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	count, err := s.store.CountCredentials(ctx, userID)
+	return count > 0, err
+}
+
+// saveChallenge stores the session data of a started ceremony and
+// returns its ID
+func (s *WebAuthn) saveChallenge(ctx context.Context, userID uuid.UUID, ceremony string, session *webauthn.SessionData) (uuid.UUID, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Abandoned ceremonies are cleaned up as new ones start
+	if _, err := s.store.DeleteExpiredChallenges(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to delete expired WebAuthn challenges")
+	}
+
+	ch := &models.WebAuthnChallenge{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Ceremony:    ceremony,
+		SessionData: data,
+		ExpiresAt:   s.now().Add(webAuthnChallengeTTL),
+	}
+	if err := s.store.CreateChallenge(ctx, ch); err != nil {
+		return uuid.Nil, err
+	}
+	return ch.ID, nil
+}
+
+// consumeChallenge returns the session data of the user's ceremony. The
+// challenge cannot be used again.
+func (s *WebAuthn) consumeChallenge(ctx context.Context, userID, id uuid.UUID, ceremony string) (*webauthn.SessionData, error) {
+	ch, err := s.store.ConsumeChallenge(ctx, id, userID, ceremony)
+	if errors.Is(err, repository.ErrWebAuthnChallengeNotFound) {
+		return nil, ErrWebAuthnChallengeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(ch.SessionData, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// account loads the user's credentials for the WebAuthn library
+func (s *WebAuthn) account(ctx context.Context, user *models.User) (*webAuthnAccount, error) {
+	creds, err := s.store.GetCredentialsByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	account := &webAuthnAccount{user: user, creds: creds}
+	for _, c := range creds {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for i, t := range c.Transports {
+			transports[i] = protocol.AuthenticatorTransport(t)
+		}
+		account.credentials = append(account.credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.CredentialFlags{BackupEligible: c.BackupEligible, BackupState: c.BackupState},
+			Authenticator:   webauthn.Authenticator{AAGUID: c.AAGUID, SignCount: c.SignCount},
+		})
+	}
+	return account, nil
+}
+
+// webAuthnAccount adapts a user and their credentials to webauthn.User.
+// The user handle is the user ID, so it reveals nothing about the email.
+type webAuthnAccount struct {
+	user        *models.User
+	creds       []models.WebAuthnCredential
+	credentials []webauthn.Credential
+}
+
+func (a *webAuthnAccount) WebAuthnID() []byte {
+	return a.user.ID[:]
+}
+
+func (a *webAuthnAccount) WebAuthnName() string {
+	return a.user.Email
+}
+
+func (a *webAuthnAccount) WebAuthnDisplayName() string {
+	if a.user.DisplayName != "" {
+		return a.user.DisplayName
+	}
+	return a.user.Email
+}
+
+func (a *webAuthnAccount) WebAuthnCredentials() []webauthn.Credential {
+	return a.credentials
+}
+
+// stored returns the stored credential with the given authenticator ID
+func (a *webAuthnAccount) stored(credentialID []byte) *models.WebAuthnCredential {
+	for i := range a.creds {
+		if string(a.creds[i].CredentialID) == string(credentialID) {
+			return &a.creds[i]
+		}
+	}
+	return nil
+}
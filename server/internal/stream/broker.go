@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Event tells the watchers of a user that one of their vaults changed
+type Event struct {
+	Vault    string // name of the vault
+	Revision int
+	DeviceID uuid.UUID // device that made the change
+}
+
+// Broker fans out vault changes to the watchers of each user within this
+// server instance. A watcher that falls behind only keeps the latest
+// event.
+type Broker struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[chan Event]string // channel to vault name
+}
+
+// NewBroker creates an empty broker
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[uuid.UUID]map[chan Event]string)}
+}
+
+// Subscribe returns the events of the named vault of userID and a
+// function that ends the subscription. The function must be called.
+func (b *Broker) Subscribe(userID uuid.UUID, vault string) (<-chan Event, func()) {
+	ch := make(chan Event, 1)
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan Event]string)
+	}
+	b.subs[userID][ch] = vault
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs[userID], ch)
+			if len(b.subs[userID]) == 0 {
+				delete(b.subs, userID)
+			}
+		})
+	}
+}
+
+// Publish sends ev to every watcher of the vault of userID without
+// blocking
+func (b *Broker) Publish(userID uuid.UUID, ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, vault := range b.subs[userID] {
+		if vault != ev.Vault {
+			continue
+		}
+		// Publishers hold mu, so the slot freed here stays free
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- ev
+	}
+}
+
+// Watchers returns the number of subscriptions of userID
+func (b *Broker) Watchers(userID uuid.UUID) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs[userID])
+}
@@ -0,0 +1,44 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBrokerPublish(t *testing.T) {
+	b := NewBroker()
+	user, other := uuid.New(), uuid.New()
+
+	events, cancel := b.Subscribe(user, "default")
+	otherEvents, cancelOther := b.Subscribe(other, "default")
+	defer cancelOther()
+	workEvents, cancelWork := b.Subscribe(user, "work")
+	defer cancelWork()
+
+	// A watcher that does not read keeps only the latest event
+	device := uuid.New()
+	b.Publish(user, Event{Vault: "default", Revision: 2, DeviceID: device})
+	b.Publish(user, Event{Vault: "default", Revision: 3, DeviceID: device})
+	// Events of another vault do not displace it
+	b.Publish(user, Event{Vault: "work", Revision: 9, DeviceID: device})
+	if ev := <-events; ev.Revision != 3 || ev.DeviceID != device {
+		t.Errorf("event = %+v, want revision 3", ev)
+	}
+	if ev := <-workEvents; ev.Vault != "work" || ev.Revision != 9 {
+		t.Errorf("work event = %+v, want revision 9", ev)
+	}
+	select {
+	case ev := <-otherEvents:
+		t.Errorf("other user got %+v", ev)
+	default:
+	}
+
+	cancel()
+	cancel()
+	cancelWork()
+	if n := b.Watchers(user); n != 0 {
+		t.Errorf("Watchers = %d after cancel", n)
+	}
+	b.Publish(user, Event{Vault: "default", Revision: 4})
+}
@@ -0,0 +1,233 @@
+// Package stream tracks long-lived streaming connections (SSE, WebSocket,
+// long polling) and enforces per-user and global connection limits.
+package stream
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUserLimit    = errors.New("too many streaming connections for user")
+	ErrGlobalLimit  = errors.New("too many streaming connections")
+	ErrShuttingDown = errors.New("server shutting down")
+)
+
+// Hub owns every open streaming connection
+type Hub struct {
+	maxPerUser int
+	maxGlobal  int
+
+	mu      sync.Mutex
+	conns   map[uuid.UUID]*Conn
+	perUser map[uuid.UUID]int
+	closed  bool
+}
+
+// NewHub creates a hub. A limit <= 0 means unlimited.
+func NewHub(maxPerUser, maxGlobal int) *Hub {
+	return &Hub{
+		maxPerUser: maxPerUser,
+		maxGlobal:  maxGlobal,
+		conns:      make(map[uuid.UUID]*Conn),
+		perUser:    make(map[uuid.UUID]int),
+	}
+}
+
+// Conn is a registered streaming connection. Its context is cancelled
+// when the connection is closed, terminated by an admin or the server
+// shuts down.
+type Conn struct {
+	ID       uuid.UUID
+	UserID   uuid.UUID
+	DeviceID uuid.UUID
+	OpenedAt time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	hub    *Hub
+	once   sync.Once
+}
+
+// Context returns the connection's context
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// Close releases the connection's slot. It is safe to call more than once.
+func (c *Conn) Close() {
+	c.once.Do(func() {
+		c.cancel()
+		c.hub.release(c)
+	})
+}
+
+// Open registers a new connection derived from parent. The slot is
+// released when Close is called or parent is done, whichever comes first.
+func (h *Hub) Open(parent context.Context, userID, deviceID uuid.UUID) (*Conn, error) {
+	h.mu.Lock()
+	switch {
+	case h.closed:
+		h.mu.Unlock()
+		return nil, ErrShuttingDown
+	case h.maxGlobal > 0 && len(h.conns) >= h.maxGlobal:
+		h.mu.Unlock()
+		return nil, ErrGlobalLimit
+	case h.maxPerUser > 0 && h.perUser[userID] >= h.maxPerUser:
+		h.mu.Unlock()
+		return nil, ErrUserLimit
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	conn := &Conn{
+		ID:       uuid.New(),
+		UserID:   userID,
+		DeviceID: deviceID,
+		OpenedAt: time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+		hub:      h,
+	}
+	h.conns[conn.ID] = conn
+	h.perUser[userID]++
+	h.mu.Unlock()
+
+	// Release on every disconnect path, even if the caller never closes
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	return conn, nil
+}
+
+func (h *Hub) release(c *Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.conns[c.ID]; !ok {
+		return
+	}
+	delete(h.conns, c.ID)
+	if h.perUser[c.UserID]--; h.perUser[c.UserID] <= 0 {
+		delete(h.perUser, c.UserID)
+	}
+}
+
+// Terminate closes a single connection
+func (h *Hub) Terminate(id uuid.UUID) bool {
+	h.mu.Lock()
+	conn, ok := h.conns[id]
+	h.mu.Unlock()
+
+	if ok {
+		conn.Close()
+	}
+	return ok
+}
+
+// TerminateUser closes all connections of a user and returns how many
+func (h *Hub) TerminateUser(userID uuid.UUID) int {
+	h.mu.Lock()
+	var conns []*Conn
+	for _, c := range h.conns {
+		if c.UserID == userID {
+			conns = append(conns, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
+// Shutdown closes all connections and refuses new ones
+func (h *Hub) Shutdown() {
+	h.mu.Lock()
+	h.closed = true
+	conns := make([]*Conn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Total returns the number of open connections
+func (h *Hub) Total() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+// UserCount returns the number of open connections of a user
+func (h *Hub) UserCount(userID uuid.UUID) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.perUser[userID]
+}
+
+// Stats describes the open connections of a Hub
+type Stats struct {
+	Open       int            `json:"open"`
+	PerUser    map[string]int `json:"per_user"` // user ID → open connections
+	MaxPerUser int            `json:"max_per_user"`
+	MaxGlobal  int            `json:"max_global"`
+}
+
+// Stats returns a snapshot of the open connections
+func (h *Hub) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	perUser := make(map[string]int, len(h.perUser))
+	for userID, n := range h.perUser {
+		perUser[userID.String()] = n
+	}
+	return Stats{Open: len(h.conns), PerUser: perUser, MaxPerUser: h.maxPerUser, MaxGlobal: h.maxGlobal}
+}
+
+// Publish exposes the hub's Stats as the expvar name. It panics if the
+// name is taken, so call it once per process.
+func (h *Hub) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return h.Stats() }))
+}
+
+// Limits returns the configured per-user and global limits
+func (h *Hub) Limits() (perUser, global int) {
+	return h.maxPerUser, h.maxGlobal
+}
+
+// Info describes an open connection
+type Info struct {
+	ID       uuid.UUID
+	UserID   uuid.UUID
+	DeviceID uuid.UUID
+	OpenedAt time.Time
+}
+
+// List returns a snapshot of open connections, oldest first
+func (h *Hub) List() []Info {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	list := make([]Info, 0, len(h.conns))
+	for _, c := range h.conns {
+		list = append(list, Info{ID: c.ID, UserID: c.UserID, DeviceID: c.DeviceID, OpenedAt: c.OpenedAt})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].OpenedAt.Before(list[j].OpenedAt)
+	})
+	return list
+}
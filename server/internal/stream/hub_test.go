@@ -0,0 +1,169 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubPerUserLimit(t *testing.T) {
+	hub := NewHub(2, 0)
+	user := uuid.New()
+	ctx := context.Background()
+
+	a, err := hub.Open(ctx, user, uuid.New())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := hub.Open(ctx, user, uuid.New()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := hub.Open(ctx, user, uuid.New()); !errors.Is(err, ErrUserLimit) {
+		t.Fatalf("expected ErrUserLimit, got %v", err)
+	}
+
+	// Other users are unaffected
+	if _, err := hub.Open(ctx, uuid.New(), uuid.New()); err != nil {
+		t.Fatalf("Open for other user: %v", err)
+	}
+
+	// Closing recycles the slot, and closing twice is harmless
+	a.Close()
+	a.Close()
+	if _, err := hub.Open(ctx, user, uuid.New()); err != nil {
+		t.Fatalf("Open after Close: %v", err)
+	}
+	if got := hub.UserCount(user); got != 2 {
+		t.Errorf("UserCount = %d, want 2", got)
+	}
+}
+
+func TestHubGlobalLimit(t *testing.T) {
+	hub := NewHub(0, 2)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := hub.Open(ctx, uuid.New(), uuid.New()); err != nil {
+			t.Fatalf("Open %d: %v", i, err)
+		}
+	}
+	if _, err := hub.Open(ctx, uuid.New(), uuid.New()); !errors.Is(err, ErrGlobalLimit) {
+		t.Fatalf("expected ErrGlobalLimit, got %v", err)
+	}
+}
+
+func TestHubReleasesOnContextCancel(t *testing.T) {
+	hub := NewHub(1, 0)
+	user := uuid.New()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if _, err := hub.Open(ctx, user, uuid.New()); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	cancel()
+	waitFor(t, func() bool { return hub.UserCount(user) == 0 })
+
+	if _, err := hub.Open(context.Background(), user, uuid.New()); err != nil {
+		t.Fatalf("Open after cancel: %v", err)
+	}
+}
+
+func TestHubTerminate(t *testing.T) {
+	hub := NewHub(0, 0)
+	user := uuid.New()
+	ctx := context.Background()
+
+	a, _ := hub.Open(ctx, user, uuid.New())
+	b, _ := hub.Open(ctx, user, uuid.New())
+	other, _ := hub.Open(ctx, uuid.New(), uuid.New())
+
+	if !hub.Terminate(a.ID) {
+		t.Fatal("Terminate returned false for open stream")
+	}
+	if a.Context().Err() == nil {
+		t.Error("terminated stream context not cancelled")
+	}
+	if hub.Terminate(a.ID) {
+		t.Error("Terminate returned true for closed stream")
+	}
+
+	if n := hub.TerminateUser(user); n != 1 {
+		t.Errorf("TerminateUser = %d, want 1", n)
+	}
+	if b.Context().Err() == nil || other.Context().Err() != nil {
+		t.Error("TerminateUser closed the wrong streams")
+	}
+	if got := hub.Total(); got != 1 {
+		t.Errorf("Total = %d, want 1", got)
+	}
+}
+
+func TestHubShutdown(t *testing.T) {
+	hub := NewHub(0, 0)
+	conn, _ := hub.Open(context.Background(), uuid.New(), uuid.New())
+
+	hub.Shutdown()
+	if conn.Context().Err() == nil {
+		t.Error("stream not cancelled on shutdown")
+	}
+	if hub.Total() != 0 {
+		t.Errorf("Total = %d after shutdown", hub.Total())
+	}
+	if _, err := hub.Open(context.Background(), uuid.New(), uuid.New()); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("expected ErrShuttingDown, got %v", err)
+	}
+}
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub(3, 10)
+	hub.Publish("streams_test")
+	alice, bob := uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	stats := func() Stats {
+		t.Helper()
+		var s Stats
+		if err := json.Unmarshal([]byte(expvar.Get("streams_test").String()), &s); err != nil {
+			t.Fatalf("decode expvar: %v", err)
+		}
+		return s
+	}
+
+	var conns []*Conn
+	for _, user := range []uuid.UUID{alice, alice, bob} {
+		c, err := hub.Open(ctx, user, uuid.New())
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		conns = append(conns, c)
+	}
+	s := stats()
+	if s.Open != 3 || s.PerUser[alice.String()] != 2 || s.PerUser[bob.String()] != 1 || s.MaxPerUser != 3 || s.MaxGlobal != 10 {
+		t.Errorf("stats with three streams = %+v", s)
+	}
+
+	for _, c := range conns[1:] {
+		c.Close()
+	}
+	s = stats()
+	if _, ok := s.PerUser[bob.String()]; s.Open != 1 || s.PerUser[alice.String()] != 1 || ok {
+		t.Errorf("stats after closing two streams = %+v", s)
+	}
+}
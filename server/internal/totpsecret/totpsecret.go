@@ -0,0 +1,137 @@
+// Package totpsecret generates TOTP secrets and converts between the raw
+// bytes stored for a user and the base32 form authenticator apps use
+package totpsecret
+
+import (
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+)
+
+// Size is the size of generated secrets in bytes
+const Size = 20
+
+// Defaults of the validation window
+const (
+	DefaultPeriod = 30 // seconds per code
+	DefaultSkew   = 1  // steps accepted before and after the current one
+)
+
+// Window is the time steps in which a code is accepted
+type Window struct {
+	Period uint // seconds per time step
+	Skew   uint // steps accepted on either side of the current one
+}
+
+// The package-level window is used by Generate, Validate and Match.
+// Configure replaces it at startup.
+var window atomic.Pointer[Window]
+
+func init() {
+	window.Store(&Window{Period: DefaultPeriod, Skew: DefaultSkew})
+}
+
+// Configure sets the period and skew of the package-level window. The
+// period is part of enrolled keys: changing it invalidates existing
+// authenticator entries.
+func Configure(period, skew int) error {
+	if period <= 0 {
+		return errors.New("TOTP period must be positive")
+	}
+	if skew < 0 {
+		return errors.New("TOTP skew must not be negative")
+	}
+	window.Store(&Window{Period: uint(period), Skew: uint(skew)})
+	return nil
+}
+
+// CurrentWindow returns the package-level window
+func CurrentWindow() Window {
+	return *window.Load()
+}
+
+// opts returns the validation options of the package-level window
+func opts() totp.ValidateOpts {
+	w := window.Load()
+	return totp.ValidateOpts{Period: w.Period, Skew: w.Skew, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+}
+
+// Encoding is base32 without padding, as the otp library emits secrets
+var Encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate creates a key of size bytes (Size if 0) and returns it with the
+// raw secret to store. A secret that does not decode back to size bytes is
+// an error rather than stored broken.
+func Generate(issuer, account string, size uint) (*otp.Key, []byte, error) {
+	if size == 0 {
+		size = Size
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: account,
+		SecretSize:  size,
+		Period:      window.Load().Period,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := Encoding.DecodeString(key.Secret())
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode TOTP secret: %w", err)
+	}
+	if len(secret) != int(size) {
+		return nil, nil, fmt.Errorf("TOTP secret decoded to %d bytes, want %d", len(secret), size)
+	}
+	return key, secret, nil
+}
+
+// Key returns the key of a stored secret, for showing it again before
+// TOTP is enabled
+func Key(issuer, account string, secret []byte) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: account,
+		Secret:      secret,
+		Period:      window.Load().Period,
+	})
+}
+
+// Encode returns a stored secret in the form authenticator apps use
+func Encode(secret []byte) string {
+	return Encoding.EncodeToString(secret)
+}
+
+// Validate checks code against a stored secret within the configured
+// window. An empty secret never validates.
+func Validate(code string, secret []byte) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	ok, err := totp.ValidateCustom(code, Encode(secret), time.Now(), opts())
+	return err == nil && ok
+}
+
+// Match is Validate at time t that also returns the time step the code
+// belongs to, so that callers can reject codes of a step already used
+func Match(code string, secret []byte, t time.Time) (step uint64, ok bool) {
+	if len(secret) == 0 {
+		return 0, false
+	}
+	o := opts()
+	current := uint64(t.Unix()) / uint64(o.Period)
+	encoded := Encode(secret)
+	hotpOpts := hotp.ValidateOpts{Digits: o.Digits, Algorithm: o.Algorithm}
+	for i := -int64(o.Skew); i <= int64(o.Skew); i++ {
+		step := uint64(int64(current) + i)
+		if ok, err := hotp.ValidateCustom(code, step, encoded, hotpOpts); err == nil && ok {
+			return step, true
+		}
+	}
+	return 0, false
+}
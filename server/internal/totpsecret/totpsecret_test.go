@@ -0,0 +1,94 @@
+package totpsecret
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestGenerateAndValidate(t *testing.T) {
+	// 21 and 23 bytes do not fill whole base32 blocks
+	for _, size := range []uint{0, 16, 21, 23, 64} {
+		key, secret, err := Generate("VibedTerm", "user@example.com", size)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if size != 0 && len(secret) != int(size) || size == 0 && len(secret) != Size {
+			t.Errorf("size %d: %d secret bytes", size, len(secret))
+		}
+		if Encode(secret) != key.Secret() || strings.Contains(Encode(secret), "=") {
+			t.Errorf("size %d: encoded %q, key shows %q", size, Encode(secret), key.Secret())
+		}
+
+		code, err := totp.GenerateCode(key.Secret(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Validate(code, secret) {
+			t.Errorf("size %d: code from the key did not validate", size)
+		}
+	}
+}
+
+func TestValidate_EmptySecret(t *testing.T) {
+	code, err := totp.GenerateCode("", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Validate(code, nil) {
+		t.Error("empty secret validated a code")
+	}
+}
+
+func TestMatch_Window(t *testing.T) {
+	t.Cleanup(func() { _ = Configure(DefaultPeriod, DefaultSkew) })
+	_, secret, err := Generate("VibedTerm", "user@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_010, 0)
+	current := uint64(now.Unix()) / DefaultPeriod
+	codeAt := func(offset time.Duration) string {
+		code, err := totp.GenerateCode(Encode(secret), now.Add(offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	if step, ok := Match(codeAt(-30*time.Second), secret, now); !ok || step != current-1 {
+		t.Errorf("previous step: step = %d, ok = %v; want %d", step, ok, current-1)
+	}
+	if _, ok := Match(codeAt(-60*time.Second), secret, now); ok {
+		t.Error("code two steps old matched with skew 1")
+	}
+
+	if err := Configure(DefaultPeriod, 2); err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := Match(codeAt(60*time.Second), secret, now); !ok || step != current+2 {
+		t.Errorf("skew 2: step = %d, ok = %v; want %d", step, ok, current+2)
+	}
+	if err := Configure(0, 1); err == nil {
+		t.Error("period 0 accepted")
+	}
+	if err := Configure(DefaultPeriod, -1); err == nil {
+		t.Error("negative skew accepted")
+	}
+}
+
+func TestKey_ShowsStoredSecret(t *testing.T) {
+	key, secret, err := Generate("VibedTerm", "user@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Key("VibedTerm", "user@example.com", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Secret() != key.Secret() || again.URL() != key.URL() {
+		t.Errorf("key of the stored secret = %q, want %q", again.URL(), key.URL())
+	}
+}
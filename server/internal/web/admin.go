@@ -1,7 +1,7 @@
 package web
 
 import (
-	"encoding/base32"
+	"context"
 	"errors"
 	"io/fs"
 	"net/http"
@@ -9,11 +9,15 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/pquerna/otp/totp"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/crypto/bcrypt"
 
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+	passwords "github.com/sprobst76/vibedterm-server/internal/password"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
 )
 
 const (
@@ -28,7 +32,25 @@ type AdminWeb struct {
 	userRepo     *repository.UserRepository
 	deviceRepo   *repository.DeviceRepository
 	vaultRepo    *repository.VaultRepository
-	refreshRepo  *repository.RefreshTokenRepository
+	userAdmin    *service.UserAdmin
+	inactivity   inactivityPolicyStore
+	invites      inviteIssuer
+	registration registrationAccessStore
+	migration    migrationCampaignStore
+	outbox       outboxQueue
+	totpGuard    totpChecker
+	maintenance  maintenanceReporter
+	events       *events.Log
+	userList     userListStore
+	admins       adminStatusSource
+	exports      *service.AccountExport
+	appVersions  models.AppVersionPolicy
+}
+
+// adminStatusSource is the subset of UserRepository needed to recheck a
+// session's admin rights
+type adminStatusSource interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
 }
 
 // NewAdminWeb creates a new admin web handler
@@ -36,66 +58,107 @@ func NewAdminWeb(
 	userRepo *repository.UserRepository,
 	deviceRepo *repository.DeviceRepository,
 	vaultRepo *repository.VaultRepository,
-	refreshRepo *repository.RefreshTokenRepository,
+	userAdmin *service.UserAdmin,
+	inactivity *service.InactivityCleanup,
+	invites *service.Invites,
+	registration *service.Registration,
+	migration *service.VaultMigration,
+	outbox *notify.Outbox,
+	totpGuard *service.TOTPGuard,
+	maintenance *service.Maintenance,
+	eventLog *events.Log,
+	exports *service.AccountExport,
+	appVersions models.AppVersionPolicy,
 	templates *Templates,
 ) *AdminWeb {
 	return &AdminWeb{
-		templates:   templates,
-		sessions:    NewSessionStore(sessionDuration),
-		userRepo:    userRepo,
-		deviceRepo:  deviceRepo,
-		vaultRepo:   vaultRepo,
-		refreshRepo: refreshRepo,
+		templates:    templates,
+		sessions:     NewSessionStore(sessionDuration),
+		userRepo:     userRepo,
+		deviceRepo:   deviceRepo,
+		vaultRepo:    vaultRepo,
+		userAdmin:    userAdmin,
+		inactivity:   inactivity,
+		invites:      invites,
+		registration: registration,
+		migration:    migration,
+		outbox:       outbox,
+		totpGuard:    totpGuard,
+		maintenance:  maintenance,
+		events:       eventLog,
+		userList:     userRepo,
+		admins:       userRepo,
+		exports:      exports,
+		appVersions:  appVersions,
 	}
 }
 
-// RegisterRoutes registers all admin web routes
-func (a *AdminWeb) RegisterRoutes(r *gin.Engine) {
+// RegisterRoutes registers all admin web routes. loginLimit guards the
+// login posts.
+func (a *AdminWeb) RegisterRoutes(r *gin.Engine, loginLimit gin.HandlerFunc) {
+	// Pages and static files alike get the browser security headers
+	admin := r.Group("/admin", securityHeaders())
+
 	// Serve static files
 	staticSubFS, err := fs.Sub(GetStaticFS(), "static")
 	if err == nil {
-		r.StaticFS("/admin/static", http.FS(staticSubFS))
+		admin.StaticFS("/static", http.FS(staticSubFS))
 	}
-
-	admin := r.Group("/admin")
 	{
 		// Public routes
 		admin.GET("/login", a.loginPage)
-		admin.POST("/login", a.login)
+		admin.POST("/login", loginLimit, a.login)
 		admin.GET("/login/totp", a.totpPage)
-		admin.POST("/login/totp", a.validateTOTP)
+		admin.POST("/login/totp", loginLimit, a.csrfMiddleware(), a.validateTOTP)
 
-		// Protected routes (require valid session)
-		protected := admin.Group("")
-		protected.Use(a.authMiddleware())
+		// Everything else needs an admin session, and posts its CSRF
+		// token. Register new pages here only, never on admin.
+		protected := admin.Group("", a.authMiddleware(), a.csrfMiddleware())
 		{
+			protected.GET("", a.index)
 			protected.GET("/", a.index)
 			protected.GET("/dashboard", a.dashboard)
 			protected.GET("/users", a.usersPage)
+			protected.GET("/approvals", a.approvalsPage)
 			protected.GET("/users/create", a.createUserPage)
 			protected.POST("/users/create", a.createUser)
+			protected.POST("/users/bulk", a.bulkUsers)
+			protected.GET("/users/export.csv", a.exportUsers)
+			protected.GET("/users/sync-logs.csv", a.exportSyncLogs)
+			protected.GET("/users/:id", a.userDetailPage)
+			protected.GET("/users/:id/devices", a.userDevicesPage)
+			protected.POST("/users/:id/devices/:deviceId/delete", a.deleteUserDevice)
+			protected.POST("/users/:id/sessions/:sessionId/revoke", a.revokeUserSession)
 			protected.POST("/users/:id/approve", a.approveUser)
 			protected.POST("/users/:id/reject", a.rejectUser)
 			protected.POST("/users/:id/block", a.blockUser)
+			protected.POST("/users/:id/role", a.setUserRole)
+			protected.GET("/invites", a.invitesPage)
+			protected.POST("/invites", a.createInvite)
+			protected.GET("/outbox", a.outboxPage)
+			protected.POST("/outbox/:id/retry", a.retryOutboxEntry)
+			protected.POST("/outbox/:id/drop", a.dropOutboxEntry)
+			protected.GET("/settings", a.settingsPage)
+			protected.POST("/settings/inactivity", a.saveInactivityPolicy)
+			protected.POST("/settings/registration", a.saveRegistrationAccess)
+			protected.POST("/settings/migration", a.saveMigrationCampaign)
 			protected.POST("/logout", a.logout)
 		}
 	}
 }
 
-// authMiddleware checks for valid admin session
+// csrfMiddleware checks the CSRF token of admin session posts
+func (a *AdminWeb) csrfMiddleware() gin.HandlerFunc {
+	return csrfProtect(a.templates, adminSessionCookie, a.sessions, "/admin")
+}
+
+// authMiddleware checks for a valid admin session. Admin rights are
+// checked against the database on every request, so a demoted or blocked
+// admin loses access at once rather than when the session expires.
 func (a *AdminWeb) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sessionID, err := c.Cookie(sessionCookieName)
-		if err != nil || sessionID == "" {
-			c.Redirect(http.StatusFound, "/admin/login")
-			c.Abort()
-			return
-		}
-
-		session := a.sessions.Get(sessionID)
+		session := adminSessionCookie.resolveOrClear(c, a.sessions)
 		if session == nil {
-			// Clear invalid cookie
-			c.SetCookie(sessionCookieName, "", -1, "/admin", "", true, true)
 			c.Redirect(http.StatusFound, "/admin/login")
 			c.Abort()
 			return
@@ -108,6 +171,28 @@ func (a *AdminWeb) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if !session.IsAdmin {
+			c.String(http.StatusForbidden, "Admin access required")
+			c.Abort()
+			return
+		}
+		user, err := a.admins.GetByID(c.Request.Context(), session.UserID)
+		switch {
+		case err == nil && user.IsAdmin && !user.IsBlocked:
+		case err == nil, errors.Is(err, repository.ErrUserNotFound):
+			log.Warn().Str("user_id", session.UserID.String()).Msg("Admin session of a user who is no longer admin")
+			a.sessions.DeleteForUser(session.UserID)
+			adminSessionCookie.clear(c)
+			c.String(http.StatusForbidden, "Admin access required")
+			c.Abort()
+			return
+		default:
+			log.Error().Err(err).Str("user_id", session.UserID.String()).Msg("Failed to check admin status")
+			c.String(http.StatusInternalServerError, "Internal server error")
+			c.Abort()
+			return
+		}
+
 		c.Set("session", session)
 		c.Next()
 	}
@@ -118,19 +203,24 @@ func (a *AdminWeb) index(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/admin/dashboard")
 }
 
+// loginPageData is the view model of login.html
+type loginPageData struct {
+	Title string
+	Error string
+}
+
 // loginPage shows the login form
 func (a *AdminWeb) loginPage(c *gin.Context) {
-	// If already logged in, redirect to dashboard
-	if sessionID, err := c.Cookie(sessionCookieName); err == nil {
-		if session := a.sessions.Get(sessionID); session != nil && session.IsFullyAuthenticated() {
-			c.Redirect(http.StatusFound, "/admin/dashboard")
-			return
-		}
+	// If already logged in, redirect to dashboard. A cookie that no longer
+	// resolves is cleared here so it cannot bounce the browser back.
+	if session := adminSessionCookie.resolveOrClear(c, a.sessions); session != nil && session.IsFullyAuthenticated() {
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
 	}
 
-	data := gin.H{
-		"Title": "Admin Login",
-		"Error": c.Query("error"),
+	data := loginPageData{
+		Title: "Admin Login",
+		Error: c.Query("error"),
 	}
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	if err := a.templates.Render(c.Writer, "login.html", data); err != nil {
@@ -141,8 +231,12 @@ func (a *AdminWeb) loginPage(c *gin.Context) {
 
 // login handles the login form submission
 func (a *AdminWeb) login(c *gin.Context) {
-	email := c.PostForm("email")
-	password := c.PostForm("password")
+	form, err := formValues(c, map[string]string{"email": input.KindEmail, "password": input.KindSecret})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/login?error="+formError(err))
+		return
+	}
+	email, password := form["email"], form["password"]
 
 	if email == "" || password == "" {
 		c.Redirect(http.StatusFound, "/admin/login?error=Email+and+password+required")
@@ -152,24 +246,40 @@ func (a *AdminWeb) login(c *gin.Context) {
 	// Get user from database
 	user, err := a.userRepo.GetByEmail(c.Request.Context(), email)
 	if err != nil {
+		// Hash as for a wrong password so the response time does not
+		// reveal whether the email is registered
+		if errors.Is(passwords.CompareDummy(c.Request.Context(), password), passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/admin/login?error="+busyMessage)
+			return
+		}
 		log.Debug().Str("email", email).Msg("Admin login failed: user not found")
+		recordLoginFailed(c, a.events, events.SurfaceAdminWeb, nil, email, events.ReasonInvalidCredentials)
 		c.Redirect(http.StatusFound, "/admin/login?error=Invalid+credentials")
 		return
 	}
 
-	// Check if user is admin
-	if !user.IsAdmin {
-		log.Warn().Str("email", email).Msg("Non-admin user attempted admin login")
+	// Verify password before the admin check, which would otherwise
+	// answer faster for existing non-admin accounts
+	rehashed, err := passwords.Verify(c.Request.Context(), user.PasswordHash, password)
+	if err != nil {
+		if errors.Is(err, passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/admin/login?error="+busyMessage)
+			return
+		}
+		log.Debug().Str("email", email).Msg("Admin login failed: wrong password")
+		recordLoginFailed(c, a.events, events.SurfaceAdminWeb, user, email, events.ReasonInvalidCredentials)
 		c.Redirect(http.StatusFound, "/admin/login?error=Invalid+credentials")
 		return
 	}
 
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		log.Debug().Str("email", email).Msg("Admin login failed: wrong password")
+	// Check if user is admin
+	if !user.IsAdmin {
+		log.Warn().Str("email", email).Msg("Non-admin user attempted admin login")
+		recordLoginFailed(c, a.events, events.SurfaceAdminWeb, user, email, events.ReasonNotAdmin)
 		c.Redirect(http.StatusFound, "/admin/login?error=Invalid+credentials")
 		return
 	}
+	upgradePasswordHash(c.Request.Context(), a.userRepo, user, rehashed)
 
 	// Create session (may need TOTP verification)
 	session, err := a.sessions.Create(user.ID, user.Email, user.IsAdmin, user.TOTPEnabled)
@@ -180,7 +290,7 @@ func (a *AdminWeb) login(c *gin.Context) {
 	}
 
 	// Set session cookie
-	c.SetCookie(sessionCookieName, session.ID, int(sessionDuration.Seconds()), "/admin", "", true, true)
+	adminSessionCookie.set(c, session.ID)
 
 	log.Info().Str("email", email).Bool("totp_required", user.TOTPEnabled).Msg("Admin login successful")
 
@@ -188,19 +298,21 @@ func (a *AdminWeb) login(c *gin.Context) {
 	if user.TOTPEnabled {
 		c.Redirect(http.StatusFound, "/admin/login/totp")
 	} else {
+		recordLoginSucceeded(c, a.events, events.SurfaceAdminWeb, user)
 		c.Redirect(http.StatusFound, "/admin/dashboard")
 	}
 }
 
+// totpPageData is the view model of totp.html
+type totpPageData struct {
+	Title     string
+	Error     string
+	CSRFToken string
+}
+
 // totpPage shows the TOTP verification form
 func (a *AdminWeb) totpPage(c *gin.Context) {
-	sessionID, err := c.Cookie(sessionCookieName)
-	if err != nil || sessionID == "" {
-		c.Redirect(http.StatusFound, "/admin/login")
-		return
-	}
-
-	session := a.sessions.Get(sessionID)
+	session := adminSessionCookie.resolveOrClear(c, a.sessions)
 	if session == nil {
 		c.Redirect(http.StatusFound, "/admin/login")
 		return
@@ -211,10 +323,10 @@ func (a *AdminWeb) totpPage(c *gin.Context) {
 		return
 	}
 
-	data := gin.H{
-		"Title": "Two-Factor Authentication",
-		"Email": session.Email,
-		"Error": c.Query("error"),
+	data := totpPageData{
+		Title:     "Two-Factor Authentication",
+		Error:     c.Query("error"),
+		CSRFToken: session.CSRFToken,
 	}
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	if err := a.templates.Render(c.Writer, "totp.html", data); err != nil {
@@ -225,13 +337,7 @@ func (a *AdminWeb) totpPage(c *gin.Context) {
 
 // validateTOTP handles TOTP verification
 func (a *AdminWeb) validateTOTP(c *gin.Context) {
-	sessionID, err := c.Cookie(sessionCookieName)
-	if err != nil || sessionID == "" {
-		c.Redirect(http.StatusFound, "/admin/login")
-		return
-	}
-
-	session := a.sessions.Get(sessionID)
+	session := adminSessionCookie.resolveOrClear(c, a.sessions)
 	if session == nil || !session.TOTPPending {
 		c.Redirect(http.StatusFound, "/admin/login")
 		return
@@ -251,19 +357,96 @@ func (a *AdminWeb) validateTOTP(c *gin.Context) {
 	}
 
 	// Validate TOTP code
-	if !totp.Validate(code, base32.StdEncoding.EncodeToString(user.TOTPSecret)) {
-		log.Debug().Str("email", user.Email).Msg("Invalid TOTP code")
-		c.Redirect(http.StatusFound, "/admin/login/totp?error=Invalid+code")
+	if err := a.totpGuard.Check(c.Request.Context(), user, code, events.SurfaceAdminWeb); err != nil {
+		log.Debug().Err(err).Str("email", user.Email).Msg("TOTP code rejected")
+		if !errors.Is(err, service.ErrTOTPThrottled) {
+			recordLoginFailed(c, a.events, events.SurfaceAdminWeb, user, user.Email, events.ReasonInvalidTOTP)
+		}
+		c.Redirect(http.StatusFound, "/admin/login/totp?error="+totpFormError(err))
 		return
 	}
 
 	// Upgrade session to fully authenticated
-	a.sessions.UpgradeFromTOTP(sessionID)
+	a.sessions.UpgradeFromTOTP(session.ID)
+	recordLoginSucceeded(c, a.events, events.SurfaceAdminWeb, user)
 	log.Info().Str("email", user.Email).Msg("Admin TOTP verification successful")
 
 	c.Redirect(http.StatusFound, "/admin/dashboard")
 }
 
+// pendingApprovalsKey caches the pending user count in the gin context
+const pendingApprovalsKey = "pendingApprovals"
+
+// adminLayout holds what layout.html shows on every admin page
+type adminLayout struct {
+	PendingApprovals int
+	CSRFToken        string
+}
+
+func (l *adminLayout) layout() *adminLayout { return l }
+
+// layoutPage is a view model of a page using layout.html
+type layoutPage interface {
+	layout() *adminLayout
+}
+
+// pendingApprovals counts the users awaiting approval, once per request
+func (a *AdminWeb) pendingApprovals(c *gin.Context) int {
+	if n, ok := c.Get(pendingApprovalsKey); ok {
+		return n.(int)
+	}
+	_, _, pending, _, err := a.userList.Count(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count pending users")
+	}
+	c.Set(pendingApprovalsKey, pending)
+	return pending
+}
+
+// render writes an admin page with the pending approval badge and the
+// CSRF token of the session filled in
+func (a *AdminWeb) render(c *gin.Context, name string, data layoutPage) {
+	data.layout().PendingApprovals = a.pendingApprovals(c)
+	data.layout().CSRFToken = c.MustGet("session").(*Session).CSRFToken
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := a.templates.Render(c.Writer, name, data); err != nil {
+		log.Error().Err(err).Str("template", name).Msg("Failed to render admin page")
+		c.String(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// maintenanceReporter reports the last run of the maintenance job
+type maintenanceReporter interface {
+	LastReport() *service.MaintenanceReport
+}
+
+// dashboardPageData is the view model of dashboard.html
+type dashboardPageData struct {
+	adminLayout
+
+	Title string
+	Email string
+
+	TotalUsers    int
+	ApprovedUsers int
+	PendingUsers  int
+	BlockedUsers  int
+	OldestPending *time.Time // nil without pending users
+	AvgApproval   *int64     // seconds, nil before the first approval
+	Devices       int
+	Vaults        int
+	DeletedVaults int                 // soft-deleted, purged after the grace period
+	VaultBytes    int                 // stored in all current vaults
+	LargestVaults []models.VaultUsage // the dashboardLargestVaults largest
+	AppVersions   []models.AppVersionCount
+
+	Migration   *service.MigrationProgress
+	Maintenance *service.MaintenanceReport // nil before the first run
+}
+
+// dashboardLargestVaults is how many vaults the dashboard lists by size
+const dashboardLargestVaults = 5
+
 // dashboard shows the admin dashboard
 func (a *AdminWeb) dashboard(c *gin.Context) {
 	session := c.MustGet("session").(*Session)
@@ -273,94 +456,79 @@ func (a *AdminWeb) dashboard(c *gin.Context) {
 	total, approved, pending, blocked, err := a.userRepo.Count(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get user stats")
+	} else {
+		c.Set(pendingApprovalsKey, pending)
 	}
 
 	deviceCount, _ := a.deviceRepo.Count(ctx)
 	vaultCount, _ := a.vaultRepo.Count(ctx)
-
-	data := gin.H{
-		"Title":        "Dashboard",
-		"Email":        session.Email,
-		"TotalUsers":   total,
-		"ApprovedUsers": approved,
-		"PendingUsers": pending,
-		"BlockedUsers": blocked,
-		"Devices":      deviceCount,
-		"Vaults":       vaultCount,
-	}
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	if err := a.templates.Render(c.Writer, "dashboard.html", data); err != nil {
-		log.Error().Err(err).Msg("Failed to render dashboard template")
-		c.String(http.StatusInternalServerError, "Internal server error")
+	deletedVaults, _ := a.vaultRepo.CountDeleted(ctx)
+	vaultBytes, _ := a.vaultRepo.TotalSize(ctx)
+	largestVaults, err := a.vaultRepo.Largest(ctx, dashboardLargestVaults)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get vault sizes")
 	}
-}
-
-// usersPage shows the user management page
-func (a *AdminWeb) usersPage(c *gin.Context) {
-	session := c.MustGet("session").(*Session)
-	ctx := c.Request.Context()
-
-	users, err := a.userRepo.List(ctx)
+	oldestPending, _ := a.userRepo.OldestPendingCreatedAt(ctx)
+	avgApproval, _ := a.userRepo.AverageApprovalSeconds(ctx)
+	appVersions, err := a.deviceRepo.CountByAppVersion(ctx)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to list users")
-		c.String(http.StatusInternalServerError, "Failed to load users")
-		return
+		log.Error().Err(err).Msg("Failed to get app versions")
 	}
+	a.appVersions.MarkOutdated(appVersions)
+	migration, err := a.migration.Progress(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get vault migration progress")
+	}
+
+	data := dashboardPageData{
+		Title:         "Dashboard",
+		Email:         session.Email,
+		TotalUsers:    total,
+		ApprovedUsers: approved,
+		PendingUsers:  pending,
+		OldestPending: oldestPending,
+		AvgApproval:   avgApproval,
+		BlockedUsers:  blocked,
+		Devices:       deviceCount,
+		Vaults:        vaultCount,
+		DeletedVaults: deletedVaults,
+		VaultBytes:    vaultBytes,
+		LargestVaults: largestVaults,
+		AppVersions:   appVersions,
+		Migration:     migration,
+		Maintenance:   a.maintenance.LastReport(),
+	}
+	a.render(c, "dashboard.html", &data)
+}
 
-	// Split users into pending and all
-	var pendingUsers, allUsers []gin.H
-	for _, u := range users {
-		userMap := gin.H{
-			"ID":          u.ID.String(),
-			"Email":       u.Email,
-			"IsApproved":  u.IsApproved,
-			"IsAdmin":     u.IsAdmin,
-			"IsBlocked":   u.IsBlocked,
-			"TOTPEnabled": u.TOTPEnabled,
-			"CreatedAt":   u.CreatedAt,
-			"LastLoginAt": u.LastLoginAt,
-		}
-		allUsers = append(allUsers, userMap)
-		if !u.IsApproved && !u.IsBlocked {
-			pendingUsers = append(pendingUsers, userMap)
-		}
-	}
+// createUserPageData is the view model of create_user.html
+type createUserPageData struct {
+	adminLayout
 
-	data := gin.H{
-		"Title":        "Users",
-		"Email":        session.Email,
-		"PendingUsers": pendingUsers,
-		"AllUsers":     allUsers,
-		"Success":      c.Query("success"),
-		"Error":        c.Query("error"),
-	}
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	if err := a.templates.Render(c.Writer, "users.html", data); err != nil {
-		log.Error().Err(err).Msg("Failed to render users template")
-		c.String(http.StatusInternalServerError, "Internal server error")
-	}
+	Title string
+	Email string
+	Error string
 }
 
 // createUserPage shows the create user form
 func (a *AdminWeb) createUserPage(c *gin.Context) {
 	session := c.MustGet("session").(*Session)
-	data := gin.H{
-		"Title": "Create User",
-		"Email": session.Email,
-		"Error": c.Query("error"),
-	}
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	if err := a.templates.Render(c.Writer, "create_user.html", data); err != nil {
-		log.Error().Err(err).Msg("Failed to render create user template")
-		c.String(http.StatusInternalServerError, "Internal server error")
+	data := createUserPageData{
+		Title: "Create User",
+		Email: session.Email,
+		Error: c.Query("error"),
 	}
+	a.render(c, "create_user.html", &data)
 }
 
 // createUser handles the create user form submission
 func (a *AdminWeb) createUser(c *gin.Context) {
-	email := c.PostForm("email")
-	password := c.PostForm("password")
-	confirmPassword := c.PostForm("confirm_password")
+	form, err := formValues(c, map[string]string{"email": input.KindEmail, "password": input.KindSecret, "confirm_password": input.KindSecret})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users/create?error="+formError(err))
+		return
+	}
+	email, password, confirmPassword := form["email"], form["password"], form["confirm_password"]
 
 	if email == "" || password == "" {
 		c.Redirect(http.StatusFound, "/admin/users/create?error=Email+and+password+required")
@@ -377,13 +545,13 @@ func (a *AdminWeb) createUser(c *gin.Context) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(c.Request.Context(), password)
 	if err != nil {
 		c.Redirect(http.StatusFound, "/admin/users/create?error=Internal+error")
 		return
 	}
 
-	user, err := a.userRepo.Create(c.Request.Context(), email, string(hashedPassword))
+	user, err := a.userRepo.Create(c.Request.Context(), email, hashedPassword)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserAlreadyExists) {
 			c.Redirect(http.StatusFound, "/admin/users/create?error=Email+already+registered")
@@ -394,8 +562,12 @@ func (a *AdminWeb) createUser(c *gin.Context) {
 		return
 	}
 
-	// Auto-approve the user
-	if err := a.userRepo.SetApproved(c.Request.Context(), user.ID, true); err != nil {
+	// Auto-approve the user; an admin vouches for the address
+	session := c.MustGet("session").(*Session)
+	if err := a.userRepo.MarkEmailVerified(c.Request.Context(), user.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to mark newly created user as verified")
+	}
+	if err := a.userRepo.SetApproved(c.Request.Context(), user.ID, true, &session.UserID); err != nil {
 		log.Error().Err(err).Msg("Failed to approve newly created user")
 	}
 
@@ -403,54 +575,64 @@ func (a *AdminWeb) createUser(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/admin/users?success=User+created+and+approved")
 }
 
-// approveUser approves a pending user
+// approveUser approves a pending user and returns to the user list or
+// the approval queue it was approved from
 func (a *AdminWeb) approveUser(c *gin.Context) {
+	back := approvalReturn(c)
 	userIDStr := c.Param("id")
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
+		c.Redirect(http.StatusFound, withMessage(back, "error", "Invalid user ID"))
 		return
 	}
 
-	if err := a.userRepo.SetApproved(c.Request.Context(), userID, true); err != nil {
-		log.Error().Err(err).Str("user_id", userIDStr).Msg("Failed to approve user")
-		c.Redirect(http.StatusFound, "/admin/users?error=Failed+to+approve+user")
+	session := c.MustGet("session").(*Session)
+	if _, err := a.userAdmin.Approve(c.Request.Context(), session.UserID, userID, false); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			c.Redirect(http.StatusFound, withMessage(back, "error", "User not found"))
+		case errors.Is(err, service.ErrUserAlreadyApproved):
+			c.Redirect(http.StatusFound, withMessage(back, "error", "User already approved"))
+		case errors.Is(err, service.ErrUserBlocked):
+			c.Redirect(http.StatusFound, withMessage(back, "error", "Unblock the user before approving"))
+		default:
+			log.Error().Err(err).Str("user_id", userIDStr).Msg("Failed to approve user")
+			c.Redirect(http.StatusFound, withMessage(back, "error", "Failed to approve user"))
+		}
 		return
 	}
 
 	log.Info().Str("user_id", userIDStr).Msg("User approved via web interface")
-	c.Redirect(http.StatusFound, "/admin/users?success=User+approved")
+	c.Redirect(http.StatusFound, withMessage(back, "success", "User approved"))
 }
 
 // rejectUser rejects (deletes) a pending user
 func (a *AdminWeb) rejectUser(c *gin.Context) {
+	back := approvalReturn(c)
 	userIDStr := c.Param("id")
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
-		return
-	}
-
-	// Only allow rejecting non-approved users
-	user, err := a.userRepo.GetByID(c.Request.Context(), userID)
-	if err != nil {
-		c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
-		return
-	}
-
-	if user.IsApproved {
-		c.Redirect(http.StatusFound, "/admin/users?error=Cannot+reject+approved+user")
+		c.Redirect(http.StatusFound, withMessage(back, "error", "Invalid user ID"))
 		return
 	}
 
-	if err := a.userRepo.Delete(c.Request.Context(), userID); err != nil {
-		log.Error().Err(err).Str("user_id", userIDStr).Msg("Failed to reject user")
-		c.Redirect(http.StatusFound, "/admin/users?error=Failed+to+reject+user")
+	// Only non-approved users can be rejected
+	session := c.MustGet("session").(*Session)
+	if _, err := a.userAdmin.Reject(c.Request.Context(), session.UserID, userID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			c.Redirect(http.StatusFound, withMessage(back, "error", "User not found"))
+		case errors.Is(err, service.ErrUserAlreadyApproved):
+			c.Redirect(http.StatusFound, withMessage(back, "error", "Cannot reject approved user"))
+		default:
+			log.Error().Err(err).Str("user_id", userIDStr).Msg("Failed to reject user")
+			c.Redirect(http.StatusFound, withMessage(back, "error", "Failed to reject user"))
+		}
 		return
 	}
 
 	log.Info().Str("user_id", userIDStr).Msg("User rejected via web interface")
-	c.Redirect(http.StatusFound, "/admin/users?success=User+rejected")
+	c.Redirect(http.StatusFound, withMessage(back, "success", "User rejected"))
 }
 
 // blockUser blocks or unblocks a user
@@ -462,33 +644,37 @@ func (a *AdminWeb) blockUser(c *gin.Context) {
 		return
 	}
 
-	action := c.PostForm("action")
-	blocked := action == "block"
-
-	// Get user to check if admin
-	user, err := a.userRepo.GetByID(c.Request.Context(), userID)
+	form, err := formValues(c, map[string]string{"action": input.KindToken, "reason": input.KindReason})
 	if err != nil {
-		c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
+		c.Redirect(http.StatusFound, "/admin/users?error="+formError(err))
 		return
 	}
+	blocked := form["action"] == "block"
+	session := c.MustGet("session").(*Session)
 
-	// Don't allow blocking admins
-	if user.IsAdmin {
-		c.Redirect(http.StatusFound, "/admin/users?error=Cannot+block+admin+users")
-		return
+	// Admins can't be blocked; blocking revokes all tokens
+	if blocked {
+		_, err = a.userAdmin.Block(c.Request.Context(), session.UserID, userID, form["reason"])
+	} else {
+		_, err = a.userAdmin.Unblock(c.Request.Context(), session.UserID, userID)
 	}
-
-	if err := a.userRepo.SetBlocked(c.Request.Context(), userID, blocked); err != nil {
-		log.Error().Err(err).Str("user_id", userIDStr).Bool("blocked", blocked).Msg("Failed to update user blocked status")
-		c.Redirect(http.StatusFound, "/admin/users?error=Failed+to+update+user")
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
+		case errors.Is(err, service.ErrCannotBlockAdmin):
+			c.Redirect(http.StatusFound, "/admin/users?error=Cannot+block+admin+users")
+		case errors.Is(err, service.ErrBlockReasonTooLong):
+			c.Redirect(http.StatusFound, "/admin/users?error=Block+reason+too+long")
+		case errors.Is(err, service.ErrUserAlreadyBlocked), errors.Is(err, service.ErrUserNotBlocked):
+			c.Redirect(http.StatusFound, "/admin/users?error=User+status+already+changed")
+		default:
+			log.Error().Err(err).Str("user_id", userIDStr).Bool("blocked", blocked).Msg("Failed to update user blocked status")
+			c.Redirect(http.StatusFound, "/admin/users?error=Failed+to+update+user")
+		}
 		return
 	}
 
-	// Revoke all tokens if blocking
-	if blocked {
-		_ = a.refreshRepo.RevokeAllForUser(c.Request.Context(), userID)
-	}
-
 	actionText := "unblocked"
 	if blocked {
 		actionText = "blocked"
@@ -499,9 +685,6 @@ func (a *AdminWeb) blockUser(c *gin.Context) {
 
 // logout destroys the session and redirects to login
 func (a *AdminWeb) logout(c *gin.Context) {
-	if sessionID, err := c.Cookie(sessionCookieName); err == nil {
-		a.sessions.Delete(sessionID)
-	}
-	c.SetCookie(sessionCookieName, "", -1, "/admin", "", true, true)
+	adminSessionCookie.destroy(c, a.sessions)
 	c.Redirect(http.StatusFound, "/admin/login")
 }
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// approvalsPath is the approval queue page
+const approvalsPath = "/admin/approvals"
+
+// Email verification filters of the approval queue
+const (
+	approvalVerified   = "verified"
+	approvalUnverified = "unverified"
+)
+
+// approvalFilter is the filter of the approval queue. It is kept when a
+// user is approved or rejected from the queue.
+type approvalFilter struct {
+	Verified string
+	Search   string
+}
+
+// parseApprovalFilter reads the filter from query parameters. Unknown
+// values and unusable search terms are ignored.
+func parseApprovalFilter(q url.Values) approvalFilter {
+	var f approvalFilter
+	if v := q.Get("verified"); v == approvalVerified || v == approvalUnverified {
+		f.Verified = v
+	}
+	if search, err := input.Clean("q", input.KindEmail, strings.TrimSpace(q.Get("q"))); err == nil {
+		f.Search = search
+	}
+	return f
+}
+
+// url links to the approval queue with the filter applied
+func (f approvalFilter) url() string {
+	q := url.Values{}
+	if f.Verified != "" {
+		q.Set("verified", f.Verified)
+	}
+	if f.Search != "" {
+		q.Set("q", f.Search)
+	}
+	return withQuery(approvalsPath, q)
+}
+
+// matches reports whether u passes the filter. The search matches email
+// and display name, ignoring case.
+func (f approvalFilter) matches(u *models.User) bool {
+	switch {
+	case f.Verified == approvalVerified && !u.EmailVerified,
+		f.Verified == approvalUnverified && u.EmailVerified:
+		return false
+	}
+	if f.Search == "" {
+		return true
+	}
+	search := strings.ToLower(f.Search)
+	return strings.Contains(strings.ToLower(u.Email), search) ||
+		strings.Contains(strings.ToLower(u.DisplayName), search)
+}
+
+// verifiedTabs returns a tab per verification filter, plus one for all
+// pending users, that keep the search term
+func (f approvalFilter) verifiedTabs() []userTab {
+	tabs := []userTab{{Label: "All"}, {Label: "Verified"}, {Label: "Unverified"}}
+	for i, v := range []string{"", approvalVerified, approvalUnverified} {
+		tab := f
+		tab.Verified = v
+		tabs[i].URL = tab.url()
+		tabs[i].Active = f.Verified == v
+	}
+	return tabs
+}
+
+// approvalReturn is where approving or rejecting redirects to: the
+// approval queue with its filter if the form was posted from there,
+// otherwise the user list. Only the queue is accepted, so the field
+// cannot redirect elsewhere.
+func approvalReturn(c *gin.Context) string {
+	back, err := url.Parse(c.PostForm("return"))
+	if err != nil || back.Scheme != "" || back.Host != "" || back.Path != approvalsPath {
+		return "/admin/users"
+	}
+	return parseApprovalFilter(back.Query()).url()
+}
+
+// withMessage adds a success or error message to a redirect target
+func withMessage(target, key, message string) string {
+	sep := "?"
+	if strings.Contains(target, "?") {
+		sep = "&"
+	}
+	return target + sep + key + "=" + url.QueryEscape(message)
+}
+
+// approvalsPageData is the view model of approvals.html
+type approvalsPageData struct {
+	adminLayout
+
+	Title   string
+	Email   string
+	Success string
+	Error   string
+
+	Filter    approvalFilter
+	Tabs      []userTab
+	ReturnURL string
+	Pending   int // all pending users, regardless of the filter
+	Users     []userRow
+}
+
+// approvalsPage lists the users awaiting approval, oldest first
+func (a *AdminWeb) approvalsPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	filter := parseApprovalFilter(c.Request.URL.Query())
+
+	pending, err := a.userList.ListPending(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list pending users")
+		c.String(http.StatusInternalServerError, "Failed to load pending users")
+		return
+	}
+	c.Set(pendingApprovalsKey, len(pending))
+
+	data := approvalsPageData{
+		Title:     "Approvals",
+		Email:     session.Email,
+		Success:   c.Query("success"),
+		Error:     c.Query("error"),
+		Filter:    filter,
+		Tabs:      filter.verifiedTabs(),
+		ReturnURL: filter.url(),
+		Pending:   len(pending),
+	}
+	for i := range pending {
+		if filter.matches(&pending[i]) {
+			data.Users = append(data.Users, newUserRow(&pending[i]))
+		}
+	}
+	a.render(c, "approvals.html", &data)
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+func TestApprovalsPage_Filter(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	created := time.Now().Add(-time.Hour)
+	users := memUserList{
+		{ID: uuid.New(), Email: "jane@example.com", DisplayName: "Jane Roe", EmailVerified: true, CreatedAt: created},
+		{ID: uuid.New(), Email: "joe@example.com", CreatedAt: created},
+		{ID: uuid.New(), Email: "active@example.com", IsApproved: true, EmailVerified: true, CreatedAt: created},
+	}
+	a := &AdminWeb{templates: tmpl, userList: users}
+
+	get := func(query string) string {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/admin/approvals?"+query, nil)
+		c.Set("session", &Session{Email: "admin@example.com"})
+		a.approvalsPage(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET ?%s: status = %d", query, w.Code)
+		}
+		return w.Body.String()
+	}
+
+	html := get("")
+	if !strings.Contains(html, "jane@example.com") || !strings.Contains(html, "joe@example.com") || strings.Contains(html, "active@example.com") {
+		t.Error("queue does not list exactly the pending users")
+	}
+	if !strings.Contains(html, `<span class="nav-badge" title="Users awaiting approval">2</span>`) {
+		t.Error("layout does not show the pending approval badge")
+	}
+
+	html = get("verified=unverified")
+	if strings.Contains(html, "jane@example.com") || !strings.Contains(html, "joe@example.com") {
+		t.Error("unverified filter not applied")
+	}
+	if !strings.Contains(html, `name="return" value="/admin/approvals?verified=unverified"`) {
+		t.Error("approve and reject forms do not return to the filtered queue")
+	}
+
+	html = get("q=roe")
+	if !strings.Contains(html, "jane@example.com") || strings.Contains(html, "joe@example.com") {
+		t.Error("search does not match the display name")
+	}
+}
+
+func TestAdminWeb_ApproveReturnsToQueue(t *testing.T) {
+	actor := uuid.New()
+	users := memAdminUsers{}
+	add := func() uuid.UUID {
+		id := uuid.New()
+		users[id] = &models.User{ID: id, Email: id.String() + "@example.com"}
+		return id
+	}
+	a := &AdminWeb{userAdmin: service.NewUserAdmin(users, nil, nil, nopAdminTokens{}, nil, &memAdminAudit{})}
+
+	for _, tc := range []struct {
+		back, want string
+	}{
+		{"/admin/approvals?verified=verified&q=jane", "/admin/approvals?q=jane&verified=verified&success=User+approved"},
+		{"/admin/approvals", "/admin/approvals?success=User+approved"},
+		{"", "/admin/users?success=User+approved"},
+		{"https://evil.example/admin/approvals", "/admin/users?success=User+approved"},
+		{"//evil.example/admin/approvals", "/admin/users?success=User+approved"},
+		{"/admin/settings", "/admin/users?success=User+approved"},
+	} {
+		w := postAdminForm(a.approveUser, actor, add(), url.Values{"return": {tc.back}})
+		if loc := w.Header().Get("Location"); loc != tc.want {
+			t.Errorf("return %q: location = %q, want %q", tc.back, loc, tc.want)
+		}
+	}
+
+	w := postAdminForm(a.rejectUser, actor, uuid.New(), url.Values{"return": {"/admin/approvals?verified=unverified"}})
+	if loc := w.Header().Get("Location"); loc != "/admin/approvals?verified=unverified&error=User+not+found" {
+		t.Errorf("reject location = %q", loc)
+	}
+}
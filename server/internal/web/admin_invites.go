@@ -0,0 +1,76 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// inviteIssuer is the subset of Invites needed by the invites page
+type inviteIssuer interface {
+	Create(ctx context.Context, actorID uuid.UUID, note string) (string, *models.Invite, error)
+	List(ctx context.Context) ([]models.Invite, error)
+}
+
+// invitesPage lists the registration invites
+func (a *AdminWeb) invitesPage(c *gin.Context) {
+	a.renderInvites(c, "")
+}
+
+// createInvite generates an invite code. The code is rendered directly
+// instead of redirecting, so it never appears in a URL.
+func (a *AdminWeb) createInvite(c *gin.Context) {
+	form, err := formValues(c, map[string]string{"note": input.KindReason})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/invites?error="+formError(err))
+		return
+	}
+	session := c.MustGet("session").(*Session)
+
+	code, _, err := a.invites.Create(c.Request.Context(), session.UserID, form["note"])
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create invite")
+		c.Redirect(http.StatusFound, "/admin/invites?error=Failed+to+create+invite")
+		return
+	}
+	a.renderInvites(c, code)
+}
+
+// invitesPageData is the view model of invites.html
+type invitesPageData struct {
+	adminLayout
+
+	Title string
+	Email string
+	Error string
+
+	Code    string // just created, shown once
+	Invites []models.Invite
+	Now     time.Time
+}
+
+// renderInvites renders the invites page, showing code if one was just created
+func (a *AdminWeb) renderInvites(c *gin.Context, code string) {
+	session := c.MustGet("session").(*Session)
+	invites, err := a.invites.List(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list invites")
+	}
+
+	data := invitesPageData{
+		Title:   "Invites",
+		Email:   session.Email,
+		Error:   c.Query("error"),
+		Code:    code,
+		Invites: invites,
+		Now:     time.Now(),
+	}
+	a.render(c, "invites.html", &data)
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+type memInvites struct {
+	invites []models.Invite
+	actor   uuid.UUID
+}
+
+func (m *memInvites) Create(_ context.Context, actorID uuid.UUID, note string) (string, *models.Invite, error) {
+	m.actor = actorID
+	invite := models.Invite{ID: uuid.New(), CodePrefix: "ABCD", Note: note, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}
+	m.invites = append(m.invites, invite)
+	return "ABCD-EFGH-IJKL-MNOP", &invite, nil
+}
+
+func (m *memInvites) List(context.Context) ([]models.Invite, error) { return m.invites, nil }
+
+func TestCreateInvite_ShowsCodeOnce(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	store := &memInvites{}
+	a := &AdminWeb{templates: tmpl, invites: store, userList: memUserList{}}
+	actor := uuid.New()
+
+	w := postAdminForm(a.createInvite, actor, uuid.Nil, url.Values{"note": {"for Jane"}})
+	html := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(html, "ABCD-EFGH-IJKL-MNOP") || !strings.Contains(html, "for Jane") {
+		t.Fatalf("create invite = %d\n%s", w.Code, html)
+	}
+	if store.actor != actor {
+		t.Errorf("invite created by %s, want %s", store.actor, actor)
+	}
+
+	// The list only shows the prefix
+	used := time.Now()
+	store.invites[0].UsedAt = &used
+	w = postAdminForm(a.invitesPage, actor, uuid.Nil, nil)
+	html = w.Body.String()
+	if strings.Contains(html, "ABCD-EFGH") || !strings.Contains(html, "ABCD-&hellip;") || !strings.Contains(html, "Used") {
+		t.Errorf("invites page leaks the code or misses the status\n%s", html)
+	}
+}
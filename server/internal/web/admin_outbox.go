@@ -0,0 +1,92 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+// outboxQueue is the subset of notify.Outbox needed by the outbox page
+type outboxQueue interface {
+	List() []notify.Entry
+	Retry(ctx context.Context, id uuid.UUID) (*notify.Entry, error)
+	Drop(id uuid.UUID) error
+}
+
+// outboxPageData is the view model of outbox.html
+type outboxPageData struct {
+	adminLayout
+
+	Title   string
+	Email   string
+	Error   string
+	Success string
+	Entries []notify.Entry
+}
+
+// outboxPage lists the notifications awaiting delivery
+func (a *AdminWeb) outboxPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	data := outboxPageData{
+		Title:   "Outbox",
+		Email:   session.Email,
+		Error:   c.Query("error"),
+		Success: c.Query("success"),
+		Entries: a.outbox.List(),
+	}
+	a.render(c, "outbox.html", &data)
+}
+
+// retryOutboxEntry attempts a delivery right away
+func (a *AdminWeb) retryOutboxEntry(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/outbox?error=Invalid+entry+ID")
+		return
+	}
+
+	entry, err := a.outbox.Retry(c.Request.Context(), id)
+	switch {
+	case err != nil:
+		c.Redirect(http.StatusFound, "/admin/outbox?error="+outboxFormError(err))
+	case entry != nil:
+		c.Redirect(http.StatusFound, "/admin/outbox?error=Delivery+failed+again")
+	default:
+		log.Info().Str("outbox_id", id.String()).Msg("Notification retried via web interface")
+		c.Redirect(http.StatusFound, "/admin/outbox?success=Notification+delivered")
+	}
+}
+
+// dropOutboxEntry removes a notification without delivering it
+func (a *AdminWeb) dropOutboxEntry(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/outbox?error=Invalid+entry+ID")
+		return
+	}
+
+	if err := a.outbox.Drop(id); err != nil {
+		c.Redirect(http.StatusFound, "/admin/outbox?error="+outboxFormError(err))
+		return
+	}
+	log.Info().Str("outbox_id", id.String()).Msg("Notification dropped via web interface")
+	c.Redirect(http.StatusFound, "/admin/outbox?success=Notification+dropped")
+}
+
+// outboxFormError is the query-encoded message for a failed outbox action
+func outboxFormError(err error) string {
+	switch {
+	case errors.Is(err, notify.ErrEntryNotFound):
+		return "Notification+already+delivered+or+dropped"
+	case errors.Is(err, notify.ErrEntryBusy):
+		return "Notification+is+being+delivered"
+	default:
+		return "Outbox+action+failed"
+	}
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/notify"
+)
+
+// brokenTarget fails every delivery
+type brokenTarget struct{}
+
+func (brokenTarget) Notify(context.Context, notify.Notification) error {
+	return errors.New("smtp: connection refused")
+}
+
+func TestOutboxPage_RetryAndDrop(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	outbox := notify.NewOutbox(brokenTarget{}, 10)
+	a := &AdminWeb{templates: tmpl, outbox: outbox, userList: memUserList{}}
+	if err := outbox.Notify(context.Background(), notify.Notification{Kind: notify.KindNewDeviceLogin, Email: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	id := outbox.List()[0].ID
+
+	w := postAdminForm(a.retryOutboxEntry, uuid.New(), id, nil)
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "error=Delivery+failed+again") {
+		t.Fatalf("retry = %d %s", w.Code, w.Header().Get("Location"))
+	}
+
+	w = postAdminForm(a.outboxPage, uuid.New(), uuid.Nil, nil)
+	html := w.Body.String()
+	if !strings.Contains(html, "jane@example.com") || !strings.Contains(html, "smtp: connection refused") {
+		t.Errorf("outbox page misses the failed entry\n%s", html)
+	}
+
+	w = postAdminForm(a.dropOutboxEntry, uuid.New(), id, nil)
+	if !strings.Contains(w.Header().Get("Location"), "success=Notification+dropped") || len(outbox.List()) != 0 {
+		t.Errorf("drop = %s, %d left", w.Header().Get("Location"), len(outbox.List()))
+	}
+	w = postAdminForm(a.dropOutboxEntry, uuid.New(), id, nil)
+	if !strings.Contains(w.Header().Get("Location"), "already+delivered+or+dropped") {
+		t.Errorf("second drop = %s", w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,216 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// inactivityPolicyStore is the subset of InactivityCleanup needed by the settings page
+type inactivityPolicyStore interface {
+	Policy(ctx context.Context) (service.InactivityPolicy, error)
+	SavePolicy(ctx context.Context, p service.InactivityPolicy) error
+	LastReport() *service.InactivityReport
+}
+
+// registrationAccessStore is the subset of Registration needed by the settings page
+type registrationAccessStore interface {
+	Policy(ctx context.Context) (service.RegistrationPolicy, error)
+	SaveAccess(ctx context.Context, mode string, domains []string) error
+}
+
+// migrationCampaignStore is the subset of VaultMigration needed by the admin pages
+type migrationCampaignStore interface {
+	Campaign(ctx context.Context) (service.MigrationCampaign, error)
+	SaveCampaign(ctx context.Context, c service.MigrationCampaign) error
+	Progress(ctx context.Context) (*service.MigrationProgress, error)
+}
+
+// migrationDeadlineLayout is the format of the deadline date input
+const migrationDeadlineLayout = "2006-01-02"
+
+// settingsPageData is the view model of settings.html
+type settingsPageData struct {
+	adminLayout
+
+	Title   string
+	Email   string
+	Error   string
+	Success string
+
+	Policy       service.InactivityPolicy
+	LastReport   *service.InactivityReport // nil before the first run
+	Registration registrationSettings
+	Migration    migrationSettings
+}
+
+// registrationSettings is the registration form of the settings page
+type registrationSettings struct {
+	Mode    string
+	Domains string // comma separated
+}
+
+// migrationSettings is the vault migration form of the settings page
+type migrationSettings struct {
+	Campaign service.MigrationCampaign
+	Deadline string // migrationDeadlineLayout, empty without a campaign
+}
+
+// settingsPage shows the runtime settings
+func (a *AdminWeb) settingsPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	policy, err := a.inactivity.Policy(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load inactivity policy")
+	}
+	registration, err := a.registration.Policy(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load registration policy")
+	}
+	campaign, err := a.migration.Campaign(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load migration campaign")
+	}
+	var deadline string
+	if campaign.Active() {
+		deadline = campaign.Deadline.UTC().Format(migrationDeadlineLayout)
+	}
+
+	data := settingsPageData{
+		Title:      "Settings",
+		Email:      session.Email,
+		Error:      c.Query("error"),
+		Success:    c.Query("success"),
+		Policy:     policy,
+		LastReport: a.inactivity.LastReport(),
+		Registration: registrationSettings{
+			Mode:    registration.Mode,
+			Domains: strings.Join(registration.AllowedDomains, ", "),
+		},
+		Migration: migrationSettings{
+			Campaign: campaign,
+			Deadline: deadline,
+		},
+	}
+	a.render(c, "settings.html", &data)
+}
+
+// saveInactivityPolicy stores the inactivity cleanup policy
+func (a *AdminWeb) saveInactivityPolicy(c *gin.Context) {
+	form, err := formValues(c, map[string]string{
+		"warn_after_months": input.KindToken,
+		"act_after_months":  input.KindToken,
+		"max_per_run":       input.KindToken,
+		"action":            input.KindToken,
+		"include_vaults":    input.KindToken,
+		"dry_run":           input.KindToken,
+	})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/settings?error="+formError(err))
+		return
+	}
+
+	policy := service.InactivityPolicy{
+		Action:        form["action"],
+		IncludeVaults: form["include_vaults"] == "on",
+		DryRun:        form["dry_run"] == "on",
+	}
+	numbers := map[string]*int{
+		"warn_after_months": &policy.WarnAfterMonths,
+		"act_after_months":  &policy.ActAfterMonths,
+		"max_per_run":       &policy.MaxPerRun,
+	}
+	for name, field := range numbers {
+		if *field, err = strconv.Atoi(form[name]); err != nil {
+			c.Redirect(http.StatusFound, "/admin/settings?error=Months+and+limits+must+be+whole+numbers")
+			return
+		}
+	}
+
+	if err := a.inactivity.SavePolicy(c.Request.Context(), policy); err != nil {
+		if errors.Is(err, service.ErrInvalidInactivityPolicy) {
+			c.Redirect(http.StatusFound, "/admin/settings?error="+url.QueryEscape(err.Error()))
+			return
+		}
+		log.Error().Err(err).Msg("Failed to save inactivity policy")
+		c.Redirect(http.StatusFound, "/admin/settings?error=Failed+to+save+settings")
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/settings?success=Inactivity+policy+saved")
+}
+
+// saveRegistrationAccess stores the registration mode and domain allowlist
+func (a *AdminWeb) saveRegistrationAccess(c *gin.Context) {
+	form, err := formValues(c, map[string]string{
+		"mode":    input.KindToken,
+		"domains": input.KindText,
+	})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/settings?error="+formError(err))
+		return
+	}
+
+	var domains []string
+	for _, d := range strings.FieldsFunc(form["domains"], func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+		domains = append(domains, strings.TrimPrefix(d, "@"))
+	}
+	if err := a.registration.SaveAccess(c.Request.Context(), form["mode"], domains); err != nil {
+		log.Error().Err(err).Msg("Failed to save registration policy")
+		c.Redirect(http.StatusFound, "/admin/settings?error="+url.QueryEscape("Failed to save registration settings: "+err.Error()))
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/settings?success=Registration+settings+saved")
+}
+
+// saveMigrationCampaign starts, changes or ends the vault migration campaign
+func (a *AdminWeb) saveMigrationCampaign(c *gin.Context) {
+	form, err := formValues(c, map[string]string{
+		"target_version":    input.KindToken,
+		"deadline":          input.KindToken,
+		"refuse_old_pushes": input.KindToken,
+	})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/settings?error="+formError(err))
+		return
+	}
+
+	campaign := service.MigrationCampaign{RefuseOldPushes: form["refuse_old_pushes"] == "on"}
+	if form["target_version"] != "" {
+		if campaign.TargetVersion, err = strconv.Atoi(form["target_version"]); err != nil {
+			c.Redirect(http.StatusFound, "/admin/settings?error=Target+version+must+be+a+whole+number")
+			return
+		}
+	}
+	if campaign.Active() && form["deadline"] != "" {
+		if campaign.Deadline, err = time.Parse(migrationDeadlineLayout, form["deadline"]); err != nil {
+			c.Redirect(http.StatusFound, "/admin/settings?error=Invalid+deadline")
+			return
+		}
+	}
+
+	if err := a.migration.SaveCampaign(c.Request.Context(), campaign); err != nil {
+		if errors.Is(err, service.ErrInvalidMigrationCampaign) {
+			c.Redirect(http.StatusFound, "/admin/settings?error="+url.QueryEscape(err.Error()))
+			return
+		}
+		log.Error().Err(err).Msg("Failed to save migration campaign")
+		c.Redirect(http.StatusFound, "/admin/settings?error=Failed+to+save+settings")
+		return
+	}
+	if !campaign.Active() {
+		c.Redirect(http.StatusFound, "/admin/settings?success=Migration+campaign+ended")
+		return
+	}
+	log.Info().Int("target_version", campaign.TargetVersion).Time("deadline", campaign.Deadline).Msg("Vault migration campaign saved")
+	c.Redirect(http.StatusFound, "/admin/settings?success=Migration+campaign+saved")
+}
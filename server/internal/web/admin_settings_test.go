@@ -0,0 +1,149 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+type memInactivityPolicy struct {
+	policy service.InactivityPolicy
+	report *service.InactivityReport
+}
+
+func (m *memInactivityPolicy) Policy(context.Context) (service.InactivityPolicy, error) {
+	return m.policy, nil
+}
+
+func (m *memInactivityPolicy) SavePolicy(_ context.Context, p service.InactivityPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	m.policy = p
+	return nil
+}
+
+func (m *memInactivityPolicy) LastReport() *service.InactivityReport { return m.report }
+
+type memSettingStore map[string]string
+
+func (m memSettingStore) All(context.Context) (map[string]string, error) { return m, nil }
+
+func (m memSettingStore) Set(_ context.Context, key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func newTestRegistration(settings memSettingStore) *service.Registration {
+	return service.NewRegistration(nil, nil, nil, settings, nil, nil, nil, service.RegistrationPolicy{Mode: service.RegistrationOpen})
+}
+
+func TestSettingsPage_ShowsLastReport(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	store := &memInactivityPolicy{
+		policy: service.InactivityPolicy{WarnAfterMonths: 6, ActAfterMonths: 1, Action: "block", MaxPerRun: 10, DryRun: true},
+		report: &service.InactivityReport{RanAt: time.Now(), DryRun: true, Action: "block", Warned: []string{"a@example.com", "b@example.com"}},
+	}
+	a := &AdminWeb{templates: tmpl, userList: memUserList{}, inactivity: store, registration: newTestRegistration(memSettingStore{}), migration: service.NewVaultMigration(memSettingStore{}, nil)}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/admin/settings", nil)
+	c.Set("session", &Session{Email: "admin@example.com"})
+	a.settingsPage(c)
+
+	html := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(html, "a@example.com, b@example.com") || !strings.Contains(html, "dry run") {
+		t.Errorf("settings page = %d\n%s", w.Code, html)
+	}
+}
+
+func TestSaveInactivityPolicy(t *testing.T) {
+	store := &memInactivityPolicy{}
+	a := &AdminWeb{inactivity: store}
+
+	form := url.Values{
+		"warn_after_months": {"12"}, "act_after_months": {"2"}, "max_per_run": {"25"},
+		"action": {"delete"}, "include_vaults": {"on"},
+	}
+	w := postAdminForm(a.saveInactivityPolicy, uuid.New(), uuid.Nil, form)
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "success=") {
+		t.Fatalf("redirect = %q", loc)
+	}
+	want := service.InactivityPolicy{WarnAfterMonths: 12, ActAfterMonths: 2, Action: "delete", IncludeVaults: true, MaxPerRun: 25}
+	if store.policy != want {
+		t.Errorf("saved %+v, want %+v", store.policy, want)
+	}
+
+	for name, bad := range map[string]url.Values{
+		"not a number":   {"warn_after_months": {"six"}, "act_after_months": {"1"}, "max_per_run": {"1"}, "action": {"block"}},
+		"unknown action": {"warn_after_months": {"6"}, "act_after_months": {"1"}, "max_per_run": {"1"}, "action": {"purge"}},
+	} {
+		w := postAdminForm(a.saveInactivityPolicy, uuid.New(), uuid.Nil, bad)
+		if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=") {
+			t.Errorf("%s: redirect = %q", name, loc)
+		}
+	}
+	if store.policy != want {
+		t.Errorf("invalid form changed the policy to %+v", store.policy)
+	}
+}
+
+func TestSaveRegistrationAccess(t *testing.T) {
+	registration := newTestRegistration(memSettingStore{})
+	a := &AdminWeb{registration: registration}
+
+	w := postAdminForm(a.saveRegistrationAccess, uuid.New(), uuid.Nil, url.Values{"mode": {"invite"}, "domains": {"Example.com, @corp.org"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "success=") {
+		t.Fatalf("redirect = %q", loc)
+	}
+	policy, _ := registration.Policy(context.Background())
+	if policy.Mode != service.RegistrationInvite || strings.Join(policy.AllowedDomains, " ") != "Example.com corp.org" {
+		t.Errorf("policy = %+v", policy)
+	}
+
+	w = postAdminForm(a.saveRegistrationAccess, uuid.New(), uuid.Nil, url.Values{"mode": {"members-only"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=") {
+		t.Errorf("unknown mode: redirect = %q", loc)
+	}
+	if policy, _ := registration.Policy(context.Background()); policy.Mode != service.RegistrationInvite {
+		t.Errorf("unknown mode changed the policy to %+v", policy)
+	}
+}
+
+func TestSaveMigrationCampaign(t *testing.T) {
+	migration := service.NewVaultMigration(memSettingStore{}, nil)
+	a := &AdminWeb{migration: migration}
+
+	w := postAdminForm(a.saveMigrationCampaign, uuid.New(), uuid.Nil, url.Values{"target_version": {"2"}, "deadline": {"2026-03-01"}, "refuse_old_pushes": {"on"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "success=") {
+		t.Fatalf("redirect = %q", loc)
+	}
+	want := service.MigrationCampaign{TargetVersion: 2, Deadline: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), RefuseOldPushes: true}
+	if got, _ := migration.Campaign(context.Background()); got != want {
+		t.Errorf("campaign = %+v, want %+v", got, want)
+	}
+
+	w = postAdminForm(a.saveMigrationCampaign, uuid.New(), uuid.Nil, url.Values{"target_version": {"3"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=") {
+		t.Errorf("campaign without deadline: redirect = %q", loc)
+	}
+
+	postAdminForm(a.saveMigrationCampaign, uuid.New(), uuid.Nil, url.Values{"target_version": {"0"}})
+	if got, _ := migration.Campaign(context.Background()); got.Active() {
+		t.Errorf("campaign still active after ending it: %+v", got)
+	}
+}
@@ -0,0 +1,337 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// memAdminUsers mirrors how UserRepository maintains the state timestamps
+type memAdminUsers map[uuid.UUID]*models.User
+
+func (m memAdminUsers) GetByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	u, ok := m[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (m memAdminUsers) SetApproved(_ context.Context, id uuid.UUID, approved bool, by *uuid.UUID) error {
+	u := m[id]
+	u.IsApproved, u.ApprovedAt, u.ApprovedBy = approved, nil, nil
+	if approved {
+		now := time.Now()
+		u.ApprovedAt, u.ApprovedBy = &now, by
+	}
+	return nil
+}
+
+func (m memAdminUsers) SetBlocked(_ context.Context, id uuid.UUID, blocked bool, reason string) error {
+	u := m[id]
+	u.IsBlocked, u.BlockedAt, u.BlockedReason = blocked, nil, ""
+	if blocked {
+		now := time.Now()
+		u.BlockedAt, u.BlockedReason = &now, reason
+	}
+	return nil
+}
+
+func (m memAdminUsers) SetAdmin(_ context.Context, id uuid.UUID, admin bool) error {
+	if !admin {
+		last := true
+		for _, u := range m {
+			if u.IsAdmin && u.ID != id {
+				last = false
+			}
+		}
+		if last {
+			return repository.ErrLastAdmin
+		}
+	}
+	m[id].IsAdmin = admin
+	return nil
+}
+
+func (m memAdminUsers) BulkUpdate(_ context.Context, action string, ids []uuid.UUID, _ uuid.UUID) ([]uuid.UUID, error) {
+	for _, id := range ids {
+		switch action {
+		case models.BulkApprove:
+			m[id].IsApproved = true
+		case models.BulkBlock, models.BulkUnblock:
+			m[id].IsBlocked = action == models.BulkBlock
+		case models.BulkDelete:
+			delete(m, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m memAdminUsers) Delete(_ context.Context, id uuid.UUID) error {
+	delete(m, id)
+	return nil
+}
+
+type nopAdminTokens struct{}
+
+func (nopAdminTokens) RevokeAllForUser(context.Context, uuid.UUID) error   { return nil }
+func (nopAdminTokens) RevokeAllForDevice(context.Context, uuid.UUID) error { return nil }
+func (nopAdminTokens) GetActiveByUserID(context.Context, uuid.UUID) ([]models.Session, error) {
+	return nil, nil
+}
+func (nopAdminTokens) RevokeByID(context.Context, uuid.UUID, uuid.UUID) error {
+	return repository.ErrRefreshTokenNotFound
+}
+
+type memAdminAudit struct{ events []models.AuditEvent }
+
+func (a *memAdminAudit) Create(_ context.Context, event *models.AuditEvent) error {
+	a.events = append(a.events, *event)
+	return nil
+}
+
+// postAdminForm submits a form to an admin handler as the given admin
+func postAdminForm(handler gin.HandlerFunc, actor, id uuid.UUID, form url.Values) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Params = gin.Params{{Key: "id", Value: id.String()}}
+	c.Set("session", &Session{UserID: actor})
+	handler(c)
+	c.Writer.WriteHeaderNow()
+	return w
+}
+
+func TestAdminWeb_StateTimestamps(t *testing.T) {
+	id, actor := uuid.New(), uuid.New()
+	users := memAdminUsers{id: {ID: id, Email: "web@example.com"}}
+	audit := &memAdminAudit{}
+	a := &AdminWeb{userAdmin: service.NewUserAdmin(users, nil, nil, nopAdminTokens{}, nil, audit)}
+
+	w := postAdminForm(a.approveUser, actor, id, nil)
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "success") {
+		t.Fatalf("approve: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if u := users[id]; u.ApprovedAt == nil || u.ApprovedBy == nil || *u.ApprovedBy != actor {
+		t.Errorf("approved user = %+v, want approved_at and approved_by set", u)
+	}
+
+	w = postAdminForm(a.blockUser, actor, id, url.Values{"action": {"block"}, "reason": {"abuse"}})
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "success") {
+		t.Fatalf("block: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if u := users[id]; u.BlockedAt == nil || u.BlockedReason != "abuse" {
+		t.Errorf("blocked user = %+v, want blocked_at and reason set", u)
+	}
+
+	w = postAdminForm(a.blockUser, actor, id, url.Values{"action": {"unblock"}})
+	if w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "success") {
+		t.Fatalf("unblock: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+	if u := users[id]; u.BlockedAt != nil || u.BlockedReason != "" || u.ApprovedAt == nil {
+		t.Errorf("unblocked user = %+v, want block state cleared and approval kept", u)
+	}
+
+	var reasons []string
+	for _, event := range audit.events {
+		if event.Action == models.AuditUserBlocked {
+			reasons = append(reasons, event.Details["reason"])
+		}
+	}
+	if len(reasons) != 1 || reasons[0] != "abuse" {
+		t.Errorf("block audit reasons = %v, want [abuse]", reasons)
+	}
+}
+
+func TestAdminWeb_SetUserRole(t *testing.T) {
+	actor, id := uuid.New(), uuid.New()
+	users := memAdminUsers{
+		actor: {ID: actor, IsApproved: true, IsAdmin: true},
+		id:    {ID: id, IsApproved: true},
+	}
+	a := &AdminWeb{sessions: NewSessionStore(time.Hour), userAdmin: service.NewUserAdmin(users, nil, nil, nopAdminTokens{}, nil, &memAdminAudit{})}
+
+	w := postAdminForm(a.setUserRole, actor, actor, url.Values{"action": {"demote"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "last+admin") {
+		t.Errorf("demote last admin: location = %q", loc)
+	}
+
+	w = postAdminForm(a.setUserRole, actor, id, url.Values{"action": {"promote"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "success") || !users[id].IsAdmin {
+		t.Fatalf("promote: location = %q, admin = %v", loc, users[id].IsAdmin)
+	}
+
+	// Demoting ends the demoted admin's sessions
+	session, err := a.sessions.Create(id, "", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = postAdminForm(a.setUserRole, actor, id, url.Values{"action": {"demote"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "success") || users[id].IsAdmin {
+		t.Fatalf("demote: location = %q, admin = %v", loc, users[id].IsAdmin)
+	}
+	if a.sessions.Get(session.ID) != nil {
+		t.Error("demoted admin's session still valid")
+	}
+}
+
+func TestAdminWeb_BulkUsers(t *testing.T) {
+	admin, a1, a2 := uuid.New(), uuid.New(), uuid.New()
+	users := memAdminUsers{
+		admin: {ID: admin, IsApproved: true, IsAdmin: true},
+		a1:    {ID: a1},
+		a2:    {ID: a2},
+	}
+	a := &AdminWeb{userAdmin: service.NewUserAdmin(users, nil, nil, nopAdminTokens{}, nil, &memAdminAudit{})}
+
+	form := url.Values{"action": {"approve"}, "user_ids": {a1.String(), a2.String(), admin.String()}}
+	w := postAdminForm(a.bulkUsers, admin, uuid.Nil, form)
+	if loc := w.Header().Get("Location"); loc != "/admin/users?success="+url.QueryEscape("Users approved: 2, skipped: 1") {
+		t.Errorf("approve: location = %q", loc)
+	}
+	if !users[a1].IsApproved || !users[a2].IsApproved {
+		t.Error("selected users not approved")
+	}
+
+	w = postAdminForm(a.bulkUsers, admin, uuid.Nil, url.Values{"action": {"approve"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "No+users+selected") {
+		t.Errorf("no selection: location = %q", loc)
+	}
+	w = postAdminForm(a.bulkUsers, admin, uuid.Nil, url.Values{"action": {""}, "user_ids": {a1.String()}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "Choose+an+action") {
+		t.Errorf("no action: location = %q", loc)
+	}
+}
+
+func TestAdminTemplatesRenderStateTimestamps(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	blockedAt := time.Now().Add(-2 * time.Hour)
+	avg := int64(90 * 60)
+
+	var buf bytes.Buffer
+	err = tmpl.Render(&buf, "users.html", usersPageData{AllUsers: []userRow{{
+		ID: uuid.NewString(), Email: "b@example.com", IsBlocked: true,
+		BlockedAt: &blockedAt, BlockedReason: "spam",
+	}}})
+	if err != nil {
+		t.Fatalf("render users: %v", err)
+	}
+	if !strings.Contains(buf.String(), "since 2 hours ago") || !strings.Contains(buf.String(), "spam") {
+		t.Errorf("users page does not show block state:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	largest := []models.VaultUsage{{UserID: uuid.New(), Email: "big@example.com", SizeBytes: 3 << 19, Revision: 4, UpdatedAt: time.Now()}}
+	maintenance := &service.MaintenanceReport{RanAt: time.Now().Add(-10 * time.Minute), SyncLogsDeleted: 42, TokensDeleted: 7}
+	if err := tmpl.Render(&buf, "dashboard.html", dashboardPageData{AvgApproval: &avg, Vaults: 3, DeletedVaults: 1, VaultBytes: 2 << 20, LargestVaults: largest, Maintenance: maintenance}); err != nil {
+		t.Fatalf("render dashboard: %v", err)
+	}
+	if !strings.Contains(buf.String(), "approved after 1h 30m on average") {
+		t.Error("dashboard does not show average time to approval")
+	}
+	if !strings.Contains(buf.String(), "2.0 MiB stored") || !strings.Contains(buf.String(), "1.5 MiB") {
+		t.Errorf("dashboard does not show vault sizes:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "1 deleted, awaiting purge") {
+		t.Error("dashboard does not show soft-deleted vaults")
+	}
+	if !strings.Contains(buf.String(), "Sync log entries removed: 42") || !strings.Contains(buf.String(), "Refresh tokens removed: 7") {
+		t.Error("dashboard does not show the last maintenance run")
+	}
+}
+
+func TestAdminTemplatesRenderEmailVerification(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	pending := []userRow{
+		{ID: uuid.NewString(), Email: "ok@example.com", EmailVerified: true, CreatedAt: time.Now()},
+		{ID: uuid.NewString(), Email: "typo@exmaple.com", EmailVerified: false, CreatedAt: time.Now()},
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Render(&buf, "users.html", usersPageData{PendingUsers: pending, AllUsers: pending}); err != nil {
+		t.Fatalf("render users: %v", err)
+	}
+	html := buf.String()
+	if !strings.Contains(html, ">Verified<") || !strings.Contains(html, ">Unverified<") {
+		t.Error("pending table does not show email verification status")
+	}
+	if strings.Count(html, "email not verified") != 1 {
+		t.Error("user list should flag exactly the unverified user")
+	}
+}
+
+// serveAdmin requests path on the registered admin routes with the
+// session cookie of sessionID
+func serveAdmin(r *gin.Engine, method, path, sessionID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: sessionID})
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminWeb_RequiresAdmin(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	admin, member := uuid.New(), uuid.New()
+	users := memAdminUsers{
+		admin:  {ID: admin, IsApproved: true, IsAdmin: true},
+		member: {ID: member, IsApproved: true},
+	}
+	a := &AdminWeb{templates: tmpl, sessions: NewSessionStore(time.Hour), admins: users}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	a.RegisterRoutes(r, func(*gin.Context) {})
+
+	// Every page but the login pages turns a non-admin session away
+	nonAdmin, _ := a.sessions.Create(member, "member@example.com", false, false)
+	for _, route := range r.Routes() {
+		if !strings.HasPrefix(route.Path, "/admin") || strings.HasPrefix(route.Path, "/admin/login") || strings.HasPrefix(route.Path, "/admin/static") {
+			continue
+		}
+		path := strings.NewReplacer(":id", uuid.NewString(), ":deviceId", uuid.NewString()).Replace(route.Path)
+		if w := serveAdmin(r, route.Method, path, nonAdmin.ID); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want 403", route.Method, route.Path, w.Code)
+		}
+	}
+
+	session, _ := a.sessions.Create(admin, "admin@example.com", true, false)
+	if w := serveAdmin(r, "GET", "/admin/", session.ID); w.Code != http.StatusFound || w.Header().Get("Location") != "/admin/dashboard" {
+		t.Fatalf("admin: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// A demotion ends the session on the next request
+	users[admin].IsAdmin = false
+	if w := serveAdmin(r, "GET", "/admin/dashboard", session.ID); w.Code != http.StatusForbidden {
+		t.Errorf("demoted admin: status = %d, want 403", w.Code)
+	}
+	if a.sessions.Get(session.ID) != nil {
+		t.Error("demoted admin's session still valid")
+	}
+	if w := serveAdmin(r, "GET", "/admin/users", session.ID); w.Header().Get("Location") != "/admin/login" {
+		t.Errorf("demoted admin: status = %d, location = %q, want login", w.Code, w.Header().Get("Location"))
+	}
+}
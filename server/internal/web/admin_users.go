@@ -0,0 +1,583 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// usersPerPage is the page size of the full user list
+const usersPerPage = 50
+
+// userListStore is the subset of UserRepository needed by the users page
+type userListStore interface {
+	ListPending(ctx context.Context) ([]models.User, error)
+	ListPage(ctx context.Context, q models.UserListQuery) ([]models.User, error)
+	CountMatching(ctx context.Context, q models.UserListQuery) (int, error)
+	Count(ctx context.Context) (total, approved, pending, blocked int, err error)
+}
+
+// userRow is a user as shown on the users page
+type userRow struct {
+	ID            string
+	Email         string
+	IsApproved    bool
+	IsAdmin       bool
+	IsBlocked     bool
+	EmailVerified bool
+	TOTPEnabled   bool
+	CreatedAt     time.Time
+	LastLoginAt   *time.Time
+	ApprovedAt    *time.Time
+	BlockedAt     *time.Time
+	BlockedReason string
+
+	DisplayName         string
+	RegistrationMessage string
+}
+
+func newUserRow(u *models.User) userRow {
+	return userRow{
+		ID:            u.ID.String(),
+		Email:         u.Email,
+		IsApproved:    u.IsApproved,
+		IsAdmin:       u.IsAdmin,
+		IsBlocked:     u.IsBlocked,
+		EmailVerified: u.EmailVerified,
+		TOTPEnabled:   u.TOTPEnabled,
+		CreatedAt:     u.CreatedAt,
+		LastLoginAt:   u.LastLoginAt,
+		ApprovedAt:    u.ApprovedAt,
+		BlockedAt:     u.BlockedAt,
+		BlockedReason: u.BlockedReason,
+
+		DisplayName:         u.DisplayName,
+		RegistrationMessage: u.RegistrationMessage,
+	}
+}
+
+// userCounts summarizes all users regardless of the filter
+type userCounts struct {
+	Total, Approved, Pending, Blocked int
+}
+
+// userFilter is the filter of the full user list. It is kept in the
+// pagination links.
+type userFilter struct {
+	Status string
+	Search string
+}
+
+// userStatuses are the accepted values of the status filter
+var userStatuses = []string{models.UserStatusActive, models.UserStatusPending, models.UserStatusBlocked, models.UserStatusAdmin}
+
+// parseUserFilter reads the filter from the query string. Unknown
+// statuses and unusable search terms are ignored.
+func parseUserFilter(c *gin.Context) userFilter {
+	var f userFilter
+	if status := c.Query("status"); slices.Contains(userStatuses, status) {
+		f.Status = status
+	}
+	if search, err := input.Clean("q", input.KindEmail, strings.TrimSpace(c.Query("q"))); err == nil {
+		f.Search = search
+	}
+	return f
+}
+
+// pageURL links to page of the user list with the filter applied
+func (f userFilter) pageURL(page int) string {
+	q := f.query()
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return withQuery("/admin/users", q)
+}
+
+// exportURL links to the CSV export file with the filter applied
+func (f userFilter) exportURL(file string) string {
+	return withQuery("/admin/users/"+file, f.query())
+}
+
+// query encodes the filter as query parameters
+func (f userFilter) query() url.Values {
+	q := url.Values{}
+	if f.Status != "" {
+		q.Set("status", f.Status)
+	}
+	if f.Search != "" {
+		q.Set("q", f.Search)
+	}
+	return q
+}
+
+// withQuery appends q to path unless it is empty
+func withQuery(path string, q url.Values) string {
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
+
+// userTab links to the user list filtered by one status
+type userTab struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// statusTabs returns a tab per status, plus one for all users, that keep
+// the search term
+func (f userFilter) statusTabs() []userTab {
+	tabs := make([]userTab, 0, len(userStatuses)+1)
+	for _, status := range append([]string{""}, userStatuses...) {
+		label := "All"
+		if status != "" {
+			label = strings.ToUpper(status[:1]) + status[1:]
+		}
+		tab := userFilter{Status: status, Search: f.Search}
+		tabs = append(tabs, userTab{Label: label, URL: tab.pageURL(1), Active: status == f.Status})
+	}
+	return tabs
+}
+
+// usersPageData is the view model of users.html
+type usersPageData struct {
+	adminLayout
+
+	Title   string
+	Email   string
+	Success string
+	Error   string
+
+	PendingUsers []userRow // always complete
+	AllUsers     []userRow // current page
+	Counts       userCounts
+
+	Filter     userFilter
+	Tabs       []userTab
+	Page       int
+	TotalPages int
+	Matching   int // users matching the filter
+	First      int // 1-based position of the first row shown
+	Last       int
+	PrevURL    string
+	NextURL    string
+
+	ExportUsersURL    string
+	ExportSyncLogsURL string
+}
+
+// usersPage shows the pending users and one page of all users
+func (a *AdminWeb) usersPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	ctx := c.Request.Context()
+
+	filter := parseUserFilter(c)
+	query := models.UserListQuery{Status: filter.Status, Search: filter.Search, Limit: usersPerPage}
+
+	data := usersPageData{
+		Title:   "Users",
+		Email:   session.Email,
+		Success: c.Query("success"),
+		Error:   c.Query("error"),
+		Filter:  filter,
+		Tabs:    filter.statusTabs(),
+
+		ExportUsersURL:    filter.exportURL("export.csv"),
+		ExportSyncLogsURL: filter.exportURL("sync-logs.csv"),
+	}
+
+	pending, err := a.userList.ListPending(ctx)
+	if err == nil {
+		data.Counts.Total, data.Counts.Approved, data.Counts.Pending, data.Counts.Blocked, err = a.userList.Count(ctx)
+		c.Set(pendingApprovalsKey, data.Counts.Pending)
+	}
+	if err == nil {
+		data.Matching, err = a.userList.CountMatching(ctx, query)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count users")
+		c.String(http.StatusInternalServerError, "Failed to load users")
+		return
+	}
+
+	// Out-of-range pages show the nearest existing page
+	data.TotalPages = max(1, (data.Matching+usersPerPage-1)/usersPerPage)
+	data.Page, _ = strconv.Atoi(c.Query("page"))
+	data.Page = min(max(data.Page, 1), data.TotalPages)
+	query.Offset = (data.Page - 1) * usersPerPage
+
+	users, err := a.userList.ListPage(ctx, query)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list users")
+		c.String(http.StatusInternalServerError, "Failed to load users")
+		return
+	}
+
+	for i := range pending {
+		data.PendingUsers = append(data.PendingUsers, newUserRow(&pending[i]))
+	}
+	for i := range users {
+		data.AllUsers = append(data.AllUsers, newUserRow(&users[i]))
+	}
+	if len(users) > 0 {
+		data.First = query.Offset + 1
+		data.Last = query.Offset + len(users)
+	}
+	if data.Page > 1 {
+		data.PrevURL = filter.pageURL(data.Page - 1)
+	}
+	if data.Page < data.TotalPages {
+		data.NextURL = filter.pageURL(data.Page + 1)
+	}
+
+	a.render(c, "users.html", &data)
+}
+
+// activityRow is a sync log entry as shown on the user detail page
+type activityRow struct {
+	CreatedAt      time.Time
+	Action         string
+	Vault          string
+	Device         string // name, or empty if the device is gone
+	RevisionBefore *int
+	RevisionAfter  *int
+}
+
+// userDetailPageData is the view model of user_detail.html
+type userDetailPageData struct {
+	adminLayout
+
+	Title   string
+	Email   string
+	Success string
+	Error   string
+
+	User     models.AdminUser
+	Devices  []models.Device
+	Sessions []models.Session
+	Vault    *models.AdminVaultInfo // nil without a vault
+	Activity []activityRow
+}
+
+// userDetailPage shows the account flags, devices, active sessions, vault
+// metadata and recent sync activity of one user
+func (a *AdminWeb) userDetailPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
+		return
+	}
+
+	detail, err := a.userAdmin.Detail(c.Request.Context(), userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID.String()).Msg("Failed to load user detail")
+		c.String(http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+
+	names := make(map[uuid.UUID]string, len(detail.Devices))
+	for _, d := range detail.Devices {
+		names[d.ID] = d.DeviceName
+	}
+	data := userDetailPageData{
+		Title:    "Users",
+		Email:    session.Email,
+		Success:  c.Query("success"),
+		Error:    c.Query("error"),
+		User:     detail.User,
+		Devices:  detail.Devices,
+		Sessions: detail.Sessions,
+		Vault:    detail.Vault,
+	}
+	for _, entry := range detail.RecentActivity {
+		row := activityRow{
+			CreatedAt:      entry.CreatedAt,
+			Action:         entry.Action,
+			Vault:          entry.Vault,
+			RevisionBefore: entry.RevisionBefore,
+			RevisionAfter:  entry.RevisionAfter,
+		}
+		if entry.DeviceID != nil {
+			row.Device = names[*entry.DeviceID]
+		}
+		data.Activity = append(data.Activity, row)
+	}
+
+	a.render(c, "user_detail.html", &data)
+}
+
+// userDevicesPageData is the view model of admin_user_devices.html
+type userDevicesPageData struct {
+	adminLayout
+
+	Title   string
+	Email   string
+	Success string
+	Error   string
+
+	User    models.AdminUser
+	Devices []models.AdminDevice
+}
+
+// userDevicesPage lists a user's devices with their sessions and last
+// activity, each with a button to remove it
+func (a *AdminWeb) userDevicesPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
+		return
+	}
+
+	devices, err := a.userAdmin.Devices(c.Request.Context(), userID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("user_id", userID.String()).Msg("Failed to load user devices")
+		c.String(http.StatusInternalServerError, "Failed to load devices")
+		return
+	}
+
+	data := userDevicesPageData{
+		Title:   "Users",
+		Email:   session.Email,
+		Success: c.Query("success"),
+		Error:   c.Query("error"),
+		User:    devices.User,
+		Devices: devices.Devices,
+	}
+
+	a.render(c, "admin_user_devices.html", &data)
+}
+
+// deleteUserDevice revokes the sessions of one of a user's devices and
+// deletes it
+func (a *AdminWeb) deleteUserDevice(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
+		return
+	}
+	page := "/admin/users/" + userID.String() + "/devices"
+	deviceID, err := uuid.Parse(c.Param("deviceId"))
+	if err != nil {
+		c.Redirect(http.StatusFound, page+"?error=Invalid+device+ID")
+		return
+	}
+
+	session := c.MustGet("session").(*Session)
+	if _, err := a.userAdmin.DeleteDevice(c.Request.Context(), session.UserID, userID, deviceID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
+		case errors.Is(err, repository.ErrDeviceNotFound):
+			c.Redirect(http.StatusFound, page+"?error=Device+not+found")
+		default:
+			log.Error().Err(err).Str("user_id", userID.String()).Str("device_id", deviceID.String()).Msg("Failed to delete device")
+			c.Redirect(http.StatusFound, page+"?error=Failed+to+delete+device")
+		}
+		return
+	}
+
+	log.Info().Str("user_id", userID.String()).Str("device_id", deviceID.String()).Msg("Device deleted via web interface")
+	c.Redirect(http.StatusFound, page+"?success=Device+deleted")
+}
+
+// revokeUserSession ends one session of a user. The device stays
+// registered and can sign in again.
+func (a *AdminWeb) revokeUserSession(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
+		return
+	}
+	page := "/admin/users/" + userID.String()
+	sessionID, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		c.Redirect(http.StatusFound, page+"?error=Invalid+session+ID")
+		return
+	}
+
+	session := c.MustGet("session").(*Session)
+	if _, err := a.userAdmin.RevokeSession(c.Request.Context(), session.UserID, userID, sessionID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
+		case errors.Is(err, repository.ErrRefreshTokenNotFound):
+			c.Redirect(http.StatusFound, page+"?error=Session+not+found+or+already+ended")
+		default:
+			log.Error().Err(err).Str("user_id", userID.String()).Str("session_id", sessionID.String()).Msg("Failed to revoke session")
+			c.Redirect(http.StatusFound, page+"?error=Failed+to+revoke+session")
+		}
+		return
+	}
+
+	log.Info().Str("user_id", userID.String()).Str("session_id", sessionID.String()).Msg("Session revoked via web interface")
+	c.Redirect(http.StatusFound, page+"?success=Session+revoked")
+}
+
+// setUserRole promotes a user to admin or demotes an admin. A demoted
+// admin's sessions end at once; demoting yourself signs you out.
+func (a *AdminWeb) setUserRole(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
+		return
+	}
+	form, err := formValues(c, map[string]string{"action": input.KindToken})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users?error="+formError(err))
+		return
+	}
+	promote := form["action"] == "promote"
+	if !promote && form["action"] != "demote" {
+		c.Redirect(http.StatusFound, "/admin/users?error=Invalid+action")
+		return
+	}
+
+	session := c.MustGet("session").(*Session)
+	if _, err := a.userAdmin.SetAdmin(c.Request.Context(), session.UserID, userID, promote); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUserNotFound):
+			c.Redirect(http.StatusFound, "/admin/users?error=User+not+found")
+		case errors.Is(err, repository.ErrLastAdmin):
+			c.Redirect(http.StatusFound, "/admin/users?error=Cannot+demote+the+last+admin")
+		case errors.Is(err, service.ErrUserBlocked), errors.Is(err, service.ErrUserNotApproved):
+			c.Redirect(http.StatusFound, "/admin/users?error=Only+active+users+can+become+admins")
+		case errors.Is(err, service.ErrUserAlreadyAdmin), errors.Is(err, service.ErrUserNotAdmin):
+			c.Redirect(http.StatusFound, "/admin/users?error=User+role+already+changed")
+		default:
+			log.Error().Err(err).Str("user_id", userID.String()).Bool("admin", promote).Msg("Failed to change user role")
+			c.Redirect(http.StatusFound, "/admin/users?error=Failed+to+update+user")
+		}
+		return
+	}
+
+	log.Info().Str("user_id", userID.String()).Bool("admin", promote).Msg("User role changed via web interface")
+	if promote {
+		c.Redirect(http.StatusFound, "/admin/users?success=User+promoted+to+admin")
+		return
+	}
+	a.sessions.DeleteForUser(userID)
+	if userID == session.UserID {
+		c.Redirect(http.StatusFound, "/admin/login")
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/users?success=User+demoted")
+}
+
+// bulkPastTense names bulk actions in the result message
+var bulkPastTense = map[string]string{
+	models.BulkApprove: "approved",
+	models.BulkBlock:   "blocked",
+	models.BulkUnblock: "unblocked",
+	models.BulkDelete:  "deleted",
+}
+
+// bulkUsers applies the action picked on the users page to the checked
+// users. The page asks for confirmation before submitting.
+func (a *AdminWeb) bulkUsers(c *gin.Context) {
+	form, err := formValues(c, map[string]string{"action": input.KindToken})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/admin/users?error="+formError(err))
+		return
+	}
+	var ids []uuid.UUID
+	for _, value := range c.PostFormArray("user_ids") {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.Redirect(http.StatusFound, "/admin/users?error=Invalid+user+ID")
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		c.Redirect(http.StatusFound, "/admin/users?error=No+users+selected")
+		return
+	}
+
+	session := c.MustGet("session").(*Session)
+	results, err := a.userAdmin.Bulk(c.Request.Context(), session.UserID, form["action"], ids)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownBulkAction):
+			c.Redirect(http.StatusFound, "/admin/users?error=Choose+an+action")
+		case errors.Is(err, service.ErrTooManyUsers):
+			c.Redirect(http.StatusFound, "/admin/users?error=Too+many+users+selected")
+		default:
+			log.Error().Err(err).Str("action", form["action"]).Int("users", len(ids)).Msg("Failed to apply bulk action")
+			c.Redirect(http.StatusFound, "/admin/users?error=Failed+to+update+users")
+		}
+		return
+	}
+
+	done := 0
+	for _, r := range results {
+		if r.Status == models.BulkStatusDone {
+			done++
+		}
+	}
+	message := fmt.Sprintf("Users %s: %d", bulkPastTense[form["action"]], done)
+	if skipped := len(results) - done; skipped > 0 {
+		message += fmt.Sprintf(", skipped: %d", skipped)
+	}
+	log.Info().Str("action", form["action"]).Int("done", done).Int("selected", len(results)).Msg("Bulk action applied via web interface")
+	c.Redirect(http.StatusFound, "/admin/users?success="+url.QueryEscape(message))
+}
+
+// exportUsers downloads the users matching the list filter as CSV
+func (a *AdminWeb) exportUsers(c *gin.Context) {
+	filter := parseUserFilter(c)
+	streamCSV(c, "users", func(w io.Writer) error {
+		return a.exports.WriteUsers(c.Request.Context(), w, models.UserListQuery{Status: filter.Status, Search: filter.Search})
+	})
+}
+
+// exportSyncLogs downloads the sync activity of the users matching the
+// list filter as CSV
+func (a *AdminWeb) exportSyncLogs(c *gin.Context) {
+	filter := parseUserFilter(c)
+	streamCSV(c, "sync-logs", func(w io.Writer) error {
+		return a.exports.WriteSyncLogs(c.Request.Context(), w, models.UserListQuery{Status: filter.Status, Search: filter.Search})
+	})
+}
+
+// streamCSV sends the CSV written by write as a dated download
+func streamCSV(c *gin.Context, name string, write func(io.Writer) error) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="vibedterm-`+name+"-"+time.Now().UTC().Format("2006-01-02")+`.csv"`)
+	c.Header("Cache-Control", "no-store")
+
+	if err := write(c.Writer); err != nil {
+		log.Error().Err(err).Str("export", name).Msg("Failed to export CSV")
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			c.Redirect(http.StatusFound, "/admin/users?error=Failed+to+export+"+name)
+		}
+	}
+}
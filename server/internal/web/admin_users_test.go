@@ -0,0 +1,441 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// memUserList filters and pages like UserRepository.ListPage
+type memUserList []models.User
+
+func (m memUserList) matching(q models.UserListQuery) []models.User {
+	var out []models.User
+	for _, u := range m {
+		active := u.IsApproved && !u.IsBlocked
+		pending := !u.IsApproved && !u.IsBlocked
+		switch {
+		case q.Status == models.UserStatusActive && !active,
+			q.Status == models.UserStatusPending && !pending,
+			q.Status == models.UserStatusBlocked && !u.IsBlocked,
+			q.Status == models.UserStatusAdmin && !u.IsAdmin,
+			!strings.Contains(strings.ToLower(u.Email), strings.ToLower(q.Search)):
+			continue
+		}
+		out = append(out, u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+func (m memUserList) ListPending(context.Context) ([]models.User, error) {
+	return m.matching(models.UserListQuery{Status: models.UserStatusPending}), nil
+}
+
+func (m memUserList) ListPage(_ context.Context, q models.UserListQuery) ([]models.User, error) {
+	users := m.matching(q)
+	if q.Offset >= len(users) {
+		return nil, nil
+	}
+	return users[q.Offset:min(q.Offset+q.Limit, len(users))], nil
+}
+
+func (m memUserList) CountMatching(_ context.Context, q models.UserListQuery) (int, error) {
+	return len(m.matching(q)), nil
+}
+
+func (m memUserList) Count(context.Context) (total, approved, pending, blocked int, err error) {
+	for _, u := range m {
+		total++
+		switch {
+		case u.IsBlocked:
+			blocked++
+		case u.IsApproved:
+			approved++
+		default:
+			pending++
+		}
+	}
+	return
+}
+
+// newUserList creates approved users plus the given number of pending ones
+func newUserList(approved, pending int) memUserList {
+	var users memUserList
+	created := time.Now()
+	for i := 0; i < approved+pending; i++ {
+		created = created.Add(-time.Minute)
+		users = append(users, models.User{
+			ID: uuid.New(), Email: fmt.Sprintf("user%03d@example.com", i),
+			IsApproved: i < approved, EmailVerified: true, CreatedAt: created,
+		})
+	}
+	return users
+}
+
+// getUsersPage renders the users page for the given query string
+func getUsersPage(t *testing.T, users memUserList, query string) string {
+	t.Helper()
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	a := &AdminWeb{templates: tmpl, userList: users}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/admin/users?"+query, nil)
+	c.Set("session", &Session{Email: "admin@example.com"})
+	a.usersPage(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET ?%s: status = %d", query, w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestUsersPage_PageBoundaries(t *testing.T) {
+	users := newUserList(2*usersPerPage, 1) // two full pages and one more row
+
+	for _, tc := range []struct {
+		query      string
+		showing    string
+		page       string
+		prev, next bool
+	}{
+		{"", "Showing 1&ndash;50 of 101", "Page 1 of 3", false, true},
+		{"page=0", "Showing 1&ndash;50 of 101", "Page 1 of 3", false, true},
+		{"page=2", "Showing 51&ndash;100 of 101", "Page 2 of 3", true, true},
+		{"page=3", "Showing 101&ndash;101 of 101", "Page 3 of 3", true, false},
+		{"page=99", "Showing 101&ndash;101 of 101", "Page 3 of 3", true, false},
+		{"page=abc", "Showing 1&ndash;50 of 101", "Page 1 of 3", false, true},
+	} {
+		html := getUsersPage(t, users, tc.query)
+		if !strings.Contains(html, tc.showing) || !strings.Contains(html, tc.page) {
+			t.Errorf("?%s: want %q and %q", tc.query, tc.showing, tc.page)
+		}
+		if got := strings.Contains(html, "Previous"); got != tc.prev {
+			t.Errorf("?%s: previous link = %v, want %v", tc.query, got, tc.prev)
+		}
+		if got := strings.Contains(html, "Next &rarr;"); got != tc.next {
+			t.Errorf("?%s: next link = %v, want %v", tc.query, got, tc.next)
+		}
+	}
+
+	// The pending list is complete on every page
+	html := getUsersPage(t, users, "page=2")
+	if !strings.Contains(html, "Pending Approval") || !strings.Contains(html, "user100@example.com") {
+		t.Error("pending user missing on page 2")
+	}
+	if !strings.Contains(html, "101 users &middot; 100 approved") {
+		t.Error("count summary missing")
+	}
+
+	// Exactly one page needs no pagination
+	html = getUsersPage(t, newUserList(usersPerPage, 0), "")
+	if strings.Contains(html, "Page 1 of") {
+		t.Error("single page should not show pagination")
+	}
+}
+
+func TestUsersPage_FilterPersistsInLinks(t *testing.T) {
+	users := newUserList(3*usersPerPage, 5)
+	q := url.Values{"status": {"active"}, "q": {"user1"}, "page": {"2"}}
+	html := getUsersPage(t, users, q.Encode())
+
+	// Of user000..user154, user100..user149 are active and match "user1";
+	// page 2 does not exist and falls back to page 1
+	if !strings.Contains(html, "Showing 1&ndash;50 of 50") {
+		t.Fatalf("filtered list wrong:\n%s", html)
+	}
+
+	q = url.Values{"status": {"active"}, "q": {"@example"}, "page": {"2"}}
+	html = getUsersPage(t, users, q.Encode())
+	if !strings.Contains(html, "Showing 51&ndash;100 of 150") {
+		t.Fatalf("filtered page 2 not shown:\n%s", html)
+	}
+	for _, page := range []int{1, 3} {
+		link := userFilter{Status: "active", Search: "@example"}.pageURL(page)
+		if !strings.Contains(html, `href="`+strings.ReplaceAll(link, "&", "&amp;")+`"`) {
+			t.Errorf("link to page %d does not keep the filter, want %q", page, link)
+		}
+	}
+	if !strings.Contains(html, `status-tab-active">Active<`) || !strings.Contains(html, `name="status" value="active"`) || !strings.Contains(html, `value="@example"`) {
+		t.Error("filter form does not show the current filter")
+	}
+	if !strings.Contains(html, `href="/admin/users?q=%40example&amp;status=pending"`) {
+		t.Error("status tabs do not keep the search")
+	}
+	if !strings.Contains(html, `href="/admin/users/export.csv?q=%40example&amp;status=active"`) {
+		t.Error("export link does not keep the filter")
+	}
+
+	// Search terms are encoded and unknown statuses dropped
+	html = getUsersPage(t, newUserList(2*usersPerPage, 0), url.Values{"status": {"bogus"}, "q": {"example.com&x"}}.Encode())
+	if !strings.Contains(html, "No users match") {
+		t.Error("search with no match should say so")
+	}
+	html = getUsersPage(t, newUserList(2*usersPerPage, 0), url.Values{"status": {"bogus"}, "q": {"@example"}}.Encode())
+	if !strings.Contains(html, `href="/admin/users?page=2&amp;q=%40example"`) {
+		t.Errorf("next link should carry the encoded search and no status")
+	}
+}
+
+func TestUserFilterPageURL(t *testing.T) {
+	for _, tc := range []struct {
+		filter userFilter
+		page   int
+		want   string
+	}{
+		{userFilter{}, 1, "/admin/users"},
+		{userFilter{}, 2, "/admin/users?page=2"},
+		{userFilter{Status: "blocked", Search: "a b&c"}, 1, "/admin/users?q=a+b%26c&status=blocked"},
+	} {
+		if got := tc.filter.pageURL(tc.page); got != tc.want {
+			t.Errorf("pageURL(%+v, %d) = %q, want %q", tc.filter, tc.page, got, tc.want)
+		}
+	}
+}
+
+func TestUsersPage_PendingShowsRegistrationProfile(t *testing.T) {
+	users := newUserList(0, 2)
+	users[0].DisplayName = "Jane Doe"
+	users[0].RegistrationMessage = "<b>Ops</b> team"
+
+	html := getUsersPage(t, users, "")
+	if !strings.Contains(html, "Jane Doe") || !strings.Contains(html, "&lt;b&gt;Ops&lt;/b&gt; team") {
+		t.Error("pending list does not show the escaped name and message")
+	}
+	if !strings.Contains(html, "No name given") {
+		t.Error("pending user without a name not marked")
+	}
+}
+
+// userDetailStores serves one user's devices, vault and sync log
+type userDetailStores struct {
+	devices []models.Device
+	vault   *models.VaultMetadata
+	logs    []models.SyncLog
+}
+
+func (s *userDetailStores) GetByUserID(context.Context, uuid.UUID) ([]models.Device, error) {
+	return s.devices, nil
+}
+
+func (s *userDetailStores) GetByID(_ context.Context, id uuid.UUID) (*models.Device, error) {
+	for _, d := range s.devices {
+		if d.ID == id {
+			return &d, nil
+		}
+	}
+	return nil, repository.ErrDeviceNotFound
+}
+
+func (s *userDetailStores) Delete(_ context.Context, id uuid.UUID) error {
+	s.devices = slices.DeleteFunc(s.devices, func(d models.Device) bool { return d.ID == id })
+	return nil
+}
+
+func (s *userDetailStores) GetMetadataByUserID(context.Context, uuid.UUID, string) (*models.VaultMetadata, error) {
+	if s.vault == nil {
+		return nil, repository.ErrVaultNotFound
+	}
+	return s.vault, nil
+}
+
+type userDetailLogs struct{ *userDetailStores }
+
+func (s userDetailLogs) GetByUserID(context.Context, uuid.UUID, int) ([]models.SyncLog, error) {
+	return s.logs, nil
+}
+
+func TestUserDetailPage(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	id, laptop, gone := uuid.New(), uuid.New(), uuid.New()
+	users := memAdminUsers{id: {ID: id, Email: "detail@example.com", IsApproved: true, TOTPEnabled: true}}
+	rev := 8
+	stores := &userDetailStores{
+		devices: []models.Device{{ID: laptop, DeviceName: "Work Laptop", DeviceType: "desktop", LastIP: "198.51.100.4", LastUserAgent: "vibedterm-app/2.0"}},
+		vault:   &models.VaultMetadata{Vault: models.EncryptedVault{Revision: 8, UpdatedByDevice: &laptop}, SizeBytes: 2048},
+		logs: []models.SyncLog{
+			{Action: "push", Vault: models.DefaultVaultName, DeviceID: &laptop, RevisionAfter: &rev},
+			{Action: "pull", Vault: models.DefaultVaultName, DeviceID: &gone},
+		},
+	}
+	a := &AdminWeb{
+		templates: tmpl,
+		userList:  memUserList{},
+		userAdmin: service.NewUserAdmin(users, stores, stores, nopAdminTokens{}, userDetailLogs{stores}, &memAdminAudit{}),
+	}
+
+	get := func(id string) *httptest.ResponseRecorder {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/admin/users/"+id, nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		c.Set("session", &Session{Email: "admin@example.com"})
+		a.userDetailPage(c)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+
+	w := get(id.String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	html := w.Body.String()
+	for _, want := range []string{"detail@example.com", "2FA enabled", "Revision 8", "2.0 KiB", "by Work Laptop", "198.51.100.4", "vibedterm-app/2.0", "push", "pull"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("page does not show %q", want)
+		}
+	}
+
+	stores.vault = nil
+	if html := get(id.String()).Body.String(); !strings.Contains(html, "not synced a vault") {
+		t.Error("page without a vault does not say so")
+	}
+	if w := get(uuid.NewString()); w.Code != http.StatusFound || !strings.Contains(w.Header().Get("Location"), "User+not+found") {
+		t.Errorf("unknown user: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+// userDetailSessions serves and revokes one user's sessions
+type userDetailSessions struct {
+	nopAdminTokens
+	sessions []models.Session
+}
+
+func (s *userDetailSessions) GetActiveByUserID(context.Context, uuid.UUID) ([]models.Session, error) {
+	return s.sessions, nil
+}
+
+func (s *userDetailSessions) RevokeByID(_ context.Context, _, sessionID uuid.UUID) error {
+	n := len(s.sessions)
+	s.sessions = slices.DeleteFunc(s.sessions, func(session models.Session) bool { return session.ID == sessionID })
+	if len(s.sessions) == n {
+		return repository.ErrRefreshTokenNotFound
+	}
+	return nil
+}
+
+func TestUserDetailPage_RevokeSession(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	id, sessionID := uuid.New(), uuid.New()
+	users := memAdminUsers{id: {ID: id, Email: "sessions@example.com", IsApproved: true}}
+	stores := &userDetailStores{}
+	tokens := &userDetailSessions{sessions: []models.Session{{ID: sessionID, DeviceName: "Old Phone", DeviceType: "mobile", ExpiresAt: time.Now().Add(time.Hour)}}}
+	audit := &memAdminAudit{}
+	a := &AdminWeb{
+		templates: tmpl,
+		userList:  memUserList{},
+		userAdmin: service.NewUserAdmin(users, stores, stores, tokens, userDetailLogs{stores}, audit),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("session", &Session{Email: "admin@example.com"}) })
+	r.GET("/admin/users/:id", a.userDetailPage)
+	r.POST("/admin/users/:id/sessions/:sessionId/revoke", a.revokeUserSession)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	page := "/admin/users/" + id.String()
+	revoke := page + "/sessions/" + sessionID.String() + "/revoke"
+	html := serve("GET", page).Body.String()
+	if !strings.Contains(html, "Old Phone") || !strings.Contains(html, revoke) {
+		t.Error("page does not list the session with a revoke button")
+	}
+
+	w := serve("POST", revoke)
+	if loc := w.Header().Get("Location"); loc != page+"?success=Session+revoked" {
+		t.Fatalf("revoke: location = %q", loc)
+	}
+	if len(tokens.sessions) != 0 || len(audit.events) != 1 {
+		t.Errorf("%d sessions left, %d audit events", len(tokens.sessions), len(audit.events))
+	}
+	if !strings.Contains(serve("GET", page).Body.String(), "No active sessions") {
+		t.Error("revoked session still listed")
+	}
+
+	w = serve("POST", revoke)
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=Session+not+found") {
+		t.Errorf("revoking twice: location = %q", loc)
+	}
+}
+
+func TestUserDevicesPage(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	id, laptop := uuid.New(), uuid.New()
+	users := memAdminUsers{id: {ID: id, Email: "devices@example.com", IsApproved: true}}
+	stores := &userDetailStores{
+		devices: []models.Device{{ID: laptop, UserID: id, DeviceName: "Work Laptop", DeviceType: "desktop", IsApproved: true}},
+	}
+	audit := &memAdminAudit{}
+	a := &AdminWeb{
+		templates: tmpl,
+		userList:  memUserList{},
+		userAdmin: service.NewUserAdmin(users, stores, stores, nopAdminTokens{}, userDetailLogs{stores}, audit),
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("session", &Session{Email: "admin@example.com"}) })
+	r.GET("/admin/users/:id/devices", a.userDevicesPage)
+	r.POST("/admin/users/:id/devices/:deviceId/delete", a.deleteUserDevice)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	page := "/admin/users/" + id.String() + "/devices"
+	w := serve("GET", page)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	html := w.Body.String()
+	for _, want := range []string{"devices@example.com", "Work Laptop", page + "/" + laptop.String() + "/delete"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("page does not show %q", want)
+		}
+	}
+
+	w = serve("POST", page+"/"+uuid.NewString()+"/delete")
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "Device+not+found") {
+		t.Errorf("unknown device: location = %q", loc)
+	}
+	w = serve("POST", page+"/"+laptop.String()+"/delete")
+	if loc := w.Header().Get("Location"); loc != page+"?success=Device+deleted" {
+		t.Errorf("delete: location = %q", loc)
+	}
+	if len(stores.devices) != 0 || len(audit.events) != 1 {
+		t.Errorf("%d devices left, %d audit events", len(stores.devices), len(audit.events))
+	}
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// versionedCacheControl applies when the request carries the current
+	// content hash, so the response can never go stale.
+	versionedCacheControl = "public, max-age=31536000, immutable"
+	// plainCacheControl applies to unversioned requests like the browser's
+	// implicit /favicon.ico.
+	plainCacheControl  = "public, max-age=86400"
+	robotsCacheControl = "public, max-age=3600"
+)
+
+// siteAsset is a file served at a fixed root path
+type siteAsset struct {
+	path        string
+	contentType string
+	body        []byte
+	version     string
+}
+
+// iconFiles maps root paths to embedded icons
+var iconFiles = []struct {
+	path, file, contentType string
+}{
+	{"/favicon.ico", "static/icons/favicon.ico", "image/x-icon"},
+	{"/apple-touch-icon.png", "static/icons/apple-touch-icon.png", "image/png"},
+	{"/icon-192.png", "static/icons/icon-192.png", "image/png"},
+	{"/icon-512.png", "static/icons/icon-512.png", "image/png"},
+}
+
+// siteAssets holds the icons and web manifest, keyed by path
+var siteAssets = loadSiteAssets()
+
+func loadSiteAssets() map[string]*siteAsset {
+	assets := make(map[string]*siteAsset)
+	for _, f := range iconFiles {
+		body, err := fs.ReadFile(staticFS, f.file)
+		if err != nil {
+			panic("web: missing embedded icon " + f.file)
+		}
+		assets[f.path] = newSiteAsset(f.path, f.contentType, body)
+	}
+
+	// The manifest references versioned icons, so it is built last and
+	// its own version changes whenever an icon does.
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"name":             "VibedTerm",
+		"short_name":       "VibedTerm",
+		"start_url":        "/account/login",
+		"display":          "standalone",
+		"background_color": "#1a1a2e",
+		"theme_color":      "#1a1a2e",
+		"icons": []map[string]string{
+			{"src": versionedURL(assets["/icon-192.png"]), "sizes": "192x192", "type": "image/png"},
+			{"src": versionedURL(assets["/icon-512.png"]), "sizes": "512x512", "type": "image/png"},
+		},
+	})
+	assets["/site.webmanifest"] = newSiteAsset("/site.webmanifest", "application/manifest+json", manifest)
+
+	return assets
+}
+
+func newSiteAsset(path, contentType string, body []byte) *siteAsset {
+	sum := sha256.Sum256(body)
+	return &siteAsset{
+		path:        path,
+		contentType: contentType,
+		body:        body,
+		version:     hex.EncodeToString(sum[:])[:12],
+	}
+}
+
+func versionedURL(a *siteAsset) string {
+	return a.path + "?v=" + a.version
+}
+
+// AssetURL returns the cache-busting URL for a site asset. Unknown paths
+// are returned unchanged.
+func AssetURL(path string) string {
+	if a, ok := siteAssets[path]; ok {
+		return versionedURL(a)
+	}
+	return path
+}
+
+// serve writes the asset with cache headers depending on whether the
+// request asked for the current version
+func (a *siteAsset) serve(c *gin.Context) {
+	etag := `"` + a.version + `"`
+	if c.Query("v") == a.version {
+		c.Header("Cache-Control", versionedCacheControl)
+	} else {
+		c.Header("Cache-Control", plainCacheControl)
+	}
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, a.contentType, a.body)
+}
+
+// SiteAssets serves the favicon, touch icons, web manifest and robots.txt
+type SiteAssets struct {
+	robots []byte
+}
+
+// NewSiteAssets creates the site asset handler. robotsDisallow lists the
+// path prefixes crawlers are asked to skip.
+func NewSiteAssets(robotsDisallow []string) *SiteAssets {
+	return &SiteAssets{robots: buildRobots(robotsDisallow)}
+}
+
+func buildRobots(disallow []string) []byte {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if len(disallow) == 0 {
+		b.WriteString("Disallow:\n")
+	}
+	for _, prefix := range disallow {
+		b.WriteString("Disallow: " + prefix + "\n")
+	}
+	return []byte(b.String())
+}
+
+// RegisterRoutes registers the site asset routes
+func (s *SiteAssets) RegisterRoutes(r *gin.Engine) {
+	for _, a := range siteAssets {
+		r.GET(a.path, a.serve)
+		r.HEAD(a.path, a.serve)
+	}
+	r.GET("/robots.txt", s.robotsTxt)
+	r.HEAD("/robots.txt", s.robotsTxt)
+}
+
+func (s *SiteAssets) robotsTxt(c *gin.Context) {
+	c.Header("Cache-Control", robotsCacheControl)
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", s.robots)
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newAssetsRouter(disallow []string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewSiteAssets(disallow).RegisterRoutes(r)
+	return r
+}
+
+func getAsset(r *gin.Engine, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestSiteAssetsContentTypes(t *testing.T) {
+	r := newAssetsRouter(nil)
+
+	tests := []struct {
+		path, contentType string
+	}{
+		{"/favicon.ico", "image/x-icon"},
+		{"/apple-touch-icon.png", "image/png"},
+		{"/icon-192.png", "image/png"},
+		{"/icon-512.png", "image/png"},
+		{"/site.webmanifest", "application/manifest+json"},
+		{"/robots.txt", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := getAsset(r, tt.path)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", tt.path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.path, got, tt.contentType)
+		}
+		if w.Body.Len() == 0 {
+			t.Errorf("%s: empty body", tt.path)
+		}
+	}
+}
+
+func TestSiteAssetsCacheHeaders(t *testing.T) {
+	r := newAssetsRouter(nil)
+
+	w := getAsset(r, AssetURL("/favicon.ico"))
+	if got := w.Header().Get("Cache-Control"); got != versionedCacheControl {
+		t.Errorf("versioned request: Cache-Control %q", got)
+	}
+
+	w = getAsset(r, "/favicon.ico")
+	if got := w.Header().Get("Cache-Control"); got != plainCacheControl {
+		t.Errorf("plain request: Cache-Control %q", got)
+	}
+
+	// A stale version must not be cached forever
+	w = getAsset(r, "/favicon.ico?v=stale")
+	if got := w.Header().Get("Cache-Control"); got != plainCacheControl {
+		t.Errorf("stale version: Cache-Control %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/favicon.ico", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional request: status %d, want 304", w.Code)
+	}
+}
+
+func TestSiteAssetsManifestReferencesVersionedIcons(t *testing.T) {
+	w := getAsset(newAssetsRouter(nil), "/site.webmanifest")
+
+	var manifest struct {
+		Icons []struct {
+			Src string `json:"src"`
+		} `json:"icons"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("manifest is not JSON: %v", err)
+	}
+	if len(manifest.Icons) == 0 {
+		t.Fatal("manifest has no icons")
+	}
+	for _, icon := range manifest.Icons {
+		if !strings.Contains(icon.Src, "?v=") {
+			t.Errorf("icon %q is not versioned", icon.Src)
+		}
+	}
+}
+
+func TestRobotsPolicy(t *testing.T) {
+	w := getAsset(newAssetsRouter([]string{"/admin", "/account"}), "/robots.txt")
+	want := "User-agent: *\nDisallow: /admin\nDisallow: /account\n"
+	if w.Body.String() != want {
+		t.Errorf("default robots.txt = %q, want %q", w.Body.String(), want)
+	}
+
+	w = getAsset(newAssetsRouter(nil), "/robots.txt")
+	if want := "User-agent: *\nDisallow:\n"; w.Body.String() != want {
+		t.Errorf("allow-all robots.txt = %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestTemplatesReferenceIcons(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Render(&buf, "login.html", loginPageData{Title: "Login"}); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	for _, path := range []string{"/favicon.ico", "/apple-touch-icon.png", "/site.webmanifest"} {
+		if !strings.Contains(buf.String(), AssetURL(path)) {
+			t.Errorf("login page does not reference %s", AssetURL(path))
+		}
+	}
+}
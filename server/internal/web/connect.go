@@ -0,0 +1,101 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+// connectQRSize is the edge length of the connect QR code in pixels
+const connectQRSize = 256
+
+// connectStatus summarizes the devices of a user for the connect page
+type connectStatus struct {
+	Devices    int
+	LastSyncAt *time.Time // latest sync of any device, nil if none synced
+}
+
+func newConnectStatus(devices []models.Device) connectStatus {
+	status := connectStatus{Devices: len(devices)}
+	for _, d := range devices {
+		if d.LastSyncAt != nil && (status.LastSyncAt == nil || d.LastSyncAt.After(*status.LastSyncAt)) {
+			status.LastSyncAt = d.LastSyncAt
+		}
+	}
+	return status
+}
+
+// connectQR renders a deep link as a PNG data URI
+func connectQR(link models.ConnectLink) (template.URL, error) {
+	return qrDataURI(link.URI())
+}
+
+// qrDataURI renders content as a QR code PNG data URI. It also renders
+// TOTP setup codes.
+func qrDataURI(content string) (template.URL, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return "", err
+	}
+	code, err = barcode.Scale(code, connectQRSize, connectQRSize)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code); err != nil {
+		return "", err
+	}
+	// The data URI is built here from our own PNG, so it is safe in src
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// connectPageData is the view model of user_connect.html
+type connectPageData struct {
+	userLayout
+	Title     string
+	Email     string
+	ServerURL string
+	Link      template.URL // custom scheme, built here
+	QRCode    template.URL
+	Status    connectStatus
+}
+
+// connectPage shows how to connect an app to this server
+func (u *UserWeb) connectPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	devices, err := u.deviceRepo.GetByUserID(c.Request.Context(), session.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list user devices")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	link := models.ConnectLink{Server: u.publicURL, Email: session.Email}
+	qrImage, err := connectQR(link)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to render connect QR code")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	data := connectPageData{
+		Title:     "Connect",
+		Email:     session.Email,
+		ServerURL: u.publicURL,
+		Link:      template.URL(link.URI()),
+		QRCode:    qrImage,
+		Status:    newConnectStatus(devices),
+	}
+	u.render(c, "user_connect.html", &data)
+}
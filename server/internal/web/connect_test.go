@@ -0,0 +1,106 @@
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+func TestConnectLinkRoundTrip(t *testing.T) {
+	link := models.ConnectLink{Server: "https://vibed.example.com:8443/base", Email: "a+b@example.com"}
+	uri := link.URI()
+	if !strings.HasPrefix(uri, "vibedterm://connect?") {
+		t.Fatalf("uri = %q", uri)
+	}
+	parsed, err := models.ParseConnectLink(uri)
+	if err != nil || parsed != link {
+		t.Errorf("parsed = %+v, %v; want %+v", parsed, err, link)
+	}
+
+	for _, bad := range []string{"https://connect?v=1&server=x", "vibedterm://connect?v=2&server=x", "vibedterm://connect?v=1"} {
+		if _, err := models.ParseConnectLink(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestConnectQREncodesLink(t *testing.T) {
+	link := models.ConnectLink{Server: "https://vibed.example.com", Email: "user@example.com"}
+	dataURI, err := connectQR(link)
+	if err != nil {
+		t.Fatalf("connectQR: %v", err)
+	}
+
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(string(dataURI), prefix) {
+		t.Fatalf("not a PNG data URI: %.40s", dataURI)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(string(dataURI), prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("decode PNG: %v", err)
+	}
+
+	// The image holds exactly the modules of the serialized link
+	want, _ := qr.Encode(link.URI(), qr.M, qr.Auto)
+	want, _ = barcode.Scale(want, connectQRSize, connectQRSize)
+	if img.Bounds() != want.Bounds() {
+		t.Fatalf("bounds = %v, want %v", img.Bounds(), want.Bounds())
+	}
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			gr, _, _, _ := img.At(x, y).RGBA()
+			wr, _, _, _ := want.At(x, y).RGBA()
+			if gr != wr {
+				t.Fatalf("pixel (%d,%d) differs from the encoded link", x, y)
+			}
+		}
+	}
+}
+
+func TestConnectStatus(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	now := time.Now()
+	older, latest := now.Add(-2*time.Hour), now.Add(-3*time.Minute)
+	devices := []models.Device{
+		{DeviceName: "Laptop", LastSyncAt: &older},
+		{DeviceName: "Phone", LastSyncAt: &latest},
+		{DeviceName: "Tablet"},
+	}
+
+	status := newConnectStatus(devices)
+	if status.Devices != 3 || status.LastSyncAt == nil || !status.LastSyncAt.Equal(latest) {
+		t.Fatalf("status = %+v, want 3 devices synced at %v", status, latest)
+	}
+	if empty := newConnectStatus(nil); empty.Devices != 0 || empty.LastSyncAt != nil {
+		t.Errorf("status without devices = %+v", empty)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Render(&buf, "user_connect.html", connectPageData{
+		Email:  "user@example.com",
+		Link:   template.URL(models.ConnectLink{Server: "https://vibed.example.com"}.URI()),
+		Status: status,
+	})
+	if err != nil {
+		t.Fatalf("render connect: %v", err)
+	}
+	if html := buf.String(); !strings.Contains(html, "3 devices connected") || !strings.Contains(html, "last sync 3 minutes ago") {
+		t.Errorf("connect page does not show the status:\n%s", html)
+	}
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// legacyCookiePaths are paths a session cookie may have been left on by
+// earlier versions. They are cleared next to the canonical path so a
+// stale duplicate cannot shadow the live cookie.
+var legacyCookiePaths = []string{"/"}
+
+// sessionCookie is where one web surface keeps its session cookie. All
+// setting, reading and clearing goes through it so the path never drifts.
+type sessionCookie struct {
+	name     string
+	path     string
+	lifetime time.Duration
+}
+
+var (
+	adminSessionCookie = sessionCookie{name: sessionCookieName, path: "/admin", lifetime: sessionDuration}
+	userSessionCookie  = sessionCookie{name: userSessionCookieName, path: "/account", lifetime: userSessionDuration}
+)
+
+// set stores sessionID on the canonical path and drops legacy copies
+func (sc sessionCookie) set(c *gin.Context, sessionID string) {
+	sc.clearLegacy(c)
+	setCookie(c, sc.name, sessionID, int(sc.lifetime.Seconds()), sc.path)
+}
+
+// clear removes the cookie from the canonical and all legacy paths
+func (sc sessionCookie) clear(c *gin.Context) {
+	setCookie(c, sc.name, "", -1, sc.path)
+	sc.clearLegacy(c)
+}
+
+func (sc sessionCookie) clearLegacy(c *gin.Context) {
+	for _, path := range legacyCookiePaths {
+		if path != sc.path {
+			setCookie(c, sc.name, "", -1, path)
+		}
+	}
+}
+
+// values returns every non-empty value sent under the cookie name. A
+// browser sends one per path when stale duplicates exist.
+func (sc sessionCookie) values(c *gin.Context) []string {
+	var values []string
+	for _, cookie := range c.Request.Cookies() {
+		if cookie.Name == sc.name && cookie.Value != "" {
+			values = append(values, cookie.Value)
+		}
+	}
+	return values
+}
+
+// resolve returns the first session any of the sent cookies refers to,
+// or nil if none of them is live
+func (sc sessionCookie) resolve(c *gin.Context, store *SessionStore) *Session {
+	for _, id := range sc.values(c) {
+		if session := store.Get(id); session != nil {
+			return session
+		}
+	}
+	return nil
+}
+
+// resolveOrClear is resolve for pages that must not bounce: cookies that
+// resolve to nothing are cleared before the caller responds
+func (sc sessionCookie) resolveOrClear(c *gin.Context, store *SessionStore) *Session {
+	session := sc.resolve(c, store)
+	if session == nil && len(sc.values(c)) > 0 {
+		sc.clear(c)
+	}
+	return session
+}
+
+// destroy deletes every session the sent cookies refer to and clears them
+func (sc sessionCookie) destroy(c *gin.Context, store *SessionStore) {
+	for _, id := range sc.values(c) {
+		store.Delete(id)
+	}
+	sc.clear(c)
+}
@@ -0,0 +1,167 @@
+package web
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// newCookieTestServer serves the user login page and a protected page
+// behind the real session middleware over TLS, since session cookies are
+// Secure and a cookie jar would not send them over plain HTTP
+func newCookieTestServer(t *testing.T) (*UserWeb, *httptest.Server, *http.Client) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	templates, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	u := &UserWeb{
+		templates: templates,
+		sessions:  &SessionStore{sessions: make(map[string]*Session), duration: time.Hour},
+	}
+
+	r := gin.New()
+	r.GET("/account/login", u.loginPage)
+	r.GET("/account/login/totp", u.totpPage)
+	protected := r.Group("/account", u.authMiddleware())
+	protected.GET("/settings", func(c *gin.Context) { c.String(http.StatusOK, "settings") })
+	protected.POST("/logout", u.logout)
+	// Stands in for a successful password login
+	r.GET("/account/test-login", func(c *gin.Context) {
+		session, _ := u.sessions.Create(uuid.New(), "jar@example.com", false, false)
+		userSessionCookie.set(c, session.ID)
+		c.Redirect(http.StatusFound, "/account/settings")
+	})
+
+	srv := httptest.NewTLSServer(r)
+	t.Cleanup(srv.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar: %v", err)
+	}
+	client := srv.Client()
+	client.Jar = jar
+	return u, srv, client
+}
+
+// plant stores a session cookie in the jar as if an older version set it
+func plant(t *testing.T, client *http.Client, srv *httptest.Server, path, value string) {
+	t.Helper()
+	base, _ := url.Parse(srv.URL)
+	client.Jar.SetCookies(base, []*http.Cookie{{
+		Name: userSessionCookieName, Value: value, Path: path, Secure: true, HttpOnly: true,
+	}})
+}
+
+// sessionCookies returns the session cookies the jar would send to path
+func sessionCookies(client *http.Client, srv *httptest.Server, path string) []string {
+	target, _ := url.Parse(srv.URL + path)
+	var values []string
+	for _, c := range client.Jar.Cookies(target) {
+		if c.Name == userSessionCookieName {
+			values = append(values, c.Value)
+		}
+	}
+	return values
+}
+
+func get(t *testing.T, client *http.Client, srv *httptest.Server, path string) *http.Response {
+	t.Helper()
+	resp, err := client.Get(srv.URL + path)
+	if err != nil {
+		// The client gives up after 10 redirects
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestSessionCookie_StaleDuplicatesAreCleared(t *testing.T) {
+	_, srv, client := newCookieTestServer(t)
+	plant(t, client, srv, "/", "stale-root")
+	plant(t, client, srv, "/account", "stale-account")
+
+	resp := get(t, client, srv, "/account/settings")
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/account/login" {
+		t.Fatalf("ended at %s with %d, want the login page", resp.Request.URL.Path, resp.StatusCode)
+	}
+	if left := sessionCookies(client, srv, "/account/settings"); len(left) != 0 {
+		t.Errorf("stale cookies left in the jar: %v", left)
+	}
+}
+
+func TestSessionCookie_LoginPageClearsUnresolvableCookie(t *testing.T) {
+	_, srv, client := newCookieTestServer(t)
+	plant(t, client, srv, "/", "stale-root")
+
+	resp := get(t, client, srv, "/account/login")
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/account/login" {
+		t.Fatalf("ended at %s with %d, want the login page", resp.Request.URL.Path, resp.StatusCode)
+	}
+	if left := sessionCookies(client, srv, "/account/login"); len(left) != 0 {
+		t.Errorf("login page rendered with stale cookies kept: %v", left)
+	}
+}
+
+func TestSessionCookie_LiveSessionBehindStaleDuplicate(t *testing.T) {
+	u, srv, client := newCookieTestServer(t)
+	session, _ := u.sessions.Create(uuid.New(), "live@example.com", false, false)
+	// The live cookie must win over a stale duplicate on another path
+	plant(t, client, srv, "/", "stale-root")
+	plant(t, client, srv, "/account", session.ID)
+
+	resp := get(t, client, srv, "/account/login")
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/account/settings" {
+		t.Fatalf("ended at %s with %d, want settings", resp.Request.URL.Path, resp.StatusCode)
+	}
+}
+
+func TestSessionCookie_LoginLeavesSingleCookie(t *testing.T) {
+	_, srv, client := newCookieTestServer(t)
+	plant(t, client, srv, "/", "stale-root")
+
+	resp := get(t, client, srv, "/account/test-login")
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/account/settings" {
+		t.Fatalf("ended at %s with %d, want settings", resp.Request.URL.Path, resp.StatusCode)
+	}
+	if values := sessionCookies(client, srv, "/account/settings"); len(values) != 1 || values[0] == "stale-root" {
+		t.Errorf("cookies after login = %v, want only the new session", values)
+	}
+
+	// Logging out clears every copy and the next visit lands on login
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/account/logout", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	resp.Body.Close()
+	if left := sessionCookies(client, srv, "/account/settings"); len(left) != 0 {
+		t.Errorf("cookies after logout = %v", left)
+	}
+	if resp := get(t, client, srv, "/account/settings"); resp.Request.URL.Path != "/account/login" {
+		t.Errorf("settings after logout ended at %s", resp.Request.URL.Path)
+	}
+}
+
+func TestSessionCookie_PendingTOTPWithStaleCookie(t *testing.T) {
+	u, srv, client := newCookieTestServer(t)
+	session, _ := u.sessions.Create(uuid.New(), "totp@example.com", false, true)
+	plant(t, client, srv, "/account", "stale-account")
+	plant(t, client, srv, "/", session.ID)
+
+	// The stale specific cookie is sent first; the pending session behind
+	// it must still be found instead of bouncing back to login
+	resp := get(t, client, srv, "/account/settings")
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/account/login/totp" {
+		t.Fatalf("ended at %s with %d, want the TOTP page", resp.Request.URL.Path, resp.StatusCode)
+	}
+}
@@ -0,0 +1,79 @@
+package web
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// csrfFormField carries the token in form posts
+	csrfFormField = "csrf_token"
+	// csrfHeader carries the token in the JSON posts of webauthn.js
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfField renders the hidden input every POST form of a session needs
+func csrfField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` +
+		template.HTMLEscapeString(token) + `">`)
+}
+
+// csrfErrorPageData is the view model of csrf_error.html
+type csrfErrorPageData struct {
+	Title      string
+	Stylesheet string
+	BackURL    string
+}
+
+// csrfProtect rejects unsafe requests of a session that do not carry its
+// CSRF token, with a page pointing back to homeURL. It runs after the
+// auth middleware, or on its own on the pages of a pending login.
+// Requests without a session have nothing to forge and pass through.
+func csrfProtect(templates *Templates, sc sessionCookie, store *SessionStore, homeURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		var session *Session
+		if s, ok := c.Get("session"); ok {
+			session = s.(*Session)
+		} else if session = sc.resolve(c, store); session == nil {
+			c.Next()
+			return
+		}
+
+		token := c.GetHeader(csrfHeader)
+		if token == "" {
+			token = c.PostForm(csrfFormField)
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1 {
+			c.Next()
+			return
+		}
+
+		log.Warn().Str("path", c.Request.URL.Path).Str("user_id", session.UserID.String()).Msg("Rejected post without a valid CSRF token")
+		c.Abort()
+		if strings.HasPrefix(c.ContentType(), "application/json") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The page has expired. Reload it and try again."})
+			return
+		}
+		data := csrfErrorPageData{
+			Title:      "Page Expired",
+			Stylesheet: sc.path + "/static/css/admin.css",
+			BackURL:    homeURL,
+		}
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusForbidden)
+		if err := templates.Render(c.Writer, "csrf_error.html", data); err != nil {
+			log.Error().Err(err).Msg("Failed to render CSRF error template")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestCSRFProtect_UserRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	templates, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	u := &UserWeb{
+		templates: templates,
+		sessions:  &SessionStore{sessions: make(map[string]*Session), duration: time.Hour},
+	}
+	r := gin.New()
+	u.RegisterRoutes(r, func(*gin.Context) {})
+
+	post := func(path string, session *Session, form url.Values, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if session != nil {
+			req.AddCookie(&http.Cookie{Name: userSessionCookieName, Value: session.ID})
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	newSession := func(pending bool) *Session {
+		session, err := u.sessions.Create(uuid.New(), "web@example.com", false, pending)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return session
+	}
+
+	session := newSession(false)
+	for name, form := range map[string]url.Values{
+		"no token":    nil,
+		"wrong token": {csrfFormField: {"not-the-token"}},
+	} {
+		w := post("/account/logout", session, form, nil)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "Page Expired") {
+			t.Errorf("%s: status = %d, body = %s", name, w.Code, w.Body)
+		}
+		if u.sessions.Get(session.ID) == nil {
+			t.Fatalf("%s: rejected post logged the session out", name)
+		}
+	}
+
+	// Another session's token is no good either
+	other := newSession(false)
+	if w := post("/account/logout", session, url.Values{csrfFormField: {other.CSRFToken}}, nil); w.Code != http.StatusForbidden {
+		t.Errorf("token of another session: status = %d", w.Code)
+	}
+
+	w := post("/account/logout", session, url.Values{csrfFormField: {session.CSRFToken}}, nil)
+	if w.Code != http.StatusFound || u.sessions.Get(session.ID) != nil {
+		t.Errorf("post with token: status = %d, session still live = %v", w.Code, u.sessions.Get(session.ID) != nil)
+	}
+
+	// webauthn.js sends the token in a header and reads JSON errors
+	pending := newSession(true)
+	w = post("/account/login/passkey/begin", pending, nil, http.Header{"Content-Type": {"application/json"}})
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Errorf("JSON post without token: status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// A pending login posts the token of its session too
+	if w := post("/account/login/totp", pending, url.Values{"code": {"123456"}}, nil); w.Code != http.StatusForbidden {
+		t.Errorf("TOTP post without token: status = %d", w.Code)
+	}
+
+	// Without a session there is nothing to forge; the handler decides
+	w = post("/account/logout", nil, nil, nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/account/login" {
+		t.Errorf("post without session: status = %d, location = %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestCSRFField_EscapesToken(t *testing.T) {
+	got := string(csrfField(`a"b`))
+	want := `<input type="hidden" name="csrf_token" value="a&#34;b">`
+	if got != want {
+		t.Errorf("csrfField = %s, want %s", got, want)
+	}
+}
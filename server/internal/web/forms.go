@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sprobst76/vibedterm-server/internal/input"
+)
+
+// formValues reads the given form fields and cleans them according to
+// their input kind. Absent fields yield empty strings.
+func formValues(c *gin.Context, kinds map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := make(map[string]string, len(kinds))
+	var errs input.Errors
+	for _, name := range names {
+		value, err := input.Clean(name, kinds[name], c.PostForm(name))
+		if err != nil {
+			errs = append(errs, *err.(*input.FieldError))
+			continue
+		}
+		values[name] = value
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return values, nil
+}
+
+// formError formats a formValues error for an error redirect
+func formError(err error) string {
+	return url.QueryEscape(err.Error())
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+)
+
+// passkeyCeremonies is the subset of service.WebAuthn used by UserWeb
+type passkeyCeremonies interface {
+	Enabled(ctx context.Context, userID uuid.UUID) (bool, error)
+	List(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	BeginRegistration(ctx context.Context, user *models.User) (*protocol.CredentialCreation, uuid.UUID, error)
+	FinishRegistration(ctx context.Context, user *models.User, challengeID uuid.UUID, name string, response []byte) (*models.WebAuthnCredential, error)
+	BeginLogin(ctx context.Context, user *models.User) (*protocol.CredentialAssertion, uuid.UUID, error)
+	FinishLogin(ctx context.Context, user *models.User, challengeID uuid.UUID, response []byte) (*models.WebAuthnCredential, error)
+}
+
+// passkeyFinishRequest is the JSON body the passkey script posts after
+// the browser ceremony
+type passkeyFinishRequest struct {
+	ChallengeID string          `json:"challenge_id" binding:"required" input:"token"`
+	Name        string          `json:"name" input:"name"`
+	Credential  json.RawMessage `json:"credential" binding:"required"`
+}
+
+// beginPasskeyLogin starts the passkey step of a web login
+func (u *UserWeb) beginPasskeyLogin(c *gin.Context) {
+	session := userSessionCookie.resolveOrClear(c, u.sessions)
+	if session == nil || !session.TOTPPending {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+		return
+	}
+
+	user, err := u.userRepo.GetByID(c.Request.Context(), session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+		return
+	}
+
+	assertion, challengeID, err := u.passkeys.BeginLogin(c.Request.Context(), user)
+	if err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, models.WebAuthnBeginResponse{ChallengeID: challengeID, Options: assertion})
+}
+
+// finishPasskeyLogin verifies the passkey and completes the web login
+func (u *UserWeb) finishPasskeyLogin(c *gin.Context) {
+	session := userSessionCookie.resolveOrClear(c, u.sessions)
+	if session == nil || !session.TOTPPending {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+		return
+	}
+
+	req, challengeID, ok := bindPasskeyFinish(c)
+	if !ok {
+		return
+	}
+
+	user, err := u.userRepo.GetByID(c.Request.Context(), session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+		return
+	}
+
+	if _, err := u.passkeys.FinishLogin(c.Request.Context(), user, challengeID, req.Credential); err != nil {
+		if errors.Is(err, service.ErrWebAuthnVerification) {
+			recordLoginFailed(c, u.events, events.SurfaceWeb, user, user.Email, events.ReasonInvalidWebAuthn)
+		}
+		respondPasskeyError(c, err)
+		return
+	}
+
+	u.sessions.UpgradeFromTOTP(session.ID)
+	recordLoginSucceeded(c, u.events, events.SurfaceWeb, user)
+	c.JSON(http.StatusOK, gin.H{"redirect": "/account/settings"})
+}
+
+// beginPasskeyRegistration starts registering a passkey from the settings page
+func (u *UserWeb) beginPasskeyRegistration(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	user, err := u.userRepo.GetByID(c.Request.Context(), session.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	creation, challengeID, err := u.passkeys.BeginRegistration(c.Request.Context(), user)
+	if err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, models.WebAuthnBeginResponse{ChallengeID: challengeID, Options: creation})
+}
+
+// finishPasskeyRegistration stores the passkey created by the browser
+func (u *UserWeb) finishPasskeyRegistration(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	req, challengeID, ok := bindPasskeyFinish(c)
+	if !ok {
+		return
+	}
+
+	user, err := u.userRepo.GetByID(c.Request.Context(), session.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	cred, err := u.passkeys.FinishRegistration(c.Request.Context(), user, challengeID, req.Name, req.Credential)
+	if errors.Is(err, repository.ErrWebAuthnCredentialExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		return
+	}
+	if err != nil {
+		respondPasskeyError(c, err)
+		return
+	}
+
+	log.Info().Str("credential_id", cred.ID.String()).Str("email", session.Email).Msg("Passkey registered via web interface")
+	c.JSON(http.StatusOK, gin.H{"redirect": "/account/settings?success=Passkey+added"})
+}
+
+// deletePasskey removes a passkey from the settings page
+func (u *UserWeb) deletePasskey(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/settings?error=Invalid+passkey+ID")
+		return
+	}
+
+	err = u.passkeys.Delete(c.Request.Context(), session.UserID, id)
+	if errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+		c.Redirect(http.StatusFound, "/account/settings?error=Passkey+not+found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to delete passkey")
+		c.Redirect(http.StatusFound, "/account/settings?error=Failed+to+remove+passkey")
+		return
+	}
+
+	log.Info().Str("credential_id", id.String()).Str("email", session.Email).Msg("Passkey removed via web interface")
+	c.Redirect(http.StatusFound, "/account/settings?success=Passkey+removed")
+}
+
+// bindPasskeyFinish reads the finish request of a ceremony or responds
+// with an error
+func bindPasskeyFinish(c *gin.Context) (*passkeyFinishRequest, uuid.UUID, bool) {
+	var req passkeyFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var fields input.Errors
+		if errors.As(err, &fields) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fields.Error()})
+			return nil, uuid.Nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return nil, uuid.Nil, false
+	}
+	challengeID, err := uuid.Parse(req.ChallengeID)
+	if err != nil {
+		respondPasskeyError(c, service.ErrWebAuthnChallengeInvalid)
+		return nil, uuid.Nil, false
+	}
+	return &req, challengeID, true
+}
+
+// respondPasskeyError answers the passkey script with a message to show
+func respondPasskeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrWebAuthnNotEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No passkey registered"})
+	case errors.Is(err, service.ErrWebAuthnChallengeInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request expired, please try again"})
+	case errors.Is(err, service.ErrWebAuthnVerification):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey could not be verified"})
+	default:
+		log.Error().Err(err).Msg("Passkey ceremony failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+	}
+}
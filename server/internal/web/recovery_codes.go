@@ -0,0 +1,107 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	passwords "github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/recoverycode"
+)
+
+// lowRecoveryCodes is the number of unused recovery codes below which
+// the settings page asks the user to generate new ones
+const lowRecoveryCodes = 3
+
+// recoverySettingsPageData is the view model of user_recovery_settings.html
+type recoverySettingsPageData struct {
+	userLayout
+	Title     string
+	Email     string
+	Remaining int
+	Low       bool
+	Error     string
+}
+
+// recoveryCodesPage shows how many recovery codes are left and offers to
+// replace them
+func (u *UserWeb) recoveryCodesPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	user, err := u.userRepo.GetByID(c.Request.Context(), session.UserID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/settings")
+		return
+	}
+	if !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/settings/totp/setup")
+		return
+	}
+
+	remaining, err := u.recoveryRepo.CountUnused(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count recovery codes")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	data := recoverySettingsPageData{
+		Title:     "Recovery Codes",
+		Email:     user.Email,
+		Remaining: remaining,
+		Low:       remaining < lowRecoveryCodes,
+		Error:     c.Query("error"),
+	}
+	u.render(c, "user_recovery_settings.html", &data)
+}
+
+// regenerateRecoveryCodes replaces all recovery codes after the password
+// and a TOTP code are confirmed, and shows the new ones once
+func (u *UserWeb) regenerateRecoveryCodes(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	ctx := c.Request.Context()
+
+	password := c.PostForm("password")
+	code := c.PostForm("code")
+	if password == "" || code == "" {
+		c.Redirect(http.StatusFound, "/account/settings/recovery-codes?error=Password+and+code+required")
+		return
+	}
+
+	user, err := u.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/settings/recovery-codes?error=Internal+error")
+		return
+	}
+	if !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/settings/totp/setup")
+		return
+	}
+
+	if err := passwords.Compare(ctx, user.PasswordHash, password); err != nil {
+		if errors.Is(err, passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/account/settings/recovery-codes?error="+busyMessage)
+			return
+		}
+		c.Redirect(http.StatusFound, "/account/settings/recovery-codes?error=Invalid+password")
+		return
+	}
+	if err := u.totpGuard.Check(ctx, user, code, events.SurfaceWeb); err != nil {
+		c.Redirect(http.StatusFound, "/account/settings/recovery-codes?error="+totpFormError(err))
+		return
+	}
+
+	codes, err := recoverycode.Issue(ctx, u.recoveryRepo, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to regenerate recovery codes")
+		c.Redirect(http.StatusFound, "/account/settings/recovery-codes?error=Failed+to+generate+recovery+codes")
+		return
+	}
+	u.events.Record(ctx, events.Entry{SubjectID: &user.ID, Payload: events.RecoveryCodesReset{Surface: events.SurfaceWeb}})
+	log.Info().Str("email", user.Email).Msg("User regenerated recovery codes via web interface")
+
+	u.renderRecoveryCodes(c, user.Email, "New recovery codes generated. Your old codes no longer work.", codes)
+}
@@ -0,0 +1,88 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	passwords "github.com/sprobst76/vibedterm-server/internal/password"
+	"github.com/sprobst76/vibedterm-server/internal/recoverycode"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
+)
+
+func TestRecoveryCodes_Regenerate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := passwords.Hash(context.Background(), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret, err := totpsecret.Generate("VibedTerm", "web@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "web@example.com", PasswordHash: hash, TOTPEnabled: true, TOTPSecret: secret}
+	codes := &memWebRecoveryCodes{hashes: map[uuid.UUID][]string{user.ID: {"a", "b"}}}
+	u := &UserWeb{
+		templates:    tmpl,
+		userRepo:     memWebUsers{user.ID: user},
+		recoveryRepo: codes,
+		sessions:     &SessionStore{sessions: make(map[string]*Session), duration: time.Hour},
+		totpGuard:    service.NewTOTPGuard(&memAdminAudit{}, 5, 5*time.Minute),
+	}
+	session, err := u.sessions.Create(user.ID, user.Email, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(handler gin.HandlerFunc, method string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/account/settings/recovery-codes", strings.NewReader(form.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c.Set("session", session)
+		handler(c)
+		return w
+	}
+
+	w := call(u.recoveryCodesPage, http.MethodGet, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Only 2 recovery codes left") {
+		t.Fatalf("page = %d, want a low code warning", w.Code)
+	}
+
+	code, err := totp.GenerateCode(totpsecret.Encode(secret), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = call(u.regenerateRecoveryCodes, http.MethodPost, url.Values{"password": {"wrong"}, "code": {code}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=Invalid+password") || len(codes.hashes[user.ID]) != 2 {
+		t.Fatalf("wrong password redirected to %q", loc)
+	}
+
+	w = call(u.regenerateRecoveryCodes, http.MethodPost, url.Values{"password": {"correct horse"}, "code": {code}})
+	stored := codes.hashes[user.ID]
+	if w.Code != http.StatusOK || len(stored) != recoverycode.Count || stored[0] == "a" {
+		t.Fatalf("regenerate = %d, stored = %v", w.Code, stored)
+	}
+	if !strings.Contains(w.Body.String(), "Your old codes no longer work") || w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("new codes page lacks the notice or is cacheable")
+	}
+
+	// The TOTP code was used up by the regeneration
+	w = call(u.regenerateRecoveryCodes, http.MethodPost, url.Values{"password": {"correct horse"}, "code": {code}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=") {
+		t.Errorf("replayed code redirected to %q", loc)
+	}
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contentSecurityPolicy allows only the embedded assets. The QR codes of
+// the connect and TOTP pages are data URIs; scripts and styles must come
+// from files, never inline.
+const contentSecurityPolicy = "default-src 'self'; img-src 'self' data:; object-src 'none'; " +
+	"base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// hstsMaxAge is sent with Strict-Transport-Security: one year
+const hstsMaxAge = "max-age=31536000"
+
+// securityHeaders sets the browser protections of the web pages. HSTS is
+// only sent over HTTPS, directly or through a TLS-terminating proxy.
+func securityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "same-origin")
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			h.Set("Strict-Transport-Security", hstsMaxAge)
+		}
+		c.Next()
+	}
+}
+
+// CookiePolicy holds the attributes of every cookie the web interface
+// sets. Cookies are always HttpOnly.
+type CookiePolicy struct {
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// cookiePolicy is set once at startup by SetCookiePolicy
+var cookiePolicy = CookiePolicy{Secure: true, SameSite: http.SameSiteLaxMode}
+
+// NewCookiePolicy builds a policy from the COOKIE_SECURE and
+// COOKIE_SAMESITE settings
+func NewCookiePolicy(secure bool, sameSite string) (CookiePolicy, error) {
+	p := CookiePolicy{Secure: secure}
+	switch strings.ToLower(sameSite) {
+	case "lax", "":
+		p.SameSite = http.SameSiteLaxMode
+	case "strict":
+		p.SameSite = http.SameSiteStrictMode
+	case "none":
+		if !secure {
+			return p, errors.New("SameSite=None cookies must be Secure")
+		}
+		p.SameSite = http.SameSiteNoneMode
+	default:
+		return p, errors.New("SameSite must be lax, strict or none")
+	}
+	return p, nil
+}
+
+// SetCookiePolicy sets the cookie attributes. It must be called before
+// the web routes serve requests.
+func SetCookiePolicy(p CookiePolicy) {
+	cookiePolicy = p
+}
+
+// setCookie sets or, with a negative maxAge, clears an HttpOnly cookie
+// with the configured attributes
+func setCookie(c *gin.Context, name, value string, maxAge int, path string) {
+	c.SetSameSite(cookiePolicy.SameSite)
+	c.SetCookie(name, value, maxAge, path, "", cookiePolicy.Secure, true)
+}
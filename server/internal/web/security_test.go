@@ -0,0 +1,98 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecurityHeaders_RenderedPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	templates, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	u := &UserWeb{
+		templates: templates,
+		sessions:  &SessionStore{sessions: make(map[string]*Session), duration: time.Hour},
+	}
+	r := gin.New()
+	u.RegisterRoutes(r, func(*gin.Context) {})
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/account/login", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<form") {
+		t.Fatalf("login page: status = %d", w.Code)
+	}
+	for name, want := range map[string]string{
+		"Content-Security-Policy": contentSecurityPolicy,
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         "DENY",
+		"Referrer-Policy":         "same-origin",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "" {
+		t.Errorf("HSTS sent over plain HTTP: %q", hsts)
+	}
+
+	w = get("/account/login", http.Header{"X-Forwarded-Proto": {"https"}})
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != hstsMaxAge {
+		t.Errorf("HSTS behind TLS proxy = %q, want %q", hsts, hstsMaxAge)
+	}
+
+	// Static assets are served under the same headers
+	w = get("/account/static/js/forms.js", nil)
+	if w.Code != http.StatusOK || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("static asset: status = %d, headers = %v", w.Code, w.Header())
+	}
+}
+
+func TestCookiePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		secure   bool
+		sameSite string
+		want     http.SameSite
+		ok       bool
+	}{
+		{true, "lax", http.SameSiteLaxMode, true},
+		{false, "Strict", http.SameSiteStrictMode, true},
+		{true, "none", http.SameSiteNoneMode, true},
+		{false, "none", 0, false},
+		{true, "loose", 0, false},
+	} {
+		p, err := NewCookiePolicy(tc.secure, tc.sameSite)
+		if (err == nil) != tc.ok || (tc.ok && (p.SameSite != tc.want || p.Secure != tc.secure)) {
+			t.Errorf("NewCookiePolicy(%v, %q) = %+v, %v", tc.secure, tc.sameSite, p, err)
+		}
+	}
+
+	t.Cleanup(func() { SetCookiePolicy(CookiePolicy{Secure: true, SameSite: http.SameSiteLaxMode}) })
+	SetCookiePolicy(CookiePolicy{Secure: false, SameSite: http.SameSiteStrictMode})
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	userSessionCookie.set(c, "session-id")
+
+	cookies := w.Result().Cookies()
+	session := cookies[len(cookies)-1]
+	if session.Value != "session-id" || session.Secure || !session.HttpOnly || session.SameSite != http.SameSiteStrictMode {
+		t.Errorf("session cookie = %+v", session)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
 )
 
 // Session represents an admin session
@@ -16,8 +18,16 @@ type Session struct {
 	Email       string
 	IsAdmin     bool
 	TOTPPending bool // true if TOTP verification is still needed
-	CreatedAt   time.Time
-	ExpiresAt   time.Time
+	// SecondFactors lists the methods that can complete a pending login
+	SecondFactors []string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	// CSRFToken must come back with every form post of the session
+	CSRFToken string
+
+	// TOTP secret being enrolled, read and written under the store lock
+	pendingTOTP          []byte
+	pendingTOTPExpiresAt time.Time
 }
 
 // IsValid checks if the session is still valid
@@ -30,6 +40,20 @@ func (s *Session) IsFullyAuthenticated() bool {
 	return s.IsValid() && !s.TOTPPending
 }
 
+// allowsSecondFactor reports whether method can complete the pending
+// login. Sessions without a list, like admin sessions, only allow TOTP.
+func (s *Session) allowsSecondFactor(method string) bool {
+	if len(s.SecondFactors) == 0 {
+		return method == models.SecondFactorTOTP
+	}
+	for _, m := range s.SecondFactors {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
 // SessionStore manages admin sessions in memory
 type SessionStore struct {
 	mu       sync.RWMutex
@@ -54,6 +78,10 @@ func (s *SessionStore) Create(userID uuid.UUID, email string, isAdmin bool, totp
 	if err != nil {
 		return nil, err
 	}
+	csrfToken, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
 
 	session := &Session{
 		ID:          sessionID,
@@ -61,6 +89,7 @@ func (s *SessionStore) Create(userID uuid.UUID, email string, isAdmin bool, totp
 		Email:       email,
 		IsAdmin:     isAdmin,
 		TOTPPending: totpRequired,
+		CSRFToken:   csrfToken,
 		CreatedAt:   time.Now(),
 		ExpiresAt:   time.Now().Add(s.duration),
 	}
@@ -84,8 +113,15 @@ func (s *SessionStore) Get(sessionID string) *Session {
 	return session
 }
 
-// UpgradeFromTOTP marks the session as fully authenticated after TOTP verification
+// UpgradeFromTOTP marks the session as fully authenticated after TOTP
+// verification. The CSRF token is rotated, so one shown on the pending
+// login page is no good for the authenticated session.
 func (s *SessionStore) UpgradeFromTOTP(sessionID string) bool {
+	csrfToken, err := generateSessionID()
+	if err != nil {
+		return false
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -95,11 +131,50 @@ func (s *SessionStore) UpgradeFromTOTP(sessionID string) bool {
 	}
 
 	session.TOTPPending = false
+	session.CSRFToken = csrfToken
 	// Extend session after successful TOTP
 	session.ExpiresAt = time.Now().Add(s.duration)
 	return true
 }
 
+// SetPendingTOTP keeps a TOTP secret being enrolled until expiresAt
+func (s *SessionStore) SetPendingTOTP(sessionID string, secret []byte, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists || !session.IsValid() {
+		return false
+	}
+	session.pendingTOTP = secret
+	session.pendingTOTPExpiresAt = expiresAt
+	return true
+}
+
+// PendingTOTP returns the TOTP secret being enrolled and when it
+// expires. The secret is nil if there is none or it expired.
+func (s *SessionStore) PendingTOTP(sessionID string) ([]byte, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists || !session.IsValid() || !time.Now().Before(session.pendingTOTPExpiresAt) {
+		return nil, time.Time{}
+	}
+	return session.pendingTOTP, session.pendingTOTPExpiresAt
+}
+
+// ClearPendingTOTP forgets the TOTP secret being enrolled
+func (s *SessionStore) ClearPendingTOTP(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, exists := s.sessions[sessionID]; exists {
+		session.pendingTOTP = nil
+		session.pendingTOTPExpiresAt = time.Time{}
+	}
+}
+
 // Delete removes a session
 func (s *SessionStore) Delete(sessionID string) {
 	s.mu.Lock()
@@ -107,6 +182,17 @@ func (s *SessionStore) Delete(sessionID string) {
 	s.mu.Unlock()
 }
 
+// DeleteForUser removes all sessions of a user
+func (s *SessionStore) DeleteForUser(userID uuid.UUID) {
+	s.mu.Lock()
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	s.mu.Unlock()
+}
+
 // cleanup periodically removes expired sessions
 func (s *SessionStore) cleanup() {
 	ticker := time.NewTicker(10 * time.Minute)
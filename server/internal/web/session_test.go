@@ -152,6 +152,10 @@ func TestSessionStore_UpgradeFromTOTP(t *testing.T) {
 	if session.IsFullyAuthenticated() {
 		t.Fatal("precondition: should not be fully authenticated")
 	}
+	pendingToken := session.CSRFToken
+	if len(pendingToken) != 64 {
+		t.Fatalf("CSRFToken = %q, want 64 hex chars", pendingToken)
+	}
 
 	ok := store.UpgradeFromTOTP(session.ID)
 	if !ok {
@@ -169,6 +173,9 @@ func TestSessionStore_UpgradeFromTOTP(t *testing.T) {
 	if !got.IsFullyAuthenticated() {
 		t.Error("IsFullyAuthenticated = false after upgrade, want true")
 	}
+	if got.CSRFToken == pendingToken || len(got.CSRFToken) != 64 {
+		t.Errorf("CSRFToken = %q after upgrade, want a new token", got.CSRFToken)
+	}
 }
 
 func TestSessionStore_UpgradeNonExistent(t *testing.T) {
@@ -0,0 +1,28 @@
+// Form helpers of the admin and account pages, kept out of the markup so
+// the Content-Security-Policy can forbid inline scripts.
+(function () {
+    'use strict';
+
+    // Forms with data-confirm ask before submitting. {action} in the
+    // message is replaced by the form's selected action.
+    document.querySelectorAll('form[data-confirm]').forEach(function (form) {
+        form.addEventListener('submit', function (event) {
+            var message = form.dataset.confirm;
+            if (form.elements.action) {
+                message = message.replace('{action}', form.elements.action.value);
+            }
+            if (!window.confirm(message)) {
+                event.preventDefault();
+            }
+        });
+    });
+
+    // Checkboxes with data-select-all toggle every box the selector matches
+    document.querySelectorAll('input[data-select-all]').forEach(function (toggle) {
+        toggle.addEventListener('change', function () {
+            document.querySelectorAll(toggle.dataset.selectAll).forEach(function (box) {
+                box.checked = toggle.checked;
+            });
+        });
+    });
+})();
@@ -0,0 +1,141 @@
+// WebAuthn ceremonies of the account pages. The server's options carry
+// binary fields as base64url strings; the browser API wants ArrayBuffers.
+(function () {
+    'use strict';
+
+    function toBuffer(value) {
+        var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        var binary = atob(base64 + '==='.slice((base64.length + 3) % 4));
+        var bytes = new Uint8Array(binary.length);
+        for (var i = 0; i < binary.length; i++) {
+            bytes[i] = binary.charCodeAt(i);
+        }
+        return bytes.buffer;
+    }
+
+    function fromBuffer(buffer) {
+        var bytes = new Uint8Array(buffer);
+        var binary = '';
+        for (var i = 0; i < bytes.length; i++) {
+            binary += String.fromCharCode(bytes[i]);
+        }
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function decodeDescriptors(list) {
+        return (list || []).map(function (d) {
+            return Object.assign({}, d, {id: toBuffer(d.id)});
+        });
+    }
+
+    function encodeCredential(cred) {
+        var response = {clientDataJSON: fromBuffer(cred.response.clientDataJSON)};
+        if (cred.response.attestationObject) {
+            response.attestationObject = fromBuffer(cred.response.attestationObject);
+            if (cred.response.getTransports) {
+                response.transports = cred.response.getTransports();
+            }
+        }
+        if (cred.response.authenticatorData) {
+            response.authenticatorData = fromBuffer(cred.response.authenticatorData);
+            response.signature = fromBuffer(cred.response.signature);
+            if (cred.response.userHandle) {
+                response.userHandle = fromBuffer(cred.response.userHandle);
+            }
+        }
+        return {
+            id: cred.id,
+            rawId: fromBuffer(cred.rawId),
+            type: cred.type,
+            authenticatorAttachment: cred.authenticatorAttachment || undefined,
+            clientExtensionResults: cred.getClientExtensionResults(),
+            response: response
+        };
+    }
+
+    // csrfToken is the session's token from the page's csrf-token meta tag
+    function csrfToken() {
+        var meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.content : '';
+    }
+
+    function postJSON(url, body) {
+        return fetch(url, {
+            method: 'POST',
+            credentials: 'same-origin',
+            headers: {'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken()},
+            body: JSON.stringify(body || {})
+        }).then(function (resp) {
+            return resp.json().then(function (data) {
+                if (!resp.ok) {
+                    throw new Error(data.error || 'Request failed');
+                }
+                return data;
+            });
+        });
+    }
+
+    // register runs a registration ceremony against beginURL/finishURL
+    function register(beginURL, finishURL, name) {
+        return postJSON(beginURL).then(function (begin) {
+            var options = begin.options.publicKey;
+            options.challenge = toBuffer(options.challenge);
+            options.user.id = toBuffer(options.user.id);
+            options.excludeCredentials = decodeDescriptors(options.excludeCredentials);
+            return navigator.credentials.create({publicKey: options}).then(function (cred) {
+                return postJSON(finishURL, {
+                    challenge_id: begin.challenge_id,
+                    name: name,
+                    credential: encodeCredential(cred)
+                });
+            });
+        });
+    }
+
+    // login runs a login ceremony against beginURL/finishURL
+    function login(beginURL, finishURL) {
+        return postJSON(beginURL).then(function (begin) {
+            var options = begin.options.publicKey;
+            options.challenge = toBuffer(options.challenge);
+            options.allowCredentials = decodeDescriptors(options.allowCredentials);
+            return navigator.credentials.get({publicKey: options}).then(function (cred) {
+                return postJSON(finishURL, {
+                    challenge_id: begin.challenge_id,
+                    credential: encodeCredential(cred)
+                });
+            });
+        });
+    }
+
+    window.VibedTermWebAuthn = {
+        supported: !!(window.PublicKeyCredential && navigator.credentials),
+        register: register,
+        login: login
+    };
+
+    // bind runs a ceremony when the button with id is clicked and follows
+    // the redirect it returns, or shows the error in #passkey-error
+    function bind(id, ceremony) {
+        var button = document.getElementById(id);
+        if (!button) {
+            return;
+        }
+        button.addEventListener('click', function () {
+            var errorBox = document.getElementById('passkey-error');
+            errorBox.hidden = true;
+            ceremony()
+                .then(function (data) { window.location = data.redirect; })
+                .catch(function (err) { errorBox.textContent = err.message; errorBox.hidden = false; });
+        });
+    }
+
+    // The page scripts are loaded at the end of the body, so the buttons
+    // exist by now
+    bind('passkey-add', function () {
+        var name = document.getElementById('passkey_name').value;
+        return register('/account/settings/passkeys/begin', '/account/settings/passkeys/finish', name);
+    });
+    bind('passkey-login', function () {
+        return login('/account/login/passkey/begin', '/account/login/passkey/finish');
+    });
+})();
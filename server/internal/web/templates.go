@@ -14,9 +14,12 @@ import (
 //go:embed templates/*.html
 var templateFS embed.FS
 
-//go:embed static/css/*.css
+//go:embed static/css/*.css static/icons/* static/js/*.js
 var staticFS embed.FS
 
+// iconsPartial defines the "icons" head block shared by every page
+const iconsPartial = "templates/icons.html"
+
 // Templates holds parsed per-page template sets.
 // Each page is parsed with its layout to avoid {{define "content"}} collisions.
 type Templates struct {
@@ -29,6 +32,11 @@ func NewTemplates() (*Templates, error) {
 		"formatTime": formatTime,
 		"timeAgo":    timeAgo,
 		"deref":      derefTime,
+		"derefInt":   derefInt64,
+		"duration":   formatSeconds,
+		"bytes":      formatBytes,
+		"asset":      AssetURL,
+		"csrfField":  csrfField,
 	}
 
 	t := &Templates{
@@ -40,10 +48,11 @@ func NewTemplates() (*Templates, error) {
 		return nil, err
 	}
 
-	// Identify layout files
+	// Identify layout and partial files
 	layouts := map[string]bool{
 		"layout.html":      true,
 		"user_layout.html": true,
+		"icons.html":       true,
 	}
 
 	for _, page := range pages {
@@ -59,16 +68,18 @@ func NewTemplates() (*Templates, error) {
 		}
 		pageContent := string(content)
 
-		var tmpl *template.Template
+		// Pages render typed view models; missingkey=error also catches
+		// lookups in any map that still reaches a template
+		tmpl := template.New(name).Option("missingkey=error").Funcs(funcMap)
 		if strings.Contains(pageContent, `{{template "layout"`) {
 			// Admin page using layout.html
-			tmpl, err = template.New(name).Funcs(funcMap).ParseFS(templateFS, "templates/layout.html", page)
+			tmpl, err = tmpl.ParseFS(templateFS, "templates/layout.html", iconsPartial, page)
 		} else if strings.Contains(pageContent, `{{template "user_layout"`) {
 			// User page using user_layout.html
-			tmpl, err = template.New(name).Funcs(funcMap).ParseFS(templateFS, "templates/user_layout.html", page)
+			tmpl, err = tmpl.ParseFS(templateFS, "templates/user_layout.html", iconsPartial, page)
 		} else {
 			// Standalone page (login, register, etc.)
-			tmpl, err = template.New(name).Funcs(funcMap).ParseFS(templateFS, page)
+			tmpl, err = tmpl.ParseFS(templateFS, iconsPartial, page)
 		}
 
 		if err != nil {
@@ -103,6 +114,41 @@ func derefTime(t *time.Time) time.Time {
 	return *t
 }
 
+func derefInt64(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+// formatSeconds renders a duration in seconds as its two largest units
+func formatSeconds(seconds int64) string {
+	d := time.Duration(seconds) * time.Second
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dd %dh", int(d.Hours())/24, int(d.Hours())%24)
+}
+
+// formatBytes renders a size in binary units with one decimal
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
 func formatTime(t time.Time) string {
 	if t.IsZero() {
 		return "Never"
@@ -0,0 +1,180 @@
+package web
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template/parse"
+	"time"
+)
+
+// pageViewModels maps every page template to its view model
+var pageViewModels = map[string]any{
+	"login.html":                  loginPageData{},
+	"totp.html":                   totpPageData{},
+	"dashboard.html":              dashboardPageData{},
+	"create_user.html":            createUserPageData{},
+	"users.html":                  usersPageData{},
+	"approvals.html":              approvalsPageData{},
+	"user_detail.html":            userDetailPageData{},
+	"admin_user_devices.html":     userDevicesPageData{},
+	"invites.html":                invitesPageData{},
+	"outbox.html":                 outboxPageData{},
+	"settings.html":               settingsPageData{},
+	"register.html":               registerPageData{},
+	"user_login.html":             userLoginPageData{},
+	"user_totp.html":              userTOTPPageData{},
+	"user_settings.html":          userSettingsPageData{},
+	"user_totp_settings.html":     totpSettingsPageData{},
+	"user_totp_setup.html":        totpSetupPageData{},
+	"user_recovery_codes.html":    recoveryCodesPageData{},
+	"user_recovery_settings.html": recoverySettingsPageData{},
+	"user_devices.html":           devicesPageData{},
+	"user_connect.html":           connectPageData{},
+	"csrf_error.html":             csrfErrorPageData{},
+}
+
+// populate sets every settable field below v to a non-zero value, so
+// {{if}} and {{range}} bodies render too
+func populate(v reflect.Value, depth int) {
+	if depth > 8 {
+		return
+	}
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		v.Set(reflect.ValueOf(time.Now().Add(-time.Hour)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			populate(v.Index(i), depth+1)
+		}
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		populate(v.Elem(), depth+1)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		populate(v.Index(0), depth+1)
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		populate(key, depth+1)
+		populate(elem, depth+1)
+		v.SetMapIndex(key, elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			// Fields promoted from embedded structs are settable too
+			if v.Field(i).CanSet() || v.Type().Field(i).Anonymous {
+				populate(v.Field(i), depth+1)
+			}
+		}
+	}
+}
+
+// fieldNames collects every field and method name a template set looks up
+func fieldNames(node parse.Node, names map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			fieldNames(c, names)
+		}
+	case *parse.ActionNode:
+		fieldNames(n.Pipe, names)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			fieldNames(cmd, names)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			fieldNames(arg, names)
+		}
+	case *parse.FieldNode:
+		for _, ident := range n.Ident {
+			names[ident] = true
+		}
+	case *parse.VariableNode:
+		for _, ident := range n.Ident[1:] {
+			names[ident] = true
+		}
+	case *parse.ChainNode:
+		fieldNames(n.Node, names)
+		for _, field := range n.Field {
+			names[field] = true
+		}
+	case *parse.IfNode:
+		fieldNames(&n.BranchNode, names)
+	case *parse.RangeNode:
+		fieldNames(&n.BranchNode, names)
+	case *parse.WithNode:
+		fieldNames(&n.BranchNode, names)
+	case *parse.BranchNode:
+		fieldNames(n.Pipe, names)
+		fieldNames(n.List, names)
+		fieldNames(n.ElseList, names)
+	case *parse.TemplateNode:
+		fieldNames(n.Pipe, names)
+	}
+}
+
+// inlineCode matches script blocks, event handler and style attributes
+var inlineCode = regexp.MustCompile(`<script>|<style|\son[a-z]+=|\sstyle=`)
+
+func TestTemplates_RenderTypedViewModels(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+
+	for name, set := range tmpl.templates {
+		t.Run(name, func(t *testing.T) {
+			model, ok := pageViewModels[name]
+			if !ok {
+				t.Fatal("no view model for page")
+			}
+			data := reflect.New(reflect.TypeOf(model))
+			populate(data.Elem(), 0)
+			var page strings.Builder
+			if err := tmpl.Render(&page, name, data.Elem().Interface()); err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			// The Content-Security-Policy blocks inline scripts and styles
+			if inline := inlineCode.FindString(page.String()); inline != "" {
+				t.Errorf("page has inline code %q", inline)
+			}
+
+			// Every field of the view model is shown by the page or its layout
+			names := map[string]bool{}
+			for _, tree := range set.Templates() {
+				fieldNames(tree.Tree.Root, names)
+			}
+			typ := reflect.TypeOf(model)
+			for _, field := range reflect.VisibleFields(typ) {
+				if !field.Anonymous && !names[field.Name] {
+					t.Errorf("field %s of %s is not used by the page", field.Name, typ.Name())
+				}
+			}
+		})
+	}
+	for name := range pageViewModels {
+		if _, ok := tmpl.templates[name]; !ok {
+			t.Errorf("view model for unknown page %s", name)
+		}
+	}
+}
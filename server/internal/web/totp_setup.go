@@ -0,0 +1,173 @@
+package web
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/recoverycode"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
+)
+
+// pendingTOTPLifetime is how long a generated secret can be confirmed
+const pendingTOTPLifetime = 15 * time.Minute
+
+// totpSetupPageData is the view model of user_totp_setup.html
+type totpSetupPageData struct {
+	userLayout
+	Title     string
+	Email     string
+	Error     string
+	Secret    string       // base32 in groups of four for manual entry
+	QRCode    template.URL // otpauth URI as PNG data URI
+	ExpiresAt time.Time
+}
+
+// recoveryCodesPageData is the view model of user_recovery_codes.html
+type recoveryCodesPageData struct {
+	userLayout
+	Title  string
+	Email  string
+	Notice string // what led to the new codes
+	Codes  []string
+}
+
+// totpSetupPage shows a new TOTP secret to scan. The secret is kept in
+// the session until it is confirmed or expires; reloading shows the same
+// one.
+func (u *UserWeb) totpSetupPage(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	user, err := u.userRepo.GetByID(c.Request.Context(), session.UserID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/settings")
+		return
+	}
+	if user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/settings/totp")
+		return
+	}
+
+	secret, expiresAt := u.sessions.PendingTOTP(session.ID)
+	if secret == nil {
+		_, secret, err = totpsecret.Generate(u.totpIssuer, user.Email, 0)
+		if err != nil {
+			log.Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to generate TOTP secret")
+			c.String(http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		expiresAt = time.Now().Add(pendingTOTPLifetime)
+		u.sessions.SetPendingTOTP(session.ID, secret, expiresAt)
+	}
+
+	key, err := totpsecret.Key(u.totpIssuer, user.Email, secret)
+	if err != nil {
+		log.Error().Err(err).Str("user_id", user.ID.String()).Msg("Failed to build TOTP key")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	qrImage, err := qrDataURI(key.URL())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to render TOTP QR code")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	data := totpSetupPageData{
+		Title:     "Enable Two-Factor Authentication",
+		Email:     user.Email,
+		Error:     c.Query("error"),
+		Secret:    groupSecret(key.Secret()),
+		QRCode:    qrImage,
+		ExpiresAt: expiresAt,
+	}
+	c.Header("Cache-Control", "no-store")
+	u.render(c, "user_totp_setup.html", &data)
+}
+
+// enableTOTP confirms the pending secret with a code from the
+// authenticator app, enables TOTP and shows the recovery codes once
+func (u *UserWeb) enableTOTP(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+	ctx := c.Request.Context()
+
+	user, err := u.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/settings")
+		return
+	}
+	if user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/account/settings/totp")
+		return
+	}
+
+	secret, _ := u.sessions.PendingTOTP(session.ID)
+	if secret == nil {
+		c.Redirect(http.StatusFound, "/account/settings/totp/setup?error=Setup+expired.+Scan+the+new+code")
+		return
+	}
+
+	// Checked like a login so guesses are throttled and the code cannot
+	// be used again right away
+	enrolling := *user
+	enrolling.TOTPSecret = secret
+	if err := u.totpGuard.Check(ctx, &enrolling, c.PostForm("code"), events.SurfaceWeb); err != nil {
+		c.Redirect(http.StatusFound, "/account/settings/totp/setup?error="+totpFormError(err))
+		return
+	}
+
+	if err := u.userRepo.SetTOTPSecret(ctx, user.ID, secret); err != nil {
+		log.Error().Err(err).Msg("Failed to save TOTP secret")
+		c.Redirect(http.StatusFound, "/account/settings/totp/setup?error=Failed+to+enable+2FA")
+		return
+	}
+	if err := u.userRepo.EnableTOTP(ctx, user.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to enable TOTP")
+		c.Redirect(http.StatusFound, "/account/settings/totp/setup?error=Failed+to+enable+2FA")
+		return
+	}
+	u.sessions.ClearPendingTOTP(session.ID)
+	u.events.Record(ctx, events.Entry{SubjectID: &user.ID, Payload: events.TOTPEnabled{Surface: events.SurfaceWeb}})
+	log.Info().Str("email", user.Email).Msg("User enabled 2FA via web interface")
+
+	codes, err := recoverycode.Issue(ctx, u.recoveryRepo, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate recovery codes")
+		c.Redirect(http.StatusFound, "/account/settings?error=2FA+enabled+but+recovery+codes+could+not+be+generated")
+		return
+	}
+
+	// Rendered directly instead of redirecting so the codes are shown
+	// exactly once
+	u.renderRecoveryCodes(c, user.Email, "Two-factor authentication is enabled.", codes)
+}
+
+// renderRecoveryCodes shows newly issued recovery codes. They are never
+// shown again, so the page must not be cached.
+func (u *UserWeb) renderRecoveryCodes(c *gin.Context, email, notice string, codes []string) {
+	data := recoveryCodesPageData{
+		Title:  "Recovery Codes",
+		Email:  email,
+		Notice: notice,
+		Codes:  codes,
+	}
+	c.Header("Cache-Control", "no-store")
+	u.render(c, "user_recovery_codes.html", &data)
+}
+
+// groupSecret splits a base32 secret into groups of four for reading
+func groupSecret(secret string) string {
+	var b strings.Builder
+	for i, r := range secret {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
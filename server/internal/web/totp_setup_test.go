@@ -0,0 +1,143 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/recoverycode"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
+)
+
+// memWebRecoveryCodes is an in-memory webRecoveryCodeStore
+type memWebRecoveryCodes struct{ hashes map[uuid.UUID][]string }
+
+func (m *memWebRecoveryCodes) ReplaceAllForUser(_ context.Context, userID uuid.UUID, codeHashes []string) error {
+	m.hashes[userID] = append([]string(nil), codeHashes...)
+	return nil
+}
+
+func (m *memWebRecoveryCodes) DeleteAllForUser(_ context.Context, userID uuid.UUID) error {
+	delete(m.hashes, userID)
+	return nil
+}
+
+func (m *memWebRecoveryCodes) CountUnused(_ context.Context, userID uuid.UUID) (int, error) {
+	return len(m.hashes[userID]), nil
+}
+
+func TestTOTPSetup_EnrollsAndShowsRecoveryCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "web@example.com"}
+	codes := &memWebRecoveryCodes{hashes: map[uuid.UUID][]string{user.ID: {"old"}}}
+	u := &UserWeb{
+		templates:    tmpl,
+		userRepo:     memWebUsers{user.ID: user},
+		recoveryRepo: codes,
+		sessions:     &SessionStore{sessions: make(map[string]*Session), duration: time.Hour},
+		totpGuard:    service.NewTOTPGuard(&memAdminAudit{}, 5, 5*time.Minute),
+		totpIssuer:   "VibedTerm",
+	}
+	session, err := u.sessions.Create(user.ID, user.Email, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(handler gin.HandlerFunc, method string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, "/account/settings/totp/setup", strings.NewReader(form.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c.Set("session", session)
+		handler(c)
+		return w
+	}
+
+	w := call(u.totpSetupPage, http.MethodGet, nil)
+	secret, _ := u.sessions.PendingTOTP(session.ID)
+	if w.Code != http.StatusOK || secret == nil {
+		t.Fatalf("setup page = %d, pending secret = %v", w.Code, secret)
+	}
+	if !strings.Contains(w.Body.String(), groupSecret(totpsecret.Encode(secret))) {
+		t.Error("setup page does not show the secret")
+	}
+	// Reloading keeps the secret that may already be scanned
+	call(u.totpSetupPage, http.MethodGet, nil)
+	if again, _ := u.sessions.PendingTOTP(session.ID); string(again) != string(secret) {
+		t.Error("reloading the setup page replaced the pending secret")
+	}
+
+	w = call(u.enableTOTP, http.MethodPost, url.Values{"code": {"000000"}})
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=Invalid+code") || user.TOTPEnabled {
+		t.Fatalf("wrong code redirected to %q, enabled = %v", loc, user.TOTPEnabled)
+	}
+
+	code, err := totp.GenerateCode(totpsecret.Encode(secret), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = call(u.enableTOTP, http.MethodPost, url.Values{"code": {code}})
+	if w.Code != http.StatusOK || !user.TOTPEnabled || string(user.TOTPSecret) != string(secret) {
+		t.Fatalf("confirm = %d, enabled = %v", w.Code, user.TOTPEnabled)
+	}
+	stored := codes.hashes[user.ID]
+	if len(stored) != recoverycode.Count || stored[0] == "old" {
+		t.Fatalf("stored recovery codes = %v, want %d new ones", stored, recoverycode.Count)
+	}
+	if !strings.Contains(w.Body.String(), "I have saved these codes") || w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("recovery codes page lacks the confirmation or is cacheable")
+	}
+	if pending, _ := u.sessions.PendingTOTP(session.ID); pending != nil {
+		t.Error("pending secret kept after enabling")
+	}
+
+	w = call(u.totpSetupPage, http.MethodGet, nil)
+	if loc := w.Header().Get("Location"); loc != "/account/settings/totp" {
+		t.Errorf("setup with TOTP enabled redirected to %q", loc)
+	}
+}
+
+func TestTOTPSetup_PendingSecretExpires(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user := &models.User{ID: uuid.New(), Email: "web@example.com"}
+	u := &UserWeb{
+		userRepo: memWebUsers{user.ID: user},
+		sessions: &SessionStore{sessions: make(map[string]*Session), duration: time.Hour},
+	}
+	session, err := u.sessions.Create(user.ID, user.Email, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret, err := totpsecret.Generate("VibedTerm", user.Email, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.sessions.SetPendingTOTP(session.ID, secret, time.Now().Add(-time.Second))
+
+	code, err := totp.GenerateCode(totpsecret.Encode(secret), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/account/settings/totp/setup", strings.NewReader(url.Values{"code": {code}}.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Set("session", session)
+	u.enableTOTP(c)
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "error=Setup+expired") || user.TOTPEnabled {
+		t.Errorf("expired setup redirected to %q, enabled = %v", loc, user.TOTPEnabled)
+	}
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+)
+
+const (
+	trustedBrowserCookieName = "trusted_browser"
+	trustedBrowserName       = "Web browser"
+)
+
+// browserTruster is the subset of service.DeviceTrust used by UserWeb
+type browserTruster interface {
+	Enabled() bool
+	Lifetime() time.Duration
+	TrustBrowser(ctx context.Context, userID uuid.UUID, name string) (string, *models.TrustedDevice, error)
+	VerifyBrowser(ctx context.Context, userID uuid.UUID, token string) (bool, error)
+	RevokeAll(ctx context.Context, userID uuid.UUID) error
+}
+
+// browserTrusted reports whether the browser carries a trust cookie of
+// userID, so its login can skip the second factor
+func (u *UserWeb) browserTrusted(c *gin.Context, userID uuid.UUID) bool {
+	token, err := c.Cookie(trustedBrowserCookieName)
+	if err != nil || token == "" || u.trust == nil {
+		return false
+	}
+	trusted, err := u.trust.VerifyBrowser(c.Request.Context(), userID, token)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check trusted browser")
+		return false
+	}
+	return trusted
+}
+
+// trustBrowser sets a long-lived cookie that lets the browser skip the
+// second factor on later logins
+func (u *UserWeb) trustBrowser(c *gin.Context, userID uuid.UUID) {
+	if u.trust == nil || !u.trust.Enabled() {
+		return
+	}
+	token, _, err := u.trust.TrustBrowser(c.Request.Context(), userID, trustedBrowserName)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to trust browser")
+		return
+	}
+	setCookie(c, trustedBrowserCookieName, token, int(u.trust.Lifetime().Seconds()), "/account")
+}
+
+// rememberDays is how many days a trusted browser skips the second
+// factor, 0 if trust is disabled
+func (u *UserWeb) rememberDays() int {
+	if u.trust == nil || !u.trust.Enabled() {
+		return 0
+	}
+	return int(u.trust.Lifetime().Hours() / 24)
+}
+
+// forgetTrustedBrowsers revokes the trust of every device and browser of
+// the user, e.g. after the password or the second factor changed
+func (u *UserWeb) forgetTrustedBrowsers(c *gin.Context, userID uuid.UUID) {
+	if u.trust == nil {
+		return
+	}
+	if err := u.trust.RevokeAll(c.Request.Context(), userID); err != nil {
+		log.Error().Err(err).Str("user_id", userID.String()).Msg("Failed to revoke trusted devices")
+	}
+	setCookie(c, trustedBrowserCookieName, "", -1, "/account")
+}
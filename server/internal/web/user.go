@@ -1,21 +1,31 @@
 package web
 
 import (
-	"encoding/base32"
+	"context"
 	"errors"
+	"fmt"
 	"io/fs"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/pquerna/otp/totp"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/crypto/bcrypt"
 
+	"github.com/sprobst76/vibedterm-server/internal/events"
+	"github.com/sprobst76/vibedterm-server/internal/input"
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	passwords "github.com/sprobst76/vibedterm-server/internal/password"
 	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
 )
 
+// busyMessage is shown when a password check was shed under load
+const busyMessage = "Server+busy,+please+try+again"
+
 const (
 	userSessionCookieName = "user_session"
 	userSessionDuration   = 4 * time.Hour
@@ -23,73 +33,212 @@ const (
 
 // UserWeb handles the user-facing web interface
 type UserWeb struct {
-	templates  *Templates
-	sessions   *SessionStore
-	userRepo   *repository.UserRepository
-	deviceRepo *repository.DeviceRepository
+	templates    *Templates
+	sessions     *SessionStore
+	userRepo     webUserStore
+	recoveryRepo webRecoveryCodeStore
+	deviceRepo   webDeviceStore
+	apiSessions  apiSessionStore
+	registration *service.Registration
+	verification verificationResender
+	passkeys     passkeyCeremonies
+	trust        browserTruster
+	totpGuard    totpChecker
+	pruning      devicePruning
+	approval     deviceApprovalSetting
+	events       *events.Log
+	publicURL    string // shown on the connect page
+	totpIssuer   string // shown in authenticator apps
+}
+
+// totpChecker is the subset of service.TOTPGuard used to check TOTP
+// codes
+type totpChecker interface {
+	Check(ctx context.Context, user *models.User, code, surface string) error
+}
+
+// webUserStore is the subset of UserRepository used by UserWeb
+type webUserStore interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	UpdateLastLogin(ctx context.Context, id uuid.UUID) error
+	UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error
+	SetTOTPSecret(ctx context.Context, id uuid.UUID, secret []byte) error
+	EnableTOTP(ctx context.Context, id uuid.UUID) error
+	DisableTOTP(ctx context.Context, id uuid.UUID) error
+	passwordRehasher
+}
+
+// webRecoveryCodeStore is the subset of RecoveryCodeRepository used by UserWeb
+type webRecoveryCodeStore interface {
+	ReplaceAllForUser(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	DeleteAllForUser(ctx context.Context, userID uuid.UUID) error
+	CountUnused(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+// webDeviceStore is the subset of DeviceRepository used by UserWeb
+type webDeviceStore interface {
+	GetByUserID(ctx context.Context, userID uuid.UUID) ([]models.Device, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error)
+	Update(ctx context.Context, id uuid.UUID, name *string, readOnly *bool) error
+	Approve(ctx context.Context, id uuid.UUID) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// deviceApprovalSetting is the subset of UserRepository holding whether
+// new devices need approval
+type deviceApprovalSetting interface {
+	DeviceApproval(ctx context.Context, id uuid.UUID) (bool, error)
+	SetDeviceApproval(ctx context.Context, id uuid.UUID, on bool) error
+}
+
+// apiSessionStore is the subset of RefreshTokenRepository used to manage
+// the user's app sessions
+type apiSessionStore interface {
+	GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	RevokeByID(ctx context.Context, userID, sessionID uuid.UUID) error
+	RevokeAllForDevice(ctx context.Context, deviceID uuid.UUID) error
+}
+
+// devicePruning is the subset of service.DeviceCleanup used to opt in to
+// the removal of inactive devices
+type devicePruning interface {
+	Policy() (after time.Duration, allAccounts bool)
+	OptedIn(ctx context.Context, userID uuid.UUID) (bool, error)
+	SetOptIn(ctx context.Context, userID uuid.UUID, on bool) error
+}
+
+// verificationResender is the subset of service.EmailVerification used by UserWeb
+type verificationResender interface {
+	Resend(ctx context.Context, email string) error
 }
 
 // NewUserWeb creates a new user web handler
 func NewUserWeb(
 	userRepo *repository.UserRepository,
+	recoveryRepo *repository.RecoveryCodeRepository,
 	deviceRepo *repository.DeviceRepository,
+	refreshRepo *repository.RefreshTokenRepository,
+	registration *service.Registration,
+	verification *service.EmailVerification,
+	webAuthn *service.WebAuthn,
+	trust *service.DeviceTrust,
+	totpGuard *service.TOTPGuard,
+	deviceCleanup *service.DeviceCleanup,
+	eventLog *events.Log,
+	publicURL string,
+	totpIssuer string,
 	templates *Templates,
 ) *UserWeb {
 	return &UserWeb{
-		templates:  templates,
-		sessions:   NewSessionStore(userSessionDuration),
-		userRepo:   userRepo,
-		deviceRepo: deviceRepo,
+		templates:    templates,
+		sessions:     NewSessionStore(userSessionDuration),
+		userRepo:     userRepo,
+		recoveryRepo: recoveryRepo,
+		deviceRepo:   deviceRepo,
+		apiSessions:  refreshRepo,
+		registration: registration,
+		verification: verification,
+		passkeys:     webAuthn,
+		trust:        trust,
+		totpGuard:    totpGuard,
+		pruning:      deviceCleanup,
+		approval:     userRepo,
+		events:       eventLog,
+		publicURL:    publicURL,
+		totpIssuer:   totpIssuer,
 	}
 }
 
-// RegisterRoutes registers all user web routes
-func (u *UserWeb) RegisterRoutes(r *gin.Engine) {
+// RegisterRoutes registers all user web routes. loginLimit guards the
+// registration and login posts.
+func (u *UserWeb) RegisterRoutes(r *gin.Engine, loginLimit gin.HandlerFunc) {
+	// Pages and static files alike get the browser security headers
+	site := r.Group("", securityHeaders())
+	account := site.Group("/account")
+
 	// Serve static files for user pages (reuse admin CSS)
 	staticSubFS, err := fs.Sub(GetStaticFS(), "static")
 	if err == nil {
-		r.StaticFS("/account/static", http.FS(staticSubFS))
+		account.StaticFS("/static", http.FS(staticSubFS))
 	}
 
 	// Public routes
-	r.GET("/register", u.registerPage)
-	r.POST("/register", u.register)
+	site.GET("/register", u.registerPage)
+	site.POST("/register", loginLimit, u.register)
 
-	account := r.Group("/account")
 	{
 		account.GET("/login", u.loginPage)
-		account.POST("/login", u.login)
+		account.POST("/login", loginLimit, u.login)
+		account.POST("/verify-email/resend", loginLimit, u.resendVerification)
 		account.GET("/login/totp", u.totpPage)
-		account.POST("/login/totp", u.validateTOTP)
+		account.POST("/login/totp", loginLimit, u.csrfMiddleware(), u.validateTOTP)
+		account.POST("/login/passkey/begin", loginLimit, u.csrfMiddleware(), u.beginPasskeyLogin)
+		account.POST("/login/passkey/finish", loginLimit, u.csrfMiddleware(), u.finishPasskeyLogin)
 
-		// Protected routes
+		// Protected routes, whose posts carry the CSRF token
 		protected := account.Group("")
-		protected.Use(u.authMiddleware())
+		protected.Use(u.authMiddleware(), u.csrfMiddleware())
 		{
 			protected.GET("/settings", u.settingsPage)
 			protected.POST("/settings/password", u.changePassword)
 			protected.GET("/settings/totp", u.totpSettingsPage)
+			protected.GET("/settings/totp/setup", u.totpSetupPage)
+			protected.POST("/settings/totp/setup", u.enableTOTP)
+			protected.GET("/settings/recovery-codes", u.recoveryCodesPage)
+			protected.POST("/settings/recovery-codes", u.regenerateRecoveryCodes)
 			protected.POST("/settings/totp/disable", u.disableTOTP)
+			protected.POST("/settings/passkeys/begin", u.beginPasskeyRegistration)
+			protected.POST("/settings/passkeys/finish", u.finishPasskeyRegistration)
+			protected.POST("/settings/passkeys/:id/delete", u.deletePasskey)
+			protected.GET("/connect", u.connectPage)
 			protected.GET("/devices", u.devicesPage)
+			protected.POST("/devices/:id/rename", u.renameDevice)
+			protected.POST("/devices/:id/read-only", u.setDeviceReadOnly)
 			protected.POST("/devices/:id/delete", u.deleteDevice)
+			protected.POST("/devices/pruning", u.setDevicePruning)
+			protected.POST("/devices/approval", u.setDeviceApproval)
+			protected.POST("/devices/:id/approve", u.approveDevice)
+			protected.POST("/devices/:id/deny", u.denyDevice)
+			protected.POST("/sessions/:id/revoke", u.revokeSession)
 			protected.POST("/logout", u.logout)
 		}
 	}
 }
 
+// csrfMiddleware checks the CSRF token of user session posts
+func (u *UserWeb) csrfMiddleware() gin.HandlerFunc {
+	return csrfProtect(u.templates, userSessionCookie, u.sessions, "/account/settings")
+}
+
+// userLayout holds what user_layout.html needs on every account page
+type userLayout struct {
+	CSRFToken string
+}
+
+func (l *userLayout) layout() *userLayout { return l }
+
+// userLayoutPage is a view model of a page using user_layout.html
+type userLayoutPage interface {
+	layout() *userLayout
+}
+
+// render writes an account page with the CSRF token of the session
+// filled in
+func (u *UserWeb) render(c *gin.Context, name string, data userLayoutPage) {
+	data.layout().CSRFToken = c.MustGet("session").(*Session).CSRFToken
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := u.templates.Render(c.Writer, name, data); err != nil {
+		log.Error().Err(err).Str("template", name).Msg("Failed to render account page")
+		c.String(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
 // authMiddleware checks for valid user session (approved & not blocked)
 func (u *UserWeb) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		sessionID, err := c.Cookie(userSessionCookieName)
-		if err != nil || sessionID == "" {
-			c.Redirect(http.StatusFound, "/account/login")
-			c.Abort()
-			return
-		}
-
-		session := u.sessions.Get(sessionID)
+		session := userSessionCookie.resolveOrClear(c, u.sessions)
 		if session == nil {
-			c.SetCookie(userSessionCookieName, "", -1, "/account", "", true, true)
 			c.Redirect(http.StatusFound, "/account/login")
 			c.Abort()
 			return
@@ -106,13 +255,35 @@ func (u *UserWeb) authMiddleware() gin.HandlerFunc {
 	}
 }
 
-// registerPage shows the registration form
+// registerPage shows the registration form, or that registration is
+// closed
 func (u *UserWeb) registerPage(c *gin.Context) {
-	data := gin.H{
-		"Title": "Register",
-		"Error": c.Query("error"),
+	u.renderRegister(c, http.StatusOK)
+}
+
+// registerPageData is the view model of register.html
+type registerPageData struct {
+	Error        string
+	Requirements *models.AuthRequirements
+	Closed       bool
+}
+
+// renderRegister renders the registration page with status. The hints
+// come from the same requirements the API publishes.
+func (u *UserWeb) renderRegister(c *gin.Context, status int) {
+	req, err := u.registration.Requirements(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load registration requirements")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	data := registerPageData{
+		Error:        c.Query("error"),
+		Requirements: req,
+		Closed:       req.Registration.Mode == service.RegistrationClosed,
 	}
 	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
 	if err := u.templates.Render(c.Writer, "register.html", data); err != nil {
 		log.Error().Err(err).Msg("Failed to render register template")
 		c.String(http.StatusInternalServerError, "Internal server error")
@@ -121,17 +292,32 @@ func (u *UserWeb) registerPage(c *gin.Context) {
 
 // register handles the registration form submission
 func (u *UserWeb) register(c *gin.Context) {
-	email := c.PostForm("email")
-	password := c.PostForm("password")
-	confirmPassword := c.PostForm("confirm_password")
+	if policy, err := u.registration.Policy(c.Request.Context()); err == nil && policy.Mode == service.RegistrationClosed {
+		u.renderRegister(c, http.StatusForbidden)
+		return
+	}
+
+	form, err := formValues(c, map[string]string{
+		"email":                input.KindEmail,
+		"password":             input.KindSecret,
+		"confirm_password":     input.KindSecret,
+		"display_name":         input.KindName,
+		"registration_message": input.KindMessage,
+		"invite_code":          input.KindToken,
+	})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/register?error="+formError(err))
+		return
+	}
+	email, password, confirmPassword := form["email"], form["password"], form["confirm_password"]
 
 	if email == "" || password == "" {
 		c.Redirect(http.StatusFound, "/register?error=Email+and+password+required")
 		return
 	}
 
-	if len(password) < 8 {
-		c.Redirect(http.StatusFound, "/register?error=Password+must+be+at+least+8+characters")
+	if len(password) < passwords.MinLength {
+		c.Redirect(http.StatusFound, "/register?error="+url.QueryEscape(fmt.Sprintf("Password must be at least %d characters", passwords.MinLength)))
 		return
 	}
 
@@ -140,41 +326,89 @@ func (u *UserWeb) register(c *gin.Context) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		c.Redirect(http.StatusFound, "/register?error=Internal+error")
-		return
+	profile := models.RegistrationProfile{DisplayName: form["display_name"], Message: form["registration_message"]}
+	_, err = u.registration.Register(c.Request.Context(), email, password, form["invite_code"], profile)
+	if errors.Is(err, repository.ErrUserAlreadyExists) && u.registration.ConcealsExisting() {
+		// Answered like a new registration; the owner was notified
+		err = nil
 	}
-
-	_, err = u.userRepo.Create(c.Request.Context(), email, string(hashedPassword))
 	if err != nil {
 		if errors.Is(err, repository.ErrUserAlreadyExists) {
 			c.Redirect(http.StatusFound, "/register?error=Email+already+registered")
 			return
 		}
+		if errors.Is(err, service.ErrDisplayNameRequired) {
+			c.Redirect(http.StatusFound, "/register?error=Please+tell+us+your+name")
+			return
+		}
+		if errors.Is(err, service.ErrRegistrationClosed) {
+			u.renderRegister(c, http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, service.ErrEmailDomainNotAllowed) {
+			c.Redirect(http.StatusFound, "/register?error=Registration+is+not+open+to+this+email+domain")
+			return
+		}
+		if errors.Is(err, service.ErrInviteRequired) {
+			c.Redirect(http.StatusFound, "/register?error=Invite+code+required")
+			return
+		}
+		if errors.Is(err, service.ErrInviteInvalid) {
+			c.Redirect(http.StatusFound, "/register?error=Invite+code+invalid,+used+or+expired")
+			return
+		}
+		if errors.Is(err, passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/register?error="+busyMessage)
+			return
+		}
 		log.Error().Err(err).Msg("Failed to create user via web registration")
 		c.Redirect(http.StatusFound, "/register?error=Registration+failed")
 		return
 	}
 
 	// Redirect to login with success message
-	c.Redirect(http.StatusFound, "/account/login?success=Registration+successful.+Please+wait+for+admin+approval.")
+	c.Redirect(http.StatusFound, "/account/login?success=Registration+successful.+Please+confirm+your+email+address,+then+wait+for+admin+approval.")
+}
+
+// resendVerification sends a new verification link from the login page
+func (u *UserWeb) resendVerification(c *gin.Context) {
+	form, err := formValues(c, map[string]string{"email": input.KindEmail})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/login?error="+formError(err))
+		return
+	}
+	email := form["email"]
+	if email == "" {
+		c.Redirect(http.StatusFound, "/account/login?error=Email+required")
+		return
+	}
+
+	if err := u.verification.Resend(c.Request.Context(), email); err != nil {
+		log.Error().Err(err).Msg("Failed to resend verification email")
+		c.Redirect(http.StatusFound, "/account/login?error=Internal+error")
+		return
+	}
+	c.Redirect(http.StatusFound, "/account/login?success=If+the+address+still+needs+confirming,+a+new+link+has+been+sent.")
+}
+
+// userLoginPageData is the view model of user_login.html
+type userLoginPageData struct {
+	Error   string
+	Success string
 }
 
 // loginPage shows the login form
 func (u *UserWeb) loginPage(c *gin.Context) {
-	// If already logged in, redirect to settings
-	if sessionID, err := c.Cookie(userSessionCookieName); err == nil {
-		if session := u.sessions.Get(sessionID); session != nil && session.IsFullyAuthenticated() {
-			c.Redirect(http.StatusFound, "/account/settings")
-			return
-		}
+	// If already logged in, redirect to settings. A cookie that no longer
+	// resolves is cleared here so it cannot bounce the browser back.
+	if session := userSessionCookie.resolveOrClear(c, u.sessions); session != nil && session.IsFullyAuthenticated() {
+		c.Redirect(http.StatusFound, "/account/settings")
+		return
 	}
 
-	data := gin.H{
-		"Title":   "Login",
-		"Error":   c.Query("error"),
-		"Success": c.Query("success"),
+	data := userLoginPageData{
+		Error:   c.Query("error"),
+		Success: c.Query("success"),
 	}
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	if err := u.templates.Render(c.Writer, "user_login.html", data); err != nil {
@@ -185,8 +419,12 @@ func (u *UserWeb) loginPage(c *gin.Context) {
 
 // login handles the login form submission
 func (u *UserWeb) login(c *gin.Context) {
-	email := c.PostForm("email")
-	password := c.PostForm("password")
+	form, err := formValues(c, map[string]string{"email": input.KindEmail, "password": input.KindSecret})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/login?error="+formError(err))
+		return
+	}
+	email, password := form["email"], form["password"]
 
 	if email == "" || password == "" {
 		c.Redirect(http.StatusFound, "/account/login?error=Email+and+password+required")
@@ -195,53 +433,99 @@ func (u *UserWeb) login(c *gin.Context) {
 
 	user, err := u.userRepo.GetByEmail(c.Request.Context(), email)
 	if err != nil {
+		// Hash as for a wrong password so the response time does not
+		// reveal whether the email is registered
+		if errors.Is(passwords.CompareDummy(c.Request.Context(), password), passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/account/login?error="+busyMessage)
+			return
+		}
+		recordLoginFailed(c, u.events, events.SurfaceWeb, nil, email, events.ReasonInvalidCredentials)
 		c.Redirect(http.StatusFound, "/account/login?error=Invalid+credentials")
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+	rehashed, err := passwords.Verify(c.Request.Context(), user.PasswordHash, password)
+	if err != nil {
+		if errors.Is(err, passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/account/login?error="+busyMessage)
+			return
+		}
+		recordLoginFailed(c, u.events, events.SurfaceWeb, user, email, events.ReasonInvalidCredentials)
 		c.Redirect(http.StatusFound, "/account/login?error=Invalid+credentials")
 		return
 	}
+	upgradePasswordHash(c.Request.Context(), u.userRepo, user, rehashed)
 
 	if user.IsBlocked {
+		recordLoginFailed(c, u.events, events.SurfaceWeb, user, email, events.ReasonAccountBlocked)
 		c.Redirect(http.StatusFound, "/account/login?error=Account+has+been+blocked")
 		return
 	}
 
+	if !user.EmailVerified {
+		recordLoginFailed(c, u.events, events.SurfaceWeb, user, email, events.ReasonEmailNotVerified)
+		c.Redirect(http.StatusFound, "/account/login?error=Please+confirm+your+email+address+first")
+		return
+	}
+
 	if !user.IsApproved {
+		recordLoginFailed(c, u.events, events.SurfaceWeb, user, email, events.ReasonPendingApproval)
 		c.Redirect(http.StatusFound, "/account/login?error=Account+pending+admin+approval")
 		return
 	}
 
-	session, err := u.sessions.Create(user.ID, user.Email, user.IsAdmin, user.TOTPEnabled)
+	var methods []string
+	if user.TOTPEnabled {
+		methods = append(methods, models.SecondFactorTOTP)
+	}
+	passkeys, err := u.passkeys.Enabled(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check passkeys")
+		c.Redirect(http.StatusFound, "/account/login?error=Internal+error")
+		return
+	}
+	if passkeys {
+		methods = append(methods, models.SecondFactorWebAuthn)
+	}
+	if len(methods) > 0 && u.browserTrusted(c, user.ID) {
+		methods = nil
+	}
+	secondFactor := len(methods) > 0
+
+	session, err := u.sessions.Create(user.ID, user.Email, user.IsAdmin, secondFactor)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create user session")
 		c.Redirect(http.StatusFound, "/account/login?error=Internal+error")
 		return
 	}
+	session.SecondFactors = methods
 
-	c.SetCookie(userSessionCookieName, session.ID, int(userSessionDuration.Seconds()), "/account", "", true, true)
+	userSessionCookie.set(c, session.ID)
 
 	// Update last login
 	_ = u.userRepo.UpdateLastLogin(c.Request.Context(), user.ID)
 
-	if user.TOTPEnabled {
+	if secondFactor {
 		c.Redirect(http.StatusFound, "/account/login/totp")
 	} else {
+		recordLoginSucceeded(c, u.events, events.SurfaceWeb, user)
 		c.Redirect(http.StatusFound, "/account/settings")
 	}
 }
 
+// userTOTPPageData is the view model of user_totp.html
+type userTOTPPageData struct {
+	Email       string
+	TOTPEnabled bool // the code form is offered
+	Passkeys    bool // the passkey button is offered
+	RememberFor int  // days a trusted browser skips this page, 0 if disabled
+	Error       string
+	CSRFToken   string
+}
+
 // totpPage shows the TOTP verification form
 func (u *UserWeb) totpPage(c *gin.Context) {
-	sessionID, err := c.Cookie(userSessionCookieName)
-	if err != nil || sessionID == "" {
-		c.Redirect(http.StatusFound, "/account/login")
-		return
-	}
-
-	session := u.sessions.Get(sessionID)
+	session := userSessionCookie.resolveOrClear(c, u.sessions)
 	if session == nil {
 		c.Redirect(http.StatusFound, "/account/login")
 		return
@@ -252,10 +536,13 @@ func (u *UserWeb) totpPage(c *gin.Context) {
 		return
 	}
 
-	data := gin.H{
-		"Title": "Two-Factor Authentication",
-		"Email": session.Email,
-		"Error": c.Query("error"),
+	data := userTOTPPageData{
+		Email:       session.Email,
+		TOTPEnabled: session.allowsSecondFactor(models.SecondFactorTOTP),
+		Passkeys:    session.allowsSecondFactor(models.SecondFactorWebAuthn),
+		RememberFor: u.rememberDays(),
+		Error:       c.Query("error"),
+		CSRFToken:   session.CSRFToken,
 	}
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	if err := u.templates.Render(c.Writer, "user_totp.html", data); err != nil {
@@ -266,13 +553,7 @@ func (u *UserWeb) totpPage(c *gin.Context) {
 
 // validateTOTP handles TOTP verification during login
 func (u *UserWeb) validateTOTP(c *gin.Context) {
-	sessionID, err := c.Cookie(userSessionCookieName)
-	if err != nil || sessionID == "" {
-		c.Redirect(http.StatusFound, "/account/login")
-		return
-	}
-
-	session := u.sessions.Get(sessionID)
+	session := userSessionCookie.resolveOrClear(c, u.sessions)
 	if session == nil || !session.TOTPPending {
 		c.Redirect(http.StatusFound, "/account/login")
 		return
@@ -290,15 +571,54 @@ func (u *UserWeb) validateTOTP(c *gin.Context) {
 		return
 	}
 
-	if !totp.Validate(code, base32.StdEncoding.EncodeToString(user.TOTPSecret)) {
+	if !user.TOTPEnabled {
+		recordLoginFailed(c, u.events, events.SurfaceWeb, user, user.Email, events.ReasonInvalidTOTP)
 		c.Redirect(http.StatusFound, "/account/login/totp?error=Invalid+code")
 		return
 	}
+	if err := u.totpGuard.Check(c.Request.Context(), user, code, events.SurfaceWeb); err != nil {
+		if !errors.Is(err, service.ErrTOTPThrottled) {
+			recordLoginFailed(c, u.events, events.SurfaceWeb, user, user.Email, events.ReasonInvalidTOTP)
+		}
+		c.Redirect(http.StatusFound, "/account/login/totp?error="+totpFormError(err))
+		return
+	}
 
-	u.sessions.UpgradeFromTOTP(sessionID)
+	u.sessions.UpgradeFromTOTP(session.ID)
+	if c.PostForm("remember") == "on" {
+		u.trustBrowser(c, user.ID)
+	}
+	recordLoginSucceeded(c, u.events, events.SurfaceWeb, user)
 	c.Redirect(http.StatusFound, "/account/settings")
 }
 
+// totpFormError is the query-encoded message for a rejected TOTP code
+func totpFormError(err error) string {
+	switch {
+	case errors.Is(err, service.ErrTOTPThrottled):
+		return "Too+many+attempts.+Try+again+in+a+few+minutes"
+	case errors.Is(err, service.ErrTOTPReused):
+		return "Code+already+used.+Wait+for+the+next+code"
+	default:
+		return "Invalid+code"
+	}
+}
+
+// userSettingsPageData is the view model of user_settings.html
+type userSettingsPageData struct {
+	userLayout
+	Title       string
+	Email       string
+	CreatedAt   time.Time
+	TOTPEnabled bool
+	// Unused recovery codes; LowRecoveryCodes warns when few are left
+	RecoveryCodes    int
+	LowRecoveryCodes bool
+	Passkeys         []models.WebAuthnCredential
+	Success          string
+	Error            string
+}
+
 // settingsPage shows the user settings page
 func (u *UserWeb) settingsPage(c *gin.Context) {
 	session := c.MustGet("session").(*Session)
@@ -310,28 +630,45 @@ func (u *UserWeb) settingsPage(c *gin.Context) {
 		return
 	}
 
-	data := gin.H{
-		"Title":       "Account Settings",
-		"Email":       user.Email,
-		"CreatedAt":   user.CreatedAt,
-		"TOTPEnabled": user.TOTPEnabled,
-		"Success":     c.Query("success"),
-		"Error":       c.Query("error"),
-	}
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	if err := u.templates.Render(c.Writer, "user_settings.html", data); err != nil {
-		log.Error().Err(err).Msg("Failed to render user settings template")
+	passkeys, err := u.passkeys.List(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list passkeys for settings page")
 		c.String(http.StatusInternalServerError, "Internal server error")
+		return
 	}
+
+	data := userSettingsPageData{
+		Title:       "Account Settings",
+		Email:       user.Email,
+		CreatedAt:   user.CreatedAt,
+		TOTPEnabled: user.TOTPEnabled,
+		Passkeys:    passkeys,
+		Success:     c.Query("success"),
+		Error:       c.Query("error"),
+	}
+	if user.TOTPEnabled {
+		remaining, err := u.recoveryRepo.CountUnused(c.Request.Context(), user.ID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to count recovery codes for settings page")
+			c.String(http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		data.RecoveryCodes = remaining
+		data.LowRecoveryCodes = remaining < lowRecoveryCodes
+	}
+	u.render(c, "user_settings.html", &data)
 }
 
 // changePassword handles password change
 func (u *UserWeb) changePassword(c *gin.Context) {
 	session := c.MustGet("session").(*Session)
 
-	currentPassword := c.PostForm("current_password")
-	newPassword := c.PostForm("new_password")
-	confirmPassword := c.PostForm("confirm_password")
+	form, err := formValues(c, map[string]string{"current_password": input.KindSecret, "new_password": input.KindSecret, "confirm_password": input.KindSecret})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/settings?error="+formError(err))
+		return
+	}
+	currentPassword, newPassword, confirmPassword := form["current_password"], form["new_password"], form["confirm_password"]
 
 	if currentPassword == "" || newPassword == "" || confirmPassword == "" {
 		c.Redirect(http.StatusFound, "/account/settings?error=All+fields+are+required")
@@ -354,26 +691,41 @@ func (u *UserWeb) changePassword(c *gin.Context) {
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
+	if err := passwords.Compare(c.Request.Context(), user.PasswordHash, currentPassword); err != nil {
+		if errors.Is(err, passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/account/settings?error="+busyMessage)
+			return
+		}
 		c.Redirect(http.StatusFound, "/account/settings?error=Current+password+is+incorrect")
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(c.Request.Context(), newPassword)
 	if err != nil {
 		c.Redirect(http.StatusFound, "/account/settings?error=Internal+error")
 		return
 	}
 
-	if err := u.userRepo.UpdatePassword(c.Request.Context(), session.UserID, string(hashedPassword)); err != nil {
+	if err := u.userRepo.UpdatePassword(c.Request.Context(), session.UserID, hashedPassword); err != nil {
 		log.Error().Err(err).Msg("Failed to update user password")
 		c.Redirect(http.StatusFound, "/account/settings?error=Failed+to+update+password")
 		return
 	}
+	u.forgetTrustedBrowsers(c, session.UserID)
+	u.events.Record(c.Request.Context(), events.Entry{SubjectID: &session.UserID, Payload: events.PasswordChanged{Surface: events.SurfaceWeb}})
 
 	c.Redirect(http.StatusFound, "/account/settings?success=Password+updated+successfully")
 }
 
+// totpSettingsPageData is the view model of user_totp_settings.html
+type totpSettingsPageData struct {
+	userLayout
+	Title   string
+	Email   string
+	Success string
+	Error   string
+}
+
 // totpSettingsPage shows TOTP management page
 func (u *UserWeb) totpSettingsPage(c *gin.Context) {
 	session := c.MustGet("session").(*Session)
@@ -389,17 +741,13 @@ func (u *UserWeb) totpSettingsPage(c *gin.Context) {
 		return
 	}
 
-	data := gin.H{
-		"Title":   "Two-Factor Authentication",
-		"Email":   user.Email,
-		"Success": c.Query("success"),
-		"Error":   c.Query("error"),
-	}
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	if err := u.templates.Render(c.Writer, "user_totp_settings.html", data); err != nil {
-		log.Error().Err(err).Msg("Failed to render TOTP settings template")
-		c.String(http.StatusInternalServerError, "Internal server error")
+	data := totpSettingsPageData{
+		Title:   "Two-Factor Authentication",
+		Email:   user.Email,
+		Success: c.Query("success"),
+		Error:   c.Query("error"),
 	}
+	u.render(c, "user_totp_settings.html", &data)
 }
 
 // disableTOTP handles TOTP disable request
@@ -420,13 +768,17 @@ func (u *UserWeb) disableTOTP(c *gin.Context) {
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+	if err := passwords.Compare(c.Request.Context(), user.PasswordHash, password); err != nil {
+		if errors.Is(err, passwords.ErrBusy) {
+			c.Redirect(http.StatusFound, "/account/settings/totp?error="+busyMessage)
+			return
+		}
 		c.Redirect(http.StatusFound, "/account/settings/totp?error=Invalid+password")
 		return
 	}
 
-	if !totp.Validate(code, base32.StdEncoding.EncodeToString(user.TOTPSecret)) {
-		c.Redirect(http.StatusFound, "/account/settings/totp?error=Invalid+TOTP+code")
+	if err := u.totpGuard.Check(c.Request.Context(), user, code, events.SurfaceWeb); err != nil {
+		c.Redirect(http.StatusFound, "/account/settings/totp?error="+totpFormError(err))
 		return
 	}
 
@@ -435,12 +787,38 @@ func (u *UserWeb) disableTOTP(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/account/settings/totp?error=Failed+to+disable+2FA")
 		return
 	}
+	_ = u.recoveryRepo.DeleteAllForUser(c.Request.Context(), session.UserID)
+	u.forgetTrustedBrowsers(c, session.UserID)
+	u.events.Record(c.Request.Context(), events.Entry{SubjectID: &session.UserID, Payload: events.TOTPDisabled{Surface: events.SurfaceWeb}})
 
 	log.Info().Str("email", session.Email).Msg("User disabled 2FA via web interface")
 	c.Redirect(http.StatusFound, "/account/settings?success=Two-factor+authentication+disabled")
 }
 
-// devicesPage shows the user's devices
+// devicesPageData is the view model of user_devices.html
+type devicesPageData struct {
+	userLayout
+	Title    string
+	Email    string
+	Devices  []models.Device
+	Pending  []models.Device // awaiting approval, not in Devices
+	Sessions []models.Session
+	Pruning  *devicePruningData
+	Success  string
+	Error    string
+
+	RequireApproval bool
+}
+
+// devicePruningData shows the cleanup of inactive devices on
+// user_devices.html
+type devicePruningData struct {
+	Days        int
+	AllAccounts bool
+	OptedIn     bool
+}
+
+// devicesPage shows the user's devices and app sessions
 func (u *UserWeb) devicesPage(c *gin.Context) {
 	session := c.MustGet("session").(*Session)
 
@@ -451,18 +829,120 @@ func (u *UserWeb) devicesPage(c *gin.Context) {
 		return
 	}
 
-	data := gin.H{
-		"Title":   "Devices",
-		"Email":   session.Email,
-		"Devices": devices,
-		"Success": c.Query("success"),
-		"Error":   c.Query("error"),
+	sessions, err := u.apiSessions.GetActiveByUserID(c.Request.Context(), session.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list user sessions")
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
 	}
-	c.Header("Content-Type", "text/html; charset=utf-8")
-	if err := u.templates.Render(c.Writer, "user_devices.html", data); err != nil {
-		log.Error().Err(err).Msg("Failed to render devices template")
+
+	requireApproval, err := u.approval.DeviceApproval(c.Request.Context(), session.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load device approval setting")
 		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	data := devicesPageData{
+		Title:           "Devices",
+		Email:           session.Email,
+		Sessions:        sessions,
+		Success:         c.Query("success"),
+		Error:           c.Query("error"),
+		RequireApproval: requireApproval,
+	}
+	for _, device := range devices {
+		if device.IsApproved {
+			data.Devices = append(data.Devices, device)
+		} else {
+			data.Pending = append(data.Pending, device)
+		}
+	}
+	if after, allAccounts := u.pruning.Policy(); after > 0 {
+		optedIn, err := u.pruning.OptedIn(c.Request.Context(), session.UserID)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load device pruning opt-in")
+			c.String(http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		data.Pruning = &devicePruningData{Days: int(after / (24 * time.Hour)), AllAccounts: allAccounts, OptedIn: optedIn}
+	}
+	u.render(c, "user_devices.html", &data)
+}
+
+// renameDevice renames a device. Names are trimmed and unique per user.
+func (u *UserWeb) renameDevice(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/devices?error=Invalid+device+ID")
+		return
+	}
+	form, err := formValues(c, map[string]string{"name": input.KindDeviceName})
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/devices?error="+formError(err))
+		return
 	}
+	name := strings.TrimSpace(form["name"])
+	if name == "" {
+		c.Redirect(http.StatusFound, "/account/devices?error=Device+name+required")
+		return
+	}
+
+	device, err := u.deviceRepo.GetByID(c.Request.Context(), deviceID)
+	if err != nil || device.UserID != session.UserID {
+		c.Redirect(http.StatusFound, "/account/devices?error=Device+not+found")
+		return
+	}
+
+	err = u.deviceRepo.Update(c.Request.Context(), deviceID, &name, nil)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrDeviceNameTaken):
+		c.Redirect(http.StatusFound, "/account/devices?error="+url.QueryEscape("Another of your devices is already named "+strconv.Quote(name)))
+		return
+	case errors.Is(err, repository.ErrDeviceNotFound):
+		c.Redirect(http.StatusFound, "/account/devices?error=Device+not+found")
+		return
+	default:
+		log.Error().Err(err).Msg("Failed to rename device")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+rename+device")
+		return
+	}
+
+	log.Info().Str("device_id", deviceID.String()).Str("email", session.Email).Msg("Device renamed via web interface")
+	c.Redirect(http.StatusFound, "/account/devices?success=Device+renamed")
+}
+
+// setDeviceReadOnly allows or forbids a device to push vaults
+func (u *UserWeb) setDeviceReadOnly(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/devices?error=Invalid+device+ID")
+		return
+	}
+	device, err := u.deviceRepo.GetByID(c.Request.Context(), deviceID)
+	if err != nil || device.UserID != session.UserID {
+		c.Redirect(http.StatusFound, "/account/devices?error=Device+not+found")
+		return
+	}
+
+	readOnly := c.PostForm("read_only") == "true"
+	if err := u.deviceRepo.Update(c.Request.Context(), deviceID, nil, &readOnly); err != nil {
+		log.Error().Err(err).Msg("Failed to update device")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+update+device")
+		return
+	}
+
+	log.Info().Str("device_id", deviceID.String()).Bool("read_only", readOnly).Str("email", session.Email).Msg("Device access changed via web interface")
+	if readOnly {
+		c.Redirect(http.StatusFound, "/account/devices?success=Device+is+now+read-only")
+		return
+	}
+	c.Redirect(http.StatusFound, "/account/devices?success=Device+can+push+again")
 }
 
 // deleteDevice removes a device
@@ -498,11 +978,162 @@ func (u *UserWeb) deleteDevice(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/account/devices?success=Device+removed")
 }
 
+// setDevicePruning opts the user in to or out of the removal of inactive
+// devices
+func (u *UserWeb) setDevicePruning(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	on := c.PostForm("enabled") == "on"
+	if err := u.pruning.SetOptIn(c.Request.Context(), session.UserID, on); err != nil {
+		log.Error().Err(err).Msg("Failed to set device pruning opt-in")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+save+setting")
+		return
+	}
+
+	log.Info().Bool("enabled", on).Str("email", session.Email).Msg("Device pruning opt-in changed via web interface")
+	c.Redirect(http.StatusFound, "/account/devices?success=Setting+saved")
+}
+
+// setDeviceApproval sets whether new devices of the user need approval
+func (u *UserWeb) setDeviceApproval(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	on := c.PostForm("enabled") == "on"
+	if err := u.approval.SetDeviceApproval(c.Request.Context(), session.UserID, on); err != nil {
+		log.Error().Err(err).Msg("Failed to set device approval")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+save+setting")
+		return
+	}
+
+	log.Info().Bool("enabled", on).Str("email", session.Email).Msg("Device approval setting changed via web interface")
+	c.Redirect(http.StatusFound, "/account/devices?success=Setting+saved")
+}
+
+// approveDevice lets a device awaiting approval use the vault
+func (u *UserWeb) approveDevice(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	device, ok := u.ownDevice(c, session)
+	if !ok {
+		return
+	}
+	if err := u.deviceRepo.Approve(c.Request.Context(), device.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to approve device")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+approve+device")
+		return
+	}
+
+	log.Info().Str("device_id", device.ID.String()).Str("email", session.Email).Msg("Device approved via web interface")
+	c.Redirect(http.StatusFound, "/account/devices?success=Device+approved")
+}
+
+// denyDevice ends the sessions of a device awaiting approval and removes
+// it
+func (u *UserWeb) denyDevice(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	device, ok := u.ownDevice(c, session)
+	if !ok {
+		return
+	}
+	if err := u.apiSessions.RevokeAllForDevice(c.Request.Context(), device.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to revoke device sessions")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+deny+device")
+		return
+	}
+	if err := u.deviceRepo.Delete(c.Request.Context(), device.ID); err != nil {
+		log.Error().Err(err).Msg("Failed to delete device")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+deny+device")
+		return
+	}
+
+	log.Info().Str("device_id", device.ID.String()).Str("email", session.Email).Msg("Device denied via web interface")
+	c.Redirect(http.StatusFound, "/account/devices?success=Device+denied+and+signed+out")
+}
+
+// ownDevice loads the device named by the id parameter if it belongs to
+// the session's user. Otherwise it redirects and returns false.
+func (u *UserWeb) ownDevice(c *gin.Context, session *Session) (*models.Device, bool) {
+	deviceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/devices?error=Invalid+device+ID")
+		return nil, false
+	}
+	device, err := u.deviceRepo.GetByID(c.Request.Context(), deviceID)
+	if err != nil || device.UserID != session.UserID {
+		c.Redirect(http.StatusFound, "/account/devices?error=Device+not+found")
+		return nil, false
+	}
+	return device, true
+}
+
+// revokeSession ends one app session. The device stays registered.
+func (u *UserWeb) revokeSession(c *gin.Context) {
+	session := c.MustGet("session").(*Session)
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.Redirect(http.StatusFound, "/account/devices?error=Invalid+session+ID")
+		return
+	}
+
+	err = u.apiSessions.RevokeByID(c.Request.Context(), session.UserID, sessionID)
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		c.Redirect(http.StatusFound, "/account/devices?error=Session+not+found")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke session")
+		c.Redirect(http.StatusFound, "/account/devices?error=Failed+to+end+session")
+		return
+	}
+
+	log.Info().Str("session_id", sessionID.String()).Str("email", session.Email).Msg("Session revoked via web interface")
+	c.Redirect(http.StatusFound, "/account/devices?success=Session+ended")
+}
+
 // logout destroys the session
 func (u *UserWeb) logout(c *gin.Context) {
-	if sessionID, err := c.Cookie(userSessionCookieName); err == nil {
-		u.sessions.Delete(sessionID)
-	}
-	c.SetCookie(userSessionCookieName, "", -1, "/account", "", true, true)
+	userSessionCookie.destroy(c, u.sessions)
 	c.Redirect(http.StatusFound, "/account/login")
 }
+
+// recordLoginFailed records a rejected web login. user is nil if the
+// email matched no account.
+func recordLoginFailed(c *gin.Context, eventLog *events.Log, surface string, user *models.User, email, reason string) {
+	entry := events.Entry{
+		IP:      c.ClientIP(),
+		Payload: events.LoginFailed{Email: email, Surface: surface, Reason: reason},
+	}
+	if user != nil {
+		entry.SubjectID = &user.ID
+	}
+	eventLog.Record(c.Request.Context(), entry)
+}
+
+// recordLoginSucceeded records a fully authenticated web login
+func recordLoginSucceeded(c *gin.Context, eventLog *events.Log, surface string, user *models.User) {
+	eventLog.Record(c.Request.Context(), events.Entry{
+		SubjectID: &user.ID,
+		IP:        c.ClientIP(),
+		Payload:   events.LoginSucceeded{Email: user.Email, Surface: surface},
+	})
+}
+
+// passwordRehasher stores upgraded password hashes
+type passwordRehasher interface {
+	RehashPassword(ctx context.Context, id uuid.UUID, oldHash, newHash string) error
+}
+
+// upgradePasswordHash stores the hash password.Verify produced for an
+// outdated one. Failures are logged; the next login tries again.
+func upgradePasswordHash(ctx context.Context, users passwordRehasher, user *models.User, rehashed string) {
+	if rehashed == "" {
+		return
+	}
+	if err := users.RehashPassword(ctx, user.ID, user.PasswordHash, rehashed); err != nil {
+		log.Warn().Err(err).Str("user_id", user.ID.String()).Msg("Failed to upgrade password hash")
+		return
+	}
+	user.PasswordHash = rehashed
+}
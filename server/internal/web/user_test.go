@@ -0,0 +1,348 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/sprobst76/vibedterm-server/internal/models"
+	"github.com/sprobst76/vibedterm-server/internal/repository"
+	"github.com/sprobst76/vibedterm-server/internal/service"
+	"github.com/sprobst76/vibedterm-server/internal/totpsecret"
+)
+
+func TestUserDevicesTemplateRendersSessions(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	id := uuid.New()
+	now := time.Now()
+	sessions := []models.Session{{ID: id, DeviceName: "Pixel", DeviceType: "android", CreatedAt: now.Add(-3 * time.Hour), LastUsedAt: now.Add(-time.Hour), ExpiresAt: now.Add(24 * time.Hour)}}
+
+	var buf bytes.Buffer
+	if err := tmpl.Render(&buf, "user_devices.html", devicesPageData{Sessions: sessions}); err != nil {
+		t.Fatalf("render devices: %v", err)
+	}
+	html := buf.String()
+	if !strings.Contains(html, "/account/sessions/"+id.String()+"/revoke") || !strings.Contains(html, "1 hour ago") {
+		t.Errorf("devices page does not list the session:\n%s", html)
+	}
+}
+
+// memDevicePruning is an in-memory devicePruning
+type memDevicePruning map[uuid.UUID]bool
+
+func (m memDevicePruning) Policy() (time.Duration, bool) { return 90 * 24 * time.Hour, false }
+
+func (m memDevicePruning) OptedIn(_ context.Context, userID uuid.UUID) (bool, error) {
+	return m[userID], nil
+}
+
+func (m memDevicePruning) SetOptIn(_ context.Context, userID uuid.UUID, on bool) error {
+	m[userID] = on
+	return nil
+}
+
+func TestSetDevicePruning(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := uuid.New()
+	pruning := memDevicePruning{}
+	u := &UserWeb{pruning: pruning}
+
+	for _, form := range []url.Values{{"enabled": {"on"}}, {}} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/account/devices/pruning", strings.NewReader(form.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c.Set("session", &Session{UserID: userID})
+		u.setDevicePruning(c)
+		if want := form.Get("enabled") == "on"; pruning[userID] != want {
+			t.Errorf("form %v: opted in = %v, want %v", form, pruning[userID], want)
+		}
+	}
+
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Render(&buf, "user_devices.html", devicesPageData{Pruning: &devicePruningData{Days: 90, OptedIn: true}}); err != nil {
+		t.Fatalf("render devices: %v", err)
+	}
+	if html := buf.String(); !strings.Contains(html, `name="enabled" checked`) || !strings.Contains(html, "90 days") {
+		t.Errorf("devices page does not show the opt-in:\n%s", html)
+	}
+}
+
+// memWebDevices is an in-memory webDeviceStore with unique names per user
+type memWebDevices map[uuid.UUID]*models.Device
+
+func (m memWebDevices) GetByUserID(_ context.Context, userID uuid.UUID) ([]models.Device, error) {
+	var devices []models.Device
+	for _, d := range m {
+		if d.UserID == userID {
+			devices = append(devices, *d)
+		}
+	}
+	return devices, nil
+}
+
+func (m memWebDevices) GetByID(_ context.Context, id uuid.UUID) (*models.Device, error) {
+	if d, ok := m[id]; ok {
+		return d, nil
+	}
+	return nil, repository.ErrDeviceNotFound
+}
+
+func (m memWebDevices) Update(_ context.Context, id uuid.UUID, name *string, readOnly *bool) error {
+	if name != nil {
+		for otherID, d := range m {
+			if otherID != id && d.UserID == m[id].UserID && d.DeviceName == *name {
+				return repository.ErrDeviceNameTaken
+			}
+		}
+		m[id].DeviceName = *name
+	}
+	if readOnly != nil {
+		m[id].ReadOnly = *readOnly
+	}
+	return nil
+}
+
+func (m memWebDevices) Approve(_ context.Context, id uuid.UUID) error {
+	m[id].IsApproved = true
+	return nil
+}
+
+func (m memWebDevices) Delete(_ context.Context, id uuid.UUID) error {
+	delete(m, id)
+	return nil
+}
+
+// revokedDevices is an apiSessionStore recording revoked devices
+type revokedDevices map[uuid.UUID]bool
+
+func (r revokedDevices) GetActiveByUserID(context.Context, uuid.UUID) ([]models.Session, error) {
+	return nil, nil
+}
+
+func (r revokedDevices) RevokeByID(context.Context, uuid.UUID, uuid.UUID) error { return nil }
+
+func (r revokedDevices) RevokeAllForDevice(_ context.Context, deviceID uuid.UUID) error {
+	r[deviceID] = true
+	return nil
+}
+
+func TestApproveAndDenyDevice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID, phone, tablet, foreign := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	devices := memWebDevices{
+		phone:   {ID: phone, UserID: userID},
+		tablet:  {ID: tablet, UserID: userID},
+		foreign: {ID: foreign, UserID: uuid.New()},
+	}
+	revoked := revokedDevices{}
+	u := &UserWeb{deviceRepo: devices, apiSessions: revoked}
+
+	post := func(handler gin.HandlerFunc, id uuid.UUID) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/", nil)
+		c.Params = gin.Params{{Key: "id", Value: id.String()}}
+		c.Set("session", &Session{UserID: userID})
+		handler(c)
+		return w.Header().Get("Location")
+	}
+
+	if loc := post(u.approveDevice, foreign); !strings.Contains(loc, "not+found") || devices[foreign].IsApproved {
+		t.Errorf("approving a foreign device redirects to %q", loc)
+	}
+	if post(u.approveDevice, phone); !devices[phone].IsApproved {
+		t.Error("device not approved")
+	}
+	if post(u.denyDevice, tablet); devices[tablet] != nil || !revoked[tablet] {
+		t.Errorf("denied device kept: %v, sessions revoked: %v", devices[tablet], revoked[tablet])
+	}
+}
+
+func TestRenameDevice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID, laptop, phone := uuid.New(), uuid.New(), uuid.New()
+	devices := memWebDevices{
+		laptop: {ID: laptop, UserID: userID, DeviceName: "Laptop"},
+		phone:  {ID: phone, UserID: userID, DeviceName: "Phone"},
+	}
+	u := &UserWeb{deviceRepo: devices}
+
+	rename := func(name string) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/account/devices/"+phone.String()+"/rename", strings.NewReader(url.Values{"name": {name}}.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c.Params = gin.Params{{Key: "id", Value: phone.String()}}
+		c.Set("session", &Session{UserID: userID})
+		u.renameDevice(c)
+		return w.Header().Get("Location")
+	}
+
+	if loc := rename("Laptop"); !strings.Contains(loc, "already+named") || devices[phone].DeviceName != "Phone" {
+		t.Errorf("duplicate name redirects to %q, name %q", loc, devices[phone].DeviceName)
+	}
+	if loc := rename(strings.Repeat("x", 101)); !strings.Contains(loc, "error=") {
+		t.Errorf("long name redirects to %q", loc)
+	}
+	if loc := rename(" Tablet "); !strings.Contains(loc, "success=") || devices[phone].DeviceName != "Tablet" {
+		t.Errorf("rename redirects to %q, name %q", loc, devices[phone].DeviceName)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/account/devices/"+phone.String()+"/read-only", strings.NewReader("read_only=true"))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Params = gin.Params{{Key: "id", Value: phone.String()}}
+	c.Set("session", &Session{UserID: userID})
+	u.setDeviceReadOnly(c)
+	if !devices[phone].ReadOnly {
+		t.Errorf("device not read-only, redirected to %q", w.Header().Get("Location"))
+	}
+}
+
+func TestRegister_ClosedAndInviteForms(t *testing.T) {
+	tmpl, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("NewTemplates: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	form := url.Values{"email": {"a@example.com"}, "password": {"password123"}, "confirm_password": {"password123"}}
+
+	closed := &UserWeb{templates: tmpl, registration: service.NewRegistration(nil, nil, nil, nil, nil, nil, nil, service.RegistrationPolicy{Mode: service.RegistrationClosed})}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/register", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	closed.register(c)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "REGISTRATION_DISABLED") || strings.Contains(w.Body.String(), "<form") {
+		t.Errorf("closed registration = %d\n%s", w.Code, w.Body.String())
+	}
+
+	invite := &UserWeb{templates: tmpl, registration: service.NewRegistration(nil, nil, nil, nil, nil, nil, nil, service.RegistrationPolicy{Mode: service.RegistrationInvite})}
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/register", nil)
+	invite.registerPage(c)
+	if !strings.Contains(w.Body.String(), `name="invite_code"`) {
+		t.Error("invite mode form has no invite code field")
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/register", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	invite.register(c)
+	if loc := w.Header().Get("Location"); !strings.Contains(loc, "Invite+code+required") {
+		t.Errorf("registration without code redirects to %q", loc)
+	}
+}
+
+// memWebUsers is an in-memory webUserStore
+type memWebUsers map[uuid.UUID]*models.User
+
+func (m memWebUsers) GetByID(_ context.Context, id uuid.UUID) (*models.User, error) {
+	if u, ok := m[id]; ok {
+		return u, nil
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m memWebUsers) GetByEmail(_ context.Context, email string) (*models.User, error) {
+	for _, u := range m {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m memWebUsers) UpdateLastLogin(context.Context, uuid.UUID) error        { return nil }
+func (m memWebUsers) UpdatePassword(context.Context, uuid.UUID, string) error { return nil }
+func (m memWebUsers) RehashPassword(context.Context, uuid.UUID, string, string) error {
+	return nil
+}
+
+func (m memWebUsers) SetTOTPSecret(_ context.Context, id uuid.UUID, secret []byte) error {
+	m[id].TOTPSecret = secret
+	return nil
+}
+
+func (m memWebUsers) EnableTOTP(_ context.Context, id uuid.UUID) error {
+	m[id].TOTPEnabled = true
+	return nil
+}
+
+func (m memWebUsers) DisableTOTP(_ context.Context, id uuid.UUID) error {
+	m[id].TOTPEnabled = false
+	m[id].TOTPSecret = nil
+	return nil
+}
+
+func TestValidateTOTP_AcceptsSecretFromAPISetup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Stored the way the API's TOTP setup stores it
+	key, secret, err := totpsecret.Generate("VibedTerm", "web@example.com", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{ID: uuid.New(), Email: "web@example.com", TOTPEnabled: true, TOTPSecret: secret}
+	u := &UserWeb{
+		userRepo:  memWebUsers{user.ID: user},
+		sessions:  &SessionStore{sessions: make(map[string]*Session), duration: time.Hour},
+		totpGuard: service.NewTOTPGuard(&memAdminAudit{}, 5, 5*time.Minute),
+	}
+
+	submit := func(code string) (*Session, string) {
+		session, err := u.sessions.Create(user.ID, user.Email, false, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/account/login/totp", strings.NewReader(url.Values{"code": {code}}.Encode()))
+		c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		c.Request.AddCookie(&http.Cookie{Name: userSessionCookieName, Value: session.ID})
+		u.validateTOTP(c)
+		return u.sessions.Get(session.ID), w.Header().Get("Location")
+	}
+
+	code, err := totp.GenerateCode(key.Secret(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, location := submit(code)
+	if location != "/account/settings" || session.TOTPPending {
+		t.Errorf("valid code redirected to %q, pending = %v", location, session.TOTPPending)
+	}
+
+	session, location = submit(code)
+	if !strings.Contains(location, "error=Code+already+used") || !session.TOTPPending {
+		t.Errorf("reused code redirected to %q, pending = %v", location, session.TOTPPending)
+	}
+
+	wrong := "000000"
+	if wrong == code {
+		wrong = "111111"
+	}
+	session, location = submit(wrong)
+	if !strings.Contains(location, "error=Invalid+code") || !session.TOTPPending {
+		t.Errorf("wrong code redirected to %q, pending = %v", location, session.TOTPPending)
+	}
+}